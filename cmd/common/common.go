@@ -1,12 +1,10 @@
 package common
 
 import (
-	"context"
 	"fmt"
 	"math/rand"
 	"net/http"
 	"os"
-	"os/signal"
 	"time"
 
 	"github.com/labstack/echo"
@@ -71,22 +69,3 @@ func NewEcho() *echo.Echo {
 	})
 	return e
 }
-
-func StartEcho(e *echo.Echo) error {
-	// Start the server
-	go func() {
-		if err := e.Start(":8888"); err != nil {
-			e.Logger.Info("shutting down the server")
-		}
-	}()
-
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, os.Interrupt)
-
-	// Wait for interrupt signal to gracefully shutdown the application with a timeout of 10 seconds
-	<-quit
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	return e.Shutdown(ctx)
-}
@@ -0,0 +1,56 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/http"
+
+	"github.com/labstack/echo"
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/scheme"
+	"github.com/weaveworks/libgitops/pkg/rest"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/serializer"
+)
+
+// NegotiateContentType returns the content type to respond with, based on the Accept header
+// of the request. YAML is returned if it is accepted before JSON, otherwise JSON is the default.
+func NegotiateContentType(c echo.Context) serializer.ContentType {
+	return rest.NegotiateContentType(c.Request().Header.Get(echo.HeaderAccept))
+}
+
+// WriteObject encodes the given object in the negotiated content type, and writes it as the response
+func WriteObject(c echo.Context, status int, obj runtime.Object) error {
+	ct := NegotiateContentType(c)
+	var content bytes.Buffer
+	if err := scheme.Serializer.Encoder().Encode(serializer.NewFrameWriter(ct, &content), obj); err != nil {
+		return err
+	}
+	return c.Blob(status, string(ct), content.Bytes())
+}
+
+// ReadObject decodes the object in the request body, based on its Content-Type header. JSON is
+// assumed if the header is not set, and any content type other than JSON or YAML is rejected.
+func ReadObject(c echo.Context) (runtime.Object, error) {
+	ct := serializer.ContentTypeJSON
+	if header := c.Request().Header.Get(echo.HeaderContentType); len(header) != 0 {
+		mediaType, _, err := mime.ParseMediaType(header)
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		ct = serializer.ContentType(mediaType)
+	}
+	if ct != serializer.ContentTypeJSON && ct != serializer.ContentTypeYAML {
+		return nil, echo.NewHTTPError(http.StatusUnsupportedMediaType, fmt.Sprintf("unsupported content type %q", ct))
+	}
+
+	obj, err := scheme.Serializer.Decoder().Decode(serializer.NewFrameReader(ct, c.Request().Body))
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	metaObj, ok := obj.(runtime.Object)
+	if !ok {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "can't convert to libgitops.runtime.Object")
+	}
+	return metaObj, nil
+}
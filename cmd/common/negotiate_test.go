@@ -0,0 +1,79 @@
+package common
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+)
+
+func TestWriteObject(t *testing.T) {
+	tests := []struct {
+		accept      string
+		contentType string
+		body        string
+	}{
+		{"", "application/json", `"brand": "Volvo"`},
+		{"application/json", "application/json", `"brand": "Volvo"`},
+		{"application/yaml", "application/yaml", "brand: Volvo"},
+		{"text/html, application/yaml;q=0.9, application/json;q=0.8", "application/yaml", "brand: Volvo"},
+		{"application/json, application/yaml", "application/json", `"brand": "Volvo"`},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if len(tt.accept) != 0 {
+			req.Header.Set(echo.HeaderAccept, tt.accept)
+		}
+		rec := httptest.NewRecorder()
+		car := NewCar("foo")
+		car.Spec.Brand = "Volvo"
+
+		if err := WriteObject(echo.New().NewContext(req, rec), http.StatusOK, car); err != nil {
+			t.Fatal(err)
+		}
+		if ct := rec.Header().Get(echo.HeaderContentType); ct != tt.contentType {
+			t.Errorf("Accept %q: expected content type %q, got %q", tt.accept, tt.contentType, ct)
+		}
+		if !strings.Contains(rec.Body.String(), tt.body) {
+			t.Errorf("Accept %q: expected the body to contain %q, got:\n%s", tt.accept, tt.body, rec.Body.String())
+		}
+	}
+}
+
+func TestReadObject(t *testing.T) {
+	tests := []struct {
+		contentType string
+		body        string
+		status      int
+	}{
+		{"", `{"apiVersion":"sample-app.weave.works/v1alpha1","kind":"Car","metadata":{"name":"foo"},"spec":{"brand":"Volvo"}}`, 0},
+		{"application/json; charset=utf-8", `{"apiVersion":"sample-app.weave.works/v1alpha1","kind":"Car","metadata":{"name":"foo"},"spec":{"brand":"Volvo"}}`, 0},
+		{"application/yaml", "apiVersion: sample-app.weave.works/v1alpha1\nkind: Car\nmetadata:\n  name: foo\nspec:\n  brand: Volvo\n", 0},
+		{"application/yaml", `{"apiVersion":"sample-app.weave.works/v1alpha1","kind":"Car","metadata":{"name":"foo"},"spec":{"brand":"Volvo"}}`, 0},
+		{"application/json", "apiVersion: sample-app.weave.works/v1alpha1\nkind: Car\n", http.StatusBadRequest},
+		{"text/plain", "brand: Volvo", http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(tt.body))
+		if len(tt.contentType) != 0 {
+			req.Header.Set(echo.HeaderContentType, tt.contentType)
+		}
+
+		obj, err := ReadObject(echo.New().NewContext(req, httptest.NewRecorder()))
+		if tt.status != 0 {
+			if httpErr, ok := err.(*echo.HTTPError); !ok || httpErr.Code != tt.status {
+				t.Errorf("Content-Type %q: expected status %d, got %v", tt.contentType, tt.status, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Content-Type %q: %v", tt.contentType, err)
+		}
+		if car, ok := obj.(*v1alpha1.Car); !ok || car.Spec.Brand != "Volvo" {
+			t.Errorf("Content-Type %q: expected a Car with brand Volvo, got %#v", tt.contentType, obj)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/spf13/pflag"
+)
+
+var shutdownGracePeriodFlag = pflag.Duration("shutdown-grace-period", 10*time.Second, "How long to wait for the server and storages to shut down on SIGINT or SIGTERM")
+
+// contextCloser is implemented by closers that can bound their cleanup by a context,
+// e.g. the watch.GenericWatchStorage, which flushes its buffered events until ctx expires
+type contextCloser interface {
+	CloseContext(ctx context.Context) error
+}
+
+// SignalContext returns a context that is cancelled when the process receives SIGINT or SIGTERM,
+// or when the returned CancelFunc is called, after which the signals are no longer intercepted
+func SignalContext(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		defer signal.Stop(quit)
+		select {
+		case <-quit:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// StartEcho serves e on port 8888 until SIGINT or SIGTERM is received, and then shuts down
+// e and the given closers within the grace period of the --shutdown-grace-period flag, see RunEcho
+func StartEcho(e *echo.Echo, closers ...io.Closer) error {
+	ctx, cancel := SignalContext(context.Background())
+	defer cancel()
+
+	return RunEcho(ctx, e, ":8888", *shutdownGracePeriodFlag, closers...)
+}
+
+// RunEcho serves e on the given address until ctx is cancelled or serving fails. It then shuts down
+// e first, so that no new requests arrive, and afterwards closes the given closers in order, e.g. the
+// storages the handlers of e use. All of this must finish within gracePeriod, otherwise the remaining
+// cleanup is cut short. Closers implementing CloseContext, like the watch.GenericWatchStorage, are
+// given the remaining grace period to e.g. flush their buffered events. The first error is returned.
+func RunEcho(ctx context.Context, e *echo.Echo, address string, gracePeriod time.Duration, closers ...io.Closer) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- e.Start(address)
+	}()
+
+	var err error
+	select {
+	case <-ctx.Done():
+		e.Logger.Info("shutting down the server")
+	case err = <-serveErr:
+		// The server never started, or failed
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+	defer cancel()
+
+	if shutdownErr := e.Shutdown(shutdownCtx); err == nil {
+		err = shutdownErr
+	}
+	for _, c := range closers {
+		var closeErr error
+		if cc, ok := c.(contextCloser); ok {
+			closeErr = cc.CloseContext(shutdownCtx)
+		} else {
+			closeErr = c.Close()
+		}
+		if err == nil {
+			err = closeErr
+		}
+	}
+
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,129 @@
+package common
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"reflect"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// recorder records the order in which the server and closers shut down
+type recorder struct {
+	events []string
+	mux    sync.Mutex
+}
+
+func (r *recorder) record(event string) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.events = append(r.events, event)
+}
+
+type closer struct {
+	name string
+	r    *recorder
+}
+
+func (c *closer) Close() error {
+	c.r.record(c.name + " closed")
+	return nil
+}
+
+type contextCloserFunc func(ctx context.Context) error
+
+func (f contextCloserFunc) Close() error                           { return f(context.Background()) }
+func (f contextCloserFunc) CloseContext(ctx context.Context) error { return f(ctx) }
+
+func TestStartEchoShutdown(t *testing.T) {
+	r := &recorder{}
+	e := NewEcho()
+	e.HideBanner, e.HidePort = true, true
+	e.Server.RegisterOnShutdown(func() { r.record("server shut down") })
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Listener = ln
+
+	watchStorage := contextCloserFunc(func(ctx context.Context) error {
+		if _, ok := ctx.Deadline(); !ok {
+			t.Error("expected the grace period to bound CloseContext")
+		}
+		// The server must not accept requests anymore
+		if _, err := http.Get("http://" + ln.Addr().String()); err == nil {
+			t.Error("expected the server to be shut down before the storages are closed")
+		}
+		r.record("watch storage closed")
+		return nil
+	})
+
+	done := make(chan error)
+	go func() {
+		done <- StartEcho(e, watchStorage, &closer{"storage", r})
+	}()
+
+	// Wait until the server is up, and then simulate SIGTERM, e.g. from the kubelet
+	for i := 0; ; i++ {
+		resp, err := http.Get("http://" + ln.Addr().String())
+		if err == nil {
+			resp.Body.Close()
+			break
+		} else if i == 100 {
+			t.Fatalf("the server didn't start: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	p, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Signal(syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected a clean shutdown, got %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the shutdown")
+	}
+
+	expected := []string{"server shut down", "watch storage closed", "storage closed"}
+	if !reflect.DeepEqual(r.events, expected) {
+		t.Errorf("expected the shutdown order %v, got %v", expected, r.events)
+	}
+}
+
+func TestRunEchoGracePeriod(t *testing.T) {
+	e := NewEcho()
+	e.HideBanner, e.HidePort = true, true
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Listener = ln
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// A closer not finishing within the grace period is cut short
+	slowStorage := contextCloserFunc(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	start := time.Now()
+	if err := RunEcho(ctx, e, "", 50*time.Millisecond, slowStorage); err != context.DeadlineExceeded {
+		t.Errorf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the shutdown to be bounded by the grace period, took %v", elapsed)
+	}
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bytes"
 	"fmt"
 	"net/http"
 	"os"
@@ -45,7 +44,6 @@ func run() error {
 		scheme.Serializer,
 		[]runtime.IdentifierFactory{runtime.Metav1NameIdentifier},
 	)
-	defer func() { _ = plainStorage.Close() }()
 
 	e := common.NewEcho()
 
@@ -59,11 +57,7 @@ func run() error {
 		if err != nil {
 			return err
 		}
-		var content bytes.Buffer
-		if err := scheme.Serializer.Encoder().Encode(serializer.NewJSONFrameWriter(&content), obj); err != nil {
-			return err
-		}
-		return c.JSONBlob(http.StatusOK, content.Bytes())
+		return common.WriteObject(c, http.StatusOK, obj)
 	})
 
 	e.POST("/plain/:name", func(c echo.Context) error {
@@ -84,11 +78,28 @@ func run() error {
 			return echo.NewHTTPError(http.StatusBadRequest, "Please set name")
 		}
 
-		if err := common.SetNewCarStatus(plainStorage, common.CarKeyForName(name)); err != nil {
+		key := common.CarKeyForName(name)
+		// Without a body, just set a new status for the Car
+		if c.Request().ContentLength == 0 {
+			if err := common.SetNewCarStatus(plainStorage, key); err != nil {
+				return err
+			}
+			return c.String(200, "OK!")
+		}
+
+		obj, err := common.ReadObject(c)
+		if err != nil {
 			return err
 		}
-		return c.String(200, "OK!")
+		if id := obj.GetNamespace() + "/" + obj.GetName(); id != key.GetIdentifier() {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("expected Car %q, got %q", key.GetIdentifier(), id))
+		}
+		if err := plainStorage.Update(obj); err != nil {
+			return err
+		}
+		return common.WriteObject(c, http.StatusOK, obj)
 	})
 
-	return common.StartEcho(e)
+	// The storage is closed after the server has shut down
+	return common.StartEcho(e, plainStorage)
 }
@@ -134,7 +134,6 @@ func run(identityFile, gitURL, ghToken, authorName, authorEmail string) error {
 	if err != nil {
 		return err
 	}
-	defer func() { _ = watchStorage.Close() }()
 
 	updates := make(chan update.Update, 4096)
 	watchStorage.SetUpdateStream(updates)
@@ -188,5 +187,6 @@ func run(identityFile, gitURL, ghToken, authorName, authorEmail string) error {
 		return c.String(200, "OK!")
 	})
 
-	return common.StartEcho(e)
+	// The storage is closed after the server has shut down
+	return common.StartEcho(e, watchStorage)
 }
@@ -1,7 +1,7 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"fmt"
 	"net/http"
 	"os"
@@ -12,7 +12,9 @@ import (
 	"github.com/weaveworks/libgitops/cmd/common"
 	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/scheme"
 	"github.com/weaveworks/libgitops/pkg/logs"
-	"github.com/weaveworks/libgitops/pkg/serializer"
+	"github.com/weaveworks/libgitops/pkg/rest"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/storage"
 	"github.com/weaveworks/libgitops/pkg/storage/watch"
 	"github.com/weaveworks/libgitops/pkg/storage/watch/update"
 )
@@ -43,10 +45,12 @@ func run() error {
 	if err != nil {
 		return err
 	}
-	defer func() { _ = watchStorage.Close() }()
 
-	updates := make(chan update.Update, 4096)
-	watchStorage.SetUpdateStream(updates)
+	// Log all events using a subscription of its own, next to the ones of the /watch clients
+	updates := make(update.UpdateStream, 4096)
+	if err := watchStorage.(*watch.GenericWatchStorage).Watch(context.Background(), updates); err != nil {
+		return err
+	}
 
 	go func() {
 		for upd := range updates {
@@ -56,6 +60,8 @@ func run() error {
 
 	e := common.NewEcho()
 
+	e.GET("/watch", streamCars(watchStorage))
+
 	e.GET("/watch/:name", func(c echo.Context) error {
 		name := c.Param("name")
 		if len(name) == 0 {
@@ -66,11 +72,7 @@ func run() error {
 		if err != nil {
 			return err
 		}
-		var content bytes.Buffer
-		if err := scheme.Serializer.Encoder().Encode(serializer.NewJSONFrameWriter(&content), obj); err != nil {
-			return err
-		}
-		return c.JSONBlob(http.StatusOK, content.Bytes())
+		return common.WriteObject(c, http.StatusOK, obj)
 	})
 
 	e.PUT("/watch/:name", func(c echo.Context) error {
@@ -79,11 +81,85 @@ func run() error {
 			return echo.NewHTTPError(http.StatusBadRequest, "Please set name")
 		}
 
-		if err := common.SetNewCarStatus(watchStorage, common.CarKeyForName(name)); err != nil {
+		key := common.CarKeyForName(name)
+		// Without a body, just set a new status for the Car
+		if c.Request().ContentLength == 0 {
+			if err := common.SetNewCarStatus(watchStorage, key); err != nil {
+				return err
+			}
+			return c.String(200, "OK!")
+		}
+
+		obj, err := common.ReadObject(c)
+		if err != nil {
+			return err
+		}
+		if id := obj.GetNamespace() + "/" + obj.GetName(); id != key.GetIdentifier() {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("expected Car %q, got %q", key.GetIdentifier(), id))
+		}
+		if err := watchStorage.Update(obj); err != nil {
 			return err
 		}
-		return c.String(200, "OK!")
+		return common.WriteObject(c, http.StatusOK, obj)
 	})
 
-	return common.StartEcho(e)
+	// The storage is closed after the server has shut down
+	return common.StartEcho(e, watchStorage)
+}
+
+// streamCars returns a handler streaming the events of the Cars in the given storage as
+// server-sent events, with the objects encoded in the negotiated content type (see
+// rest.WriteEvent), until the client disconnects
+func streamCars(s update.EventStorage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		watcher, ok := s.(rest.Watcher)
+		if !ok {
+			return echo.NewHTTPError(http.StatusNotImplemented, "watching is not supported by the storage")
+		}
+
+		// The subscription ends when the client disconnects
+		ctx := c.Request().Context()
+		updates := make(update.UpdateStream, 16)
+		if err := watcher.WatchKind(ctx, common.CarGVK, updates); err != nil {
+			return err
+		}
+
+		ct := common.NegotiateContentType(c)
+		resp := c.Response()
+		resp.Header().Set(echo.HeaderContentType, rest.EventStreamContentType)
+		resp.Header().Set("Cache-Control", "no-cache")
+		resp.WriteHeader(http.StatusOK)
+		resp.Flush()
+
+		for {
+			select {
+			case upd := <-updates:
+				if err := rest.WriteEvent(resp, scheme.Serializer, ct, upd.Event, eventObject(s, upd)); err != nil {
+					return nil // The client disconnected
+				}
+				resp.Flush()
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+}
+
+// eventObject returns the object to stream for the given Update: the current object for CREATE
+// and MODIFY events if it can be read, and the metadata sent with the event otherwise
+func eventObject(s storage.Storage, upd update.Update) runtime.Object {
+	switch upd.Event {
+	case update.ObjectEventSync:
+		return nil
+	case update.ObjectEventCreate, update.ObjectEventModify:
+		if upd.Object != nil {
+			return upd.Object
+		}
+		if key, err := s.ObjectKeyFor(upd.PartialObject); err == nil {
+			if obj, err := s.Get(key); err == nil {
+				return obj
+			}
+		}
+	}
+	return upd.PartialObject
 }
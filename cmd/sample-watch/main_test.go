@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/scheme"
+	"github.com/weaveworks/libgitops/pkg/rest"
+	"github.com/weaveworks/libgitops/pkg/storage/watch"
+)
+
+func TestStreamCars(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sample-watch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := watch.NewManifestStorage(dir, scheme.Serializer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	e := echo.New()
+	e.GET("/watch", streamCars(s))
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/watch", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(echo.HeaderAccept, rest.EventStreamContentType+", application/yaml")
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get(echo.HeaderContentType); ct != rest.EventStreamContentType {
+		t.Fatalf("expected content type %q, got %q", rest.EventStreamContentType, ct)
+	}
+
+	frames := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		var lines []string
+		for scanner.Scan() {
+			if len(scanner.Text()) != 0 {
+				lines = append(lines, scanner.Text())
+				continue
+			}
+			frames <- strings.Join(lines, "\n")
+			lines = nil
+		}
+		close(frames)
+	}()
+	expectFrame := func(contains ...string) {
+		t.Helper()
+		select {
+		case frame, ok := <-frames:
+			if !ok {
+				t.Fatal("the stream ended unexpectedly")
+			}
+			for _, c := range contains {
+				if !strings.Contains(frame, c) {
+					t.Errorf("expected the frame to contain %q, got:\n%s", c, frame)
+				}
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("timed out waiting for a frame containing %q", contains)
+		}
+	}
+
+	// Change the manifest of a Car on disk, the writes through the storage itself are not streamed
+	file := filepath.Join(dir, "foo.yaml")
+	writeCar := func(brand string) {
+		car := "apiVersion: sample-app.weave.works/v1alpha1\nkind: Car\nmetadata:\n  name: foo\n  namespace: default\nspec:\n  brand: " + brand + "\n"
+		if err := ioutil.WriteFile(file, []byte(car), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeCar("Volvo")
+	expectFrame("event: CREATE\n", "data:   brand: Volvo")
+	writeCar("Saab")
+	expectFrame("event: MODIFY\n", "data:   brand: Saab")
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	expectFrame("event: DELETE\n", "uid: default/foo")
+}
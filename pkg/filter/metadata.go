@@ -0,0 +1,17 @@
+package filter
+
+// WithMetadataOnly returns a ListOption which only decodes the TypeMeta and ObjectMeta of the
+// listed objects, which are returned as runtime.PartialObjects without their spec and status.
+// This is a lot cheaper when e.g. only the labels of many objects are needed, like listing
+// the metadata.k8s.io API of Kubernetes. The filters are applied to the partial objects.
+func WithMetadataOnly() ListOption {
+	return metadataOnlyOption{}
+}
+
+type metadataOnlyOption struct{}
+
+// ApplyToListOptions implements ListOption, and sets ListOptions.MetadataOnly.
+func (metadataOnlyOption) ApplyToListOptions(target *ListOptions) error {
+	target.MetadataOnly = true
+	return nil
+}
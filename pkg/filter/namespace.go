@@ -0,0 +1,30 @@
+package filter
+
+import (
+	"github.com/weaveworks/libgitops/pkg/runtime"
+)
+
+// NamespaceFilter implements ObjectFilter and ListOption.
+var _ ObjectFilter = NamespaceFilter{}
+var _ ListOption = NamespaceFilter{}
+
+// NamespaceFilter is an ObjectFilter that compares runtime.Object.GetNamespace()
+// to the Namespace field by equality.
+type NamespaceFilter struct {
+	// Namespace matches the object by .metadata.namespace. If left as
+	// an empty string, it matches objects without a namespace.
+	// +optional
+	Namespace string
+}
+
+// Filter implements ObjectFilter
+func (f NamespaceFilter) Filter(obj runtime.Object) (bool, error) {
+	return f.Namespace == obj.GetNamespace(), nil
+}
+
+// ApplyToListOptions implements ListOption, and adds itself converted to
+// a ListFilter to ListOptions.Filters.
+func (f NamespaceFilter) ApplyToListOptions(target *ListOptions) error {
+	target.Filters = append(target.Filters, ObjectToListFilter(f))
+	return nil
+}
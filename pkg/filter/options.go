@@ -5,6 +5,12 @@ type ListOptions struct {
 	// Filters contains a chain of ListFilters, which will be processed in order and pipe the
 	// available objects through before returning.
 	Filters []ListFilter
+	// Limit is the maximum amount of objects to return, if positive. See WithLimit.
+	Limit int
+	// Continue is the continue token of the previous page to continue listing from. See WithContinue.
+	Continue string
+	// MetadataOnly specifies whether to only decode the metadata of the objects. See WithMetadataOnly.
+	MetadataOnly bool
 }
 
 // ListOption is an interface which can be passed into e.g. List() methods as a variadic-length
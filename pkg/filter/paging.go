@@ -0,0 +1,36 @@
+package filter
+
+import "fmt"
+
+// WithLimit returns a ListOption limiting the amount of returned objects to limit.
+// If more objects match, a continue token is returned for listing the next page
+// using WithContinue. A limit of 0 means no limit.
+func WithLimit(limit int) ListOption {
+	return limitOption(limit)
+}
+
+// WithContinue returns a ListOption continuing a paginated list from the page
+// the given continue token was returned for.
+func WithContinue(token string) ListOption {
+	return continueOption(token)
+}
+
+type limitOption int
+
+// ApplyToListOptions implements ListOption, and sets ListOptions.Limit.
+func (l limitOption) ApplyToListOptions(target *ListOptions) error {
+	if l < 0 {
+		return fmt.Errorf("the limit must not be negative: %w", ErrInvalidFilterParams)
+	}
+
+	target.Limit = int(l)
+	return nil
+}
+
+type continueOption string
+
+// ApplyToListOptions implements ListOption, and sets ListOptions.Continue.
+func (c continueOption) ApplyToListOptions(target *ListOptions) error {
+	target.Continue = string(c)
+	return nil
+}
@@ -0,0 +1,131 @@
+package logs
+
+import (
+	"fmt"
+
+	"github.com/go-logr/logr"
+	log "github.com/sirupsen/logrus"
+)
+
+// The verbosity levels passed to logr.Logger.V by libgitops. logr has no warning level,
+// so warnings are logged using logr.Logger.Error, or as info messages if there's no error.
+const (
+	// InfoLevel is the verbosity of messages logged at the logrus info level
+	InfoLevel = 0
+	// DebugLevel is the verbosity of messages logged at the logrus debug level
+	DebugLevel = 1
+	// TraceLevel is the verbosity of messages logged at the logrus trace level. These are
+	// logged in hot paths, so check Enabled() before computing the key/value pairs.
+	TraceLevel = 2
+)
+
+// NewLogger returns a logr.Logger writing to the given logrus Logger, or to Logger if nil. The
+// key/value pairs are logged as logrus fields, and the name of the logr.Logger is prepended to
+// the messages, e.g. "GenericWatchStorage: Sending event". The verbosity levels map to the logrus
+// levels as described by InfoLevel, DebugLevel and TraceLevel, and errors are logged at the
+// logrus error level.
+func NewLogger(l *log.Logger) logr.Logger {
+	if l == nil {
+		l = Logger.Logger
+	}
+	return &logrusLogger{l: l, level: log.InfoLevel}
+}
+
+// OrDefault returns l, or a logr.Logger writing to Logger if l is nil. This is used by
+// the components that accept a logr.Logger in their options.
+func OrDefault(l logr.Logger) logr.Logger {
+	if l == nil {
+		return NewLogger(nil)
+	}
+	return l
+}
+
+// logrusLogger implements logr.Logger on top of a logrus Logger
+type logrusLogger struct {
+	l      *log.Logger
+	level  log.Level
+	name   string
+	fields log.Fields
+}
+
+func (l *logrusLogger) Enabled() bool {
+	return l.l.IsLevelEnabled(l.level)
+}
+
+func (l *logrusLogger) Info(msg string, keysAndValues ...interface{}) {
+	if l.Enabled() {
+		l.entry(keysAndValues).Log(l.level, l.message(msg))
+	}
+}
+
+func (l *logrusLogger) Error(err error, msg string, keysAndValues ...interface{}) {
+	entry := l.entry(keysAndValues)
+	if err != nil {
+		entry = entry.WithError(err)
+	}
+	entry.Error(l.message(msg))
+}
+
+func (l *logrusLogger) V(level int) logr.InfoLogger {
+	c := *l
+	switch {
+	case level >= TraceLevel:
+		c.level = log.TraceLevel
+	case level == DebugLevel:
+		c.level = log.DebugLevel
+	default:
+		c.level = log.InfoLevel
+	}
+	return &c
+}
+
+func (l *logrusLogger) WithValues(keysAndValues ...interface{}) logr.Logger {
+	c := *l
+	c.fields = withFields(l.fields, keysAndValues)
+	return &c
+}
+
+func (l *logrusLogger) WithName(name string) logr.Logger {
+	c := *l
+	if len(c.name) == 0 {
+		c.name = name
+	} else {
+		c.name += "." + name
+	}
+	return &c
+}
+
+// message prepends the name of the logger to the given message, if set
+func (l *logrusLogger) message(msg string) string {
+	if len(l.name) == 0 {
+		return msg
+	}
+	return l.name + ": " + msg
+}
+
+// entry returns a logrus Entry with the fields of the logger and the given key/value pairs
+func (l *logrusLogger) entry(keysAndValues []interface{}) *log.Entry {
+	return log.NewEntry(l.l).WithFields(withFields(l.fields, keysAndValues))
+}
+
+// withFields returns a copy of fields with the given key/value pairs added. A key without
+// a value gets a nil value, and keys that aren't strings are formatted using fmt.Sprint.
+func withFields(fields log.Fields, keysAndValues []interface{}) log.Fields {
+	merged := make(log.Fields, len(fields)+len(keysAndValues)/2)
+	for k, v := range fields {
+		merged[k] = v
+	}
+	for i := 0; i < len(keysAndValues); i += 2 {
+		key, ok := keysAndValues[i].(string)
+		if !ok {
+			key = fmt.Sprint(keysAndValues[i])
+		}
+
+		var value interface{}
+		if i+1 < len(keysAndValues) {
+			value = keysAndValues[i+1]
+		}
+		merged[key] = value
+	}
+	return merged
+}
@@ -0,0 +1,85 @@
+package logs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func newTestLogger(level log.Level) (*log.Logger, *bytes.Buffer) {
+	var buf bytes.Buffer
+	l := log.New()
+	l.SetOutput(&buf)
+	l.SetFormatter(&log.JSONFormatter{DisableTimestamp: true})
+	l.SetLevel(level)
+	return l, &buf
+}
+
+// entries decodes the JSON log lines written to buf
+func entries(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var result []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if len(line) == 0 {
+			continue
+		}
+		entry := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatal(err)
+		}
+		result = append(result, entry)
+	}
+	return result
+}
+
+func TestNewLogger(t *testing.T) {
+	l, buf := newTestLogger(log.DebugLevel)
+	logger := NewLogger(l).WithName("GenericWatchStorage").WithValues("path", "/tmp/foo.yaml")
+
+	logger.Info("Sending event", "eventType", "CREATE", "objectID", "default/foo")
+	logger.V(DebugLevel).Info("Skipping event", "eventType", "MODIFY")
+	logger.Error(errors.New("invalid"), "Ignoring file", "dangling")
+
+	expected := []map[string]interface{}{
+		{"level": "info", "msg": "GenericWatchStorage: Sending event", "path": "/tmp/foo.yaml", "eventType": "CREATE", "objectID": "default/foo"},
+		{"level": "debug", "msg": "GenericWatchStorage: Skipping event", "path": "/tmp/foo.yaml", "eventType": "MODIFY"},
+		{"level": "error", "msg": "GenericWatchStorage: Ignoring file", "path": "/tmp/foo.yaml", "error": "invalid", "dangling": nil},
+	}
+	got := entries(t, buf)
+	if len(got) != len(expected) {
+		t.Fatalf("expected %d entries, got %d: %v", len(expected), len(got), got)
+	}
+	for i := range expected {
+		for k, v := range expected[i] {
+			if got[i][k] != v {
+				t.Errorf("entry %d: expected %s=%v, got %v", i, k, v, got[i][k])
+			}
+		}
+	}
+}
+
+func TestLoggerLevels(t *testing.T) {
+	l, buf := newTestLogger(log.InfoLevel)
+	logger := NewLogger(l)
+
+	if !logger.Enabled() {
+		t.Error("expected the info level to be enabled")
+	}
+	for _, level := range []int{DebugLevel, TraceLevel, TraceLevel + 1} {
+		if logger.V(level).Enabled() {
+			t.Errorf("expected verbosity %d to be disabled at the logrus info level", level)
+		}
+		logger.V(level).Info("hidden")
+	}
+	if buf.Len() != 0 {
+		t.Errorf("expected the disabled levels not to log, got %q", buf.String())
+	}
+
+	l.SetLevel(log.TraceLevel)
+	if !logger.V(TraceLevel).Enabled() {
+		t.Error("expected the trace verbosity to be enabled at the logrus trace level")
+	}
+}
@@ -0,0 +1,322 @@
+package rest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/weaveworks/libgitops/pkg/filter"
+	"github.com/weaveworks/libgitops/pkg/logs"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/serializer"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	kruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// maxBodySize is the maximum size of the body of a request
+const maxBodySize = 3 * 1024 * 1024
+
+// errBadRequest is wrapped by the errors caused by invalid requests
+var errBadRequest = errors.New("bad request")
+
+// Handler returns an http.Handler serving the objects of the given Storage over HTTP. Endpoints
+// are served for every kind registered in the scheme of the given Serializer, at the paths
+// returned by KindPath and ObjectPath:
+//
+//	GET    {resource}             lists the objects, see the "limit" and "continue" parameters
+//	GET    {resource}?watch=true  streams the events of the objects, see Watcher
+//	POST   {resource}             creates the object in the body
+//	GET    {resource}/{name}      returns the object, with its checksum as ETag (also HEAD)
+//	PUT    {resource}/{name}      updates the object to the one in the body
+//	PATCH  {resource}/{name}      patches the object, see storage.WriteStorage.PatchWithType
+//	DELETE {resource}/{name}      deletes the object
+//
+// Objects are returned in YAML if the Accept header asks for serializer.ContentTypeYAML, and
+// in JSON otherwise. Lists are returned as one frame per object. The content type of request
+// bodies is given by their Content-Type header, defaulting to JSON. The storage errors are
+// mapped to HTTP status codes, e.g. storage.ErrNotFound to 404 Not Found and
+// storage.ErrAlreadyExists to 409 Conflict. If s implements storage.ContextStorage, its
+// operations run in the context of the request.
+func Handler(s storage.Storage, ser serializer.Serializer) http.Handler {
+	return HandlerWithOptions(s, ser, HandlerOptions{})
+}
+
+// HandlerOptions specifies options for the http.Handler returned by HandlerWithOptions
+type HandlerOptions struct {
+	// Logger receives the logs of the handler, i.e. the requests failing with an internal error,
+	// with the method and path of the request as key/value pairs. (Default: nil, which logs to
+	// logs.Logger, see logs.NewLogger)
+	Logger logr.Logger
+}
+
+// HandlerWithOptions is the same as Handler, but allows customizing the handler using the given
+// HandlerOptions
+func HandlerWithOptions(s storage.Storage, ser serializer.Serializer, opts HandlerOptions) http.Handler {
+	h := &handler{
+		s:      s,
+		ser:    ser,
+		kinds:  make(map[schema.GroupVersionResource]schema.GroupVersionKind),
+		logger: logs.OrDefault(opts.Logger).WithName("Handler"),
+	}
+
+	for gvk := range ser.Scheme().AllKnownTypes() {
+		if gvk.Version == kruntime.APIVersionInternal || strings.HasSuffix(gvk.Kind, "List") {
+			continue
+		}
+		// Only serve kinds with ObjectMeta, which excludes e.g. the option kinds of metav1
+		if obj, err := ser.Scheme().New(gvk); err != nil {
+			continue
+		} else if _, ok := obj.(runtime.Object); !ok {
+			continue
+		}
+		h.kinds[Resource(gvk)] = gvk
+	}
+	return h
+}
+
+// handler implements the handler returned by Handler
+type handler struct {
+	s      storage.Storage
+	ser    serializer.Serializer
+	kinds  map[schema.GroupVersionResource]schema.GroupVersionKind
+	logger logr.Logger
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt, err := parsePath(r.URL.Path)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	gvk, ok := h.kinds[rt.gvr]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	kind := storage.NewKindKey(gvk)
+
+	// Run the storage operations in the context of the request, e.g. to trace them as its children
+	h = &handler{s: storage.WithContext(h.s, r.Context()), ser: h.ser, kinds: h.kinds, logger: h.logger}
+
+	if len(rt.name) == 0 {
+		switch r.Method {
+		case http.MethodGet:
+			if r.URL.Query().Get("watch") == "true" {
+				err = h.watch(w, r, kind, rt.namespace)
+			} else {
+				err = h.list(w, r, kind, rt.namespace)
+			}
+		case http.MethodPost:
+			err = h.create(w, r, kind, rt.namespace)
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+	} else {
+		key := storage.NewObjectKey(kind, runtime.NewIdentifier(JoinIdentifier(rt.namespace, rt.name)))
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			err = h.get(w, r, key)
+		case http.MethodPut:
+			err = h.update(w, r, key)
+		case http.MethodPatch:
+			err = h.patch(w, r, key)
+		case http.MethodDelete:
+			err = h.s.Delete(key)
+		default:
+			w.Header().Set("Allow", "GET, HEAD, PUT, PATCH, DELETE")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+	}
+
+	if err != nil {
+		h.writeError(w, r, err)
+	}
+}
+
+func (h *handler) list(w http.ResponseWriter, r *http.Request, kind storage.KindKey, namespace string) error {
+	opts := []filter.ListOption{filter.WithContinue(r.URL.Query().Get("continue"))}
+	if limit := r.URL.Query().Get("limit"); len(limit) != 0 {
+		l, err := strconv.Atoi(limit)
+		if err != nil || l < 0 {
+			return fmt.Errorf("%w: invalid limit %q", errBadRequest, limit)
+		}
+		opts = append(opts, filter.WithLimit(l))
+	}
+	if len(namespace) != 0 {
+		opts = append(opts, filter.NamespaceFilter{Namespace: namespace})
+	}
+
+	objs, next, err := h.s.ListPage(kind, opts...)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	ct := NegotiateContentType(r.Header.Get("Accept"))
+	fw := serializer.NewFrameWriter(ct, &buf)
+	for _, obj := range objs {
+		if err := h.ser.Encoder().Encode(fw, obj); err != nil {
+			return err
+		}
+	}
+
+	if len(next) != 0 {
+		w.Header().Set(ContinueHeader, next)
+	}
+	return writeBody(w, http.StatusOK, ct, buf.Bytes())
+}
+
+func (h *handler) get(w http.ResponseWriter, r *http.Request, key storage.ObjectKey) error {
+	obj, err := h.s.Get(key)
+	if err != nil {
+		return err
+	}
+	checksum, err := h.s.Checksum(key)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("ETag", strconv.Quote(checksum))
+	return h.writeObject(w, r, http.StatusOK, obj)
+}
+
+func (h *handler) create(w http.ResponseWriter, r *http.Request, kind storage.KindKey, namespace string) error {
+	obj, err := h.readObject(w, r, kind)
+	if err != nil {
+		return err
+	}
+	if obj.GetNamespace() != namespace {
+		return fmt.Errorf("%w: the namespace %q of the object doesn't match the namespace %q of the path", errBadRequest, obj.GetNamespace(), namespace)
+	}
+
+	if err := h.s.Create(obj); err != nil {
+		return err
+	}
+	return h.writeObject(w, r, http.StatusCreated, obj)
+}
+
+func (h *handler) update(w http.ResponseWriter, r *http.Request, key storage.ObjectKey) error {
+	obj, err := h.readObject(w, r, key)
+	if err != nil {
+		return err
+	}
+	if id := JoinIdentifier(obj.GetNamespace(), obj.GetName()); id != key.GetIdentifier() {
+		return fmt.Errorf("%w: the object %q doesn't match the object %q of the path", errBadRequest, id, key.GetIdentifier())
+	}
+
+	if err := h.s.Update(obj); err != nil {
+		return err
+	}
+	return h.writeObject(w, r, http.StatusOK, obj)
+}
+
+func (h *handler) patch(w http.ResponseWriter, r *http.Request, key storage.ObjectKey) error {
+	patch, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		return fmt.Errorf("%w: %v", errBadRequest, err)
+	}
+	patchType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err := h.s.PatchWithType(key, types.PatchType(patchType), patch); err != nil {
+		return err
+	}
+
+	obj, err := h.s.Get(key)
+	if err != nil {
+		return err
+	}
+	return h.writeObject(w, r, http.StatusOK, obj)
+}
+
+// readObject decodes the object in the body of the request, which must be of the given kind
+func (h *handler) readObject(w http.ResponseWriter, r *http.Request, kind storage.KindKey) (runtime.Object, error) {
+	ct := serializer.ContentTypeJSON
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil {
+		ct = serializer.ContentType(mediaType)
+	}
+	if ct != serializer.ContentTypeJSON && ct != serializer.ContentTypeYAML {
+		return nil, fmt.Errorf("%w: %q", serializer.ErrUnsupportedContentType, ct)
+	}
+
+	obj, err := h.ser.Decoder().Decode(serializer.NewFrameReader(ct, http.MaxBytesReader(w, r.Body, maxBodySize)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errBadRequest, err)
+	}
+	metaObj, ok := obj.(runtime.Object)
+	if !ok {
+		return nil, fmt.Errorf("%w: can't convert to libgitops.runtime.Object", errBadRequest)
+	}
+
+	if gvk := metaObj.GetObjectKind().GroupVersionKind(); !gvk.Empty() && gvk.GroupKind() != kind.GetGVK().GroupKind() {
+		return nil, fmt.Errorf("%w: expected an object of kind %s, got %s", errBadRequest, kind.GetGVK().GroupKind(), gvk.GroupKind())
+	}
+	return metaObj, nil
+}
+
+// writeObject writes the given object in the content type negotiated for the request
+func (h *handler) writeObject(w http.ResponseWriter, r *http.Request, status int, obj runtime.Object) error {
+	var buf bytes.Buffer
+	ct := NegotiateContentType(r.Header.Get("Accept"))
+	if err := h.ser.Encoder().Encode(serializer.NewFrameWriter(ct, &buf), obj); err != nil {
+		return err
+	}
+
+	return writeBody(w, status, ct, buf.Bytes())
+}
+
+// NegotiateContentType returns the content type for the given Accept header, which is
+// serializer.ContentTypeYAML if YAML is accepted before JSON, and JSON otherwise
+func NegotiateContentType(accept string) serializer.ContentType {
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(mediaRange)
+		if err != nil {
+			continue
+		}
+
+		switch serializer.ContentType(mediaType) {
+		case serializer.ContentTypeYAML:
+			return serializer.ContentTypeYAML
+		case serializer.ContentTypeJSON:
+			return serializer.ContentTypeJSON
+		}
+	}
+	return serializer.ContentTypeJSON
+}
+
+// writeBody writes the given response body of the given content type
+func writeBody(w http.ResponseWriter, status int, ct serializer.ContentType, body []byte) error {
+	w.Header().Set("Content-Type", string(ct))
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	_, err := w.Write(body)
+	return err
+}
+
+// writeError writes the HTTP error for the given error
+func (h *handler) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, storage.ErrAlreadyExists):
+		status = http.StatusConflict
+	case errors.Is(err, storage.ErrUnsupportedPatchType), errors.Is(err, serializer.ErrUnsupportedContentType):
+		status = http.StatusUnsupportedMediaType
+	case errors.Is(err, storage.ErrInvalidContinue), errors.Is(err, errBadRequest):
+		status = http.StatusBadRequest
+	default:
+		h.logger.Error(err, "Request failed", "method", r.Method, "path", r.URL.Path)
+	}
+
+	http.Error(w, err.Error(), status)
+}
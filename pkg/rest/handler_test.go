@@ -0,0 +1,230 @@
+package rest
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/scheme"
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/serializer"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/storage/watch"
+	"github.com/weaveworks/libgitops/pkg/util/filesystem"
+)
+
+const carsPath = "/apis/sample-app.weave.works/v1alpha1/namespaces/default/cars"
+
+func newTestServer(t *testing.T) *httptest.Server {
+	dir, err := filepath.Abs("manifests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := storage.DefaultRawStorageOptions()
+	opts.Filesystem = filesystem.NewInMemory()
+	// Patches are applied to JSON
+	opts.FileLayout = storage.FileLayoutFunc(func(key storage.ObjectKey) string {
+		return key.GetIdentifier() + ".json"
+	})
+	s := storage.NewGenericStorage(storage.NewGenericMappedRawStorageWithOptions(dir, opts), scheme.Serializer, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier})
+
+	return httptest.NewServer(Handler(s, scheme.Serializer))
+}
+
+// request sends a request, and returns the status, content type and body of the response
+func request(t *testing.T, method, url string, header map[string]string, body string) (int, string, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, resp.Header.Get("Content-Type"), string(content)
+}
+
+func TestHandler(t *testing.T) {
+	server := newTestServer(t)
+	defer server.Close()
+
+	car := `{"apiVersion":"sample-app.weave.works/v1alpha1","kind":"Car","metadata":{"name":"foo","namespace":"default"},"spec":{"brand":"Volvo"}}`
+	json := map[string]string{"Content-Type": "application/json"}
+	yaml := map[string]string{"Accept": "application/yaml"}
+
+	tests := []struct {
+		name         string
+		method, path string
+		header       map[string]string
+		body         string
+		status       int
+		contentType  string
+		bodyContains string
+		bodyMissing  string
+	}{
+		{"create", http.MethodPost, carsPath, json, car, http.StatusCreated, "application/json", `"brand": "Volvo"`, ""},
+		{"create existing", http.MethodPost, carsPath, json, car, http.StatusConflict, "", "", ""},
+		{"create in other namespace", http.MethodPost, "/apis/sample-app.weave.works/v1alpha1/namespaces/other/cars", json, car, http.StatusBadRequest, "", "", ""},
+		{"get as JSON", http.MethodGet, carsPath + "/foo", nil, "", http.StatusOK, "application/json", `"brand": "Volvo"`, ""},
+		{"get as YAML", http.MethodGet, carsPath + "/foo", yaml, "", http.StatusOK, "application/yaml", "brand: Volvo", ""},
+		{"update from YAML", http.MethodPut, carsPath + "/foo", map[string]string{"Content-Type": "application/yaml"},
+			"apiVersion: sample-app.weave.works/v1alpha1\nkind: Car\nmetadata:\n  name: foo\n  namespace: default\nspec:\n  brand: Saab\n",
+			http.StatusOK, "application/json", `"brand": "Saab"`, ""},
+		{"update other object", http.MethodPut, carsPath + "/bar", json, car, http.StatusBadRequest, "", "", ""},
+		{"patch", http.MethodPatch, carsPath + "/foo", map[string]string{"Content-Type": "application/strategic-merge-patch+json"},
+			`{"spec":{"engine":"V8"}}`, http.StatusOK, "application/json", `"engine": "V8"`, ""},
+		{"patch with unsupported type", http.MethodPatch, carsPath + "/foo", map[string]string{"Content-Type": "text/plain"},
+			`{}`, http.StatusUnsupportedMediaType, "", "", ""},
+		{"list", http.MethodGet, carsPath, yaml, "", http.StatusOK, "application/yaml", "name: foo", ""},
+		{"list other namespace", http.MethodGet, "/apis/sample-app.weave.works/v1alpha1/namespaces/other/cars", nil, "", http.StatusOK, "application/json", "", "foo"},
+		{"list all namespaces", http.MethodGet, "/apis/sample-app.weave.works/v1alpha1/cars", nil, "", http.StatusOK, "application/json", `"name": "foo"`, ""},
+		{"unsupported method", http.MethodPut, carsPath, nil, "", http.StatusMethodNotAllowed, "", "", ""},
+		{"unknown resource", http.MethodGet, "/apis/sample-app.weave.works/v1alpha1/boats", nil, "", http.StatusNotFound, "", "", ""},
+		{"watch unsupported", http.MethodGet, carsPath + "?watch=true", nil, "", http.StatusNotImplemented, "", "", ""},
+		{"delete", http.MethodDelete, carsPath + "/foo", nil, "", http.StatusOK, "", "", ""},
+		{"get deleted", http.MethodGet, carsPath + "/foo", nil, "", http.StatusNotFound, "", "", ""},
+	}
+	for _, tt := range tests {
+		status, contentType, body := request(t, tt.method, server.URL+tt.path, tt.header, tt.body)
+		if status != tt.status {
+			t.Errorf("%s: expected status %d, got %d: %s", tt.name, tt.status, status, body)
+		}
+		if len(tt.contentType) != 0 && contentType != tt.contentType {
+			t.Errorf("%s: expected content type %q, got %q", tt.name, tt.contentType, contentType)
+		}
+		if !strings.Contains(body, tt.bodyContains) || (len(tt.bodyMissing) != 0 && strings.Contains(body, tt.bodyMissing)) {
+			t.Errorf("%s: unexpected body:\n%s", tt.name, body)
+		}
+	}
+}
+
+func TestListPages(t *testing.T) {
+	server := newTestServer(t)
+	defer server.Close()
+
+	for _, name := range []string{"a", "b", "c"} {
+		car := `{"apiVersion":"sample-app.weave.works/v1alpha1","kind":"Car","metadata":{"name":"` + name + `","namespace":"default"}}`
+		if status, _, body := request(t, http.MethodPost, server.URL+carsPath, nil, car); status != http.StatusCreated {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, status, body)
+		}
+	}
+
+	var names []string
+	next := ""
+	for i := 0; i < 3; i++ {
+		resp, err := http.Get(server.URL + carsPath + "?limit=2&continue=" + next)
+		if err != nil {
+			t.Fatal(err)
+		}
+		objs, err := scheme.Serializer.Decoder().DecodeAll(serializer.NewJSONFrameReader(resp.Body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, obj := range objs {
+			names = append(names, obj.(*v1alpha1.Car).GetName())
+		}
+
+		if next = resp.Header.Get(ContinueHeader); len(next) == 0 {
+			break
+		}
+	}
+	if strings.Join(names, ",") != "a,b,c" {
+		t.Errorf("expected to list a, b and c in two pages, got %v", names)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rest-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	opts := storage.DefaultRawStorageOptions()
+	opts.FileLayout = storage.FlatLayout
+	raw := storage.NewGenericMappedRawStorageWithOptions(dir, opts)
+	s, err := watch.NewGenericWatchStorage(storage.NewGenericStorage(raw, scheme.Serializer, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	server := httptest.NewServer(Handler(s, scheme.Serializer))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + carsPath + "?watch=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != EventStreamContentType {
+		t.Fatalf("expected content type %q, got %q", EventStreamContentType, ct)
+	}
+
+	// Create and delete a Car on disk, like e.g. a git pull would, and read the events. The
+	// writes through the storage itself are not sent to its event stream.
+	file := filepath.Join(dir, "foo.yaml")
+	car := "apiVersion: sample-app.weave.works/v1alpha1\nkind: Car\nmetadata:\n  name: foo\n  namespace: default\nspec:\n  brand: Volvo\n"
+	if err := ioutil.WriteFile(file, []byte(car), 0644); err != nil {
+		t.Fatal(err)
+	}
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	expectEvent := func(event string, data ...string) {
+		t.Helper()
+		var received []string
+		for {
+			select {
+			case line, ok := <-lines:
+				if !ok {
+					t.Fatalf("the stream ended before the %s event", event)
+				}
+				if len(line) != 0 {
+					received = append(received, line)
+					continue
+				}
+
+				frame := strings.Join(received, "\n")
+				if !strings.HasPrefix(frame, "event: "+event+"\n") {
+					t.Fatalf("expected a %s event, got:\n%s", event, frame)
+				}
+				for _, d := range data {
+					if !strings.Contains(frame, d) {
+						t.Errorf("expected the %s event to contain %q, got:\n%s", event, d, frame)
+					}
+				}
+				return
+			case <-time.After(10 * time.Second):
+				t.Fatalf("timed out waiting for the %s event", event)
+			}
+		}
+	}
+	expectEvent("CREATE", `data:     "brand": "Volvo"`)
+
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent("DELETE", `"name":"foo"`, `"namespace":"default"`)
+}
@@ -0,0 +1,106 @@
+package rest
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// ContinueHeader carries the continue token of a listed page, if there are more objects
+	ContinueHeader = "X-Continue"
+	// EventStreamContentType is the content type of the server-sent events of watch requests
+	EventStreamContentType = "text/event-stream"
+)
+
+// Resource returns the resource of the given kind, i.e. its lowercase plural, e.g. "cars" for Car
+func Resource(gvk schema.GroupVersionKind) schema.GroupVersionResource {
+	gvr, _ := meta.UnsafeGuessKindToResource(gvk)
+	return gvr
+}
+
+// KindPath returns the path of the resource of the given kind, in the given namespace if set:
+//
+//	/apis/{group}/{version}/namespaces/{namespace}/{resource}
+//	/apis/{group}/{version}/{resource} (for all namespaces, or objects without a namespace)
+//
+// Kinds of the core group use the /api/{version} prefix instead, like in the Kubernetes API.
+func KindPath(kind storage.KindKey, namespace string) string {
+	gvr := Resource(kind.GetGVK())
+
+	path := "/apis/" + gvr.Group + "/" + gvr.Version
+	if len(gvr.Group) == 0 {
+		path = "/api/" + gvr.Version
+	}
+	if len(namespace) != 0 {
+		path += "/namespaces/" + namespace
+	}
+	return path + "/" + gvr.Resource
+}
+
+// ObjectPath returns the path of the object with the given key, which is identified by its
+// namespace and name, e.g. "default/foo", or only its name if it doesn't have a namespace
+func ObjectPath(key storage.ObjectKey) string {
+	namespace, name := SplitIdentifier(key.GetIdentifier())
+	return KindPath(key, namespace) + "/" + name
+}
+
+// SplitIdentifier splits the given identifier into its namespace and name
+func SplitIdentifier(id string) (namespace, name string) {
+	if i := strings.Index(id, "/"); i >= 0 {
+		return id[:i], id[i+1:]
+	}
+	return "", id
+}
+
+// JoinIdentifier returns the identifier for the given namespace and name
+func JoinIdentifier(namespace, name string) string {
+	if len(namespace) == 0 {
+		return name
+	}
+	return namespace + "/" + name
+}
+
+// route is a parsed request path
+type route struct {
+	gvr       schema.GroupVersionResource
+	namespace string
+	// name is empty for requests to the resource
+	name string
+}
+
+// parsePath parses the given path as returned by KindPath or ObjectPath
+func parsePath(path string) (*route, error) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+
+	r := &route{}
+	switch {
+	case len(parts) >= 3 && parts[0] == "apis":
+		r.gvr.Group, r.gvr.Version, parts = parts[1], parts[2], parts[3:]
+	case len(parts) >= 2 && parts[0] == "api":
+		r.gvr.Version, parts = parts[1], parts[2:]
+	default:
+		return nil, fmt.Errorf("invalid path %q", path)
+	}
+
+	if len(parts) >= 3 && parts[0] == "namespaces" {
+		r.namespace, parts = parts[1], parts[2:]
+	}
+	switch len(parts) {
+	case 2:
+		r.name = parts[1]
+		fallthrough
+	case 1:
+		r.gvr.Resource = parts[0]
+	default:
+		return nil, fmt.Errorf("invalid path %q", path)
+	}
+
+	if len(r.gvr.Resource) == 0 || (len(parts) == 2 && len(r.name) == 0) {
+		return nil, fmt.Errorf("invalid path %q", path)
+	}
+	return r, nil
+}
@@ -0,0 +1,146 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/serializer"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/storage/watch"
+	"github.com/weaveworks/libgitops/pkg/storage/watch/update"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+)
+
+// watchBuffer is the amount of events buffered for every watch request
+const watchBuffer = 16
+
+// Watcher is implemented by Storages that can stream the ObjectEvents of a kind, e.g. the
+// watch.GenericWatchStorage. The watch endpoints are only served for Storages implementing it.
+type Watcher interface {
+	// WatchKind sends the ObjectEvents for objects with the group and kind of gvk to ch, until ctx is cancelled
+	WatchKind(ctx context.Context, gvk schema.GroupVersionKind, ch update.UpdateStream) error
+}
+
+// watch streams the ObjectEvents of the given kind, in the given namespace if set, as server-sent
+// events until the client disconnects. The type of every event is the name of its ObjectEvent, and
+// the data is the object encoded as JSON, or its metadata for DELETE events, see WriteEvent.
+func (h *handler) watch(w http.ResponseWriter, r *http.Request, kind storage.KindKey, namespace string) error {
+	watcher, ok := h.s.(Watcher)
+	if !ok {
+		http.Error(w, "watching is not supported by the storage", http.StatusNotImplemented)
+		return nil
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return fmt.Errorf("streaming is not supported by the connection")
+	}
+
+	// The subscription ends when the client disconnects
+	updates := make(update.UpdateStream, watchBuffer)
+	if err := watcher.WatchKind(r.Context(), kind.GetGVK(), updates); err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", EventStreamContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case upd := <-updates:
+			if upd.Event != update.ObjectEventSync {
+				upd.PartialObject = deletedObject(upd)
+				if len(namespace) != 0 && upd.PartialObject.GetNamespace() != namespace {
+					continue
+				}
+			}
+
+			if err := WriteEvent(w, h.ser, serializer.ContentTypeJSON, upd.Event, h.eventObject(upd)); err != nil {
+				return nil // The client disconnected
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return nil
+		}
+	}
+}
+
+// eventObject returns the object to send for the given Update: the object itself for CREATE and
+// MODIFY events, falling back to its metadata if it can't be read, and its metadata otherwise
+func (h *handler) eventObject(upd update.Update) runtime.Object {
+	switch {
+	case upd.Event == update.ObjectEventSync:
+		return nil
+	case upd.Object != nil:
+		return upd.Object
+	case upd.Event == update.ObjectEventDelete:
+		return upd.PartialObject
+	}
+
+	key, err := h.s.ObjectKeyFor(upd.PartialObject)
+	if err != nil {
+		return upd.PartialObject
+	}
+	obj, err := h.s.Get(key)
+	if err != nil {
+		return upd.PartialObject
+	}
+	return obj
+}
+
+// deletedObject returns the PartialObject of the given Update, with the namespace and name of
+// the deleted object set for DELETE events, which carry its identifier as UID instead
+func deletedObject(upd update.Update) runtime.PartialObject {
+	if upd.Event != update.ObjectEventDelete || upd.PartialObject.GetName() != watch.EventDeleteObjectName {
+		return upd.PartialObject
+	}
+
+	obj := &runtime.PartialObjectImpl{}
+	obj.SetGroupVersionKind(upd.PartialObject.GetObjectKind().GroupVersionKind())
+	namespace, name := SplitIdentifier(string(upd.PartialObject.GetUID()))
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	return obj
+}
+
+// WriteEvent writes the given ObjectEvent for the given object as a server-sent event to w, with the
+// name of the event as its type, and the object encoded in the given content type as its data. The
+// data is empty for SYNC events. PartialObjects are encoded as their metadata.
+func WriteEvent(w io.Writer, ser serializer.Serializer, ct serializer.ContentType, event update.ObjectEvent, obj runtime.Object) error {
+	var data []byte
+	if _, isPartialObject := obj.(runtime.PartialObject); isPartialObject {
+		// PartialObjects aren't registered in any scheme, but only consist of metadata
+		var err error
+		if data, err = json.Marshal(obj); err != nil {
+			return err
+		}
+		if ct == serializer.ContentTypeYAML {
+			if data, err = yaml.JSONToYAML(data); err != nil {
+				return err
+			}
+		}
+	} else if obj != nil {
+		var buf bytes.Buffer
+		if err := ser.Encoder().Encode(serializer.NewFrameWriter(ct, &buf), obj); err != nil {
+			return err
+		}
+		data = buf.Bytes()
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "event: %s\n", event)
+	for _, line := range bytes.Split(bytes.TrimRight(data, "\n"), []byte("\n")) {
+		fmt.Fprintf(&buf, "data: %s\n", line)
+	}
+	buf.WriteByte('\n')
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
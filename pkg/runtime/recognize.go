@@ -0,0 +1,131 @@
+package runtime
+
+import (
+	"bufio"
+	"bytes"
+	"strconv"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+)
+
+// RecognizeGVK returns the GroupVersionKind of the YAML or JSON document in frame, which is empty if
+// the document doesn't specify its apiVersion and kind. Unlike NewPartialObject, only the apiVersion
+// and kind fields are read, which is a lot cheaper for large documents. This makes it possible to
+// skip documents of unwanted kinds before decoding them.
+func RecognizeGVK(frame []byte) (schema.GroupVersionKind, error) {
+	trimmed := bytes.TrimSpace(frame)
+	var apiVersion, kind string
+	var ok bool
+	if len(trimmed) != 0 && trimmed[0] == '{' {
+		apiVersion, kind, ok = peekJSON(trimmed)
+	} else {
+		apiVersion, kind, ok = peekYAML(trimmed)
+	}
+
+	// Fall back to decoding the TypeMeta for documents the peeking can't handle, e.g. YAML flow mappings
+	if !ok {
+		typeMeta := metav1.TypeMeta{}
+		if err := yaml.Unmarshal(frame, &typeMeta); err != nil {
+			return schema.GroupVersionKind{}, err
+		}
+		apiVersion, kind = typeMeta.APIVersion, typeMeta.Kind
+	}
+
+	return schema.FromAPIVersionAndKind(apiVersion, kind), nil
+}
+
+// peekJSON reads the top-level apiVersion and kind fields of the given JSON object, skipping
+// all other fields. ok is false if the document isn't valid, or doesn't contain both fields.
+func peekJSON(doc []byte) (apiVersion, kind string, ok bool) {
+	iter := jsoniter.ConfigCompatibleWithStandardLibrary.BorrowIterator(doc)
+	defer jsoniter.ConfigCompatibleWithStandardLibrary.ReturnIterator(iter)
+
+	var found int
+	iter.ReadObjectCB(func(iter *jsoniter.Iterator, field string) bool {
+		switch field {
+		case "apiVersion":
+			apiVersion = iter.ReadString()
+			found++
+		case "kind":
+			kind = iter.ReadString()
+			found++
+		default:
+			iter.Skip()
+		}
+		// Stop reading once both fields are found
+		return found < 2 && iter.Error == nil
+	})
+	return apiVersion, kind, found == 2 && iter.Error == nil
+}
+
+// peekYAML reads the top-level apiVersion and kind fields of the given YAML document line by
+// line. Only plain and quoted single-line values are supported, ok is false for anything else,
+// or if the document doesn't contain both fields.
+func peekYAML(doc []byte) (apiVersion, kind string, ok bool) {
+	var foundAPIVersion, foundKind bool
+	scanner := bufio.NewScanner(bytes.NewReader(doc))
+	// Lines longer than the buffer fail the scan, which falls back to decoding the document
+	for scanner.Scan() && !(foundAPIVersion && foundKind) {
+		// Top-level fields aren't indented, don't allocate strings for the other lines
+		raw := scanner.Bytes()
+		if len(raw) == 0 || raw[0] == ' ' || raw[0] == '\t' || raw[0] == '#' {
+			continue
+		}
+		line := string(raw)
+
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		field := strings.Trim(strings.TrimSpace(line[:i]), `"'`)
+		if field != "apiVersion" && field != "kind" {
+			continue
+		}
+
+		value, valid := yamlScalar(line[i+1:])
+		if !valid {
+			return "", "", false
+		}
+		if field == "apiVersion" {
+			apiVersion, foundAPIVersion = value, true
+		} else {
+			kind, foundKind = value, true
+		}
+	}
+	return apiVersion, kind, foundAPIVersion && foundKind && scanner.Err() == nil
+}
+
+// yamlScalar returns the string value of a single-line YAML scalar following a colon.
+// valid is false if the value isn't a plain or quoted string.
+func yamlScalar(s string) (value string, valid bool) {
+	// A comment is only started by a # preceded by whitespace
+	if i := strings.Index(s, " #"); i >= 0 && !strings.ContainsAny(s[:i], `"'`) {
+		s = s[:i]
+	}
+	s = strings.TrimSpace(s)
+	if len(s) == 0 {
+		return "", false // The value may be on the following lines
+	}
+
+	switch s[0] {
+	case '"':
+		unquoted, err := strconv.Unquote(s)
+		return unquoted, err == nil
+	case '\'':
+		if len(s) < 2 || s[len(s)-1] != '\'' {
+			return "", false
+		}
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), true
+	case '|', '>', '&', '*', '!', '[', '{', '%', '@', '`':
+		// Block scalars, anchors, aliases, tags and flow collections need a real parser
+		return "", false
+	}
+	if s == "null" || s == "~" {
+		return "", true
+	}
+	return s, true
+}
@@ -0,0 +1,92 @@
+package runtime
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestRecognizeGVK(t *testing.T) {
+	docs := []string{
+		"apiVersion: sample-app.weave.works/v1alpha1\nkind: Car\nmetadata:\n  name: foo\n",
+		"# comment\nmetadata:\n  name: foo\n  kind: NotMe\nspec:\n  apiVersion: nested\nkind: Car # the kind\napiVersion: v1\n",
+		"apiVersion: \"apps/v1\"\nkind: 'Deploy''ment'\n",
+		"kind:\n  Car\napiVersion: v1\n",
+		"kind: &kind Car\napiVersion: v1\n",
+		"{kind: Car, apiVersion: v1}\n",
+		"kind: Car\n",
+		"kind: null\napiVersion: ~\n",
+		"metadata:\n  name: foo\n",
+		"",
+		`{"metadata":{"name":"foo","kind":"NotMe"},"spec":{"list":[{"apiVersion":"nested"}]},"kind":"Car","apiVersion":"v1"}`,
+		`  {"apiVersion": "apps/v1", "kind": "Deployment"}`,
+		`{"kind":"Car"}`,
+		`{"kind":null,"apiVersion":"v1"}`,
+	}
+	for _, doc := range docs {
+		obj, err := NewPartialObject([]byte(doc))
+		if err != nil {
+			t.Fatalf("%q: %v", doc, err)
+		}
+		expected := obj.GetObjectKind().GroupVersionKind()
+
+		gvk, err := RecognizeGVK([]byte(doc))
+		if err != nil {
+			t.Errorf("%q: %v", doc, err)
+		}
+		if gvk != expected {
+			t.Errorf("%q: expected %v, got %v", doc, expected, gvk)
+		}
+	}
+
+	if _, err := RecognizeGVK([]byte("kind: [")); err == nil {
+		t.Error("expected an error for invalid YAML")
+	}
+}
+
+// largeDocument returns a YAML or JSON document with the given amount of items in its spec,
+// and the apiVersion and kind at the end, which is the worst case for peeking
+func largeDocument(items int, json bool) []byte {
+	var b strings.Builder
+	if json {
+		b.WriteString(`{"metadata":{"name":"foo"},"spec":{"items":[`)
+		for i := 0; i < items; i++ {
+			if i > 0 {
+				b.WriteString(",")
+			}
+			fmt.Fprintf(&b, `{"name":"item-%d","value":%d,"labels":{"foo":"bar"}}`, i, i)
+		}
+		b.WriteString(`]},"apiVersion":"sample-app.weave.works/v1alpha1","kind":"Car"}`)
+		return []byte(b.String())
+	}
+
+	b.WriteString("metadata:\n  name: foo\nspec:\n  items:\n")
+	for i := 0; i < items; i++ {
+		fmt.Fprintf(&b, "  - name: item-%d\n    value: %d\n    labels:\n      foo: bar\n", i, i)
+	}
+	b.WriteString("apiVersion: sample-app.weave.works/v1alpha1\nkind: Car\n")
+	return []byte(b.String())
+}
+
+// BenchmarkRecognizeGVK compares peeking at the GroupVersionKind of large documents with decoding their metadata
+func BenchmarkRecognizeGVK(b *testing.B) {
+	for _, format := range []string{"yaml", "json"} {
+		doc := largeDocument(1000, format == "json")
+		b.Run(format+"/peek", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := RecognizeGVK(doc); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(format+"/decode", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := NewPartialObject(doc); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
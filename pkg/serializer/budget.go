@@ -0,0 +1,73 @@
+package serializer
+
+import (
+	"errors"
+	"fmt"
+
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// ErrDecodedBytesExceeded is returned when the decoded documents are larger
+// in memory than allowed by DecodingOptions.MaxDecodedBytes.
+var ErrDecodedBytesExceeded = errors.New("decoded size exceeds the maximum allowed size")
+
+// decodeBudget keeps track of how many bytes may still be decoded
+type decodeBudget struct {
+	max       int64
+	remaining int64
+}
+
+// newDecodeBudget returns a decodeBudget for max bytes, nil or zero means no limit
+func newDecodeBudget(max *int64) *decodeBudget {
+	if max == nil {
+		return &decodeBudget{}
+	}
+	return &decodeBudget{max: *max, remaining: *max}
+}
+
+// consume charges the size of the given document when decoded to the budget, and returns
+// ErrDecodedBytesExceeded if that exceeds the remaining budget. For YAML, the aliases in
+// the document are expanded, just like when decoding it. The expansion is aborted as soon
+// as the budget is exceeded, so an alias "bomb" can't consume unbounded memory or time.
+func (b *decodeBudget) consume(doc []byte, ct ContentType) error {
+	if b.max <= 0 {
+		return nil
+	}
+
+	size := int64(len(doc))
+	if ct == ContentTypeYAML {
+		node, err := yaml.Parse(string(doc))
+		if err != nil {
+			// Let the decoder report the malformed document
+			return nil
+		}
+		size = expandedSize(node.YNode(), b.remaining)
+	}
+
+	if size > b.remaining {
+		b.remaining = 0
+		return fmt.Errorf("%w of %d bytes", ErrDecodedBytesExceeded, b.max)
+	}
+	b.remaining -= size
+	return nil
+}
+
+// expandedSize returns the size of the given YAML node with all aliases expanded. Every
+// node is counted as one byte, scalars additionally as the length of their value. As soon
+// as the size exceeds limit, the walk is stopped, and a size larger than limit returned.
+func expandedSize(node *yaml.Node, limit int64) int64 {
+	switch node.Kind {
+	case yaml.AliasNode:
+		return expandedSize(node.Alias, limit)
+	case yaml.ScalarNode:
+		return 1 + int64(len(node.Value))
+	}
+
+	size := int64(1)
+	for _, child := range node.Content {
+		if size += expandedSize(child, limit-size); size > limit {
+			break
+		}
+	}
+	return size
+}
@@ -0,0 +1,140 @@
+package serializer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+// EncodeCanonical encodes the given objects like Encode, but writes them in a deterministic
+// form to the FrameWriter. See Canonicalize for what the canonical form looks like.
+func (e *encoder) EncodeCanonical(fw FrameWriter, objs ...runtime.Object) error {
+	// Comments are dropped by the canonical form anyways, so don't bother preserving them
+	plain := newEncoder(e.schemeAndCodec, *defaultEncodeOpts())
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+	for _, obj := range objs {
+		buf.Reset()
+		if err := plain.Encode(NewJSONFrameWriter(buf, WithoutTracing()), obj); err != nil {
+			return err
+		}
+
+		doc, err := Canonicalize(buf.Bytes(), fw.ContentType())
+		if err != nil {
+			return err
+		}
+
+		if _, err := fw.Write(doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Canonicalize converts the given YAML or JSON document into its canonical form in the given
+// ContentType. In the canonical form, map keys are sorted, fields with null values, empty maps
+// or empty lists are omitted, and numbers are formatted consistently (e.g. 1.0 and 1e0 both
+// become 1). Comments and the formatting of the original document are not preserved, and JSON
+// is written compactly. Semantically equal documents hence produce identical output.
+func Canonicalize(doc []byte, ct ContentType) ([]byte, error) {
+	tree, err := canonicalTree(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	// Marshalling maps sorts their keys
+	out, err := json.Marshal(tree)
+	if err != nil {
+		return nil, err
+	}
+
+	switch ct {
+	case ContentTypeJSON:
+		return append(out, '\n'), nil
+	case ContentTypeYAML:
+		return yaml.JSONToYAML(out)
+	case ContentTypeCBOR:
+		return jsonToCBOR(out)
+	}
+	return nil, fmt.Errorf("can't canonicalize to %q: %w", ct, ErrUnsupportedContentType)
+}
+
+// canonicalTree decodes the given YAML or JSON document into a normalized generic tree
+func canonicalTree(doc []byte) (interface{}, error) {
+	jsonDoc, err := yaml.YAMLToJSON(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	d := json.NewDecoder(bytes.NewReader(jsonDoc))
+	// Keep the numbers as they were written, so that large integers don't lose precision
+	d.UseNumber()
+
+	var tree interface{}
+	if err := d.Decode(&tree); err != nil {
+		return nil, err
+	}
+	return normalizeValue(tree), nil
+}
+
+// normalizeValue recursively drops empty fields from maps, and normalizes all numbers
+func normalizeValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for key, field := range val {
+			field = normalizeValue(field)
+			if isEmptyValue(field) {
+				delete(val, key)
+				continue
+			}
+			val[key] = field
+		}
+	case []interface{}:
+		// List items are never dropped, as that'd change the indices of the following items
+		for i, item := range val {
+			val[i] = normalizeValue(item)
+		}
+	case json.Number:
+		return normalizeNumber(val)
+	}
+	return v
+}
+
+// isEmptyValue returns true for null values, empty maps and empty lists
+func isEmptyValue(v interface{}) bool {
+	switch val := v.(type) {
+	case nil:
+		return true
+	case map[string]interface{}:
+		return len(val) == 0
+	case []interface{}:
+		return len(val) == 0
+	}
+	return false
+}
+
+// maxExactFloat is the largest integer a float64 can represent without losing precision
+const maxExactFloat = 1 << 53
+
+// normalizeNumber formats integers without a fraction or exponent, and all other
+// numbers in the shortest form that represents them exactly
+func normalizeNumber(n json.Number) json.Number {
+	if i, err := strconv.ParseInt(string(n), 10, 64); err == nil {
+		return json.Number(strconv.FormatInt(i, 10))
+	}
+
+	f, err := strconv.ParseFloat(string(n), 64)
+	if err != nil {
+		return n
+	}
+	if f == math.Trunc(f) && math.Abs(f) <= maxExactFloat {
+		return json.Number(strconv.FormatInt(int64(f), 10))
+	}
+	return json.Number(strconv.FormatFloat(f, 'g', -1, 64))
+}
@@ -0,0 +1,127 @@
+package serializer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+var (
+	// cborEncMode writes deterministic CBOR, so that equal objects are always encoded equally
+	cborEncMode, _ = cbor.CoreDetEncOptions().EncMode()
+	// cborDecMode decodes maps like encoding/json, and rejects duplicate map keys as they
+	// would be lost when converting the document to JSON
+	cborDecMode, _ = cbor.DecOptions{
+		DupMapKey:      cbor.DupMapKeyEnforcedAPF,
+		DefaultMapType: reflect.TypeOf(map[string]interface{}(nil)),
+	}.DecMode()
+)
+
+// jsonToCBOR converts the given JSON document into CBOR. Integers are encoded as CBOR
+// integers if they fit an int64, all other numbers as floating-point values.
+func jsonToCBOR(doc []byte) ([]byte, error) {
+	d := json.NewDecoder(bytes.NewReader(doc))
+	d.UseNumber()
+
+	var content interface{}
+	if err := d.Decode(&content); err != nil {
+		return nil, fmt.Errorf("failed to convert JSON to CBOR: %w", err)
+	}
+	return cborEncMode.Marshal(convertJSONNumbers(content))
+}
+
+// convertJSONNumbers replaces all json.Numbers in the given content with int64 or float64 values
+func convertJSONNumbers(content interface{}) interface{} {
+	switch c := content.(type) {
+	case map[string]interface{}:
+		for key, value := range c {
+			c[key] = convertJSONNumbers(value)
+		}
+	case []interface{}:
+		for i, value := range c {
+			c[i] = convertJSONNumbers(value)
+		}
+	case json.Number:
+		if i, err := c.Int64(); err == nil {
+			return i
+		}
+		f, _ := c.Float64()
+		return f
+	}
+	return content
+}
+
+// cborToJSON converts the given CBOR document into JSON, so that it can be decoded
+// like any other JSON document
+func cborToJSON(doc []byte) ([]byte, error) {
+	var content interface{}
+	if err := cborDecMode.Unmarshal(doc, &content); err != nil {
+		return nil, fmt.Errorf("failed to convert CBOR to JSON: %w", err)
+	}
+	return json.Marshal(content)
+}
+
+// newCBORReader returns a ReadCloser that returns one CBOR data item per Read call. As CBOR data
+// items are self-delimiting, a stream of them (a CBOR sequence) needs no separators. If the given
+// buffer is too short for the item, io.ErrShortBuffer is returned and the rest of the item is
+// returned in subsequent Read calls, like the JSON framer of the API machinery does.
+func newCBORReader(rc io.ReadCloser) io.ReadCloser {
+	return &cborReader{rc: rc, decoder: cborDecMode.NewDecoder(rc)}
+}
+
+type cborReader struct {
+	rc      io.ReadCloser
+	decoder *cbor.Decoder
+	// remaining holds the part of the current item not yet returned
+	remaining []byte
+}
+
+// Read implements io.Reader
+func (r *cborReader) Read(p []byte) (int, error) {
+	if len(r.remaining) == 0 {
+		var item cbor.RawMessage
+		if err := r.decoder.Decode(&item); err != nil {
+			return 0, err
+		}
+		r.remaining = item
+	}
+
+	n := copy(p, r.remaining)
+	r.remaining = r.remaining[n:]
+	if len(r.remaining) != 0 {
+		return n, io.ErrShortBuffer
+	}
+	return n, nil
+}
+
+// Close implements io.Closer
+func (r *cborReader) Close() error {
+	return r.rc.Close()
+}
+
+// cborFrameWriter is a FrameWriter converting the JSON frames written to it into CBOR
+type cborFrameWriter struct {
+	FrameWriter
+}
+
+// Write implements io.Writer, and writes the CBOR equivalent of the JSON frame p
+func (w *cborFrameWriter) Write(p []byte) (int, error) {
+	doc, err := jsonToCBOR(p)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := w.FrameWriter.Write(doc); err != nil {
+		return 0, err
+	}
+	// All of p has been written, although converted
+	return len(p), nil
+}
+
+// ContentType returns ContentTypeJSON, as JSON is written to the cborFrameWriter
+func (w *cborFrameWriter) ContentType() ContentType {
+	return ContentTypeJSON
+}
@@ -0,0 +1,72 @@
+package serializer_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/scheme"
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/weaveworks/libgitops/pkg/serializer"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestCBORRoundtrip(t *testing.T) {
+	newCar := func(name, brand string, persons uint64) *v1alpha1.Car {
+		car := &v1alpha1.Car{Spec: v1alpha1.CarSpec{Brand: brand, YearModel: "2005"}}
+		car.SetName(name)
+		car.SetNamespace("default")
+		car.SetLabels(map[string]string{"color": "red"})
+		car.Status.Persons = persons
+		car.Status.Speed = 12.5
+		return car
+	}
+	cars := []runtime.Object{newCar("foo", "Volvo", 4), newCar("bar", "Saab", 2)}
+
+	for _, count := range []int{1, 2} {
+		var buf bytes.Buffer
+		fw := serializer.NewCBORFrameWriter(&buf)
+		if err := scheme.Serializer.Encoder().Encode(fw, cars[:count]...); err != nil {
+			t.Fatal(err)
+		}
+		if frames := fw.FramesWritten(); frames != count {
+			t.Errorf("expected %d frames, got %d", count, frames)
+		}
+		// CBOR maps with up to 23 entries start with 0xa0 + the length
+		if buf.Len() == 0 || buf.Bytes()[0]&0xe0 != 0xa0 {
+			t.Fatalf("expected a CBOR map, got %x", buf.Bytes())
+		}
+
+		objs, err := scheme.Serializer.Decoder().DecodeAll(serializer.NewCBORFrameReader(serializer.FromBytes(buf.Bytes())))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(objs) != count {
+			t.Fatalf("expected %d objects, got %d", count, len(objs))
+		}
+		for i, obj := range objs {
+			car, ok := obj.(*v1alpha1.Car)
+			if !ok {
+				t.Fatalf("expected a *v1alpha1.Car, got %T", obj)
+			}
+			expected := cars[i].(*v1alpha1.Car)
+			expected.SetGroupVersionKind(v1alpha1.SchemeGroupVersion.WithKind("Car"))
+			if !reflect.DeepEqual(car, expected) {
+				t.Errorf("expected %+v, got %+v", expected, car)
+			}
+		}
+	}
+
+	// DecodeInto works for CBOR frames as well
+	var buf bytes.Buffer
+	if err := scheme.Serializer.Encoder().Encode(serializer.NewCBORFrameWriter(&buf), cars[0]); err != nil {
+		t.Fatal(err)
+	}
+	car := &v1alpha1.Car{}
+	if err := scheme.Serializer.Decoder().DecodeInto(serializer.NewCBORFrameReader(serializer.FromBytes(buf.Bytes())), car); err != nil {
+		t.Fatal(err)
+	}
+	if car.Spec.Brand != "Volvo" || car.Status.Persons != 4 {
+		t.Errorf("unexpected decoded Car %+v", car)
+	}
+}
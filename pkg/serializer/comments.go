@@ -1,7 +1,6 @@
 package serializer
 
 import (
-	"bytes"
 	"encoding/base64"
 	"errors"
 	"fmt"
@@ -63,8 +62,9 @@ func (e *encoder) encodeWithCommentSupport(versionEncoder runtime.Encoder, fw Fr
 	}
 
 	// Encode the new object into a temporary buffer, it should not be written as the "final result" to the FrameWriter
-	buf := new(bytes.Buffer)
-	if err := noAnnotationWrapper(metaObj, e.normalEncodeFunc(versionEncoder, NewYAMLFrameWriter(buf), obj)); err != nil {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if err := noAnnotationWrapper(metaObj, e.normalEncodeFunc(versionEncoder, NewYAMLFrameWriter(buf, WithoutTracing()), obj)); err != nil {
 		// fatal error
 		return err
 	}
@@ -77,6 +77,13 @@ func (e *encoder) encodeWithCommentSupport(versionEncoder runtime.Encoder, fw Fr
 		return err
 	}
 
+	// If asked to, apply the changes onto the old tree, keeping its field order, formatting and comments
+	if e.opts.PreserveFieldOrder != nil && *e.opts.PreserveFieldOrder {
+		applyNodeChanges(priorNode.YNode(), afterNode.YNode())
+		_, err = fmt.Fprint(fw, compactSequenceIndent(priorNode.MustString()))
+		return err
+	}
+
 	// Copy over comments from the old to the new schema
 	if err := comments.CopyComments(priorNode, afterNode, true); err != nil {
 		// fatal error
@@ -84,7 +91,7 @@ func (e *encoder) encodeWithCommentSupport(versionEncoder runtime.Encoder, fw Fr
 	}
 
 	// Print the new schema with the old comments kept to the FrameWriter
-	_, err = fmt.Fprint(fw, afterNode.MustString())
+	_, err = fmt.Fprint(fw, compactSequenceIndent(afterNode.MustString()))
 	// we're done, exit the encode function
 	return err
 }
@@ -149,7 +156,7 @@ func SetCommentSource(obj runtime.Object, source *yaml.RNode) error {
 	}
 
 	// Convert the string to bytes and pass it to setCommentSourceBytes to be applied.
-	if !setCommentSourceBytes(obj, []byte(str)) {
+	if !setCommentSourceBytes(obj, []byte(compactSequenceIndent(str))) {
 		// If this fails, the passed object is not metav1.ObjectMeta compliant.
 		return ErrNoObjectMeta
 	}
@@ -6,6 +6,7 @@ import (
 	"reflect"
 
 	"github.com/weaveworks/libgitops/pkg/util"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
@@ -24,15 +25,17 @@ type DecodingOptions struct {
 	ConvertToHub *bool
 
 	// Parse the YAML/JSON in strict mode, returning a specific error if the input
-	// contains duplicate or unknown fields or formatting errors. (Default: true)
+	// contains duplicate or unknown fields or formatting errors. Unknown and duplicate
+	// fields are listed in a *StrictDecodingError. (Default: true)
 	Strict *bool
 
 	// Automatically default the decoded object. (Default: false)
 	Default *bool
 
-	// Only applicable for Decoder.DecodeAll(). If the underlying data contains a v1.List,
+	// Only applicable for Decoder.DecodeAll() and Decoder.DecodeEach(). If the underlying data contains a v1.List,
 	// the items of the list will be traversed, decoded into their respective types, and
-	// appended to the returned slice. The v1.List will in this case not be returned.
+	// appended to the returned slice. The v1.List will in this case not be returned. Nested
+	// v1.Lists are flattened recursively.
 	// This conversion does NOT support preserving comments. If the given scheme doesn't
 	// recognize the v1.List, before using it will be registered automatically. (Default: true)
 	DecodeListElements *bool
@@ -47,6 +50,17 @@ type DecodingOptions struct {
 	// *runtime.Unknown object when running Decode(All) (true value) or to return an error when
 	// any unrecognized type is found (false value). (Default: false)
 	DecodeUnknown *bool
+
+	// The maximum size in bytes the decoded documents may take in memory, e.g. after expanding the
+	// aliases of a YAML document. If exceeded, decoding is aborted with ErrDecodedBytesExceeded. The
+	// budget applies to all documents decoded in one Decode(All/Each/Into) call, which protects against
+	// untrusted input. Zero means no limit. (Default: 0)
+	MaxDecodedBytes *int64
+
+	// StructuralSchemas maps GroupKinds to the OpenAPI v3 schemas their decoded documents are validated
+	// against, see WithStructuralSchema. Failed validations return a *SchemaValidationError. The schema
+	// must be structural, as for CustomResourceDefinitions. (Default: nil)
+	StructuralSchemas map[schema.GroupKind]*apiextensionsv1.JSONSchemaProps
 }
 
 type DecodingOptionsFunc func(*DecodingOptions)
@@ -87,6 +101,12 @@ func WithUnknownDecode(unknown bool) DecodingOptionsFunc {
 	}
 }
 
+func WithMaxDecodedBytes(maxDecodedBytes int64) DecodingOptionsFunc {
+	return func(opts *DecodingOptions) {
+		opts.MaxDecodedBytes = &maxDecodedBytes
+	}
+}
+
 func WithDecodingOptions(newOpts DecodingOptions) DecodingOptionsFunc {
 	return func(opts *DecodingOptions) {
 		// TODO: Null-check all of these before using them
@@ -102,6 +122,8 @@ func defaultDecodeOpts() *DecodingOptions {
 		DecodeListElements: util.BoolPtr(true),
 		PreserveComments:   util.BoolPtr(false),
 		DecodeUnknown:      util.BoolPtr(false),
+		MaxDecodedBytes:    util.Int64Ptr(0),
+		StructuralSchemas:  nil,
 	}
 }
 
@@ -116,8 +138,9 @@ func newDecodeOpts(fns ...DecodingOptionsFunc) *DecodingOptions {
 type decoder struct {
 	*schemeAndCodec
 
-	decoder runtime.Decoder
-	opts    DecodingOptions
+	decoder    runtime.Decoder
+	opts       DecodingOptions
+	validators *schemaValidators
 }
 
 // Decode returns the decoded object from the next document in the FrameReader stream.
@@ -129,7 +152,9 @@ type decoder struct {
 // If opts.Default is true, the decoded object will be defaulted.
 // If opts.Strict is true, the YAML/JSON will be parsed in strict mode, returning a specific error
 // 	if the input contains duplicate or unknown fields or formatting errors. You can check whether
-// 	a returned failed because of the strictness using k8s.io/apimachinery/pkg/runtime.IsStrictDecodingError.
+// 	a returned failed because of the strictness using IsStrictDecodingError.
+// If opts.StructuralSchemas contains a schema for the GroupKind of the document, the document is
+// 	validated against it, and a *SchemaValidationError is returned if it isn't valid.
 // If opts.ConvertToHub is true, the decoded external object will be converted into its hub
 // 	(or internal, if applicable) representation.
 // 	Otherwise, the decoded object will be left in the external representation.
@@ -137,25 +162,55 @@ type decoder struct {
 // 	*runtime.Unknown object instead of returning a UnrecognizedTypeError.
 // opts.DecodeListElements is not applicable in this call.
 func (d *decoder) Decode(fr FrameReader) (runtime.Object, error) {
+	return d.decodeNext(fr, newDecodeBudget(d.opts.MaxDecodedBytes))
+}
+
+// decodeNext decodes the next document in the FrameReader stream, charging its size to budget
+func (d *decoder) decodeNext(fr FrameReader, budget *decodeBudget) (runtime.Object, error) {
 	// Read a frame from the FrameReader
 	// TODO: Make sure to test the case when doc might contain something, and err is io.EOF
 	doc, err := fr.ReadFrame()
 	if err != nil {
 		return nil, err
 	}
-	return d.decode(doc, nil, fr.ContentType())
+	if err := budget.consume(doc, fr.ContentType()); err != nil {
+		return nil, err
+	}
+
+	start := d.startObserving()
+	obj, err := d.decode(doc, nil, fr.ContentType())
+	if err != nil {
+		return nil, err
+	}
+	d.observe(OperationDecode, obj, int64(len(doc)), start)
+	return obj, nil
 }
 
 func (d *decoder) decode(doc []byte, into runtime.Object, ct ContentType) (runtime.Object, error) {
+	// CBOR documents are decoded as their JSON equivalent
+	if ct == ContentTypeCBOR {
+		var err error
+		if doc, err = cborToJSON(doc); err != nil {
+			return nil, err
+		}
+		ct = ContentTypeJSON
+	}
+
 	// If the scheme doesn't recognize a v1.List, and we enabled opts.DecodeListElements,
 	// make the scheme able to decode the v1.List automatically
-	if *d.opts.DecodeListElements && !d.scheme.Recognizes(listGVK) {
-		d.scheme.AddKnownTypes(metav1.Unversioned, &metav1.List{})
+	if *d.opts.DecodeListElements {
+		registerList(d.scheme)
 	}
 
 	// Record if this decode call should have runtime.DecodeInto-functionality
 	intoGiven := into != nil
 
+	// Validate the raw document against the structural schema of its kind, if any, before
+	// decoding it into a struct, which could coerce or reject the invalid values
+	if err := d.validators.validate(doc); err != nil {
+		return nil, err
+	}
+
 	// Use our own special (e.g. strict, defaulting/non-defaulting) decoder
 	// TODO: Make sure any possible strict errors are returned/handled properly
 	obj, gvk, err := d.decoder.Decode(doc, nil, into)
@@ -201,7 +256,9 @@ func (d *decoder) decode(doc []byte, into runtime.Object, ct ContentType) (runti
 // If opts.Default is true, the decoded object will be defaulted.
 // If opts.Strict is true, the YAML/JSON will be parsed in strict mode, returning a specific error
 // 	if the input contains duplicate or unknown fields or formatting errors. You can check whether
-// 	a returned failed because of the strictness using k8s.io/apimachinery/pkg/runtime.IsStrictDecodingError.
+// 	a returned failed because of the strictness using IsStrictDecodingError.
+// If opts.StructuralSchemas contains a schema for the GroupKind of the document, the document is
+// 	validated against it, and a *SchemaValidationError is returned if it isn't valid.
 // opts.DecodeListElements is not applicable in this call.
 // opts.ConvertToHub is not applicable in this call.
 // opts.DecodeUnknown is not applicable in this call. In case you want to decode an object into a
@@ -214,10 +271,17 @@ func (d *decoder) DecodeInto(fr FrameReader, into runtime.Object) error {
 	if err != nil {
 		return err
 	}
+	if err := newDecodeBudget(d.opts.MaxDecodedBytes).consume(doc, fr.ContentType()); err != nil {
+		return err
+	}
 
 	// Run the internal decode() and pass the into object
-	_, err = d.decode(doc, into, fr.ContentType())
-	return err
+	start := d.startObserving()
+	if _, err := d.decode(doc, into, fr.ContentType()); err != nil {
+		return err
+	}
+	d.observe(OperationDecode, into, int64(len(doc)), start)
+	return nil
 }
 
 // DecodeAll returns the decoded objects from all documents in the FrameReader stream. The underlying
@@ -227,34 +291,58 @@ func (d *decoder) DecodeInto(fr FrameReader, into runtime.Object) error {
 // If opts.Default is true, the decoded objects will be defaulted.
 // If opts.Strict is true, the YAML/JSON will be parsed in strict mode, returning a specific error
 // 	if the input contains duplicate or unknown fields or formatting errors. You can check whether
-// 	a returned failed because of the strictness using k8s.io/apimachinery/pkg/runtime.IsStrictDecodingError.
+// 	a returned failed because of the strictness using IsStrictDecodingError.
+// If opts.StructuralSchemas contains a schema for the GroupKind of the document, the document is
+// 	validated against it, and a *SchemaValidationError is returned if it isn't valid.
 // If opts.ConvertToHub is true, the decoded external object will be converted into its hub
 // 	(or internal, if applicable) representation.
 // If opts.DecodeListElements is true and the underlying data contains a v1.List,
 // 	the items of the list will be traversed and decoded into their respective types, which are
-// 	added into the returning slice. The v1.List will in this case not be returned.
+// 	added into the returning slice. The v1.List will in this case not be returned. Lists nested
+// 	in the v1.List are flattened recursively.
 // If opts.DecodeUnknown is true, any type with an unrecognized apiVersion/kind will be returned as a
 // 	*runtime.Unknown object instead of returning a UnrecognizedTypeError.
 func (d *decoder) DecodeAll(fr FrameReader) ([]runtime.Object, error) {
 	objs := []runtime.Object{}
+	if err := d.DecodeEach(fr, func(obj runtime.Object) error {
+		objs = append(objs, obj)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return objs, nil
+}
+
+// DecodeEach decodes all documents in the FrameReader stream one-by-one, and passes each decoded
+// object to fn. Contrary to DecodeAll, only one object is held in memory at a time. If fn returns
+// an error, decoding stops and that error is returned. The underlying stream is automatically
+// closed on io.EOF. io.EOF is never returned from this function.
+// The options are applied per-document exactly in the same way as in DecodeAll, i.e. if
+// 	opts.DecodeListElements is true, fn is called once per item in a v1.List.
+func (d *decoder) DecodeEach(fr FrameReader, fn func(obj runtime.Object) error) error {
+	// The budget applies to all documents in the stream
+	budget := newDecodeBudget(d.opts.MaxDecodedBytes)
 	for {
-		obj, err := d.Decode(fr)
+		obj, err := d.decodeNext(fr, budget)
 		if err == io.EOF {
 			// If we encountered io.EOF, we know that all is fine and we can exit the for loop and return
-			break
+			return nil
 		} else if err != nil {
-			return nil, err
+			return err
 		}
 
 		// Extract possibly nested objects within the one we got (e.g. unwrapping lists if asked to),
-		// or just no-op and return the object given for addition to the larger list
+		// or just no-op and return the object given for handing off to fn
 		nestedObjs, err := d.extractNestedObjects(obj, fr.ContentType())
 		if err != nil {
-			return nil, err
+			return err
+		}
+		for _, nestedObj := range nestedObjs {
+			if err := fn(nestedObj); err != nil {
+				return err
+			}
 		}
-		objs = append(objs, nestedObjs...)
 	}
-	return objs, nil
 }
 
 // decodeUnknown decodes bytes of a certain content type into a returned *runtime.Unknown object
@@ -291,6 +379,11 @@ func (d *decoder) handleDecodeError(doc []byte, origErr error) error {
 		return NewUnrecognizedKindError(*gvk, origErr)
 	}
 
+	// If the document contained unknown or duplicate fields, list them all in a structured error
+	if runtime.IsStrictDecodingError(origErr) {
+		return d.newStrictDecodingError(doc, *gvk, origErr)
+	}
+
 	// If nothing else, just return the underlying error
 	return origErr
 }
@@ -307,7 +400,13 @@ func (d *decoder) extractNestedObjects(obj runtime.Object, ct ContentType) ([]ru
 		return []runtime.Object{obj}, nil
 	}
 
-	// Loop through the list, and decode every item. Return the final list
+	// The items of lists decoded from CBOR are JSON, as the list was converted before decoding
+	if ct == ContentTypeCBOR {
+		ct = ContentTypeJSON
+	}
+
+	// Loop through the list, and decode every item. Nested lists are flattened
+	// recursively. Return the final list
 	var objs []runtime.Object
 	for _, item := range list.Items {
 		// Decode each item of the list
@@ -315,11 +414,22 @@ func (d *decoder) extractNestedObjects(obj runtime.Object, ct ContentType) ([]ru
 		if err != nil {
 			return nil, err
 		}
-		objs = append(objs, listobj)
+		nestedObjs, err := d.extractNestedObjects(listobj, ct)
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, nestedObjs...)
 	}
 	return objs, nil
 }
 
+// registerList makes the scheme able to decode and encode the v1.List, if it doesn't already
+func registerList(scheme *runtime.Scheme) {
+	if !scheme.Recognizes(listGVK) {
+		scheme.AddKnownTypes(metav1.Unversioned, &metav1.List{})
+	}
+}
+
 func newDecoder(schemeAndCodec *schemeAndCodec, opts DecodingOptions) Decoder {
 	// Allow both YAML and JSON inputs (JSON is a subset of YAML), and deserialize in strict mode
 	s := json.NewSerializerWithOptions(json.DefaultMetaFactory, schemeAndCodec.scheme, schemeAndCodec.scheme, json.SerializerOptions{
@@ -329,7 +439,7 @@ func newDecoder(schemeAndCodec *schemeAndCodec, opts DecodingOptions) Decoder {
 
 	decodeCodec := decoderForVersion(schemeAndCodec.scheme, s, *opts.Default, *opts.ConvertToHub)
 
-	return &decoder{schemeAndCodec, decodeCodec, opts}
+	return &decoder{schemeAndCodec, decodeCodec, opts, newSchemaValidators(opts.StructuralSchemas)}
 }
 
 // decoderForVersion is used instead of CodecFactory.DecoderForVersion, as we want to use our own converter
@@ -0,0 +1,147 @@
+package serializer
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+// FieldChangeType describes how a field differs between two objects
+type FieldChangeType string
+
+const (
+	// FieldAdded means that the field only exists in the second object
+	FieldAdded FieldChangeType = "added"
+	// FieldRemoved means that the field only exists in the first object
+	FieldRemoved FieldChangeType = "removed"
+	// FieldChanged means that the field exists in both objects, with different values
+	FieldChanged FieldChangeType = "changed"
+)
+
+// FieldChange describes a field that differs between two objects
+type FieldChange struct {
+	// Path is the JSON path of the field, e.g. "spec.engine" or "spec.items[1]"
+	Path string
+	// Type describes how the field differs
+	Type FieldChangeType
+}
+
+// ObjectDiff is the result of Serializer.Diff
+type ObjectDiff struct {
+	// Unified is a unified diff of the canonical YAML encodings of the two objects.
+	// It is empty if the objects are equal.
+	Unified string
+	// Changes lists the differing fields, sorted by path. Only the outermost differing
+	// field is listed, e.g. an added map lists only the map, not all of its keys.
+	Changes []FieldChange
+}
+
+// Empty returns true if the two objects were equal
+func (d *ObjectDiff) Empty() bool {
+	return len(d.Changes) == 0
+}
+
+func (s *serializer) Diff(a, b runtime.Object) (*ObjectDiff, error) {
+	aTree, err := s.encodeTree(a)
+	if err != nil {
+		return nil, err
+	}
+	bTree, err := s.encodeTree(b)
+	if err != nil {
+		return nil, err
+	}
+
+	// Marshalling the generic trees sorts all map keys, which normalizes the field ordering
+	aYAML, err := yaml.Marshal(aTree)
+	if err != nil {
+		return nil, err
+	}
+	bYAML, err := yaml.Marshal(bTree)
+	if err != nil {
+		return nil, err
+	}
+
+	unified, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(aYAML)),
+		B:        difflib.SplitLines(string(bYAML)),
+		FromFile: "a",
+		ToFile:   "b",
+		Context:  3,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	changes := diffFields("", aTree, bTree)
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+
+	return &ObjectDiff{Unified: unified, Changes: changes}, nil
+}
+
+// encodeTree encodes the given object as JSON, and decodes it into a canonical generic tree
+func (s *serializer) encodeTree(obj runtime.Object) (interface{}, error) {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if err := s.Encoder().Encode(NewJSONFrameWriter(buf, WithoutTracing()), obj); err != nil {
+		return nil, err
+	}
+
+	return canonicalTree(buf.Bytes())
+}
+
+// diffFields recursively compares the two generic trees, and returns the changed fields
+func diffFields(path string, a, b interface{}) []FieldChange {
+	switch aVal := a.(type) {
+	case map[string]interface{}:
+		bVal, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+
+		var changes []FieldChange
+		for key, aField := range aVal {
+			bField, ok := bVal[key]
+			if !ok {
+				changes = append(changes, FieldChange{joinFieldPath(path, key), FieldRemoved})
+				continue
+			}
+			changes = append(changes, diffFields(joinFieldPath(path, key), aField, bField)...)
+		}
+		for key := range bVal {
+			if _, ok := aVal[key]; !ok {
+				changes = append(changes, FieldChange{joinFieldPath(path, key), FieldAdded})
+			}
+		}
+		return changes
+	case []interface{}:
+		bVal, ok := b.([]interface{})
+		if !ok {
+			break
+		}
+
+		var changes []FieldChange
+		for i := 0; i < len(aVal) || i < len(bVal); i++ {
+			itemPath := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i >= len(bVal):
+				changes = append(changes, FieldChange{itemPath, FieldRemoved})
+			case i >= len(aVal):
+				changes = append(changes, FieldChange{itemPath, FieldAdded})
+			default:
+				changes = append(changes, diffFields(itemPath, aVal[i], bVal[i])...)
+			}
+		}
+		return changes
+	}
+
+	if reflect.DeepEqual(a, b) {
+		return nil
+	}
+	return []FieldChange{{path, FieldChanged}}
+}
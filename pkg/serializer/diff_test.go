@@ -0,0 +1,53 @@
+package serializer_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/scheme"
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/weaveworks/libgitops/pkg/serializer"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newCar(labels map[string]string, engine string) *v1alpha1.Car {
+	return &v1alpha1.Car{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "sample-app.weave.works/v1alpha1", Kind: "Car"},
+		ObjectMeta: metav1.ObjectMeta{Name: "foo", Labels: labels},
+		Spec:       v1alpha1.CarSpec{Engine: engine, Brand: "Volvo"},
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a := newCar(map[string]string{"removed": "true", "kept": "true"}, "v8")
+	b := newCar(map[string]string{"kept": "true", "added": "true"}, "electric")
+
+	diff, err := scheme.Serializer.Diff(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []serializer.FieldChange{
+		{Path: "metadata.labels.added", Type: serializer.FieldAdded},
+		{Path: "metadata.labels.removed", Type: serializer.FieldRemoved},
+		{Path: "spec.engine", Type: serializer.FieldChanged},
+	}
+	if !reflect.DeepEqual(diff.Changes, expected) {
+		t.Errorf("expected changes %v, got %v", expected, diff.Changes)
+	}
+	for _, line := range []string{"-    removed: \"true\"", "+    added: \"true\"", "-  engine: v8", "+  engine: electric"} {
+		if !strings.Contains(diff.Unified, line+"\n") {
+			t.Errorf("expected the unified diff to contain %q, got:\n%s", line, diff.Unified)
+		}
+	}
+
+	// Equal objects don't produce a diff
+	diff, err = scheme.Serializer.Diff(a, a.DeepCopy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !diff.Empty() || len(diff.Unified) != 0 {
+		t.Errorf("expected no diff, got %v:\n%s", diff.Changes, diff.Unified)
+	}
+}
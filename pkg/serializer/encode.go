@@ -1,8 +1,11 @@
 package serializer
 
 import (
+	"bytes"
+
 	"github.com/sirupsen/logrus"
 	"github.com/weaveworks/libgitops/pkg/util"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 )
@@ -17,6 +20,12 @@ type EncodingOptions struct {
 	// the PreserveComments in DecodingOptions, too. (Default: false)
 	// TODO: Make this a BestEffort & Strict mode
 	PreserveComments *bool
+	// Whether to apply the changes of the object onto the original YAML tree stored when decoding with
+	// PreserveComments, instead of only copying over the comments to a newly-encoded tree. This keeps the
+	// original field ordering and formatting for all unchanged fields, which minimizes the diff against
+	// the original document. This is more expensive than plain comment preservation. Only applicable if
+	// PreserveComments is true. (Default: false)
+	PreserveFieldOrder *bool
 
 	// TODO: Maybe consider an option to always convert to the preferred version (not just internal)
 }
@@ -35,17 +44,32 @@ func WithCommentsEncode(comments bool) EncodingOptionsFunc {
 	}
 }
 
+func WithFieldOrderEncode(fieldOrder bool) EncodingOptionsFunc {
+	return func(opts *EncodingOptions) {
+		opts.PreserveFieldOrder = &fieldOrder
+	}
+}
+
+// WithEncodingOptions sets the non-nil fields of newOpts, the other fields keep their current values
 func WithEncodingOptions(newOpts EncodingOptions) EncodingOptionsFunc {
 	return func(opts *EncodingOptions) {
-		// TODO: Null-check all of these before using them
-		*opts = newOpts
+		if newOpts.Pretty != nil {
+			opts.Pretty = newOpts.Pretty
+		}
+		if newOpts.PreserveComments != nil {
+			opts.PreserveComments = newOpts.PreserveComments
+		}
+		if newOpts.PreserveFieldOrder != nil {
+			opts.PreserveFieldOrder = newOpts.PreserveFieldOrder
+		}
 	}
 }
 
 func defaultEncodeOpts() *EncodingOptions {
 	return &EncodingOptions{
-		Pretty:           util.BoolPtr(true),
-		PreserveComments: util.BoolPtr(false),
+		Pretty:             util.BoolPtr(true),
+		PreserveComments:   util.BoolPtr(false),
+		PreserveFieldOrder: util.BoolPtr(false),
 	}
 }
 
@@ -100,42 +124,113 @@ func (e *encoder) Encode(fw FrameWriter, objs ...runtime.Object) error {
 	return nil
 }
 
+// EncodeList wraps the given objects into a v1.List, and writes it as one document to the
+// FrameWriter. The items are converted like in Encode, and may be of different kinds.
+func (e *encoder) EncodeList(fw FrameWriter, objs ...runtime.Object) error {
+	// Comments can't be preserved for the items of the list
+	plain := newEncoder(e.schemeAndCodec, *defaultEncodeOpts())
+
+	list := &metav1.List{
+		TypeMeta: metav1.TypeMeta{APIVersion: listGVK.GroupVersion().String(), Kind: listGVK.Kind},
+		Items:    make([]runtime.RawExtension, 0, len(objs)),
+	}
+	buf := getBuffer()
+	defer putBuffer(buf)
+	for _, obj := range objs {
+		// The items are encoded as JSON, which the v1.List embeds as-is
+		buf.Reset()
+		if err := plain.Encode(NewJSONFrameWriter(buf, WithoutTracing()), obj); err != nil {
+			return err
+		}
+		// Copy the item, as the buffer is reused
+		raw := append([]byte(nil), bytes.TrimSpace(buf.Bytes())...)
+		list.Items = append(list.Items, runtime.RawExtension{Raw: raw})
+	}
+
+	registerList(e.scheme)
+	return e.EncodeForGroupVersion(fw, list, listGVK.GroupVersion())
+}
+
 // EncodeForGroupVersion encodes the given object for the specific groupversion. If the object
 // is not of that version currently it will try to convert. The output bytes are written to the
 // FrameWriter. The FrameWriter specifies the ContentType.
 func (e *encoder) EncodeForGroupVersion(fw FrameWriter, obj runtime.Object, gv schema.GroupVersion) error {
+	// CBOR is written by converting the JSON encoding of the object
+	if fw.ContentType() == ContentTypeCBOR {
+		fw = &cborFrameWriter{fw}
+	}
+
+	// Get a version-specific encoder for the content type and the specified groupversion
+	versionEncoder, err := e.versionEncoder(fw.ContentType(), *e.opts.Pretty, gv)
+	if err != nil {
+		return err
+	}
+
+	start, written := e.startObserving(), fw.BytesWritten()
+	if err := e.encode(versionEncoder, fw, obj); err != nil {
+		return err
+	}
+	e.observe(OperationEncode, obj, fw.BytesWritten()-written, start)
+	return nil
+}
+
+// encode encodes the given object using the version-specific encoder, and preserves its comments
+func (e *encoder) encode(versionEncoder runtime.Encoder, fw FrameWriter, obj runtime.Object) error {
+
+	// Cast the object to a metav1.Object to get access to annotations
+	metaobj, ok := toMetaObject(obj)
+	// For objects without ObjectMeta, the cast will fail. Allow that failure and do "normal" encoding
+	if !ok {
+		return versionEncoder.Encode(obj, fw)
+	}
+
+	// Specialize the encoder for a specific gv and encode the object
+	return e.encodeWithCommentSupport(versionEncoder, fw, obj, metaobj)
+}
+
+// versionEncoderKey identifies a version-specific encoder cached by schemeAndCodec.versionEncoder
+type versionEncoderKey struct {
+	contentType ContentType
+	pretty      bool
+	gv          schema.GroupVersion
+}
+
+// versionEncoder returns the version-specific encoder for the given content type and groupversion,
+// which is created once and then reused for all objects
+func (s *schemeAndCodec) versionEncoder(contentType ContentType, pretty bool, gv schema.GroupVersion) (runtime.Encoder, error) {
+	key := versionEncoderKey{contentType, pretty, gv}
+	if encoder, ok := s.versionEncoders.Load(key); ok {
+		return encoder.(runtime.Encoder), nil
+	}
+
 	// Get the serializer for the media type
-	serializerInfo, ok := runtime.SerializerInfoForMediaType(e.codecs.SupportedMediaTypes(), string(fw.ContentType()))
+	serializerInfo, ok := runtime.SerializerInfoForMediaType(s.codecs.SupportedMediaTypes(), string(contentType))
 	if !ok {
-		return ErrUnsupportedContentType
+		return nil, ErrUnsupportedContentType
 	}
 
 	// Choose the pretty or non-pretty one
-	encoder := serializerInfo.Serializer
+	var encoder runtime.Encoder = serializerInfo.Serializer
 
 	// Use the pretty serializer if it was asked for and is defined for the content type
-	if *e.opts.Pretty {
+	if pretty {
 		// Apparently not all SerializerInfos have this field defined (e.g. YAML)
 		// TODO: This could be considered a bug in upstream, create an issue
 		if serializerInfo.PrettySerializer != nil {
 			encoder = serializerInfo.PrettySerializer
 		} else {
-			logrus.Debugf("PrettySerializer for ContentType %s is nil, falling back to Serializer.", fw.ContentType())
+			logrus.Debugf("PrettySerializer for ContentType %s is nil, falling back to Serializer.", contentType)
 		}
 	}
 
-	// Get a version-specific encoder for the specified groupversion
-	versionEncoder := encoderForVersion(e.scheme, encoder, gv)
-
-	// Cast the object to a metav1.Object to get access to annotations
-	metaobj, ok := toMetaObject(obj)
-	// For objects without ObjectMeta, the cast will fail. Allow that failure and do "normal" encoding
-	if !ok {
-		return versionEncoder.Encode(obj, fw)
+	// Write the same YAML as upstream, but more efficiently
+	if contentType == ContentTypeYAML {
+		encoder = &yamlSerializer{encoder}
 	}
 
-	// Specialize the encoder for a specific gv and encode the object
-	return e.encodeWithCommentSupport(versionEncoder, fw, obj, metaobj)
+	// Concurrent callers might both create the encoder, but they are equivalent
+	versionEncoder, _ := s.versionEncoders.LoadOrStore(key, encoderForVersion(s.scheme, encoder, gv))
+	return versionEncoder.(runtime.Encoder), nil
 }
 
 // encoderForVersion is used instead of CodecFactory.EncoderForVersion, as we want to use our own converter
@@ -2,6 +2,7 @@ package serializer
 
 import (
 	"fmt"
+	"strings"
 
 	"k8s.io/apimachinery/pkg/runtime/schema"
 )
@@ -73,6 +74,47 @@ const (
 	UnrecognizedTypeErrorCauseUnknownKind UnrecognizedTypeErrorCause = "UnknownKind"
 )
 
+// NewStrictDecodingError returns information about which fields made strict decoding fail
+func NewStrictDecodingError(gvk schema.GroupVersionKind, unknownFields, duplicateFields []string, err error) *StrictDecodingError {
+	return &StrictDecodingError{
+		GVK:             gvk,
+		UnknownFields:   unknownFields,
+		DuplicateFields: duplicateFields,
+		Err:             err,
+	}
+}
+
+// StrictDecodingError describes that the decoded document contained unknown or duplicate fields.
+// The fields are described by their dot-separated paths, e.g. "spec.brand".
+type StrictDecodingError struct {
+	GVK             schema.GroupVersionKind
+	UnknownFields   []string
+	DuplicateFields []string
+	Err             error
+}
+
+// Error implements the error interface
+func (e *StrictDecodingError) Error() string {
+	var causes []string
+	if len(e.UnknownFields) != 0 {
+		causes = append(causes, fmt.Sprintf("unknown fields: %s", strings.Join(e.UnknownFields, ", ")))
+	}
+	if len(e.DuplicateFields) != 0 {
+		causes = append(causes, fmt.Sprintf("duplicate fields: %s", strings.Join(e.DuplicateFields, ", ")))
+	}
+	return fmt.Sprintf("strict decoding of %s failed: %s", e.GVK, strings.Join(causes, "; "))
+}
+
+// GroupVersionKind returns the GroupVersionKind for the error
+func (e *StrictDecodingError) GroupVersionKind() schema.GroupVersionKind {
+	return e.GVK
+}
+
+// Unwrap allows the standard library unwrap the underlying error
+func (e *StrictDecodingError) Unwrap() error {
+	return e.Err
+}
+
 // NewCRDConversionError creates a new CRDConversionError error
 func NewCRDConversionError(gvk *schema.GroupVersionKind, cause CRDConversionErrorCause, err error) *CRDConversionError {
 	if gvk == nil {
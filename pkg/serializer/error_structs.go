@@ -50,3 +50,11 @@ func (fw *errFrameWriter) Write(_ []byte) (n int, err error) {
 func (fw *errFrameWriter) ContentType() ContentType {
 	return fw.contentType
 }
+
+func (fw *errFrameWriter) FramesWritten() int {
+	return 0
+}
+
+func (fw *errFrameWriter) BytesWritten() int64 {
+	return 0
+}
@@ -2,23 +2,32 @@ package serializer
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"sync"
 
+	"github.com/weaveworks/libgitops/pkg/util"
 	"k8s.io/apimachinery/pkg/runtime/serializer/json"
 )
 
 const (
 	defaultBufSize      = 64 * 1024        // 64 kB
 	defaultMaxFrameSize = 16 * 1024 * 1024 // 16 MB
+	// frameSlack is read from the underlying reader per frame in addition to the maximum
+	// frame size, to make room for the separators and whitespace between frames
+	frameSlack = 4 * 1024 // 4 kB
 )
 
 var (
 	// FrameOverflowErr is returned from FrameReader.ReadFrame when one frame exceeds the
-	// maximum size of 16 MB.
+	// maximum size, by default 16 MB (see FrameReaderOptions.MaxFrameSize).
 	FrameOverflowErr = errors.New("frame was larger than maximum allowed size")
+	// ErrInvalidMaxFrameSize is returned from FrameReader.ReadFrame if FrameReaderOptions.MaxFrameSize isn't positive
+	ErrInvalidMaxFrameSize = errors.New("the maximum frame size must be positive")
 )
 
 // ReadCloser in this package is an alias for io.ReadCloser. It helps in Godoc to locate
@@ -37,16 +46,80 @@ type FrameReader interface {
 	ReadFrame() ([]byte, error)
 }
 
+type FrameReaderOptions struct {
+	// The maximum size of one frame in bytes. Reading a larger frame returns FrameOverflowErr,
+	// without reading more than this amount of bytes (plus a few kB for separators) from the
+	// underlying reader, also for content types whose framers buffer whole objects, like JSON.
+	// Values less than one make ReadFrame return ErrInvalidMaxFrameSize. (Default: 16 MB)
+	MaxFrameSize *int
+	// The maximum number of frames to read. Reading more frames returns ErrFrameCountExceeded.
+	// Zero means no limit. (Default: 0)
+	MaxFrames *int
+}
+
+type FrameReaderOptionsFunc func(*FrameReaderOptions)
+
+func WithMaxFrameSize(maxFrameSize int) FrameReaderOptionsFunc {
+	return func(opts *FrameReaderOptions) {
+		opts.MaxFrameSize = &maxFrameSize
+	}
+}
+
+func WithMaxFramesRead(maxFrames int) FrameReaderOptionsFunc {
+	return func(opts *FrameReaderOptions) {
+		opts.MaxFrames = &maxFrames
+	}
+}
+
+// WithFrameReaderOptions sets the non-nil fields of newOpts, the other fields keep their current values
+func WithFrameReaderOptions(newOpts FrameReaderOptions) FrameReaderOptionsFunc {
+	return func(opts *FrameReaderOptions) {
+		if newOpts.MaxFrameSize != nil {
+			opts.MaxFrameSize = newOpts.MaxFrameSize
+		}
+		if newOpts.MaxFrames != nil {
+			opts.MaxFrames = newOpts.MaxFrames
+		}
+	}
+}
+
+func defaultFrameReaderOpts() *FrameReaderOptions {
+	return &FrameReaderOptions{
+		MaxFrameSize: util.IntPtr(defaultMaxFrameSize),
+		MaxFrames:    util.IntPtr(0),
+	}
+}
+
+func newFrameReaderOpts(fns ...FrameReaderOptionsFunc) *FrameReaderOptions {
+	opts := defaultFrameReaderOpts()
+	for _, fn := range fns {
+		fn(opts)
+	}
+	return opts
+}
+
 // NewFrameReader returns a FrameReader for the given ContentType and data in the
 // ReadCloser. The Reader is automatically closed in io.EOF. ReadFrame is called
 // once each Decoder.Decode() or Decoder.DecodeInto() call. When Decoder.DecodeAll() is
-// called, the FrameReader is read until io.EOF, upon where it is closed.
-func NewFrameReader(contentType ContentType, rc ReadCloser) FrameReader {
+// called, the FrameReader is read until io.EOF, upon where it is closed. The FrameReader
+// can be customized by passing some options (e.g. WithMaxFrameSize), which protect
+// against unbounded memory usage when reading untrusted data.
+func NewFrameReader(contentType ContentType, rc ReadCloser, fns ...FrameReaderOptionsFunc) FrameReader {
+	opts := newFrameReaderOpts(fns...)
+	if *opts.MaxFrameSize < 1 {
+		return &errFrameReader{fmt.Errorf("%w, got %d", ErrInvalidMaxFrameSize, *opts.MaxFrameSize), contentType}
+	}
+
+	// Limit the amount of bytes the framers may read per frame, as e.g. the JSON
+	// framer decodes a whole object before returning any part of it
+	lr := &frameLimitReader{rc: rc, limit: *opts.MaxFrameSize + 1 + frameSlack}
 	switch contentType {
 	case ContentTypeYAML:
-		return newFrameReader(json.YAMLFramer.NewFrameReader(rc), contentType)
+		return newFrameReader(json.YAMLFramer.NewFrameReader(lr), lr, contentType, opts)
 	case ContentTypeJSON:
-		return newFrameReader(json.Framer.NewFrameReader(rc), contentType)
+		return newFrameReader(json.Framer.NewFrameReader(lr), lr, contentType, opts)
+	case ContentTypeCBOR:
+		return newFrameReader(newCBORReader(lr), lr, contentType, opts)
 	default:
 		return &errFrameReader{ErrUnsupportedContentType, contentType}
 	}
@@ -54,35 +127,146 @@ func NewFrameReader(contentType ContentType, rc ReadCloser) FrameReader {
 
 // NewYAMLFrameReader returns a FrameReader that supports both YAML and JSON. Frames are separated by "---\n"
 //
-// This call is the same as NewFrameReader(ContentTypeYAML, rc)
-func NewYAMLFrameReader(rc ReadCloser) FrameReader {
-	return NewFrameReader(ContentTypeYAML, rc)
+// This call is the same as NewFrameReader(ContentTypeYAML, rc, fns...)
+func NewYAMLFrameReader(rc ReadCloser, fns ...FrameReaderOptionsFunc) FrameReader {
+	return NewFrameReader(ContentTypeYAML, rc, fns...)
 }
 
 // NewJSONFrameReader returns a FrameReader that supports both JSON. Objects are read from the stream one-by-one,
 // each object making up its own frame.
 //
-// This call is the same as NewFrameReader(ContentTypeJSON, rc)
-func NewJSONFrameReader(rc ReadCloser) FrameReader {
-	return NewFrameReader(ContentTypeJSON, rc)
+// This call is the same as NewFrameReader(ContentTypeJSON, rc, fns...)
+func NewJSONFrameReader(rc ReadCloser, fns ...FrameReaderOptionsFunc) FrameReader {
+	return NewFrameReader(ContentTypeJSON, rc, fns...)
+}
+
+// NewCBORFrameReader returns a FrameReader for a CBOR sequence, i.e. CBOR data items without
+// separation. Every data item makes up its own frame.
+//
+// This call is the same as NewFrameReader(ContentTypeCBOR, rc, fns...)
+func NewCBORFrameReader(rc ReadCloser, fns ...FrameReaderOptionsFunc) FrameReader {
+	return NewFrameReader(ContentTypeCBOR, rc, fns...)
+}
+
+// NewNDJSONFrameReader returns a FrameReader for newline-delimited JSON, where every line holds
+// one object. As the objects are self-framing, objects spanning multiple lines, e.g. when
+// pretty-printed, are supported as well, and empty lines are skipped.
+//
+// This call is the same as NewFrameReader(ContentTypeJSON, rc, fns...)
+func NewNDJSONFrameReader(rc ReadCloser, fns ...FrameReaderOptionsFunc) FrameReader {
+	return NewFrameReader(ContentTypeJSON, rc, fns...)
+}
+
+// NewContextFrameReader wraps the given FrameReader so that reading is aborted when ctx is done.
+// Before each frame is read, ctx is checked, and if ctx is done while a read is blocked, the
+// underlying FrameReader is closed to unblock it. In both cases, ctx.Err() is returned. This
+// makes it possible to pass a context-aware FrameReader to all Decoder methods. ctx is only
+// watched while ReadFrame runs, so no goroutines are left behind by readers which aren't read
+// until io.EOF or closed. Close may be called concurrently with ReadFrame, and more than once.
+func NewContextFrameReader(ctx context.Context, fr FrameReader) FrameReader {
+	return &contextFrameReader{FrameReader: fr, ctx: ctx}
+}
+
+// contextFrameReader is a FrameReader that aborts reading when its context is done
+type contextFrameReader struct {
+	FrameReader
+	ctx       context.Context
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// ReadFrame reads one frame from the underlying FrameReader, unless the context is done
+func (rf *contextFrameReader) ReadFrame() ([]byte, error) {
+	if err := rf.ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Close the underlying FrameReader if the context is done before the read finished. The
+	// goroutine has exited when ReadFrame returns. Contexts which can't be done aren't watched.
+	if rf.ctx.Done() != nil {
+		done, exited := make(chan struct{}), make(chan struct{})
+		go func() {
+			defer close(exited)
+			select {
+			case <-rf.ctx.Done():
+				_ = rf.Close()
+			case <-done:
+			}
+		}()
+		defer func() {
+			close(done)
+			<-exited
+		}()
+	}
+
+	frame, err := rf.FrameReader.ReadFrame()
+	// If the context was cancelled during the read, the error comes from the closed reader
+	if ctxErr := rf.ctx.Err(); err != nil && ctxErr != nil {
+		return nil, ctxErr
+	}
+	return frame, err
+}
+
+// Close closes the underlying FrameReader once, later calls return the same error
+func (rf *contextFrameReader) Close() error {
+	rf.closeOnce.Do(func() {
+		rf.closeErr = rf.FrameReader.Close()
+	})
+	return rf.closeErr
+}
+
+// frameLimitReader limits the amount of bytes read from rc per frame, see FrameReaderOptions.MaxFrameSize
+type frameLimitReader struct {
+	rc    io.ReadCloser
+	limit int
+	// remaining is the amount of bytes that may still be read for the current frame
+	remaining int
+}
+
+// reset allows reading the limit again, for the next frame
+func (r *frameLimitReader) reset() {
+	r.remaining = r.limit
+}
+
+func (r *frameLimitReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, FrameOverflowErr
+	}
+	if len(p) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err := r.rc.Read(p)
+	r.remaining -= n
+	return n, err
+}
+
+func (r *frameLimitReader) Close() error {
+	return r.rc.Close()
 }
 
 // newFrameReader returns a new instance of the frameReader struct
-func newFrameReader(rc io.ReadCloser, contentType ContentType) *frameReader {
+func newFrameReader(rc io.ReadCloser, lr *frameLimitReader, contentType ContentType, opts *FrameReaderOptions) *frameReader {
 	return &frameReader{
 		rc:           rc,
+		lr:           lr,
 		bufSize:      defaultBufSize,
-		maxFrameSize: defaultMaxFrameSize,
+		maxFrameSize: *opts.MaxFrameSize,
+		maxFrames:    *opts.MaxFrames,
 		contentType:  contentType,
 	}
 }
 
 // frameReader is a FrameReader implementation
 type frameReader struct {
-	rc           io.ReadCloser
+	rc io.ReadCloser
+	// lr limits the amount of bytes read by rc per frame
+	lr           *frameLimitReader
 	bufSize      int
 	maxFrameSize int
-	contentType  ContentType
+	// maxFrames is the maximum number of frames to read, zero means no limit
+	maxFrames   int
+	frames      int
+	contentType ContentType
 
 	// TODO: Maybe add mutexes for thread-safety (so no two goroutines read at the same time)
 }
@@ -93,6 +277,18 @@ type frameReader struct {
 // ReadFrame keeps on reading using new calls. ReadFrame might return both data and
 // io.EOF. io.EOF will be returned in the final call.
 func (rf *frameReader) ReadFrame() (frame []byte, err error) {
+	if frame, err = rf.readFrame(); err == nil {
+		rf.frames++
+		// Reading the frame exceeding the limit is required to know whether it exists
+		if rf.maxFrames > 0 && rf.frames > rf.maxFrames {
+			return nil, fmt.Errorf("%w: can't read more than %d frames", ErrFrameCountExceeded, rf.maxFrames)
+		}
+	}
+	return
+}
+
+func (rf *frameReader) readFrame() (frame []byte, err error) {
+	rf.lr.reset()
 	// Temporary buffer to parts of a frame into
 	var buf []byte
 	// How many bytes were read by the read call
@@ -100,8 +296,13 @@ func (rf *frameReader) ReadFrame() (frame []byte, err error) {
 	// Multiplier for bufsize
 	c := 1
 	for {
-		// Allocate a buffer of a multiple of bufSize.
-		buf = make([]byte, c*rf.bufSize)
+		// Allocate a buffer of a multiple of bufSize, but never buffer more than one byte
+		// over maxFrameSize, which is enough for detecting that the frame is too large
+		bufSize := c * rf.bufSize
+		if remaining := rf.maxFrameSize - len(frame) + 1; bufSize > remaining {
+			bufSize = remaining
+		}
+		buf = make([]byte, bufSize)
 		// Call the underlying reader.
 		n, err = rf.rc.Read(buf)
 		// Append the returned bytes to the b slice returned
@@ -129,6 +330,7 @@ func (rf *frameReader) ReadFrame() (frame []byte, err error) {
 			}
 			// The document was empty, reset the frame (just to be sure) and continue
 			frame = nil
+			rf.lr.reset()
 			continue
 		case io.EOF:
 			// we reached the end of the file, close the reader and return
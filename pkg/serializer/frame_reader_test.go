@@ -1,12 +1,16 @@
 package serializer
 
 import (
+	"context"
+	"errors"
 	"io"
 	"io/ioutil"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
+	"go.uber.org/goleak"
 	"k8s.io/apimachinery/pkg/runtime/serializer/json"
 )
 
@@ -92,6 +96,7 @@ func Test_FrameReader_ReadFrame(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			rf := &frameReader{
 				rc:           tt.fields.rc,
+				lr:           &frameLimitReader{},
 				bufSize:      tt.fields.bufSize,
 				maxFrameSize: tt.fields.maxFrameSize,
 			}
@@ -112,3 +117,143 @@ func Test_FrameReader_ReadFrame(t *testing.T) {
 		})
 	}
 }
+
+func Test_ContextFrameReader(t *testing.T) {
+	// A pipe blocks reads until data is written, or the pipe is closed
+	pr, pw := io.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+	fr := NewContextFrameReader(ctx, NewYAMLFrameReader(pr))
+
+	go func() {
+		_, _ = pw.Write([]byte(fooYAML + "\n---\n"))
+	}()
+	frame, err := fr.ReadFrame()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(frame, []byte(fooYAML)) {
+		t.Errorf("unexpected frame: %q", frame)
+	}
+
+	// The next read blocks, until the context is cancelled
+	errCh := make(chan error)
+	go func() {
+		_, err := fr.ReadFrame()
+		errCh <- err
+	}()
+	cancel()
+	select {
+	case err := <-errCh:
+		if err != context.Canceled {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("blocked read wasn't aborted when the context was cancelled")
+	}
+
+	// Subsequent reads return the context error directly
+	if _, err := fr.ReadFrame(); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+
+	// Closing again is a no-op
+	if err := fr.Close(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func Test_ContextFrameReaderLeaks(t *testing.T) {
+	// The reader is abandoned before io.EOF without closing it, and ctx is never cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fr := NewContextFrameReader(ctx, NewYAMLFrameReader(FromBytes([]byte(testYAML))))
+	if _, err := fr.ReadFrame(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Closing concurrently with reading closes the underlying reader once
+	pr, pw := io.Pipe()
+	fr = NewContextFrameReader(ctx, NewYAMLFrameReader(pr))
+	errCh := make(chan error)
+	go func() {
+		_, err := fr.ReadFrame()
+		errCh <- err
+	}()
+	for i := 0; i < 2; i++ {
+		if err := fr.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := <-errCh; err == nil {
+		t.Error("expected the read of the closed reader to fail")
+	}
+	_ = pw.Close()
+
+	// Verify before ctx is cancelled, which would stop any goroutines watching it
+	goleak.VerifyNone(t, goleak.IgnoreTopFunction("k8s.io/klog.(*loggingT).flushDaemon"))
+}
+
+// countingReader counts the bytes read from r
+type countingReader struct {
+	r ReadCloser
+	n int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += n
+	return n, err
+}
+
+func (r *countingReader) Close() error {
+	return r.r.Close()
+}
+
+func TestFrameReaderLimits(t *testing.T) {
+	// The second document is larger than the maximum frame size
+	fr := NewYAMLFrameReader(FromBytes([]byte(bazYAML+"\n---\n"+fooYAML)), WithMaxFrameSize(len(bazYAML)+1))
+	if frame, err := fr.ReadFrame(); err != nil || !strings.Contains(string(frame), bazYAML) {
+		t.Fatalf("expected the first frame to be read, got %q (%v)", frame, err)
+	}
+	if _, err := fr.ReadFrame(); !errors.Is(err, FrameOverflowErr) {
+		t.Errorf("expected FrameOverflowErr, got %v", err)
+	}
+
+	// The JSON framer buffers whole objects, but the underlying reader is limited as well
+	large := []byte(`{"kind": "Foo", "data": "` + strings.Repeat("x", 1<<20) + `"}`)
+	cr := &countingReader{r: FromBytes(large)}
+	fr = NewJSONFrameReader(cr, WithMaxFrameSize(1024))
+	if _, err := fr.ReadFrame(); !errors.Is(err, FrameOverflowErr) {
+		t.Errorf("expected FrameOverflowErr, got %v", err)
+	}
+	if limit := 1024 + 1 + frameSlack; cr.n > limit {
+		t.Errorf("expected at most %d bytes to be read, got %d", limit, cr.n)
+	}
+
+	// Frames of the maximum size are read, also if they follow each other closely
+	obj := `{"kind": "Foo", "data": "` + strings.Repeat("x", 1000) + `"}`
+	fr = NewNDJSONFrameReader(FromBytes([]byte(strings.Repeat(obj+"\n", 10))), WithMaxFrameSize(len(obj)))
+	for i := 0; i < 10; i++ {
+		if frame, err := fr.ReadFrame(); err != nil || len(frame) != len(obj) {
+			t.Fatalf("expected frame %d to be read, got %d bytes (%v)", i, len(frame), err)
+		}
+	}
+
+	// Invalid maximum sizes are rejected instead of looping or panicking
+	for _, size := range []int{0, -1, -100} {
+		fr = NewJSONFrameReader(FromBytes([]byte(obj)), WithMaxFrameSize(size))
+		if _, err := fr.ReadFrame(); !errors.Is(err, ErrInvalidMaxFrameSize) {
+			t.Errorf("expected ErrInvalidMaxFrameSize for %d, got %v", size, err)
+		}
+	}
+
+	fr = NewYAMLFrameReader(FromBytes([]byte(testYAML)), WithMaxFramesRead(2))
+	for i := 0; i < 2; i++ {
+		if _, err := fr.ReadFrame(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := fr.ReadFrame(); !errors.Is(err, ErrFrameCountExceeded) {
+		t.Errorf("expected ErrFrameCountExceeded, got %v", err)
+	}
+}
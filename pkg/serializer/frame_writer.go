@@ -1,11 +1,40 @@
 package serializer
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"sync"
+
+	"github.com/weaveworks/libgitops/pkg/util"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// yamlSeparator is written between the YAML frames. It's a variable instead of a converted
+// constant to not allocate it for every frame.
+var yamlSeparator = []byte("---\n")
+
+const (
+	// tracerName is the name of the default OpenTelemetry tracer of the FrameWriters
+	tracerName = "github.com/weaveworks/libgitops/pkg/serializer"
 )
 
 const (
-	yamlSeparator = "---\n"
+	// contentTypeAttribute holds the content type of a written frame
+	contentTypeAttribute = attribute.Key("libgitops.content_type")
+	// frameSizeAttribute holds the size of a written frame in bytes, excluding any separators
+	frameSizeAttribute = attribute.Key("libgitops.frame_size")
+)
+
+var (
+	// ErrFrameCountExceeded is returned from FrameWriter.Write or FrameReader.ReadFrame when writing or
+	// reading more frames than allowed by FrameWriterOptions.MaxFrames or FrameReaderOptions.MaxFrames.
+	ErrFrameCountExceeded = errors.New("frame count exceeded")
 )
 
 // Writer in this package is an alias for io.Writer. It helps in Godoc to locate
@@ -17,19 +46,120 @@ type Writer io.Writer
 type FrameWriter interface {
 	ContentTyped
 	Writer
+
+	// FramesWritten returns the number of frames successfully written
+	FramesWritten() int
+	// BytesWritten returns the number of bytes written to the underlying Writer,
+	// including e.g. the separators written between frames
+	BytesWritten() int64
 }
 
-// NewFrameWriter returns a new FrameWriter for the given Writer and ContentType
-func NewFrameWriter(contentType ContentType, w Writer) FrameWriter {
+type FrameWriterOptions struct {
+	// Whether to write the YAML separator "---" before the first document, too. Otherwise, the
+	// separator is only written between documents, and a single document has no separator at all.
+	// Only applicable to ContentTypeYAML FrameWriters. (Default: false)
+	LeadingSeparator *bool
+	// The maximum number of frames to write. Writing more frames returns ErrFrameCountExceeded, and
+	// leaves the frames written before intact. Zero means no limit. (Default: 0)
+	MaxFrames *int
+	// The tracer creating a span for every written frame. A nil tracer disables the tracing,
+	// which avoids its overhead entirely, see WithoutTracing. (Default: the tracer of the global
+	// TracerProvider, see otel.GetTracerProvider)
+	Tracer trace.Tracer
+	// The context of the caller, the spans of the frames are its children. (Default: nil, which
+	// makes every frame the root span of a new trace)
+	TraceContext context.Context
+}
+
+type FrameWriterOptionsFunc func(*FrameWriterOptions)
+
+func WithLeadingSeparator(leadingSeparator bool) FrameWriterOptionsFunc {
+	return func(opts *FrameWriterOptions) {
+		opts.LeadingSeparator = &leadingSeparator
+	}
+}
+
+func WithMaxFrames(maxFrames int) FrameWriterOptionsFunc {
+	return func(opts *FrameWriterOptions) {
+		opts.MaxFrames = &maxFrames
+	}
+}
+
+// WithTracer makes the FrameWriter create a span using the given tracer for every written
+// frame, e.g. to integrate with the tracing backend of an application
+func WithTracer(tracer trace.Tracer) FrameWriterOptionsFunc {
+	return func(opts *FrameWriterOptions) {
+		opts.Tracer = tracer
+	}
+}
+
+// WithoutTracing disables the per-frame tracing of the FrameWriter, e.g. in hot paths
+func WithoutTracing() FrameWriterOptionsFunc {
+	return WithTracer(nil)
+}
+
+// WithTraceContext makes the spans of the frames children of the span in ctx
+func WithTraceContext(ctx context.Context) FrameWriterOptionsFunc {
+	return func(opts *FrameWriterOptions) {
+		opts.TraceContext = ctx
+	}
+}
+
+// WithFrameWriterOptions sets the non-nil fields of newOpts, the other fields keep their current values
+func WithFrameWriterOptions(newOpts FrameWriterOptions) FrameWriterOptionsFunc {
+	return func(opts *FrameWriterOptions) {
+		if newOpts.LeadingSeparator != nil {
+			opts.LeadingSeparator = newOpts.LeadingSeparator
+		}
+		if newOpts.MaxFrames != nil {
+			opts.MaxFrames = newOpts.MaxFrames
+		}
+		if newOpts.Tracer != nil {
+			opts.Tracer = newOpts.Tracer
+		}
+		if newOpts.TraceContext != nil {
+			opts.TraceContext = newOpts.TraceContext
+		}
+	}
+}
+
+func defaultFrameWriterOpts() *FrameWriterOptions {
+	return &FrameWriterOptions{
+		LeadingSeparator: util.BoolPtr(false),
+		MaxFrames:        util.IntPtr(0),
+		Tracer:           otel.Tracer(tracerName),
+	}
+}
+
+func newFrameWriterOpts(fns ...FrameWriterOptionsFunc) *FrameWriterOptions {
+	opts := defaultFrameWriterOpts()
+	for _, fn := range fns {
+		fn(opts)
+	}
+	return opts
+}
+
+// NewFrameWriter returns a new FrameWriter for the given Writer and ContentType.
+// The FrameWriter can be customized by passing some options (e.g. WithLeadingSeparator).
+func NewFrameWriter(contentType ContentType, w Writer, fns ...FrameWriterOptionsFunc) FrameWriter {
+	opts := newFrameWriterOpts(fns...)
 	switch contentType {
 	case ContentTypeYAML:
 		// Use our own implementation of the underlying YAML FrameWriter
-		return &frameWriter{newYAMLWriter(w), contentType}
+		return newFrameWriter(w, contentType, opts, func(w Writer) Writer {
+			return newYAMLWriter(w, *opts.LeadingSeparator)
+		})
 	case ContentTypeJSON:
 		// Comment from k8s.io/apimachinery/pkg/runtime/serializer/json.Framer.NewFrameWriter:
 		// "we can write JSON objects directly to the writer, because they are self-framing"
 		// Hence, we directly use w without any modifications.
-		return &frameWriter{w, contentType}
+		return newFrameWriter(w, contentType, opts, nil)
+	case ContentTypeCBOR:
+		// CBOR data items are self-delimiting as well, and are written as a CBOR sequence
+		return newFrameWriter(w, contentType, opts, nil)
+	case ContentTypeTable:
+		// Every table written by the TableEncoder is one frame, ending with a newline
+		return newFrameWriter(w, contentType, opts, nil)
 	default:
 		return &errFrameWriter{ErrUnsupportedContentType, contentType}
 	}
@@ -37,26 +167,120 @@ func NewFrameWriter(contentType ContentType, w Writer) FrameWriter {
 
 // NewYAMLFrameWriter returns a FrameWriter that writes YAML frames separated by "---\n"
 //
-// This call is the same as NewFrameWriter(ContentTypeYAML, w)
-func NewYAMLFrameWriter(w Writer) FrameWriter {
-	return NewFrameWriter(ContentTypeYAML, w)
+// This call is the same as NewFrameWriter(ContentTypeYAML, w, fns...)
+func NewYAMLFrameWriter(w Writer, fns ...FrameWriterOptionsFunc) FrameWriter {
+	return NewFrameWriter(ContentTypeYAML, w, fns...)
 }
 
 // NewJSONFrameWriter returns a FrameWriter that writes JSON frames without separation
 // (i.e. "{ ... }{ ... }{ ... }" on the wire)
 //
-// This call is the same as NewFrameWriter(ContentTypeYAML, w)
-func NewJSONFrameWriter(w Writer) FrameWriter {
-	return NewFrameWriter(ContentTypeJSON, w)
+// This call is the same as NewFrameWriter(ContentTypeJSON, w, fns...)
+func NewJSONFrameWriter(w Writer, fns ...FrameWriterOptionsFunc) FrameWriter {
+	return NewFrameWriter(ContentTypeJSON, w, fns...)
+}
+
+// NewCBORFrameWriter returns a FrameWriter that writes CBOR frames without separation,
+// i.e. a CBOR sequence as specified in RFC 8742
+//
+// This call is the same as NewFrameWriter(ContentTypeCBOR, w, fns...)
+func NewCBORFrameWriter(w Writer, fns ...FrameWriterOptionsFunc) FrameWriter {
+	return NewFrameWriter(ContentTypeCBOR, w, fns...)
+}
+
+// NewNDJSONFrameWriter returns a FrameWriter that writes newline-delimited JSON, i.e. every frame
+// is compacted to one line and followed by "\n". Newlines in string values are escaped by the
+// JSON encoding, so they never split a frame. Frames that aren't valid JSON can't be written.
+func NewNDJSONFrameWriter(w Writer, fns ...FrameWriterOptionsFunc) FrameWriter {
+	opts := newFrameWriterOpts(fns...)
+	return newFrameWriter(w, ContentTypeJSON, opts, func(w Writer) Writer {
+		return &ndjsonWriter{w}
+	})
+}
+
+// newFrameWriter returns a new frameWriter writing frames to w as specified by opts. If framer
+// is non-nil, the frames are written to w through the Writer returned by it.
+func newFrameWriter(w Writer, contentType ContentType, opts *FrameWriterOptions, framer func(Writer) Writer) *frameWriter {
+	counter := &countingWriter{w: w}
+	fw := &frameWriter{
+		w:           counter,
+		counter:     counter,
+		maxFrames:   *opts.MaxFrames,
+		tracer:      opts.Tracer,
+		traceCtx:    opts.TraceContext,
+		contentType: contentType,
+	}
+	if fw.traceCtx == nil {
+		fw.traceCtx = context.Background()
+	}
+	if framer != nil {
+		fw.w = framer(counter)
+	}
+	return fw
 }
 
 // frameWriter is an implementation of the FrameWriter interface
 type frameWriter struct {
-	Writer
+	// w writes one frame per Write call
+	w       Writer
+	counter *countingWriter
+	frames  int
+	// maxFrames is the maximum number of frames to write, zero means no limit
+	maxFrames int
+	// tracer creates a span for every frame if non-nil, as a child of traceCtx
+	tracer   trace.Tracer
+	traceCtx context.Context
 
 	contentType ContentType
 
-	// TODO: Maybe add mutexes for thread-safety (so no two goroutines write at the same time)
+	// mux guards w and the counters, so no two goroutines write at the same time
+	mux sync.Mutex
+}
+
+// Write implements io.Writer, and writes p as one frame
+func (wf *frameWriter) Write(p []byte) (n int, err error) {
+	if wf.tracer != nil {
+		_, span := wf.tracer.Start(wf.traceCtx, "FrameWriter.Write", trace.WithAttributes(
+			contentTypeAttribute.String(string(wf.contentType)),
+			frameSizeAttribute.Int(len(p)),
+		))
+		defer func() {
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+			span.End()
+		}()
+	}
+
+	wf.mux.Lock()
+	defer wf.mux.Unlock()
+
+	// Don't touch the underlying Writer, so that the frames written before stay intact
+	if wf.maxFrames > 0 && wf.frames >= wf.maxFrames {
+		return 0, fmt.Errorf("%w: can't write more than %d frames", ErrFrameCountExceeded, wf.maxFrames)
+	}
+
+	if n, err = wf.w.Write(p); err == nil {
+		wf.frames++
+	}
+	return
+}
+
+// FramesWritten returns the number of frames successfully written
+func (wf *frameWriter) FramesWritten() int {
+	wf.mux.Lock()
+	defer wf.mux.Unlock()
+
+	return wf.frames
+}
+
+// BytesWritten returns the number of bytes written to the underlying Writer
+func (wf *frameWriter) BytesWritten() int64 {
+	wf.mux.Lock()
+	defer wf.mux.Unlock()
+
+	return wf.counter.n
 }
 
 // ContentType returns the content type for the given FrameWriter
@@ -65,24 +289,26 @@ func (wf *frameWriter) ContentType() ContentType {
 }
 
 // newYAMLWriter returns a new yamlWriter implementation
-func newYAMLWriter(w Writer) *yamlWriter {
+func newYAMLWriter(w Writer, leadingSeparator bool) *yamlWriter {
 	return &yamlWriter{
-		w:          w,
-		hasWritten: false,
+		w:                w,
+		hasWritten:       false,
+		leadingSeparator: leadingSeparator,
 	}
 }
 
-// yamlWriter writes yamlSeparator between documents
+// yamlWriter writes yamlSeparator between documents, and optionally before the first one
 type yamlWriter struct {
-	w          io.Writer
-	hasWritten bool
+	w                io.Writer
+	hasWritten       bool
+	leadingSeparator bool
 }
 
 // Write implements io.Writer
 func (w *yamlWriter) Write(p []byte) (n int, err error) {
 	// If we've already written some documents, add the separator in between
-	if w.hasWritten {
-		_, err = w.w.Write([]byte(yamlSeparator))
+	if w.hasWritten || w.leadingSeparator {
+		_, err = w.w.Write(yamlSeparator)
 		if err != nil {
 			return
 		}
@@ -99,6 +325,40 @@ func (w *yamlWriter) Write(p []byte) (n int, err error) {
 	return
 }
 
+// ndjsonWriter writes every frame as one line
+type ndjsonWriter struct {
+	w io.Writer
+}
+
+// Write implements io.Writer
+func (w *ndjsonWriter) Write(p []byte) (n int, err error) {
+	line := getBuffer()
+	defer putBuffer(line)
+	if err = json.Compact(line, p); err != nil {
+		return
+	}
+	line.WriteByte('\n')
+
+	if _, err = w.w.Write(line.Bytes()); err != nil {
+		return
+	}
+	// All of p has been written, although compacted
+	return len(p), nil
+}
+
+// countingWriter counts the bytes written to the underlying Writer
+type countingWriter struct {
+	w Writer
+	n int64
+}
+
+// Write implements io.Writer
+func (w *countingWriter) Write(p []byte) (n int, err error) {
+	n, err = w.w.Write(p)
+	w.n += int64(n)
+	return
+}
+
 // ToBytes returns a Writer which can be passed to NewFrameWriter. The Writer writes directly
 // to an underlying byte array. The byte array must be of enough length in order to write.
 func ToBytes(p []byte) Writer {
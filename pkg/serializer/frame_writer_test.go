@@ -2,7 +2,18 @@ package serializer
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"reflect"
 	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func Test_byteWriter_Write(t *testing.T) {
@@ -64,3 +75,232 @@ func Test_byteWriter_Write(t *testing.T) {
 		})
 	}
 }
+
+func TestNDJSONFrames(t *testing.T) {
+	frames := FrameList{
+		[]byte(`{"kind":"Foo","apiVersion":"bar/v1"}`),
+		[]byte("{\n  \"kind\": \"Bar\",\n  \"apiVersion\": \"foo/v1\"\n}\n"),
+		[]byte(`{"description":"multiple\nlines"}`),
+	}
+	expected := "{\"kind\":\"Foo\",\"apiVersion\":\"bar/v1\"}\n" +
+		"{\"kind\":\"Bar\",\"apiVersion\":\"foo/v1\"}\n" +
+		"{\"description\":\"multiple\\nlines\"}\n"
+
+	var buf bytes.Buffer
+	if err := WriteFrameList(NewNDJSONFrameWriter(&buf), frames); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, buf.String())
+	}
+
+	// Pretty-printed objects and empty lines are read as well
+	for _, content := range []string{expected, "\n" + string(frames[0]) + "\n\n" + string(frames[1]) + string(frames[2])} {
+		got, err := ReadFrameList(NewNDJSONFrameReader(FromBytes([]byte(content))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(frames) {
+			t.Fatalf("expected %d frames, got %d", len(frames), len(got))
+		}
+		if !bytes.Contains(got[2], []byte(`"multiple\nlines"`)) {
+			t.Errorf("expected the embedded newline to round-trip, got %q", got[2])
+		}
+	}
+
+	if _, err := NewNDJSONFrameWriter(&buf).Write([]byte("kind: Foo")); err == nil {
+		t.Error("expected an error when writing a non-JSON frame")
+	}
+}
+
+func TestYAMLFrameWriterSeparators(t *testing.T) {
+	tests := []struct {
+		name     string
+		frames   FrameList
+		fns      []FrameWriterOptionsFunc
+		expected string
+	}{
+		{
+			name:     "one document",
+			frames:   FrameList{[]byte("a: b\n")},
+			expected: "a: b\n",
+		},
+		{
+			name:     "three documents",
+			frames:   FrameList{[]byte("a: b\n"), []byte("c: d\n"), []byte("e: f\n")},
+			expected: "a: b\n---\nc: d\n---\ne: f\n",
+		},
+		{
+			name:     "one document with leading separator",
+			frames:   FrameList{[]byte("a: b\n")},
+			fns:      []FrameWriterOptionsFunc{WithLeadingSeparator(true)},
+			expected: "---\na: b\n",
+		},
+		{
+			name:     "three documents with leading separator",
+			frames:   FrameList{[]byte("a: b\n"), []byte("c: d\n"), []byte("e: f\n")},
+			fns:      []FrameWriterOptionsFunc{WithLeadingSeparator(true)},
+			expected: "---\na: b\n---\nc: d\n---\ne: f\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteFrameList(NewYAMLFrameWriter(&buf, tt.fns...), tt.frames); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, buf.String())
+			}
+		})
+	}
+}
+
+func TestFrameWriterCounters(t *testing.T) {
+	frames := FrameList{[]byte("a: b\n"), []byte("c: d\n"), []byte("e: f\n")}
+	for _, ct := range []ContentType{ContentTypeYAML, ContentTypeJSON} {
+		var buf bytes.Buffer
+		fw := NewFrameWriter(ct, &buf)
+		if err := WriteFrameList(fw, frames); err != nil {
+			t.Fatal(err)
+		}
+		if fw.FramesWritten() != len(frames) {
+			t.Errorf("%s: expected %d frames written, got %d", ct, len(frames), fw.FramesWritten())
+		}
+		if fw.BytesWritten() != int64(buf.Len()) {
+			t.Errorf("%s: expected %d bytes written, got %d", ct, buf.Len(), fw.BytesWritten())
+		}
+	}
+
+	// Failed writes aren't counted as frames
+	fw := NewNDJSONFrameWriter(&bytes.Buffer{})
+	if _, err := fw.Write([]byte("not json")); err == nil {
+		t.Fatal("expected an error")
+	}
+	if fw.FramesWritten() != 0 || fw.BytesWritten() != 0 {
+		t.Errorf("expected no frames and bytes written, got %d and %d", fw.FramesWritten(), fw.BytesWritten())
+	}
+}
+
+func TestFrameWriterMaxFrames(t *testing.T) {
+	frames := FrameList{[]byte("a: b\n"), []byte("c: d\n"), []byte("e: f\n")}
+	for _, maxFrames := range []int{1, 2} {
+		var buf bytes.Buffer
+		fw := NewYAMLFrameWriter(&buf, WithMaxFrames(maxFrames))
+		if err := WriteFrameList(fw, frames[:maxFrames]); err != nil {
+			t.Fatal(err)
+		}
+		expected := buf.String()
+
+		if _, err := fw.Write(frames[maxFrames]); !errors.Is(err, ErrFrameCountExceeded) {
+			t.Errorf("expected ErrFrameCountExceeded, got %v", err)
+		}
+		if fw.FramesWritten() != maxFrames || buf.String() != expected {
+			t.Errorf("expected exactly %d frames to be written, got %d: %q", maxFrames, fw.FramesWritten(), buf.String())
+		}
+	}
+}
+
+func TestWithFrameWriterOptions(t *testing.T) {
+	// Only the set fields replace the current options
+	maxFrames := 1
+	opts := newFrameWriterOpts(WithLeadingSeparator(true), WithFrameWriterOptions(FrameWriterOptions{MaxFrames: &maxFrames}))
+	if !*opts.LeadingSeparator || *opts.MaxFrames != 1 || opts.Tracer == nil {
+		t.Errorf("expected the leading separator, one frame and the default tracer, got %v, %d and %v", *opts.LeadingSeparator, *opts.MaxFrames, opts.Tracer)
+	}
+
+	var buf bytes.Buffer
+	fw := NewFrameWriter(ContentTypeYAML, &buf, WithFrameWriterOptions(FrameWriterOptions{MaxFrames: &maxFrames}))
+	if err := WriteFrameList(fw, FrameList{[]byte("a: b\n")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fw.Write([]byte("c: d\n")); !errors.Is(err, ErrFrameCountExceeded) {
+		t.Errorf("expected ErrFrameCountExceeded, got %v", err)
+	}
+	if buf.String() != "a: b\n" {
+		t.Errorf("expected a single frame without a leading separator, got %q", buf.String())
+	}
+}
+
+func TestFrameWriterTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer func() { _ = tp.Shutdown(context.Background()) }()
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "encode")
+	defer parent.End()
+
+	frames := FrameList{[]byte("a: b\n"), []byte("c: d\n"), []byte("e: f\n")}
+	var buf bytes.Buffer
+	fw := NewYAMLFrameWriter(&buf, WithTracer(tp.Tracer("test")), WithTraceContext(ctx), WithMaxFrames(len(frames)))
+	if err := WriteFrameList(fw, frames); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fw.Write(frames[0]); !errors.Is(err, ErrFrameCountExceeded) {
+		t.Fatalf("expected ErrFrameCountExceeded, got %v", err)
+	}
+
+	// One span per frame, including the failed one
+	spans := exporter.GetSpans()
+	if len(spans) != len(frames)+1 {
+		t.Fatalf("expected %d spans, got %d", len(frames)+1, len(spans))
+	}
+	for i, span := range spans {
+		if span.Name != "FrameWriter.Write" || span.Parent.SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("expected a FrameWriter.Write span as child of the context, got %q", span.Name)
+		}
+		expected := []attribute.KeyValue{contentTypeAttribute.String("application/yaml"), frameSizeAttribute.Int(5)}
+		if !reflect.DeepEqual(span.Attributes, expected) {
+			t.Errorf("expected the attributes %v, got %v", expected, span.Attributes)
+		}
+		if failed := span.Status.Code == codes.Error; failed != (i == len(frames)) {
+			t.Errorf("frame %d: unexpected status %v", i, span.Status)
+		}
+	}
+
+	// No spans are created when the tracing is disabled
+	exporter.Reset()
+	otel.SetTracerProvider(tp)
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+	if err := WriteFrameList(NewYAMLFrameWriter(&buf, WithoutTracing()), frames); err != nil {
+		t.Fatal(err)
+	}
+	if spans := exporter.GetSpans(); len(spans) != 0 {
+		t.Errorf("expected no spans, got %d", len(spans))
+	}
+
+	// By default, the global TracerProvider is used
+	if err := WriteFrameList(NewYAMLFrameWriter(&buf), frames); err != nil {
+		t.Fatal(err)
+	}
+	if spans := exporter.GetSpans(); len(spans) != len(frames) {
+		t.Errorf("expected %d spans, got %d", len(frames), len(spans))
+	}
+}
+
+// BenchmarkFrameWriter shows the overhead of the per-frame tracing, e.g. using
+// go test -run=^$ -bench=FrameWriter -benchmem ./pkg/serializer
+func BenchmarkFrameWriter(b *testing.B) {
+	frame := []byte("apiVersion: sample-app.weave.works/v1alpha1\nkind: Car\nmetadata:\n  name: foo\n")
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(tracetest.NewNoopExporter()))
+	defer func() { _ = tp.Shutdown(context.Background()) }()
+
+	for _, bt := range []struct {
+		name string
+		fns  []FrameWriterOptionsFunc
+	}{
+		{"WithoutTracing", []FrameWriterOptionsFunc{WithoutTracing()}},
+		{"NoopTracer", []FrameWriterOptionsFunc{WithTracer(trace.NewNoopTracerProvider().Tracer(""))}},
+		{"SDKTracer", []FrameWriterOptionsFunc{WithTracer(tp.Tracer(""))}},
+	} {
+		b.Run(bt.name, func(b *testing.B) {
+			fw := NewYAMLFrameWriter(ioutil.Discard, bt.fns...)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := fw.Write(frame); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package serializer
+
+import (
+	"strings"
+
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// compactSequenceIndent writes the block sequences that are values of mapping keys at the
+// indentation of the key, like sigs.k8s.io/yaml and older releases of gopkg.in/yaml.v3 do.
+// Newer releases of gopkg.in/yaml.v3 indent these sequences, which would make the YAML written
+// with preserved comments depend on the release the application ends up building with. YAML
+// that already uses compact sequences is returned unchanged.
+func compactSequenceIndent(doc string) string {
+	node, err := yaml.Parse(doc)
+	if err != nil {
+		// The YAML was just written by the encoder, so this shouldn't happen. Keep it as is.
+		return doc
+	}
+
+	lines := strings.SplitAfter(doc, "\n")
+	// shifts contains the number of columns to remove from the start of each line
+	shifts := make([]int, len(lines))
+	findIndentedSequences(node.YNode(), len(lines)+1, func(seq *yaml.Node, end int) {
+		// Only the lines at least as indented as the sequence belong to it, the rest
+		// are e.g. head comments of the next key of the mapping
+		for line := seq.Line; line < end && line <= len(lines); line++ {
+			if indentation(lines[line-1]) >= seq.Column-1 {
+				shifts[line-1] += 2
+			}
+		}
+	})
+
+	var sb strings.Builder
+	sb.Grow(len(doc))
+	for i, line := range lines {
+		if shift := shifts[i]; shift > 0 && indentation(line) >= shift {
+			line = line[shift:]
+		}
+		sb.WriteString(line)
+	}
+	return sb.String()
+}
+
+// findIndentedSequences calls fn for every block sequence in the tree of node that is indented
+// relative to the mapping key it's the value of. The tree must have been parsed, so that the
+// nodes have positions. end is the line where the content of node ends, exclusively.
+func findIndentedSequences(node *yaml.Node, end int, fn func(seq *yaml.Node, end int)) {
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for i, child := range node.Content {
+			childEnd := end
+			if i+1 < len(node.Content) {
+				childEnd = node.Content[i+1].Line
+			}
+			findIndentedSequences(child, childEnd, fn)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			valueEnd := end
+			if i+2 < len(node.Content) {
+				valueEnd = node.Content[i+2].Line
+			}
+			if value.Kind == yaml.SequenceNode && value.Style&yaml.FlowStyle == 0 &&
+				len(value.Content) != 0 && value.Column > key.Column {
+				fn(value, valueEnd)
+			}
+			findIndentedSequences(value, valueEnd, fn)
+		}
+	}
+}
+
+// indentation returns the number of spaces the given line starts with
+func indentation(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
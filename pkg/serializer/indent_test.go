@@ -0,0 +1,69 @@
+package serializer
+
+import (
+	"testing"
+)
+
+func TestCompactSequenceIndent(t *testing.T) {
+	// compact is written by sigs.k8s.io/yaml and older releases of gopkg.in/yaml.v3
+	compact := `kind: Test
+spec:
+  # Head comment
+  data:
+  - field # Inline comment
+  - another:
+      subthing: "yes"
+    list:
+    - a
+    - - b
+      - c
+  # Head comment of the next key
+  thing:
+    script: |
+      if true:
+        - not a sequence
+    empty: []
+items:
+- name: foo
+  values:
+  - 1
+
+  - 2
+`
+	// indented is the same YAML written by newer releases of gopkg.in/yaml.v3
+	indented := `kind: Test
+spec:
+  # Head comment
+  data:
+    - field # Inline comment
+    - another:
+        subthing: "yes"
+      list:
+        - a
+        - - b
+          - c
+  # Head comment of the next key
+  thing:
+    script: |
+      if true:
+        - not a sequence
+    empty: []
+items:
+  - name: foo
+    values:
+      - 1
+
+      - 2
+`
+	if actual := compactSequenceIndent(indented); actual != compact {
+		t.Errorf("expected\n%s\ngot\n%s", compact, actual)
+	}
+	// Compact sequences are left as they are
+	if actual := compactSequenceIndent(compact); actual != compact {
+		t.Errorf("expected the YAML to be unchanged, got\n%s", actual)
+	}
+	// Invalid YAML is left as it is
+	if invalid := "a: [\n  - b\n"; compactSequenceIndent(invalid) != invalid {
+		t.Error("expected invalid YAML to be unchanged")
+	}
+}
@@ -0,0 +1,75 @@
+package serializer
+
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Operation describes what the Serializer did when a MetricsHook is invoked
+type Operation string
+
+const (
+	// OperationEncode describes that an object was encoded and written to a FrameWriter
+	OperationEncode Operation = "encode"
+	// OperationDecode describes that a document was read from a FrameReader and decoded
+	OperationDecode Operation = "decode"
+)
+
+// MetricsHook is invoked after every successfully encoded or decoded object with the operation,
+// the GroupKind of the object, the size of its document in bytes and the time the operation took.
+// The hook is called synchronously, hence it should be cheap, e.g. observe a histogram.
+type MetricsHook func(op Operation, gk schema.GroupKind, size int64, duration time.Duration)
+
+type SerializerOptions struct {
+	// MetricsHook, if set, is invoked for every object encoded or decoded by the
+	// Encoders and Decoders of the Serializer. (Default: nil)
+	MetricsHook MetricsHook
+}
+
+type SerializerOptionsFunc func(*SerializerOptions)
+
+func WithMetricsHook(hook MetricsHook) SerializerOptionsFunc {
+	return func(opts *SerializerOptions) {
+		opts.MetricsHook = hook
+	}
+}
+
+func defaultSerializerOpts() *SerializerOptions {
+	return &SerializerOptions{
+		MetricsHook: nil,
+	}
+}
+
+func newSerializerOpts(fns ...SerializerOptionsFunc) *SerializerOptions {
+	opts := defaultSerializerOpts()
+	for _, fn := range fns {
+		fn(opts)
+	}
+	return opts
+}
+
+// observe invokes the MetricsHook, if any, for the given object. start should be
+// taken using startObserving, which avoids reading the clock without a hook.
+func (s *schemeAndCodec) observe(op Operation, obj runtime.Object, size int64, start time.Time) {
+	if s.metrics == nil {
+		return
+	}
+
+	duration := time.Since(start)
+	// Decoded hub objects might not have TypeMeta set, hence ask the scheme
+	gvk, err := GVKForObject(s.scheme, obj)
+	if err != nil {
+		gvk = obj.GetObjectKind().GroupVersionKind()
+	}
+	s.metrics(op, gvk.GroupKind(), size, duration)
+}
+
+// startObserving returns the start time of an operation, or the zero time without a MetricsHook
+func (s *schemeAndCodec) startObserving() time.Time {
+	if s.metrics == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
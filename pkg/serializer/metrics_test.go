@@ -0,0 +1,66 @@
+package serializer
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	runtimetest "k8s.io/apimachinery/pkg/runtime/testing"
+)
+
+type observation struct {
+	op   Operation
+	gk   schema.GroupKind
+	size int64
+}
+
+func TestMetricsHook(t *testing.T) {
+	var observations []observation
+	s := NewSerializer(scheme, &codecs, WithMetricsHook(func(op Operation, gk schema.GroupKind, size int64, duration time.Duration) {
+		if duration <= 0 {
+			t.Errorf("expected a positive duration for %s of %s, got %v", op, gk, duration)
+		}
+		observations = append(observations, observation{op, gk, size})
+	}))
+	simpleGK := schema.GroupKind{Group: groupname, Kind: "Simple"}
+	complexGK := schema.GroupKind{Group: groupname, Kind: "Complex"}
+
+	// The sizes of the decoded documents are the sizes of the frames
+	frames, err := ReadFrameList(NewYAMLFrameReader(FromBytes(simpleAndComplex)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	objs, err := s.Decoder().DecodeAll(NewYAMLFrameReader(FromBytes(simpleAndComplex)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []observation{
+		{OperationDecode, simpleGK, int64(len(frames[0]))},
+		{OperationDecode, complexGK, int64(len(frames[1]))},
+	}
+	if !reflect.DeepEqual(observations, expected) {
+		t.Errorf("expected %v, got %v", expected, observations)
+	}
+
+	observations = nil
+	buf := new(bytes.Buffer)
+	if err := s.Encoder().Encode(NewFrameWriter(ContentTypeYAML, buf), objs[0]); err != nil {
+		t.Fatal(err)
+	}
+	expected = []observation{{OperationEncode, simpleGK, int64(buf.Len())}}
+	if !reflect.DeepEqual(observations, expected) {
+		t.Errorf("expected %v, got %v", expected, observations)
+	}
+
+	// Failed operations aren't observed
+	observations = nil
+	into := &runtimetest.InternalComplex{}
+	if err := s.Decoder().DecodeInto(NewYAMLFrameReader(FromBytes(oneSimple)), into); err == nil {
+		t.Error("expected decoding a Simple into a Complex to fail")
+	}
+	if len(observations) != 0 {
+		t.Errorf("expected no observations, got %v", observations)
+	}
+}
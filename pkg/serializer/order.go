@@ -0,0 +1,101 @@
+package serializer
+
+import (
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// applyNodeChanges applies the changes in the after tree onto the prior tree, in place. Fields that exist
+// in both trees keep their original position, formatting and comments from prior. Fields that don't exist
+// in after are removed from prior, and fields only present in after are appended in after's order. This
+// makes it possible to write back a changed object with as small of a diff as possible.
+func applyNodeChanges(prior, after *yaml.Node) {
+	// If the node kinds differ, the whole node has changed. Replace it, but keep the comments
+	if prior.Kind != after.Kind {
+		replaceNode(prior, after)
+		return
+	}
+
+	switch prior.Kind {
+	case yaml.MappingNode:
+		applyMappingChanges(prior, after)
+	case yaml.SequenceNode:
+		applySequenceChanges(prior, after)
+	case yaml.ScalarNode:
+		// Only touch the scalar if the value or type changed, this preserves e.g. the quoting style
+		if prior.Value != after.Value || prior.ShortTag() != after.ShortTag() {
+			prior.Value = after.Value
+			prior.Tag = after.Tag
+			prior.Style = after.Style
+		}
+	default:
+		replaceNode(prior, after)
+	}
+}
+
+// applyMappingChanges applies the changes of the after mapping node onto the prior mapping node
+func applyMappingChanges(prior, after *yaml.Node) {
+	content := make([]*yaml.Node, 0, len(after.Content))
+	// Keep the fields that exist in both trees in the prior order, and apply the changes recursively
+	for i := 0; i+1 < len(prior.Content); i += 2 {
+		key, val := prior.Content[i], prior.Content[i+1]
+		afterVal := mappingValue(after, key.Value)
+		if afterVal == nil {
+			// The field was removed
+			continue
+		}
+
+		applyNodeChanges(val, afterVal)
+		content = append(content, key, val)
+	}
+
+	// Append the fields that were added, in the order they are in the after tree
+	for i := 0; i+1 < len(after.Content); i += 2 {
+		if mappingValue(prior, after.Content[i].Value) == nil {
+			content = append(content, after.Content[i], after.Content[i+1])
+		}
+	}
+
+	prior.Content = content
+}
+
+// applySequenceChanges applies the changes of the after sequence node onto the prior sequence node.
+// The items are matched by index.
+func applySequenceChanges(prior, after *yaml.Node) {
+	for i := 0; i < len(prior.Content) && i < len(after.Content); i++ {
+		applyNodeChanges(prior.Content[i], after.Content[i])
+	}
+
+	if len(after.Content) < len(prior.Content) {
+		// Items were removed from the end of the list
+		prior.Content = prior.Content[:len(after.Content)]
+	} else {
+		// Items were added to the end of the list
+		prior.Content = append(prior.Content, after.Content[len(prior.Content):]...)
+	}
+}
+
+// mappingValue returns the value node for the given key in the mapping node, or nil if it doesn't exist
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// replaceNode replaces the contents of prior with after, but keeps prior's comments if after has none
+func replaceNode(prior, after *yaml.Node) {
+	head, line, foot := prior.HeadComment, prior.LineComment, prior.FootComment
+	*prior = *after
+
+	if len(prior.HeadComment) == 0 {
+		prior.HeadComment = head
+	}
+	if len(prior.LineComment) == 0 {
+		prior.LineComment = line
+	}
+	if len(prior.FootComment) == 0 {
+		prior.FootComment = foot
+	}
+}
@@ -0,0 +1,34 @@
+package serializer
+
+import (
+	"bytes"
+	"sync"
+)
+
+// maxPooledBufferSize is the maximum capacity of the buffers put back into bufferPool, so
+// that a few huge objects don't pin their memory for the lifetime of the process
+const maxPooledBufferSize = 1 << 20
+
+// bufferPool holds the intermediate buffers objects are encoded into before being processed
+// further, e.g. for preserving comments, which are reused to avoid allocating them per object
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// getBuffer returns an empty buffer from bufferPool, which must be returned using putBuffer
+// once its content isn't referenced anymore
+func getBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+// putBuffer returns the given buffer to bufferPool
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBufferSize {
+		return
+	}
+	bufferPool.Put(buf)
+}
@@ -0,0 +1,131 @@
+package serializer
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/go-openapi/validate"
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	structuralschema "k8s.io/apiextensions-apiserver/pkg/apiserver/schema"
+	"k8s.io/apiextensions-apiserver/pkg/apiserver/validation"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/yaml"
+)
+
+// WithStructuralSchema validates all decoded objects of the given GroupKind against the given
+// OpenAPI v3 schema, the same way as the API server validates custom resources. The schema must
+// be structural. Can be given multiple times for different GroupKinds.
+func WithStructuralSchema(gk schema.GroupKind, s *apiextensionsv1.JSONSchemaProps) DecodingOptionsFunc {
+	return func(opts *DecodingOptions) {
+		schemas := make(map[schema.GroupKind]*apiextensionsv1.JSONSchemaProps, len(opts.StructuralSchemas)+1)
+		for existingGK, existingSchema := range opts.StructuralSchemas {
+			schemas[existingGK] = existingSchema
+		}
+		schemas[gk] = s
+		opts.StructuralSchemas = schemas
+	}
+}
+
+// NewSchemaValidationError returns information about which fields of the decoded document
+// are not valid according to the structural schema of its GroupKind
+func NewSchemaValidationError(gvk schema.GroupVersionKind, errs field.ErrorList) *SchemaValidationError {
+	return &SchemaValidationError{
+		GVK:    gvk,
+		Errors: errs,
+	}
+}
+
+// SchemaValidationError describes that the decoded document didn't validate against the
+// structural schema registered for its GroupKind using WithStructuralSchema
+type SchemaValidationError struct {
+	GVK    schema.GroupVersionKind
+	Errors field.ErrorList
+}
+
+// Error implements the error interface
+func (e *SchemaValidationError) Error() string {
+	return fmt.Sprintf("validation of %s failed: %v", e.GVK, e.Errors.ToAggregate())
+}
+
+// GroupVersionKind returns the GroupVersionKind for the error
+func (e *SchemaValidationError) GroupVersionKind() schema.GroupVersionKind {
+	return e.GVK
+}
+
+// schemaValidators lazily compiles and caches the validators of the structural schemas
+type schemaValidators struct {
+	schemas    map[schema.GroupKind]*apiextensionsv1.JSONSchemaProps
+	validators map[schema.GroupKind]*validate.SchemaValidator
+	mux        sync.Mutex
+}
+
+func newSchemaValidators(schemas map[schema.GroupKind]*apiextensionsv1.JSONSchemaProps) *schemaValidators {
+	return &schemaValidators{
+		schemas:    schemas,
+		validators: make(map[schema.GroupKind]*validate.SchemaValidator, len(schemas)),
+	}
+}
+
+// validate validates the given YAML or JSON document against the structural schema of its kind.
+// The raw document is validated, as decoding it into a struct might coerce invalid values or fail
+// without pointing out the field. If no schema is registered for the kind, this is a no-op.
+func (v *schemaValidators) validate(doc []byte) error {
+	if len(v.schemas) == 0 {
+		return nil
+	}
+	// Documents without TypeMeta are left for the decoder to report
+	gvk, err := extractYAMLTypeMeta(doc)
+	if err != nil {
+		return nil
+	}
+
+	validator, err := v.validatorFor(gvk.GroupKind())
+	if validator == nil || err != nil {
+		return err
+	}
+
+	var content interface{}
+	if err := yaml.Unmarshal(doc, &content); err != nil {
+		return err
+	}
+
+	if errs := validation.ValidateCustomResource(nil, content, validator); len(errs) != 0 {
+		return NewSchemaValidationError(*gvk, errs)
+	}
+	return nil
+}
+
+// validatorFor returns the compiled validator for the given GroupKind, or nil if it has no schema
+func (v *schemaValidators) validatorFor(gk schema.GroupKind) (*validate.SchemaValidator, error) {
+	v.mux.Lock()
+	defer v.mux.Unlock()
+
+	if validator, ok := v.validators[gk]; ok {
+		return validator, nil
+	}
+	s, ok := v.schemas[gk]
+	if !ok || s == nil {
+		return nil, nil
+	}
+
+	internal := &apiextensions.JSONSchemaProps{}
+	if err := apiextensionsv1.Convert_v1_JSONSchemaProps_To_apiextensions_JSONSchemaProps(s, internal, nil); err != nil {
+		return nil, fmt.Errorf("failed to convert the schema of %s: %w", gk, err)
+	}
+	structural, err := structuralschema.NewStructural(internal)
+	if err != nil {
+		return nil, fmt.Errorf("the schema of %s is not structural: %w", gk, err)
+	}
+	if errs := structuralschema.ValidateStructural(nil, structural); len(errs) != 0 {
+		return nil, fmt.Errorf("the schema of %s is not structural: %v", gk, errs.ToAggregate())
+	}
+
+	validator, _, err := validation.NewSchemaValidator(&apiextensions.CustomResourceValidation{OpenAPIV3Schema: internal})
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile the schema of %s: %w", gk, err)
+	}
+	v.validators[gk] = validator
+	return validator, nil
+}
@@ -0,0 +1,93 @@
+package serializer_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/scheme"
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/weaveworks/libgitops/pkg/serializer"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+var carSchema = &apiextensionsv1.JSONSchemaProps{
+	Type: "object",
+	Properties: map[string]apiextensionsv1.JSONSchemaProps{
+		"spec": {
+			Type: "object",
+			Properties: map[string]apiextensionsv1.JSONSchemaProps{
+				"brand": {
+					Type: "string",
+					Enum: []apiextensionsv1.JSON{{Raw: []byte(`"Volvo"`)}, {Raw: []byte(`"Saab"`)}},
+				},
+			},
+		},
+		"status": {
+			Type: "object",
+			Properties: map[string]apiextensionsv1.JSONSchemaProps{
+				"persons": {Type: "integer", Minimum: float64Ptr(1)},
+			},
+		},
+	},
+}
+
+func float64Ptr(f float64) *float64 {
+	return &f
+}
+
+func TestStructuralSchema(t *testing.T) {
+	carGK := v1alpha1.SchemeGroupVersion.WithKind("Car").GroupKind()
+
+	decode := func(spec, status string) error {
+		doc := "apiVersion: sample-app.weave.works/v1alpha1\nkind: Car\nmetadata:\n  name: foo\nspec:\n  " + spec + "\nstatus:\n  " + status + "\n"
+		_, err := scheme.Serializer.Decoder(serializer.WithStructuralSchema(carGK, carSchema)).
+			Decode(serializer.NewYAMLFrameReader(serializer.FromBytes([]byte(doc))))
+		return err
+	}
+
+	for _, tt := range []struct {
+		name   string
+		spec   string
+		status string
+		fields []string
+	}{
+		{"valid", "brand: Volvo", "persons: 4", nil},
+		{"enum", "brand: Trabant", "persons: 4", []string{"spec.brand"}},
+		{"minimum", "brand: Saab", "persons: 0", []string{"status.persons"}},
+		// Struct decoding would fail without pointing out the field
+		{"type", "brand: Saab", "persons: 1.5", []string{"status.persons"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := decode(tt.spec, tt.status)
+			if tt.fields == nil {
+				if err != nil {
+					t.Fatalf("expected the Car to be valid, got %v", err)
+				}
+				return
+			}
+
+			var validationErr *serializer.SchemaValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("expected a SchemaValidationError, got %v", err)
+			}
+			var fields []string
+			for _, fieldErr := range validationErr.Errors {
+				fields = append(fields, fieldErr.Field)
+			}
+			if len(fields) != len(tt.fields) || fields[0] != tt.fields[0] {
+				t.Errorf("expected errors for %v, got %v", tt.fields, validationErr.Errors)
+			}
+			if validationErr.Errors[0].Type != field.ErrorTypeNotSupported && validationErr.Errors[0].Type != field.ErrorTypeInvalid {
+				t.Errorf("unexpected error type %s", validationErr.Errors[0].Type)
+			}
+		})
+	}
+
+	// Other kinds are not validated
+	motorcycle := "apiVersion: sample-app.weave.works/v1alpha1\nkind: Motorcycle\nmetadata:\n  name: bike\n"
+	if _, err := scheme.Serializer.Decoder(serializer.WithStructuralSchema(carGK, carSchema)).
+		Decode(serializer.NewYAMLFrameReader(serializer.FromBytes([]byte(motorcycle)))); err != nil {
+		t.Errorf("expected the Motorcycle not to be validated, got %v", err)
+	}
+}
@@ -3,6 +3,7 @@ package serializer
 import (
 	"errors"
 	"fmt"
+	"sync"
 
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
@@ -20,6 +21,14 @@ const (
 	// ContentTypeYAML specifies usage of YAML as the content type.
 	// It is an alias for k8s.io/apimachinery/pkg/runtime.ContentTypeYAML
 	ContentTypeYAML = ContentType(runtime.ContentTypeYAML)
+
+	// ContentTypeCBOR specifies usage of CBOR (RFC 8949) as the content type. CBOR documents are
+	// converted from and to JSON when decoding and encoding, and are written deterministically.
+	ContentTypeCBOR = ContentType("application/cbor")
+
+	// ContentTypeTable specifies human-readable tables, as written by the TableEncoder.
+	// Tables can only be written, they can't be decoded.
+	ContentTypeTable = ContentType("text/plain")
 )
 
 // ErrUnsupportedContentType is returned if the specified content type isn't supported
@@ -60,11 +69,20 @@ type Serializer interface {
 	// Codecs provides access to the underlying serializer.CodecFactory, may be used if low-level access
 	// is needed for encoding and decoding
 	Codecs() *k8sserializer.CodecFactory
+
+	// Diff encodes both objects and returns the differences between them, both as a unified
+	// diff of their canonical YAML encodings and as a list of changed JSON paths. Field ordering
+	// is normalized before diffing, so reordered fields don't show up as changes.
+	Diff(a, b runtime.Object) (*ObjectDiff, error)
 }
 
 type schemeAndCodec struct {
-	scheme *runtime.Scheme
-	codecs *k8sserializer.CodecFactory
+	scheme  *runtime.Scheme
+	codecs  *k8sserializer.CodecFactory
+	metrics MetricsHook
+	// versionEncoders caches the version-specific encoders by their versionEncoderKey, as
+	// they are stateless, but expensive to create for every encoded object
+	versionEncoders sync.Map
 }
 
 // Encoder is a high-level interface for encoding Kubernetes API Machinery objects and writing them
@@ -80,6 +98,16 @@ type Encoder interface {
 	// is not of that version currently it will try to convert. The output bytes are written to the
 	// FrameWriter. The FrameWriter specifies the ContentType.
 	EncodeForGroupVersion(fw FrameWriter, obj runtime.Object, gv schema.GroupVersion) error
+
+	// EncodeCanonical encodes the given objects like Encode, but writes them in a deterministic
+	// form to the FrameWriter: map keys are sorted, null and empty fields are omitted, and numbers
+	// are formatted consistently. Semantically equal objects hence always produce the same bytes.
+	// Comments aren't preserved, and JSON is always written compactly. See Canonicalize.
+	EncodeCanonical(fw FrameWriter, obj ...runtime.Object) error
+
+	// EncodeList wraps the given objects into a v1.List, and writes it as one document to the
+	// FrameWriter. The items are converted like in Encode, and may be of different kinds.
+	EncodeList(fw FrameWriter, obj ...runtime.Object) error
 }
 
 // Decoder is a high-level interface for decoding Kubernetes API Machinery objects read from
@@ -95,7 +123,7 @@ type Decoder interface {
 	// If opts.Default is true, the decoded object will be defaulted.
 	// If opts.Strict is true, the YAML/JSON will be parsed in strict mode, returning a specific error
 	// 	if the input contains duplicate or unknown fields or formatting errors. You can check whether
-	// 	a returned failed because of the strictness using k8s.io/apimachinery/pkg/runtime.IsStrictDecodingError.
+	// 	a returned failed because of the strictness using IsStrictDecodingError.
 	// If opts.ConvertToHub is true, the decoded external object will be converted into its internal representation.
 	// 	Otherwise, the decoded object will be left in the external representation.
 	// If opts.DecodeUnknown is true, any type with an unrecognized apiVersion/kind will be returned as a
@@ -114,7 +142,7 @@ type Decoder interface {
 	// If opts.Default is true, the decoded object will be defaulted.
 	// If opts.Strict is true, the YAML/JSON will be parsed in strict mode, returning a specific error
 	// 	if the input contains duplicate or unknown fields or formatting errors. You can check whether
-	// 	a returned failed because of the strictness using k8s.io/apimachinery/pkg/runtime.IsStrictDecodingError.
+	// 	a returned failed because of the strictness using IsStrictDecodingError.
 	// opts.DecodeListElements is not applicable in this call.
 	// opts.ConvertToHub is not applicable in this call.
 	// opts.DecodeUnknown is not applicable in this call. In case you want to decode an object into a
@@ -129,15 +157,24 @@ type Decoder interface {
 	// If opts.Default is true, the decoded objects will be defaulted.
 	// If opts.Strict is true, the YAML/JSON will be parsed in strict mode, returning a specific error
 	// 	if the input contains duplicate or unknown fields or formatting errors. You can check whether
-	// 	a returned failed because of the strictness using k8s.io/apimachinery/pkg/runtime.IsStrictDecodingError.
+	// 	a returned failed because of the strictness using IsStrictDecodingError.
 	// If opts.ConvertToHub is true, the decoded external object will be converted into their internal representation.
 	// 	Otherwise, the decoded objects will be left in their external representation.
 	// If opts.DecodeListElements is true and the underlying data contains a v1.List,
 	// 	the items of the list will be traversed and decoded into their respective types, which are
-	// 	added into the returning slice. The v1.List will in this case not be returned.
+	// 	added into the returning slice. The v1.List will in this case not be returned. Lists nested
+	// 	in the v1.List are flattened recursively.
 	// If opts.DecodeUnknown is true, any type with an unrecognized apiVersion/kind will be returned as a
 	// 	*runtime.Unknown object instead of returning a UnrecognizedTypeError.
 	DecodeAll(fr FrameReader) ([]runtime.Object, error)
+
+	// DecodeEach decodes all documents in the FrameReader stream one-by-one, and passes each decoded
+	// object to fn. Contrary to DecodeAll, only one object is held in memory at a time. If fn returns
+	// an error, decoding stops and that error is returned. The underlying stream is automatically
+	// closed on io.EOF. io.EOF is never returned from this function.
+	// The options are applied per-document exactly in the same way as in DecodeAll, i.e. if
+	// 	opts.DecodeListElements is true, fn is called once per item in a v1.List.
+	DecodeEach(fr FrameReader, fn func(obj runtime.Object) error) error
 }
 
 // Converter is an interface that allows access to object conversion capabilities
@@ -174,8 +211,9 @@ type Defaulter interface {
 	NewDefaultedObject(gvk schema.GroupVersionKind) (runtime.Object, error)
 }
 
-// NewSerializer constructs a new serializer based on a scheme, and optionally a codecfactory
-func NewSerializer(scheme *runtime.Scheme, codecs *k8sserializer.CodecFactory) Serializer {
+// NewSerializer constructs a new serializer based on a scheme, and optionally a codecfactory.
+// The serializer can be customized by passing some options (e.g. WithMetricsHook).
+func NewSerializer(scheme *runtime.Scheme, codecs *k8sserializer.CodecFactory, optFns ...SerializerOptionsFunc) Serializer {
 	if scheme == nil {
 		panic("scheme must not be nil")
 	}
@@ -186,10 +224,13 @@ func NewSerializer(scheme *runtime.Scheme, codecs *k8sserializer.CodecFactory) S
 		*codecs = k8sserializer.NewCodecFactory(scheme)
 	}
 
+	opts := newSerializerOpts(optFns...)
+
 	return &serializer{
 		schemeAndCodec: &schemeAndCodec{
-			scheme: scheme,
-			codecs: codecs,
+			scheme:  scheme,
+			codecs:  codecs,
+			metrics: opts.MetricsHook,
 		},
 		converter: newConverter(scheme),
 		defaulter: newDefaulter(scheme),
@@ -2,7 +2,9 @@ package serializer
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"reflect"
 	"strings"
 	"testing"
@@ -298,6 +300,26 @@ items:
   testString: bar
 `)
 
+	testNestedList = []byte(`apiVersion: v1
+kind: List
+items:
+- apiVersion: foogroup/v1alpha1
+  kind: Simple
+  testString: foo
+- apiVersion: v1
+  kind: List
+  items:
+  - apiVersion: foogroup/v1alpha1
+    kind: Complex
+    int: 5
+  - apiVersion: v1
+    kind: List
+    items:
+    - apiVersion: foogroup/v1alpha1
+      kind: Simple
+      testString: bar
+`)
+
 	simpleJSON = []byte(`{"apiVersion":"foogroup/v1alpha1","kind":"Simple","testString":"foo"}
 `)
 	complexJSON = []byte(`{"apiVersion":"foogroup/v1alpha1","kind":"Complex","string":"bar","int":0,"Int64":0,"bool":false}
@@ -415,6 +437,55 @@ func TestDecode(t *testing.T) {
 	}
 }
 
+func TestStrictDecode(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        []byte
+		strict      bool
+		expected    runtime.Object
+		expectedErr string
+	}{
+		{"unknown field", simpleUnknownField, true, nil,
+			"strict decoding of foogroup/v1alpha1, Kind=Simple failed: unknown fields: unknownField"},
+		{"duplicate field", simpleDuplicateField, true, nil,
+			"strict decoding of foogroup/v1alpha1, Kind=Simple failed: duplicate fields: testString"},
+		{"multiple unknown nested fields", []byte(`apiVersion: foogroup/v1alpha1
+kind: CRD
+metadata:
+  name: foo
+  nmae: foo
+testString: foo
+testStrng: bar
+`), true, nil,
+			"strict decoding of foogroup/v1alpha1, Kind=CRD failed: unknown fields: metadata.nmae, testStrng"},
+		{"lenient unknown field", simpleUnknownField, false,
+			&runtimetest.ExternalSimple{TypeMeta: simpleMeta, TestString: "foo"}, ""},
+	}
+
+	for _, rt := range tests {
+		t.Run(rt.name, func(t2 *testing.T) {
+			obj, err := ourserializer.Decoder(
+				WithStrictDecode(rt.strict),
+			).Decode(NewYAMLFrameReader(FromBytes(rt.data)))
+			if len(rt.expectedErr) != 0 {
+				if err == nil || err.Error() != rt.expectedErr {
+					t2.Fatalf("expected error %q but actual %v", rt.expectedErr, err)
+				}
+				if !IsStrictDecodingError(err) {
+					t2.Errorf("expected a strict decoding error, got %T", err)
+				}
+				return
+			}
+			if err != nil {
+				t2.Fatal(err)
+			}
+			if !reflect.DeepEqual(obj, rt.expected) {
+				t2.Errorf("expected %#v but actual %#v", rt.expected, obj)
+			}
+		})
+	}
+}
+
 func TestDecodeInto(t *testing.T) {
 	// Also test Defaulting & Conversion
 	tests := []struct {
@@ -470,6 +541,11 @@ func TestDecodeAll(t *testing.T) {
 			&runtimetest.ExternalComplex{TypeMeta: complexv1Meta, Integer: 5},
 			&runtimetest.ExternalSimple{TypeMeta: simpleMeta, TestString: "bar"},
 		}, false},
+		{"nested list split decoding", testNestedList, false, true, []runtime.Object{
+			&runtimetest.ExternalSimple{TypeMeta: simpleMeta, TestString: "foo"},
+			&runtimetest.ExternalComplex{TypeMeta: complexv1Meta, Integer: 5},
+			&runtimetest.ExternalSimple{TypeMeta: simpleMeta, TestString: "bar"},
+		}, false},
 		/*{"simple internal", oneSimple, false, &runtimetest.InternalSimple{}, &runtimetest.InternalSimple{TestString: "foo"}, false},
 		{"complex internal", oneComplex, false, &runtimetest.InternalComplex{}, &runtimetest.InternalComplex{String: "bar"}, false},
 		{"simple external", oneSimple, false, &runtimetest.ExternalSimple{}, &runtimetest.ExternalSimple{TypeMeta: simpleMeta, TestString: "foo"}, false},
@@ -490,6 +566,9 @@ func TestDecodeAll(t *testing.T) {
 			if (actual != nil) != rt.expectedErr {
 				t2.Errorf("expected error %t but actual %t: %v", rt.expectedErr, actual != nil, actual)
 			}
+			if len(objs) != len(rt.expected) {
+				t2.Fatalf("expected %d objects, got %d", len(rt.expected), len(objs))
+			}
 			for i := range objs {
 				expected := rt.expected[i]
 				obj := objs[i]
@@ -502,6 +581,50 @@ func TestDecodeAll(t *testing.T) {
 	}
 }
 
+func TestDecodeEach(t *testing.T) {
+	expected := []runtime.Object{
+		&runtimetest.ExternalSimple{TypeMeta: simpleMeta, TestString: "foo"},
+		&runtimetest.ExternalComplex{TypeMeta: complexv1Meta, Integer: 5},
+		&runtimetest.ExternalSimple{TypeMeta: simpleMeta, TestString: "bar"},
+	}
+	errStop := errors.New("stop")
+
+	tests := []struct {
+		name        string
+		stopAfter   int
+		expectedErr error
+	}{
+		{"decode all list elements", -1, nil},
+		{"stop when the callback fails", 2, errStop},
+	}
+
+	for _, rt := range tests {
+		t.Run(rt.name, func(t2 *testing.T) {
+			objs := []runtime.Object{}
+			err := ourserializer.Decoder(
+				WithListElementsDecoding(true),
+			).DecodeEach(NewYAMLFrameReader(FromBytes(testList)), func(obj runtime.Object) error {
+				if len(objs) == rt.stopAfter {
+					return errStop
+				}
+				objs = append(objs, obj)
+				return nil
+			})
+			if err != rt.expectedErr {
+				t2.Errorf("expected error %v but actual %v", rt.expectedErr, err)
+			}
+
+			want := expected
+			if rt.stopAfter >= 0 {
+				want = expected[:rt.stopAfter]
+			}
+			if !reflect.DeepEqual(objs, want) {
+				t2.Errorf("expected %#v but actual %#v", want, objs)
+			}
+		})
+	}
+}
+
 func newUnknown(tm runtime.TypeMeta, raw []byte) *runtime.Unknown {
 	return &runtime.Unknown{
 		TypeMeta:        tm,
@@ -585,6 +708,81 @@ func TestRoundtrip(t *testing.T) {
 	}
 }
 
+func TestFieldOrderRoundtrip(t *testing.T) {
+	data := []byte(`# I'm a top comment
+kind: CRD
+apiVersion: "foogroup/v1alpha1"
+# Preserve me please!
+testString: foobar # Me too
+metadata:
+  creationTimestamp: null
+`)
+	expected := []byte(`# I'm a top comment
+kind: CRD
+apiVersion: "foogroup/v1alpha1"
+# Preserve me please!
+testString: changed # Me too
+metadata:
+  creationTimestamp: null
+`)
+
+	obj, err := ourserializer.Decoder(
+		WithCommentsDecode(true),
+	).Decode(NewYAMLFrameReader(FromBytes(data)))
+	if err != nil {
+		t.Fatalf("unexpected decode error: %v", err)
+	}
+	obj.(*CRDOldVersion).TestString = "changed"
+
+	buf := new(bytes.Buffer)
+	err = ourserializer.Encoder(
+		WithCommentsEncode(true),
+		WithFieldOrderEncode(true),
+	).Encode(NewYAMLFrameWriter(buf), obj)
+	if err != nil {
+		t.Fatalf("unexpected encode error: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("expected %q but actual %q", string(expected), buf.String())
+	}
+}
+
+func TestPartialEncodingOptions(t *testing.T) {
+	data := []byte(`# I'm a top comment
+kind: CRD
+apiVersion: foogroup/v1alpha1
+testString: foobar # Me too
+metadata:
+  creationTimestamp: null
+`)
+
+	obj, err := ourserializer.Decoder(
+		WithCommentsDecode(true),
+	).Decode(NewYAMLFrameReader(FromBytes(data)))
+	if err != nil {
+		t.Fatalf("unexpected decode error: %v", err)
+	}
+
+	// The fields left nil keep their defaults, instead of being dereferenced as nil
+	comments := true
+	buf := new(bytes.Buffer)
+	err = ourserializer.Encoder(
+		WithEncodingOptions(EncodingOptions{PreserveComments: &comments}),
+	).Encode(NewYAMLFrameWriter(buf), obj)
+	if err != nil {
+		t.Fatalf("unexpected encode error: %v", err)
+	}
+	if !strings.Contains(buf.String(), "# Me too") {
+		t.Errorf("expected the comments to be preserved, got %q", buf.String())
+	}
+
+	// A nil PreserveFieldOrder is treated as false by the encoder itself too
+	e := newEncoder(ourserializer.(*serializer).schemeAndCodec, EncodingOptions{Pretty: &comments, PreserveComments: &comments})
+	if err := e.Encode(NewYAMLFrameWriter(new(bytes.Buffer)), obj); err != nil {
+		t.Fatalf("unexpected encode error: %v", err)
+	}
+}
+
 func TestDefaulter(t *testing.T) {
 	//first := &runtimetest.ExternalComplex{TypeMeta: complexv2Meta, Integer64: 3}
 	//second := &runtimetest.InternalComplex{Integer64: 3}
@@ -700,3 +898,128 @@ func TestListRoundtrip(t *testing.T) {
 	}
 }
 */
+
+func TestMaxDecodedBytes(t *testing.T) {
+	// Every level of aliases multiplies the decoded size by nine
+	bomb := []byte(`a: &a ["xx","xx","xx","xx","xx","xx","xx","xx","xx"]
+b: &b [*a,*a,*a,*a,*a,*a,*a,*a,*a]
+c: &c [*b,*b,*b,*b,*b,*b,*b,*b,*b]
+d: &d [*c,*c,*c,*c,*c,*c,*c,*c,*c]
+e: &e [*d,*d,*d,*d,*d,*d,*d,*d,*d]
+f: &f [*e,*e,*e,*e,*e,*e,*e,*e,*e]
+`)
+	decoder := ourserializer.Decoder(WithMaxDecodedBytes(64 * 1024))
+	if _, err := decoder.Decode(NewYAMLFrameReader(FromBytes(bomb))); !errors.Is(err, ErrDecodedBytesExceeded) {
+		t.Errorf("expected ErrDecodedBytesExceeded for the alias bomb, got %v", err)
+	}
+
+	// The budget applies to all documents together
+	twoDocs := append(append([]byte{}, oneSimple...), []byte("\n---\n")...)
+	twoDocs = append(twoDocs, oneSimple...)
+	decoder = ourserializer.Decoder(WithMaxDecodedBytes(int64(len(oneSimple))))
+	if _, err := decoder.Decode(NewYAMLFrameReader(FromBytes(oneSimple))); err != nil {
+		t.Errorf("expected one document to fit the budget, got %v", err)
+	}
+	if _, err := decoder.DecodeAll(NewYAMLFrameReader(FromBytes(twoDocs))); !errors.Is(err, ErrDecodedBytesExceeded) {
+		t.Errorf("expected ErrDecodedBytesExceeded for two documents, got %v", err)
+	}
+}
+
+func TestEncodeCanonical(t *testing.T) {
+	// Different field ordering, comments, empty fields and number formatting
+	a := []byte(`# Comment
+kind: Complex
+int: 1.0
+metadata:
+  labels: {}
+  creationTimestamp: null
+apiVersion: foogroup/v1alpha1
+`)
+	b := []byte(`{"apiVersion":"foogroup/v1alpha1","kind":"Complex","int":1}`)
+	expected := "apiVersion: foogroup/v1alpha1\nint: 1\nkind: Complex\n"
+
+	for _, doc := range [][]byte{a, b} {
+		out, err := Canonicalize(doc, ContentTypeYAML)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != expected {
+			t.Errorf("expected %q, got %q", expected, out)
+		}
+	}
+
+	// Differently ordered documents decode into equal objects, which encode identically
+	reordered := []byte(`testString: foobar
+metadata:
+  creationTimestamp: null
+kind: CRD
+apiVersion: foogroup/v1alpha1
+`)
+	for _, ct := range []ContentType{ContentTypeYAML, ContentTypeJSON} {
+		var outputs []string
+		for _, doc := range [][]byte{oldCRD, reordered} {
+			obj, err := ourserializer.Decoder().Decode(NewYAMLFrameReader(FromBytes(doc)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var buf bytes.Buffer
+			if err := ourserializer.Encoder().EncodeCanonical(NewFrameWriter(ct, &buf), obj); err != nil {
+				t.Fatal(err)
+			}
+			outputs = append(outputs, buf.String())
+		}
+		if outputs[0] != outputs[1] {
+			t.Errorf("%s: expected identical output, got %q and %q", ct, outputs[0], outputs[1])
+		}
+	}
+}
+
+func TestEncodeList(t *testing.T) {
+	objs := []runtime.Object{
+		&runtimetest.ExternalSimple{TypeMeta: simpleMeta, TestString: "foo"},
+		&runtimetest.InternalComplex{Integer: 5},
+	}
+	expected := []runtime.Object{
+		&runtimetest.ExternalSimple{TypeMeta: simpleMeta, TestString: "foo"},
+		&runtimetest.ExternalComplex{TypeMeta: complexv1Meta, Integer: 5},
+	}
+
+	for _, ct := range []ContentType{ContentTypeYAML, ContentTypeJSON} {
+		var buf bytes.Buffer
+		if err := defaultEncoder.EncodeList(NewFrameWriter(ct, &buf), objs...); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(buf.String(), "List") {
+			t.Errorf("%s: expected a v1.List, got %q", ct, buf.String())
+		}
+
+		actual, err := ourserializer.Decoder().DecodeAll(NewFrameReader(ct, FromBytes(buf.Bytes())))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("%s: expected %#v, got %#v", ct, expected, actual)
+		}
+	}
+}
+
+// BenchmarkEncodeYAML encodes 10k small objects into one YAML stream, like e.g. an export does.
+// The allocations per object are reported as allocs/op divided by 10000.
+func BenchmarkEncodeYAML(b *testing.B) {
+	objs := make([]runtime.Object, 10000)
+	for i := range objs {
+		obj := &CRDOldVersion{TypeMeta: metav1.TypeMeta{APIVersion: ext1gv.String(), Kind: "CRD"}, TestString: "foo"}
+		obj.SetName(fmt.Sprintf("obj-%d", i))
+		objs[i] = obj
+	}
+	encoder := ourserializer.Encoder()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := encoder.Encode(NewYAMLFrameWriter(ioutil.Discard, WithoutTracing()), objs...); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
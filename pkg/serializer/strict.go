@@ -0,0 +1,175 @@
+package serializer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+	k8syaml "sigs.k8s.io/yaml"
+)
+
+var jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// newStrictDecodingError inspects the document that failed strict decoding, and returns a
+// StrictDecodingError listing all unknown and duplicate fields. If no such fields can be found,
+// origErr is returned as-is.
+func (d *decoder) newStrictDecodingError(doc []byte, gvk schema.GroupVersionKind, origErr error) error {
+	obj, err := d.scheme.New(gvk)
+	if err != nil {
+		return origErr
+	}
+
+	var unknownFields []string
+	var content interface{}
+	// The document might be malformed because of the duplicate fields, hence ignore the error here
+	if err := k8syaml.Unmarshal(doc, &content); err == nil {
+		unknownFields = findUnknownFields("", content, reflect.TypeOf(obj))
+	}
+	duplicateFields := findDuplicateFields(doc)
+
+	if len(unknownFields) == 0 && len(duplicateFields) == 0 {
+		return origErr
+	}
+	return NewStrictDecodingError(gvk, unknownFields, duplicateFields, origErr)
+}
+
+// findUnknownFields walks the generic JSON content alongside the Go type it is decoded into,
+// and returns the paths of all fields in the content not known to the Go type
+func findUnknownFields(path string, content interface{}, t reflect.Type) []string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	// Types with custom decoding logic (e.g. metav1.Time) and interfaces can't be inspected
+	if t.Kind() == reflect.Interface || reflect.PtrTo(t).Implements(jsonUnmarshalerType) {
+		return nil
+	}
+
+	var unknownFields []string
+	switch c := content.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(c))
+		for key := range c {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		switch t.Kind() {
+		case reflect.Struct:
+			fields := jsonFields(t)
+			for _, key := range keys {
+				fieldType, ok := fields[key]
+				if !ok {
+					unknownFields = append(unknownFields, joinFieldPath(path, key))
+					continue
+				}
+				unknownFields = append(unknownFields, findUnknownFields(joinFieldPath(path, key), c[key], fieldType)...)
+			}
+		case reflect.Map:
+			for _, key := range keys {
+				unknownFields = append(unknownFields, findUnknownFields(joinFieldPath(path, key), c[key], t.Elem())...)
+			}
+		}
+	case []interface{}:
+		if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+			for i, item := range c {
+				unknownFields = append(unknownFields, findUnknownFields(fmt.Sprintf("%s[%d]", path, i), item, t.Elem())...)
+			}
+		}
+	}
+	return unknownFields
+}
+
+// jsonFields returns a map of JSON field names to their Go types for the given struct type.
+// The fields of inlined, embedded structs are included.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		// Skip unexported fields
+		if len(f.PkgPath) != 0 && !f.Anonymous {
+			continue
+		}
+
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+
+		// Embedded structs without a JSON name are inlined
+		if f.Anonymous && len(name) == 0 {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				for fieldName, fieldType := range jsonFields(ft) {
+					fields[fieldName] = fieldType
+				}
+				continue
+			}
+		}
+
+		if len(name) == 0 {
+			name = f.Name
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
+
+// findDuplicateFields returns the paths of all mapping keys that are set more than once in the document
+func findDuplicateFields(doc []byte) []string {
+	node, err := yaml.Parse(string(doc))
+	if err != nil {
+		return nil
+	}
+	return findDuplicateNodeFields("", node.YNode())
+}
+
+func findDuplicateNodeFields(path string, node *yaml.Node) []string {
+	var duplicateFields []string
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, n := range node.Content {
+			duplicateFields = append(duplicateFields, findDuplicateNodeFields(path, n)...)
+		}
+	case yaml.MappingNode:
+		seen := map[string]bool{}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			fieldPath := joinFieldPath(path, key)
+			if seen[key] {
+				duplicateFields = append(duplicateFields, fieldPath)
+				continue
+			}
+			seen[key] = true
+			duplicateFields = append(duplicateFields, findDuplicateNodeFields(fieldPath, node.Content[i+1])...)
+		}
+	case yaml.SequenceNode:
+		for i, n := range node.Content {
+			duplicateFields = append(duplicateFields, findDuplicateNodeFields(fmt.Sprintf("%s[%d]", path, i), n)...)
+		}
+	}
+	return duplicateFields
+}
+
+func joinFieldPath(path, field string) string {
+	if len(path) == 0 {
+		return field
+	}
+	return path + "." + field
+}
+
+// IsStrictDecodingError returns true if the error was caused by the document containing
+// unknown or duplicate fields when decoding in strict mode
+func IsStrictDecodingError(err error) bool {
+	var strictErr *StrictDecodingError
+	return errors.As(err, &strictErr) || runtime.IsStrictDecodingError(err)
+}
@@ -0,0 +1,160 @@
+package serializer
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+// TableColumn describes a column of the tables written by the TableEncoder
+type TableColumn struct {
+	// Name is the header of the column, e.g. "NAME"
+	Name string
+	// JSONPath is the JSONPath template extracting the value of the column
+	// from an object, e.g. "{.metadata.name}". See k8s.io/client-go/util/jsonpath.
+	JSONPath string
+}
+
+// DefaultTableColumns are used for objects of GroupKinds without configured columns
+var DefaultTableColumns = []TableColumn{
+	{Name: "NAME", JSONPath: "{.metadata.name}"},
+}
+
+// noneValue is shown for fields which aren't set, like kubectl does
+const noneValue = "<none>"
+
+// NewTableEncoder returns a TableEncoder printing the objects of the types registered in the
+// given scheme. The columns of the tables are configured per GroupKind, objects of other
+// GroupKinds are printed using DefaultTableColumns.
+func NewTableEncoder(scheme *runtime.Scheme, columns map[schema.GroupKind][]TableColumn) (*TableEncoder, error) {
+	e := &TableEncoder{scheme: scheme, columns: make(map[schema.GroupKind][]tableColumn, len(columns))}
+	for gk, cols := range columns {
+		parsed, err := parseTableColumns(cols)
+		if err != nil {
+			return nil, fmt.Errorf("invalid columns for %s: %w", gk, err)
+		}
+		e.columns[gk] = parsed
+	}
+
+	var err error
+	if e.defaultColumns, err = parseTableColumns(DefaultTableColumns); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// TableEncoder writes objects as aligned, human-readable tables, like kubectl get does
+type TableEncoder struct {
+	scheme         *runtime.Scheme
+	columns        map[schema.GroupKind][]tableColumn
+	defaultColumns []tableColumn
+}
+
+// tableColumn is a TableColumn with its JSONPath template parsed
+type tableColumn struct {
+	name string
+	path *jsonpath.JSONPath
+}
+
+func parseTableColumns(cols []TableColumn) ([]tableColumn, error) {
+	parsed := make([]tableColumn, 0, len(cols))
+	for _, col := range cols {
+		path := jsonpath.New(col.Name).AllowMissingKeys(true)
+		if err := path.Parse(col.JSONPath); err != nil {
+			return nil, fmt.Errorf("column %q: %w", col.Name, err)
+		}
+		parsed = append(parsed, tableColumn{col.Name, path})
+	}
+	return parsed, nil
+}
+
+// Encode writes the given objects as tables to the FrameWriter, which must be of ContentTypeTable.
+// The objects are grouped by their GroupKinds, and one table is written per GroupKind, in the
+// order the GroupKinds first occur in. Every table is one frame, and starts with a header row.
+// Fields which aren't set are shown as "<none>".
+func (e *TableEncoder) Encode(fw FrameWriter, objs ...runtime.Object) error {
+	if fw.ContentType() != ContentTypeTable {
+		return fmt.Errorf("%w: the TableEncoder can only write %q, not %q", ErrUnsupportedContentType, ContentTypeTable, fw.ContentType())
+	}
+
+	var order []schema.GroupKind
+	groups := make(map[schema.GroupKind][]runtime.Object)
+	for _, obj := range objs {
+		gvk, err := GVKForObject(e.scheme, obj)
+		if err != nil {
+			return err
+		}
+
+		gk := gvk.GroupKind()
+		if _, ok := groups[gk]; !ok {
+			order = append(order, gk)
+		}
+		groups[gk] = append(groups[gk], obj)
+	}
+
+	for i, gk := range order {
+		table, err := e.table(gk, groups[gk])
+		if err != nil {
+			return err
+		}
+
+		// Separate the tables by an empty line
+		if i != 0 {
+			table = append([]byte{'\n'}, table...)
+		}
+		if _, err := fw.Write(table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// table renders the table for the given objects of the given GroupKind
+func (e *TableEncoder) table(gk schema.GroupKind, objs []runtime.Object) ([]byte, error) {
+	cols, ok := e.columns[gk]
+	if !ok {
+		cols = e.defaultColumns
+	}
+
+	var buf bytes.Buffer
+	tw := tabwriter.NewWriter(&buf, 0, 8, 3, ' ', 0)
+
+	headers := make([]string, 0, len(cols))
+	for _, col := range cols {
+		headers = append(headers, col.name)
+	}
+	fmt.Fprintln(tw, strings.Join(headers, "\t"))
+
+	for _, obj := range objs {
+		u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+		if err != nil {
+			return nil, err
+		}
+
+		values := make([]string, 0, len(cols))
+		for _, col := range cols {
+			var value bytes.Buffer
+			if err := col.path.Execute(&value, u); err != nil {
+				return nil, fmt.Errorf("column %q: %w", col.name, err)
+			}
+
+			// Tabs and newlines would break the alignment
+			v := strings.Join(strings.Fields(value.String()), " ")
+			if len(v) == 0 {
+				v = noneValue
+			}
+			values = append(values, v)
+		}
+		fmt.Fprintln(tw, strings.Join(values, "\t"))
+	}
+
+	if err := tw.Flush(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
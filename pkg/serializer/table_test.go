@@ -0,0 +1,67 @@
+package serializer_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/scheme"
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/weaveworks/libgitops/pkg/serializer"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// The Cars live in the sample app, which imports this package, hence the external test package
+
+func TestTableEncoder(t *testing.T) {
+	e, err := serializer.NewTableEncoder(scheme.Scheme, map[schema.GroupKind][]serializer.TableColumn{
+		v1alpha1.SchemeGroupVersion.WithKind("Car").GroupKind(): {
+			{Name: "NAME", JSONPath: "{.metadata.name}"},
+			{Name: "BRAND", JSONPath: "{.spec.brand}"},
+			{Name: "STATUS", JSONPath: "{.status.speed}"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newCar := func(name, brand string, speed float64) *v1alpha1.Car {
+		car := &v1alpha1.Car{Spec: v1alpha1.CarSpec{Brand: brand}}
+		car.SetName(name)
+		car.Status.Speed = speed
+		return car
+	}
+	motorcycle := &v1alpha1.Motorcycle{}
+	motorcycle.SetName("bike")
+
+	var buf bytes.Buffer
+	fw := serializer.NewFrameWriter(serializer.ContentTypeTable, &buf)
+	objs := []runtime.Object{
+		newCar("foo", "Volvo", 100),
+		motorcycle,
+		newCar("a-longer-name", "", 5.5),
+	}
+	if err := e.Encode(fw, objs...); err != nil {
+		t.Fatal(err)
+	}
+
+	// One table is written per kind, and Motorcycles get the default columns
+	expected := `NAME            BRAND    STATUS
+foo             Volvo    100
+a-longer-name   <none>   5.5
+
+NAME
+bike
+`
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+	if frames := fw.FramesWritten(); frames != 2 {
+		t.Errorf("expected 2 frames, got %d", frames)
+	}
+
+	if err := e.Encode(serializer.NewYAMLFrameWriter(&buf), objs...); !errors.Is(err, serializer.ErrUnsupportedContentType) {
+		t.Errorf("expected ErrUnsupportedContentType for YAML, got %v", err)
+	}
+}
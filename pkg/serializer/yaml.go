@@ -0,0 +1,96 @@
+package serializer
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+
+	jsoniter "github.com/json-iterator/go"
+	yamlv2 "gopkg.in/yaml.v2"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	// caseSensitiveJSONIterator is configured like the one of the YAML serializer of
+	// k8s.io/apimachinery, so that yamlSerializer encodes the objects identically
+	caseSensitiveJSONIterator = jsoniter.Config{
+		EscapeHTML:             true,
+		SortMapKeys:            true,
+		ValidateJsonRawMessage: true,
+		CaseSensitive:          true,
+	}.Froze()
+	// jsonTreeIterator decodes JSON documents into generic trees, keeping the numbers as json.Number
+	jsonTreeIterator = jsoniter.Config{UseNumber: true}.Froze()
+)
+
+// yamlSerializer wraps the YAML serializer of k8s.io/apimachinery, and writes exactly the same
+// YAML. Upstream encodes the object as JSON, and then parses that JSON as YAML before writing it
+// as YAML again. Parsing YAML is expensive, so yamlSerializer decodes the JSON directly into the
+// tree the YAML parser would have returned instead, which avoids most of the allocations.
+type yamlSerializer struct {
+	// Encoder is the upstream YAML serializer, which provides the Identifier
+	runtime.Encoder
+}
+
+// Encode implements runtime.Encoder
+func (s *yamlSerializer) Encode(obj runtime.Object, w io.Writer) error {
+	// The JSON is encoded into a pooled stream, as it's only needed until it has been converted
+	stream := caseSensitiveJSONIterator.BorrowStream(nil)
+	defer caseSensitiveJSONIterator.ReturnStream(stream)
+
+	stream.WriteVal(obj)
+	if stream.Error != nil {
+		return stream.Error
+	}
+
+	data, err := jsonToYAML(stream.Buffer())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// jsonToYAML converts the given JSON document to YAML like yaml.JSONToYAML does
+func jsonToYAML(doc []byte) ([]byte, error) {
+	var tree interface{}
+	if err := jsonTreeIterator.Unmarshal(doc, &tree); err != nil {
+		// The document isn't JSON, e.g. the raw YAML of a runtime.Unknown. Upstream parses
+		// it as YAML, so fall back to that.
+		return yaml.JSONToYAML(doc)
+	}
+
+	return yamlv2.Marshal(resolveNumbers(tree))
+}
+
+// resolveNumbers replaces the json.Numbers in the given generic tree with the types the YAML
+// parser resolves them to: int if the number fits, then int64 and uint64, and float64 otherwise
+func resolveNumbers(tree interface{}) interface{} {
+	switch v := tree.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = resolveNumbers(value)
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = resolveNumbers(v[i])
+		}
+	case json.Number:
+		if i, err := strconv.ParseInt(string(v), 0, 64); err == nil {
+			if i == int64(int(i)) {
+				return int(i)
+			}
+			return i
+		}
+		if u, err := strconv.ParseUint(string(v), 0, 64); err == nil {
+			return u
+		}
+		if f, err := strconv.ParseFloat(string(v), 64); err == nil {
+			return f
+		}
+		// Like the YAML parser, keep numbers which can't be parsed as strings
+		return string(v)
+	}
+	return tree
+}
@@ -0,0 +1,70 @@
+package serializer
+
+import (
+	"bytes"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+func TestJSONToYAML(t *testing.T) {
+	// jsonToYAML must produce exactly the same output as upstream for all JSON written by the
+	// encoder. It's more lenient for other JSON, e.g. upstream can't parse the escape "\/".
+	docs := []string{
+		`{}`,
+		`[]`,
+		`null`,
+		`{"kind":"Car","apiVersion":"sample-app.weave.works/v1alpha1","metadata":{"name":"foo","creationTimestamp":null},"spec":{"brand":"Volvo"}}`,
+		`{"b":1,"a":[1,2,{"c":null}],"10":true,"9":false,"true":"false","empty":{},"list":[],"s":""}`,
+		`{"ints":[0,-0,1,-1,2147483648,9223372036854775807,-9223372036854775808,9223372036854775808,18446744073709551615,18446744073709551616]}`,
+		`{"floats":[0.5,-1.25,1.0,1e3,1E-7,1.5e+300,123456789.123456789,1e21]}`,
+		`{"strings":["123","1.5","0x10","0b101","true","null","~","yes","2001-12-14","- a",": b","a: b","#c","'q'","\"dq\""]}`,
+		`{"escapes":"line1\nline2\ttab \u003chtml\u003e \u0026 <html> & \u2028 \u0000 \\ \" ünïcödé 😀"}`,
+		`{"multiline":"a\nb\nc\n","leading":" a","trailing":"a ","long":"` + string(bytes.Repeat([]byte("word "), 40)) + `"}`,
+		`{"nested":{"deeply":{"nested":{"list":[[1,[2,[3]]],{"a":{"b":[]}}]}}}}`,
+		`"just a string"`,
+		`42`,
+		`apiVersion: unknown/v1
+kind: YouDontRecognizeMe
+testFooBar: true
+`,
+	}
+	for _, doc := range docs {
+		expected, expectedErr := yaml.JSONToYAML([]byte(doc))
+		actual, err := jsonToYAML([]byte(doc))
+		if (err != nil) != (expectedErr != nil) {
+			t.Errorf("%s: expected error %v, got %v", doc, expectedErr, err)
+		}
+		if !bytes.Equal(actual, expected) {
+			t.Errorf("%s: expected\n%s\ngot\n%s", doc, expected, actual)
+		}
+	}
+}
+
+func TestYAMLSerializer(t *testing.T) {
+	// The objects are encoded exactly like the upstream YAML serializer does
+	info, ok := runtime.SerializerInfoForMediaType(codecs.SupportedMediaTypes(), string(ContentTypeYAML))
+	if !ok {
+		t.Fatal("no upstream YAML serializer")
+	}
+	upstream := info.Serializer
+
+	for _, data := range [][]byte{oneSimple, oneComplex, oldCRD} {
+		obj, err := ourserializer.Decoder().Decode(NewYAMLFrameReader(FromBytes(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var expected, actual bytes.Buffer
+		if err := upstream.Encode(obj, &expected); err != nil {
+			t.Fatal(err)
+		}
+		if err := (&yamlSerializer{upstream}).Encode(obj, &actual); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(actual.Bytes(), expected.Bytes()) {
+			t.Errorf("expected\n%s\ngot\n%s", expected.String(), actual.String())
+		}
+	}
+}
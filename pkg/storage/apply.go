@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/weaveworks/libgitops/pkg/runtime"
+)
+
+// ApplyAttempts is the maximum number of times Apply and CreateOrUpdate try to write
+// an object that keeps getting modified, created or deleted concurrently
+const ApplyAttempts = 5
+
+// Apply creates obj in s if it doesn't exist yet, and updates it otherwise. created tells
+// which of the two happened. Like for Create and Update, obj is modified to be the object
+// that was written. If the object is modified concurrently, which makes Update fail with
+// ErrConflict (see Options.ConflictResolver), the resourceVersion of obj is set to the one of
+// the stored object and the update is retried, i.e. obj overwrites the concurrent changes.
+// Concurrent creations and deletions are retried as well, up to ApplyAttempts writes in total.
+// If s implements ContextStorage, the writes are run in ctx.
+func Apply(ctx context.Context, s Storage, obj runtime.Object) (created bool, err error) {
+	s = WithContext(s, ctx)
+	key, err := s.ObjectKeyFor(obj)
+	if err != nil {
+		return false, err
+	}
+
+	exists := s.RawStorage().Exists(key)
+	for i := 0; i < ApplyAttempts; i++ {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+
+		if !exists {
+			err = s.Create(obj)
+			if err == nil {
+				return true, nil
+			}
+			// The object was created since it was checked for, update it instead
+			if !errors.Is(err, ErrAlreadyExists) {
+				return false, err
+			}
+			exists = true
+			continue
+		}
+
+		err = s.Update(obj)
+		switch {
+		case err == nil:
+			return false, nil
+		case errors.Is(err, ErrNotFound):
+			// The object was deleted since it was checked for, create it instead
+			exists = false
+		case errors.Is(err, ErrConflict):
+			// Base the next write on the current version of the object
+			current, err := s.GetMeta(key)
+			if errors.Is(err, ErrNotFound) {
+				exists = false
+				obj.SetResourceVersion("")
+				continue
+			} else if err != nil {
+				return false, err
+			}
+			obj.SetResourceVersion(current.GetResourceVersion())
+		default:
+			return false, err
+		}
+	}
+
+	return false, fmt.Errorf("%s: giving up after %d attempts: %w", key, ApplyAttempts, err)
+}
+
+// MutateFunc changes obj to its desired state, see CreateOrUpdate
+type MutateFunc func(obj runtime.Object) error
+
+// CreateOrUpdate reads the object for key from s, changes it using mutate, and writes it back. If the
+// object doesn't exist, mutate is given a new object of the kind of key, whose namespace and name are
+// set from the identifier of key, and the object is created instead. created tells which of the two
+// happened. If the object is modified, created or deleted concurrently, the write is retried with the
+// object read again, and mutate is invoked again, so that no concurrent changes are overwritten. It's
+// retried up to ApplyAttempts writes in total. mutate must not change the key of the object, and
+// errors returned by it abort CreateOrUpdate. If s implements ContextStorage, the operations are run in ctx.
+func CreateOrUpdate(ctx context.Context, s Storage, key ObjectKey, mutate MutateFunc) (obj runtime.Object, created bool, err error) {
+	s = WithContext(s, ctx)
+	for i := 0; i < ApplyAttempts; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, false, err
+		}
+
+		obj, err = s.Get(key)
+		created = errors.Is(err, ErrNotFound)
+		if created {
+			obj, err = newObject(s, key)
+		}
+		if err != nil {
+			return nil, false, err
+		}
+
+		if err := mutate(obj); err != nil {
+			return nil, false, err
+		}
+		if mutated, err := s.ObjectKeyFor(obj); err != nil {
+			return nil, false, err
+		} else if !mutated.EqualsGVK(key, false) || mutated.GetIdentifier() != key.GetIdentifier() {
+			return nil, false, fmt.Errorf("%s: the MutateFunc changed the key of the object to %s", key, mutated)
+		}
+
+		if created {
+			err = s.Create(obj)
+		} else {
+			err = s.Update(obj)
+		}
+		if err == nil {
+			return obj, created, nil
+		}
+		if !errors.Is(err, ErrConflict) && !errors.Is(err, ErrAlreadyExists) && !errors.Is(err, ErrNotFound) {
+			return nil, false, err
+		}
+	}
+
+	return nil, false, fmt.Errorf("%s: giving up after %d attempts: %w", key, ApplyAttempts, err)
+}
+
+// newObject returns a new object of the kind of key, named after the identifier of key
+func newObject(s Storage, key ObjectKey) (runtime.Object, error) {
+	kobj, err := s.Serializer().Scheme().New(key.GetGVK())
+	if err != nil {
+		return nil, err
+	}
+	obj, ok := kobj.(runtime.Object)
+	if !ok {
+		return nil, fmt.Errorf("%s: %T doesn't implement runtime.Object", key, kobj)
+	}
+
+	namespace, name := splitIdentifier(key)
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	return obj, nil
+}
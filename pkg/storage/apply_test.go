@@ -0,0 +1,189 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+)
+
+// conflictingStorage fails the first conflicts Updates with ErrConflict
+type conflictingStorage struct {
+	Storage
+	conflicts int
+	updates   int
+}
+
+func (s *conflictingStorage) Update(obj runtime.Object) error {
+	s.updates++
+	if s.updates <= s.conflicts {
+		return ErrConflict
+	}
+	return s.Storage.Update(obj)
+}
+
+// rejectConflicts configures newTestStorage to reject the conflicting updates
+func rejectConflicts(_ *RawStorageOptions, opts *Options) {
+	opts.ConflictResolver = RejectConflicts
+}
+
+func TestApply(t *testing.T) {
+	newStorage := func() Storage {
+		s, _, _ := newTestStorage(t, manageGeneration, rejectConflicts)
+		return s
+	}
+	newCar := func(brand string) *v1alpha1.Car {
+		car := &v1alpha1.Car{Spec: v1alpha1.CarSpec{Brand: brand}}
+		car.SetName("foo")
+		car.SetNamespace("default")
+		return car
+	}
+	expectBrand := func(s Storage, brand string, generation int64) {
+		obj, err := s.Get(carKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if car := obj.(*v1alpha1.Car); car.Spec.Brand != brand || car.Generation != generation {
+			t.Errorf("expected brand %q and generation %d, got %q and %d", brand, generation, car.Spec.Brand, car.Generation)
+		}
+	}
+	ctx := context.Background()
+
+	t.Run("create", func(t *testing.T) {
+		s := newStorage()
+		if created, err := Apply(ctx, s, newCar("Volvo")); err != nil || !created {
+			t.Fatalf("expected the Car to be created, got %t, %v", created, err)
+		}
+		expectBrand(s, "Volvo", 1)
+	})
+
+	t.Run("update", func(t *testing.T) {
+		s := newStorage()
+		if err := s.Create(newCar("Volvo")); err != nil {
+			t.Fatal(err)
+		}
+
+		if created, err := Apply(ctx, s, newCar("Tesla")); err != nil || created {
+			t.Fatalf("expected the Car to be updated, got %t, %v", created, err)
+		}
+		expectBrand(s, "Tesla", 2)
+	})
+
+	t.Run("retry on conflict", func(t *testing.T) {
+		s := newStorage()
+		if err := s.Create(newCar("Volvo")); err != nil {
+			t.Fatal(err)
+		}
+
+		// The Car is modified after it was read
+		obj, err := s.Get(carKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Update(newCar("Audi")); err != nil {
+			t.Fatal(err)
+		}
+		stale := obj.(*v1alpha1.Car)
+		stale.Spec.Brand = "Tesla"
+		if err := s.Update(stale.DeepCopy()); !errors.Is(err, ErrConflict) {
+			t.Fatalf("expected ErrConflict, got %v", err)
+		}
+
+		if created, err := Apply(ctx, s, stale); err != nil || created {
+			t.Fatalf("expected the Car to be updated, got %t, %v", created, err)
+		}
+		expectBrand(s, "Tesla", 3)
+	})
+
+	t.Run("give up", func(t *testing.T) {
+		s := &conflictingStorage{Storage: newStorage(), conflicts: ApplyAttempts}
+		if err := s.Create(newCar("Volvo")); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := Apply(ctx, s, newCar("Tesla")); !errors.Is(err, ErrConflict) {
+			t.Errorf("expected ErrConflict, got %v", err)
+		}
+		if s.updates != ApplyAttempts {
+			t.Errorf("expected %d attempts, got %d", ApplyAttempts, s.updates)
+		}
+		expectBrand(s, "Volvo", 1)
+
+		// One attempt less succeeds
+		s.updates, s.conflicts = 0, ApplyAttempts-1
+		if _, err := Apply(ctx, s, newCar("Tesla")); err != nil {
+			t.Fatal(err)
+		}
+		expectBrand(s, "Tesla", 2)
+	})
+}
+
+func TestCreateOrUpdate(t *testing.T) {
+	s, _, _ := newTestStorage(t, manageGeneration, rejectConflicts)
+	ctx := context.Background()
+
+	// A new object is named after the key
+	obj, created, err := CreateOrUpdate(ctx, s, carKey, func(obj runtime.Object) error {
+		obj.(*v1alpha1.Car).Spec.Brand = "Volvo"
+		return nil
+	})
+	if err != nil || !created {
+		t.Fatalf("expected the Car to be created, got %t, %v", created, err)
+	}
+	if obj.GetNamespace() != "default" || obj.GetName() != "foo" {
+		t.Errorf("expected the Car to be named default/foo, got %s/%s", obj.GetNamespace(), obj.GetName())
+	}
+
+	// A concurrent write during the first attempt makes the update conflict, which is
+	// retried with the object read again, so that both changes are kept
+	var calls int
+	obj, created, err = CreateOrUpdate(ctx, s, carKey, func(obj runtime.Object) error {
+		calls++
+		if calls == 1 {
+			concurrent, err := s.Get(carKey)
+			if err != nil {
+				return err
+			}
+			concurrent.(*v1alpha1.Car).Status.Speed = 100
+			if err := s.Update(concurrent); err != nil {
+				return err
+			}
+		}
+		obj.(*v1alpha1.Car).Spec.Brand = "Tesla"
+		return nil
+	})
+	if err != nil || created {
+		t.Fatalf("expected the Car to be updated, got %t, %v", created, err)
+	}
+	if calls != 2 {
+		t.Errorf("expected the MutateFunc to be invoked twice, got %d", calls)
+	}
+
+	stored, err := s.Get(carKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	car := stored.(*v1alpha1.Car)
+	if car.Spec.Brand != "Tesla" || car.Status.Speed != 100 {
+		t.Errorf("expected both changes to be kept, got brand %q and speed %v", car.Spec.Brand, car.Status.Speed)
+	}
+	// Only the spec change bumps the generation
+	if car.Generation != 2 || car.ResourceVersion != obj.GetResourceVersion() {
+		t.Errorf("expected the written object with generation 2 to be returned, got generation %d", car.Generation)
+	}
+
+	// The MutateFunc can't change the key, and its errors are returned
+	if _, _, err := CreateOrUpdate(ctx, s, carKey, func(obj runtime.Object) error {
+		obj.SetName("bar")
+		return nil
+	}); err == nil {
+		t.Error("expected an error for a changed key")
+	}
+	if _, _, err := CreateOrUpdate(ctx, s, carKey, func(runtime.Object) error {
+		return ErrAmbiguousFind
+	}); !errors.Is(err, ErrAmbiguousFind) {
+		t.Errorf("expected the error of the MutateFunc, got %v", err)
+	}
+}
@@ -0,0 +1,133 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/storage/watch"
+	"github.com/weaveworks/libgitops/pkg/storage/watch/update"
+)
+
+// Store is an in-memory cache of decoded objects, fed by the UpdateStream of an
+// EventStorage. Objects are added on CREATE and MODIFY events, and removed on DELETE
+// events. Reading from the Store never hits the disk. The EventStorage should send an
+// ObjectEventSync after the initial scan (see watch.Options.SyncEvent), after which
+// HasSynced returns true.
+type Store struct {
+	objects map[storage.ObjectKey]runtime.Object
+	synced  bool
+	mux     sync.RWMutex
+}
+
+// NewStore creates a new, empty Store
+func NewStore() *Store {
+	return &Store{objects: make(map[storage.ObjectKey]runtime.Object)}
+}
+
+// Run processes the Updates sent to the given UpdateStream, until ctx is cancelled
+func (s *Store) Run(ctx context.Context, updates update.UpdateStream) {
+	for {
+		select {
+		case upd := <-updates:
+			if err := s.Process(upd); err != nil {
+				log.Warnf("Store: Failed to process %s event: %v", upd.Event, err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Process applies the given Update to the Store. The objects of CREATE and MODIFY events
+// are taken from Update.Object if set, otherwise they're decoded using Update.Storage.
+func (s *Store) Process(upd update.Update) error {
+	switch upd.Event {
+	case update.ObjectEventSync:
+		s.mux.Lock()
+		s.synced = true
+		s.mux.Unlock()
+		return nil
+	case update.ObjectEventDelete:
+		key, err := deletedKey(upd)
+		if err != nil {
+			return err
+		}
+
+		s.mux.Lock()
+		delete(s.objects, key)
+		s.mux.Unlock()
+		return nil
+	case update.ObjectEventCreate, update.ObjectEventModify:
+		key, err := upd.Storage.ObjectKeyFor(upd.PartialObject)
+		if err != nil {
+			return err
+		}
+
+		obj := upd.Object
+		if obj == nil {
+			if obj, err = upd.Storage.Get(key); err != nil {
+				return err
+			}
+		}
+
+		s.mux.Lock()
+		s.objects[key] = obj
+		s.mux.Unlock()
+		return nil
+	}
+
+	return fmt.Errorf("unknown event %s", upd.Event)
+}
+
+// deletedKey returns the key of the object of the given DELETE event, which
+// carries the identifier of the object as its UID (see watch.EventDeleteObjectName)
+func deletedKey(upd update.Update) (storage.ObjectKey, error) {
+	if upd.PartialObject.GetName() != watch.EventDeleteObjectName {
+		return upd.Storage.ObjectKeyFor(upd.PartialObject)
+	}
+
+	gvk := upd.PartialObject.GetObjectKind().GroupVersionKind()
+	return storage.NewObjectKey(storage.NewKindKey(gvk), runtime.NewIdentifier(string(upd.PartialObject.GetUID()))), nil
+}
+
+// GetByKey returns a copy of the cached object with the given key,
+// or storage.ErrNotFound if the object isn't cached
+func (s *Store) GetByKey(key storage.ObjectKey) (runtime.Object, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	obj, ok := s.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", key, storage.ErrNotFound)
+	}
+
+	return obj.DeepCopyObject().(runtime.Object), nil
+}
+
+// List returns copies of all cached objects of the given kind
+func (s *Store) List(kind storage.KindKey) []runtime.Object {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	var objs []runtime.Object
+	for key, obj := range s.objects {
+		if key.GetGVK() == kind.GetGVK() {
+			objs = append(objs, obj.DeepCopyObject().(runtime.Object))
+		}
+	}
+
+	return objs
+}
+
+// HasSynced returns true if the Store has processed the ObjectEventSync, i.e. it
+// contains all objects found by the initial scan of the EventStorage
+func (s *Store) HasSynced() bool {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	return s.synced
+}
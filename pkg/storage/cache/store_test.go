@@ -0,0 +1,91 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/scheme"
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/storage/watch"
+	"github.com/weaveworks/libgitops/pkg/storage/watch/update"
+	"k8s.io/apimachinery/pkg/api/equality"
+)
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"foo", "bar"} {
+		content := fmt.Sprintf("apiVersion: sample-app.weave.works/v1alpha1\nkind: Car\nmetadata:\n  name: %s\n  namespace: default\nspec:\n  engine: v8\n", name)
+		if err := ioutil.WriteFile(filepath.Join(dir, name+".yaml"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	opts := watch.DefaultOptions()
+	opts.SyncEvent = true
+	s, err := watch.NewGenericWatchStorageWithOptions(storage.NewGenericStorage(
+		storage.NewGenericMappedRawStorage(dir), scheme.Serializer, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier},
+	), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := NewStore()
+	updates := make(update.UpdateStream, 10)
+	s.SetUpdateStream(updates)
+	go store.Run(ctx, updates)
+
+	for i := 0; !store.HasSynced(); i++ {
+		if i == 500 {
+			t.Fatal("timed out waiting for the Store to sync")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	carKind := storage.NewKindKey(v1alpha1.SchemeGroupVersion.WithKind("Car"))
+	if cars := store.List(carKind); len(cars) != 2 {
+		t.Fatalf("expected 2 cached Cars, got %d", len(cars))
+	}
+
+	key := storage.NewObjectKey(carKind, runtime.NewIdentifier("default/foo"))
+	cached, err := store.GetByKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := s.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equality.Semantic.DeepEqual(cached, stored) {
+		t.Errorf("expected the cached object to match the stored one:\n%+v\n%+v", cached, stored)
+	}
+
+	// Deletions invalidate the cached object
+	if err := os.Remove(filepath.Join(dir, "foo.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; ; i++ {
+		if _, err := store.GetByKey(key); err != nil {
+			break
+		}
+		if i == 500 {
+			t.Fatal("timed out waiting for the deletion")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
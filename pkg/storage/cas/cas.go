@@ -0,0 +1,288 @@
+package cas
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/serializer"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/util/filesystem"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// objectsDir holds the content of all object versions, named by their SHA-256 hash
+	objectsDir = "objects"
+	// refsDir holds one ref file per object, listing the hashes of its versions
+	refsDir = "refs"
+	// coreGroup is used as the ref directory name for the core group, whose name is empty
+	coreGroup = "core"
+	// checksumPrefix is the prefix of the checksums, as used by filesystem.SHA256Checksummer
+	checksumPrefix = "sha256:"
+)
+
+// Option configures a Storage
+type Option func(*options)
+
+// options holds the configuration of a Storage
+type options struct {
+	fs filesystem.Filesystem
+	ct serializer.ContentType
+}
+
+func defaultOptions() options {
+	return options{
+		fs: filesystem.NewOSFilesystem(),
+		ct: serializer.ContentTypeYAML,
+	}
+}
+
+// WithFilesystem sets the Filesystem the objects and refs are stored in. (Default: the local disk)
+func WithFilesystem(fs filesystem.Filesystem) Option {
+	return func(o *options) {
+		o.fs = fs
+	}
+}
+
+// WithContentType sets the content type of the stored objects. (Default: serializer.ContentTypeYAML)
+func WithContentType(ct serializer.ContentType) Option {
+	return func(o *options) {
+		o.ct = ct
+	}
+}
+
+// New returns a content-addressable RawStorage in the given directory. Every version of an object
+// is stored immutably as "<dir>/objects/<sha256>", hence equal contents are only stored once. The
+// mutable ref "<dir>/refs/<group>/<version>/<kind>/<identifier>" lists the hashes of all versions
+// of the object, the last one being the current version. Deleting an object only removes its ref,
+// the versions are kept until they are garbage collected.
+func New(dir string, opts ...Option) *Storage {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return &Storage{
+		dir: path.Clean(dir),
+		fs:  o.fs,
+		ct:  o.ct,
+	}
+}
+
+// Storage is a content-addressable RawStorage, see New
+type Storage struct {
+	dir string
+	fs  filesystem.Filesystem
+	ct  serializer.ContentType
+
+	// mux guards the refs, so that concurrent writes don't lose versions
+	mux sync.RWMutex
+}
+
+var _ storage.RawStorage = &Storage{}
+
+func (s *Storage) Read(key storage.ObjectKey) ([]byte, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	hashes, err := s.readRef(key)
+	if err != nil {
+		return nil, err
+	}
+	return s.fs.ReadFile(s.objectPath(hashes[len(hashes)-1]))
+}
+
+func (s *Storage) Exists(key storage.ObjectKey) bool {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	return filesystem.FileExists(s.fs, s.refPath(key))
+}
+
+// Write stores content as a new version of the object, and makes it the current version.
+// Writing the content of the current version again doesn't add a version.
+func (s *Storage) Write(key storage.ObjectKey, content []byte) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+
+	// Versions with the same content are only stored once
+	if object := s.objectPath(hash); !filesystem.FileExists(s.fs, object) {
+		if err := s.fs.MkdirAll(path.Dir(object), 0755); err != nil {
+			return err
+		}
+		if err := s.fs.WriteFile(object, content, 0644); err != nil {
+			return err
+		}
+	}
+
+	hashes, err := s.readRef(key)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+	if len(hashes) != 0 && hashes[len(hashes)-1] == hash {
+		return nil
+	}
+
+	ref := s.refPath(key)
+	if err := s.fs.MkdirAll(path.Dir(ref), 0755); err != nil {
+		return err
+	}
+	return s.fs.WriteFile(ref, []byte(strings.Join(append(hashes, hash), "\n")+"\n"), 0644)
+}
+
+// Delete removes the ref of the object. The versions of the object are kept.
+func (s *Storage) Delete(key storage.ObjectKey) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	ref := s.refPath(key)
+	if !filesystem.FileExists(s.fs, ref) {
+		return storage.ErrNotFound
+	}
+	return s.fs.Remove(ref)
+}
+
+func (s *Storage) List(kind storage.KindKey) ([]storage.ObjectKey, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	kindDir := s.kindPath(kind)
+	if _, err := s.fs.Stat(kindDir); os.IsNotExist(err) {
+		return []storage.ObjectKey{}, nil
+	}
+
+	keys := []storage.ObjectKey{}
+	err := s.fs.Walk(kindDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		id := strings.TrimPrefix(filepath.ToSlash(p), kindDir+"/")
+		keys = append(keys, storage.NewObjectKey(kind, runtime.NewIdentifier(id)))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	storage.SortKeys(keys)
+	return keys, nil
+}
+
+// Checksum returns the hash of the current version of the object, prefixed like the
+// checksums of filesystem.SHA256Checksummer
+func (s *Storage) Checksum(key storage.ObjectKey) (string, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	hashes, err := s.readRef(key)
+	if err != nil {
+		return "", err
+	}
+	return checksumPrefix + hashes[len(hashes)-1], nil
+}
+
+func (s *Storage) ContentType(_ storage.ObjectKey) serializer.ContentType {
+	return s.ct
+}
+
+// WatchDir returns the directory of the refs, as the objects never change
+func (s *Storage) WatchDir() string {
+	return path.Join(s.dir, refsDir)
+}
+
+// GetKey returns the key of the object with the given ref file
+func (s *Storage) GetKey(p string) (storage.ObjectKey, error) {
+	clean, prefix := filepath.ToSlash(filepath.Clean(p)), s.WatchDir()+"/"
+	if !strings.HasPrefix(clean, prefix) {
+		return nil, fmt.Errorf("path has wrong base: %s", p)
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(clean, prefix), "/", 4)
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("path not long enough: %s", p)
+	}
+	gvk := schema.GroupVersionKind{Group: parts[0], Version: parts[1], Kind: parts[2]}
+	if gvk.Group == coreGroup {
+		gvk.Group = ""
+	}
+
+	return storage.NewObjectKey(storage.NewKindKey(gvk), runtime.NewIdentifier(parts[3])), nil
+}
+
+// History returns the hashes of all versions of the object, from the oldest to the
+// current one. If the object doesn't exist, ErrNotFound is returned.
+func (s *Storage) History(key storage.ObjectKey) ([]string, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	return s.readRef(key)
+}
+
+// GetVersion returns the content of the version of the object with the given hash, which
+// may be prefixed like the checksums returned by Checksum. Only the versions listed in the
+// History of the object can be read, otherwise ErrNotFound is returned.
+func (s *Storage) GetVersion(key storage.ObjectKey, hash string) ([]byte, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	hashes, err := s.readRef(key)
+	if err != nil {
+		return nil, err
+	}
+
+	hash = strings.TrimPrefix(hash, checksumPrefix)
+	for _, h := range hashes {
+		if h == hash {
+			return s.fs.ReadFile(s.objectPath(hash))
+		}
+	}
+	return nil, fmt.Errorf("version %s of %s: %w", hash, key, storage.ErrNotFound)
+}
+
+// readRef returns the hashes listed in the ref of the object, or ErrNotFound
+func (s *Storage) readRef(key storage.ObjectKey) ([]string, error) {
+	ref := s.refPath(key)
+	if !filesystem.FileExists(s.fs, ref) {
+		return nil, storage.ErrNotFound
+	}
+
+	content, err := s.fs.ReadFile(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := strings.Fields(string(bytes.TrimSpace(content)))
+	if len(hashes) == 0 {
+		return nil, fmt.Errorf("ref of %s is empty", key)
+	}
+	return hashes, nil
+}
+
+func (s *Storage) objectPath(hash string) string {
+	return path.Join(s.dir, objectsDir, hash)
+}
+
+func (s *Storage) kindPath(kind storage.KindKey) string {
+	group := kind.GetGroup()
+	if len(group) == 0 {
+		group = coreGroup
+	}
+	return path.Join(s.dir, refsDir, group, kind.GetVersion(), kind.GetKind())
+}
+
+func (s *Storage) refPath(key storage.ObjectKey) string {
+	return path.Join(s.kindPath(key), key.GetIdentifier())
+}
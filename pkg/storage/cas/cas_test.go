@@ -0,0 +1,129 @@
+package cas
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/util/filesystem"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var (
+	carKind = storage.NewKindKey(schema.GroupVersionKind{Group: "sample-app.weave.works", Version: "v1alpha1", Kind: "Car"})
+	fooKey  = storage.NewObjectKey(carKind, runtime.NewIdentifier("default/foo"))
+	barKey  = storage.NewObjectKey(carKind, runtime.NewIdentifier("bar"))
+)
+
+func TestVersions(t *testing.T) {
+	fs := filesystem.NewInMemory()
+	s := New("/cas", WithFilesystem(fs))
+
+	if _, err := s.History(fooKey); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	versions := []string{"v1", "v2", "v2", "v1"}
+	for _, content := range versions {
+		if err := s.Write(fooKey, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Writing the current content again doesn't add a version
+	history, err := s.History(fooKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 3 || history[0] != history[2] {
+		t.Fatalf("expected three versions, the first and last being equal, got %v", history)
+	}
+
+	// The ref points to the latest version
+	if content, err := s.Read(fooKey); err != nil || string(content) != "v1" {
+		t.Errorf("expected to read %q, got %q (%v)", "v1", content, err)
+	}
+	if checksum, err := s.Checksum(fooKey); err != nil || checksum != "sha256:"+history[2] {
+		t.Errorf("expected the checksum of the latest version, got %q (%v)", checksum, err)
+	}
+
+	for i, expected := range []string{"v1", "v2", "v1"} {
+		if content, err := s.GetVersion(fooKey, history[i]); err != nil || string(content) != expected {
+			t.Errorf("expected version %d to be %q, got %q (%v)", i, expected, content, err)
+		}
+	}
+
+	// Equal contents are only stored once
+	objects, err := fs.ReadDir("/cas/objects")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 2 {
+		t.Errorf("expected two objects, got %d", len(objects))
+	}
+
+	// Versions of other objects can't be read through the key
+	if err := s.Write(barKey, []byte("v3")); err != nil {
+		t.Fatal(err)
+	}
+	barHistory, err := s.History(barKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetVersion(fooKey, barHistory[0]); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestRefs(t *testing.T) {
+	fs := filesystem.NewInMemory()
+	s := New("/cas", WithFilesystem(fs))
+
+	for _, key := range []storage.ObjectKey{fooKey, barKey} {
+		if err := s.Write(key, []byte(key.GetIdentifier())); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keys, err := s.List(carKind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(keys, []storage.ObjectKey{barKey, fooKey}) {
+		t.Errorf("expected %v, got %v", []storage.ObjectKey{barKey, fooKey}, keys)
+	}
+
+	key, err := s.GetKey("/cas/refs/sample-app.weave.works/v1alpha1/Car/default/foo")
+	if err != nil || key != fooKey {
+		t.Errorf("expected %v, got %v (%v)", fooKey, key, err)
+	}
+	if _, err := s.GetKey("/cas/objects/abc"); err == nil {
+		t.Error("expected the objects not to map to keys")
+	}
+
+	// Deleting drops the ref, but keeps the objects
+	history, err := s.History(fooKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(fooKey); err != nil {
+		t.Fatal(err)
+	}
+	if s.Exists(fooKey) {
+		t.Error("expected the object not to exist after deleting it")
+	}
+	if err := s.Delete(fooKey); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if _, err := s.Read(fooKey); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if !filesystem.FileExists(fs, "/cas/objects/"+history[0]) {
+		t.Error("expected the object to be kept")
+	}
+	if keys, err := s.List(carKind); err != nil || len(keys) != 1 {
+		t.Errorf("expected one key, got %v (%v)", keys, err)
+	}
+}
@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"path/filepath"
+
+	"github.com/weaveworks/libgitops/pkg/serializer"
+	"github.com/weaveworks/libgitops/pkg/util/filesystem"
+)
+
+// CanonicalChecksummer uses the SHA-256 hash of the canonical form of the file contents (see
+// serializer.Canonicalize) as the checksum. Files only differing in e.g. field ordering, comments
+// or formatting hence have the same checksum. Files with an unknown extension are hashed as-is.
+var CanonicalChecksummer = filesystem.NewChecksummer("canonical-sha256", func(fs filesystem.Filesystem, path string) (string, error) {
+	content, err := fs.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	if ct, ok := ContentTypes[filepath.Ext(path)]; ok {
+		if content, err = serializer.Canonicalize(content, ct); err != nil {
+			return "", err
+		}
+	}
+
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+})
+
+// isEquivalent returns true if the existing file of the given key has
+// the same canonical form as the given content
+func (s *GenericStorage) isEquivalent(key ObjectKey, content []byte, ct serializer.ContentType) bool {
+	if !s.raw.Exists(key) {
+		return false
+	}
+
+	existing, err := s.raw.Read(key)
+	if err != nil {
+		return false
+	}
+
+	a, err := serializer.Canonicalize(existing, ct)
+	if err != nil {
+		return false
+	}
+	b, err := serializer.Canonicalize(content, ct)
+	if err != nil {
+		return false
+	}
+
+	return bytes.Equal(a, b)
+}
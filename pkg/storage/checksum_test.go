@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/weaveworks/libgitops/pkg/serializer"
+)
+
+func TestSkipEquivalentWrites(t *testing.T) {
+	s, fs, dir := newTestStorage(t, func(rawOpts *RawStorageOptions, opts *Options) {
+		rawOpts.Checksummer = CanonicalChecksummer
+		opts.SkipEquivalentWrites = true
+	})
+
+	car := &v1alpha1.Car{Spec: v1alpha1.CarSpec{Brand: "Volvo"}}
+	car.SetName("foo")
+	car.SetNamespace("default")
+	if err := s.Create(car); err != nil {
+		t.Fatal(err)
+	}
+	key, err := s.ObjectKeyFor(car)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "car_default_foo.yaml")
+
+	// Rewriting the file as JSON doesn't change the canonical checksum
+	checksum, err := s.Checksum(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := fs.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reordered, err := serializer.Canonicalize(content, serializer.ContentTypeJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.WriteFile(path, reordered, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if newChecksum, err := s.Checksum(key); err != nil || newChecksum != checksum {
+		t.Errorf("expected the checksum to stay %q, got %q (%v)", checksum, newChecksum, err)
+	}
+
+	// Writing an equal object doesn't rewrite the file
+	obj, err := s.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Update(obj); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := fs.ReadFile(path); err != nil || string(content) != string(reordered) {
+		t.Errorf("expected the file not to be rewritten, got %q (%v)", content, err)
+	}
+
+	// Writing a changed object does
+	car.Spec.Engine = "v8"
+	if err := s.Update(car); err != nil {
+		t.Fatal(err)
+	}
+	if newChecksum, err := s.Checksum(key); err != nil || newChecksum == checksum {
+		t.Errorf("expected the checksum to change, got %q (%v)", newChecksum, err)
+	}
+}
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/weaveworks/libgitops/pkg/serializer"
+	"github.com/weaveworks/libgitops/pkg/util/filesystem"
+)
+
+// Compact tidies up the multi-document file at path in fs, e.g. one accumulating many objects of
+// the same kind. Every document is rewritten in its canonical form (see serializer.Canonicalize),
+// documents without content (e.g. only comments or null) are dropped, and so are the exact
+// duplicates of earlier documents. The remaining documents keep their order, and as only their
+// formatting changes, no object is dropped or changes its identity. The file is only rewritten
+// if its content changes. The content type of the file is decided by its extension.
+func Compact(ctx context.Context, fs filesystem.Filesystem, path string) error {
+	ct, ok := ContentTypes[filepath.Ext(path)]
+	if !ok {
+		return fmt.Errorf("can't compact %q: %w", path, serializer.ErrUnsupportedContentType)
+	}
+
+	fi, err := fs.Stat(path)
+	if err != nil {
+		return err
+	}
+	content, err := fs.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	fw := serializer.NewFrameWriter(ct, &buf)
+	fr := serializer.NewContextFrameReader(ctx, serializer.NewFrameReader(ct, serializer.FromBytes(content)))
+	defer fr.Close()
+
+	seen := make(map[string]bool)
+	for {
+		doc, err := fr.ReadFrame()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("can't compact %q: %w", path, err)
+		}
+
+		// The canonical JSON form tells whether the document is empty, or a duplicate
+		canonical, err := serializer.Canonicalize(doc, serializer.ContentTypeJSON)
+		if err != nil {
+			return fmt.Errorf("can't compact %q: %w", path, err)
+		}
+		key := string(bytes.TrimSpace(canonical))
+		if key == "null" || key == "{}" || seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		if ct != serializer.ContentTypeJSON {
+			if canonical, err = serializer.Canonicalize(canonical, ct); err != nil {
+				return fmt.Errorf("can't compact %q: %w", path, err)
+			}
+		}
+		if _, err := fw.Write(canonical); err != nil {
+			return err
+		}
+	}
+
+	if bytes.Equal(buf.Bytes(), content) {
+		return nil
+	}
+	return fs.WriteFile(path, buf.Bytes(), fi.Mode().Perm())
+}
@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/weaveworks/libgitops/pkg/util/filesystem"
+)
+
+func TestCompact(t *testing.T) {
+	input := `# A fragmented file
+---
+---
+apiVersion: sample-app.weave.works/v1alpha1
+kind: Car
+metadata:
+  namespace: default
+  name: foo
+  labels: {}
+spec: {brand: Volvo}
+---
+# Only a comment
+---
+null
+---
+apiVersion: sample-app.weave.works/v1alpha1
+kind: Car
+metadata:
+  name: bar
+  namespace: default
+---
+{"apiVersion": "sample-app.weave.works/v1alpha1", "kind": "Car", "metadata": {"name": "foo", "namespace": "default"}, "spec": {"brand": "Volvo"}}
+---
+apiVersion: sample-app.weave.works/v1alpha1
+kind: Car
+metadata:
+  name: foo
+  namespace: default
+spec:
+  brand: Saab
+`
+	// The duplicate of foo is dropped, but the conflicting declaration of foo is kept
+	expected := `apiVersion: sample-app.weave.works/v1alpha1
+kind: Car
+metadata:
+  name: foo
+  namespace: default
+spec:
+  brand: Volvo
+---
+apiVersion: sample-app.weave.works/v1alpha1
+kind: Car
+metadata:
+  name: bar
+  namespace: default
+---
+apiVersion: sample-app.weave.works/v1alpha1
+kind: Car
+metadata:
+  name: foo
+  namespace: default
+spec:
+  brand: Saab
+`
+
+	fs := filesystem.NewInMemory()
+	if err := fs.MkdirAll("/manifests", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.WriteFile("/manifests/cars.yaml", []byte(input), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, step := range []string{"compact", "compact again"} {
+		if err := Compact(context.Background(), fs, "/manifests/cars.yaml"); err != nil {
+			t.Fatal(err)
+		}
+		actual, err := fs.ReadFile("/manifests/cars.yaml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(actual) != expected {
+			t.Errorf("%s: expected\n%s\ngot\n%s", step, expected, actual)
+		}
+	}
+
+	if fi, err := fs.Stat("/manifests/cars.yaml"); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("expected the permissions to be kept, got %v (%v)", fi.Mode(), err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := Compact(ctx, fs, "/manifests/cars.yaml"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
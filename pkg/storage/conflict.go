@@ -0,0 +1,209 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"k8s.io/apimachinery/pkg/api/equality"
+	kruntime "k8s.io/apimachinery/pkg/runtime"
+)
+
+// ErrConflict is returned when an object was modified since it was read, and the
+// ConflictResolver couldn't merge the changes (see Options.ConflictResolver)
+var ErrConflict = errors.New("object has been modified since it was read")
+
+// ConflictResolver merges concurrent changes to an object, see Options.ConflictResolver
+type ConflictResolver interface {
+	// Resolve returns the object to write for key, given the stored object the write was based
+	// on (base), the object being written (ours), and the currently stored object (theirs).
+	// base is nil if the object it was based on isn't known anymore. If the changes can't be
+	// merged, Resolve returns an error wrapping ErrConflict, and the write is aborted.
+	Resolve(key ObjectKey, base, ours, theirs runtime.Object) (runtime.Object, error)
+}
+
+// ConflictResolverFunc implements ConflictResolver using a function
+type ConflictResolverFunc func(key ObjectKey, base, ours, theirs runtime.Object) (runtime.Object, error)
+
+var _ ConflictResolver = ConflictResolverFunc(nil)
+
+// Resolve implements ConflictResolver
+func (f ConflictResolverFunc) Resolve(key ObjectKey, base, ours, theirs runtime.Object) (runtime.Object, error) {
+	return f(key, base, ours, theirs)
+}
+
+var (
+	// RejectConflicts never merges any changes, which means that writes of objects
+	// modified since they were read always fail with ErrConflict
+	RejectConflicts ConflictResolver = ConflictResolverFunc(func(key ObjectKey, _, _, _ runtime.Object) (runtime.Object, error) {
+		return nil, fmt.Errorf("%s: %w", key, ErrConflict)
+	})
+
+	// MergeSpecKeepStatus merges the spec and metadata of the objects, and keeps the status of the
+	// object being written. A field is taken from the object changing it compared to base, which
+	// lets e.g. a controller writing the status of an object not revert concurrent changes to its
+	// spec. If both objects change the spec or the metadata differently, or base isn't known, the
+	// write is aborted with ErrConflict. The generation and resourceVersion are not merged.
+	MergeSpecKeepStatus ConflictResolver = ConflictResolverFunc(mergeSpecKeepStatus)
+)
+
+// mergeSpecKeepStatus implements MergeSpecKeepStatus
+func mergeSpecKeepStatus(key ObjectKey, base, ours, theirs runtime.Object) (runtime.Object, error) {
+	if base == nil {
+		return nil, fmt.Errorf("%s: the object the write was based on is unknown: %w", key, ErrConflict)
+	}
+
+	objs := make([]map[string]interface{}, 0, 3)
+	for _, obj := range []runtime.Object{base, ours, theirs} {
+		u, err := kruntime.DefaultUnstructuredConverter.ToUnstructured(obj)
+		if err != nil {
+			return nil, err
+		}
+
+		// These are managed by the GenericStorage, and always differ
+		if meta, ok := u["metadata"].(map[string]interface{}); ok {
+			delete(meta, "resourceVersion")
+			delete(meta, "generation")
+		}
+		objs = append(objs, u)
+	}
+
+	merged := objs[1]
+	for _, field := range []string{"metadata", "spec"} {
+		value, ok := mergeField(objs[0][field], objs[1][field], objs[2][field])
+		if !ok {
+			return nil, fmt.Errorf("%s: both writers changed the %s: %w", key, field, ErrConflict)
+		}
+
+		merged[field] = value
+	}
+
+	obj := reflect.New(reflect.TypeOf(ours).Elem()).Interface().(runtime.Object)
+	if err := kruntime.DefaultUnstructuredConverter.FromUnstructured(merged, obj); err != nil {
+		return nil, err
+	}
+
+	obj.SetGeneration(theirs.GetGeneration())
+	obj.SetResourceVersion(theirs.GetResourceVersion())
+	return obj, nil
+}
+
+// mergeField returns the value of a field changed in either ours or theirs compared to
+// base, or false if both changed it to different values
+func mergeField(base, ours, theirs interface{}) (interface{}, bool) {
+	switch {
+	case equality.Semantic.DeepEqual(ours, theirs), equality.Semantic.DeepEqual(base, theirs):
+		return ours, true
+	case equality.Semantic.DeepEqual(base, ours):
+		return theirs, true
+	}
+
+	return nil, false
+}
+
+// resolveConflict checks whether the stored object for key was modified since obj was read,
+// i.e. whether the resourceVersion of obj differs from the checksum of the file. If so, the
+// ConflictResolver is invoked, and obj is set to the merged object.
+func (s *GenericStorage) resolveConflict(key ObjectKey, obj runtime.Object) error {
+	version := obj.GetResourceVersion()
+	if s.opts.ConflictResolver == nil || !s.opts.ManageGeneration || len(version) == 0 {
+		return nil
+	}
+
+	checksum, err := s.raw.Checksum(key)
+	if err != nil || checksum == version {
+		return err
+	}
+
+	theirs, err := s.get(key)
+	if err != nil {
+		return err
+	}
+
+	var base runtime.Object
+	if content, ok := s.bases.get(key, version); ok {
+		if base, err = s.decode(key, content); err != nil {
+			return err
+		}
+	}
+
+	merged, err := s.opts.ConflictResolver.Resolve(key, base, obj, theirs)
+	if err != nil {
+		return err
+	}
+
+	// Let the caller see the object that was written, like for other writes
+	if reflect.TypeOf(merged) != reflect.TypeOf(obj) {
+		return fmt.Errorf("ConflictResolver returned a %T for a %T", merged, obj)
+	}
+	reflect.ValueOf(obj).Elem().Set(reflect.ValueOf(merged).Elem())
+	return nil
+}
+
+// trackBase remembers the content of the file for key at the given resourceVersion,
+// for it to be passed as the base to the ConflictResolver
+func (s *GenericStorage) trackBase(key ObjectKey, version string, content []byte) {
+	if s.opts.ConflictResolver != nil && s.opts.ManageGeneration {
+		s.bases.add(key, version, content)
+	}
+}
+
+// maxBases is the amount of versions of each object remembered by a baseCache
+const maxBases = 4
+
+// baseCache holds the content of the last read or written versions of each object
+type baseCache struct {
+	versions map[ObjectKey][]baseVersion
+	mux      sync.Mutex
+}
+
+// baseVersion is the content of the file of an object at a given resourceVersion
+type baseVersion struct {
+	version string
+	content []byte
+}
+
+func newBaseCache() *baseCache {
+	return &baseCache{versions: make(map[ObjectKey][]baseVersion)}
+}
+
+// add remembers the content for the given version, forgetting the oldest version if needed
+func (c *baseCache) add(key ObjectKey, version string, content []byte) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	versions := c.versions[key]
+	for _, v := range versions {
+		if v.version == version {
+			return
+		}
+	}
+
+	if len(versions) == maxBases {
+		versions = versions[1:]
+	}
+	c.versions[key] = append(versions, baseVersion{version, content})
+}
+
+// get returns the content for the given version, if it's known
+func (c *baseCache) get(key ObjectKey, version string) ([]byte, bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	for _, v := range c.versions[key] {
+		if v.version == version {
+			return v.content, true
+		}
+	}
+	return nil, false
+}
+
+// forget removes all versions of the given object
+func (c *baseCache) forget(key ObjectKey) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	delete(c.versions, key)
+}
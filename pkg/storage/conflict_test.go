@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+)
+
+func TestConflictResolver(t *testing.T) {
+	newStorage := func(resolver ConflictResolver) Storage {
+		s, _, _ := newTestStorage(t, manageGeneration, func(_ *RawStorageOptions, opts *Options) {
+			opts.ConflictResolver = resolver
+		})
+
+		car := &v1alpha1.Car{Spec: v1alpha1.CarSpec{Brand: "Volvo"}}
+		car.SetName("foo")
+		car.SetNamespace("default")
+		if err := s.Create(car); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	// read returns two copies of the stored car, as read by two concurrent writers
+	read := func(s Storage) (*v1alpha1.Car, *v1alpha1.Car) {
+		ours, err := s.Get(carKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		theirs, err := s.Get(carKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ours.(*v1alpha1.Car), theirs.(*v1alpha1.Car)
+	}
+	get := func(s Storage) *v1alpha1.Car {
+		obj, err := s.Get(carKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return obj.(*v1alpha1.Car)
+	}
+
+	t.Run("resolve", func(t *testing.T) {
+		s := newStorage(MergeSpecKeepStatus)
+		ours, theirs := read(s)
+
+		theirs.Spec.Brand = "Tesla"
+		if err := s.Update(theirs); err != nil {
+			t.Fatal(err)
+		}
+
+		// The status is kept, and the concurrent spec change isn't reverted
+		ours.Status.Speed = 100
+		if err := s.Update(ours); err != nil {
+			t.Fatal(err)
+		}
+
+		car := get(s)
+		if car.Spec.Brand != "Tesla" || car.Status.Speed != 100 {
+			t.Errorf("expected the changes to be merged, got brand %q and speed %v", car.Spec.Brand, car.Status.Speed)
+		}
+		if car.Generation != 2 {
+			t.Errorf("expected generation 2, got %d", car.Generation)
+		}
+		if ours.Spec.Brand != "Tesla" || ours.ResourceVersion != car.ResourceVersion {
+			t.Errorf("expected the written object to be merged, got brand %q and resourceVersion %q", ours.Spec.Brand, ours.ResourceVersion)
+		}
+	})
+
+	t.Run("abort", func(t *testing.T) {
+		s := newStorage(MergeSpecKeepStatus)
+		ours, theirs := read(s)
+
+		theirs.Spec.Brand = "Tesla"
+		if err := s.Update(theirs); err != nil {
+			t.Fatal(err)
+		}
+
+		ours.Spec.Brand = "Saab"
+		if err := s.Update(ours); !errors.Is(err, ErrConflict) {
+			t.Fatalf("expected ErrConflict, got %v", err)
+		}
+		if car := get(s); car.Spec.Brand != "Tesla" {
+			t.Errorf("expected the aborted write to not change the file, got brand %q", car.Spec.Brand)
+		}
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		s := newStorage(RejectConflicts)
+		ours, theirs := read(s)
+
+		theirs.Status.Speed = 50
+		if err := s.Update(theirs); err != nil {
+			t.Fatal(err)
+		}
+
+		ours.Spec.Brand = "Saab"
+		if err := s.Update(ours); !errors.Is(err, ErrConflict) {
+			t.Fatalf("expected ErrConflict, got %v", err)
+		}
+
+		// Writes based on the current version succeed
+		car := get(s)
+		car.Spec.Brand = "Saab"
+		if err := s.Update(car); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
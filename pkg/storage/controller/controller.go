@@ -0,0 +1,166 @@
+package controller
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/weaveworks/libgitops/pkg/logs"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/storage/watch"
+	"github.com/weaveworks/libgitops/pkg/storage/watch/update"
+)
+
+// Reconciler reconciles the object with the given key. It's called for created, modified and
+// deleted objects alike, and should get the current state of the object from the storage. If
+// the object has been deleted, the storage returns storage.ErrNotFound.
+type Reconciler interface {
+	// Reconcile reconciles the object with the given key. If an error is returned,
+	// the object is reconciled again after a delay.
+	Reconcile(ctx context.Context, key storage.ObjectKey) error
+}
+
+// ReconcilerFunc implements Reconciler using a function
+type ReconcilerFunc func(ctx context.Context, key storage.ObjectKey) error
+
+var _ Reconciler = ReconcilerFunc(nil)
+
+// Reconcile implements Reconciler
+func (f ReconcilerFunc) Reconcile(ctx context.Context, key storage.ObjectKey) error {
+	return f(ctx, key)
+}
+
+// Options specifies options for the Controller. The zero value of each field means its default value.
+type Options struct {
+	// Workers is the number of objects reconciled concurrently. The same object is
+	// never reconciled by more than one worker at a time. (Default: 1)
+	Workers int
+	// BaseDelay is the delay before an object is reconciled again after its first failure.
+	// The delay doubles for every consecutive failure of the object. (Default: 5ms)
+	BaseDelay time.Duration
+	// MaxDelay is the maximum delay before a failed object is reconciled again. (Default: 5m)
+	MaxDelay time.Duration
+	// UpdateStreamSize is the buffer size of the UpdateStream set for the EventStorage. (Default: 4096)
+	UpdateStreamSize int
+	// Logger receives the logs of the Controller, with the eventType of the event or the kind and
+	// objectID of the object concerned as key/value pairs. (Default: nil, which logs to logs.Logger,
+	// see logs.NewLogger)
+	Logger logr.Logger
+}
+
+// DefaultOptions returns the default options for the Controller
+func DefaultOptions() Options {
+	return Options{
+		Workers:          1,
+		BaseDelay:        5 * time.Millisecond,
+		MaxDelay:         5 * time.Minute,
+		UpdateStreamSize: 4096,
+	}
+}
+
+// Controller calls a Reconciler for the objects of the events sent by an EventStorage. Events
+// for an object waiting to be reconciled coalesce, and failed objects are retried with an
+// exponential backoff.
+type Controller struct {
+	updates    update.UpdateStream
+	reconciler Reconciler
+	opts       Options
+	queue      *queue
+	logger     logr.Logger
+}
+
+// New creates a new Controller for the given EventStorage and Reconciler. The Controller
+// sets the UpdateStream of the EventStorage, so it can't be consumed by anyone else.
+func New(s update.EventStorage, r Reconciler) *Controller {
+	return NewWithOptions(s, r, DefaultOptions())
+}
+
+// NewWithOptions is the same as New, but allows customizing the Controller using the given Options.
+// The zero or negative fields of opts are set to the ones of DefaultOptions.
+func NewWithOptions(s update.EventStorage, r Reconciler, opts Options) *Controller {
+	defaults := DefaultOptions()
+	if opts.Workers < 1 {
+		opts.Workers = defaults.Workers
+	}
+	if opts.BaseDelay <= 0 {
+		opts.BaseDelay = defaults.BaseDelay
+	}
+	if opts.MaxDelay <= 0 {
+		opts.MaxDelay = defaults.MaxDelay
+	}
+	if opts.UpdateStreamSize <= 0 {
+		opts.UpdateStreamSize = defaults.UpdateStreamSize
+	}
+
+	// Set the UpdateStream right away, so no events are lost before Run is called
+	updates := make(update.UpdateStream, opts.UpdateStreamSize)
+	s.SetUpdateStream(updates)
+
+	return &Controller{updates, r, opts, newQueue(), logs.OrDefault(opts.Logger).WithName("Controller")}
+}
+
+// Run reconciles the objects of the events sent by the EventStorage until ctx is
+// cancelled. Run blocks until the running reconciliations have returned.
+func (c *Controller) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < c.opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.work(ctx)
+		}()
+	}
+
+	for {
+		select {
+		case upd := <-c.updates:
+			if upd.Event == update.ObjectEventSync {
+				continue
+			}
+
+			key, err := keyFor(upd)
+			if err != nil {
+				c.logger.Error(err, "Failed to get the key of the event", "eventType", upd.Event)
+				continue
+			}
+			c.queue.add(key)
+		case <-ctx.Done():
+			c.queue.shutDown()
+			wg.Wait()
+			return
+		}
+	}
+}
+
+// work reconciles the queued objects until the queue is shut down
+func (c *Controller) work(ctx context.Context) {
+	for {
+		key, shutdown := c.queue.get()
+		if shutdown {
+			return
+		}
+
+		if err := c.reconciler.Reconcile(ctx, key); err != nil {
+			delay := c.queue.backoff(key, c.opts.BaseDelay, c.opts.MaxDelay)
+			c.logger.Error(err, "Failed to reconcile, retrying", "kind", key.GetKind(), "objectID", key.GetIdentifier(), "delay", delay)
+			c.queue.addAfter(key, delay)
+		} else {
+			c.queue.forget(key)
+		}
+
+		c.queue.done(key)
+	}
+}
+
+// keyFor returns the key of the object of the given Update. DELETE events carry
+// the identifier of the object as its UID (see watch.EventDeleteObjectName).
+func keyFor(upd update.Update) (storage.ObjectKey, error) {
+	if upd.Event != update.ObjectEventDelete || upd.PartialObject.GetName() != watch.EventDeleteObjectName {
+		return upd.Storage.ObjectKeyFor(upd.PartialObject)
+	}
+
+	gvk := upd.PartialObject.GetObjectKind().GroupVersionKind()
+	return storage.NewObjectKey(storage.NewKindKey(gvk), runtime.NewIdentifier(string(upd.PartialObject.GetUID()))), nil
+}
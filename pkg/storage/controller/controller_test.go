@@ -0,0 +1,175 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/scheme"
+	"github.com/weaveworks/libgitops/pkg/logs"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/storage/watch"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var carKind = storage.NewKindKey(schema.GroupVersionKind{Group: "sample-app.weave.works", Version: "v1alpha1", Kind: "Car"})
+
+func TestRequeueOnError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "controller-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := watch.NewManifestStorage(dir, scheme.Serializer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	var buf bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&buf)
+
+	var mux sync.Mutex
+	var calls []storage.ObjectKey
+	succeeded := make(chan struct{})
+	c := NewWithOptions(s, ReconcilerFunc(func(ctx context.Context, key storage.ObjectKey) error {
+		mux.Lock()
+		defer mux.Unlock()
+
+		calls = append(calls, key)
+		if len(calls) < 3 {
+			return errors.New("not yet")
+		}
+		close(succeeded)
+		return nil
+	}), Options{Logger: logs.NewLogger(logger)})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.Run(ctx)
+		close(done)
+	}()
+
+	content := "apiVersion: sample-app.weave.works/v1alpha1\nkind: Car\nmetadata:\n  name: foo\n  namespace: default\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "foo.yaml"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-succeeded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the reconciliation to succeed")
+	}
+
+	// The object isn't reconciled again after succeeding
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	<-done
+
+	expected := storage.NewObjectKey(carKind, runtime.NewIdentifier("default/foo"))
+	if len(calls) != 3 {
+		t.Fatalf("expected 3 reconciliations, got %d", len(calls))
+	}
+	for _, key := range calls {
+		if key != expected {
+			t.Errorf("expected %s to be reconciled, got %s", expected, key)
+		}
+	}
+	// The failures are logged to the given Logger
+	if output := buf.String(); strings.Count(output, "Controller: Failed to reconcile, retrying") != 2 || !strings.Contains(output, "error=\"not yet\"") {
+		t.Errorf("expected the failures to be logged, got %q", output)
+	}
+}
+
+func TestQueueDedup(t *testing.T) {
+	q := newQueue()
+	keys := make([]storage.ObjectKey, 2)
+	for i := range keys {
+		keys[i] = storage.NewObjectKey(carKind, runtime.NewIdentifier(fmt.Sprintf("default/car%d", i)))
+	}
+
+	// Duplicate keys coalesce while they're queued
+	q.add(keys[0])
+	q.add(keys[1])
+	q.add(keys[0])
+
+	key, _ := q.get()
+	if key != keys[0] {
+		t.Fatalf("expected %s, got %s", keys[0], key)
+	}
+
+	// Keys added while they're processed are queued again when done
+	q.add(keys[0])
+	q.add(keys[0])
+	if key, _ := q.get(); key != keys[1] {
+		t.Fatalf("expected %s, got %s", keys[1], key)
+	}
+	q.done(keys[1])
+	q.done(keys[0])
+
+	if key, _ := q.get(); key != keys[0] {
+		t.Fatalf("expected %s, got %s", keys[0], key)
+	}
+	q.done(keys[0])
+	if len(q.keys) != 0 {
+		t.Errorf("expected no more queued keys, got %v", q.keys)
+	}
+
+	q.shutDown()
+	if _, shutdown := q.get(); !shutdown {
+		t.Error("expected get to return after the shutdown")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	q := newQueue()
+	key := storage.NewObjectKey(carKind, runtime.NewIdentifier("default/foo"))
+	for _, expected := range []time.Duration{1, 2, 4, 5, 5} {
+		if delay := q.backoff(key, 1, 5); delay != expected {
+			t.Errorf("expected %d, got %d", expected, delay)
+		}
+	}
+
+	q.forget(key)
+	if delay := q.backoff(key, 1, 5); delay != 1 {
+		t.Errorf("expected the delay to be reset, got %d", delay)
+	}
+}
+
+func TestOptionDefaults(t *testing.T) {
+	dir, err := ioutil.TempDir("", "controller-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := watch.NewManifestStorage(dir, scheme.Serializer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// The zero fields are set to the defaults, so that the workers are started
+	c := NewWithOptions(s, ReconcilerFunc(nil), Options{MaxDelay: time.Minute})
+	expected := DefaultOptions()
+	expected.MaxDelay = time.Minute
+	if c.opts != expected {
+		t.Errorf("expected options %+v, got %+v", expected, c.opts)
+	}
+	if cap(c.updates) != expected.UpdateStreamSize {
+		t.Errorf("expected an update stream of size %d, got %d", expected.UpdateStreamSize, cap(c.updates))
+	}
+}
@@ -0,0 +1,124 @@
+package controller
+
+import (
+	"sync"
+	"time"
+
+	"github.com/weaveworks/libgitops/pkg/storage"
+)
+
+// queue is a work queue of object keys. A key is queued at most once, so duplicate events for
+// the same object coalesce. Keys added while they're being processed are queued again once the
+// processing is done, so the same object is never processed concurrently.
+type queue struct {
+	// keys holds the queued keys in order
+	keys []storage.ObjectKey
+	// queued holds the keys waiting in keys, or to be queued when their processing is done
+	queued map[storage.ObjectKey]bool
+	// processing holds the keys returned by get, until done is called for them
+	processing map[storage.ObjectKey]bool
+	// failures holds the amount of consecutive failures of each key, see backoff
+	failures map[storage.ObjectKey]int
+	shutdown bool
+	mux      sync.Mutex
+	cond     *sync.Cond
+}
+
+func newQueue() *queue {
+	q := &queue{
+		queued:     make(map[storage.ObjectKey]bool),
+		processing: make(map[storage.ObjectKey]bool),
+		failures:   make(map[storage.ObjectKey]int),
+	}
+	q.cond = sync.NewCond(&q.mux)
+	return q
+}
+
+// add queues the given key, unless it's already queued
+func (q *queue) add(key storage.ObjectKey) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	if q.shutdown || q.queued[key] {
+		return
+	}
+
+	q.queued[key] = true
+	if q.processing[key] {
+		return // Queued by done
+	}
+
+	q.keys = append(q.keys, key)
+	q.cond.Signal()
+}
+
+// addAfter queues the given key after the given delay
+func (q *queue) addAfter(key storage.ObjectKey, delay time.Duration) {
+	time.AfterFunc(delay, func() { q.add(key) })
+}
+
+// get blocks until a key is queued, and returns it. shutdown is true if the queue has
+// been shut down, in which case no key is returned. done must be called for the key.
+func (q *queue) get() (key storage.ObjectKey, shutdown bool) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	for len(q.keys) == 0 && !q.shutdown {
+		q.cond.Wait()
+	}
+	if q.shutdown {
+		return nil, true
+	}
+
+	key, q.keys = q.keys[0], q.keys[1:]
+	delete(q.queued, key)
+	q.processing[key] = true
+	return key, false
+}
+
+// done marks the processing of the given key as done, and queues it again if it was added meanwhile
+func (q *queue) done(key storage.ObjectKey) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	delete(q.processing, key)
+	if q.queued[key] && !q.shutdown {
+		q.keys = append(q.keys, key)
+		q.cond.Signal()
+	}
+}
+
+// backoff registers a failure of the given key, and returns the delay before it should be retried.
+// The delay starts at base, and doubles for every consecutive failure, up to max.
+func (q *queue) backoff(key storage.ObjectKey, base, max time.Duration) time.Duration {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	delay := base
+	for i := 0; i < q.failures[key] && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+
+	q.failures[key]++
+	return delay
+}
+
+// forget resets the consecutive failures of the given key
+func (q *queue) forget(key storage.ObjectKey) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	delete(q.failures, key)
+}
+
+// shutDown makes all current and future calls to get return immediately
+func (q *queue) shutDown() {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	q.shutdown = true
+	q.cond.Broadcast()
+}
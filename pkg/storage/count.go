@@ -0,0 +1,79 @@
+package storage
+
+// KeyCounter is implemented by RawStorages which can count their keys without listing them
+type KeyCounter interface {
+	// CountKeys returns the amount of keys of the given kind in the given namespace
+	CountKeys(kind KindKey, namespace string) int
+	// CountAllKeys returns the amount of keys of the given kind in all namespaces
+	CountAllKeys(kind KindKey) int
+}
+
+var _ KeyCounter = &GenericMappedRawStorage{}
+
+// Count counts the Objects for the specific kind
+func (s *GenericStorage) Count(kind KindKey) (uint64, error) {
+	if counter, ok := s.raw.(KeyCounter); ok {
+		return uint64(counter.CountAllKeys(kind)), nil
+	}
+
+	entries, err := s.raw.List(kind)
+	return uint64(len(entries)), err
+}
+
+// CountNamespace counts the Objects for the specific kind in the given namespace
+func (s *GenericStorage) CountNamespace(kind KindKey, namespace string) (uint64, error) {
+	if err := s.validateNamespace(kind, namespace); err != nil {
+		return 0, err
+	}
+
+	if counter, ok := s.raw.(KeyCounter); ok {
+		return uint64(counter.CountKeys(kind, namespace)), nil
+	}
+
+	entries, err := s.raw.List(kind)
+	if err != nil {
+		return 0, err
+	}
+
+	var count uint64
+	for _, key := range entries {
+		if ns, _ := splitIdentifier(key); ns == namespace {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// CountKeys returns the amount of mapped keys of the given kind in the given namespace,
+// without building a list of them
+func (r *GenericMappedRawStorage) CountKeys(kind KindKey, namespace string) int {
+	return r.countKeys(kind, func(key ObjectKey) bool {
+		ns, _ := splitIdentifier(key)
+		return ns == namespace
+	})
+}
+
+// CountAllKeys returns the amount of mapped keys of the given kind in all namespaces,
+// without building a list of them
+func (r *GenericMappedRawStorage) CountAllKeys(kind KindKey) int {
+	return r.countKeys(kind, func(ObjectKey) bool { return true })
+}
+
+// countKeys counts the mapped keys of the given kind matching fn
+func (r *GenericMappedRawStorage) countKeys(kind KindKey, fn func(key ObjectKey) bool) (count int) {
+	m := r.mappingsFor(kind, false)
+	if m == nil {
+		return
+	}
+
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+
+	// Like in List, the mappings include all versions of the kind
+	for key := range m.paths {
+		if fn(key) {
+			count++
+		}
+	}
+	return
+}
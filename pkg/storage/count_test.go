@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+)
+
+func TestCount(t *testing.T) {
+	s, _, _ := newTestStorage(t)
+	kind := NewKindKey(carGVK)
+
+	expect := func(step string, all, defaultNs, otherNs uint64) {
+		for namespace, expected := range map[string]uint64{"default": defaultNs, "other": otherNs} {
+			if count, err := s.CountNamespace(kind, namespace); err != nil || count != expected {
+				t.Errorf("%s: expected %d cars in %s, got %d (%v)", step, expected, namespace, count, err)
+			}
+		}
+		if count, err := s.Count(kind); err != nil || count != all {
+			t.Errorf("%s: expected %d cars, got %d (%v)", step, all, count, err)
+		}
+	}
+	expect("empty", 0, 0, 0)
+
+	var keys []ObjectKey
+	for _, id := range [][2]string{{"default", "foo"}, {"default", "bar"}, {"other", "foo"}} {
+		car := &v1alpha1.Car{}
+		car.SetNamespace(id[0])
+		car.SetName(id[1])
+		if err := s.Create(car); err != nil {
+			t.Fatal(err)
+		}
+		key, err := s.ObjectKeyFor(car)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+	expect("created", 3, 2, 1)
+
+	if err := s.Delete(keys[0]); err != nil {
+		t.Fatal(err)
+	}
+	expect("deleted", 2, 1, 1)
+}
@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/weaveworks/libgitops/pkg/runtime"
+)
+
+// decodeCache is an LRU cache of the decoded objects, by the checksums of their files
+// (see Options.DecodeCacheSize). Every object is cached for one checksum at most, so an
+// entry is replaced as soon as the checksum of its file changes.
+type decodeCache struct {
+	size    int
+	entries map[ObjectKey]*list.Element
+	// lru holds the *decodeCacheEntries, the most recently used first
+	lru *list.List
+	mux sync.Mutex
+}
+
+// decodeCacheEntry is the object decoded from the content of a file with the given checksum
+type decodeCacheEntry struct {
+	key      ObjectKey
+	checksum string
+	obj      runtime.Object
+	content  []byte
+}
+
+// newDecodeCache returns a decodeCache holding up to size objects, zero disables it
+func newDecodeCache(size int) *decodeCache {
+	return &decodeCache{size: size, entries: make(map[ObjectKey]*list.Element), lru: list.New()}
+}
+
+// enabled returns true if objects are cached
+func (c *decodeCache) enabled() bool {
+	return c.size > 0
+}
+
+// get returns a copy of the cached object for key, and the content it was decoded from,
+// if the object was decoded from a file with the given checksum
+func (c *decodeCache) get(key ObjectKey, checksum string) (runtime.Object, []byte, bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, nil, false
+	}
+
+	entry := elem.Value.(*decodeCacheEntry)
+	if entry.checksum != checksum {
+		// The file has changed, so the object will be decoded again
+		c.remove(elem)
+		return nil, nil, false
+	}
+
+	c.lru.MoveToFront(elem)
+	// Hand out copies, so that callers modifying the object don't modify the cache
+	return entry.obj.DeepCopyObject().(runtime.Object), entry.content, true
+}
+
+// add caches a copy of the object decoded from the given content with the given checksum,
+// and evicts the least recently used object if the cache is full
+func (c *decodeCache) add(key ObjectKey, checksum string, obj runtime.Object, content []byte) {
+	if !c.enabled() {
+		return
+	}
+
+	entry := &decodeCacheEntry{key, checksum, obj.DeepCopyObject().(runtime.Object), content}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+
+	if c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+	}
+}
+
+// forget removes the cached object for key, if any
+func (c *decodeCache) forget(key ObjectKey) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+}
+
+// remove removes the given element, c.mux must be held
+func (c *decodeCache) remove(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.entries, elem.Value.(*decodeCacheEntry).key)
+}
+
+// getCached returns the cached object for key if its file is unchanged, see Options.DecodeCacheSize.
+// The returned checksum is passed to cacheDecoded if the object has to be decoded instead.
+func (s *GenericStorage) getCached(key ObjectKey) (obj runtime.Object, checksum string, ok bool) {
+	if !s.decoded.enabled() {
+		return nil, "", false
+	}
+
+	// If the checksum can't be computed, e.g. because the file doesn't exist, let Read report it
+	checksum, err := s.raw.Checksum(key)
+	if err != nil {
+		return nil, "", false
+	}
+
+	obj, content, ok := s.decoded.get(key, checksum)
+	if ok {
+		s.trackBase(key, obj.GetResourceVersion(), content)
+	}
+	return obj, checksum, ok
+}
+
+// cacheDecoded caches the object decoded from the given content, if the file still has the checksum
+// returned by getCached. Otherwise it has been modified while decoding, and the object might be stale.
+func (s *GenericStorage) cacheDecoded(key ObjectKey, checksum string, obj runtime.Object, content []byte) {
+	if len(checksum) == 0 {
+		return
+	}
+
+	if current, err := s.raw.Checksum(key); err == nil && current == checksum {
+		s.decoded.add(key, checksum, obj, content)
+	}
+}
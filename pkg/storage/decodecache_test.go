@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/weaveworks/libgitops/pkg/util/filesystem"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// newDecodeCacheStorage returns a storage caching size objects in memory, which
+// records its spans in the returned exporter to tell when objects are decoded
+func newDecodeCacheStorage(tb testing.TB, size int) (Storage, filesystem.Filesystem, string, *memoryExporter) {
+	exporter := &memoryExporter{}
+	s, fs, dir := newTestStorage(tb, func(rawOpts *RawStorageOptions, opts *Options) {
+		rawOpts.Checksummer = filesystem.SHA256Checksummer
+		opts.DecodeCacheSize = size
+		opts.TracerProvider = sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	})
+	return s, fs, dir, exporter
+}
+
+// decodes returns the amount of objects decoded since the last call
+func decodes(exporter *memoryExporter) int {
+	n := 0
+	for _, span := range exporter.reset() {
+		if span.Name() == "GenericStorage.decode" {
+			n++
+		}
+	}
+	return n
+}
+
+func createTestCar(t *testing.T, s Storage, name string) ObjectKey {
+	car := &v1alpha1.Car{Spec: v1alpha1.CarSpec{Brand: "Volvo"}}
+	car.SetName(name)
+	car.SetNamespace("default")
+	if err := s.Create(car); err != nil {
+		t.Fatal(err)
+	}
+	key, err := s.ObjectKeyFor(car)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestDecodeCache(t *testing.T) {
+	s, fs, dir, exporter := newDecodeCacheStorage(t, 2)
+	key := createTestCar(t, s, "foo")
+	exporter.reset()
+
+	get := func(step string, expectedDecodes int, expectedBrand string) *v1alpha1.Car {
+		obj, err := s.Get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		car := obj.(*v1alpha1.Car)
+		if car.Spec.Brand != expectedBrand {
+			t.Errorf("%s: expected brand %q, got %q", step, expectedBrand, car.Spec.Brand)
+		}
+		if n := decodes(exporter); n != expectedDecodes {
+			t.Errorf("%s: expected %d decodes, got %d", step, expectedDecodes, n)
+		}
+		return car
+	}
+
+	get("first get", 1, "Volvo").Spec.Brand = "modified by the caller"
+	// The cached object isn't affected by modifying the returned copy
+	get("unchanged file", 0, "Volvo")
+
+	// A changed file busts the cache
+	content := "apiVersion: sample-app.weave.works/v1alpha1\nkind: Car\nmetadata:\n  name: foo\n  namespace: default\nspec:\n  brand: Saab\n"
+	if err := fs.WriteFile(filepath.Join(dir, "car_default_foo.yaml"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	get("changed file", 1, "Saab")
+	get("unchanged again", 0, "Saab")
+
+	// Writes change the checksum as well
+	car := get("before update", 0, "Saab")
+	car.Spec.Brand = "Tesla"
+	if err := s.Update(car); err != nil {
+		t.Fatal(err)
+	}
+	get("after update", 1, "Tesla")
+
+	// List uses the cache, too
+	if _, err := s.List(key); err != nil {
+		t.Fatal(err)
+	}
+	if n := decodes(exporter); n != 0 {
+		t.Errorf("expected List to use the cache, got %d decodes", n)
+	}
+
+	// The least recently used object is evicted
+	bar, baz := createTestCar(t, s, "bar"), createTestCar(t, s, "baz")
+	for _, k := range []ObjectKey{bar, baz} {
+		if _, err := s.Get(k); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exporter.reset()
+	get("evicted", 1, "Tesla")
+
+	if err := s.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(key); err == nil {
+		t.Error("expected deleted objects not to be returned from the cache")
+	}
+}
+
+func TestDecodeCacheDisabled(t *testing.T) {
+	s, _, _, exporter := newDecodeCacheStorage(t, 0)
+	key := createTestCar(t, s, "foo")
+	exporter.reset()
+
+	for i := 0; i < 3; i++ {
+		if _, err := s.Get(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := decodes(exporter); n != 3 {
+		t.Errorf("expected every Get to decode the object, got %d decodes", n)
+	}
+}
+
+// BenchmarkDecodeCache measures repeated Gets of an unchanged object with and without the cache
+func BenchmarkDecodeCache(b *testing.B) {
+	for _, size := range []int{0, 100} {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			s, _, _, _ := newDecodeCacheStorage(b, size)
+			// Don't record the spans of the benchmark
+			s = WithContext(s, context.Background())
+			s.(*GenericStorage).opts.TracerProvider = nil
+
+			car := &v1alpha1.Car{Spec: v1alpha1.CarSpec{Brand: "Volvo"}}
+			car.SetName("foo")
+			car.SetNamespace("default")
+			if err := s.Create(car); err != nil {
+				b.Fatal(err)
+			}
+			key, err := s.ObjectKeyFor(car)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := s.Get(key); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package encbackend
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/weaveworks/libgitops/pkg/storage"
+)
+
+// header prefixes all encrypted contents, followed by the ID of the key and the
+// base64-encoded nonce and ciphertext, e.g. "libgitops:aes-gcm:<key ID>:<data>"
+const header = "libgitops:aes-gcm:"
+
+var (
+	// ErrNotEncrypted is returned when reading contents that were not written by a Backend
+	ErrNotEncrypted = errors.New("content is not encrypted")
+	// ErrUnknownKey is returned by KeyProviders for unknown key IDs
+	ErrUnknownKey = errors.New("unknown encryption key")
+)
+
+// KeyProvider provides the AES keys used by the Backend. The keys must be 16, 24 or 32 bytes
+// long, selecting AES-128, AES-192 or AES-256. Contents are always encrypted with the current
+// key, but can be decrypted with any key known by ID, which allows rotating the keys.
+type KeyProvider interface {
+	// CurrentKey returns the ID and the key to encrypt new contents with
+	CurrentKey() (id string, key []byte, err error)
+	// Key returns the key with the given ID, or ErrUnknownKey
+	Key(id string) ([]byte, error)
+}
+
+// NewStaticKeyProvider returns a KeyProvider for a single key with the given ID
+func NewStaticKeyProvider(id string, key []byte) KeyProvider {
+	return &staticKeyProvider{id, key}
+}
+
+type staticKeyProvider struct {
+	id  string
+	key []byte
+}
+
+func (p *staticKeyProvider) CurrentKey() (string, []byte, error) {
+	return p.id, p.key, nil
+}
+
+func (p *staticKeyProvider) Key(id string) ([]byte, error) {
+	if id != p.id {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, id)
+	}
+	return p.key, nil
+}
+
+// New returns a Backend wrapping the given RawStorage, encrypting all contents written to it
+// with AES-GCM, using the keys of the given KeyProvider. The encryption is transparent to the
+// Storage using the Backend, as the contents are decrypted when read, before they are decoded.
+// The ciphertext is bound to the key of the object, so it can't be moved to another object. The
+// API version isn't part of the binding, so the content can still be read after a version bump.
+func New(inner storage.RawStorage, keys KeyProvider) *Backend {
+	return &Backend{inner, keys}
+}
+
+// Backend is a RawStorage encrypting the contents of the wrapped RawStorage at rest
+type Backend struct {
+	storage.RawStorage
+	keys KeyProvider
+}
+
+var _ storage.RawStorage = &Backend{}
+
+// Read reads and decrypts the content of the object. Content not written by a Backend
+// can't be read, ErrNotEncrypted is returned instead.
+func (b *Backend) Read(key storage.ObjectKey) ([]byte, error) {
+	content, err := b.RawStorage.Read(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.decrypt(key, content)
+}
+
+// Write encrypts the content with the current key, and writes it to the wrapped RawStorage
+func (b *Backend) Write(key storage.ObjectKey, content []byte) error {
+	encrypted, err := b.encrypt(key, content)
+	if err != nil {
+		return err
+	}
+
+	return b.RawStorage.Write(key, encrypted)
+}
+
+func (b *Backend) encrypt(key storage.ObjectKey, content []byte) ([]byte, error) {
+	id, k, err := b.keys.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	if strings.Contains(id, ":") {
+		return nil, fmt.Errorf("invalid key ID %q: must not contain a colon", id)
+	}
+
+	aead, err := newAEAD(k)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	sealed := aead.Seal(nonce, nonce, content, additionalData(key))
+
+	return []byte(header + id + ":" + base64.StdEncoding.EncodeToString(sealed) + "\n"), nil
+}
+
+func (b *Backend) decrypt(key storage.ObjectKey, content []byte) ([]byte, error) {
+	content = bytes.TrimSpace(content)
+	if !bytes.HasPrefix(content, []byte(header)) {
+		return nil, fmt.Errorf("%s: %w", key, ErrNotEncrypted)
+	}
+
+	parts := strings.SplitN(string(content[len(header):]), ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("%s: malformed encrypted content", key)
+	}
+	k, err := b.keys.Key(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%s: malformed encrypted content: %w", key, err)
+	}
+
+	aead, err := newAEAD(k)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("%s: malformed encrypted content", key)
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, additionalData(key))
+	if err != nil {
+		// Content written before the binding ignored the version is bound to the versioned key
+		var legacyErr error
+		if plaintext, legacyErr = aead.Open(nil, nonce, ciphertext, []byte(key.String())); legacyErr != nil {
+			return nil, fmt.Errorf("failed to decrypt %s: %w", key, err)
+		}
+	}
+	return plaintext, nil
+}
+
+// newAEAD returns an AES-GCM AEAD for the given key
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// additionalData binds the ciphertext to the given key, regardless of its version
+func additionalData(key storage.ObjectKey) []byte {
+	return []byte(storage.FormatObjectKey(key))
+}
@@ -0,0 +1,98 @@
+package encbackend
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/scheme"
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/util/filesystem"
+)
+
+func TestBackend(t *testing.T) {
+	fs := filesystem.NewInMemory()
+	dir, err := filepath.Abs("manifests")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := storage.DefaultRawStorageOptions()
+	opts.Filesystem = fs
+	opts.FileLayout = storage.FlatLayout
+	raw := storage.NewGenericMappedRawStorageWithOptions(dir, opts)
+	keys := NewStaticKeyProvider("test", bytes.Repeat([]byte{1}, 32))
+	s := storage.NewGenericStorage(New(raw, keys), scheme.Serializer, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier})
+
+	car := &v1alpha1.Car{Spec: v1alpha1.CarSpec{Brand: "Volvo"}}
+	car.SetName("foo")
+	car.SetNamespace("default")
+	if err := s.Create(car); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file on disk only contains ciphertext
+	content, err := fs.ReadFile(filepath.Join(dir, "car_default_foo.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(content, []byte(header+"test:")) || bytes.Contains(content, []byte("Volvo")) {
+		t.Errorf("expected the file to be encrypted, got %q", content)
+	}
+
+	key, err := s.ObjectKeyFor(car)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := s.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if brand := obj.(*v1alpha1.Car).Spec.Brand; brand != "Volvo" {
+		t.Errorf("expected the decrypted Car to have brand %q, got %q", "Volvo", brand)
+	}
+
+	// The ciphertext can't be decrypted with another key, or for another object
+	other := New(raw, NewStaticKeyProvider("test", bytes.Repeat([]byte{2}, 32)))
+	if _, err := other.Read(key); err == nil {
+		t.Error("expected decrypting with the wrong key to fail")
+	}
+	if _, err := New(raw, NewStaticKeyProvider("other", bytes.Repeat([]byte{1}, 32))).Read(key); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("expected ErrUnknownKey, got %v", err)
+	}
+	movedKey := storage.NewObjectKey(key, runtime.NewIdentifier("default/bar"))
+	if _, err := New(raw, keys).decrypt(movedKey, content); err == nil {
+		t.Error("expected decrypting the content of another object to fail")
+	}
+
+	// The content can be decrypted through a key of another version, e.g. after a version bump
+	gvk := key.GetGVK()
+	gvk.Version = "v1alpha2"
+	otherVersion := storage.NewObjectKey(storage.NewKindKey(gvk), key)
+	if plaintext, err := New(raw, keys).decrypt(otherVersion, content); err != nil || !bytes.Contains(plaintext, []byte("Volvo")) {
+		t.Errorf("expected the content to be decrypted for another version, got %q (%v)", plaintext, err)
+	}
+
+	// Content bound to the versioned key by earlier versions can still be decrypted
+	aead, err := newAEAD(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	legacy := header + "test:" + base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte("legacy"), []byte(key.String())))
+	if plaintext, err := New(raw, keys).decrypt(key, []byte(legacy)); err != nil || string(plaintext) != "legacy" {
+		t.Errorf("expected the legacy content to be decrypted, got %q (%v)", plaintext, err)
+	}
+
+	// Plaintext content is rejected
+	if err := raw.Write(key, []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(key); !errors.Is(err, ErrNotEncrypted) {
+		t.Errorf("expected ErrNotEncrypted, got %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"context"
+	"io"
+
+	"github.com/weaveworks/libgitops/pkg/filter"
+	"github.com/weaveworks/libgitops/pkg/serializer"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Export writes all objects of the given GroupKind in s to w, as a stream of frames of the given
+// ContentType. The objects are read and written one-by-one, sorted by their namespaces and names, so that
+// they're never all held in memory. Optionally, filters can be applied (e.g. filter.NamespaceFilter{}),
+// which are consulted for every object separately. The export stops if ctx is cancelled.
+func Export(ctx context.Context, s Storage, w io.Writer, gk schema.GroupKind, ct serializer.ContentType, opts ...filter.ListOption) error {
+	o, err := filter.MakeListOptions(opts...)
+	if err != nil {
+		return err
+	}
+
+	// The version is ignored when listing
+	keys, err := s.RawStorage().List(NewKindKey(gk.WithVersion("")))
+	if err != nil {
+		return err
+	}
+	SortKeys(keys)
+
+	fw := serializer.NewFrameWriter(ct, w)
+	encoder := s.Serializer().Encoder()
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		obj, err := s.Get(key)
+		if err != nil {
+			return err
+		}
+
+		objs, err := applyFilters(o.Filters, obj)
+		if err != nil {
+			return err
+		}
+		if len(objs) == 0 {
+			continue
+		}
+
+		if err := encoder.Encode(fw, obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
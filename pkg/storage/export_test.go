@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/weaveworks/libgitops/pkg/filter"
+	"github.com/weaveworks/libgitops/pkg/serializer"
+)
+
+func TestExport(t *testing.T) {
+	s, fs, dir := newTestStorage(t)
+
+	for _, id := range [][2]string{{"default", "foo"}, {"default", "bar"}, {"other", "baz"}} {
+		car := &v1alpha1.Car{Spec: v1alpha1.CarSpec{Brand: id[1]}}
+		car.SetNamespace(id[0])
+		car.SetName(id[1])
+		if err := s.Create(car); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	readFile := func(name string) string {
+		content, err := fs.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(content)
+	}
+
+	var buf bytes.Buffer
+	if err := Export(context.Background(), s, &buf, carGVK.GroupKind(), serializer.ContentTypeYAML, filter.NamespaceFilter{Namespace: "default"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The objects are sorted by their namespaces and names, and the ones in other namespaces are skipped
+	expected := readFile("car_default_bar.yaml") + "---\n" + readFile("car_default_foo.yaml")
+	if buf.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buf.String())
+	}
+}
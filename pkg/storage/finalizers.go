@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// softDelete implements Delete when Options.FinalizerSupport is set. Objects without finalizers
+// are removed right away, otherwise metadata.deletionTimestamp is set and the object is kept.
+func (s *GenericStorage) softDelete(key ObjectKey) error {
+	obj, err := s.get(key)
+	if err != nil {
+		return err
+	}
+
+	if len(obj.GetFinalizers()) == 0 {
+		return s.raw.Delete(key)
+	}
+
+	// The object is already being deleted
+	if obj.GetDeletionTimestamp() != nil {
+		return nil
+	}
+
+	now := metav1.Now()
+	obj.SetDeletionTimestamp(&now)
+	return s.write(key, obj)
+}
+
+// finalize removes the file of the stored object for key if it's being deleted, and obj has no
+// finalizers left. Like in Kubernetes, the deletionTimestamp can't be unset once it's been set,
+// so it's copied onto obj. Returns true if the object was removed.
+func (s *GenericStorage) finalize(key ObjectKey, obj runtime.Object) (bool, error) {
+	old, err := s.get(key)
+	if err != nil {
+		return false, err
+	}
+
+	if old.GetDeletionTimestamp() == nil {
+		return false, nil
+	}
+
+	obj.SetDeletionTimestamp(old.GetDeletionTimestamp())
+	if len(obj.GetFinalizers()) != 0 {
+		return false, nil
+	}
+
+	return true, s.raw.Delete(key)
+}
@@ -18,3 +18,24 @@ func extForContentType(wanted serializer.ContentType) string {
 	}
 	return ""
 }
+
+// ContentTypeCandidater is implemented by RawStorages which can't always tell the content type of a
+// resource for sure, e.g. for files without an extension. The contents of such resources are decoded
+// using the candidate content types in order, until one of them succeeds.
+type ContentTypeCandidater interface {
+	// ContentTypeCandidates returns the content types the contents of the resource indicated
+	// by key may have, in order of preference
+	ContentTypeCandidates(key ObjectKey) []serializer.ContentType
+}
+
+var _ ContentTypeCandidater = &GenericMappedRawStorage{}
+
+// ContentTypeCandidates returns the candidate content types of the resource indicated by key, if raw
+// is a ContentTypeCandidater. Otherwise only the content type reported by raw.ContentType is returned.
+func ContentTypeCandidates(raw RawStorage, key ObjectKey) []serializer.ContentType {
+	if candidater, ok := raw.(ContentTypeCandidater); ok {
+		return candidater.ContentTypeCandidates(key)
+	}
+
+	return []serializer.ContentType{raw.ContentType(key)}
+}
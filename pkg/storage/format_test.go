@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/scheme"
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/serializer"
+	"github.com/weaveworks/libgitops/pkg/util/filesystem"
+)
+
+// candidateRawStorage reports the given candidate content types for all resources
+type candidateRawStorage struct {
+	MappedRawStorage
+	candidates []serializer.ContentType
+}
+
+func (r *candidateRawStorage) ContentTypeCandidates(ObjectKey) []serializer.ContentType {
+	return r.candidates
+}
+
+func TestContentTypeCandidates(t *testing.T) {
+	dir, err := filepath.Abs("manifests")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs := filesystem.NewInMemory()
+	if err := fs.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	content := `{"apiVersion":"sample-app.weave.works/v1alpha1","kind":"Car","metadata":{"name":"foo","namespace":"default"},"spec":{"brand":"Volvo"}}`
+	for _, file := range []string{"foo", "foo.json"} {
+		if err := fs.WriteFile(filepath.Join(dir, file), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	key := NewObjectKey(NewKindKey(carGVK), runtime.NewIdentifier("default/foo"))
+	tests := []struct {
+		name       string
+		file       string
+		candidates []serializer.ContentType
+		err        bool
+	}{
+		{name: "extensionless file", file: "foo"},
+		{name: "fallback candidate", file: "foo.json", candidates: []serializer.ContentType{serializer.ContentTypeCBOR, serializer.ContentTypeJSON}},
+		{name: "no matching candidate", file: "foo.json", candidates: []serializer.ContentType{serializer.ContentTypeCBOR}, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := NewGenericMappedRawStorageWithFilesystem(dir, fs)
+			raw.AddMapping(key, filepath.Join(dir, tt.file))
+			if tt.candidates != nil {
+				raw = &candidateRawStorage{raw, tt.candidates}
+			}
+			s := NewGenericStorage(raw, scheme.Serializer, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier})
+
+			obj, err := s.Get(key)
+			if tt.err {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if car := obj.(*v1alpha1.Car); car.Spec.Brand != "Volvo" {
+				t.Errorf("expected the Car to be decoded, got %v", car)
+			}
+		})
+	}
+}
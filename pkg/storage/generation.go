@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kruntime "k8s.io/apimachinery/pkg/runtime"
+)
+
+// bumpGeneration sets the generation of obj to the one of the stored object for key,
+// incremented by one if the spec of obj differs from the stored one. Changes to only
+// the metadata or status of the object don't change the generation.
+func (s *GenericStorage) bumpGeneration(key ObjectKey, obj runtime.Object) error {
+	old, err := s.get(key)
+	if err != nil {
+		return err
+	}
+
+	oldSpec, err := specOf(old)
+	if err != nil {
+		return err
+	}
+
+	newSpec, err := specOf(obj)
+	if err != nil {
+		return err
+	}
+
+	generation := old.GetGeneration()
+	if !equality.Semantic.DeepEqual(oldSpec, newSpec) {
+		generation++
+	}
+
+	obj.SetGeneration(generation)
+	return nil
+}
+
+// specOf returns the unstructured spec field of the given object, or nil if it has none
+func specOf(obj runtime.Object) (interface{}, error) {
+	u, err := kruntime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	return u["spec"], nil
+}
+
+// setResourceVersion sets the resourceVersion of obj to the checksum of the file for key
+func (s *GenericStorage) setResourceVersion(key ObjectKey, obj metav1.Object) error {
+	checksum, err := s.raw.Checksum(key)
+	if err != nil {
+		return err
+	}
+
+	obj.SetResourceVersion(checksum)
+	return nil
+}
@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+)
+
+func TestManageGeneration(t *testing.T) {
+	s, _, _ := newTestStorage(t, manageGeneration, func(rawOpts *RawStorageOptions, _ *Options) {
+		// Patches are applied to JSON
+		rawOpts.FileLayout = FileLayoutFunc(func(key ObjectKey) string {
+			return key.GetIdentifier() + ".json"
+		})
+	})
+
+	car := &v1alpha1.Car{Spec: v1alpha1.CarSpec{Brand: "Volvo"}}
+	car.SetName("foo")
+	car.SetNamespace("default")
+	key, err := s.ObjectKeyFor(car)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lastVersion := ""
+	expect := func(step string, generation int64) {
+		obj, err := s.Get(key)
+		if err != nil {
+			t.Fatalf("%s: %v", step, err)
+		}
+		if obj.GetGeneration() != generation {
+			t.Errorf("%s: expected generation %d, got %d", step, generation, obj.GetGeneration())
+		}
+		if rv := obj.GetResourceVersion(); len(rv) == 0 || rv == lastVersion || rv != car.GetResourceVersion() {
+			t.Errorf("%s: expected a new resourceVersion matching the written one, got %q (written %q, previous %q)", step, rv, car.GetResourceVersion(), lastVersion)
+		}
+		lastVersion = obj.GetResourceVersion()
+	}
+
+	if err := s.Create(car); err != nil {
+		t.Fatal(err)
+	}
+	expect("create", 1)
+
+	car.Status.Speed = 50
+	if err := s.Update(car); err != nil {
+		t.Fatal(err)
+	}
+	expect("status update", 1)
+
+	car.Spec.Engine = "V8"
+	if err := s.Update(car); err != nil {
+		t.Fatal(err)
+	}
+	expect("spec update", 2)
+
+	if err := s.Patch(key, []byte(`{"spec":{"brand":"Saab"}}`)); err != nil {
+		t.Fatal(err)
+	}
+	obj, err := s.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj.GetGeneration() != 3 {
+		t.Errorf("patch: expected generation 3, got %d", obj.GetGeneration())
+	}
+
+	// The resourceVersion is never stored in the file
+	content, err := s.RawStorage().Read(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	partObj, err := runtime.NewPartialObject(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rv := partObj.GetResourceVersion(); len(rv) != 0 {
+		t.Errorf("expected no stored resourceVersion, got %q", rv)
+	}
+}
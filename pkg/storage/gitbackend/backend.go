@@ -0,0 +1,236 @@
+package gitbackend
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-logr/logr"
+	"github.com/weaveworks/libgitops/pkg/logs"
+	"github.com/weaveworks/libgitops/pkg/storage"
+)
+
+// Action describes the change committed by the Backend
+type Action string
+
+const (
+	// ActionWrite is used for the commits of RawStorage.Write
+	ActionWrite Action = "Write"
+	// ActionDelete is used for the commits of RawStorage.Delete
+	ActionDelete Action = "Delete"
+	// ActionTransaction is used for the commits of Backend.Transaction
+	ActionTransaction Action = "Update"
+)
+
+// CommitInfo is passed to the commit message template, see WithCommitTemplate
+type CommitInfo struct {
+	// Action describes the change
+	Action Action
+	// Keys are the keys of the changed objects, in the order they were changed
+	Keys []storage.ObjectKey
+}
+
+// New wraps the given RawStorage, so that every Write and Delete is committed to the given
+// repository. The files of inner must be stored in the worktree of repo, e.g. using
+// filesystem.NewBillyFilesystem. All changed files in the directory of inner are committed,
+// not only the written one. Writes not changing any file don't create a commit.
+func New(inner storage.RawStorage, repo *git.Repository, opts ...Option) (*Backend, error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	tmpl, err := template.New("commit").Parse(o.commitTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid commit template: %w", err)
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+
+	// The files are staged using paths relative to the root of the worktree
+	dir, err := filepath.Rel(worktree.Filesystem.Root(), inner.WatchDir())
+	if err != nil || strings.HasPrefix(dir, "..") {
+		return nil, fmt.Errorf("directory %q is not in the worktree %q", inner.WatchDir(), worktree.Filesystem.Root())
+	}
+
+	return &Backend{
+		RawStorage: inner,
+		worktree:   worktree,
+		dir:        filepath.ToSlash(dir),
+		tmpl:       tmpl,
+		opts:       o,
+		logger:     logs.OrDefault(o.logger).WithName("Backend"),
+	}, nil
+}
+
+// Backend is a RawStorage committing every change to a git repository
+type Backend struct {
+	storage.RawStorage
+
+	worktree *git.Worktree
+	dir      string
+	tmpl     *template.Template
+	opts     options
+	logger   logr.Logger
+
+	// mux guards the fields below, and serializes the changes
+	mux sync.Mutex
+	// pending holds the keys changed by the active transaction, or is nil without one
+	pending []storage.ObjectKey
+	// txMux serializes the transactions
+	txMux sync.Mutex
+}
+
+var _ storage.RawStorage = &Backend{}
+
+// Write writes the given content to the resource indicated by key, and commits it
+func (b *Backend) Write(key storage.ObjectKey, content []byte) error {
+	return b.change(ActionWrite, key, func() error {
+		return b.RawStorage.Write(key, content)
+	})
+}
+
+// Delete deletes the resource indicated by key, and commits the removal
+func (b *Backend) Delete(key storage.ObjectKey) error {
+	return b.change(ActionDelete, key, func() error {
+		return b.RawStorage.Delete(key)
+	})
+}
+
+// Transaction runs fn, and creates a single commit for all changes made through the Backend
+// while fn runs, instead of one per change. If fn returns an error, nothing is committed,
+// and the changes are left in the worktree, to be committed with the next change.
+func (b *Backend) Transaction(fn func() error) error {
+	b.txMux.Lock()
+	defer b.txMux.Unlock()
+
+	b.mux.Lock()
+	if err := b.pull(); err != nil {
+		b.mux.Unlock()
+		return err
+	}
+	b.pending = []storage.ObjectKey{}
+	b.mux.Unlock()
+
+	err := fn()
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	keys := b.pending
+	b.pending = nil
+	if err != nil {
+		return err
+	}
+
+	return b.commit(CommitInfo{Action: ActionTransaction, Keys: keys})
+}
+
+// change applies the given change to the object with key, and commits it,
+// unless a transaction is active
+func (b *Backend) change(action Action, key storage.ObjectKey, fn func() error) error {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if b.pending != nil {
+		if err := fn(); err != nil {
+			return err
+		}
+
+		b.pending = append(b.pending, key)
+		return nil
+	}
+
+	if err := b.pull(); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+
+	return b.commit(CommitInfo{Action: action, Keys: []storage.ObjectKey{key}})
+}
+
+// pull pulls the remote if configured, see WithPullBeforeCommit
+func (b *Backend) pull() error {
+	if b.opts.pull == nil {
+		return nil
+	}
+
+	if err := b.worktree.Pull(b.opts.pull); err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return fmt.Errorf("failed to pull before committing: %w", err)
+	}
+	return nil
+}
+
+// commit stages all changed files in the directory of the RawStorage, and commits them
+// with a message rendered from info. If no files were changed, nothing is committed.
+func (b *Backend) commit(info CommitInfo) error {
+	staged, err := b.stage()
+	if err != nil || !staged {
+		return err
+	}
+
+	var msg bytes.Buffer
+	if err := b.tmpl.Execute(&msg, info); err != nil {
+		return fmt.Errorf("failed to render the commit message: %w", err)
+	}
+
+	signature := &object.Signature{
+		Name:  b.opts.authorName,
+		Email: b.opts.authorEmail,
+		When:  time.Now(),
+	}
+	hash, err := b.worktree.Commit(msg.String(), &git.CommitOptions{Author: signature, Committer: signature})
+	if err != nil {
+		return err
+	}
+
+	b.logger.V(logs.DebugLevel).Info("Committed", "hash", hash, "message", msg.String())
+	return nil
+}
+
+// stage adds all changed files in the directory of the RawStorage to the index,
+// including removed ones. Returns true if any changes are staged.
+func (b *Backend) stage() (bool, error) {
+	status, err := b.worktree.Status()
+	if err != nil {
+		return false, err
+	}
+
+	for path, fileStatus := range status {
+		if !b.inDir(path) || fileStatus.Worktree == git.Unmodified {
+			continue
+		}
+
+		// Removed files which were never committed aren't in the index
+		if _, err := b.worktree.Add(path); err != nil && !errors.Is(err, index.ErrEntryNotFound) {
+			return false, err
+		}
+	}
+
+	if status, err = b.worktree.Status(); err != nil {
+		return false, err
+	}
+	for path, fileStatus := range status {
+		if b.inDir(path) && fileStatus.Staging != git.Unmodified && fileStatus.Staging != git.Untracked {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// inDir returns true if the given path in the worktree is in the directory of the RawStorage
+func (b *Backend) inDir(path string) bool {
+	return b.dir == "." || strings.HasPrefix(path, b.dir+"/")
+}
@@ -0,0 +1,136 @@
+package gitbackend
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	gitmemory "github.com/go-git/go-git/v5/storage/memory"
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/scheme"
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/util/filesystem"
+)
+
+func TestBackend(t *testing.T) {
+	repo, err := git.Init(gitmemory.NewStorage(), memfs.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rawOpts := storage.DefaultRawStorageOptions()
+	rawOpts.Filesystem = filesystem.NewBillyFilesystem(worktree.Filesystem)
+	rawOpts.FileLayout = storage.FlatLayout
+	backend, err := New(storage.NewGenericMappedRawStorageWithOptions("/manifests", rawOpts), repo,
+		WithAuthor("Jane Doe", "jane@example.com"),
+		WithCommitTemplate(`{{ .Action }} {{ len .Keys }} object(s)`),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := storage.NewGenericStorage(backend, scheme.Serializer, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier})
+
+	newCar := func(name string) *v1alpha1.Car {
+		car := &v1alpha1.Car{Spec: v1alpha1.CarSpec{Brand: "Volvo"}}
+		car.SetName(name)
+		car.SetNamespace("default")
+		return car
+	}
+	expectCommits := func(expected ...string) {
+		t.Helper()
+		iter, err := repo.Log(&git.LogOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var messages []string
+		err = iter.ForEach(func(c *object.Commit) error {
+			if c.Author.Name != "Jane Doe" || c.Author.Email != "jane@example.com" {
+				t.Errorf("unexpected author %s", c.Author.String())
+			}
+			messages = append([]string{c.Message}, messages...)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(messages, expected) {
+			t.Errorf("expected commits %q, got %q", expected, messages)
+		}
+
+		status, err := worktree.Status()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !status.IsClean() {
+			t.Errorf("expected a clean worktree, got:\n%s", status)
+		}
+	}
+
+	car := newCar("foo")
+	if err := s.Create(car); err != nil {
+		t.Fatal(err)
+	}
+	car.Spec.Brand = "Tesla"
+	if err := s.Update(car); err != nil {
+		t.Fatal(err)
+	}
+	expectCommits("Write 1 object(s)", "Write 1 object(s)")
+
+	// A transaction creates a single commit
+	err = backend.Transaction(func() error {
+		if err := s.Create(newCar("bar")); err != nil {
+			return err
+		}
+		return s.Delete(storage.NewObjectKey(storage.NewKindKey(v1alpha1.SchemeGroupVersion.WithKind("Car")), runtime.NewIdentifier("default/foo")))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCommits("Write 1 object(s)", "Write 1 object(s)", "Update 2 object(s)")
+
+	// Nothing is committed for failed transactions
+	errAbort := errors.New("abort")
+	err = backend.Transaction(func() error {
+		if err := s.Create(newCar("baz")); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("expected the transaction to fail, got %v", err)
+	}
+	if _, err := repo.Head(); err != nil {
+		t.Fatal(err)
+	}
+	iter, _ := repo.Log(&git.LogOptions{})
+	if c, _ := iter.Next(); c.Message != "Update 2 object(s)" {
+		t.Errorf("expected no commit for the failed transaction, got %q", c.Message)
+	}
+}
+
+func TestDefaultCommitTemplate(t *testing.T) {
+	kind := storage.NewKindKey(v1alpha1.SchemeGroupVersion.WithKind("Car"))
+	info := CommitInfo{Action: ActionTransaction, Keys: []storage.ObjectKey{
+		storage.NewObjectKey(kind, runtime.NewIdentifier("default/foo")),
+		storage.NewObjectKey(kind, runtime.NewIdentifier("default/bar")),
+	}}
+
+	var msg strings.Builder
+	if err := template.Must(template.New("").Parse(DefaultCommitTemplate)).Execute(&msg, info); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "Update Car default/foo, Car default/bar"; msg.String() != expected {
+		t.Errorf("expected %q, got %q", expected, msg.String())
+	}
+}
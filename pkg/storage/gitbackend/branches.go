@@ -0,0 +1,254 @@
+package gitbackend
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	log "github.com/sirupsen/logrus"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/serializer"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	kruntime "k8s.io/apimachinery/pkg/runtime"
+)
+
+// NewBranchStorage returns a read-only RawStorage serving the objects in the given directory
+// of the repository, as committed to the given branch. The active branch can be switched using
+// CheckoutBranch, without touching the worktree, which lets the same Storage present different
+// branches, e.g. to preview the changes of a pull request. The files are decoded using the
+// given scheme, and the keys of their objects are determined using the given identifiers.
+func NewBranchStorage(repo *git.Repository, dir string, scheme *kruntime.Scheme, identifiers []runtime.IdentifierFactory, branch string) (*BranchStorage, error) {
+	s := &BranchStorage{
+		repo:        repo,
+		dir:         path.Clean(dir),
+		scheme:      scheme,
+		identifiers: identifiers,
+		branches:    make(map[string]*branchImpl),
+	}
+
+	if err := s.CheckoutBranch(branch); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// BranchStorage is a read-only RawStorage serving the objects committed to a branch
+type BranchStorage struct {
+	repo        *git.Repository
+	dir         string
+	scheme      *kruntime.Scheme
+	identifiers []runtime.IdentifierFactory
+
+	// mux guards the fields below
+	mux sync.RWMutex
+	// branches holds the mappings of all checked out branches, keyed by the branch name
+	branches map[string]*branchImpl
+	// active is the name of the active branch
+	active string
+}
+
+// branchImpl holds the mappings of the objects committed to a branch
+type branchImpl struct {
+	// commit is the commit the mappings were computed for
+	commit plumbing.Hash
+	// tree is the tree of the commit
+	tree *object.Tree
+	// files maps the objects to the paths of their files in the tree
+	files map[storage.ObjectKey]string
+	// keys maps the paths of the files to the keys of their objects
+	keys map[string]storage.ObjectKey
+}
+
+var _ storage.RawStorage = &BranchStorage{}
+
+// CheckoutBranch makes the given branch active, so that all reads reflect the objects committed
+// to it. The mappings of every branch are cached until a new commit is checked out, so switching
+// back and forth is cheap. Checking out the active branch again picks up its new commits.
+func (s *BranchStorage) CheckoutBranch(name string) error {
+	ref, err := s.repo.Reference(plumbing.NewBranchReferenceName(name), true)
+	if err != nil {
+		return fmt.Errorf("failed to resolve branch %q: %w", name, err)
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if b, ok := s.branches[name]; !ok || b.commit != ref.Hash() {
+		if s.branches[name], err = s.computeBranch(ref.Hash()); err != nil {
+			return err
+		}
+		log.Debugf("BranchStorage: Computed the mappings of branch %q at %s", name, ref.Hash())
+	}
+
+	s.active = name
+	return nil
+}
+
+// Branch returns the name of the active branch
+func (s *BranchStorage) Branch() string {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	return s.active
+}
+
+// computeBranch maps all objects in the directory of the storage in the tree of the given commit
+func (s *BranchStorage) computeBranch(commit plumbing.Hash) (*branchImpl, error) {
+	c, err := s.repo.CommitObject(commit)
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := c.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	b := &branchImpl{
+		commit: commit,
+		tree:   tree,
+		files:  make(map[storage.ObjectKey]string),
+		keys:   make(map[string]storage.ObjectKey),
+	}
+	err = tree.Files().ForEach(func(f *object.File) error {
+		if _, ok := storage.ContentTypes[path.Ext(f.Name)]; !ok || !s.inDir(f.Name) {
+			return nil
+		}
+
+		r, err := f.Reader()
+		if err != nil {
+			return err
+		}
+
+		partObjs, err := storage.DecodePartialObjects(r, s.scheme, false, nil)
+		if err != nil {
+			log.Warnf("BranchStorage: Ignoring file %q: %v", f.Name, err)
+			return nil
+		}
+
+		key, err := s.keyFor(partObjs[0])
+		if err != nil {
+			log.Warnf("BranchStorage: Ignoring file %q: %v", f.Name, err)
+			return nil
+		}
+
+		b.files[key] = f.Name
+		b.keys[f.Name] = key
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// keyFor returns the key of the given object, using the first matching identifier
+func (s *BranchStorage) keyFor(obj runtime.PartialObject) (storage.ObjectKey, error) {
+	for _, identifier := range s.identifiers {
+		if id, ok := identifier.Identify(obj); ok {
+			return storage.NewObjectKey(storage.NewKindKey(obj.GetObjectKind().GroupVersionKind()), id), nil
+		}
+	}
+
+	return nil, errors.New("couldn't identify object")
+}
+
+// inDir returns true if the given path in the tree is in the directory of the storage
+func (s *BranchStorage) inDir(p string) bool {
+	return s.dir == "." || strings.HasPrefix(p, s.dir+"/")
+}
+
+// branch returns the mappings of the active branch
+func (s *BranchStorage) branch() *branchImpl {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	return s.branches[s.active]
+}
+
+func (s *BranchStorage) Read(key storage.ObjectKey) ([]byte, error) {
+	b := s.branch()
+	p, ok := b.files[key]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", key, storage.ErrNotFound)
+	}
+
+	f, err := b.tree.File(p)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := f.Contents()
+	return []byte(content), err
+}
+
+func (s *BranchStorage) Exists(key storage.ObjectKey) bool {
+	_, ok := s.branch().files[key]
+	return ok
+}
+
+func (s *BranchStorage) Write(key storage.ObjectKey, _ []byte) error {
+	return fmt.Errorf("cannot write %s to a branch: %w", key, storage.ErrReadOnly)
+}
+
+func (s *BranchStorage) Delete(key storage.ObjectKey) error {
+	return fmt.Errorf("cannot delete %s from a branch: %w", key, storage.ErrReadOnly)
+}
+
+func (s *BranchStorage) List(kind storage.KindKey) ([]storage.ObjectKey, error) {
+	var keys []storage.ObjectKey
+	for key := range s.branch().files {
+		if key.GetGVK() == kind.GetGVK() {
+			keys = append(keys, key)
+		}
+	}
+
+	storage.SortKeys(keys)
+	return keys, nil
+}
+
+// Checksum returns the hash of the blob of the file for key, which
+// only changes if the content of the file changes
+func (s *BranchStorage) Checksum(key storage.ObjectKey) (string, error) {
+	b := s.branch()
+	p, ok := b.files[key]
+	if !ok {
+		return "", fmt.Errorf("%s: %w", key, storage.ErrNotFound)
+	}
+
+	f, err := b.tree.File(p)
+	if err != nil {
+		return "", err
+	}
+
+	return f.Hash.String(), nil
+}
+
+func (s *BranchStorage) ContentType(key storage.ObjectKey) serializer.ContentType {
+	if p, ok := s.branch().files[key]; ok {
+		return storage.ContentTypes[path.Ext(p)]
+	}
+
+	return ""
+}
+
+// WatchDir returns the directory of the storage in the repository. The files of a
+// branch can't be watched, use CheckoutBranch to pick up new commits instead.
+func (s *BranchStorage) WatchDir() string {
+	return s.dir
+}
+
+// GetKey returns the key of the object in the file at the given path in the repository
+func (s *BranchStorage) GetKey(p string) (storage.ObjectKey, error) {
+	if key, ok := s.branch().keys[p]; ok {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("no object for %q: %w", p, storage.ErrNotFound)
+}
@@ -0,0 +1,99 @@
+package gitbackend
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	gitmemory "github.com/go-git/go-git/v5/storage/memory"
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/scheme"
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/storage"
+)
+
+func TestBranchStorage(t *testing.T) {
+	repo, err := git.Init(gitmemory.NewStorage(), memfs.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commitCar := func(name, brand string) {
+		content := fmt.Sprintf("apiVersion: sample-app.weave.works/v1alpha1\nkind: Car\nmetadata:\n  name: %s\n  namespace: default\nspec:\n  brand: %s\n", name, brand)
+		if err := util.WriteFile(worktree.Filesystem, "manifests/"+name+".yaml", []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := worktree.Add("manifests/" + name + ".yaml"); err != nil {
+			t.Fatal(err)
+		}
+		signature := &object.Signature{Name: "Jane Doe", Email: "jane@example.com"}
+		if _, err := worktree.Commit("Update "+name, &git.CommitOptions{Author: signature}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	commitCar("foo", "Volvo")
+	err = worktree.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName("feature"), Create: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	commitCar("foo", "Tesla")
+	commitCar("bar", "Saab")
+
+	raw, err := NewBranchStorage(repo, "manifests", scheme.Scheme, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier}, "master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := storage.NewGenericStorage(raw, scheme.Serializer, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier})
+	kind := storage.NewKindKey(v1alpha1.SchemeGroupVersion.WithKind("Car"))
+	fooKey := storage.NewObjectKey(kind, runtime.NewIdentifier("default/foo"))
+
+	expect := func(branch, brand string, count int) {
+		t.Helper()
+		if raw.Branch() != branch {
+			t.Errorf("expected branch %q to be active, got %q", branch, raw.Branch())
+		}
+
+		obj, err := s.Get(fooKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if car := obj.(*v1alpha1.Car); car.Spec.Brand != brand {
+			t.Errorf("%s: expected brand %q, got %q", branch, brand, car.Spec.Brand)
+		}
+
+		objs, err := s.List(kind)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(objs) != count {
+			t.Errorf("%s: expected %d cars, got %d", branch, count, len(objs))
+		}
+	}
+
+	expect("master", "Volvo", 1)
+	if err := raw.CheckoutBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+	expect("feature", "Tesla", 2)
+	if err := raw.CheckoutBranch("master"); err != nil {
+		t.Fatal(err)
+	}
+	expect("master", "Volvo", 1)
+
+	if err := raw.CheckoutBranch("missing"); err == nil {
+		t.Error("expected an error for a missing branch")
+	}
+	if err := raw.Write(fooKey, []byte("{}")); !errors.Is(err, storage.ErrReadOnly) {
+		t.Errorf("expected ErrReadOnly, got %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package gitbackend
+
+import (
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-logr/logr"
+)
+
+// DefaultCommitTemplate is the default template for the commit messages, see WithCommitTemplate
+const DefaultCommitTemplate = `{{ .Action }} {{ range $i, $key := .Keys }}{{ if $i }}, {{ end }}{{ $key.GetKind }} {{ $key.GetIdentifier }}{{ end }}`
+
+// Option configures a Backend
+type Option func(*options)
+
+// options holds the configuration of a Backend
+type options struct {
+	commitTemplate string
+	authorName     string
+	authorEmail    string
+	pull           *git.PullOptions
+	logger         logr.Logger
+}
+
+func defaultOptions() options {
+	return options{
+		commitTemplate: DefaultCommitTemplate,
+		authorName:     "libgitops",
+		authorEmail:    "libgitops@localhost",
+	}
+}
+
+// WithCommitTemplate sets the text/template used for the commit messages. The template
+// is executed with a CommitInfo describing the change. (Default: DefaultCommitTemplate)
+func WithCommitTemplate(tmpl string) Option {
+	return func(o *options) {
+		o.commitTemplate = tmpl
+	}
+}
+
+// WithAuthor sets the author and committer of the commits. (Default: "libgitops <libgitops@localhost>")
+func WithAuthor(name, email string) Option {
+	return func(o *options) {
+		o.authorName = name
+		o.authorEmail = email
+	}
+}
+
+// WithPullBeforeCommit makes the Backend pull the given remote before every change, so that the
+// commits are made on top of the latest remote state. As go-git can't rebase, this only works
+// if the branch can be fast-forwarded to the remote one. The pull uses the given options.
+func WithPullBeforeCommit(pullOpts git.PullOptions) Option {
+	return func(o *options) {
+		o.pull = &pullOpts
+	}
+}
+
+// WithLogger sets the logr.Logger receiving the logs of the Backend, with the hash and message of
+// the created commits as key/value pairs. (Default: nil, which logs to logs.Logger, see logs.NewLogger)
+func WithLogger(logger logr.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/scheme"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/util/filesystem"
+)
+
+// newTestStorage returns a GenericStorage of the sample-app objects, identified by their names.
+// The files are stored in memory, in the returned directory, using FlatLayout. The configure
+// funcs may change the options of the storages before they are created.
+func newTestStorage(t testing.TB, configure ...func(rawOpts *RawStorageOptions, opts *Options)) (s Storage, fs filesystem.Filesystem, dir string) {
+	dir, err := filepath.Abs("manifests")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs = filesystem.NewInMemory()
+	rawOpts := DefaultRawStorageOptions()
+	rawOpts.Filesystem = fs
+	rawOpts.FileLayout = FlatLayout
+	opts := DefaultOptions()
+	for _, fn := range configure {
+		fn(&rawOpts, &opts)
+	}
+
+	raw := NewGenericMappedRawStorageWithOptions(dir, rawOpts)
+	return NewGenericStorageWithOptions(raw, scheme.Serializer, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier}, opts), fs, dir
+}
+
+// manageGeneration configures newTestStorage to manage the generations of the objects, using
+// checksums of the content of the files to detect conflicting updates
+func manageGeneration(rawOpts *RawStorageOptions, opts *Options) {
+	rawOpts.Checksummer = filesystem.SHA256Checksummer
+	opts.ManageGeneration = true
+}
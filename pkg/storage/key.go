@@ -1,6 +1,11 @@
 package storage
 
 import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
 	"github.com/weaveworks/libgitops/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 )
@@ -62,3 +67,47 @@ func NewKindKey(gvk schema.GroupVersionKind) KindKey {
 func NewObjectKey(kind KindKey, id runtime.Identifyable) ObjectKey {
 	return objectKey{kind, id}
 }
+
+// ErrInvalidObjectKey is returned by ParseObjectKey for malformed keys
+var ErrInvalidObjectKey = errors.New("invalid object key")
+
+// FormatObjectKey returns the canonical string form of the given key without its version, i.e.
+// "<group>/<kind>/<namespace>/<name>", which is parsed by ParseObjectKey. The core group and the
+// namespace of objects without one are empty, e.g. "/Namespace//default". All parts are escaped
+// like URL path segments, so that e.g. names containing slashes keep the form unambiguous.
+func FormatObjectKey(key ObjectKey) string {
+	namespace, name := splitIdentifier(key)
+	parts := []string{key.GetGroup(), key.GetKind(), namespace, name}
+	for i := range parts {
+		parts[i] = url.PathEscape(parts[i])
+	}
+	return strings.Join(parts, "/")
+}
+
+// ParseObjectKey parses the string form of a key returned by FormatObjectKey. As the string
+// form has no version, the KindKey of the returned key has an empty version.
+func ParseObjectKey(s string) (ObjectKey, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("%w %q: expected <group>/<kind>/<namespace>/<name>", ErrInvalidObjectKey, s)
+	}
+
+	for i := range parts {
+		unescaped, err := url.PathUnescape(parts[i])
+		if err != nil {
+			return nil, fmt.Errorf("%w %q: %v", ErrInvalidObjectKey, s, err)
+		}
+		parts[i] = unescaped
+	}
+
+	group, kind, namespace, name := parts[0], parts[1], parts[2], parts[3]
+	if len(kind) == 0 || len(name) == 0 {
+		return nil, fmt.Errorf("%w %q: the kind and name are required", ErrInvalidObjectKey, s)
+	}
+
+	id := name
+	if len(namespace) != 0 {
+		id = namespace + "/" + name
+	}
+	return NewObjectKey(NewKindKey(schema.GroupVersionKind{Group: group, Kind: kind}), runtime.NewIdentifier(id)), nil
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"testing/quick"
+
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestFormatObjectKey(t *testing.T) {
+	tests := []struct {
+		gvk      schema.GroupVersionKind
+		id       string
+		expected string
+	}{
+		{carGVK, "default/foo", "sample-app.weave.works/Car/default/foo"},
+		{schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}, "default", "/Namespace//default"},
+		{carGVK, "default/foo/bar", "sample-app.weave.works/Car/default/foo%2Fbar"},
+		{carGVK, "default/100% a", "sample-app.weave.works/Car/default/100%25%20a"},
+	}
+	for _, tt := range tests {
+		key := NewObjectKey(NewKindKey(tt.gvk), runtime.NewIdentifier(tt.id))
+		actual := FormatObjectKey(key)
+		if actual != tt.expected {
+			t.Errorf("expected %s to be formatted as %q, got %q", key, tt.expected, actual)
+		}
+
+		parsed, err := ParseObjectKey(actual)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsed.GetGroup() != tt.gvk.Group || parsed.GetKind() != tt.gvk.Kind || parsed.GetVersion() != "" || parsed.GetIdentifier() != tt.id {
+			t.Errorf("expected %q to be parsed as %s, got %s", actual, key, parsed)
+		}
+	}
+
+	for _, invalid := range []string{"", "Car/foo", "a/Car/b/c/d", "a//default/foo", "a/Car/default/", "a/Car/default/%zz"} {
+		if _, err := ParseObjectKey(invalid); !errors.Is(err, ErrInvalidObjectKey) {
+			t.Errorf("expected %q to be invalid, got %v", invalid, err)
+		}
+	}
+}
+
+func TestObjectKeyRoundTrip(t *testing.T) {
+	roundTrip := func(group, kind, namespace, name string) bool {
+		// Namespaces can't contain slashes, as the first one separates the namespace from the name
+		namespace = strings.ReplaceAll(namespace, "/", "")
+		if len(kind) == 0 || len(name) == 0 || (len(namespace) == 0 && strings.Contains(name, "/")) {
+			return true
+		}
+
+		id := name
+		if len(namespace) != 0 {
+			id = namespace + "/" + name
+		}
+		key := NewObjectKey(NewKindKey(schema.GroupVersionKind{Group: group, Kind: kind}), runtime.NewIdentifier(id))
+		parsed, err := ParseObjectKey(FormatObjectKey(key))
+		return err == nil && parsed == key
+	}
+	if err := quick.Check(roundTrip, &quick.Config{MaxCount: 1000}); err != nil {
+		t.Error(err)
+	}
+
+	// Names with slashes and empty namespaces
+	for _, args := range [][4]string{{"", "Namespace", "", "default"}, {"g", "Car", "ns", "a/b/c"}, {"g", "Car", "ns", "/"}, {"", "K", "", "%2F"}} {
+		if !roundTrip(args[0], args[1], args[2], args[3]) {
+			t.Errorf("expected %v to round-trip", args)
+		}
+	}
+}
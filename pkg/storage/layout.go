@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// FileLayout decides where the GenericMappedRawStorage creates the files of new objects
+type FileLayout interface {
+	// PathFor returns the path of the file to create for the object with the given key,
+	// relative to the directory of the storage, using "/" as the separator
+	PathFor(key ObjectKey) string
+}
+
+// FileLayoutFunc implements FileLayout using a function
+type FileLayoutFunc func(key ObjectKey) string
+
+var _ FileLayout = FileLayoutFunc(nil)
+
+// PathFor implements FileLayout
+func (f FileLayoutFunc) PathFor(key ObjectKey) string {
+	return f(key)
+}
+
+var (
+	// FlatLayout stores all files in the storage directory,
+	// as "<kind>_<namespace>_<name>.yaml" or "<kind>_<name>.yaml"
+	FlatLayout FileLayout = FileLayoutFunc(func(key ObjectKey) string {
+		namespace, name := splitIdentifier(key)
+		kind := strings.ToLower(key.GetKind())
+		if len(namespace) == 0 {
+			return kind + "_" + name + ".yaml"
+		}
+
+		return kind + "_" + namespace + "_" + name + ".yaml"
+	})
+
+	// NamespaceLayout stores the files in a directory per namespace, as
+	// "<namespace>/<kind>_<name>.yaml". Objects without a namespace are
+	// stored in the storage directory as "<kind>_<name>.yaml".
+	NamespaceLayout FileLayout = FileLayoutFunc(func(key ObjectKey) string {
+		namespace, name := splitIdentifier(key)
+		return path.Join(namespace, strings.ToLower(key.GetKind())+"_"+name+".yaml")
+	})
+
+	// GVKLayout stores the files in a directory per group, version and kind, as
+	// "<group>/<version>/<kind>/<namespace>/<name>.yaml". Objects in the core
+	// group use "core" as the group, objects without a namespace skip that directory.
+	GVKLayout FileLayout = FileLayoutFunc(func(key ObjectKey) string {
+		namespace, name := splitIdentifier(key)
+		gvk := key.GetGVK()
+		group := gvk.Group
+		if len(group) == 0 {
+			group = "core"
+		}
+
+		return path.Join(group, gvk.Version, strings.ToLower(gvk.Kind), namespace, name+".yaml")
+	})
+)
+
+// ErrUnsafeName is returned when writing the file of an object whose kind, namespace or
+// name can't be used safely as a path element, e.g. the name "../../etc/passwd"
+var ErrUnsafeName = errors.New("name is unsafe to use in a path")
+
+// ValidateKeyPath checks that the group, version, kind, namespace and name of key can be used as path
+// elements. They may not contain path separators, ".." or null bytes, and the name may not be empty.
+// All DNS subdomain names (which Kubernetes requires for most names) are valid. It's used by the raw
+// storages before composing the path of a new file, as the names may come from untrusted objects.
+func ValidateKeyPath(key ObjectKey) error {
+	namespace, name := splitIdentifier(key)
+	if len(name) == 0 {
+		return fmt.Errorf("%w: the name of %s is empty", ErrUnsafeName, key)
+	}
+
+	gvk := key.GetGVK()
+	for _, part := range []string{gvk.Group, gvk.Version, gvk.Kind, namespace, name} {
+		if part == "." || strings.Contains(part, "..") || strings.ContainsAny(part, "/\\\x00") {
+			return fmt.Errorf("%w: %q", ErrUnsafeName, part)
+		}
+	}
+	return nil
+}
+
+// splitIdentifier splits the identifier of the given key into a namespace and name, if the
+// identifier has the "<namespace>/<name>" format. Otherwise the identifier is used as the name.
+func splitIdentifier(key ObjectKey) (namespace, name string) {
+	return splitIdentifierString(key.GetIdentifier())
+}
+
+// splitIdentifierString is the same as splitIdentifier, but for a plain identifier
+func splitIdentifierString(id string) (namespace, name string) {
+	if i := strings.Index(id, "/"); i >= 0 {
+		return id[:i], id[i+1:]
+	}
+
+	return "", id
+}
+
+// SortKeys sorts the given keys by the namespace and name of their identifiers,
+// see splitIdentifier. Keys without a namespace are sorted first.
+func SortKeys(keys []ObjectKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		return identifierLess(keys[i].GetIdentifier(), keys[j].GetIdentifier())
+	})
+}
+
+// identifierLess returns true if the identifier a sorts before b by namespace and name
+func identifierLess(a, b string) bool {
+	aNamespace, aName := splitIdentifierString(a)
+	bNamespace, bName := splitIdentifierString(b)
+	if aNamespace != bNamespace {
+		return aNamespace < bNamespace
+	}
+
+	return aName < bName
+}
@@ -0,0 +1,196 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/serializer"
+	"github.com/weaveworks/libgitops/pkg/util/filesystem"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var (
+	carGVK       = schema.GroupVersionKind{Group: "sample-app.weave.works", Version: "v1alpha1", Kind: "Car"}
+	namespaceGVK = schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}
+	carKey       = NewObjectKey(NewKindKey(carGVK), runtime.NewIdentifier("default/foo"))
+	namespaceKey = NewObjectKey(NewKindKey(namespaceGVK), runtime.NewIdentifier("prod"))
+)
+
+func TestFileLayouts(t *testing.T) {
+	tests := []struct {
+		name     string
+		layout   FileLayout
+		key      ObjectKey
+		expected string
+	}{
+		{"flat", FlatLayout, carKey, "car_default_foo.yaml"},
+		{"flat without namespace", FlatLayout, namespaceKey, "namespace_prod.yaml"},
+		{"namespace", NamespaceLayout, carKey, "default/car_foo.yaml"},
+		{"namespace without namespace", NamespaceLayout, namespaceKey, "namespace_prod.yaml"},
+		{"gvk", GVKLayout, carKey, "sample-app.weave.works/v1alpha1/car/default/foo.yaml"},
+		{"gvk without namespace", GVKLayout, namespaceKey, "core/v1/namespace/prod.yaml"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if path := tt.layout.PathFor(tt.key); path != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, path)
+			}
+		})
+	}
+}
+
+func TestMappedRawStorageCreate(t *testing.T) {
+	fs := filesystem.NewInMemory()
+	dir, err := filepath.Abs("manifests")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a FileLayout, unknown objects can't be written
+	raw := NewGenericMappedRawStorageWithFilesystem(dir, fs)
+	if err := raw.Write(carKey, []byte("{}")); !errors.Is(err, ErrNotTracked) {
+		t.Fatalf("expected ErrNotTracked, got %v", err)
+	}
+
+	opts := DefaultRawStorageOptions()
+	opts.Filesystem = fs
+	opts.FileLayout = NamespaceLayout
+	raw = NewGenericMappedRawStorageWithOptions(dir, opts)
+
+	// The content type of new objects is decided by the FileLayout
+	if ct := raw.ContentType(carKey); ct != serializer.ContentTypeYAML {
+		t.Errorf("expected the content type of the new object to be %q, got %q", serializer.ContentTypeYAML, ct)
+	}
+
+	if err := raw.Write(carKey, []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := filepath.Join(dir, "default", "car_foo.yaml")
+	if path, err := raw.GetPath(carKey); err != nil || path != expected {
+		t.Errorf("expected the object to be mapped to %q, got %q (%v)", expected, path, err)
+	}
+	if content, err := fs.ReadFile(expected); err != nil || string(content) != "{}" {
+		t.Errorf("expected the file to be created, got %q (%v)", content, err)
+	}
+
+	// Existing files are never overwritten
+	raw.RemoveMapping(carKey)
+	if err := raw.Write(carKey, []byte("{}")); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("expected ErrAlreadyExists, got %v", err)
+	}
+}
+
+func TestValidateKeyPath(t *testing.T) {
+	newKey := func(id string) ObjectKey {
+		return NewObjectKey(NewKindKey(carGVK), runtime.NewIdentifier(id))
+	}
+
+	for _, id := range []string{
+		"foo",
+		"default/foo",
+		"my-car-1",
+		"0car",
+		"car.example.com",
+		"kube-system/coredns.v1",
+		"a123456789012345678901234567890123456789012345678901234567890ab",
+	} {
+		if err := ValidateKeyPath(newKey(id)); err != nil {
+			t.Errorf("expected %q to be valid, got %v", id, err)
+		}
+	}
+
+	for _, id := range []string{
+		"",
+		"default/",
+		".",
+		"..",
+		"default/..",
+		"../foo",
+		"../../etc/passwd",
+		"default/../../etc/passwd",
+		"default/foo/../bar",
+		"default/foo/bar",
+		"../default/foo",
+		"./foo",
+		"foo..bar",
+		"..\\..\\windows\\system32",
+		"default/foo\\bar",
+		"foo\x00bar",
+		"default/foo\x00.yaml",
+		"default\x00/foo",
+	} {
+		if err := ValidateKeyPath(newKey(id)); !errors.Is(err, ErrUnsafeName) {
+			t.Errorf("expected %q to be rejected with ErrUnsafeName, got %v", id, err)
+		}
+	}
+
+	unsafeKind := NewObjectKey(NewKindKey(schema.GroupVersionKind{Group: "..", Version: "v1", Kind: "Car"}), runtime.NewIdentifier("foo"))
+	if err := ValidateKeyPath(unsafeKind); !errors.Is(err, ErrUnsafeName) {
+		t.Errorf("expected the group to be rejected with ErrUnsafeName, got %v", err)
+	}
+
+	// Neither raw storage writes files for unsafe keys
+	fs := filesystem.NewInMemory()
+	dir, err := filepath.Abs("manifests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := DefaultRawStorageOptions()
+	opts.Filesystem = fs
+	opts.FileLayout = GVKLayout
+	mapped := NewGenericMappedRawStorageWithOptions(dir, opts)
+	raw := NewGenericRawStorageWithOptions(dir, carGVK.GroupVersion(), serializer.ContentTypeYAML, opts)
+	for _, s := range []RawStorage{mapped, raw} {
+		if err := s.Write(newKey("default/../../../etc/passwd"), []byte("{}")); !errors.Is(err, ErrUnsafeName) {
+			t.Errorf("expected ErrUnsafeName, got %v", err)
+		}
+	}
+
+	// A FileLayout can't escape the storage directory either
+	opts.FileLayout = FileLayoutFunc(func(ObjectKey) string {
+		return "../escaped.yaml"
+	})
+	mapped = NewGenericMappedRawStorageWithOptions(dir, opts)
+	if err := mapped.Write(carKey, []byte("{}")); !errors.Is(err, ErrUnsafeName) {
+		t.Errorf("expected ErrUnsafeName, got %v", err)
+	}
+
+	if err := fs.Walk("/", func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			t.Errorf("expected no files to be written, got %q", path)
+		}
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSortedList(t *testing.T) {
+	raw := NewGenericMappedRawStorageWithFilesystem("manifests", filesystem.NewInMemory())
+	// Sorting by the whole identifier would put "a-b/x" before "a/y"
+	expected := []string{"cluster-scoped", "a/y", "a/z", "a-b/x", "b/a"}
+	for _, i := range []int{3, 1, 4, 0, 2} {
+		raw.AddMapping(NewObjectKey(NewKindKey(carGVK), runtime.NewIdentifier(expected[i])), expected[i]+".yaml")
+	}
+
+	for i := 0; i < 10; i++ {
+		keys, err := raw.List(NewKindKey(carGVK))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var ids []string
+		for _, key := range keys {
+			ids = append(ids, key.GetIdentifier())
+		}
+		if !reflect.DeepEqual(ids, expected) {
+			t.Fatalf("expected %v, got %v", expected, ids)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"context"
+	"sync"
+)
+
+// ObjectLocker holds advisory locks for objects within a process. Taking the lock of an object
+// around a read-modify-write sequence serializes the sequences for the same object, which is
+// simpler than retrying on conflicts for frequently written objects. The locks of different
+// objects are independent, and the version of the keys is ignored. Storage operations don't
+// take the locks themselves, so all writers of an object need to take its lock.
+type ObjectLocker struct {
+	locks map[string]*objectLock
+	mux   sync.Mutex
+}
+
+// objectLock is the lock of one object, which is removed once nobody holds or waits for it
+type objectLock struct {
+	// held has a buffer of one, and holds a value while the lock is held
+	held chan struct{}
+	// refs counts the holders and waiters of the lock
+	refs int
+}
+
+// NewObjectLocker returns a new ObjectLocker
+func NewObjectLocker() *ObjectLocker {
+	return &ObjectLocker{locks: make(map[string]*objectLock)}
+}
+
+// Lock takes the lock of the object with the given key, waiting until it's released by its
+// current holder. If ctx is cancelled while waiting, its error is returned. The returned
+// ObjectLock must be unlocked when the object has been written.
+func (l *ObjectLocker) Lock(ctx context.Context, key ObjectKey) (*ObjectLock, error) {
+	id := FormatObjectKey(key)
+
+	l.mux.Lock()
+	lock, ok := l.locks[id]
+	if !ok {
+		lock = &objectLock{held: make(chan struct{}, 1)}
+		l.locks[id] = lock
+	}
+	lock.refs++
+	l.mux.Unlock()
+
+	select {
+	case lock.held <- struct{}{}:
+		return &ObjectLock{locker: l, id: id, lock: lock}, nil
+	case <-ctx.Done():
+		l.release(id, lock)
+		return nil, ctx.Err()
+	}
+}
+
+// release drops a reference to the lock for id, and removes it once it's unreferenced
+func (l *ObjectLocker) release(id string, lock *objectLock) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	lock.refs--
+	if lock.refs == 0 {
+		delete(l.locks, id)
+	}
+}
+
+// ObjectLock is a lock of an object taken using ObjectLocker.Lock
+type ObjectLock struct {
+	locker *ObjectLocker
+	id     string
+	lock   *objectLock
+	once   sync.Once
+}
+
+// Unlock releases the lock, which lets the next waiter take it. Calling Unlock more than once is a no-op.
+func (lock *ObjectLock) Unlock() {
+	lock.once.Do(func() {
+		<-lock.lock.held
+		lock.locker.release(lock.id, lock.lock)
+	})
+}
+
+// Lock takes the advisory lock of the object with the given key, which is shared by all copies of
+// the GenericStorage (see WithContext). See ObjectLocker for the semantics of the locks.
+func (s *GenericStorage) Lock(ctx context.Context, key ObjectKey) (*ObjectLock, error) {
+	return s.locks.Lock(ctx, key)
+}
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/libgitops/pkg/runtime"
+)
+
+func TestObjectLocker(t *testing.T) {
+	l := NewObjectLocker()
+	ctx := context.Background()
+	otherKey := NewObjectKey(NewKindKey(carGVK), runtime.NewIdentifier("default/bar"))
+
+	// Only one goroutine at a time holds the lock of an object
+	var wg sync.WaitGroup
+	var holders, maxHolders int
+	var mux sync.Mutex
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lock, err := l.Lock(ctx, carKey)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer lock.Unlock()
+
+			mux.Lock()
+			holders++
+			if holders > maxHolders {
+				maxHolders = holders
+			}
+			mux.Unlock()
+
+			time.Sleep(time.Millisecond)
+
+			mux.Lock()
+			holders--
+			mux.Unlock()
+		}()
+	}
+	wg.Wait()
+	if maxHolders != 1 {
+		t.Errorf("expected one holder of the lock at a time, got %d", maxHolders)
+	}
+
+	// The lock of another object is independent
+	lock, err := l.Lock(ctx, carKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := l.Lock(ctx, otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.Unlock()
+
+	// Waiting for a held lock is cancelled with the context
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := l.Lock(timeoutCtx, carKey); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	// Unlocking twice is a no-op, and the unused locks are removed
+	lock.Unlock()
+	lock.Unlock()
+	if len(l.locks) != 0 {
+		t.Errorf("expected the unused locks to be removed, got %d", len(l.locks))
+	}
+}
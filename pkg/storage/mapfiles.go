@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/util/filesystem"
+)
+
+// ErrNotMapped is returned by MapFiles if the RawStorage of the Storage isn't a MappedRawStorage
+var ErrNotMapped = errors.New("mapping files requires a MappedRawStorage")
+
+// MapFiles walks dir in fs, which should be the Filesystem of the MappedRawStorage of s, and maps
+// the object declared by every file with an extension in ContentTypes to the file, so that List
+// and Get find the objects without a GenericWatchStorage scanning the directory. This is useful
+// for read-only Filesystems, e.g. the ones of filesystem.OpenArchive. Files declaring objects of
+// types unknown to the scheme of s are skipped. An error is returned if a file can't be decoded,
+// or if an object is declared by more than one file.
+func MapFiles(s Storage, fs filesystem.Filesystem, dir string) error {
+	mapped, ok := s.RawStorage().(MappedRawStorage)
+	if !ok {
+		return ErrNotMapped
+	}
+
+	scheme := s.Serializer().Scheme()
+	mappings := make(map[string]string)
+	return fs.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		if _, ok := ContentTypes[filepath.Ext(path)]; !ok {
+			return nil
+		}
+
+		content, err := fs.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		obj, err := runtime.NewPartialObject(content)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if !scheme.Recognizes(obj.GetObjectKind().GroupVersionKind()) {
+			return nil
+		}
+
+		key, err := s.ObjectKeyFor(obj)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		id := FormatObjectKey(key)
+		if other, ok := mappings[id]; ok {
+			return fmt.Errorf("%s is declared by both %q and %q", key, other, path)
+		}
+		mappings[id] = path
+
+		mapped.AddMapping(key, path)
+		return nil
+	})
+}
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/scheme"
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/serializer"
+	"github.com/weaveworks/libgitops/pkg/util/filesystem"
+)
+
+func TestMapFilesInArchive(t *testing.T) {
+	files := map[string]string{
+		"cars/foo.yaml": "apiVersion: sample-app.weave.works/v1alpha1\nkind: Car\nmetadata:\n  name: foo\n  namespace: default\nspec:\n  brand: Volvo\n",
+		"cars/bar.json": `{"apiVersion": "sample-app.weave.works/v1alpha1", "kind": "Car", "metadata": {"name": "bar", "namespace": "default"}, "spec": {"brand": "Tesla"}}`,
+		// Files of other types or unknown kinds are skipped
+		"README.md":       "# Cars",
+		"deployment.yaml": "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: foo\n",
+	}
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for _, name := range []string{"cars/foo.yaml", "cars/bar.json", "README.md", "deployment.yaml"} {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(files[name]))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(files[name])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fs, err := filesystem.NewTarArchive(&buf, "/manifests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewGenericStorage(NewGenericMappedRawStorageWithFilesystem("/manifests", fs), scheme.Serializer, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier})
+	if err := MapFiles(s, fs, "/manifests"); err != nil {
+		t.Fatal(err)
+	}
+
+	objs, err := s.List(NewKindKey(carGVK))
+	if err != nil {
+		t.Fatal(err)
+	}
+	brands := make(map[string]string)
+	for _, obj := range objs {
+		brands[obj.GetName()] = obj.(*v1alpha1.Car).Spec.Brand
+	}
+	// The content type of each file is detected from its name
+	if expected := map[string]string{"foo": "Volvo", "bar": "Tesla"}; !reflect.DeepEqual(brands, expected) {
+		t.Errorf("expected Cars %v, got %v", expected, brands)
+	}
+
+	// The archive is read-only
+	if err := s.Delete(carKey); !errors.Is(err, filesystem.ErrReadOnly) {
+		t.Errorf("expected ErrReadOnly, got %v", err)
+	}
+
+	// A Storage without mappings is rejected
+	raw := NewGenericRawStorageWithFilesystem("/manifests", carGVK.GroupVersion(), serializer.ContentTypeYAML, fs)
+	if err := MapFiles(NewGenericStorage(raw, scheme.Serializer, nil), fs, "/manifests"); !errors.Is(err, ErrNotMapped) {
+		t.Errorf("expected ErrNotMapped, got %v", err)
+	}
+}
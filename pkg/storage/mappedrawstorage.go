@@ -2,14 +2,16 @@ package storage
 
 import (
 	"fmt"
-	"io/ioutil"
-	"os"
+	"path"
 	"path/filepath"
+	"strings"
 	"sync"
 
-	log "github.com/sirupsen/logrus"
+	"github.com/go-logr/logr"
+	"github.com/weaveworks/libgitops/pkg/logs"
 	"github.com/weaveworks/libgitops/pkg/serializer"
-	"github.com/weaveworks/libgitops/pkg/util"
+	"github.com/weaveworks/libgitops/pkg/util/filesystem"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
 var (
@@ -30,28 +32,111 @@ type MappedRawStorage interface {
 
 	// SetMappings overwrites all known mappings
 	SetMappings(m map[ObjectKey]string)
+	// GetPath retrieves the physical file path mapped for the given Key
+	GetPath(key ObjectKey) (string, error)
 }
 
 func NewGenericMappedRawStorage(dir string) MappedRawStorage {
+	return NewGenericMappedRawStorageWithOptions(dir, DefaultRawStorageOptions())
+}
+
+// NewGenericMappedRawStorageWithFilesystem is the same as NewGenericMappedRawStorage,
+// but reads and writes the mapped files in the given Filesystem.
+func NewGenericMappedRawStorageWithFilesystem(dir string, fs filesystem.Filesystem) MappedRawStorage {
+	opts := DefaultRawStorageOptions()
+	opts.Filesystem = fs
+	return NewGenericMappedRawStorageWithOptions(dir, opts)
+}
+
+// NewGenericMappedRawStorageWithOptions is the same as NewGenericMappedRawStorage, but allows
+// customizing the behavior of the GenericMappedRawStorage using the given RawStorageOptions.
+// The nil fields of opts are set to their defaults.
+func NewGenericMappedRawStorageWithOptions(dir string, opts RawStorageOptions) MappedRawStorage {
+	opts = opts.withDefaults()
 	return &GenericMappedRawStorage{
-		dir:          dir,
-		fileMappings: make(map[ObjectKey]string),
-		mux:          &sync.Mutex{},
+		dir:         dir,
+		kinds:       make(map[schema.GroupKind]*kindMappings),
+		mux:         &sync.RWMutex{},
+		fs:          opts.Filesystem,
+		checksummer: opts.Checksummer,
+		layout:      opts.FileLayout,
+		unsorted:    opts.UnsortedList,
+		maxFileSize: maxFileSize(opts.MaxFileSize),
+		logger:      logs.OrDefault(opts.Logger).WithName("GenericMappedRawStorage"),
 	}
 }
 
 // GenericMappedRawStorage is the default implementation of a MappedRawStorage,
 // it stores files in the given directory via a path translation map.
 type GenericMappedRawStorage struct {
-	dir          string
-	fileMappings map[ObjectKey]string
-	mux          *sync.Mutex
+	dir string
+	// kinds holds the path translation map, sharded by GroupKind so
+	// that operations on different kinds don't contend for a lock
+	kinds map[schema.GroupKind]*kindMappings
+	// mux guards kinds, but not the contents of the shards
+	mux         *sync.RWMutex
+	fs          filesystem.Filesystem
+	checksummer filesystem.Checksummer
+	layout      FileLayout
+	unsorted    bool
+	maxFileSize int64
+	logger      logr.Logger
 }
 
-func (r *GenericMappedRawStorage) realPath(key ObjectKey) (string, error) {
+// kindMappings holds the file paths of the objects of a single GroupKind
+type kindMappings struct {
+	paths map[ObjectKey]string
+	mux   sync.RWMutex
+}
+
+// groupKind returns the GroupKind the mappings of the given kind are stored under,
+// versions are ignored like for List
+func groupKind(kind KindKey) schema.GroupKind {
+	return schema.GroupKind{Group: kind.GetGroup(), Kind: kind.GetKind()}
+}
+
+// mappingsFor returns the mappings of the given kind. If there are none yet,
+// they're added if create is true, otherwise nil is returned.
+func (r *GenericMappedRawStorage) mappingsFor(kind KindKey, create bool) *kindMappings {
+	gk := groupKind(kind)
+
+	r.mux.RLock()
+	m := r.kinds[gk]
+	r.mux.RUnlock()
+	if m != nil || !create {
+		return m
+	}
+
 	r.mux.Lock()
-	path, ok := r.fileMappings[key]
-	r.mux.Unlock()
+	defer r.mux.Unlock()
+	// Another goroutine might have added the mappings in the meantime
+	if m = r.kinds[gk]; m == nil {
+		m = &kindMappings{paths: make(map[ObjectKey]string)}
+		r.kinds[gk] = m
+	}
+	return m
+}
+
+// shards returns the mappings of all kinds
+func (r *GenericMappedRawStorage) shards() []*kindMappings {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	result := make([]*kindMappings, 0, len(r.kinds))
+	for _, m := range r.kinds {
+		result = append(result, m)
+	}
+	return result
+}
+
+func (r *GenericMappedRawStorage) realPath(key ObjectKey) (string, error) {
+	var path string
+	ok := false
+	if m := r.mappingsFor(key, false); m != nil {
+		m.mux.RLock()
+		path, ok = m.paths[key]
+		m.mux.RUnlock()
+	}
 	if !ok {
 		return "", fmt.Errorf("GenericMappedRawStorage: cannot resolve %q: %w", key, ErrNotTracked)
 	}
@@ -66,7 +151,7 @@ func (r *GenericMappedRawStorage) Read(key ObjectKey) ([]byte, error) {
 		return nil, err
 	}
 
-	return ioutil.ReadFile(file)
+	return filesystem.ReadFileLimited(r.fs, file, r.maxFileSize)
 }
 
 func (r *GenericMappedRawStorage) Exists(key ObjectKey) bool {
@@ -75,18 +160,56 @@ func (r *GenericMappedRawStorage) Exists(key ObjectKey) bool {
 		return false
 	}
 
-	return util.FileExists(file)
+	return filesystem.FileExists(r.fs, file)
 }
 
 func (r *GenericMappedRawStorage) Write(key ObjectKey, content []byte) error {
-	// GenericMappedRawStorage isn't going to generate files itself,
-	// only write if the file is already known
+	// Without a FileLayout, GenericMappedRawStorage isn't going to
+	// generate files itself, only write if the file is already known
 	file, err := r.realPath(key)
 	if err != nil {
+		if r.layout == nil {
+			return err
+		}
+
+		return r.create(key, content)
+	}
+
+	return r.fs.WriteFile(file, content, 0644)
+}
+
+// create writes the content of a new object to the file decided by the FileLayout,
+// and maps the object to it. Existing files are never overwritten. The names of key
+// are validated using ValidateKeyPath, and the file must be in the storage directory.
+func (r *GenericMappedRawStorage) create(key ObjectKey, content []byte) error {
+	if err := ValidateKeyPath(key); err != nil {
+		return fmt.Errorf("GenericMappedRawStorage: cannot create %q: %w", key, err)
+	}
+
+	rel := filepath.Clean(filepath.FromSlash(r.layout.PathFor(key)))
+	if filepath.IsAbs(rel) || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("GenericMappedRawStorage: cannot create %q in %q outside of the storage directory: %w", key, rel, ErrUnsafeName)
+	}
+
+	file := filepath.Join(r.dir, rel)
+	if abs, err := filepath.Abs(file); err == nil {
+		file = abs // Use absolute paths, like the ones reported by watchers
+	}
+
+	if filesystem.FileExists(r.fs, file) {
+		return fmt.Errorf("GenericMappedRawStorage: cannot create %q in %q: %w", key, file, ErrAlreadyExists)
+	}
+
+	if err := r.fs.MkdirAll(filepath.Dir(file), 0755); err != nil {
 		return err
 	}
 
-	return ioutil.WriteFile(file, content, 0644)
+	if err := r.fs.WriteFile(file, content, 0644); err != nil {
+		return err
+	}
+
+	r.AddMapping(key, file)
+	return nil
 }
 
 // If the file doesn't exist, returns ErrNotFound + ErrNotTracked.
@@ -98,8 +221,8 @@ func (r *GenericMappedRawStorage) Delete(key ObjectKey) (err error) {
 
 	// GenericMappedRawStorage files can be deleted
 	// externally, check that the file exists first
-	if util.FileExists(file) {
-		err = os.Remove(file)
+	if filesystem.FileExists(r.fs, file) {
+		err = r.fs.Remove(file)
 	}
 
 	if err == nil {
@@ -112,17 +235,24 @@ func (r *GenericMappedRawStorage) Delete(key ObjectKey) (err error) {
 func (r *GenericMappedRawStorage) List(kind KindKey) ([]ObjectKey, error) {
 	result := make([]ObjectKey, 0)
 
-	for key := range r.fileMappings {
-		// Include objects with the same kind and group, ignore version mismatches
-		if key.EqualsGVK(kind, false) {
+	// Snapshot the keys under the lock of the kind, and sort them afterwards.
+	// The mappings include objects of all versions of the kind.
+	if m := r.mappingsFor(kind, false); m != nil {
+		m.mux.RLock()
+		for key := range m.paths {
 			result = append(result, key)
 		}
+		m.mux.RUnlock()
 	}
 
+	if !r.unsorted {
+		SortKeys(result)
+	}
 	return result, nil
 }
 
-// This returns the modification time as a UnixNano string.
+// This returns the checksum computed by the configured Checksummer, by default
+// the modification time as a UnixNano string, prefixed with the algorithm name.
 // If the file doesn't exist, returns ErrNotFound + ErrNotTracked.
 func (r *GenericMappedRawStorage) Checksum(key ObjectKey) (string, error) {
 	path, err := r.realPath(key)
@@ -130,48 +260,88 @@ func (r *GenericMappedRawStorage) Checksum(key ObjectKey) (string, error) {
 		return "", err
 	}
 
-	return checksumFromModTime(path)
+	return r.checksummer.Checksum(r.fs, path)
 }
 
 func (r *GenericMappedRawStorage) ContentType(key ObjectKey) (ct serializer.ContentType) {
 	if file, err := r.realPath(key); err == nil {
 		ct = ContentTypes[filepath.Ext(file)] // Retrieve the correct format based on the extension
+	} else if r.layout != nil {
+		ct = ContentTypes[path.Ext(r.layout.PathFor(key))] // New objects are written to the file decided by the FileLayout
 	}
 
 	return
 }
 
+// ContentTypeCandidates returns the content type decided by the extension of the file, or
+// JSON and YAML for files without a known extension, as JSON is the stricter one of them
+func (r *GenericMappedRawStorage) ContentTypeCandidates(key ObjectKey) []serializer.ContentType {
+	if ct := r.ContentType(key); len(ct) != 0 {
+		return []serializer.ContentType{ct}
+	}
+
+	return []serializer.ContentType{serializer.ContentTypeJSON, serializer.ContentTypeYAML}
+}
+
 func (r *GenericMappedRawStorage) WatchDir() string {
 	return r.dir
 }
 
 func (r *GenericMappedRawStorage) GetKey(path string) (ObjectKey, error) {
-	for key, p := range r.fileMappings {
-		if p == path {
-			return key, nil
+	for _, m := range r.shards() {
+		m.mux.RLock()
+		for key, p := range m.paths {
+			if p == path {
+				m.mux.RUnlock()
+				return key, nil
+			}
 		}
+		m.mux.RUnlock()
 	}
 
 	return objectKey{}, fmt.Errorf("no mapping found for path %q", path)
 }
 
+// If the key isn't mapped, returns ErrNotFound + ErrNotTracked.
+func (r *GenericMappedRawStorage) GetPath(key ObjectKey) (string, error) {
+	return r.realPath(key)
+}
+
 func (r *GenericMappedRawStorage) AddMapping(key ObjectKey, path string) {
-	log.Debugf("GenericMappedRawStorage: AddMapping: %q -> %q", key, path)
-	r.mux.Lock()
-	r.fileMappings[key] = path
-	r.mux.Unlock()
+	if l := r.logger.V(logs.DebugLevel); l.Enabled() {
+		l.Info("AddMapping", "objectID", key.GetIdentifier(), "kind", key.GetKind(), "path", path)
+	}
+	m := r.mappingsFor(key, true)
+	m.mux.Lock()
+	m.paths[key] = path
+	m.mux.Unlock()
 }
 
 func (r *GenericMappedRawStorage) RemoveMapping(key ObjectKey) {
-	log.Debugf("GenericMappedRawStorage: RemoveMapping: %q", key)
-	r.mux.Lock()
-	delete(r.fileMappings, key)
-	r.mux.Unlock()
+	if l := r.logger.V(logs.DebugLevel); l.Enabled() {
+		l.Info("RemoveMapping", "objectID", key.GetIdentifier(), "kind", key.GetKind())
+	}
+	if m := r.mappingsFor(key, false); m != nil {
+		m.mux.Lock()
+		delete(m.paths, key)
+		m.mux.Unlock()
+	}
 }
 
 func (r *GenericMappedRawStorage) SetMappings(m map[ObjectKey]string) {
-	log.Debugf("GenericMappedRawStorage: SetMappings: %v", m)
+	if l := r.logger.V(logs.DebugLevel); l.Enabled() {
+		l.Info("SetMappings", "mappings", m)
+	}
+	kinds := make(map[schema.GroupKind]*kindMappings)
+	for key, path := range m {
+		gk := groupKind(key)
+		if kinds[gk] == nil {
+			kinds[gk] = &kindMappings{paths: make(map[ObjectKey]string)}
+		}
+		kinds[gk].paths[key] = path
+	}
+
 	r.mux.Lock()
-	r.fileMappings = m
+	r.kinds = kinds
 	r.mux.Unlock()
 }
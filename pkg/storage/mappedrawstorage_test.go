@@ -0,0 +1,256 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/scheme"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/serializer"
+	"github.com/weaveworks/libgitops/pkg/util/filesystem"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// testKinds returns n kinds of the same group
+func testKinds(n int) []KindKey {
+	kinds := make([]KindKey, 0, n)
+	for i := 0; i < n; i++ {
+		kinds = append(kinds, NewKindKey(schema.GroupVersionKind{Group: "sample-app.weave.works", Version: "v1alpha1", Kind: fmt.Sprintf("Kind%d", i)}))
+	}
+	return kinds
+}
+
+func testKey(kind KindKey, i int) ObjectKey {
+	return NewObjectKey(kind, runtime.NewIdentifier(fmt.Sprintf("default/obj-%d", i)))
+}
+
+// TestMappingsConcurrency modifies and reads the mappings of several kinds concurrently,
+// it's most useful when run with -race
+func TestMappingsConcurrency(t *testing.T) {
+	const objects = 200
+	r := NewGenericMappedRawStorage("manifests").(*GenericMappedRawStorage)
+	kinds := testKinds(4)
+
+	// The objects are listed for other versions of their kinds as well
+	otherVersion := func(kind KindKey) KindKey {
+		gvk := kind.GetGVK()
+		gvk.Version = "v1alpha2"
+		return NewKindKey(gvk)
+	}
+
+	var wg sync.WaitGroup
+	for _, kind := range kinds {
+		kind := kind
+		// Writers add all objects, and remove the odd ones again
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < objects; i++ {
+				r.AddMapping(testKey(kind, i), fmt.Sprintf("%s-%d.yaml", kind.GetKind(), i))
+				if i%2 == 1 {
+					r.RemoveMapping(testKey(kind, i))
+				}
+			}
+		}()
+		// Readers list, count and resolve the objects meanwhile
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < objects; i++ {
+				keys, _ := r.List(otherVersion(kind))
+				for _, key := range keys {
+					if key.GetKind() != kind.GetKind() {
+						t.Errorf("expected only %s objects to be listed, got %s", kind.GetKind(), key)
+					}
+				}
+				_ = r.CountAllKeys(kind)
+				_, _ = r.GetPath(testKey(kind, i))
+				_, _ = r.GetKey(fmt.Sprintf("%s-%d.yaml", kind.GetKind(), i))
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, kind := range kinds {
+		keys, err := r.List(kind)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) != objects/2 || r.CountAllKeys(kind) != objects/2 {
+			t.Errorf("expected %d %s objects, got %d", objects/2, kind.GetKind(), len(keys))
+		}
+		for _, key := range keys {
+			path, err := r.GetPath(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if found, err := r.GetKey(path); err != nil || found != key {
+				t.Errorf("expected %q to be mapped to %s, got %v (%v)", path, key, found, err)
+			}
+		}
+	}
+
+	// SetMappings replaces the mappings of all kinds, while they're modified
+	m := map[ObjectKey]string{testKey(kinds[0], 0): "replaced.yaml"}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		r.SetMappings(m)
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < objects; i++ {
+			r.AddMapping(testKey(kinds[1], objects+i), "added.yaml")
+			_, _ = r.List(kinds[0])
+		}
+	}()
+	wg.Wait()
+
+	if path, err := r.GetPath(testKey(kinds[0], 0)); err != nil || path != "replaced.yaml" {
+		t.Errorf("expected the mappings to be replaced, got %q (%v)", path, err)
+	}
+	for _, kind := range kinds[2:] {
+		if count := r.CountAllKeys(kind); count != 0 {
+			t.Errorf("expected no %s objects after replacing the mappings, got %d", kind.GetKind(), count)
+		}
+	}
+	// Modifying the map passed to SetMappings doesn't affect the storage
+	delete(m, testKey(kinds[0], 0))
+	if _, err := r.GetPath(testKey(kinds[0], 0)); err != nil {
+		t.Error(err)
+	}
+}
+
+// BenchmarkMappingsContention lists the objects of one kind while the mappings of other
+// kinds are modified concurrently. As the mappings are locked per kind, the readers don't
+// contend with the writers, e.g. go test -run=^$ -bench=MappingsContention -cpu=1,4 ./pkg/storage
+func BenchmarkMappingsContention(b *testing.B) {
+	const objects = 1000
+	r := NewGenericMappedRawStorage("manifests").(*GenericMappedRawStorage)
+	r.unsorted = true
+	kinds := testKinds(8)
+	for _, kind := range kinds {
+		for i := 0; i < objects; i++ {
+			r.AddMapping(testKey(kind, i), fmt.Sprintf("%s-%d.yaml", kind.GetKind(), i))
+		}
+	}
+
+	var worker int32
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		// Every other goroutine is a writer of a kind, the others list the first kind
+		n := int(atomic.AddInt32(&worker, 1))
+		kind := kinds[1+n%(len(kinds)-1)]
+		for i := 0; pb.Next(); i++ {
+			if n%2 == 0 {
+				r.AddMapping(testKey(kind, i%objects), "updated.yaml")
+			} else if _, err := r.List(kinds[0]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestMaxFileSize(t *testing.T) {
+	fs := filesystem.NewInMemory()
+	if err := fs.MkdirAll("/manifests/Car/default/foo", 0755); err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("apiVersion: sample-app.weave.works/v1alpha1\nkind: Car\nmetadata:\n  name: foo\n  namespace: default\n")
+	// The GenericRawStorage uses either extension of YAML files
+	for _, filename := range []string{"/manifests/foo.yaml", "/manifests/Car/default/foo/metadata.yaml", "/manifests/Car/default/foo/metadata.yml"} {
+		if err := fs.WriteFile(filename, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	opts := DefaultRawStorageOptions()
+	opts.Filesystem = fs
+	mapped := NewGenericMappedRawStorageWithOptions("/manifests", opts)
+	mapped.AddMapping(carKey, "/manifests/foo.yaml")
+	raws := map[string]RawStorage{
+		"mapped":  mapped,
+		"generic": NewGenericRawStorageWithOptions("/manifests", carGVK.GroupVersion(), serializer.ContentTypeYAML, opts),
+	}
+	for name, raw := range raws {
+		// The files are limited to the default maximum size
+		if actual, err := raw.Read(carKey); err != nil || !bytes.Equal(actual, content) {
+			t.Errorf("%s: expected content %q, got %q (%v)", name, content, actual, err)
+		}
+	}
+
+	opts.MaxFileSize = int64(len(content)) - 1
+	mapped = NewGenericMappedRawStorageWithOptions("/manifests", opts)
+	mapped.AddMapping(carKey, "/manifests/foo.yaml")
+	raws = map[string]RawStorage{
+		"mapped":  mapped,
+		"generic": NewGenericRawStorageWithOptions("/manifests", carGVK.GroupVersion(), serializer.ContentTypeYAML, opts),
+	}
+	for name, raw := range raws {
+		// Every reader of the RawStorage is limited, including Get of the Storage
+		s := NewGenericStorage(raw, scheme.Serializer, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier})
+		if _, err := s.Get(carKey); !errors.Is(err, filesystem.ErrFileTooLarge) {
+			t.Errorf("%s: expected ErrFileTooLarge, got %v", name, err)
+		}
+	}
+
+	if r := NewGenericMappedRawStorage("/manifests").(*GenericMappedRawStorage); r.maxFileSize != filesystem.DefaultMaxFileSize {
+		t.Errorf("expected the default maximum size %d, got %d", filesystem.DefaultMaxFileSize, r.maxFileSize)
+	}
+}
+
+func TestZeroRawStorageOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rawstorage-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The files are stored on the local disk, and checksummed using their modification time
+	mapped := NewGenericMappedRawStorageWithOptions(filepath.Join(dir, "mapped"), RawStorageOptions{})
+	mapped.AddMapping(carKey, filepath.Join(dir, "mapped", "foo.yaml"))
+	if err := os.MkdirAll(filepath.Join(dir, "mapped"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	raws := map[string]RawStorage{
+		"mapped":  mapped,
+		"generic": NewGenericRawStorageWithOptions(filepath.Join(dir, "generic"), carGVK.GroupVersion(), serializer.ContentTypeYAML, RawStorageOptions{}),
+	}
+	content := []byte("apiVersion: sample-app.weave.works/v1alpha1\nkind: Car\nmetadata:\n  name: foo\n  namespace: default\n")
+	for name, raw := range raws {
+		t.Run(name, func(t *testing.T) {
+			if err := raw.Write(carKey, content); err != nil {
+				t.Fatal(err)
+			}
+			if !raw.Exists(carKey) {
+				t.Error("expected the object to exist")
+			}
+			if read, err := raw.Read(carKey); err != nil || !bytes.Equal(read, content) {
+				t.Errorf("expected the written content, got %q (%v)", read, err)
+			}
+			if checksum, err := raw.Checksum(carKey); err != nil || len(checksum) == 0 {
+				t.Errorf("expected a checksum, got %q (%v)", checksum, err)
+			}
+		})
+	}
+
+	var files int
+	if err := filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			files++
+		}
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if files != len(raws) {
+		t.Errorf("expected %d files on the local disk, got %d", len(raws), files)
+	}
+}
@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// mappingsFormatVersion is the version of the format written by SaveMappings
+const mappingsFormatVersion = 1
+
+// ErrUnsupportedMappingsVersion is returned by LoadMappings for snapshots written in an unknown format
+var ErrUnsupportedMappingsVersion = errors.New("unsupported mappings snapshot version")
+
+// ChecksumPath is the file an object is mapped to, and the checksum the file had when the
+// mapping was exported, as computed by the Checksummer of the RawStorage
+type ChecksumPath struct {
+	Path     string
+	Checksum string
+}
+
+// MappingSnapshotter is implemented by MappedRawStorages which can export their mappings, and
+// restore them later, e.g. to skip reading all files when restarting. The snapshots can be
+// persisted using SaveMappings and LoadMappings.
+type MappingSnapshotter interface {
+	// ExportMappings returns the mappings of all objects, along with the current checksums
+	// of their files. Objects whose files can't be checksummed, e.g. because they've been
+	// removed, are left out.
+	ExportMappings() map[ObjectKey]ChecksumPath
+	// RestoreMappings overwrites all known mappings with the ones of the given snapshot,
+	// like SetMappings. Objects whose files have changed since the snapshot was exported
+	// aren't restored, but are returned, so that their files can be read again.
+	RestoreMappings(m map[ObjectKey]ChecksumPath) (stale []ObjectKey)
+}
+
+var _ MappingSnapshotter = &GenericMappedRawStorage{}
+
+func (r *GenericMappedRawStorage) ExportMappings() map[ObjectKey]ChecksumPath {
+	// Snapshot the paths first, so that no lock is held while checksumming
+	paths := make(map[ObjectKey]string)
+	for _, m := range r.shards() {
+		m.mux.RLock()
+		for key, path := range m.paths {
+			paths[key] = path
+		}
+		m.mux.RUnlock()
+	}
+
+	result := make(map[ObjectKey]ChecksumPath, len(paths))
+	for key, path := range paths {
+		if checksum, err := r.checksummer.Checksum(r.fs, path); err == nil {
+			result[key] = ChecksumPath{Path: path, Checksum: checksum}
+		}
+	}
+	return result
+}
+
+func (r *GenericMappedRawStorage) RestoreMappings(m map[ObjectKey]ChecksumPath) (stale []ObjectKey) {
+	valid := make(map[ObjectKey]string, len(m))
+	for key, cp := range m {
+		// Only stat the files, or whatever the Checksummer needs to detect changes
+		if checksum, err := r.checksummer.Checksum(r.fs, cp.Path); err == nil && checksum == cp.Checksum {
+			valid[key] = cp.Path
+		} else {
+			stale = append(stale, key)
+		}
+	}
+
+	r.SetMappings(valid)
+	SortKeys(stale)
+	return
+}
+
+// mappingsFile is the on-disk format of a mappings snapshot
+type mappingsFile struct {
+	Version  int            `json:"version"`
+	Mappings []mappingEntry `json:"mappings"`
+}
+
+// mappingEntry is the on-disk format of a single mapping
+type mappingEntry struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	ID         string `json:"id"`
+	Path       string `json:"path"`
+	Checksum   string `json:"checksum"`
+}
+
+// SaveMappings writes the given mappings, e.g. returned by ExportMappings, to w
+// in a versioned JSON format, which can be read back by LoadMappings
+func SaveMappings(w io.Writer, m map[ObjectKey]ChecksumPath) error {
+	f := mappingsFile{Version: mappingsFormatVersion, Mappings: make([]mappingEntry, 0, len(m))}
+	for key, cp := range m {
+		apiVersion, kind := key.GetGVK().ToAPIVersionAndKind()
+		f.Mappings = append(f.Mappings, mappingEntry{apiVersion, kind, key.GetIdentifier(), cp.Path, cp.Checksum})
+	}
+
+	// Sort the mappings by path, so that the same mappings are always written the same way
+	sort.Slice(f.Mappings, func(i, j int) bool {
+		return f.Mappings[i].Path < f.Mappings[j].Path
+	})
+
+	return json.NewEncoder(w).Encode(&f)
+}
+
+// LoadMappings reads mappings written by SaveMappings from r, e.g. to pass them to RestoreMappings.
+// Snapshots written in an unknown format return ErrUnsupportedMappingsVersion.
+func LoadMappings(r io.Reader) (map[ObjectKey]ChecksumPath, error) {
+	var f mappingsFile
+	if err := json.NewDecoder(r).Decode(&f); err != nil {
+		return nil, fmt.Errorf("failed to read mappings snapshot: %w", err)
+	}
+
+	if f.Version != mappingsFormatVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedMappingsVersion, f.Version)
+	}
+
+	result := make(map[ObjectKey]ChecksumPath, len(f.Mappings))
+	for _, e := range f.Mappings {
+		gvk := schema.FromAPIVersionAndKind(e.APIVersion, e.Kind)
+		key := NewObjectKey(NewKindKey(gvk), runtime.NewIdentifier(e.ID))
+		result[key] = ChecksumPath{Path: e.Path, Checksum: e.Checksum}
+	}
+	return result, nil
+}
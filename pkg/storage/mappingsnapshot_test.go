@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/scheme"
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/util/filesystem"
+)
+
+func TestMappingSnapshot(t *testing.T) {
+	dir, err := filepath.Abs("manifests")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs := filesystem.NewInMemory()
+	newStorage := func() (*GenericMappedRawStorage, Storage) {
+		rawOpts := DefaultRawStorageOptions()
+		rawOpts.Filesystem = fs
+		rawOpts.FileLayout = FlatLayout
+		rawOpts.Checksummer = filesystem.SHA256Checksummer
+		raw := NewGenericMappedRawStorageWithOptions(dir, rawOpts)
+		return raw.(*GenericMappedRawStorage), NewGenericStorage(raw, scheme.Serializer, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier})
+	}
+
+	raw, s := newStorage()
+	var keys []ObjectKey
+	for _, name := range []string{"foo", "bar", "baz"} {
+		keys = append(keys, createTestCar(t, s, name))
+	}
+
+	// Save the snapshot, and load it again
+	var buf bytes.Buffer
+	if err := SaveMappings(&buf, raw.ExportMappings()); err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := LoadMappings(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(snapshot, raw.ExportMappings()) {
+		t.Errorf("expected the loaded snapshot to equal the exported one, got %v", snapshot)
+	}
+
+	// Modify foo and remove bar before restoring the snapshot, only baz is unchanged
+	fooPath, _ := raw.GetPath(keys[0])
+	barPath, _ := raw.GetPath(keys[1])
+	if err := fs.WriteFile(fooPath, []byte("modified"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Remove(barPath); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, rs := newStorage()
+	stale := restored.RestoreMappings(snapshot)
+	if expected := []ObjectKey{keys[1], keys[0]}; !reflect.DeepEqual(stale, expected) {
+		t.Errorf("expected the stale keys %v, got %v", expected, stale)
+	}
+	for _, key := range keys[:2] {
+		if restored.Exists(key) {
+			t.Errorf("expected %s not to be restored", key)
+		}
+	}
+
+	obj, err := rs.Get(keys[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if car := obj.(*v1alpha1.Car); car.GetName() != "baz" || car.Spec.Brand != "Volvo" {
+		t.Errorf("expected baz to be restored, got %v", car)
+	}
+}
+
+func TestLoadMappingsVersion(t *testing.T) {
+	_, err := LoadMappings(strings.NewReader(`{"version":2,"mappings":[]}`))
+	if !errors.Is(err, ErrUnsupportedMappingsVersion) {
+		t.Errorf("expected ErrUnsupportedMappingsVersion, got %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// ErrNamespacedMismatch is returned when a namespace is given for a kind which isn't namespaced,
+// or when no namespace is given for a namespaced kind
+var ErrNamespacedMismatch = errors.New("mismatch between namespace and scope of the kind")
+
+// Namespacer decides whether the objects of a GroupKind are namespaced
+type Namespacer interface {
+	// IsNamespaced returns true if the objects of the given GroupKind are namespaced,
+	// or false if they're cluster-scoped
+	IsNamespaced(gk schema.GroupKind) (bool, error)
+}
+
+// StaticNamespacer implements Namespacer using a static default policy with exceptions
+type StaticNamespacer struct {
+	// NamespacedIsDefault specifies whether the kinds are namespaced by default
+	NamespacedIsDefault bool
+	// Exceptions are the kinds which don't follow the default policy
+	Exceptions []schema.GroupKind
+}
+
+var _ Namespacer = StaticNamespacer{}
+
+// IsNamespaced implements Namespacer
+func (n StaticNamespacer) IsNamespaced(gk schema.GroupKind) (bool, error) {
+	for _, exception := range n.Exceptions {
+		if exception == gk {
+			return !n.NamespacedIsDefault, nil
+		}
+	}
+
+	return n.NamespacedIsDefault, nil
+}
+
+// SchemeNamespacer implements Namespacer by looking up the types registered in a runtime.Scheme.
+// Kinds whose types implement metav1.Object are namespaced, other registered kinds are not. The
+// v1.Namespace kind is never namespaced. Kinds which aren't registered fall back to a default.
+type SchemeNamespacer struct {
+	scheme              *kruntime.Scheme
+	namespacedIsDefault bool
+}
+
+var _ Namespacer = &SchemeNamespacer{}
+
+// NewSchemeNamespacer creates a new SchemeNamespacer for the given scheme, e.g. the one of
+// serializer.Serializer.Scheme(). namespacedIsDefault decides whether kinds which aren't
+// registered in the scheme are namespaced.
+func NewSchemeNamespacer(scheme *kruntime.Scheme, namespacedIsDefault bool) *SchemeNamespacer {
+	return &SchemeNamespacer{scheme, namespacedIsDefault}
+}
+
+// IsNamespaced implements Namespacer
+func (n *SchemeNamespacer) IsNamespaced(gk schema.GroupKind) (bool, error) {
+	if gk == NamespaceGVK.GroupKind() {
+		return false, nil
+	}
+
+	for _, gv := range n.scheme.PrioritizedVersionsForGroup(gk.Group) {
+		gvk := gv.WithKind(gk.Kind)
+		if !n.scheme.Recognizes(gvk) {
+			continue
+		}
+
+		obj, err := n.scheme.New(gvk)
+		if err != nil {
+			return false, err
+		}
+
+		_, namespaced := obj.(metav1.Object)
+		return namespaced, nil
+	}
+
+	return n.namespacedIsDefault, nil
+}
+
+// validateNamespace returns an error wrapping ErrNamespacedMismatch if the given namespace doesn't
+// match the scope of the kind, as decided by Options.Namespacer. Without a Namespacer, any
+// namespace is allowed.
+func (s *GenericStorage) validateNamespace(kind KindKey, namespace string) error {
+	if s.opts.Namespacer == nil {
+		return nil
+	}
+
+	gk := kind.GetGVK().GroupKind()
+	namespaced, err := s.opts.Namespacer.IsNamespaced(gk)
+	if err != nil {
+		return err
+	}
+
+	if namespaced && len(namespace) == 0 {
+		return fmt.Errorf("%s is namespaced, but no namespace was given: %w", gk, ErrNamespacedMismatch)
+	} else if !namespaced && len(namespace) != 0 {
+		return fmt.Errorf("%s is not namespaced, but namespace %q was given: %w", gk, namespace, ErrNamespacedMismatch)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/scheme"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/util/filesystem"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestNamespacedMismatch(t *testing.T) {
+	rawOpts := DefaultRawStorageOptions()
+	rawOpts.Filesystem = filesystem.NewInMemory()
+	opts := DefaultOptions()
+	opts.Namespacer = StaticNamespacer{
+		NamespacedIsDefault: true,
+		Exceptions:          []schema.GroupKind{namespaceGVK.GroupKind()},
+	}
+	raw := NewGenericMappedRawStorageWithOptions("manifests", rawOpts)
+	s := NewGenericStorageWithOptions(raw, scheme.Serializer, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier}, opts)
+
+	tests := []struct {
+		name      string
+		kind      KindKey
+		namespace string
+		mismatch  bool
+	}{
+		{"namespaced kind with namespace", NewKindKey(carGVK), "default", false},
+		{"namespaced kind without namespace", NewKindKey(carGVK), "", true},
+		{"cluster-scoped kind without namespace", NewKindKey(namespaceGVK), "", false},
+		{"cluster-scoped kind with namespace", NewKindKey(namespaceGVK), "default", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := s.CountNamespace(tt.kind, tt.namespace); errors.Is(err, ErrNamespacedMismatch) != tt.mismatch {
+				t.Errorf("expected mismatch to be %t, got %v", tt.mismatch, err)
+			}
+		})
+	}
+
+	if _, err := s.ListNamespaces(NewKindKey(carGVK)); err != nil {
+		t.Errorf("expected the namespaces of a namespaced kind to be listed, got %v", err)
+	}
+	if _, err := s.ListNamespaces(NewKindKey(namespaceGVK)); !errors.Is(err, ErrNamespacedMismatch) {
+		t.Errorf("expected ErrNamespacedMismatch for a cluster-scoped kind, got %v", err)
+	}
+
+	// Without a Namespacer, any namespace is allowed
+	s = NewGenericStorage(raw, scheme.Serializer, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier})
+	if _, err := s.CountNamespace(NewKindKey(namespaceGVK), "default"); err != nil {
+		t.Errorf("expected no error without a Namespacer, got %v", err)
+	}
+}
+
+func TestSchemeNamespacer(t *testing.T) {
+	unknown := schema.GroupKind{Group: "example.com", Kind: "Unknown"}
+	tests := []struct {
+		name                string
+		gk                  schema.GroupKind
+		namespacedIsDefault bool
+		expected            bool
+	}{
+		{"registered kind", carGVK.GroupKind(), false, true},
+		{"namespace kind", namespaceGVK.GroupKind(), true, false},
+		{"unknown kind with namespaced default", unknown, true, true},
+		{"unknown kind with cluster-scoped default", unknown, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := NewSchemeNamespacer(scheme.Serializer.Scheme(), tt.namespacedIsDefault)
+			if namespaced, err := n.IsNamespaced(tt.gk); err != nil || namespaced != tt.expected {
+				t.Errorf("expected %t, got %t (%v)", tt.expected, namespaced, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// NamespaceSource decides how GenericStorage.ListNamespaces determines the existing namespaces
+type NamespaceSource int
+
+const (
+	// NamespacesFromObjects derives the namespaces from the objects of the listed kind. A
+	// namespace only exists as long as at least one object of the kind is in it. (Default)
+	NamespacesFromObjects NamespaceSource = iota
+	// NamespacesFromNamespaceKind uses the names of the v1.Namespace objects stored, regardless
+	// of the listed kind, like a Kubernetes cluster does. A namespace exists as long as its
+	// v1.Namespace object does, even if there are no other objects in it.
+	NamespacesFromNamespaceKind
+)
+
+// NamespaceGVK is the GroupVersionKind of the v1.Namespace objects
+// used by NamespacesFromNamespaceKind
+var NamespaceGVK = schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}
+
+// ListNamespaces returns the sorted namespaces of the Objects of a specific kind, as
+// determined by Options.NamespaceSource. If Options.Namespacer is set, and decides that the kind isn't
+// namespaced, an error wrapping ErrNamespacedMismatch is returned.
+func (s *GenericStorage) ListNamespaces(kind KindKey) ([]string, error) {
+	if s.opts.Namespacer != nil {
+		gk := kind.GetGVK().GroupKind()
+		if namespaced, err := s.opts.Namespacer.IsNamespaced(gk); err != nil {
+			return nil, err
+		} else if !namespaced {
+			return nil, fmt.Errorf("cannot list the namespaces of %s, as it's not namespaced: %w", gk, ErrNamespacedMismatch)
+		}
+	}
+
+	if s.opts.NamespaceSource == NamespacesFromNamespaceKind {
+		keys, err := s.raw.List(NewKindKey(NamespaceGVK))
+		if err != nil {
+			return nil, err
+		}
+
+		namespaces := make([]string, 0, len(keys))
+		for _, key := range keys {
+			_, name := splitIdentifier(key)
+			namespaces = append(namespaces, name)
+		}
+		sort.Strings(namespaces)
+		return namespaces, nil
+	}
+
+	keys, err := s.raw.List(kind)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	namespaces := []string{}
+	for _, key := range keys {
+		if namespace, _ := splitIdentifier(key); len(namespace) != 0 && !seen[namespace] {
+			seen[namespace] = true
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	sort.Strings(namespaces)
+	return namespaces, nil
+}
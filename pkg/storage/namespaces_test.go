@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/scheme"
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/util/filesystem"
+)
+
+func TestListNamespaces(t *testing.T) {
+	dir, err := filepath.Abs("manifests")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		source        NamespaceSource
+		afterDeletion []string
+	}{
+		{"from objects", NamespacesFromObjects, []string{"a"}},
+		{"from namespace kind", NamespacesFromNamespaceKind, []string{"a", "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rawOpts := DefaultRawStorageOptions()
+			rawOpts.Filesystem = filesystem.NewInMemory()
+			rawOpts.FileLayout = FlatLayout
+			opts := DefaultOptions()
+			opts.NamespaceSource = tt.source
+			raw := NewGenericMappedRawStorageWithOptions(dir, rawOpts)
+			s := NewGenericStorageWithOptions(raw, scheme.Serializer, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier}, opts)
+
+			for _, namespace := range []string{"a", "b"} {
+				content := []byte("apiVersion: v1\nkind: Namespace\nmetadata:\n  name: " + namespace + "\n")
+				if err := raw.Write(NewObjectKey(NewKindKey(NamespaceGVK), runtime.NewIdentifier(namespace)), content); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var keys []ObjectKey
+			for _, namespace := range []string{"b", "a", "a"} {
+				car := &v1alpha1.Car{}
+				car.SetNamespace(namespace)
+				car.SetName("car" + string(rune('0'+len(keys))))
+				if err := s.Create(car); err != nil {
+					t.Fatal(err)
+				}
+				key, err := s.ObjectKeyFor(car)
+				if err != nil {
+					t.Fatal(err)
+				}
+				keys = append(keys, key)
+			}
+
+			expect := func(step string, expected []string) {
+				namespaces, err := s.ListNamespaces(NewKindKey(carGVK))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(namespaces, expected) {
+					t.Errorf("%s: expected %v, got %v", step, expected, namespaces)
+				}
+			}
+			expect("created", []string{"a", "b"})
+
+			// Delete the last car in namespace b
+			if err := s.Delete(keys[0]); err != nil {
+				t.Fatal(err)
+			}
+			expect("deleted", tt.afterDeletion)
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package storage
+
+import (
+	"context"
+	"reflect"
+	"sort"
+
+	"github.com/weaveworks/libgitops/pkg/logs"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// GarbageCollector is implemented by Storages which can remove the objects whose owners,
+// as referenced by metadata.ownerReferences, no longer exist
+type GarbageCollector interface {
+	// GarbageCollect removes all objects whose owners no longer exist,
+	// and returns the keys of the removed objects
+	GarbageCollect(ctx context.Context) ([]ObjectKey, error)
+}
+
+var _ GarbageCollector = &GenericStorage{}
+
+// GarbageCollect removes all objects with ownerReferences, whose owners all no longer exist. Like
+// in Kubernetes, objects are only removed if none of their owners exist. Removing an object may
+// orphan its own dependents, which are then removed as well. Objects owning each other in a cycle
+// are kept, as long as any of them exists. The owner graph is built from the objects of all kinds
+// registered in the scheme of the Serializer.
+func (s *GenericStorage) GarbageCollect(ctx context.Context) ([]ObjectKey, error) {
+	g, err := s.ownerGraph()
+	if err != nil {
+		return nil, err
+	}
+
+	var collected []ObjectKey
+	deleted := make(map[ObjectKey]bool)
+	for changed := true; changed; {
+		changed = false
+		for _, key := range g.keys {
+			if deleted[key] || !g.orphaned(key, deleted) {
+				continue
+			}
+
+			if err := ctx.Err(); err != nil {
+				return collected, err
+			}
+			if err := s.delete(key); err != nil {
+				return collected, err
+			}
+
+			s.logger.V(logs.DebugLevel).Info("Collected object, as its owners no longer exist", "kind", key.GetKind(), "objectID", key.GetIdentifier())
+			deleted[key] = true
+			collected = append(collected, key)
+			changed = true
+		}
+	}
+
+	return collected, nil
+}
+
+// cascadingDelete deletes the object with the given key, and all its dependents which
+// no longer have any existing owner after that, recursively. Every object is only
+// visited once, so objects owning each other in a cycle don't cause an endless loop.
+func (s *GenericStorage) cascadingDelete(key ObjectKey) error {
+	g, err := s.ownerGraph()
+	if err != nil {
+		return err
+	}
+
+	if err := s.delete(key); err != nil {
+		return err
+	}
+
+	deleted := map[ObjectKey]bool{key: true}
+	queue := []ObjectKey{key}
+	for len(queue) != 0 {
+		owner := queue[0]
+		queue = queue[1:]
+
+		for _, dependent := range g.dependents[owner] {
+			if deleted[dependent] || !g.orphaned(dependent, deleted) {
+				continue
+			}
+
+			if err := s.delete(dependent); err != nil {
+				return err
+			}
+
+			s.logger.V(logs.DebugLevel).Info("Deleted dependent, as its owner was deleted", "kind", dependent.GetKind(), "objectID", dependent.GetIdentifier(),
+				"ownerKind", owner.GetKind(), "ownerID", owner.GetIdentifier())
+			deleted[dependent] = true
+			queue = append(queue, dependent)
+		}
+	}
+
+	return nil
+}
+
+// ownerID identifies an object as referenced by an OwnerReference
+type ownerID struct {
+	gk        schema.GroupKind
+	namespace string
+	name      string
+}
+
+// ownerGraph holds the owner relationships between all stored objects
+type ownerGraph struct {
+	// keys holds the keys of all objects, in a deterministic order
+	keys []ObjectKey
+	// objects holds the metadata of all objects
+	objects map[ObjectKey]runtime.PartialObject
+	// ids maps the identities referenced by OwnerReferences to the keys of the objects
+	ids map[ownerID]ObjectKey
+	// dependents holds the keys of the existing objects owned by each object
+	dependents map[ObjectKey][]ObjectKey
+}
+
+// ownerGraph builds the owner graph of all objects of the kinds registered in the scheme
+func (s *GenericStorage) ownerGraph() (*ownerGraph, error) {
+	g := &ownerGraph{
+		objects:    make(map[ObjectKey]runtime.PartialObject),
+		ids:        make(map[ownerID]ObjectKey),
+		dependents: make(map[ObjectKey][]ObjectKey),
+	}
+
+	for _, kind := range s.objectKinds() {
+		keys, err := s.raw.List(kind)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, key := range keys {
+			obj, err := s.GetMeta(key)
+			if err != nil {
+				return nil, err
+			}
+
+			g.keys = append(g.keys, key)
+			g.objects[key] = obj
+			g.ids[ownerID{key.GetGVK().GroupKind(), obj.GetNamespace(), obj.GetName()}] = key
+		}
+	}
+
+	for _, key := range g.keys {
+		for _, ref := range g.objects[key].GetOwnerReferences() {
+			if owner, ok := g.owner(key, ref); ok {
+				g.dependents[owner] = append(g.dependents[owner], key)
+			}
+		}
+	}
+
+	return g, nil
+}
+
+// owner returns the key of the existing object referenced by the given OwnerReference of the
+// object with the given key. The owner is either in the same namespace, or cluster-scoped.
+func (g *ownerGraph) owner(key ObjectKey, ref metav1.OwnerReference) (ObjectKey, bool) {
+	gv, err := schema.ParseGroupVersion(ref.APIVersion)
+	if err != nil {
+		return nil, false
+	}
+
+	gk := gv.WithKind(ref.Kind).GroupKind()
+	for _, namespace := range []string{g.objects[key].GetNamespace(), ""} {
+		owner, ok := g.ids[ownerID{gk, namespace, ref.Name}]
+		if !ok {
+			continue
+		}
+
+		// A different UID means that the owner has been replaced by a new object with the same name
+		if uid := g.objects[owner].GetUID(); len(ref.UID) != 0 && len(uid) != 0 && ref.UID != uid {
+			return nil, false
+		}
+		return owner, true
+	}
+
+	return nil, false
+}
+
+// orphaned returns true if the object with the given key has ownerReferences,
+// but none of its owners exist anymore, when not counting the deleted objects
+func (g *ownerGraph) orphaned(key ObjectKey, deleted map[ObjectKey]bool) bool {
+	refs := g.objects[key].GetOwnerReferences()
+	if len(refs) == 0 {
+		return false
+	}
+
+	for _, ref := range refs {
+		if owner, ok := g.owner(key, ref); ok && !deleted[owner] {
+			return false
+		}
+	}
+	return true
+}
+
+// objectKinds returns the preferred versions of all kinds registered in the
+// scheme of the Serializer, whose types have an ObjectMeta
+func (s *GenericStorage) objectKinds() []KindKey {
+	scheme := s.serializer.Scheme()
+
+	var kinds []KindKey
+	for _, gv := range scheme.PreferredVersionAllGroups() {
+		for kind, t := range scheme.KnownTypes(gv) {
+			if _, ok := reflect.New(t).Interface().(runtime.Object); ok {
+				kinds = append(kinds, NewKindKey(gv.WithKind(kind)))
+			}
+		}
+	}
+
+	// Sort the kinds, so that objects are collected in a deterministic order
+	sort.Slice(kinds, func(i, j int) bool {
+		return kinds[i].GetGVK().String() < kinds[j].GetGVK().String()
+	})
+	return kinds
+}
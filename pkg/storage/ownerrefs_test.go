@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestOwnerReferences(t *testing.T) {
+	newStorage := func(cascading bool) *GenericStorage {
+		s, _, _ := newTestStorage(t, func(_ *RawStorageOptions, opts *Options) {
+			opts.CascadingDelete = cascading
+		})
+		return s.(*GenericStorage)
+	}
+
+	// The objects are given as name: owners
+	objects := map[string][]string{
+		"owner":     nil,
+		"dependent": {"owner"},
+		"orphan":    {"missing"},
+		"chained":   {"orphan"},
+		"shared":    {"owner", "missing"},
+		"cycle-a":   {"cycle-b"},
+		"cycle-b":   {"cycle-a"},
+	}
+	create := func(s *GenericStorage) {
+		for name, owners := range objects {
+			car := &v1alpha1.Car{}
+			car.SetName(name)
+			car.SetNamespace("default")
+			for _, owner := range owners {
+				car.OwnerReferences = append(car.OwnerReferences, metav1.OwnerReference{
+					APIVersion: v1alpha1.SchemeGroupVersion.String(),
+					Kind:       "Car",
+					Name:       owner,
+				})
+			}
+			if err := s.Create(car); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	key := func(name string) ObjectKey {
+		return NewObjectKey(NewKindKey(carGVK), runtime.NewIdentifier("default/"+name))
+	}
+	expectRemaining := func(s *GenericStorage, expected ...string) {
+		t.Helper()
+		keys, err := s.raw.List(NewKindKey(carGVK))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var names []string
+		for _, k := range keys {
+			_, name := splitIdentifier(k)
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		sort.Strings(expected)
+		if !reflect.DeepEqual(names, expected) {
+			t.Errorf("expected %v to remain, got %v", expected, names)
+		}
+	}
+
+	t.Run("garbage collection", func(t *testing.T) {
+		s := newStorage(false)
+		create(s)
+
+		// Objects owning each other in a cycle are kept
+		collected, err := s.GarbageCollect(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := []ObjectKey{key("orphan"), key("chained")}; !reflect.DeepEqual(collected, expected) {
+			t.Errorf("expected %v to be collected, got %v", expected, collected)
+		}
+		expectRemaining(s, "owner", "dependent", "shared", "cycle-a", "cycle-b")
+
+		// Without cascading deletes, dependents are only collected by GarbageCollect
+		if err := s.Delete(key("owner")); err != nil {
+			t.Fatal(err)
+		}
+		expectRemaining(s, "dependent", "shared", "cycle-a", "cycle-b")
+		if _, err := s.GarbageCollect(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		expectRemaining(s, "cycle-a", "cycle-b")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		s = newStorage(false)
+		create(s)
+		if _, err := s.GarbageCollect(ctx); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	})
+
+	t.Run("cascading delete", func(t *testing.T) {
+		s := newStorage(true)
+		create(s)
+
+		if err := s.Delete(key("owner")); err != nil {
+			t.Fatal(err)
+		}
+		expectRemaining(s, "orphan", "chained", "cycle-a", "cycle-b")
+
+		// Deleting an object in a cycle deletes the whole cycle, without looping
+		if err := s.Delete(key("cycle-a")); err != nil {
+			t.Fatal(err)
+		}
+		expectRemaining(s, "orphan", "chained")
+	})
+}
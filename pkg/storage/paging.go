@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+
+	"github.com/weaveworks/libgitops/pkg/filter"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+)
+
+// ListPage is the same as List, but also returns a continue token if filter.WithLimit was given
+// and more objects match. The next page is listed by passing the token using filter.WithContinue.
+// The objects are sorted by their namespaces and names, which makes the tokens stable across calls. The
+// returned continue token is empty for the last page.
+func (s *GenericStorage) ListPage(kind KindKey, opts ...filter.ListOption) (_ []runtime.Object, _ string, err error) {
+	s, span := s.startSpan("List", kindAttributes(kind)...)
+	defer func() { endSpan(span, err) }()
+
+	// First, complete the options struct
+	o, err := filter.MakeListOptions(opts...)
+	if err != nil {
+		return nil, "", err
+	}
+
+	keys, err := s.raw.List(kind)
+	if err != nil {
+		return nil, "", err
+	}
+	// The RawStorage may not sort the keys, but the continue tokens depend on their order
+	SortKeys(keys)
+
+	// Skip all keys up to and including the last one of the previous page
+	if len(o.Continue) != 0 {
+		last, err := decodeContinue(o.Continue)
+		if err != nil {
+			return nil, "", err
+		}
+
+		keys = keys[sort.Search(len(keys), func(i int) bool {
+			return identifierLess(last, keys[i].GetIdentifier())
+		}):]
+	}
+
+	// Without a limit, the filters are applied to the whole list at once
+	if o.Limit == 0 {
+		var objs []runtime.Object
+		for _, key := range keys {
+			obj, err := s.getListed(key, o.MetadataOnly)
+			if err != nil {
+				return nil, "", err
+			}
+			if obj != nil {
+				objs = append(objs, obj)
+			}
+		}
+
+		objs, err = applyFilters(o.Filters, objs...)
+		return objs, "", err
+	}
+
+	var result []runtime.Object
+	for i, key := range keys {
+		obj, err := s.getListed(key, o.MetadataOnly)
+		if err != nil {
+			return nil, "", err
+		} else if obj == nil {
+			continue
+		}
+
+		objs, err := applyFilters(o.Filters, obj)
+		if err != nil {
+			return nil, "", err
+		}
+		result = append(result, objs...)
+
+		if len(result) >= o.Limit {
+			if i < len(keys)-1 {
+				return result, encodeContinue(key.GetIdentifier()), nil
+			}
+			break
+		}
+	}
+
+	return result, "", nil
+}
+
+// getListed returns the object for the given listed key, or nil if its file doesn't exist.
+// If metadataOnly is set, only the metadata of the object is decoded, see filter.WithMetadataOnly.
+func (s *GenericStorage) getListed(key ObjectKey, metadataOnly bool) (runtime.Object, error) {
+	// Allow metadata.json to not exist, although the directory does exist
+	if !s.raw.Exists(key) {
+		return nil, nil
+	}
+
+	// Don't use Get, which would start a span for every listed object
+	var obj runtime.Object
+	var err error
+	if metadataOnly {
+		obj, err = s.GetMeta(key)
+	} else {
+		obj, err = s.get(key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.transform(obj)
+}
+
+// encodeContinue returns the continue token for a page ending with the given identifier
+func encodeContinue(lastIdentifier string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(lastIdentifier))
+}
+
+// decodeContinue returns the last identifier of the page the given continue token was returned for
+func decodeContinue(token string) (string, error) {
+	last, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(last) == 0 {
+		return "", fmt.Errorf("%w: %q", ErrInvalidContinue, token)
+	}
+
+	return string(last), nil
+}
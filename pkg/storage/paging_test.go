@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/weaveworks/libgitops/pkg/filter"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	kruntime "k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestListPage(t *testing.T) {
+	s, _, _ := newTestStorage(t)
+
+	const count = 10
+	for i := 0; i < count; i++ {
+		car := &v1alpha1.Car{}
+		car.SetNamespace("default")
+		car.SetName(fmt.Sprintf("car-%d", i))
+		if err := s.Create(car); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	kind := NewKindKey(carGVK)
+	seen := make(map[string]bool)
+	var names []string
+	token := ""
+	for pages := 1; ; pages++ {
+		objs, next, err := s.ListPage(kind, filter.WithLimit(3), filter.WithContinue(token))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(objs) > 3 {
+			t.Fatalf("expected at most 3 objects per page, got %d", len(objs))
+		}
+
+		for _, obj := range objs {
+			if seen[obj.GetName()] {
+				t.Errorf("%s was listed twice", obj.GetName())
+			}
+			seen[obj.GetName()] = true
+			names = append(names, obj.GetName())
+		}
+
+		if len(next) == 0 {
+			if pages != 4 {
+				t.Errorf("expected 4 pages, got %d", pages)
+			}
+			break
+		}
+		token = next
+	}
+
+	if len(seen) != count {
+		t.Errorf("expected all %d objects to be listed, got %v", count, names)
+	}
+	for i := 1; i < len(names); i++ {
+		if names[i-1] >= names[i] {
+			t.Errorf("expected the objects to be sorted, got %v", names)
+		}
+	}
+
+	// The filters are applied before the limit
+	objs, next, err := s.ListPage(kind, filter.WithLimit(1), filter.NameFilter{Name: "car-5"})
+	if err != nil || len(objs) != 1 || objs[0].GetName() != "car-5" {
+		t.Errorf("expected car-5, got %v (%v)", objs, err)
+	}
+	if _, _, err := s.ListPage(kind, filter.WithContinue(next), filter.NameFilter{Name: "car-5"}); err != nil {
+		t.Error(err)
+	}
+
+	if _, _, err := s.ListPage(kind, filter.WithContinue("!")); !errors.Is(err, ErrInvalidContinue) {
+		t.Errorf("expected ErrInvalidContinue, got %v", err)
+	}
+}
+
+// newListStorage returns a GenericStorage containing count Cars with the "index" label
+func newListStorage(tb testing.TB, count int) Storage {
+	s, _, _ := newTestStorage(tb)
+
+	for i := 0; i < count; i++ {
+		car := &v1alpha1.Car{Spec: v1alpha1.CarSpec{Brand: "Volvo", Engine: "V8", YearModel: "2020"}}
+		car.SetNamespace("default")
+		car.SetName(fmt.Sprintf("car-%d", i))
+		car.SetLabels(map[string]string{"index": fmt.Sprint(i)})
+		if err := s.Create(car); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return s
+}
+
+func TestListMetadataOnly(t *testing.T) {
+	s := newListStorage(t, 3)
+
+	objs, err := s.List(NewKindKey(carGVK), filter.WithMetadataOnly(), filter.NameFilter{Name: "car-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objs) != 1 {
+		t.Fatalf("expected car-1 to be listed, got %v", objs)
+	}
+
+	// Only the metadata is decoded
+	obj, ok := objs[0].(runtime.PartialObject)
+	if !ok {
+		t.Fatalf("expected a PartialObject, got %T", objs[0])
+	}
+	if obj.GetLabels()["index"] != "1" {
+		t.Errorf("expected the labels to be decoded, got %v", obj.GetLabels())
+	}
+	if gvk := obj.GetObjectKind().GroupVersionKind(); gvk != carGVK {
+		t.Errorf("expected the GroupVersionKind %s, got %s", carGVK, gvk)
+	}
+	u, err := kruntime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := u["spec"]; ok {
+		t.Errorf("expected the spec to be absent, got %v", u)
+	}
+}
+
+// BenchmarkListMetadataOnly compares listing the metadata of objects with listing the full objects
+func BenchmarkListMetadataOnly(b *testing.B) {
+	s := newListStorage(b, 100)
+	kind := NewKindKey(carGVK)
+
+	for _, metadataOnly := range []bool{false, true} {
+		var opts []filter.ListOption
+		if metadataOnly {
+			opts = append(opts, filter.WithMetadataOnly())
+		}
+		b.Run(fmt.Sprintf("metadataOnly=%t", metadataOnly), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := s.List(kind, opts...); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/weaveworks/libgitops/pkg/filter"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	kruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+var (
+	// ErrInvalidProjection is returned when a projection is requested using a malformed JSONPath template
+	ErrInvalidProjection = errors.New("invalid projection")
+	// ErrFieldNotFound is returned by GetProjection if the object doesn't have the projected field
+	ErrFieldNotFound = errors.New("field not found")
+)
+
+// Projection holds the values of the fields of an object selected by a JSONPath template
+type Projection struct {
+	// Key is the key of the projected object
+	Key ObjectKey
+	// Values holds the values matched by the template, in their unstructured form (e.g. string,
+	// int64, map[string]interface{}). A scalar field gives one value, while e.g. "{.items[*]}" gives
+	// one value per list item.
+	Values []interface{}
+}
+
+// Projector is implemented by Storages which can return only some fields of their objects
+type Projector interface {
+	// GetProjection returns the fields of the object with the given key selected by the JSONPath
+	// template, e.g. "{.spec.brand}". If the object doesn't have the field, an error wrapping
+	// ErrFieldNotFound is returned. Malformed templates return an error wrapping ErrInvalidProjection.
+	GetProjection(key ObjectKey, template string) (*Projection, error)
+	// ListProjections is the same as GetProjection, but for all objects returned by List with the
+	// given options. Objects which don't have the field have no Values, instead of failing the list.
+	ListProjections(kind KindKey, template string, opts ...filter.ListOption) ([]Projection, error)
+}
+
+var _ Projector = &GenericStorage{}
+
+// GetProjection returns the fields of the object with the given key selected by the JSONPath template
+func (s *GenericStorage) GetProjection(key ObjectKey, template string) (*Projection, error) {
+	path, err := parseProjection(template)
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err := s.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := project(path, obj)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", key, err)
+	}
+
+	return &Projection{Key: key, Values: values}, nil
+}
+
+// ListProjections returns the fields selected by the JSONPath template for all listed objects
+func (s *GenericStorage) ListProjections(kind KindKey, template string, opts ...filter.ListOption) ([]Projection, error) {
+	path, err := parseProjection(template)
+	if err != nil {
+		return nil, err
+	}
+
+	objs, err := s.List(kind, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	projections := make([]Projection, 0, len(objs))
+	for _, obj := range objs {
+		key, err := s.ObjectKeyFor(obj)
+		if err != nil {
+			return nil, err
+		}
+
+		values, err := project(path, obj)
+		if err != nil && !errors.Is(err, ErrFieldNotFound) {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+
+		projections = append(projections, Projection{Key: key, Values: values})
+	}
+
+	return projections, nil
+}
+
+// parseProjection parses the given JSONPath template
+func parseProjection(template string) (*jsonpath.JSONPath, error) {
+	path := jsonpath.New("projection")
+	if err := path.Parse(template); err != nil {
+		return nil, fmt.Errorf("%w %q: %v", ErrInvalidProjection, template, err)
+	}
+
+	return path, nil
+}
+
+// project returns the values of the fields of obj selected by path
+func project(path *jsonpath.JSONPath, obj runtime.Object) ([]interface{}, error) {
+	u, err := kruntime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	// The template has been parsed already, so the remaining errors are about missing fields
+	results, err := path.FindResults(u)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFieldNotFound, err)
+	}
+
+	var values []interface{}
+	for _, result := range results {
+		for _, value := range result {
+			values = append(values, value.Interface())
+		}
+	}
+
+	return values, nil
+}
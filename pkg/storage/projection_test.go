@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/weaveworks/libgitops/pkg/filter"
+)
+
+func TestProjections(t *testing.T) {
+	generic, _, _ := newTestStorage(t)
+	s := generic.(*GenericStorage)
+
+	for name, brand := range map[string]string{"foo": "Volvo", "bar": "Tesla"} {
+		car := &v1alpha1.Car{Spec: v1alpha1.CarSpec{Brand: brand}}
+		car.SetName(name)
+		car.SetNamespace("default")
+		car.SetLabels(map[string]string{"brand": brand})
+		if err := s.Create(car); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("scalar", func(t *testing.T) {
+		p, err := s.GetProjection(carKey, "{.spec.brand}")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := []interface{}{"Volvo"}; !reflect.DeepEqual(p.Values, expected) || p.Key != carKey {
+			t.Errorf("expected %v for %s, got %v for %s", expected, carKey, p.Values, p.Key)
+		}
+
+		if _, err := s.GetProjection(carKey, "{.spec.missing}"); !errors.Is(err, ErrFieldNotFound) {
+			t.Errorf("expected ErrFieldNotFound, got %v", err)
+		}
+		if _, err := s.GetProjection(carKey, "{.spec.brand"); !errors.Is(err, ErrInvalidProjection) {
+			t.Errorf("expected ErrInvalidProjection, got %v", err)
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		projections, err := s.ListProjections(NewKindKey(carGVK), "{.metadata.name}{.spec.brand}")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := [][]interface{}{{"bar", "Tesla"}, {"foo", "Volvo"}}
+		var values [][]interface{}
+		for _, p := range projections {
+			values = append(values, p.Values)
+		}
+		if !reflect.DeepEqual(values, expected) {
+			t.Errorf("expected %v, got %v", expected, values)
+		}
+
+		// Objects without the field have no values, and the list options apply
+		projections, err = s.ListProjections(NewKindKey(carGVK), "{.metadata.annotations.missing}", filter.NameFilter{Name: "foo"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(projections) != 1 || projections[0].Key != carKey || len(projections[0].Values) != 0 {
+			t.Errorf("expected foo without values, got %v", projections)
+		}
+
+		// Maps are returned in their unstructured form
+		projections, err = s.ListProjections(NewKindKey(carGVK), "{.metadata.labels}", filter.NameFilter{Name: "foo"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := []interface{}{map[string]interface{}{"brand": "Volvo"}}; !reflect.DeepEqual(projections[0].Values, expected) {
+			t.Errorf("expected %v, got %v", expected, projections[0].Values)
+		}
+	})
+}
@@ -2,16 +2,15 @@ package storage
 
 import (
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
-	"strconv"
 	"strings"
 
+	"github.com/go-logr/logr"
 	"github.com/weaveworks/libgitops/pkg/runtime"
 	"github.com/weaveworks/libgitops/pkg/serializer"
-	"github.com/weaveworks/libgitops/pkg/util"
+	"github.com/weaveworks/libgitops/pkg/util/filesystem"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
@@ -30,7 +29,9 @@ type RawStorage interface {
 	// Delete deletes the resource indicated by key.
 	// If the resource does not exist, it returns ErrNotFound.
 	Delete(key ObjectKey) error
-	// List returns all matching object keys based on the given KindKey.
+	// List returns all matching object keys based on the given KindKey. The keys are
+	// sorted by namespace and name (see SortKeys), unless the implementation opts out
+	// (e.g. using RawStorageOptions.UnsortedList).
 	List(key KindKey) ([]ObjectKey, error)
 	// Checksum returns a string checksum for the resource indicated by key.
 	// If the resource does not exist, it returns ErrNotFound.
@@ -45,17 +46,90 @@ type RawStorage interface {
 	GetKey(path string) (ObjectKey, error)
 }
 
+// RawStorageOptions specifies options for the GenericRawStorage and GenericMappedRawStorage
+type RawStorageOptions struct {
+	// Filesystem specifies where the files are stored. (Default: the local disk)
+	Filesystem filesystem.Filesystem
+	// Checksummer computes the checksums returned by Checksum. (Default: filesystem.ModTimeChecksummer)
+	Checksummer filesystem.Checksummer
+	// FileLayout decides where the GenericMappedRawStorage creates the files of new objects.
+	// If nil, only objects with known files can be written. The GenericRawStorage uses its
+	// own layout, and ignores this option. (Default: nil)
+	FileLayout FileLayout
+	// UnsortedList skips sorting the keys returned by List, which is faster for storages with
+	// many objects, but makes the order of the keys non-deterministic. (Default: false)
+	UnsortedList bool
+	// MaxFileSize specifies the maximum size of the files read by Read, in bytes. Reading a larger
+	// file fails with filesystem.ErrFileTooLarge, see filesystem.ReadFileLimited. This protects
+	// against running out of memory because of oversized files. (Default: 0, which uses
+	// filesystem.DefaultMaxFileSize, while a negative value disables the limit)
+	MaxFileSize int64
+	// Logger receives the logs of the GenericMappedRawStorage, with the objectID and path of the
+	// mappings as key/value pairs. The GenericRawStorage doesn't log. (Default: nil, which logs
+	// to logs.Logger, see logs.NewLogger)
+	Logger logr.Logger
+}
+
+// DefaultRawStorageOptions returns the default options for the raw storages
+func DefaultRawStorageOptions() RawStorageOptions {
+	return RawStorageOptions{
+		Filesystem:  filesystem.NewOSFilesystem(),
+		Checksummer: filesystem.ModTimeChecksummer,
+	}
+}
+
 func NewGenericRawStorage(dir string, gv schema.GroupVersion, ct serializer.ContentType) RawStorage {
+	return NewGenericRawStorageWithOptions(dir, gv, ct, DefaultRawStorageOptions())
+}
+
+// NewGenericRawStorageWithFilesystem is the same as NewGenericRawStorage, but stores
+// the files in the given Filesystem instead of on the local disk.
+func NewGenericRawStorageWithFilesystem(dir string, gv schema.GroupVersion, ct serializer.ContentType, fs filesystem.Filesystem) RawStorage {
+	opts := DefaultRawStorageOptions()
+	opts.Filesystem = fs
+	return NewGenericRawStorageWithOptions(dir, gv, ct, opts)
+}
+
+// NewGenericRawStorageWithOptions is the same as NewGenericRawStorage, but allows
+// customizing the behavior of the GenericRawStorage using the given RawStorageOptions.
+// The nil fields of opts are set to their defaults.
+func NewGenericRawStorageWithOptions(dir string, gv schema.GroupVersion, ct serializer.ContentType, opts RawStorageOptions) RawStorage {
 	ext := extForContentType(ct)
 	if ext == "" {
 		panic("Invalid content type")
 	}
+	opts = opts.withDefaults()
 	return &GenericRawStorage{
-		dir: dir,
-		gv:  gv,
-		ct:  ct,
-		ext: ext,
+		dir:         dir,
+		gv:          gv,
+		ct:          ct,
+		ext:         ext,
+		fs:          opts.Filesystem,
+		checksummer: opts.Checksummer,
+		unsorted:    opts.UnsortedList,
+		maxFileSize: maxFileSize(opts.MaxFileSize),
+	}
+}
+
+// withDefaults returns a copy of the options, with the nil Filesystem and Checksummer
+// set to the ones of DefaultRawStorageOptions
+func (o RawStorageOptions) withDefaults() RawStorageOptions {
+	if o.Filesystem == nil {
+		o.Filesystem = filesystem.NewOSFilesystem()
+	}
+	if o.Checksummer == nil {
+		o.Checksummer = filesystem.ModTimeChecksummer
+	}
+	return o
+}
+
+// maxFileSize returns the maximum file size to pass to filesystem.ReadFileLimited for the given
+// RawStorageOptions.MaxFileSize
+func maxFileSize(size int64) int64 {
+	if size == 0 {
+		return filesystem.DefaultMaxFileSize
 	}
+	return size
 }
 
 // GenericRawStorage is a rawstorage which stores objects as JSON files on disk,
@@ -63,10 +137,14 @@ func NewGenericRawStorage(dir string, gv schema.GroupVersion, ct serializer.Cont
 // The GenericRawStorage only supports one GroupVersion at a time, and will error if given
 // any other resources
 type GenericRawStorage struct {
-	dir string
-	gv  schema.GroupVersion
-	ct  serializer.ContentType
-	ext string
+	dir         string
+	gv          schema.GroupVersion
+	ct          serializer.ContentType
+	ext         string
+	fs          filesystem.Filesystem
+	checksummer filesystem.Checksummer
+	unsorted    bool
+	maxFileSize int64
 }
 
 func (r *GenericRawStorage) keyPath(key ObjectKey) string {
@@ -85,9 +163,18 @@ func (r *GenericRawStorage) validateGroupVersion(kind KindKey) error {
 	return fmt.Errorf("GroupVersion %s/%s not supported by this GenericRawStorage", kind.GetGroup(), kind.GetVersion())
 }
 
-func (r *GenericRawStorage) Read(key ObjectKey) ([]byte, error) {
-	// Validate GroupVersion first
+// validateKey validates the GroupVersion of key, and that its names are safe to use in a path
+func (r *GenericRawStorage) validateKey(key ObjectKey) error {
 	if err := r.validateGroupVersion(key); err != nil {
+		return err
+	}
+
+	return ValidateKeyPath(key)
+}
+
+func (r *GenericRawStorage) Read(key ObjectKey) ([]byte, error) {
+	// Validate GroupVersion and the names used in the path first
+	if err := r.validateKey(key); err != nil {
 		return nil, err
 	}
 
@@ -96,21 +183,21 @@ func (r *GenericRawStorage) Read(key ObjectKey) ([]byte, error) {
 		return nil, ErrNotFound
 	}
 
-	return ioutil.ReadFile(r.keyPath(key))
+	return filesystem.ReadFileLimited(r.fs, r.keyPath(key), r.maxFileSize)
 }
 
 func (r *GenericRawStorage) Exists(key ObjectKey) bool {
-	// Validate GroupVersion first
-	if err := r.validateGroupVersion(key); err != nil {
+	// Validate GroupVersion and the names used in the path first
+	if err := r.validateKey(key); err != nil {
 		return false
 	}
 
-	return util.FileExists(r.keyPath(key))
+	return filesystem.FileExists(r.fs, r.keyPath(key))
 }
 
 func (r *GenericRawStorage) Write(key ObjectKey, content []byte) error {
-	// Validate GroupVersion first
-	if err := r.validateGroupVersion(key); err != nil {
+	// Validate GroupVersion and the names used in the path first
+	if err := r.validateKey(key); err != nil {
 		return err
 	}
 
@@ -118,17 +205,17 @@ func (r *GenericRawStorage) Write(key ObjectKey, content []byte) error {
 
 	// Create the underlying directories if they do not exist already
 	if !r.Exists(key) {
-		if err := os.MkdirAll(path.Dir(file), 0755); err != nil {
+		if err := r.fs.MkdirAll(path.Dir(file), 0755); err != nil {
 			return err
 		}
 	}
 
-	return ioutil.WriteFile(file, content, 0644)
+	return r.fs.WriteFile(file, content, 0644)
 }
 
 func (r *GenericRawStorage) Delete(key ObjectKey) error {
-	// Validate GroupVersion first
-	if err := r.validateGroupVersion(key); err != nil {
+	// Validate GroupVersion and the names used in the path first
+	if err := r.validateKey(key); err != nil {
 		return err
 	}
 
@@ -137,7 +224,7 @@ func (r *GenericRawStorage) Delete(key ObjectKey) error {
 		return ErrNotFound
 	}
 
-	return os.RemoveAll(path.Dir(r.keyPath(key)))
+	return r.fs.RemoveAll(path.Dir(r.keyPath(key)))
 }
 
 func (r *GenericRawStorage) List(kind KindKey) ([]ObjectKey, error) {
@@ -146,7 +233,7 @@ func (r *GenericRawStorage) List(kind KindKey) ([]ObjectKey, error) {
 		return nil, err
 	}
 
-	entries, err := ioutil.ReadDir(r.kindKeyPath(kind))
+	entries, err := r.fs.ReadDir(r.kindKeyPath(kind))
 	if err != nil {
 		return nil, err
 	}
@@ -156,14 +243,18 @@ func (r *GenericRawStorage) List(kind KindKey) ([]ObjectKey, error) {
 		result = append(result, NewObjectKey(kind, runtime.NewIdentifier(entry.Name())))
 	}
 
+	if !r.unsorted {
+		SortKeys(result)
+	}
 	return result, nil
 }
 
-// This returns the modification time as a UnixNano string
+// This returns the checksum computed by the configured Checksummer, by default
+// the modification time as a UnixNano string, prefixed with the algorithm name.
 // If the file doesn't exist, return ErrNotFound
 func (r *GenericRawStorage) Checksum(key ObjectKey) (string, error) {
-	// Validate GroupVersion first
-	if err := r.validateGroupVersion(key); err != nil {
+	// Validate GroupVersion and the names used in the path first
+	if err := r.validateKey(key); err != nil {
 		return "", err
 	}
 
@@ -172,7 +263,7 @@ func (r *GenericRawStorage) Checksum(key ObjectKey) (string, error) {
 		return "", ErrNotFound
 	}
 
-	return checksumFromModTime(r.keyPath(key))
+	return r.checksummer.Checksum(r.fs, r.keyPath(key))
 }
 
 func (r *GenericRawStorage) ContentType(_ ObjectKey) serializer.ContentType {
@@ -206,12 +297,3 @@ func (r *GenericRawStorage) GetKey(p string) (ObjectKey, error) {
 
 	return NewObjectKey(NewKindKey(gvk), runtime.NewIdentifier(uid)), nil
 }
-
-func checksumFromModTime(path string) (string, error) {
-	fi, err := os.Stat(path)
-	if err != nil {
-		return "", err
-	}
-
-	return strconv.FormatInt(fi.ModTime().UnixNano(), 10), nil
-}
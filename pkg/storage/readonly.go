@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/serializer"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// ErrReadOnly is returned when a mutating method is called on a read-only Storage or RawStorage
+var ErrReadOnly = errors.New("storage is read-only")
+
+// NewReadOnlyStorage wraps the given Storage, so that Create, Update, Patch, PatchWithType
+// and Delete return ErrReadOnly without touching the backend. All read operations are
+// passed through. The RawStorage of the returned Storage is read-only as well.
+func NewReadOnlyStorage(s Storage) Storage {
+	return &readOnlyStorage{s}
+}
+
+// IsReadOnly returns true if the given Storage was created by NewReadOnlyStorage,
+// in which case all mutating methods are guaranteed to return ErrReadOnly
+func IsReadOnly(s Storage) bool {
+	_, ok := s.(*readOnlyStorage)
+	return ok
+}
+
+// readOnlyStorage implements the WriteStorage methods of a Storage by returning ErrReadOnly
+type readOnlyStorage struct {
+	Storage
+}
+
+var _ Storage = &readOnlyStorage{}
+
+func (s *readOnlyStorage) Create(obj runtime.Object) error {
+	return fmt.Errorf("cannot create %s: %w", obj.GetName(), ErrReadOnly)
+}
+
+func (s *readOnlyStorage) Update(obj runtime.Object) error {
+	return fmt.Errorf("cannot update %s: %w", obj.GetName(), ErrReadOnly)
+}
+
+func (s *readOnlyStorage) Patch(key ObjectKey, patch []byte) error {
+	return fmt.Errorf("cannot patch %s: %w", key, ErrReadOnly)
+}
+
+func (s *readOnlyStorage) PatchWithType(key ObjectKey, patchType types.PatchType, patch []byte) error {
+	return fmt.Errorf("cannot patch %s: %w", key, ErrReadOnly)
+}
+
+func (s *readOnlyStorage) Delete(key ObjectKey) error {
+	return fmt.Errorf("cannot delete %s: %w", key, ErrReadOnly)
+}
+
+// RawStorage returns a read-only view of the underlying RawStorage, so that
+// the files can't be modified by bypassing the Storage either
+func (s *readOnlyStorage) RawStorage() RawStorage {
+	return NewReadOnlyRawStorage(s.Storage.RawStorage())
+}
+
+// NewReadOnlyRawStorage wraps the given RawStorage, so that Write and Delete return
+// ErrReadOnly. If raw is a MappedRawStorage, so is the returned RawStorage, and
+// the in-memory mappings can still be modified, as they don't touch the files.
+func NewReadOnlyRawStorage(raw RawStorage) RawStorage {
+	if mapped, ok := raw.(MappedRawStorage); ok {
+		return &readOnlyMappedRawStorage{mapped}
+	}
+
+	return &readOnlyRawStorage{raw}
+}
+
+// readOnlyRawStorage implements the mutating methods of a RawStorage by returning ErrReadOnly
+type readOnlyRawStorage struct {
+	RawStorage
+}
+
+var _ RawStorage = &readOnlyRawStorage{}
+
+func (r *readOnlyRawStorage) Write(key ObjectKey, _ []byte) error {
+	return fmt.Errorf("cannot write %s: %w", key, ErrReadOnly)
+}
+
+func (r *readOnlyRawStorage) Delete(key ObjectKey) error {
+	return fmt.Errorf("cannot delete %s: %w", key, ErrReadOnly)
+}
+
+// readOnlyMappedRawStorage implements the mutating methods of a MappedRawStorage by returning ErrReadOnly
+type readOnlyMappedRawStorage struct {
+	MappedRawStorage
+}
+
+var _ MappedRawStorage = &readOnlyMappedRawStorage{}
+
+func (r *readOnlyMappedRawStorage) Write(key ObjectKey, _ []byte) error {
+	return fmt.Errorf("cannot write %s: %w", key, ErrReadOnly)
+}
+
+func (r *readOnlyMappedRawStorage) Delete(key ObjectKey) error {
+	return fmt.Errorf("cannot delete %s: %w", key, ErrReadOnly)
+}
+
+// ContentTypeCandidates forwards the candidate content types of the wrapped RawStorage, if any
+func (r *readOnlyRawStorage) ContentTypeCandidates(key ObjectKey) []serializer.ContentType {
+	return ContentTypeCandidates(r.RawStorage, key)
+}
+
+// ContentTypeCandidates forwards the candidate content types of the wrapped MappedRawStorage, if any
+func (r *readOnlyMappedRawStorage) ContentTypeCandidates(key ObjectKey) []serializer.ContentType {
+	return ContentTypeCandidates(r.MappedRawStorage, key)
+}
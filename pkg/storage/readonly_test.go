@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/serializer"
+	"github.com/weaveworks/libgitops/pkg/util/filesystem"
+	kruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestReadOnlyStorage(t *testing.T) {
+	fs := filesystem.NewInMemory()
+	dir, err := filepath.Abs("manifests")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "car.yaml")
+	if err := fs.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.WriteFile(path, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	raw := NewGenericMappedRawStorageWithFilesystem(dir, fs)
+	raw.AddMapping(carKey, path)
+	s := NewReadOnlyStorage(NewGenericStorage(raw, serializer.NewSerializer(kruntime.NewScheme(), nil), []runtime.IdentifierFactory{runtime.Metav1NameIdentifier}))
+	if !IsReadOnly(s) {
+		t.Error("expected the storage to be read-only")
+	}
+
+	obj := &runtime.PartialObjectImpl{}
+	obj.SetName("foo")
+	writes := map[string]error{
+		"create":          s.Create(obj),
+		"update":          s.Update(obj),
+		"patch":           s.Patch(carKey, []byte("{}")),
+		"patch with type": s.PatchWithType(carKey, types.MergePatchType, []byte("{}")),
+		"delete":          s.Delete(carKey),
+		"raw write":       s.RawStorage().Write(carKey, []byte("changed")),
+		"raw delete":      s.RawStorage().Delete(carKey),
+	}
+	for name, err := range writes {
+		if !errors.Is(err, ErrReadOnly) {
+			t.Errorf("%s: expected ErrReadOnly, got %v", name, err)
+		}
+	}
+
+	// Reads pass through, and the mappings are still accessible
+	if _, ok := s.RawStorage().(MappedRawStorage); !ok {
+		t.Error("expected the raw storage to stay a MappedRawStorage")
+	}
+	if content, err := s.RawStorage().Read(carKey); err != nil || string(content) != "{}" {
+		t.Errorf("expected the file to be unchanged, got %q (%v)", content, err)
+	}
+	if count, err := s.Count(carKey); err != nil || count != 1 {
+		t.Errorf("expected 1 object, got %d (%v)", count, err)
+	}
+}
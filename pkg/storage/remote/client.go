@@ -0,0 +1,414 @@
+package remote
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/weaveworks/libgitops/pkg/filter"
+	"github.com/weaveworks/libgitops/pkg/rest"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/serializer"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// maxErrorMessage is the maximum amount of bytes of an error response included in errors
+const maxErrorMessage = 4096
+
+// Option configures a Client
+type Option func(*options)
+
+// options holds the configuration of a Client
+type options struct {
+	httpClient *http.Client
+	ct         serializer.ContentType
+}
+
+func defaultOptions() options {
+	return options{
+		httpClient: http.DefaultClient,
+		ct:         serializer.ContentTypeJSON,
+	}
+}
+
+// WithHTTPClient sets the HTTP client used for the requests. (Default: http.DefaultClient)
+func WithHTTPClient(c *http.Client) Option {
+	return func(o *options) {
+		o.httpClient = c
+	}
+}
+
+// WithContentType sets the content type the objects are sent and requested in, see
+// serializer.NewFrameReader for the supported content types. (Default: serializer.ContentTypeJSON)
+func WithContentType(ct serializer.ContentType) Option {
+	return func(o *options) {
+		o.ct = ct
+	}
+}
+
+// New returns a Client performing all operations of the Storage against the HTTP API at the
+// given base URL, as served by rest.Handler, e.g. for a central storage server used by processes
+// on several machines. The objects are encoded and decoded using the given Serializer, which must
+// know all of their types. The objects are identified by their namespaces and names, which make
+// up their paths, see rest.ObjectPath. Errors of the server are mapped to storage.ErrNotFound
+// (404) and storage.ErrAlreadyExists (409).
+func New(baseURL string, ser serializer.Serializer, opts ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return &Client{baseURL: u, ser: ser, opts: o}, nil
+}
+
+// Client is a Storage backed by a remote HTTP API
+type Client struct {
+	baseURL *url.URL
+	ser     serializer.Serializer
+	opts    options
+}
+
+var _ storage.Storage = &Client{}
+
+// Get requests the object with the given key
+func (c *Client) Get(key storage.ObjectKey) (runtime.Object, error) {
+	content, _, err := c.do(http.MethodGet, rest.ObjectPath(key), nil, "", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.decode(c.opts.ct, content)
+}
+
+// GetMeta requests the object with the given key, but only decodes its metadata
+func (c *Client) GetMeta(key storage.ObjectKey) (runtime.PartialObject, error) {
+	content, _, err := c.do(http.MethodGet, rest.ObjectPath(key), nil, "", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return runtime.NewPartialObject(content)
+}
+
+// List requests all objects of the given kind, and applies the filters of opts to them
+func (c *Client) List(kind storage.KindKey, opts ...filter.ListOption) ([]runtime.Object, error) {
+	objs, _, err := c.ListPage(kind, opts...)
+	return objs, err
+}
+
+// ListPage requests the page of the objects of the given kind selected by filter.WithLimit and
+// filter.WithContinue, and applies the filters of opts to it. The filters can't be sent to the
+// server, hence pages may contain less objects than the limit, when combined with filters.
+func (c *Client) ListPage(kind storage.KindKey, opts ...filter.ListOption) ([]runtime.Object, string, error) {
+	o, err := filter.MakeListOptions(opts...)
+	if err != nil {
+		return nil, "", err
+	}
+
+	query := url.Values{}
+	if o.Limit > 0 {
+		query.Set("limit", strconv.Itoa(o.Limit))
+	}
+	if len(o.Continue) != 0 {
+		query.Set("continue", o.Continue)
+	}
+
+	content, header, err := c.do(http.MethodGet, rest.KindPath(kind, ""), query, "", nil)
+	if err != nil {
+		return nil, "", err
+	}
+	objs, err := c.ser.Decoder().DecodeAll(serializer.NewFrameReader(c.opts.ct, serializer.FromBytes(content)))
+	if err != nil {
+		return nil, "", err
+	}
+
+	result := make([]runtime.Object, 0, len(objs))
+	for _, obj := range objs {
+		metaObj, ok := obj.(runtime.Object)
+		if !ok {
+			return nil, "", fmt.Errorf("can't convert to libgitops.runtime.Object")
+		}
+		result = append(result, metaObj)
+	}
+	for _, f := range o.Filters {
+		if result, err = f.Filter(result...); err != nil {
+			return nil, "", err
+		}
+	}
+	return result, header.Get(rest.ContinueHeader), nil
+}
+
+// Find does a List underneath, also using filters, but always returns one object. If the List
+// underneath returned two or more results, ErrAmbiguousFind is returned. If no match was found,
+// ErrNotFound is returned.
+func (c *Client) Find(kind storage.KindKey, opts ...filter.ListOption) (runtime.Object, error) {
+	objs, err := c.List(kind, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	switch l := len(objs); l {
+	case 0:
+		return nil, fmt.Errorf("no Find match found: %w", storage.ErrNotFound)
+	case 1:
+		return objs[0], nil
+	default:
+		return nil, fmt.Errorf("too many (%d) matches: %v: %w", l, objs, storage.ErrAmbiguousFind)
+	}
+}
+
+// ListMeta requests all objects of the given kind, but only decodes their metadata
+func (c *Client) ListMeta(kind storage.KindKey) ([]runtime.PartialObject, error) {
+	return c.listMeta(kind, "")
+}
+
+// listMeta requests the objects of the given kind in the given namespace, or in all namespaces
+// if it is empty, and decodes their metadata
+func (c *Client) listMeta(kind storage.KindKey, namespace string) ([]runtime.PartialObject, error) {
+	content, _, err := c.do(http.MethodGet, rest.KindPath(kind, namespace), nil, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	frames, err := serializer.ReadFrameList(serializer.NewFrameReader(c.opts.ct, serializer.FromBytes(content)))
+	if err != nil {
+		return nil, err
+	}
+
+	objs := make([]runtime.PartialObject, 0, len(frames))
+	for _, frame := range frames {
+		obj, err := runtime.NewPartialObject(frame)
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, obj)
+	}
+	return objs, nil
+}
+
+// Checksum returns the ETag of the object with the given key, which is its checksum on the server
+func (c *Client) Checksum(key storage.ObjectKey) (string, error) {
+	_, header, err := c.do(http.MethodHead, rest.ObjectPath(key), nil, "", nil)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Trim(header.Get("ETag"), `"`), nil
+}
+
+// Count returns the amount of objects of the given kind
+func (c *Client) Count(kind storage.KindKey) (uint64, error) {
+	objs, err := c.listMeta(kind, "")
+	return uint64(len(objs)), err
+}
+
+// CountNamespace returns the amount of objects of the given kind in the given namespace.
+// An empty namespace counts the objects without a namespace.
+func (c *Client) CountNamespace(kind storage.KindKey, namespace string) (uint64, error) {
+	objs, err := c.listMeta(kind, namespace)
+	if err != nil || len(namespace) != 0 {
+		return uint64(len(objs)), err
+	}
+
+	var count uint64
+	for _, obj := range objs {
+		if len(obj.GetNamespace()) == 0 {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// ListNamespaces returns the sorted namespaces of the objects of the given kind
+func (c *Client) ListNamespaces(kind storage.KindKey) ([]string, error) {
+	objs, err := c.listMeta(kind, "")
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	namespaces := []string{}
+	for _, obj := range objs {
+		if ns := obj.GetNamespace(); len(ns) != 0 && !seen[ns] {
+			seen[ns] = true
+			namespaces = append(namespaces, ns)
+		}
+	}
+	sort.Strings(namespaces)
+	return namespaces, nil
+}
+
+// Create sends the given object to the server, which must not have it stored yet
+func (c *Client) Create(obj runtime.Object) error {
+	key, err := c.ObjectKeyFor(obj)
+	if err != nil {
+		return err
+	}
+	content, err := c.encode(obj)
+	if err != nil {
+		return err
+	}
+
+	namespace, _ := rest.SplitIdentifier(key.GetIdentifier())
+	_, _, err = c.do(http.MethodPost, rest.KindPath(key, namespace), nil, string(c.opts.ct), content)
+	return err
+}
+
+// Update sends the given object to the server, which must have it stored already
+func (c *Client) Update(obj runtime.Object) error {
+	key, err := c.ObjectKeyFor(obj)
+	if err != nil {
+		return err
+	}
+	content, err := c.encode(obj)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = c.do(http.MethodPut, rest.ObjectPath(key), nil, string(c.opts.ct), content)
+	return err
+}
+
+// Patch sends a strategic merge patch for the object with the given key to the server
+func (c *Client) Patch(key storage.ObjectKey, patch []byte) error {
+	return c.PatchWithType(key, types.StrategicMergePatchType, patch)
+}
+
+// PatchWithType sends a patch of the given type for the object with the given key to the
+// server. Supported types are types.StrategicMergePatchType and types.JSONPatchType (RFC 6902).
+func (c *Client) PatchWithType(key storage.ObjectKey, patchType types.PatchType, patch []byte) error {
+	if patchType != types.StrategicMergePatchType && patchType != types.JSONPatchType {
+		return fmt.Errorf("%w: %q", storage.ErrUnsupportedPatchType, patchType)
+	}
+
+	_, _, err := c.do(http.MethodPatch, rest.ObjectPath(key), nil, string(patchType), patch)
+	return err
+}
+
+// Delete deletes the object with the given key on the server
+func (c *Client) Delete(key storage.ObjectKey) error {
+	_, _, err := c.do(http.MethodDelete, rest.ObjectPath(key), nil, "", nil)
+	return err
+}
+
+// ObjectKeyFor returns the ObjectKey for the given object, identified by its namespace and name
+func (c *Client) ObjectKeyFor(obj runtime.Object) (storage.ObjectKey, error) {
+	gvk := obj.GetObjectKind().GroupVersionKind()
+	if _, isPartialObject := obj.(runtime.PartialObject); !isPartialObject {
+		var err error
+		if gvk, err = serializer.GVKForObject(c.ser.Scheme(), obj); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(obj.GetName()) == 0 {
+		return nil, fmt.Errorf("couldn't identify object: its name is empty")
+	}
+	id := rest.JoinIdentifier(obj.GetNamespace(), obj.GetName())
+	return storage.NewObjectKey(storage.NewKindKey(gvk), runtime.NewIdentifier(id)), nil
+}
+
+// RawStorage returns nil, as the files of the objects are only accessible by the server
+func (c *Client) RawStorage() storage.RawStorage {
+	return nil
+}
+
+// Serializer returns the serializer
+func (c *Client) Serializer() serializer.Serializer {
+	return c.ser
+}
+
+// Close closes the idle connections of the HTTP client
+func (c *Client) Close() error {
+	c.opts.httpClient.CloseIdleConnections()
+	return nil
+}
+
+// do sends a request with the given method, path, query and body, and returns the body and
+// header of the response. Error responses are returned as errors, see statusError.
+func (c *Client) do(method, path string, query url.Values, contentType string, body []byte) ([]byte, http.Header, error) {
+	req, err := c.newRequest(method, path, query, body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(contentType) != 0 {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Accept", string(c.opts.ct))
+
+	resp, err := c.opts.httpClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return nil, nil, statusError(req, resp)
+	}
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return content, resp.Header, nil
+}
+
+// newRequest returns a request for the given path relative to the base URL
+func (c *Client) newRequest(method, path string, query url.Values, body []byte) (*http.Request, error) {
+	u := *c.baseURL
+	u.Path += path
+	u.RawQuery = query.Encode()
+
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	return http.NewRequest(method, u.String(), r)
+}
+
+// statusError returns the error for the given error response
+func statusError(req *http.Request, resp *http.Response) error {
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorMessage))
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return fmt.Errorf("%s %s: %w", req.Method, req.URL.Path, storage.ErrNotFound)
+	case http.StatusConflict:
+		return fmt.Errorf("%s %s: %w", req.Method, req.URL.Path, storage.ErrAlreadyExists)
+	default:
+		return fmt.Errorf("%s %s: unexpected status %q: %s", req.Method, req.URL.Path, resp.Status, bytes.TrimSpace(msg))
+	}
+}
+
+// encode encodes the given object in the content type of the Client
+func (c *Client) encode(obj runtime.Object) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := c.ser.Encoder().Encode(serializer.NewFrameWriter(c.opts.ct, &buf), obj); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decode decodes the given content of the given content type into an object
+func (c *Client) decode(ct serializer.ContentType, content []byte) (runtime.Object, error) {
+	obj, err := c.ser.Decoder().Decode(serializer.NewFrameReader(ct, serializer.FromBytes(content)))
+	if err != nil {
+		return nil, err
+	}
+
+	metaObj, ok := obj.(runtime.Object)
+	if !ok {
+		return nil, fmt.Errorf("can't convert to libgitops.runtime.Object")
+	}
+	return metaObj, nil
+}
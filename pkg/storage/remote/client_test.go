@@ -0,0 +1,192 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/scheme"
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/weaveworks/libgitops/pkg/filter"
+	"github.com/weaveworks/libgitops/pkg/rest"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/storage/watch"
+	"github.com/weaveworks/libgitops/pkg/storage/watch/update"
+	"github.com/weaveworks/libgitops/pkg/util/filesystem"
+)
+
+var carKind = storage.NewKindKey(v1alpha1.SchemeGroupVersion.WithKind("Car"))
+
+func newTestServer(t *testing.T) *httptest.Server {
+	dir, err := filepath.Abs("manifests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := storage.DefaultRawStorageOptions()
+	opts.Filesystem = filesystem.NewInMemory()
+	// Patches are applied to JSON
+	opts.FileLayout = storage.FileLayoutFunc(func(key storage.ObjectKey) string {
+		return key.GetIdentifier() + ".json"
+	})
+	s := storage.NewGenericStorage(storage.NewGenericMappedRawStorageWithOptions(dir, opts), scheme.Serializer, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier})
+
+	return httptest.NewServer(rest.Handler(s, scheme.Serializer))
+}
+
+func newCar(namespace, name, brand string) *v1alpha1.Car {
+	car := &v1alpha1.Car{Spec: v1alpha1.CarSpec{Brand: brand}}
+	car.SetNamespace(namespace)
+	car.SetName(name)
+	return car
+}
+
+func TestClient(t *testing.T) {
+	server := newTestServer(t)
+	defer server.Close()
+	c, err := New(server.URL, scheme.Serializer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for _, car := range []*v1alpha1.Car{newCar("default", "foo", "Volvo"), newCar("default", "bar", "Saab"), newCar("other", "baz", "Audi")} {
+		if err := c.Create(car); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Create(newCar("default", "foo", "Volvo")); !errors.Is(err, storage.ErrAlreadyExists) {
+		t.Errorf("expected ErrAlreadyExists, got %v", err)
+	}
+
+	key, err := c.ObjectKeyFor(newCar("default", "foo", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := c.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if brand := obj.(*v1alpha1.Car).Spec.Brand; brand != "Volvo" {
+		t.Errorf("expected brand %q, got %q", "Volvo", brand)
+	}
+	checksum, err := c.Checksum(key)
+	if err != nil || len(checksum) == 0 {
+		t.Errorf("expected a checksum, got %q (%v)", checksum, err)
+	}
+
+	// Update and patch the Car
+	car := obj.(*v1alpha1.Car)
+	car.Spec.Brand = "Tesla"
+	if err := c.Update(car); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Patch(key, []byte(`{"spec":{"engine":"electric"}}`)); err != nil {
+		t.Fatal(err)
+	}
+	obj, err = c.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec := obj.(*v1alpha1.Car).Spec; spec.Brand != "Tesla" || spec.Engine != "electric" {
+		t.Errorf("expected the Car to be updated and patched, got %+v", spec)
+	}
+	if err := c.Update(newCar("default", "missing", "Volvo")); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	// List the Cars, in pages, filtered and by their metadata
+	objs, err := c.List(carKind, filter.NamespaceFilter{Namespace: "default"})
+	if err != nil || len(objs) != 2 {
+		t.Errorf("expected 2 Cars in the default namespace, got %d (%v)", len(objs), err)
+	}
+	page, next, err := c.ListPage(carKind, filter.WithLimit(2))
+	if err != nil || len(page) != 2 || len(next) == 0 {
+		t.Fatalf("expected a page of 2 Cars and a continue token, got %d and %q (%v)", len(page), next, err)
+	}
+	page, next, err = c.ListPage(carKind, filter.WithLimit(2), filter.WithContinue(next))
+	if err != nil || len(page) != 1 || len(next) != 0 || page[0].GetName() != "baz" {
+		t.Errorf("expected the last page to contain baz, got %v and %q (%v)", page, next, err)
+	}
+	if count, err := c.CountNamespace(carKind, "other"); err != nil || count != 1 {
+		t.Errorf("expected 1 Car in the other namespace, got %d (%v)", count, err)
+	}
+	if namespaces, err := c.ListNamespaces(carKind); err != nil || strings.Join(namespaces, ",") != "default,other" {
+		t.Errorf("expected the namespaces default and other, got %v (%v)", namespaces, err)
+	}
+
+	if err := c.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(key); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestWatchKind(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remote-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := watch.NewManifestStorage(dir, scheme.Serializer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	server := httptest.NewServer(rest.Handler(s, scheme.Serializer))
+	defer server.Close()
+
+	c, err := New(server.URL, scheme.Serializer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates := make(update.UpdateStream, 4)
+	if err := c.WatchKind(ctx, carKind.GetGVK(), updates); err != nil {
+		t.Fatal(err)
+	}
+
+	// Change the file of a Car on the server, like e.g. a git pull would
+	file := filepath.Join(dir, "foo.yaml")
+	writeCar := func(brand string) {
+		content := "apiVersion: sample-app.weave.works/v1alpha1\nkind: Car\nmetadata:\n  name: foo\n  namespace: default\nspec:\n  brand: " + brand + "\n"
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectEvent := func(event update.ObjectEvent, brand string) {
+		t.Helper()
+		select {
+		case upd := <-updates:
+			if upd.Event != event || upd.PartialObject.GetName() != "foo" || upd.PartialObject.GetNamespace() != "default" {
+				t.Fatalf("expected a %s event for default/foo, got %s for %s/%s", event, upd.Event, upd.PartialObject.GetNamespace(), upd.PartialObject.GetName())
+			}
+			if len(brand) != 0 {
+				if got := upd.Object.(*v1alpha1.Car).Spec.Brand; got != brand {
+					t.Errorf("expected brand %q, got %q", brand, got)
+				}
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("timed out waiting for the %s event", event)
+		}
+	}
+
+	writeCar("Volvo")
+	expectEvent(update.ObjectEventCreate, "Volvo")
+	writeCar("Saab")
+	expectEvent(update.ObjectEventModify, "Saab")
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(update.ObjectEventDelete, "")
+}
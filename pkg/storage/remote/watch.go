@@ -0,0 +1,121 @@
+package remote
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/weaveworks/libgitops/pkg/rest"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/serializer"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/storage/watch/update"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// maxEventSize is the maximum size of a line of a server-sent event
+const maxEventSize = 16 * 1024 * 1024
+
+// WatchKind requests the ObjectEvents for objects with the group and kind of gvk from the server,
+// and sends them to ch until ctx is cancelled, or the server ends the stream. The events are
+// streamed as server-sent events, see rest.WriteEvent. The updates carry the decoded object for
+// CREATE and MODIFY events, and the PartialObject of all events but SYNC. ch is never closed.
+func (c *Client) WatchKind(ctx context.Context, gvk schema.GroupVersionKind, ch update.UpdateStream) error {
+	req, err := c.newRequest(http.MethodGet, rest.KindPath(storage.NewKindKey(gvk), ""), url.Values{"watch": {"true"}}, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", rest.EventStreamContentType)
+
+	resp, err := c.opts.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return statusError(req, resp)
+	}
+
+	go func() {
+		defer resp.Body.Close()
+		err := readEvents(resp, func(event string, data []byte) error {
+			upd, err := c.newUpdate(event, data)
+			if err != nil {
+				return err
+			}
+
+			select {
+			case ch <- upd:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		if err != nil && ctx.Err() == nil {
+			log.Errorf("Watching %s failed: %v", gvk.GroupKind(), err)
+		}
+	}()
+	return nil
+}
+
+// newUpdate returns the Update for the server-sent event of the given type with the given data
+func (c *Client) newUpdate(event string, data []byte) (update.Update, error) {
+	upd := update.Update{Storage: c}
+	for e := update.ObjectEventCreate; e <= update.ObjectEventSync; e++ {
+		if e.String() == event {
+			upd.Event = e
+		}
+	}
+	if upd.Event == update.ObjectEventNone {
+		return upd, fmt.Errorf("unknown event %q", event)
+	} else if upd.Event == update.ObjectEventSync {
+		return upd, nil
+	}
+
+	var err error
+	if upd.PartialObject, err = runtime.NewPartialObject(data); err != nil {
+		return upd, err
+	}
+	if upd.Event != update.ObjectEventDelete {
+		if upd.Object, err = c.decode(serializer.ContentTypeJSON, data); err != nil {
+			return upd, err
+		}
+	}
+	return upd, nil
+}
+
+// readEvents calls fn for every server-sent event in the body of the given response, until
+// the body ends or fn returns an error. Events without a type are ignored.
+func readEvents(resp *http.Response, fn func(event string, data []byte) error) error {
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, maxEventSize)
+
+	var event string
+	var data bytes.Buffer
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case len(line) == 0:
+			// An empty line dispatches the event
+			if len(event) != 0 {
+				if err := fn(event, bytes.TrimSuffix(data.Bytes(), []byte("\n"))); err != nil {
+					return err
+				}
+			}
+			event = ""
+			data.Reset()
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+			data.WriteByte('\n')
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,209 @@
+package retrybackend
+
+import (
+	"errors"
+	"math/rand"
+	"syscall"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/weaveworks/libgitops/pkg/logs"
+	"github.com/weaveworks/libgitops/pkg/storage"
+)
+
+// transientErrnos are the errno values considered transient by IsTransient. They're
+// returned e.g. by NFS when the server is briefly unavailable, or a file handle went stale.
+var transientErrnos = []syscall.Errno{
+	syscall.EAGAIN,
+	syscall.EBUSY,
+	syscall.EINTR,
+	syscall.ESTALE,
+	syscall.ETIMEDOUT,
+}
+
+// IsTransient returns true if err is caused by one of the errno values EAGAIN, EBUSY, EINTR,
+// ESTALE or ETIMEDOUT, which likely go away when retrying the operation. All other errors,
+// e.g. storage.ErrNotFound or permission errors, are considered permanent.
+func IsTransient(err error) bool {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+
+	for _, transient := range transientErrnos {
+		if errno == transient {
+			return true
+		}
+	}
+	return false
+}
+
+// Policy specifies how the Backend retries operations failing with transient errors. The zero
+// value of each field means its default value.
+type Policy struct {
+	// Attempts is the maximum number of attempts of an operation, including the first one. (Default: 5)
+	Attempts int
+	// BaseDelay is the delay before the first retry. The delay doubles for every further retry. (Default: 10ms)
+	BaseDelay time.Duration
+	// MaxDelay is the maximum delay before a retry. (Default: 1s)
+	MaxDelay time.Duration
+	// Jitter is the fraction of each delay which is randomized, which spreads the retries of concurrent
+	// operations. E.g. for 0.5, the delays are between half of and the full exponential delay. A negative
+	// value disables the jitter. (Default: 0.5)
+	Jitter float64
+	// Retryable decides whether an operation failing with the given error is retried. (Default: IsTransient)
+	Retryable func(err error) bool
+	// Logger receives the logs of the retries, with the operation, key, delay and error as key/value
+	// pairs. (Default: nil, which logs to logs.Logger, see logs.NewLogger)
+	Logger logr.Logger
+}
+
+// DefaultPolicy returns the default Policy for the Backend
+func DefaultPolicy() Policy {
+	return Policy{
+		Attempts:  5,
+		BaseDelay: 10 * time.Millisecond,
+		MaxDelay:  time.Second,
+		Jitter:    0.5,
+		Retryable: IsTransient,
+	}
+}
+
+// withDefaults returns the Policy with its zero fields set to the ones of DefaultPolicy
+func (p Policy) withDefaults() Policy {
+	defaults := DefaultPolicy()
+	if p.Attempts == 0 {
+		p.Attempts = defaults.Attempts
+	}
+	if p.BaseDelay == 0 {
+		p.BaseDelay = defaults.BaseDelay
+	}
+	if p.MaxDelay == 0 {
+		p.MaxDelay = defaults.MaxDelay
+	}
+	if p.Jitter == 0 {
+		p.Jitter = defaults.Jitter
+	}
+	if p.Retryable == nil {
+		p.Retryable = defaults.Retryable
+	}
+	p.Logger = logs.OrDefault(p.Logger).WithName("retrybackend")
+	return p
+}
+
+// delay returns the delay before the given retry, starting at 1
+func (p *Policy) delay(retry int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < retry && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+
+	if jitter := time.Duration(p.Jitter * float64(delay)); jitter > 0 {
+		delay -= time.Duration(rand.Int63n(int64(jitter) + 1))
+	}
+	return delay
+}
+
+// New returns a Backend wrapping the given RawStorage, retrying its operations failing with
+// transient errors, as decided by the given Policy. This makes the Storage using the Backend
+// resilient against flaky filesystems, e.g. network filesystems. Exists can't report errors,
+// so it isn't retried, which also affects the operations of inner that call it internally.
+// The zero fields of policy are set to the ones of DefaultPolicy. If inner is a
+// MappedRawStorage, so is the returned RawStorage, and the mappings are forwarded to inner.
+func New(inner storage.RawStorage, policy Policy) storage.RawStorage {
+	b := &Backend{inner, policy.withDefaults()}
+	if mapped, ok := inner.(storage.MappedRawStorage); ok {
+		return &mappedBackend{b, mapped}
+	}
+	return b
+}
+
+// Backend is a RawStorage retrying the operations of the wrapped RawStorage
+type Backend struct {
+	storage.RawStorage
+	policy Policy
+}
+
+var _ storage.RawStorage = &Backend{}
+
+// mappedBackend is a Backend forwarding the mappings of the wrapped MappedRawStorage, which
+// aren't retried as they don't touch the files
+type mappedBackend struct {
+	*Backend
+	mapped storage.MappedRawStorage
+}
+
+var _ storage.MappedRawStorage = &mappedBackend{}
+
+func (b *mappedBackend) AddMapping(key storage.ObjectKey, path string) {
+	b.mapped.AddMapping(key, path)
+}
+
+func (b *mappedBackend) RemoveMapping(key storage.ObjectKey) {
+	b.mapped.RemoveMapping(key)
+}
+
+func (b *mappedBackend) SetMappings(m map[storage.ObjectKey]string) {
+	b.mapped.SetMappings(m)
+}
+
+func (b *mappedBackend) GetPath(key storage.ObjectKey) (string, error) {
+	return b.mapped.GetPath(key)
+}
+
+// retry invokes fn until it succeeds, fails with an error that isn't retryable, or the attempts
+// run out, and returns its last error
+func (b *Backend) retry(op string, key storage.KindKey, fn func() error) error {
+	err := fn()
+	for retry := 1; err != nil && retry < b.policy.Attempts && b.policy.Retryable(err); retry++ {
+		delay := b.policy.delay(retry)
+		b.policy.Logger.V(logs.DebugLevel).Info("Retrying operation", "operation", op, "key", key, "delay", delay, "error", err)
+		time.Sleep(delay)
+		err = fn()
+	}
+	return err
+}
+
+// Read reads the content of the object, retrying transient errors
+func (b *Backend) Read(key storage.ObjectKey) (content []byte, err error) {
+	err = b.retry("read", key, func() (err error) {
+		content, err = b.RawStorage.Read(key)
+		return err
+	})
+	return content, err
+}
+
+// Write writes the content of the object, retrying transient errors
+func (b *Backend) Write(key storage.ObjectKey, content []byte) error {
+	return b.retry("write", key, func() error {
+		return b.RawStorage.Write(key, content)
+	})
+}
+
+// Delete deletes the object, retrying transient errors
+func (b *Backend) Delete(key storage.ObjectKey) error {
+	return b.retry("delete", key, func() error {
+		return b.RawStorage.Delete(key)
+	})
+}
+
+// List lists the keys of the objects of the kind, retrying transient errors
+func (b *Backend) List(kind storage.KindKey) (keys []storage.ObjectKey, err error) {
+	err = b.retry("list", kind, func() (err error) {
+		keys, err = b.RawStorage.List(kind)
+		return err
+	})
+	return keys, err
+}
+
+// Checksum returns the checksum of the object, retrying transient errors
+func (b *Backend) Checksum(key storage.ObjectKey) (checksum string, err error) {
+	err = b.retry("checksum", key, func() (err error) {
+		checksum, err = b.RawStorage.Checksum(key)
+		return err
+	})
+	return checksum, err
+}
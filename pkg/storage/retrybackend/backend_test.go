@@ -0,0 +1,178 @@
+package retrybackend
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/scheme"
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/serializer"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/util/filesystem"
+)
+
+// failingFilesystem fails the next failures reads and writes with err
+type failingFilesystem struct {
+	filesystem.Filesystem
+	err      error
+	failures int
+	attempts int
+}
+
+func (fs *failingFilesystem) fail(op, filename string) error {
+	fs.attempts++
+	if fs.failures > 0 {
+		fs.failures--
+		return &os.PathError{Op: op, Path: filename, Err: fs.err}
+	}
+	return nil
+}
+
+func (fs *failingFilesystem) ReadFile(filename string) ([]byte, error) {
+	if err := fs.fail("read", filename); err != nil {
+		return nil, err
+	}
+	return fs.Filesystem.ReadFile(filename)
+}
+
+func (fs *failingFilesystem) WriteFile(filename string, data []byte, perm os.FileMode) error {
+	if err := fs.fail("open", filename); err != nil {
+		return err
+	}
+	return fs.Filesystem.WriteFile(filename, data, perm)
+}
+
+func TestBackend(t *testing.T) {
+	dir, err := filepath.Abs("manifests")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs := &failingFilesystem{Filesystem: filesystem.NewInMemory(), err: syscall.ESTALE}
+	opts := storage.DefaultRawStorageOptions()
+	opts.Filesystem = fs
+	opts.FileLayout = storage.FlatLayout
+	policy := DefaultPolicy()
+	policy.BaseDelay = time.Millisecond
+	s := storage.NewGenericStorage(New(storage.NewGenericMappedRawStorageWithOptions(dir, opts), policy), scheme.Serializer, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier})
+
+	expectAttempts := func(expected int) {
+		t.Helper()
+		if fs.attempts != expected {
+			t.Errorf("expected %d attempts, got %d", expected, fs.attempts)
+		}
+		fs.attempts = 0
+	}
+
+	// Transient errors are retried, until the third attempt succeeds
+	car := &v1alpha1.Car{Spec: v1alpha1.CarSpec{Brand: "Volvo"}}
+	car.SetName("foo")
+	car.SetNamespace("default")
+	fs.failures = 2
+	if err := s.Create(car); err != nil {
+		t.Fatal(err)
+	}
+	expectAttempts(3)
+
+	key, err := s.ObjectKeyFor(car)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.failures = 2
+	obj, err := s.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if brand := obj.(*v1alpha1.Car).Spec.Brand; brand != "Volvo" {
+		t.Errorf("expected brand %q, got %q", "Volvo", brand)
+	}
+	expectAttempts(3)
+
+	// The retries are limited by the Policy
+	fs.failures = policy.Attempts
+	if _, err := s.Get(key); !errors.Is(err, syscall.ESTALE) {
+		t.Errorf("expected ESTALE, got %v", err)
+	}
+	expectAttempts(policy.Attempts)
+	fs.failures = 0
+
+	// Permanent errors aren't retried
+	fs.err, fs.failures = syscall.EACCES, 2
+	if _, err := s.Get(key); !errors.Is(err, os.ErrPermission) {
+		t.Errorf("expected a permission error, got %v", err)
+	}
+	expectAttempts(1)
+	fs.failures = 0
+
+	car.SetName("bar")
+	barKey, err := s.ObjectKeyFor(car)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(barKey); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	expectAttempts(0)
+}
+
+func TestPolicyDelay(t *testing.T) {
+	p := Policy{BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond}
+	for retry, expected := range []time.Duration{10, 20, 40, 50, 50} {
+		if delay := p.delay(retry + 1); delay != expected*time.Millisecond {
+			t.Errorf("expected delay %v for retry %d, got %v", expected*time.Millisecond, retry+1, delay)
+		}
+	}
+
+	// The jitter only shortens the delays
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if delay := p.delay(2); delay < 10*time.Millisecond || delay > 20*time.Millisecond {
+			t.Fatalf("expected a delay between 10ms and 20ms, got %v", delay)
+		}
+	}
+}
+
+func TestNewDefaults(t *testing.T) {
+	fs := &failingFilesystem{Filesystem: filesystem.NewInMemory(), err: syscall.ESTALE}
+	if err := fs.MkdirAll("/manifests", 0755); err != nil {
+		t.Fatal(err)
+	}
+	opts := storage.DefaultRawStorageOptions()
+	opts.Filesystem = fs
+	raw := storage.NewGenericMappedRawStorageWithOptions("/manifests", opts)
+	key := storage.NewObjectKey(storage.NewKindKey(v1alpha1.SchemeGroupVersion.WithKind("Car")), runtime.NewIdentifier("default/foo"))
+	raw.AddMapping(key, "/manifests/foo.yaml")
+
+	// The zero fields of the Policy are set to the defaults, so the operations are retried
+	b := New(raw, Policy{MaxDelay: time.Millisecond})
+	fs.failures = 2
+	if err := b.Write(key, []byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+	if fs.attempts != 3 {
+		t.Errorf("expected 3 attempts, got %d", fs.attempts)
+	}
+
+	// The mappings of a MappedRawStorage are forwarded
+	mapped, ok := b.(storage.MappedRawStorage)
+	if !ok {
+		t.Fatal("expected a MappedRawStorage")
+	}
+	if path, err := mapped.GetPath(key); err != nil || path != "/manifests/foo.yaml" {
+		t.Errorf("expected path %q, got %q (%v)", "/manifests/foo.yaml", path, err)
+	}
+	mapped.RemoveMapping(key)
+	if raw.Exists(key) {
+		t.Error("expected the mapping to be removed from the wrapped storage")
+	}
+
+	// Other RawStorages aren't turned into MappedRawStorages
+	if _, ok := New(storage.NewGenericRawStorageWithFilesystem("/manifests", v1alpha1.SchemeGroupVersion, serializer.ContentTypeYAML, fs), Policy{}).(storage.MappedRawStorage); ok {
+		t.Error("expected the Backend not to be a MappedRawStorage")
+	}
+}
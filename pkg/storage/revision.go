@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RevisionAnnotation holds the revision of an object, see Options.ManageRevision
+const RevisionAnnotation = "storage.libgitops.weave.works/revision"
+
+// ErrStaleRevision is returned by Get if the stored object is older than the requested revision,
+// e.g. because a write hasn't reached the storage yet. The read can be retried later.
+var ErrStaleRevision = errors.New("the stored object is older than the requested revision")
+
+// Revision returns the revision of obj, or 0 if it has none
+func Revision(obj metav1.Object) uint64 {
+	revision, err := strconv.ParseUint(obj.GetAnnotations()[RevisionAnnotation], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return revision
+}
+
+// setRevision sets the revision of obj
+func setRevision(obj metav1.Object, revision uint64) {
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string, 1)
+	}
+
+	annotations[RevisionAnnotation] = strconv.FormatUint(revision, 10)
+	obj.SetAnnotations(annotations)
+}
+
+// bumpRevision sets the revision of obj to the one of the stored object for key incremented
+// by one, or to 1 if the object isn't stored yet. The revision of obj itself is ignored.
+func (s *GenericStorage) bumpRevision(key ObjectKey, obj runtime.Object) error {
+	var revision uint64
+	if s.raw.Exists(key) {
+		old, err := s.GetMeta(key)
+		if err != nil {
+			return err
+		}
+		revision = Revision(old)
+	}
+
+	setRevision(obj, revision+1)
+	return nil
+}
+
+// GetOption is an option for Get
+type GetOption func(*getOptions)
+
+type getOptions struct {
+	minRevision uint64
+}
+
+// WithMinRevision makes Get return ErrStaleRevision if the revision of the stored object is
+// older than the given one. This makes sure that a read sees an earlier write of the revision.
+func WithMinRevision(revision uint64) GetOption {
+	return func(o *getOptions) {
+		o.minRevision = revision
+	}
+}
+
+// Get returns the object for key from s, like ReadStorage.Get, but with the given options.
+// If s implements ContextStorage, the object is read in ctx.
+func Get(ctx context.Context, s Storage, key ObjectKey, opts ...GetOption) (runtime.Object, error) {
+	o := &getOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	obj, err := WithContext(s, ctx).Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if revision := Revision(obj); revision < o.minRevision {
+		return nil, fmt.Errorf("%s: revision %d is older than %d: %w", key, revision, o.minRevision, ErrStaleRevision)
+	}
+	return obj, nil
+}
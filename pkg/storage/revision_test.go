@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestGetWithMinRevision(t *testing.T) {
+	s, _, _ := newTestStorage(t, func(_ *RawStorageOptions, opts *Options) {
+		opts.ManageRevision = true
+	})
+	ctx := context.Background()
+
+	car := &v1alpha1.Car{Spec: v1alpha1.CarSpec{Brand: "Volvo"}}
+	car.SetName("foo")
+	car.SetNamespace("default")
+	if err := s.Create(car); err != nil {
+		t.Fatal(err)
+	}
+	if rev := Revision(car); rev != 1 {
+		t.Errorf("expected revision 1 after Create, got %d", rev)
+	}
+
+	// The revision of the written object is ignored
+	setRevision(car, 10)
+	car.Spec.Brand = "Tesla"
+	if err := s.Update(car); err != nil {
+		t.Fatal(err)
+	}
+	if rev := Revision(car); rev != 2 {
+		t.Errorf("expected revision 2 after Update, got %d", rev)
+	}
+	// JSON patches can be applied to YAML files
+	if err := s.PatchWithType(carKey, types.JSONPatchType, []byte(`[{"op":"replace","path":"/spec/brand","value":"Audi"}]`)); err != nil {
+		t.Fatal(err)
+	}
+
+	obj, err := Get(ctx, s, carKey, WithMinRevision(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev := Revision(obj); rev != 3 || obj.(*v1alpha1.Car).Spec.Brand != "Audi" {
+		t.Errorf("expected the patched object with revision 3, got %d", rev)
+	}
+
+	// A read can't see the next write before it's made
+	if _, err := Get(ctx, s, carKey, WithMinRevision(4)); !errors.Is(err, ErrStaleRevision) {
+		t.Errorf("expected ErrStaleRevision, got %v", err)
+	}
+	if _, err := Get(ctx, s, carKey); err != nil {
+		t.Error(err)
+	}
+}
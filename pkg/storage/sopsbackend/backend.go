@@ -0,0 +1,215 @@
+package sopsbackend
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/weaveworks/libgitops/pkg/serializer"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"go.mozilla.org/sops/v3"
+	"go.mozilla.org/sops/v3/aes"
+	"go.mozilla.org/sops/v3/age"
+	sopsjson "go.mozilla.org/sops/v3/stores/json"
+	"go.mozilla.org/sops/v3/version"
+)
+
+// CreationRule describes how the Backend encrypts the files of new objects
+type CreationRule struct {
+	// AgeRecipients are the age public keys the data key of new files is encrypted for
+	AgeRecipients []string
+	// EncryptedRegex selects the fields to encrypt by their keys, e.g. "^(data|stringData)$".
+	// If empty, all values are encrypted.
+	EncryptedRegex string
+}
+
+// Option configures a Backend
+type Option func(*options)
+
+// options holds the configuration of a Backend
+type options struct {
+	creationRule *CreationRule
+}
+
+// WithCreationRule makes the Backend encrypt the files of new objects using the given rule.
+// (Default: new objects are written in plaintext)
+func WithCreationRule(rule CreationRule) Option {
+	return func(o *options) {
+		o.creationRule = &rule
+	}
+}
+
+// New returns a Backend wrapping the given RawStorage, which decrypts SOPS-encrypted files when
+// they're read, and re-encrypts them when they're written. Only the fields selected by the SOPS
+// metadata of a file are encrypted, hence the other fields stay readable and diffable. The SOPS
+// metadata of the file is preserved, and values that weren't changed keep their ciphertext.
+// Files without SOPS metadata are passed through in plaintext. The data keys are decrypted using
+// the age identities in the SOPS_AGE_KEY or SOPS_AGE_KEY_FILE environment variables. Only YAML
+// and JSON files are supported.
+func New(inner storage.RawStorage, opts ...Option) *Backend {
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return &Backend{inner, o}
+}
+
+// Backend is a RawStorage encrypting selected fields of the files of the wrapped RawStorage using SOPS
+type Backend struct {
+	storage.RawStorage
+	opts options
+}
+
+var _ storage.RawStorage = &Backend{}
+
+// Read reads the file of the object, and decrypts it if it is encrypted
+func (b *Backend) Read(key storage.ObjectKey) ([]byte, error) {
+	content, err := b.RawStorage.Read(key)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := b.store(key)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := store.LoadEncryptedFile(content)
+	if errors.Is(err, sops.MetadataNotFound) {
+		return content, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if _, err := decrypt(tree, aes.NewCipher()); err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s: %w", key, err)
+	}
+	return store.EmitPlainFile(tree.Branches)
+}
+
+// Write encrypts the given plaintext content using the SOPS metadata of the existing file,
+// or the CreationRule for new objects, and writes it to the wrapped RawStorage
+func (b *Backend) Write(key storage.ObjectKey, content []byte) error {
+	store, err := b.store(key)
+	if err != nil {
+		return err
+	}
+
+	// Use the same Cipher for decrypting the existing file, and encrypting the new one,
+	// so that unchanged values keep their ciphertext
+	cipher := aes.NewCipher()
+	tree, dataKey, err := b.existingTree(key, store, cipher)
+	if err != nil {
+		return err
+	}
+	if tree == nil {
+		return b.RawStorage.Write(key, content)
+	}
+
+	if tree.Branches, err = store.LoadPlainFile(content); err != nil {
+		return err
+	}
+	mac, err := tree.Encrypt(dataKey, cipher)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt %s: %w", key, err)
+	}
+	tree.Metadata.LastModified = time.Now().UTC()
+	if tree.Metadata.MessageAuthenticationCode, err = cipher.Encrypt(mac, dataKey, tree.Metadata.LastModified.Format(time.RFC3339)); err != nil {
+		return fmt.Errorf("failed to encrypt the MAC of %s: %w", key, err)
+	}
+
+	encrypted, err := store.EmitEncryptedFile(*tree)
+	if err != nil {
+		return err
+	}
+	return b.RawStorage.Write(key, encrypted)
+}
+
+// existingTree returns the decrypted SOPS tree of the existing file of the object and its data
+// key, or a tree with new metadata for new objects if there's a CreationRule. If the file isn't
+// encrypted, or the object is new and there's no CreationRule, a nil tree is returned.
+func (b *Backend) existingTree(key storage.ObjectKey, store fileStore, cipher sops.Cipher) (*sops.Tree, []byte, error) {
+	content, err := b.RawStorage.Read(key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return b.newTree()
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	tree, err := store.LoadEncryptedFile(content)
+	if errors.Is(err, sops.MetadataNotFound) {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	dataKey, err := decrypt(tree, cipher)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decrypt %s: %w", key, err)
+	}
+	return &tree, dataKey, nil
+}
+
+// newTree returns a tree with the metadata of the CreationRule and a new data key, or nil
+func (b *Backend) newTree() (*sops.Tree, []byte, error) {
+	rule := b.opts.creationRule
+	if rule == nil {
+		return nil, nil, nil
+	}
+
+	masterKeys, err := age.MasterKeysFromRecipients(strings.Join(rule.AgeRecipients, ","))
+	if err != nil {
+		return nil, nil, err
+	}
+	group := make(sops.KeyGroup, 0, len(masterKeys))
+	for _, masterKey := range masterKeys {
+		group = append(group, masterKey)
+	}
+
+	tree := &sops.Tree{
+		Metadata: sops.Metadata{
+			KeyGroups:      []sops.KeyGroup{group},
+			EncryptedRegex: rule.EncryptedRegex,
+			Version:        version.Version,
+		},
+	}
+	dataKey, errs := tree.GenerateDataKey()
+	if len(errs) != 0 {
+		return nil, nil, fmt.Errorf("failed to encrypt the data key: %v", errs)
+	}
+	return tree, dataKey, nil
+}
+
+// decrypt decrypts the given tree in place, verifies its MAC, and returns its data key
+func decrypt(tree sops.Tree, cipher sops.Cipher) ([]byte, error) {
+	dataKey, err := tree.Metadata.GetDataKey()
+	if err != nil {
+		return nil, err
+	}
+
+	mac, err := tree.Decrypt(dataKey, cipher)
+	if err != nil {
+		return nil, err
+	}
+	originalMAC, err := cipher.Decrypt(tree.Metadata.MessageAuthenticationCode, dataKey, tree.Metadata.LastModified.Format(time.RFC3339))
+	if err != nil {
+		return nil, err
+	}
+	if originalMAC != mac {
+		return nil, errors.New("failed to verify the integrity of the data: the MAC doesn't match")
+	}
+	return dataKey, nil
+}
+
+// store returns the fileStore for the content type of the object
+func (b *Backend) store(key storage.ObjectKey) (fileStore, error) {
+	switch ct := b.ContentType(key); ct {
+	case serializer.ContentTypeYAML:
+		return yamlStore{}, nil
+	case serializer.ContentTypeJSON:
+		return &sopsjson.Store{}, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", serializer.ErrUnsupportedContentType, ct)
+	}
+}
@@ -0,0 +1,161 @@
+package sopsbackend
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/scheme"
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/util/filesystem"
+	sopsage "go.mozilla.org/sops/v3/age"
+)
+
+const (
+	// testAgeKey is the age identity testdata/car.sops.yaml was encrypted for, using the SOPS CLI
+	testAgeKey       = "AGE-SECRET-KEY-1WTM72HKDRRCE9YR460LMEVATKXQFFTL5XZDFSHPNTVQK9V2GALZQ46LWWY"
+	testAgeRecipient = "age1pwa7nwuaq6nythn7wwuhqjmhzdwe3rt272ct3de8qju4rzzzw5asklu3yw"
+)
+
+// lineWith returns the first line of content containing substr
+func lineWith(content []byte, substr string) []byte {
+	for _, line := range bytes.Split(content, []byte("\n")) {
+		if bytes.Contains(line, []byte(substr)) {
+			return line
+		}
+	}
+	return nil
+}
+
+// newTestStorage returns a Storage using a Backend with the given options, the wrapped
+// RawStorage, its in-memory Filesystem and the path of the file of the "default/foo" Car
+func newTestStorage(t *testing.T, opts ...Option) (storage.Storage, storage.RawStorage, filesystem.Filesystem, string) {
+	t.Helper()
+	dir, err := filepath.Abs("manifests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := filesystem.NewInMemory()
+	rawOpts := storage.DefaultRawStorageOptions()
+	rawOpts.Filesystem = fs
+	rawOpts.FileLayout = storage.FlatLayout
+	raw := storage.NewGenericMappedRawStorageWithOptions(dir, rawOpts)
+	s := storage.NewGenericStorage(New(raw, opts...), scheme.Serializer, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier})
+	return s, raw, fs, filepath.Join(dir, "car_default_foo.yaml")
+}
+
+func setAgeKey(t *testing.T, key string) {
+	t.Helper()
+	if err := os.Setenv(sopsage.SopsAgeKeyEnv, key); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBackend(t *testing.T) {
+	defer os.Unsetenv(sopsage.SopsAgeKeyEnv)
+	setAgeKey(t, testAgeKey)
+
+	encrypted, err := ioutil.ReadFile("testdata/car.sops.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, raw, fs, file := newTestStorage(t)
+	key := storage.NewObjectKey(storage.NewKindKey(v1alpha1.SchemeGroupVersion.WithKind("Car")), runtime.NewIdentifier("default/foo"))
+	if err := raw.Write(key, encrypted); err != nil {
+		t.Fatal(err)
+	}
+
+	obj, err := s.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	car := obj.(*v1alpha1.Car)
+	if car.Spec.Engine != "secret-v8" || car.Spec.Brand != "Volvo" {
+		t.Errorf("expected the Car to be decrypted, got %+v", car.Spec)
+	}
+
+	// Updating a plaintext field keeps the ciphertext of the engine, and the SOPS metadata
+	car.Spec.Brand = "Saab"
+	if err := s.Update(car); err != nil {
+		t.Fatal(err)
+	}
+	updated, err := fs.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(updated, []byte("brand: Saab")) {
+		t.Errorf("expected the brand to be updated in plaintext, got:\n%s", updated)
+	}
+	for _, substr := range []string{"engine:", "recipient:", "encrypted_regex:"} {
+		// The indentation of sequences differs from the SOPS CLI, hence only the values are compared
+		if before, after := lineWith(encrypted, substr), lineWith(updated, substr); before == nil || !bytes.Equal(bytes.TrimSpace(before), bytes.TrimSpace(after)) {
+			t.Errorf("expected the line with %q to be preserved, got %q and %q", substr, before, after)
+		}
+	}
+	if obj, err := s.Get(key); err != nil || obj.(*v1alpha1.Car).Spec.Engine != "secret-v8" {
+		t.Errorf("expected the updated Car to be decrypted, got %v (%v)", obj, err)
+	}
+
+	// The files can't be read without the key
+	other, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	setAgeKey(t, other.String())
+	if _, err := s.Get(key); err == nil {
+		t.Error("expected reading without the key to fail")
+	}
+
+	// Files without SOPS metadata are passed through
+	if err := raw.Write(key, []byte("spec:\n  brand: Volvo\n")); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := New(raw).Read(key); err != nil || string(content) != "spec:\n  brand: Volvo\n" {
+		t.Errorf("expected the plaintext file to be passed through, got %q (%v)", content, err)
+	}
+}
+
+func TestCreationRule(t *testing.T) {
+	defer os.Unsetenv(sopsage.SopsAgeKeyEnv)
+	setAgeKey(t, testAgeKey)
+
+	s, _, fs, file := newTestStorage(t, WithCreationRule(CreationRule{
+		AgeRecipients:  []string{testAgeRecipient},
+		EncryptedRegex: "^engine$",
+	}))
+	car := &v1alpha1.Car{Spec: v1alpha1.CarSpec{Brand: "Volvo", Engine: "secret-v8"}}
+	car.SetName("foo")
+	car.SetNamespace("default")
+	if err := s.Create(car); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the engine is encrypted
+	encrypted, err := fs.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(encrypted, []byte("secret-v8")) || !bytes.Contains(lineWith(encrypted, "engine:"), []byte("ENC[AES256_GCM")) {
+		t.Errorf("expected the engine to be encrypted, got:\n%s", encrypted)
+	}
+	if !bytes.Contains(encrypted, []byte("brand: Volvo")) || !bytes.Contains(encrypted, []byte(testAgeRecipient)) {
+		t.Errorf("expected the brand in plaintext and the SOPS metadata, got:\n%s", encrypted)
+	}
+
+	key, err := s.ObjectKeyFor(car)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := s.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if engine := obj.(*v1alpha1.Car).Spec.Engine; engine != "secret-v8" {
+		t.Errorf("expected the engine to be decrypted, got %q", engine)
+	}
+}
@@ -0,0 +1,19 @@
+module github.com/weaveworks/libgitops/pkg/storage/sopsbackend
+
+go 1.14
+
+// The SOPS backend is a module of its own, so that users of libgitops don't depend on SOPS and its
+// many dependencies. The replace directives of libgitops are repeated, as they only apply to the
+// main module. libgitops itself is built from this repository.
+replace (
+	github.com/docker/distribution => github.com/docker/distribution v2.7.1+incompatible
+	github.com/googleapis/gnostic => github.com/googleapis/gnostic v0.3.0
+	github.com/weaveworks/libgitops => ../../..
+)
+
+require (
+	filippo.io/age v1.0.0
+	github.com/weaveworks/libgitops v0.0.0
+	go.mozilla.org/sops/v3 v3.7.3
+	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b
+)
@@ -0,0 +1,202 @@
+package sopsbackend
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"go.mozilla.org/sops/v3"
+	"go.mozilla.org/sops/v3/stores"
+	"gopkg.in/yaml.v3"
+)
+
+// fileStore loads and emits the SOPS trees of files of a given format
+type fileStore interface {
+	sops.EncryptedFileLoader
+	sops.PlainFileLoader
+	sops.EncryptedFileEmitter
+	sops.PlainFileEmitter
+}
+
+// yamlStore is a fileStore for YAML files in the format of the SOPS YAML store. Comments are
+// not preserved, as the serializer doesn't write them to the files. The SOPS YAML store can't
+// be used directly, as it requires a newer version of the YAML library than the serializer.
+type yamlStore struct{}
+
+var _ fileStore = yamlStore{}
+
+func (yamlStore) LoadEncryptedFile(in []byte) (sops.Tree, error) {
+	// The metadata is loaded separately, as its structure is known
+	metadataHolder := stores.SopsFile{}
+	if err := yaml.Unmarshal(in, &metadataHolder); err != nil {
+		return sops.Tree{}, fmt.Errorf("failed to unmarshal the YAML: %w", err)
+	}
+	if metadataHolder.Metadata == nil {
+		return sops.Tree{}, sops.MetadataNotFound
+	}
+	metadata, err := metadataHolder.Metadata.ToInternal()
+	if err != nil {
+		return sops.Tree{}, err
+	}
+
+	branches, err := loadYAMLBranches(in)
+	if err != nil {
+		return sops.Tree{}, err
+	}
+	for i, branch := range branches {
+		for j, item := range branch {
+			if item.Key == "sops" {
+				branches[i] = append(branch[:j], branch[j+1:]...)
+				break
+			}
+		}
+	}
+	return sops.Tree{Branches: branches, Metadata: metadata}, nil
+}
+
+func (yamlStore) LoadPlainFile(in []byte) (sops.TreeBranches, error) {
+	return loadYAMLBranches(in)
+}
+
+func (yamlStore) EmitEncryptedFile(in sops.Tree) ([]byte, error) {
+	branches := make(sops.TreeBranches, 0, len(in.Branches))
+	for _, branch := range in.Branches {
+		// Copy the branch, so that the metadata isn't appended to the tree itself
+		branch = append(append(sops.TreeBranch(nil), branch...), sops.TreeItem{
+			Key:   "sops",
+			Value: stores.MetadataFromInternal(in.Metadata),
+		})
+		branches = append(branches, branch)
+	}
+	return emitYAMLBranches(branches)
+}
+
+func (yamlStore) EmitPlainFile(branches sops.TreeBranches) ([]byte, error) {
+	return emitYAMLBranches(branches)
+}
+
+// loadYAMLBranches loads every YAML document as a TreeBranch, keeping the order of the fields
+func loadYAMLBranches(in []byte) (sops.TreeBranches, error) {
+	var branches sops.TreeBranches
+	d := yaml.NewDecoder(bytes.NewReader(in))
+	for {
+		var doc yaml.Node
+		if err := d.Decode(&doc); err == io.EOF {
+			return branches, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal the YAML: %w", err)
+		}
+
+		if len(doc.Content) == 0 {
+			continue
+		}
+		value, err := yamlNodeToTreeValue(doc.Content[0])
+		if err != nil {
+			return nil, err
+		}
+		branch, ok := value.(sops.TreeBranch)
+		if !ok {
+			return nil, fmt.Errorf("expected a YAML mapping at the top-level of the document")
+		}
+		branches = append(branches, branch)
+	}
+}
+
+// yamlNodeToTreeValue converts the given YAML node to a TreeBranch, slice or scalar value
+func yamlNodeToTreeValue(node *yaml.Node) (interface{}, error) {
+	switch node.Kind {
+	case yaml.MappingNode:
+		branch := make(sops.TreeBranch, 0, len(node.Content)/2)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			var key interface{}
+			if err := node.Content[i].Decode(&key); err != nil {
+				return nil, err
+			}
+			value, err := yamlNodeToTreeValue(node.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+			branch = append(branch, sops.TreeItem{Key: key, Value: value})
+		}
+		return branch, nil
+	case yaml.SequenceNode:
+		values := make([]interface{}, 0, len(node.Content))
+		for _, item := range node.Content {
+			value, err := yamlNodeToTreeValue(item)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		return values, nil
+	case yaml.AliasNode:
+		return yamlNodeToTreeValue(node.Alias)
+	default:
+		var value interface{}
+		if err := node.Decode(&value); err != nil {
+			return nil, err
+		}
+		return value, nil
+	}
+}
+
+// emitYAMLBranches emits every TreeBranch as a YAML document, indented like the SOPS YAML store
+func emitYAMLBranches(branches sops.TreeBranches) ([]byte, error) {
+	var buf bytes.Buffer
+	e := yaml.NewEncoder(&buf)
+	e.SetIndent(4)
+	for _, branch := range branches {
+		node, err := treeValueToYAMLNode(branch)
+		if err != nil {
+			return nil, err
+		}
+		if err := e.Encode(node); err != nil {
+			return nil, fmt.Errorf("failed to marshal the YAML: %w", err)
+		}
+	}
+	if err := e.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// treeValueToYAMLNode converts the given TreeBranch, slice or other value to a YAML node
+func treeValueToYAMLNode(value interface{}) (*yaml.Node, error) {
+	switch v := value.(type) {
+	case sops.TreeBranch:
+		node := &yaml.Node{Kind: yaml.MappingNode}
+		for _, item := range v {
+			key, err := treeValueToYAMLNode(item.Key)
+			if err != nil {
+				return nil, err
+			}
+			val, err := treeValueToYAMLNode(item.Value)
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, key, val)
+		}
+		return node, nil
+	case []interface{}:
+		node := &yaml.Node{Kind: yaml.SequenceNode}
+		for _, item := range v {
+			val, err := treeValueToYAMLNode(item)
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, val)
+		}
+		return node, nil
+	default:
+		// Let the YAML library encode scalars and the metadata, by marshalling them into a document
+		out, err := yaml.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		var doc yaml.Node
+		if err := yaml.Unmarshal(out, &doc); err != nil {
+			return nil, err
+		}
+		return doc.Content[0], nil
+	}
+}
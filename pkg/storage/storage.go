@@ -2,18 +2,23 @@ package storage
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 
-	"github.com/sirupsen/logrus"
+	"github.com/go-logr/logr"
 	"github.com/weaveworks/libgitops/pkg/filter"
+	"github.com/weaveworks/libgitops/pkg/logs"
 	"github.com/weaveworks/libgitops/pkg/runtime"
 	"github.com/weaveworks/libgitops/pkg/serializer"
 	patchutil "github.com/weaveworks/libgitops/pkg/util/patch"
+	"go.opentelemetry.io/otel/trace"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	kruntime "k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
 )
 
 var (
@@ -23,6 +28,10 @@ var (
 	ErrNotFound = errors.New("resource not found")
 	// ErrAlreadyExists is returned when when WriteStorage.Create is called for an already stored object.
 	ErrAlreadyExists = errors.New("resource already exists")
+	// ErrUnsupportedPatchType is returned when WriteStorage.PatchWithType is called with an unknown patch type.
+	ErrUnsupportedPatchType = errors.New("unsupported patch type")
+	// ErrInvalidContinue is returned when ReadStorage.ListPage is called with a malformed continue token.
+	ErrInvalidContinue = errors.New("invalid continue token")
 )
 
 type ReadStorage interface {
@@ -31,9 +40,16 @@ type ReadStorage interface {
 	Get(key ObjectKey) (runtime.Object, error)
 
 	// List lists Objects for the specific kind. Optionally, filters can be applied (see the filter package
-	// for more information, e.g. filter.NameFilter{} and filter.UIDFilter{})
+	// for more information, e.g. filter.NameFilter{} and filter.UIDFilter{}). If filter.WithMetadataOnly is
+	// given, only the metadata of the objects is decoded, and runtime.PartialObjects are returned.
 	List(kind KindKey, opts ...filter.ListOption) ([]runtime.Object, error)
 
+	// ListPage is the same as List, but also returns a continue token if filter.WithLimit was given
+	// and more objects match. The next page is listed by passing the token using filter.WithContinue.
+	// The objects are sorted by their namespaces and names, which makes the tokens stable across calls. The
+	// returned continue token is empty for the last page.
+	ListPage(kind KindKey, opts ...filter.ListOption) ([]runtime.Object, string, error)
+
 	// Find does a List underneath, also using filters, but always returns one object. If the List
 	// underneath returned two or more results, ErrAmbiguousFind is returned. If no match was found,
 	// ErrNotFound is returned.
@@ -65,6 +81,14 @@ type ReadStorage interface {
 	// Count returns the amount of available Objects of a specific kind
 	// This is used by Caches to check if all Objects are cached to perform a List
 	Count(kind KindKey) (uint64, error)
+	// CountNamespace returns the amount of available Objects of a specific kind in the given
+	// namespace. An empty namespace counts the Objects without a namespace. If the namespace doesn't
+	// match the scope of the kind, an error wrapping ErrNamespacedMismatch may be returned.
+	CountNamespace(kind KindKey, namespace string) (uint64, error)
+	// ListNamespaces returns the sorted namespaces of the Objects of a specific kind. How the
+	// namespaces are determined is decided by Options.NamespaceSource for the GenericStorage. If the
+	// kind isn't namespaced, an error wrapping ErrNamespacedMismatch may be returned.
+	ListNamespaces(kind KindKey) ([]string, error)
 
 	//
 	// Access to underlying Resources.
@@ -95,6 +119,9 @@ type WriteStorage interface {
 
 	// Patch performs a strategic merge patch on the Object with the given UID, using the byte-encoded patch given
 	Patch(key ObjectKey, patch []byte) error
+	// PatchWithType performs a patch of the given type on the Object with the given UID, using the byte-encoded
+	// patch given. Supported types are types.StrategicMergePatchType and types.JSONPatchType (RFC 6902).
+	PatchWithType(key ObjectKey, patchType types.PatchType, patch []byte) error
 	// Delete removes an Object from the storage
 	Delete(key ObjectKey) error
 }
@@ -106,9 +133,97 @@ type Storage interface {
 	WriteStorage
 }
 
+// Options specifies options for the GenericStorage
+type Options struct {
+	// PreserveFormatting specifies whether to keep the comments, field ordering and formatting
+	// of an existing YAML file when the object in it is updated. Only the changed fields are
+	// applied onto the file, which minimizes the diff, but makes writes more expensive.
+	PreserveFormatting bool
+	// ManageGeneration specifies whether to manage metadata.generation and metadata.resourceVersion
+	// like the Kubernetes API server does. Create sets the generation to 1, and every write changing
+	// the spec of the object increments it. The resourceVersion is set to the checksum of the file
+	// when reading or writing an object, and is never stored in the file itself.
+	ManageGeneration bool
+	// SkipEquivalentWrites specifies whether to skip writing objects whose canonical form (see
+	// serializer.Canonicalize) equals the one of the existing file. Semantically equal updates
+	// then don't rewrite the file, at the cost of reading the existing file on every write.
+	SkipEquivalentWrites bool
+	// ReadTransformers are applied in order to every object returned by Get and List, after it
+	// has been decoded. They only modify the returned objects, never the stored files. (Default: nil)
+	ReadTransformers []ReadTransformer
+	// WriteValidators are run for every object of the given GroupKind before it's written by Create,
+	// Update, Patch or PatchWithType. If any of them returns errors, the write is aborted with a
+	// *ValidationError aggregating all errors, and nothing is written. (Default: nil)
+	WriteValidators map[schema.GroupKind][]WriteValidator
+	// NamespaceSource decides how ListNamespaces determines the existing namespaces.
+	// (Default: NamespacesFromObjects)
+	NamespaceSource NamespaceSource
+	// Namespacer decides whether the kinds are namespaced. If set, CountNamespace and ListNamespaces
+	// return an error wrapping ErrNamespacedMismatch when a namespace is given for a kind which isn't
+	// namespaced, or vice versa. (Default: nil, which allows any namespace)
+	Namespacer Namespacer
+	// FinalizerSupport specifies whether to delete objects like the Kubernetes API server does. Delete
+	// only sets metadata.deletionTimestamp of objects with finalizers, and their files are removed once
+	// an Update or Patch clears the finalizers. Objects without finalizers are removed right away.
+	FinalizerSupport bool
+	// CascadingDelete specifies whether Delete also deletes the dependents of the deleted object, i.e. the
+	// objects referencing it in their metadata.ownerReferences, once none of their owners exist anymore.
+	// This lists all objects on every Delete to find the dependents. See also GarbageCollect.
+	CascadingDelete bool
+	// ConflictResolver is invoked when Update is called with an object whose resourceVersion differs from the
+	// current one, i.e. when the file was modified since the object was read. It merges the concurrent changes,
+	// or aborts the write with ErrConflict. This requires ManageGeneration, and a content-based Checksummer for
+	// the RawStorage to detect all modifications. RejectConflicts implements plain optimistic locking.
+	// (Default: nil, which means that the last write wins)
+	ConflictResolver ConflictResolver
+	// TracerProvider creates the OpenTelemetry spans of Get, List, Create, Update and Delete, and of the
+	// decoding and encoding of the objects. The spans are children of the context given to WithContext.
+	// (Default: nil, which means the global TracerProvider, see otel.GetTracerProvider)
+	TracerProvider trace.TracerProvider
+	// DecodeCacheSize specifies how many decoded objects to cache, if positive. Get and List return copies
+	// of the cached objects as long as the checksums of their files are unchanged, which skips reading and
+	// decoding them. The least recently used objects are evicted first. Modifications are detected by the
+	// Checksummer of the RawStorage, which must hence detect all modifications, see e.g. filesystem.SHA256Checksummer.
+	// (Default: 0, which disables the cache)
+	DecodeCacheSize int
+	// ManageRevision specifies whether to maintain a revision counter for every object in its RevisionAnnotation.
+	// Every write increments the revision of the stored object by one, starting at 1 for new objects. Unlike
+	// the resourceVersion, the revisions are ordered, see Revision and WithMinRevision. As every write changes
+	// the revision, it's never equivalent to the stored object for SkipEquivalentWrites.
+	ManageRevision bool
+	// Logger receives the logs of the GenericStorage, with the kind and objectID of the objects concerned
+	// as key/value pairs. (Default: nil, which logs to logs.Logger, see logs.NewLogger)
+	Logger logr.Logger
+}
+
+// DefaultOptions returns the default options
+func DefaultOptions() Options {
+	return Options{
+		PreserveFormatting:   false,
+		ManageGeneration:     false,
+		SkipEquivalentWrites: false,
+		ReadTransformers:     nil,
+		WriteValidators:      nil,
+		NamespaceSource:      NamespacesFromObjects,
+		Namespacer:           nil,
+		FinalizerSupport:     false,
+		CascadingDelete:      false,
+		ConflictResolver:     nil,
+		TracerProvider:       nil,
+		DecodeCacheSize:      0,
+		ManageRevision:       false,
+		Logger:               nil,
+	}
+}
+
 // NewGenericStorage constructs a new Storage
 func NewGenericStorage(rawStorage RawStorage, serializer serializer.Serializer, identifiers []runtime.IdentifierFactory) Storage {
-	return &GenericStorage{rawStorage, serializer, patchutil.NewPatcher(serializer), identifiers}
+	return NewGenericStorageWithOptions(rawStorage, serializer, identifiers, DefaultOptions())
+}
+
+// NewGenericStorageWithOptions constructs a new Storage with the given options
+func NewGenericStorageWithOptions(rawStorage RawStorage, serializer serializer.Serializer, identifiers []runtime.IdentifierFactory, opts Options) Storage {
+	return &GenericStorage{rawStorage, serializer, patchutil.NewPatcher(serializer), identifiers, opts, newBaseCache(), newDecodeCache(opts.DecodeCacheSize), logs.OrDefault(opts.Logger).WithName("GenericStorage"), NewObjectLocker(), nil}
 }
 
 // GenericStorage implements the Storage interface
@@ -117,6 +232,14 @@ type GenericStorage struct {
 	serializer  serializer.Serializer
 	patcher     patchutil.Patcher
 	identifiers []runtime.IdentifierFactory
+	opts        Options
+	bases       *baseCache
+	decoded     *decodeCache
+	logger      logr.Logger
+	// locks holds the advisory locks of the objects, see Lock
+	locks *ObjectLocker
+	// ctx is the context the operations are run in, see WithContext
+	ctx context.Context
 }
 
 var _ Storage = &GenericStorage{}
@@ -126,13 +249,38 @@ func (s *GenericStorage) Serializer() serializer.Serializer {
 }
 
 // Get returns a new Object for the resource at the specified kind/uid path, based on the file content
-func (s *GenericStorage) Get(key ObjectKey) (runtime.Object, error) {
+func (s *GenericStorage) Get(key ObjectKey) (_ runtime.Object, err error) {
+	s, span := s.startSpan("Get", objectAttributes(key)...)
+	defer func() { endSpan(span, err) }()
+
+	obj, err := s.get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.transform(obj)
+}
+
+// get returns the object for the given key as stored, without applying the ReadTransformers
+func (s *GenericStorage) get(key ObjectKey) (runtime.Object, error) {
+	obj, checksum, ok := s.getCached(key)
+	if ok {
+		return obj, nil
+	}
+
 	content, err := s.raw.Read(key)
 	if err != nil {
 		return nil, err
 	}
 
-	return s.decode(key, content)
+	obj, err = s.decode(key, content)
+	if err != nil {
+		return nil, err
+	}
+
+	s.trackBase(key, obj.GetResourceVersion(), content)
+	s.cacheDecoded(key, checksum, obj, content)
+	return obj, nil
 }
 
 // TODO: Verify this works
@@ -148,6 +296,10 @@ func (s *GenericStorage) GetMeta(key ObjectKey) (runtime.PartialObject, error) {
 
 // TODO: Make sure we don't save a partial object
 func (s *GenericStorage) write(key ObjectKey, obj runtime.Object) error {
+	if err := s.validate(key, obj); err != nil {
+		return err
+	}
+
 	// Set the content type based on the format given by the RawStorage, but default to JSON
 	contentType := serializer.ContentTypeJSON
 	if ct := s.raw.ContentType(key); len(ct) != 0 {
@@ -160,60 +312,210 @@ func (s *GenericStorage) write(key ObjectKey, obj runtime.Object) error {
 		obj.SetCreationTimestamp(metav1.Now())
 	}
 
+	// The resourceVersion is derived from the checksum of the file, don't store it
+	if s.opts.ManageGeneration {
+		obj.SetResourceVersion("")
+	}
+
+	if s.opts.ManageRevision {
+		if err := s.bumpRevision(key, obj); err != nil {
+			return err
+		}
+	}
+
+	// If asked to, apply the changes onto the existing file instead of re-encoding it from scratch
+	encoder := s.serializer.Encoder()
+	if s.opts.PreserveFormatting && contentType == serializer.ContentTypeYAML && s.raw.Exists(key) {
+		var err error
+		if obj, encoder, err = s.formattingEncoder(key, obj); err != nil {
+			return err
+		}
+	}
+
 	var objBytes bytes.Buffer
-	err := s.serializer.Encoder().Encode(serializer.NewFrameWriter(contentType, &objBytes), obj)
+	es, span := s.startSpan("encode", objectAttributes(key)...)
+	fw := serializer.NewFrameWriter(contentType, &objBytes, serializer.WithTracer(es.tracer()), serializer.WithTraceContext(es.context()))
+	err := encoder.Encode(fw, obj)
+	endSpan(span, err)
 	if err != nil {
 		return err
 	}
 
-	return s.raw.Write(key, objBytes.Bytes())
+	if s.opts.SkipEquivalentWrites && s.isEquivalent(key, objBytes.Bytes(), contentType) {
+		if l := s.logger.V(logs.TraceLevel); l.Enabled() {
+			l.Info("Skipping write, as the file is equivalent", "kind", key.GetKind(), "objectID", key.GetIdentifier())
+		}
+	} else if err := s.raw.Write(key, objBytes.Bytes()); err != nil {
+		return err
+	}
+
+	if s.opts.ManageGeneration {
+		if err := s.setResourceVersion(key, obj); err != nil {
+			return err
+		}
+		s.trackBase(key, obj.GetResourceVersion(), objBytes.Bytes())
+	}
+	return nil
 }
 
-func (s *GenericStorage) Create(obj runtime.Object) error {
+// formattingEncoder returns a copy of obj with the current content of the file for key as its
+// comment source, together with an Encoder that applies the changes of obj onto that content.
+func (s *GenericStorage) formattingEncoder(key ObjectKey, obj runtime.Object) (runtime.Object, serializer.Encoder, error) {
+	oldContent, err := s.raw.Read(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	source, err := yaml.Parse(string(oldContent))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Don't leak the comment source annotation to the caller's object
+	obj = obj.DeepCopyObject().(runtime.Object)
+	if err := serializer.SetCommentSource(obj, source); err != nil {
+		return nil, nil, err
+	}
+
+	return obj, s.serializer.Encoder(
+		serializer.WithCommentsEncode(true),
+		serializer.WithFieldOrderEncode(true),
+	), nil
+}
+
+func (s *GenericStorage) Create(obj runtime.Object) (err error) {
+	s, span := s.startSpan("Create")
+	defer func() { endSpan(span, err) }()
+
 	key, err := s.ObjectKeyFor(obj)
 	if err != nil {
 		return err
 	}
+	span.SetAttributes(objectAttributes(key)...)
 
 	if s.raw.Exists(key) {
 		return ErrAlreadyExists
 	}
 
+	if s.opts.ManageGeneration {
+		obj.SetGeneration(1)
+	}
+
 	// The object was not found so we can safely create it
 	return s.write(key, obj)
 }
 
-func (s *GenericStorage) Update(obj runtime.Object) error {
+func (s *GenericStorage) Update(obj runtime.Object) (err error) {
+	s, span := s.startSpan("Update")
+	defer func() { endSpan(span, err) }()
+
 	key, err := s.ObjectKeyFor(obj)
 	if err != nil {
 		return err
 	}
+	span.SetAttributes(objectAttributes(key)...)
 
 	if !s.raw.Exists(key) {
 		return ErrNotFound
 	}
 
 	// The object was found so we can safely update it
+	return s.update(key, obj)
+}
+
+func (s *GenericStorage) update(key ObjectKey, obj runtime.Object) error {
+	if err := s.resolveConflict(key, obj); err != nil {
+		return err
+	}
+
+	if s.opts.FinalizerSupport {
+		if removed, err := s.finalize(key, obj); err != nil || removed {
+			return err
+		}
+	}
+
+	if s.opts.ManageGeneration {
+		if err := s.bumpGeneration(key, obj); err != nil {
+			return err
+		}
+	}
+
 	return s.write(key, obj)
 }
 
 // Patch performs a strategic merge patch on the object with the given UID, using the byte-encoded patch given
 func (s *GenericStorage) Patch(key ObjectKey, patch []byte) error {
+	return s.PatchWithType(key, types.StrategicMergePatchType, patch)
+}
+
+// PatchWithType performs a patch of the given type on the object with the given UID, using the byte-encoded patch given
+func (s *GenericStorage) PatchWithType(key ObjectKey, patchType types.PatchType, patch []byte) error {
 	oldContent, err := s.raw.Read(key)
 	if err != nil {
 		return err
 	}
 
-	newContent, err := s.patcher.Apply(oldContent, patch, key.GetGVK())
+	// The patcher validates the result by decoding it into the registered type
+	var newContent []byte
+	switch patchType {
+	case types.StrategicMergePatchType:
+		newContent, err = s.patcher.Apply(oldContent, patch, key.GetGVK())
+	case types.JSONPatchType:
+		newContent, err = s.patcher.ApplyJSONPatch(oldContent, patch, key.GetGVK())
+	default:
+		return fmt.Errorf("%w: %q", ErrUnsupportedPatchType, patchType)
+	}
 	if err != nil {
 		return err
 	}
 
+	// The generation, revision and finalizers depend on the decoded object, so write it like in Update
+	if s.opts.ManageGeneration || s.opts.ManageRevision || s.opts.FinalizerSupport {
+		obj, err := s.decode(key, newContent)
+		if err != nil {
+			return err
+		}
+
+		return s.update(key, obj)
+	}
+
+	// Only decode the patched object if there are validators for it
+	if len(s.opts.WriteValidators[key.GetGVK().GroupKind()]) != 0 {
+		obj, err := s.decode(key, newContent)
+		if err != nil {
+			return err
+		}
+
+		if err := s.validate(key, obj); err != nil {
+			return err
+		}
+	}
+
 	return s.raw.Write(key, newContent)
 }
 
-// Delete removes an Object from the storage
-func (s *GenericStorage) Delete(key ObjectKey) error {
+// Delete removes an Object from the storage. If Options.FinalizerSupport is set, objects
+// with finalizers are only marked as being deleted, see softDelete. If Options.CascadingDelete
+// is set, the dependents of the object are deleted as well, see cascadingDelete.
+func (s *GenericStorage) Delete(key ObjectKey) (err error) {
+	s, span := s.startSpan("Delete", objectAttributes(key)...)
+	defer func() { endSpan(span, err) }()
+
+	if s.opts.CascadingDelete {
+		return s.cascadingDelete(key)
+	}
+
+	return s.delete(key)
+}
+
+// delete removes the object with the given key, without deleting its dependents
+func (s *GenericStorage) delete(key ObjectKey) error {
+	s.bases.forget(key)
+	s.decoded.forget(key)
+	if s.opts.FinalizerSupport {
+		return s.softDelete(key)
+	}
+
 	return s.raw.Delete(key)
 }
 
@@ -222,38 +524,18 @@ func (s *GenericStorage) Checksum(key ObjectKey) (string, error) {
 	return s.raw.Checksum(key)
 }
 
-func (s *GenericStorage) list(kind KindKey) (result []runtime.Object, walkerr error) {
-	walkerr = s.walkKind(kind, func(key ObjectKey, content []byte) error {
-		obj, err := s.decode(key, content)
-		if err != nil {
-			return err
-		}
-
-		result = append(result, obj)
-		return nil
-	})
-	return
-}
-
 // List lists Objects for the specific kind. Optionally, filters can be applied (see the filter package
 // for more information, e.g. filter.NameFilter{} and filter.UIDFilter{})
 func (s *GenericStorage) List(kind KindKey, opts ...filter.ListOption) ([]runtime.Object, error) {
-	// First, complete the options struct
-	o, err := filter.MakeListOptions(opts...)
-	if err != nil {
-		return nil, err
-	}
-
-	// Do an internal list to get all objects
-	objs, err := s.list(kind)
-	if err != nil {
-		return nil, err
-	}
+	objs, _, err := s.ListPage(kind, opts...)
+	return objs, err
+}
 
-	// For all list filters, pipe the output of the previous as the input to the next, in order.
-	for _, filter := range o.Filters {
-		objs, err = filter.Filter(objs...)
-		if err != nil {
+// applyFilters pipes the output of the previous list filter as the input to the next, in order
+func applyFilters(filters []filter.ListFilter, objs ...runtime.Object) ([]runtime.Object, error) {
+	for _, f := range filters {
+		var err error
+		if objs, err = f.Filter(objs...); err != nil {
 			return nil, err
 		}
 	}
@@ -298,12 +580,6 @@ func (s *GenericStorage) ListMeta(kind KindKey) (result []runtime.PartialObject,
 	return
 }
 
-// Count counts the Objects for the specific kind
-func (s *GenericStorage) Count(kind KindKey) (uint64, error) {
-	entries, err := s.raw.List(kind)
-	return uint64(len(entries)), err
-}
-
 func (s *GenericStorage) ObjectKeyFor(obj runtime.Object) (ObjectKey, error) {
 	var gvk schema.GroupVersionKind
 	var err error
@@ -348,18 +624,32 @@ func (s *GenericStorage) identify(obj runtime.Object) runtime.Identifyable {
 	return nil
 }
 
-func (s *GenericStorage) decode(key ObjectKey, content []byte) (runtime.Object, error) {
+func (s *GenericStorage) decode(key ObjectKey, content []byte) (_ runtime.Object, err error) {
+	s, span := s.startSpan("decode", objectAttributes(key)...)
+	defer func() { endSpan(span, err) }()
+
 	gvk := key.GetGVK()
 	// Decode the bytes to the internal version of the Object, if desired
 	isInternal := gvk.Version == kruntime.APIVersionInternal
 
-	// Decode the bytes into an Object
-	ct := s.raw.ContentType(key)
-	logrus.Infof("Decoding with content type %s", ct)
-	obj, err := s.serializer.Decoder(
-		serializer.WithConvertToHubDecode(isInternal),
-	).Decode(serializer.NewFrameReader(ct, serializer.FromBytes(content)))
-	if err != nil {
+	// Decode the bytes into an Object, trying all candidate content types in order
+	var obj kruntime.Object
+	err = fmt.Errorf("no content type known for %s: %w", key, serializer.ErrUnsupportedContentType)
+	for i, ct := range ContentTypeCandidates(s.raw, key) {
+		if l := s.logger.V(logs.DebugLevel); l.Enabled() {
+			l.Info("Decoding object", "kind", key.GetKind(), "objectID", key.GetIdentifier(), "contentType", ct)
+		}
+		decoded, decodeErr := s.decodeAs(content, ct, gvk, isInternal)
+		if decodeErr == nil {
+			obj = decoded
+			break
+		}
+		// Report the error of the preferred content type
+		if i == 0 {
+			err = decodeErr
+		}
+	}
+	if obj == nil {
 		return nil, err
 	}
 
@@ -371,9 +661,58 @@ func (s *GenericStorage) decode(key ObjectKey, content []byte) (runtime.Object,
 
 	// Set the desired gvk of this Object from the caller
 	metaObj.GetObjectKind().SetGroupVersionKind(gvk)
+
+	if s.opts.ManageGeneration {
+		if err := s.setResourceVersion(key, metaObj); err != nil {
+			return nil, err
+		}
+	}
 	return metaObj, nil
 }
 
+// decodeAs decodes the given content of the given ContentType, see decode
+func (s *GenericStorage) decodeAs(content []byte, ct serializer.ContentType, gvk schema.GroupVersionKind, isInternal bool) (kruntime.Object, error) {
+	// If the content doesn't specify its apiVersion and kind (e.g. because its type is
+	// implied by its location), use the GroupVersionKind of the key
+	if !isInternal {
+		var err error
+		if content, err = withTypeMeta(content, ct, gvk); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.serializer.Decoder(
+		serializer.WithConvertToHubDecode(isInternal),
+	).Decode(serializer.NewFrameReader(ct, serializer.FromBytes(content)))
+}
+
+// withTypeMeta sets the apiVersion and kind of the given content to the given GroupVersionKind,
+// if the content doesn't specify them. Otherwise, the content is returned as-is.
+func withTypeMeta(content []byte, ct serializer.ContentType, gvk schema.GroupVersionKind) ([]byte, error) {
+	partObj, err := runtime.NewPartialObject(content)
+	if err != nil || !partObj.GetObjectKind().GroupVersionKind().Empty() {
+		return content, nil
+	}
+
+	node, err := yaml.Parse(string(content))
+	if err != nil || node.YNode().Kind != yaml.MappingNode {
+		return content, nil
+	}
+
+	// Prepend the TypeMeta fields, just like they'd be written by the serializer
+	apiVersion, kind := gvk.ToAPIVersionAndKind()
+	node.YNode().Content = append([]*yaml.Node{
+		{Kind: yaml.ScalarNode, Value: "apiVersion"}, {Kind: yaml.ScalarNode, Value: apiVersion},
+		{Kind: yaml.ScalarNode, Value: "kind"}, {Kind: yaml.ScalarNode, Value: kind},
+	}, node.YNode().Content...)
+
+	if ct == serializer.ContentTypeJSON {
+		return node.MarshalJSON()
+	}
+	str, err := node.String()
+	return []byte(str), err
+}
+
 func (s *GenericStorage) decodeMeta(key ObjectKey, content []byte) (runtime.PartialObject, error) {
 	gvk := key.GetGVK()
 	partobjs, err := DecodePartialObjects(serializer.FromBytes(content), s.serializer.Scheme(), false, &gvk)
@@ -381,6 +720,11 @@ func (s *GenericStorage) decodeMeta(key ObjectKey, content []byte) (runtime.Part
 		return nil, err
 	}
 
+	if s.opts.ManageGeneration {
+		if err := s.setResourceVersion(key, partobjs[0]); err != nil {
+			return nil, err
+		}
+	}
 	return partobjs[0], nil
 }
 
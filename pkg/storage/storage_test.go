@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/weaveworks/libgitops/pkg/logs"
+)
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&buf)
+	logger.SetLevel(logrus.DebugLevel)
+
+	s, _, _ := newTestStorage(t, func(_ *RawStorageOptions, opts *Options) {
+		opts.Logger = logs.NewLogger(logger)
+	})
+	car := &v1alpha1.Car{}
+	car.SetName("foo")
+	car.SetNamespace("default")
+	if err := s.Create(car); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(carKey); err != nil {
+		t.Fatal(err)
+	}
+
+	// The decoding is logged at the debug level to the given Logger, with the key of the object
+	output := buf.String()
+	for _, expected := range []string{"GenericStorage: Decoding object", "kind=Car", "objectID=default/foo", "contentType=application/yaml"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("expected the logs to contain %q, got %q", expected, output)
+		}
+	}
+
+	buf.Reset()
+	logger.SetLevel(logrus.InfoLevel)
+	if _, err := s.Get(carKey); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("expected nothing to be logged at the info level, got %q", buf.String())
+	}
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the name of the OpenTelemetry tracer creating the spans of the GenericStorage
+const tracerName = "github.com/weaveworks/libgitops/pkg/storage"
+
+const (
+	// groupKindAttribute holds the GroupKind of the objects of a span, e.g. "Car.sample-app.weave.works"
+	groupKindAttribute = attribute.Key("libgitops.group_kind")
+	// objectKeyAttribute holds the identifier of the object of a span, e.g. "default/foo"
+	objectKeyAttribute = attribute.Key("libgitops.object_key")
+)
+
+// ContextStorage is implemented by Storages which can be bound to a context, e.g. the one of
+// an incoming HTTP request. As the Storage methods don't take a context themselves, this is
+// how e.g. the tracing spans of a request are propagated into the storage.
+type ContextStorage interface {
+	Storage
+
+	// WithContext returns a Storage for the same objects, whose operations are run in ctx
+	WithContext(ctx context.Context) Storage
+}
+
+// WithContext returns s bound to ctx if it implements ContextStorage, otherwise s itself
+func WithContext(s Storage, ctx context.Context) Storage {
+	if cs, ok := s.(ContextStorage); ok {
+		return cs.WithContext(ctx)
+	}
+	return s
+}
+
+var _ ContextStorage = &GenericStorage{}
+
+// WithContext implements ContextStorage. The spans of the returned Storage are children of the
+// span in ctx, and ctx is passed on to the ReadTransformers.
+func (s *GenericStorage) WithContext(ctx context.Context) Storage {
+	c := *s
+	c.ctx = ctx
+	return &c
+}
+
+// context returns the context the GenericStorage is bound to, see WithContext
+func (s *GenericStorage) context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+// startSpan starts the span of the given operation as a child of the context of the GenericStorage.
+// The returned GenericStorage is bound to the context of the span, so that the spans of nested
+// operations, e.g. decoding, are its children. The span must be ended using endSpan.
+func (s *GenericStorage) startSpan(operation string, attrs ...attribute.KeyValue) (*GenericStorage, trace.Span) {
+	ctx, span := s.tracer().Start(s.context(), "GenericStorage."+operation, trace.WithAttributes(attrs...))
+	c := *s
+	c.ctx = ctx
+	return &c, span
+}
+
+// tracer returns the tracer of Options.TracerProvider, or of the global TracerProvider if unset
+func (s *GenericStorage) tracer() trace.Tracer {
+	tp := s.opts.TracerProvider
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return tp.Tracer(tracerName)
+}
+
+// endSpan ends the given span, and records err in it if set
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// kindAttributes returns the span attributes for the given kind
+func kindAttributes(kind KindKey) []attribute.KeyValue {
+	return []attribute.KeyValue{groupKindAttribute.String(kind.GetGVK().GroupKind().String())}
+}
+
+// objectAttributes returns the span attributes for the object with the given key
+func objectAttributes(key ObjectKey) []attribute.KeyValue {
+	return append(kindAttributes(key), objectKeyAttribute.String(key.GetIdentifier()))
+}
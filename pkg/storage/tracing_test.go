@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// memoryExporter keeps the exported spans in memory
+type memoryExporter struct {
+	spans []sdktrace.ReadOnlySpan
+	mux   sync.Mutex
+}
+
+func (e *memoryExporter) ExportSpans(_ context.Context, spans []sdktrace.ReadOnlySpan) error {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *memoryExporter) Shutdown(context.Context) error { return nil }
+
+// reset returns the exported spans, and forgets them
+func (e *memoryExporter) reset() []sdktrace.ReadOnlySpan {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	spans := e.spans
+	e.spans = nil
+	return spans
+}
+
+func attributeValue(span sdktrace.ReadOnlySpan, key attribute.Key) string {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value.AsString()
+		}
+	}
+	return ""
+}
+
+func TestTracing(t *testing.T) {
+	exporter := &memoryExporter{}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer func() { _ = tp.Shutdown(context.Background()) }()
+
+	s, _, _ := newTestStorage(t, func(_ *RawStorageOptions, opts *Options) {
+		opts.TracerProvider = tp
+	})
+
+	// The spans of the storage are children of the span of the caller
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "request")
+	defer parent.End()
+	s = WithContext(s, ctx)
+
+	car := &v1alpha1.Car{Spec: v1alpha1.CarSpec{Brand: "Volvo"}}
+	car.SetName("foo")
+	car.SetNamespace("default")
+	key, err := s.ObjectKeyFor(car)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gk := key.GetGVK().GroupKind().String()
+
+	tests := []struct {
+		name     string
+		fn       func() error
+		expected []string
+	}{
+		{"Create", func() error { return s.Create(car) }, []string{"FrameWriter.Write", "GenericStorage.encode", "GenericStorage.Create"}},
+		{"Get", func() error { _, err := s.Get(key); return err }, []string{"GenericStorage.decode", "GenericStorage.Get"}},
+		{"List", func() error { _, err := s.List(key); return err }, []string{"GenericStorage.decode", "GenericStorage.List"}},
+		{"Update", func() error { return s.Update(car) }, []string{"FrameWriter.Write", "GenericStorage.encode", "GenericStorage.Update"}},
+		{"Delete", func() error { return s.Delete(key) }, []string{"GenericStorage.Delete"}},
+	}
+	for _, rt := range tests {
+		t.Run(rt.name, func(t *testing.T) {
+			if err := rt.fn(); err != nil {
+				t.Fatal(err)
+			}
+
+			// The spans are exported when they end, i.e. every nested span before its parent
+			spans := exporter.reset()
+			if len(spans) != len(rt.expected) {
+				t.Fatalf("expected spans %v, got %d spans", rt.expected, len(spans))
+			}
+			op := spans[len(spans)-1]
+			for i, span := range spans {
+				if span.Name() != rt.expected[i] {
+					t.Errorf("expected span %q, got %q", rt.expected[i], span.Name())
+				}
+				if span.Status().Code != codes.Unset {
+					t.Errorf("%s: expected no error, got %v", span.Name(), span.Status())
+				}
+				if span == op {
+					continue
+				}
+				if span.Parent().SpanID() != spans[i+1].SpanContext().SpanID() {
+					t.Errorf("expected %s to be a child of %s", span.Name(), spans[i+1].Name())
+				}
+			}
+
+			for _, span := range spans {
+				// The frames of the serializer don't know about the objects
+				if span.Name() == "FrameWriter.Write" {
+					continue
+				}
+				if attributeValue(span, groupKindAttribute) != gk {
+					t.Errorf("%s: expected the GroupKind %q, got %q", span.Name(), gk, attributeValue(span, groupKindAttribute))
+				}
+			}
+
+			if op.Parent().SpanID() != parent.SpanContext().SpanID() {
+				t.Errorf("expected %s to be a child of the span of the context", op.Name())
+			}
+			if rt.name != "List" && attributeValue(op, objectKeyAttribute) != "default/foo" {
+				t.Errorf("expected the object key %q, got %q", "default/foo", attributeValue(op, objectKeyAttribute))
+			}
+		})
+	}
+
+	// Errors are recorded in the spans
+	if _, err := s.Get(key); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected %v, got %v", ErrNotFound, err)
+	}
+	spans := exporter.reset()
+	if len(spans) != 1 || spans[0].Status().Code != codes.Error || len(spans[0].Events()) != 1 {
+		t.Errorf("expected the Get span to record the error, got %v", spans)
+	}
+
+	// Without WithContext, the spans are roots of new traces
+	if _, err := s.(ContextStorage).WithContext(context.Background()).List(key); err != nil {
+		t.Fatal(err)
+	}
+	spans = exporter.reset()
+	if len(spans) != 1 || spans[0].Parent().IsValid() {
+		t.Errorf("expected one root span, got %v", spans)
+	}
+}
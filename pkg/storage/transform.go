@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/weaveworks/libgitops/pkg/runtime"
+)
+
+// ReadTransformer transforms objects read from a Storage, before they're returned to the caller.
+// This can be used to e.g. enforce common labels for all objects at the storage layer.
+type ReadTransformer interface {
+	// Transform returns the transformed object. It may modify and return obj directly,
+	// as obj is always freshly decoded.
+	Transform(ctx context.Context, obj runtime.Object) (runtime.Object, error)
+}
+
+// ReadTransformerFunc implements ReadTransformer using a function
+type ReadTransformerFunc func(ctx context.Context, obj runtime.Object) (runtime.Object, error)
+
+var _ ReadTransformer = ReadTransformerFunc(nil)
+
+// Transform implements ReadTransformer
+func (f ReadTransformerFunc) Transform(ctx context.Context, obj runtime.Object) (runtime.Object, error) {
+	return f(ctx, obj)
+}
+
+// CommonLabelsTransformer returns a ReadTransformer setting the given labels on every object,
+// overwriting any existing labels with the same keys
+func CommonLabelsTransformer(labels map[string]string) ReadTransformer {
+	return ReadTransformerFunc(func(_ context.Context, obj runtime.Object) (runtime.Object, error) {
+		objLabels := obj.GetLabels()
+		if objLabels == nil {
+			objLabels = make(map[string]string, len(labels))
+		}
+
+		for k, v := range labels {
+			objLabels[k] = v
+		}
+
+		obj.SetLabels(objLabels)
+		return obj, nil
+	})
+}
+
+// transform applies the ReadTransformers to the given object, in order
+func (s *GenericStorage) transform(obj runtime.Object) (runtime.Object, error) {
+	// The Storage methods don't take a context, it's given to WithContext instead
+	ctx := s.context()
+
+	for _, t := range s.opts.ReadTransformers {
+		var err error
+		if obj, err = t.Transform(ctx, obj); err != nil {
+			return nil, err
+		}
+	}
+	return obj, nil
+}
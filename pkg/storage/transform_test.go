@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+)
+
+func TestReadTransformers(t *testing.T) {
+	s, fs, dir := newTestStorage(t, func(_ *RawStorageOptions, opts *Options) {
+		opts.ReadTransformers = []ReadTransformer{
+			CommonLabelsTransformer(map[string]string{"org": "acme"}),
+			// Transformers run in order, so this one sees the label set by the previous one
+			ReadTransformerFunc(func(_ context.Context, obj runtime.Object) (runtime.Object, error) {
+				obj.SetAnnotations(map[string]string{"org": obj.GetLabels()["org"]})
+				return obj, nil
+			}),
+		}
+	})
+
+	car := &v1alpha1.Car{Spec: v1alpha1.CarSpec{Brand: "Volvo"}}
+	car.SetName("foo")
+	car.SetNamespace("default")
+	if err := s.Create(car); err != nil {
+		t.Fatal(err)
+	}
+	key, err := s.ObjectKeyFor(car)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := func(method string, obj runtime.Object) {
+		if obj.GetLabels()["org"] != "acme" || obj.GetAnnotations()["org"] != "acme" {
+			t.Errorf("%s: expected the object to be transformed, got labels %v and annotations %v", method, obj.GetLabels(), obj.GetAnnotations())
+		}
+	}
+
+	obj, err := s.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect("Get", obj)
+
+	objs, err := s.List(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objs) != 1 {
+		t.Fatalf("expected one object, got %d", len(objs))
+	}
+	expect("List", objs[0])
+
+	// The file itself is untouched
+	content, err := fs.ReadFile(filepath.Join(dir, "car_default_foo.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), "acme") {
+		t.Errorf("expected the file not to be transformed, got %q", content)
+	}
+}
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// ErrInvalid is returned (wrapped in a *ValidationError) when a WriteValidator rejects an object
+var ErrInvalid = errors.New("invalid object")
+
+// WriteValidator validates objects before they're written to a Storage. This gives
+// admission-like guarantees for the objects stored, similar to validating webhooks.
+type WriteValidator interface {
+	// Validate returns the field-level errors of the given object, if any
+	Validate(obj runtime.Object) field.ErrorList
+}
+
+// WriteValidatorFunc implements WriteValidator using a function
+type WriteValidatorFunc func(obj runtime.Object) field.ErrorList
+
+var _ WriteValidator = WriteValidatorFunc(nil)
+
+// Validate implements WriteValidator
+func (f WriteValidatorFunc) Validate(obj runtime.Object) field.ErrorList {
+	return f(obj)
+}
+
+// ValidationError is returned when a write was aborted, as the WriteValidators rejected the object
+type ValidationError struct {
+	// Key is the key of the rejected object
+	Key ObjectKey
+	// Errors are the aggregated errors of all WriteValidators for the object
+	Errors field.ErrorList
+}
+
+var _ error = &ValidationError{}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s is invalid: %v", e.Key, e.Errors.ToAggregate())
+}
+
+// Unwrap makes errors.Is(err, ErrInvalid) return true for a *ValidationError
+func (e *ValidationError) Unwrap() error {
+	return ErrInvalid
+}
+
+// ObjectValidator is implemented by Storages which validate objects before writing them.
+// This allows e.g. validating objects that are loaded into a Storage without writing them.
+type ObjectValidator interface {
+	// Validate returns a *ValidationError if the given object wouldn't be accepted by a write
+	Validate(obj runtime.Object) error
+}
+
+var _ ObjectValidator = &GenericStorage{}
+
+// Validate runs the WriteValidators registered for the GroupKind of obj on it
+func (s *GenericStorage) Validate(obj runtime.Object) error {
+	key, err := s.ObjectKeyFor(obj)
+	if err != nil {
+		return err
+	}
+
+	return s.validate(key, obj)
+}
+
+// validate runs the WriteValidators registered for the GroupKind of the given key on obj
+func (s *GenericStorage) validate(key ObjectKey, obj runtime.Object) error {
+	var errs field.ErrorList
+	for _, v := range s.opts.WriteValidators[key.GetGVK().GroupKind()] {
+		errs = append(errs, v.Validate(obj)...)
+	}
+
+	if len(errs) != 0 {
+		return &ValidationError{key, errs}
+	}
+	return nil
+}
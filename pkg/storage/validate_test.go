@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/util/filesystem"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func TestWriteValidators(t *testing.T) {
+	s, fs, dir := newTestStorage(t, func(rawOpts *RawStorageOptions, opts *Options) {
+		// Patches are applied to JSON
+		rawOpts.FileLayout = FileLayoutFunc(func(key ObjectKey) string {
+			return key.GetIdentifier() + ".json"
+		})
+		opts.WriteValidators = map[schema.GroupKind][]WriteValidator{
+			carGVK.GroupKind(): {WriteValidatorFunc(func(obj runtime.Object) field.ErrorList {
+				if obj.(*v1alpha1.Car).Spec.Brand == "" {
+					return field.ErrorList{field.Required(field.NewPath("spec", "brand"), "a car must have a brand")}
+				}
+				return nil
+			})},
+		}
+	})
+
+	car := &v1alpha1.Car{}
+	car.SetName("foo")
+	car.SetNamespace("default")
+	path := filepath.Join(dir, "default", "foo.json")
+
+	expectInvalid := func(step string, err error) {
+		var validationErr *ValidationError
+		if !errors.Is(err, ErrInvalid) || !errors.As(err, &validationErr) {
+			t.Fatalf("%s: expected a ValidationError, got %v", step, err)
+		}
+		if len(validationErr.Errors) != 1 || validationErr.Errors[0].Field != "spec.brand" {
+			t.Errorf("%s: expected an error for spec.brand, got %v", step, validationErr.Errors)
+		}
+	}
+
+	expectInvalid("create", s.Create(car))
+	if filesystem.FileExists(fs, path) {
+		t.Errorf("expected no file to be written for the invalid car")
+	}
+
+	car.Spec.Brand = "Volvo"
+	if err := s.Create(car); err != nil {
+		t.Fatal(err)
+	}
+	content, err := fs.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	car.Spec.Brand = ""
+	expectInvalid("update", s.Update(car))
+
+	key, err := s.ObjectKeyFor(car)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectInvalid("patch", s.Patch(key, []byte(`{"spec":{"brand":""}}`)))
+
+	if newContent, err := fs.ReadFile(path); err != nil || string(newContent) != string(content) {
+		t.Errorf("expected the file to be unchanged, got %q (%v)", newContent, err)
+	}
+}
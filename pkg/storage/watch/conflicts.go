@@ -0,0 +1,212 @@
+package watch
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/weaveworks/libgitops/pkg/storage"
+)
+
+// pathTracker keeps track of all files declaring each object. If multiple files
+// declare the same object, the lexicographically first path wins deterministically,
+// and the others are reported as conflicts instead of silently being dropped.
+type pathTracker struct {
+	// paths holds the sorted paths of all files declaring an object
+	paths map[storage.ObjectKey][]string
+	// keys holds the key of the object declared in each file
+	keys map[string]storage.ObjectKey
+	// checksums holds the checksum of the content of each file
+	checksums map[string]string
+	// contents holds the content of each file, if it's recorded using setContent
+	contents map[string][]byte
+	mux      sync.Mutex
+}
+
+func newPathTracker() *pathTracker {
+	return &pathTracker{
+		paths:     make(map[storage.ObjectKey][]string),
+		keys:      make(map[string]storage.ObjectKey),
+		checksums: make(map[string]string),
+		contents:  make(map[string][]byte),
+	}
+}
+
+// add tracks that the file at path with the given content checksum declares the object
+// with the given key. If the file declared another object earlier, that declaration is
+// removed. winner is the path that should be mapped for key, and created is true if the
+// object wasn't declared before.
+func (t *pathTracker) add(key storage.ObjectKey, path, checksum string) (winner string, created bool) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	t.checksums[path] = checksum
+	if oldKey, ok := t.keys[path]; ok {
+		if oldKey == key {
+			return t.paths[key][0], false
+		}
+		t.removePath(oldKey, path)
+	}
+
+	paths := t.paths[key]
+	created = len(paths) == 0
+
+	paths = append(paths, path)
+	sort.Strings(paths)
+	t.paths[key] = paths
+	t.keys[path] = key
+
+	return paths[0], created
+}
+
+// remove stops tracking the file at path. key is the object the file declared, and
+// winner is the path that should now be mapped for key, or empty if no other file
+// declares the object. ok is false if the path wasn't tracked.
+func (t *pathTracker) remove(path string) (key storage.ObjectKey, winner string, ok bool) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	if key, ok = t.keys[path]; !ok {
+		return
+	}
+
+	t.removePath(key, path)
+	if paths := t.paths[key]; len(paths) != 0 {
+		winner = paths[0]
+	}
+	return
+}
+
+// move tracks that the file at oldPath, declaring the object with the given key, has been
+// moved to newPath, where its content has the given checksum. winner is the path that should
+// now be mapped for key, and changed is true if the content of the mapped file changed.
+func (t *pathTracker) move(key storage.ObjectKey, oldPath, newPath, checksum string) (winner string, changed bool) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	var oldChecksum string
+	if paths := t.paths[key]; len(paths) != 0 {
+		oldChecksum = t.checksums[paths[0]]
+	}
+
+	t.removePath(key, oldPath)
+	if _, ok := t.keys[newPath]; !ok {
+		paths := append(t.paths[key], newPath)
+		sort.Strings(paths)
+		t.paths[key] = paths
+		t.keys[newPath] = key
+	}
+	t.checksums[newPath] = checksum
+
+	winner = t.paths[key][0]
+	return winner, t.checksums[winner] != oldChecksum
+}
+
+// removePath removes path from the declarations of key. The caller must hold the lock.
+func (t *pathTracker) removePath(key storage.ObjectKey, path string) {
+	delete(t.keys, path)
+	delete(t.checksums, path)
+	delete(t.contents, path)
+
+	paths := t.paths[key]
+	for i := range paths {
+		if paths[i] == path {
+			paths = append(paths[:i], paths[i+1:]...)
+			break
+		}
+	}
+
+	if len(paths) == 0 {
+		delete(t.paths, key)
+	} else {
+		t.paths[key] = paths
+	}
+}
+
+// setContent records the content of the file at path, until the path is no longer tracked
+func (t *pathTracker) setContent(path string, content []byte) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	t.contents[path] = content
+}
+
+// forgetContent removes the recorded content of the file at path, if the path isn't tracked
+func (t *pathTracker) forgetContent(path string) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	if _, ok := t.keys[path]; !ok {
+		delete(t.contents, path)
+	}
+}
+
+// state returns the checksum and recorded content of the file at path.
+// ok is false if the path isn't tracked.
+func (t *pathTracker) state(path string) (checksum string, content []byte, ok bool) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	if _, ok = t.keys[path]; !ok {
+		return
+	}
+	return t.checksums[path], t.contents[path], true
+}
+
+// keyFor returns the key of the object declared by the file at path
+func (t *pathTracker) keyFor(path string) (storage.ObjectKey, bool) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	key, ok := t.keys[path]
+	return key, ok
+}
+
+// trackedPaths returns the paths of all tracked files
+func (t *pathTracker) trackedPaths() []string {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	paths := make([]string, 0, len(t.keys))
+	for path := range t.keys {
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// trackedKeys returns the keys of all objects declared by tracked files
+func (t *pathTracker) trackedKeys() []storage.ObjectKey {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	keys := make([]storage.ObjectKey, 0, len(t.paths))
+	for key := range t.paths {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// winner returns the path currently mapped for key
+func (t *pathTracker) winner(key storage.ObjectKey) (string, bool) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	if paths := t.paths[key]; len(paths) != 0 {
+		return paths[0], true
+	}
+	return "", false
+}
+
+// conflicts returns all objects declared by more than one file, together with the
+// sorted paths of those files. The first path is the one in use.
+func (t *pathTracker) conflicts() map[storage.ObjectKey][]string {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	conflicts := map[storage.ObjectKey][]string{}
+	for key, paths := range t.paths {
+		if len(paths) > 1 {
+			conflicts[key] = append([]string{}, paths...)
+		}
+	}
+	return conflicts
+}
@@ -0,0 +1,71 @@
+package watch
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/weaveworks/libgitops/pkg/storage/watch/update"
+)
+
+// ErrResumeTooOld is returned when resuming from a sequence number whose following events
+// aren't kept anymore (see Options.EventLogSize), or from a sequence number that hasn't been
+// assigned yet, e.g. as the GenericWatchStorage has been restarted. The consumer needs to
+// resync its state, e.g. by listing the objects, and watch without resuming.
+var ErrResumeTooOld = errors.New("the events to resume from are too old, resync")
+
+// eventLog assigns the sequence numbers of the sent events, and keeps the latest of them
+// in a ring buffer for replaying them to resuming subscriptions, see WithResumeFrom
+type eventLog struct {
+	// events holds the event with sequence number n at index (n-1) % len(events)
+	events []update.Update
+	// last is the sequence number of the last event
+	last uint64
+	// mux guards events and last. It must be held while assigning the sequence number of an
+	// event and choosing the subscriptions to send it to, so that the subscriptions added in the
+	// meantime, e.g. resuming ones, get every event exactly once. It's not held while sending.
+	mux sync.Mutex
+	// send must be held while sending an event, so that the events are sent in the
+	// order of their sequence numbers. Subscribing doesn't take it, so consumers can
+	// subscribe without waiting for slow consumers, e.g. from their event handlers.
+	send sync.Mutex
+}
+
+func newEventLog(size int) *eventLog {
+	if size < 0 {
+		size = 0
+	}
+	return &eventLog{events: make([]update.Update, size)}
+}
+
+// append assigns the next sequence number to upd, and keeps it. The mux must be held.
+func (l *eventLog) append(upd *update.Update) {
+	l.last++
+	upd.Sequence = l.last
+	if len(l.events) != 0 {
+		l.events[(l.last-1)%uint64(len(l.events))] = *upd
+	}
+}
+
+// since returns the kept events after the one with the given sequence number, or an error wrapping
+// ErrResumeTooOld if some of them have already been evicted, or if the sequence number is unknown.
+// The mux must be held.
+func (l *eventLog) since(sequence uint64) ([]update.Update, error) {
+	if sequence > l.last {
+		return nil, fmt.Errorf("can't resume from %d, the last event is %d: %w", sequence, l.last, ErrResumeTooOld)
+	}
+
+	oldest := uint64(1)
+	if size := uint64(len(l.events)); l.last > size {
+		oldest = l.last - size + 1
+	}
+	if sequence+1 < oldest {
+		return nil, fmt.Errorf("can't resume from %d, the oldest kept event is %d: %w", sequence, oldest, ErrResumeTooOld)
+	}
+
+	var events []update.Update
+	for n := sequence + 1; n <= l.last; n++ {
+		events = append(events, l.events[(n-1)%uint64(len(l.events))])
+	}
+	return events, nil
+}
@@ -0,0 +1,138 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/storage/watch/update"
+)
+
+func TestEventLog(t *testing.T) {
+	l := newEventLog(3)
+	for i := 0; i < 5; i++ {
+		upd := update.Update{}
+		l.append(&upd)
+		if upd.Sequence != uint64(i+1) {
+			t.Errorf("expected sequence %d, got %d", i+1, upd.Sequence)
+		}
+	}
+
+	for from, expected := range map[uint64]int{2: 3, 3: 2, 5: 0} {
+		events, err := l.since(from)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != expected {
+			t.Errorf("expected %d events after %d, got %d", expected, from, len(events))
+		}
+		for i, upd := range events {
+			if upd.Sequence != from+uint64(i)+1 {
+				t.Errorf("expected sequence %d, got %d", from+uint64(i)+1, upd.Sequence)
+			}
+		}
+	}
+	if _, err := l.since(1); !errors.Is(err, ErrResumeTooOld) {
+		t.Errorf("expected ErrResumeTooOld, got %v", err)
+	}
+	// Sequence numbers not assigned yet, e.g. after a restart, are unknown
+	if _, err := l.since(6); !errors.Is(err, ErrResumeTooOld) {
+		t.Errorf("expected ErrResumeTooOld, got %v", err)
+	}
+
+	// Without a size, only resuming from the last event is possible
+	l = newEventLog(0)
+	l.append(&update.Update{})
+	if events, err := l.since(1); err != nil || len(events) != 0 {
+		t.Errorf("expected no events, got %v, %v", events, err)
+	}
+	if _, err := l.since(0); !errors.Is(err, ErrResumeTooOld) {
+		t.Errorf("expected ErrResumeTooOld, got %v", err)
+	}
+	if _, err := newEventLog(3).since(1); !errors.Is(err, ErrResumeTooOld) {
+		t.Errorf("expected ErrResumeTooOld, got %v", err)
+	}
+}
+
+func TestWatchResumeFrom(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	opts := DefaultOptions()
+	opts.EventLogSize = 3
+	s, err := NewGenericWatchStorageWithOptions(storage.NewGenericStorage(
+		storage.NewGenericMappedRawStorage(dir), testSerializer, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier},
+	), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ws := s.(*GenericWatchStorage)
+	car := v1alpha1.SchemeGroupVersion.WithKind("Car")
+
+	// expectCars receives the events until the ones for the given Cars, which must have
+	// consecutive sequence numbers, and returns the last sequence number
+	expectCars := func(ch update.UpdateStream, last uint64, names ...string) uint64 {
+		pending := make(map[string]bool, len(names))
+		for _, name := range names {
+			pending[name] = true
+		}
+		for len(pending) != 0 {
+			select {
+			case upd := <-ch:
+				if upd.Sequence != last+1 {
+					t.Fatalf("expected sequence %d, got %d for %s", last+1, upd.Sequence, upd.PartialObject.GetName())
+				}
+				last = upd.Sequence
+				delete(pending, upd.PartialObject.GetName())
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out waiting for %v", pending)
+			}
+		}
+		return last
+	}
+
+	// The events are logged without any consumers
+	writeTestCar(t, dir, "foo")
+	writeTestCar(t, dir, "bar")
+	time.Sleep(2 * time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(update.UpdateStream, 10)
+	if err := ws.WatchKindWithOptions(ctx, car, ch, WithResumeFrom(0)); err != nil {
+		t.Fatal(err)
+	}
+	last := expectCars(ch, 0, "foo", "bar")
+	cancel()
+
+	// The consumer misses the events while it's away, but receives them when resuming
+	writeTestCar(t, dir, "baz")
+	time.Sleep(2 * time.Second)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	ch = make(update.UpdateStream, 10)
+	if err := ws.WatchKindWithOptions(ctx, car, ch, WithResumeFrom(last)); err != nil {
+		t.Fatal(err)
+	}
+	last = expectCars(ch, last, "baz")
+	writeTestCar(t, dir, "qux")
+	last = expectCars(ch, last, "qux")
+
+	// The first events aren't kept anymore
+	if last <= uint64(opts.EventLogSize) {
+		t.Fatalf("expected more than %d events, got %d", opts.EventLogSize, last)
+	}
+	if err := ws.WatchKindWithOptions(ctx, car, make(update.UpdateStream), WithResumeFrom(0)); !errors.Is(err, ErrResumeTooOld) {
+		t.Errorf("expected ErrResumeTooOld, got %v", err)
+	}
+}
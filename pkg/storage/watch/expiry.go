@@ -0,0 +1,151 @@
+package watch
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/weaveworks/libgitops/pkg/logs"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/storage/watch/update"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ExpiresAtAnnotation holds the time in RFC 3339 format after which an object is deleted
+// by the expiry reaper, see Options.ExpiryReaperInterval. Updating the annotation refreshes
+// the expiry of the object, removing it makes the object live forever.
+const ExpiresAtAnnotation = "libgitops.io/expires-at"
+
+// ExpiresAt returns the expiry of the given object set by SetExpiresAt.
+// ok is false if the object doesn't expire.
+func ExpiresAt(obj metav1.Object) (expiry time.Time, ok bool, err error) {
+	value, ok := obj.GetAnnotations()[ExpiresAtAnnotation]
+	if !ok {
+		return
+	}
+
+	if expiry, err = time.Parse(time.RFC3339, value); err != nil {
+		return expiry, false, fmt.Errorf("invalid %s annotation: %w", ExpiresAtAnnotation, err)
+	}
+	return
+}
+
+// SetExpiresAt makes the given object expire at the given time. A zero time clears the expiry.
+// The object needs to be written to the storage for the expiry to take effect.
+func SetExpiresAt(obj metav1.Object, expiry time.Time) {
+	annotations := obj.GetAnnotations()
+	if expiry.IsZero() {
+		delete(annotations, ExpiresAtAnnotation)
+		obj.SetAnnotations(annotations)
+		return
+	}
+
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[ExpiresAtAnnotation] = expiry.UTC().Format(time.RFC3339)
+	obj.SetAnnotations(annotations)
+}
+
+// expiryIndex holds the expiries of the tracked objects which expire, so that the reaper doesn't
+// need to read the files of all objects. It's updated with the events of the objects.
+type expiryIndex struct {
+	// expiries holds the expiring objects by their keys without the version, see storage.FormatObjectKey
+	expiries map[string]expiringObject
+	mux      sync.Mutex
+}
+
+// expiringObject is an object in the expiryIndex
+type expiringObject struct {
+	key    storage.ObjectKey
+	expiry time.Time
+}
+
+func newExpiryIndex() *expiryIndex {
+	return &expiryIndex{expiries: make(map[string]expiringObject)}
+}
+
+// set records that the object with the given key expires at the given time
+func (i *expiryIndex) set(key storage.ObjectKey, expiry time.Time) {
+	i.mux.Lock()
+	defer i.mux.Unlock()
+
+	i.expiries[storage.FormatObjectKey(key)] = expiringObject{key, expiry}
+}
+
+// remove forgets the expiry of the object with the given key, if any
+func (i *expiryIndex) remove(key storage.ObjectKey) {
+	i.mux.Lock()
+	defer i.mux.Unlock()
+
+	delete(i.expiries, storage.FormatObjectKey(key))
+}
+
+// expired returns the keys of the objects that have expired at the given time
+func (i *expiryIndex) expired(now time.Time) (keys []storage.ObjectKey) {
+	i.mux.Lock()
+	defer i.mux.Unlock()
+
+	for _, obj := range i.expiries {
+		if !now.Before(obj.expiry) {
+			keys = append(keys, obj.key)
+		}
+	}
+	return
+}
+
+// indexExpiry records the expiry of the object of the given event in the expiryIndex, if the reaper runs
+func (s *GenericWatchStorage) indexExpiry(event update.ObjectEvent, partObj runtime.PartialObject) {
+	if s.expiries == nil || event == update.ObjectEventSync {
+		return
+	}
+
+	key, err := s.eventKey(event, partObj)
+	if err != nil {
+		// The object can't be deleted without its key anyways
+		return
+	}
+	if event == update.ObjectEventDelete {
+		s.expiries.remove(key)
+		return
+	}
+
+	expiry, ok, err := ExpiresAt(partObj)
+	if err != nil {
+		s.logger.Error(err, "Not reaping object", "objectID", key.GetIdentifier())
+	}
+	if ok {
+		s.expiries.set(key, expiry)
+	} else {
+		s.expiries.remove(key)
+	}
+}
+
+// runReaper deletes the expired objects every opts.ExpiryReaperInterval until the storage is closed
+func (s *GenericWatchStorage) runReaper() {
+	ticker := s.clock.NewTicker(s.opts.ExpiryReaperInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			s.reap(s.clock.Now())
+		case <-s.closing:
+			return
+		}
+	}
+}
+
+// reap deletes all tracked objects that have expired at the given time, as recorded by the
+// expiryIndex. The files are removed through the embedded Storage, so the watcher sees the
+// deletions, and sends the DELETE events like for files removed by anyone else.
+func (s *GenericWatchStorage) reap(now time.Time) {
+	for _, key := range s.expiries.expired(now) {
+		s.logger.V(logs.DebugLevel).Info("Deleting expired object", "objectID", key.GetIdentifier())
+		if err := s.Storage.Delete(key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			s.logger.Error(err, "Failed to delete expired object", "objectID", key.GetIdentifier())
+		}
+	}
+}
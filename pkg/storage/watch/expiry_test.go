@@ -0,0 +1,215 @@
+package watch
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/storage/watch/update"
+	"github.com/weaveworks/libgitops/pkg/util/clock"
+	"go.uber.org/goleak"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// writeExpiringCar writes a Car expiring at the given time, or never if it's zero
+func writeExpiringCar(t *testing.T, dir, name string, expiry time.Time) {
+	annotations := ""
+	if !expiry.IsZero() {
+		annotations = fmt.Sprintf("  annotations:\n    %s: %q\n", ExpiresAtAnnotation, expiry.UTC().Format(time.RFC3339))
+	}
+	content := fmt.Sprintf("apiVersion: sample-app.weave.works/v1alpha1\nkind: Car\nmetadata:\n  name: %s\n  namespace: default\n%s", name, annotations)
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".yaml"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExpiryReaper(t *testing.T) {
+	defer goleak.VerifyNone(t, globalGoroutines...)
+
+	dir, err := ioutil.TempDir("", "watch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// RFC 3339 has a resolution of seconds, leave at least two seconds to clear the expiry of bar
+	expiry := time.Now().Truncate(time.Second).Add(3 * time.Second)
+	writeExpiringCar(t, dir, "foo", expiry)
+	writeExpiringCar(t, dir, "bar", expiry)
+	writeExpiringCar(t, dir, "baz", time.Time{})
+
+	opts := DefaultOptions()
+	opts.SyncEvent = true
+	opts.ExpiryReaperInterval = 50 * time.Millisecond
+	s, err := NewGenericWatchStorageWithOptions(storage.NewGenericStorage(
+		storage.NewGenericMappedRawStorage(dir), testSerializer, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier},
+	), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	updates := make(update.UpdateStream, 10)
+	s.SetUpdateStream(updates)
+	for {
+		upd := <-updates
+		if upd.Event == update.ObjectEventSync {
+			break
+		}
+	}
+
+	// Clearing the expiry of bar keeps it from being deleted
+	writeExpiringCar(t, dir, "bar", time.Time{})
+	select {
+	case upd := <-updates:
+		if upd.Event != update.ObjectEventModify || upd.PartialObject.GetName() != "bar" {
+			t.Fatalf("expected MODIFY bar, got %s %s", upd.Event, objectID(upd.PartialObject))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the MODIFY event")
+	}
+
+	select {
+	case upd := <-updates:
+		if upd.Event != update.ObjectEventDelete || objectID(upd.PartialObject) != "default/foo" {
+			t.Fatalf("expected DELETE default/foo, got %s %s", upd.Event, objectID(upd.PartialObject))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the DELETE event")
+	}
+
+	// All objects have been checked by the reaper pass deleting foo, wait for another one to be sure
+	time.Sleep(2 * opts.ExpiryReaperInterval)
+	for _, name := range []string{"bar", "baz"} {
+		if _, err := os.Stat(filepath.Join(dir, name+".yaml")); err != nil {
+			t.Errorf("expected %s not to be deleted: %v", name, err)
+		}
+	}
+	select {
+	case upd := <-updates:
+		t.Errorf("expected no more events, got %s %s", upd.Event, objectID(upd.PartialObject))
+	default:
+	}
+}
+
+func TestExpiryReaperFakeClock(t *testing.T) {
+	defer goleak.VerifyNone(t, globalGoroutines...)
+
+	dir, err := ioutil.TempDir("", "watch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fake := clock.NewFakeClock(time.Now().Truncate(time.Second))
+	writeExpiringCar(t, dir, "foo", fake.Now().Add(time.Hour))
+	writeExpiringCar(t, dir, "bar", fake.Now().Add(2*time.Hour))
+
+	opts := DefaultOptions()
+	opts.SyncEvent = true
+	opts.ExpiryReaperInterval = time.Minute
+	opts.Clock = fake
+	s, err := NewGenericWatchStorageWithOptions(storage.NewGenericStorage(
+		storage.NewGenericMappedRawStorage(dir), testSerializer, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier},
+	), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	updates := make(update.UpdateStream, 10)
+	s.SetUpdateStream(updates)
+	for {
+		upd := <-updates
+		if upd.Event == update.ObjectEventSync {
+			break
+		}
+	}
+	// Wait for the reaper to start its ticker
+	for fake.Waiters() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// expectDeleted advances the clock to the given time, and then second by second until the
+	// FileWatcher has sent the DELETE event of the reaped object, as it debounces using the clock
+	expectDeleted := func(d time.Duration, name string) {
+		fake.Advance(d)
+		for i := 0; i < 500; i++ {
+			select {
+			case upd := <-updates:
+				if upd.Event != update.ObjectEventDelete || objectID(upd.PartialObject) != "default/"+name {
+					t.Fatalf("expected DELETE default/%s, got %s %s", name, upd.Event, objectID(upd.PartialObject))
+				}
+				return
+			case <-time.After(10 * time.Millisecond):
+				fake.Advance(time.Second)
+			}
+		}
+		t.Fatalf("timed out waiting for the DELETE event of %s", name)
+	}
+
+	expectDeleted(61*time.Minute, "foo")
+	if _, err := os.Stat(filepath.Join(dir, "bar.yaml")); err != nil {
+		t.Errorf("expected bar not to be deleted yet: %v", err)
+	}
+	expectDeleted(time.Hour, "bar")
+}
+
+func TestSetExpiresAt(t *testing.T) {
+	obj := &metav1.ObjectMeta{}
+	if _, ok, err := ExpiresAt(obj); ok || err != nil {
+		t.Errorf("expected no expiry, got %v (%v)", ok, err)
+	}
+
+	expiry := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	SetExpiresAt(obj, expiry)
+	if actual, ok, err := ExpiresAt(obj); !ok || err != nil || !actual.Equal(expiry) {
+		t.Errorf("expected the expiry %s, got %s (%v)", expiry, actual, err)
+	}
+
+	SetExpiresAt(obj, time.Time{})
+	if _, ok := obj.GetAnnotations()[ExpiresAtAnnotation]; ok {
+		t.Error("expected the expiry to be cleared")
+	}
+
+	obj.SetAnnotations(map[string]string{ExpiresAtAnnotation: "tomorrow"})
+	if _, _, err := ExpiresAt(obj); err == nil {
+		t.Error("expected an error for an invalid expiry")
+	}
+}
+
+func TestExpiryIndex(t *testing.T) {
+	i := newExpiryIndex()
+	key := func(version, name string) storage.ObjectKey {
+		gvk := schema.GroupVersionKind{Group: "sample-app.weave.works", Version: version, Kind: "Car"}
+		return storage.NewObjectKey(storage.NewKindKey(gvk), runtime.NewIdentifier("default/"+name))
+	}
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	i.set(key("v1alpha1", "foo"), now.Add(-time.Second))
+	i.set(key("v1alpha1", "bar"), now.Add(time.Second))
+	i.set(key("v1alpha1", "baz"), now)
+	// The expiry of an object is replaced regardless of the version
+	i.set(key("v1beta1", "baz"), now.Add(time.Hour))
+
+	expired := i.expired(now)
+	if len(expired) != 1 || expired[0] != key("v1alpha1", "foo") {
+		t.Errorf("expected only foo to have expired, got %v", expired)
+	}
+	if expired := i.expired(now.Add(time.Second)); len(expired) != 2 {
+		t.Errorf("expected foo and bar to have expired, got %v", expired)
+	}
+
+	i.remove(key("v1alpha1", "foo"))
+	i.remove(key("v1alpha1", "baz"))
+	expired = i.expired(now.Add(time.Hour))
+	if len(expired) != 1 || expired[0] != key("v1alpha1", "bar") {
+		t.Errorf("expected only bar to be left, got %v", expired)
+	}
+}
@@ -0,0 +1,17 @@
+package watch
+
+import (
+	"context"
+	"io"
+
+	"github.com/weaveworks/libgitops/pkg/filter"
+	"github.com/weaveworks/libgitops/pkg/serializer"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Export writes all objects of the given GroupKind to w, as a stream of frames of the
+// given ContentType. This is the inverse of Import, see storage.Export for the details.
+func (s *GenericWatchStorage) Export(ctx context.Context, w io.Writer, gk schema.GroupKind, ct serializer.ContentType, opts ...filter.ListOption) error {
+	return storage.Export(ctx, s, w, gk, ct, opts...)
+}
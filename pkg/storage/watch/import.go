@@ -0,0 +1,164 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/util/watcher"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var (
+	// ErrExcluded is reported by Import for files that are skipped due to their path
+	ErrExcluded = errors.New("file is excluded")
+	// ErrNotMapped is returned by Import if the RawStorage isn't a MappedRawStorage
+	ErrNotMapped = errors.New("importing requires a MappedRawStorage")
+)
+
+// ImportReport summarizes the result of GenericWatchStorage.Import
+type ImportReport struct {
+	// Imported counts the imported objects per GroupKind
+	Imported map[schema.GroupKind]int
+	// Skipped maps the paths of the files that were skipped, as they were excluded or
+	// contain an object of a type unknown to the scheme, to the reason for skipping them
+	Skipped map[string]error
+	// Failed maps the paths of the files that couldn't be imported, as they failed
+	// to be decoded or validated, to the error
+	Failed map[string]error
+}
+
+// Import walks srcDir, and decodes and validates the object in every file in it. The objects of the
+// valid files are mapped to their files, like the objects found by the initial scan, and a CREATE
+// event is sent for each of them, or a MODIFY event if the object was already tracked. The errors
+// for invalid files are aggregated in the returned ImportReport instead of aborting the import.
+// Files excluded by Options.PathExcluders are skipped. srcDir is usually one of the watched
+// directories, otherwise later changes to the imported files aren't noticed. The import stops
+// if ctx is cancelled.
+func (s *GenericWatchStorage) Import(ctx context.Context, srcDir string) (*ImportReport, error) {
+	raw := s.RawStorage()
+	if _, ok := raw.(storage.MappedRawStorage); !ok {
+		return nil, ErrNotMapped
+	}
+
+	report := &ImportReport{
+		Imported: make(map[schema.GroupKind]int),
+		Skipped:  make(map[string]error),
+		Failed:   make(map[string]error),
+	}
+
+	opts := watcher.DefaultOptions()
+	err := filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		excluded := path != srcDir && s.excluded(path, info.IsDir())
+		if info.IsDir() {
+			if excluded || (path != srcDir && contains(opts.ExcludeDirs, info.Name())) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if excluded || !contains(opts.ValidExtensions, filepath.Ext(path)) {
+			report.Skipped[path] = ErrExcluded
+			return nil
+		}
+
+		gk, err := s.importFile(raw, path)
+		var skipErr *skippedError
+		switch {
+		case errors.As(err, &skipErr):
+			report.Skipped[path] = skipErr.err
+		case err != nil:
+			report.Failed[path] = err
+		default:
+			report.Imported[gk]++
+		}
+		return nil
+	})
+
+	s.logger.Info("Imported objects", "path", srcDir, "imported", report.total(), "skipped", len(report.Skipped), "failed", len(report.Failed))
+	return report, err
+}
+
+// total returns the amount of imported objects
+func (r *ImportReport) total() (total int) {
+	for _, n := range r.Imported {
+		total += n
+	}
+	return
+}
+
+// skippedError marks the errors of files that are skipped instead of failed
+type skippedError struct {
+	err error
+}
+
+func (e *skippedError) Error() string {
+	return e.err.Error()
+}
+
+// importFile maps the object declared by the file at path to it, if it's valid
+func (s *GenericWatchStorage) importFile(raw storage.RawStorage, path string) (schema.GroupKind, error) {
+	partObj, checksum, err := s.readFile(path)
+	if err != nil {
+		return schema.GroupKind{}, err
+	}
+
+	gvk := partObj.GetObjectKind().GroupVersionKind()
+	if !s.Serializer().Scheme().Recognizes(gvk) {
+		return schema.GroupKind{}, &skippedError{fmt.Errorf("unrecognized type %s", gvk)}
+	}
+
+	key, err := s.Storage.ObjectKeyFor(partObj)
+	if err != nil {
+		return schema.GroupKind{}, err
+	}
+
+	winner, created := s.addMapping(raw, partObj, path, checksum)
+	if winner != path {
+		return schema.GroupKind{}, &skippedError{fmt.Errorf("%s is already declared by %q", key, winner)}
+	}
+
+	// Decode the whole object, now that it's mapped to the file
+	obj, err := s.Storage.Get(key)
+	if err == nil {
+		if v, ok := s.Storage.(storage.ObjectValidator); ok {
+			err = v.Validate(obj)
+		}
+	}
+	if err != nil {
+		s.removeMapping(raw, path)
+		return schema.GroupKind{}, err
+	}
+
+	s.sendEvent(trackedEvent(raw, created), partObj)
+	return gvk.GroupKind(), nil
+}
+
+// excluded returns true if any of opts.PathExcluders excludes the given path
+func (s *GenericWatchStorage) excluded(path string, isDir bool) bool {
+	for _, excluder := range s.opts.PathExcluders {
+		if excluder.ShouldExcludePath(path, isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
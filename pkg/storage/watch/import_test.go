@@ -0,0 +1,84 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/scheme"
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func TestImport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	opts := storage.DefaultOptions()
+	opts.WriteValidators = map[schema.GroupKind][]storage.WriteValidator{
+		v1alpha1.SchemeGroupVersion.WithKind("Car").GroupKind(): {storage.WriteValidatorFunc(func(obj runtime.Object) field.ErrorList {
+			if obj.(*v1alpha1.Car).Spec.Brand == "" {
+				return field.ErrorList{field.Required(field.NewPath("spec", "brand"), "")}
+			}
+			return nil
+		})},
+	}
+	s, err := NewGenericWatchStorage(storage.NewGenericStorageWithOptions(
+		storage.NewGenericMappedRawStorage(dir), scheme.Serializer, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier}, opts))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	files := map[string]string{
+		"foo.yaml":      "apiVersion: sample-app.weave.works/v1alpha1\nkind: Car\nmetadata:\n  name: foo\n  namespace: default\nspec:\n  brand: Volvo\n",
+		"cars/bar.yaml": "apiVersion: sample-app.weave.works/v1alpha1\nkind: Car\nmetadata:\n  name: bar\n  namespace: default\nspec:\n  brand: Saab\n",
+		"invalid.yaml":  "apiVersion: sample-app.weave.works/v1alpha1\nkind: Car\nmetadata:\n  name: invalid\n  namespace: default\n",
+		"broken.yaml":   "apiVersion: sample-app.weave.works/v1alpha1\nkind: Car\nmetadata: [\n",
+		"unknown.yaml":  "apiVersion: foo/v1\nkind: Bar\nmetadata:\n  name: unknown\n",
+		"README.md":     "# Cars\n",
+		".git/foo.yaml": "apiVersion: sample-app.weave.works/v1alpha1\nkind: Car\nmetadata:\n  name: git\n",
+	}
+	for path, content := range files {
+		path = filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := s.(*GenericWatchStorage).Import(context.Background(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	carGK := schema.GroupKind{Group: v1alpha1.SchemeGroupVersion.Group, Kind: "Car"}
+	if len(report.Imported) != 1 || report.Imported[carGK] != 2 {
+		t.Errorf("expected two imported cars, got %v", report.Imported)
+	}
+	if len(report.Skipped) != 2 || !errors.Is(report.Skipped[filepath.Join(dir, "README.md")], ErrExcluded) || report.Skipped[filepath.Join(dir, "unknown.yaml")] == nil {
+		t.Errorf("expected README.md and unknown.yaml to be skipped, got %v", report.Skipped)
+	}
+	if len(report.Failed) != 2 || !errors.Is(report.Failed[filepath.Join(dir, "invalid.yaml")], storage.ErrInvalid) || report.Failed[filepath.Join(dir, "broken.yaml")] == nil {
+		t.Errorf("expected invalid.yaml and broken.yaml to fail, got %v", report.Failed)
+	}
+
+	// The imported objects can be read, the failed ones can't
+	for name, found := range map[string]bool{"foo": true, "bar": true, "invalid": false} {
+		key := storage.NewObjectKey(storage.NewKindKey(v1alpha1.SchemeGroupVersion.WithKind("Car")), runtime.NewIdentifier("default/"+name))
+		if _, err := s.Get(key); (err == nil) != found {
+			t.Errorf("%s: expected found to be %t, got %v", name, found, err)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package watch
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/weaveworks/libgitops/pkg/util/watcher"
+)
+
+// Metrics is a snapshot of the counters of a GenericWatchStorage and its FileWatcher
+type Metrics struct {
+	// Watcher holds the metrics of the underlying FileWatcher
+	Watcher watcher.Metrics
+	// EventsSent is the number of ObjectEvents sent to the update stream
+	EventsSent uint64
+	// EventsDropped is the number of ObjectEvents dropped, as the update
+	// stream didn't accept them before the storage was closed
+	EventsDropped uint64
+	// EventsFiltered is the number of ObjectEvents not sent, as they were
+	// rejected by one of the Options.EventFilters
+	EventsFiltered uint64
+	// FilesIgnored is the number of times a changed file was ignored, as it
+	// couldn't be read or didn't contain a recognizable object
+	FilesIgnored uint64
+	// EventsUnchanged is the number of MODIFY events not sent, as the
+	// content of the modified file didn't change
+	EventsUnchanged uint64
+	// UpdateStreamDepth is the number of ObjectEvents waiting to be consumed
+	UpdateStreamDepth int
+}
+
+// counters holds the counters of a GenericWatchStorage. It must be the first field
+// of GenericWatchStorage to guarantee the 64-bit alignment required by the atomic operations.
+type counters struct {
+	sent      uint64
+	dropped   uint64
+	filtered  uint64
+	ignored   uint64
+	unchanged uint64
+}
+
+// Metrics returns a snapshot of the counters of the GenericWatchStorage
+func (s *GenericWatchStorage) Metrics() Metrics {
+	return Metrics{
+		Watcher:           s.watcher.Metrics(),
+		EventsSent:        atomic.LoadUint64(&s.counters.sent),
+		EventsDropped:     atomic.LoadUint64(&s.counters.dropped),
+		EventsFiltered:    atomic.LoadUint64(&s.counters.filtered),
+		FilesIgnored:      atomic.LoadUint64(&s.counters.ignored),
+		EventsUnchanged:   atomic.LoadUint64(&s.counters.unchanged),
+		UpdateStreamDepth: len(s.updateStream()),
+	}
+}
+
+// NewCollector returns a prometheus.Collector exposing the Metrics of the given
+// GenericWatchStorage. All metrics are prefixed with namespace, and labeled with
+// the given constant labels, which can be used to distinguish multiple storages.
+func NewCollector(s *GenericWatchStorage, namespace string, labels prometheus.Labels) prometheus.Collector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "watch", name), help, nil, labels)
+	}
+
+	return &collector{
+		storage:           s,
+		eventsReceived:    desc("events_received_total", "Number of inotify events received for valid files."),
+		eventsCoalesced:   desc("events_coalesced_total", "Number of inotify events concatenated with other events of the same file."),
+		eventsSuspended:   desc("events_suspended_total", "Number of inotify events skipped, as they were caused by the storage itself."),
+		overflows:         desc("overflows_total", "Number of times the event queue overflowed and the watched directories were resynced."),
+		updatesSent:       desc("file_updates_sent_total", "Number of file updates sent by the watcher."),
+		eventsSent:        desc("object_events_sent_total", "Number of object events sent to the update stream."),
+		eventsDropped:     desc("object_events_dropped_total", "Number of object events dropped when closing the storage."),
+		eventsFiltered:    desc("object_events_filtered_total", "Number of object events rejected by the event filters."),
+		filesIgnored:      desc("files_ignored_total", "Number of times a changed file was ignored, as it couldn't be recognized."),
+		eventsUnchanged:   desc("object_events_unchanged_total", "Number of modify events not sent, as the content of the file didn't change."),
+		eventQueueDepth:   desc("event_queue_depth", "Number of inotify events waiting to be registered."),
+		updateQueueDepth:  desc("file_update_queue_depth", "Number of file updates waiting to be processed."),
+		updateStreamDepth: desc("update_stream_depth", "Number of object events waiting to be consumed from the update stream."),
+		lastEvent:         desc("seconds_since_last_event", "Seconds since the last inotify event was received, -1 if none has been received."),
+	}
+}
+
+// collector implements prometheus.Collector for a GenericWatchStorage
+type collector struct {
+	storage *GenericWatchStorage
+
+	eventsReceived    *prometheus.Desc
+	eventsCoalesced   *prometheus.Desc
+	eventsSuspended   *prometheus.Desc
+	overflows         *prometheus.Desc
+	updatesSent       *prometheus.Desc
+	eventsSent        *prometheus.Desc
+	eventsDropped     *prometheus.Desc
+	eventsFiltered    *prometheus.Desc
+	filesIgnored      *prometheus.Desc
+	eventsUnchanged   *prometheus.Desc
+	eventQueueDepth   *prometheus.Desc
+	updateQueueDepth  *prometheus.Desc
+	updateStreamDepth *prometheus.Desc
+	lastEvent         *prometheus.Desc
+}
+
+var _ prometheus.Collector = &collector{}
+
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	m := c.storage.Metrics()
+
+	counter := func(desc *prometheus.Desc, value uint64) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(value))
+	}
+	gauge := func(desc *prometheus.Desc, value float64) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value)
+	}
+
+	counter(c.eventsReceived, m.Watcher.EventsReceived)
+	counter(c.eventsCoalesced, m.Watcher.EventsCoalesced)
+	counter(c.eventsSuspended, m.Watcher.EventsSuspended)
+	counter(c.overflows, m.Watcher.Overflows)
+	counter(c.updatesSent, m.Watcher.UpdatesSent)
+	counter(c.eventsSent, m.EventsSent)
+	counter(c.eventsDropped, m.EventsDropped)
+	counter(c.eventsFiltered, m.EventsFiltered)
+	counter(c.filesIgnored, m.FilesIgnored)
+	counter(c.eventsUnchanged, m.EventsUnchanged)
+	gauge(c.eventQueueDepth, float64(m.Watcher.EventQueueDepth))
+	gauge(c.updateQueueDepth, float64(m.Watcher.UpdateQueueDepth))
+	gauge(c.updateStreamDepth, float64(m.UpdateStreamDepth))
+
+	sinceLastEvent := float64(-1)
+	if !m.Watcher.LastEvent.IsZero() {
+		sinceLastEvent = time.Since(m.Watcher.LastEvent).Seconds()
+	}
+	gauge(c.lastEvent, sinceLastEvent)
+}
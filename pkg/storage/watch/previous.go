@@ -0,0 +1,60 @@
+package watch
+
+import (
+	"sync"
+
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/storage/watch/update"
+)
+
+// objectCache holds the last decoded object sent for each key, which is attached
+// as the previous object to MODIFY events if Options.PreviousObject is set
+type objectCache struct {
+	objects map[storage.ObjectKey]runtime.Object
+	mux     sync.Mutex
+}
+
+func newObjectCache() *objectCache {
+	return &objectCache{objects: make(map[storage.ObjectKey]runtime.Object)}
+}
+
+// swap stores obj for key, and returns the object stored before, if any
+func (c *objectCache) swap(key storage.ObjectKey, obj runtime.Object) runtime.Object {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	previous := c.objects[key]
+	c.objects[key] = obj
+	return previous
+}
+
+// forget removes the object stored for key
+func (c *objectCache) forget(key storage.ObjectKey) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	delete(c.objects, key)
+}
+
+// attachObjects decodes the object of the given CREATE or MODIFY Update, and attaches it to
+// the Update. For MODIFY events, the previously sent version of the object is attached too.
+func (s *GenericWatchStorage) attachObjects(upd *update.Update) {
+	key, err := s.Storage.ObjectKeyFor(upd.PartialObject)
+	if err != nil {
+		s.logger.Error(err, "Failed to identify the object", "eventType", upd.Event, "name", upd.PartialObject.GetName())
+		return
+	}
+
+	obj, err := s.Storage.Get(key)
+	if err != nil {
+		s.logger.Error(err, "Failed to decode the object", "eventType", upd.Event, "objectID", key.GetIdentifier())
+		return
+	}
+
+	previous := s.previous.swap(key, obj)
+	upd.Object = obj
+	if upd.Event == update.ObjectEventModify {
+		upd.PreviousObject = previous
+	}
+}
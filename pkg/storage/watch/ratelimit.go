@@ -0,0 +1,133 @@
+package watch
+
+import (
+	"sync"
+	"time"
+
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/storage/watch/update"
+	"github.com/weaveworks/libgitops/pkg/util/clock"
+)
+
+// pendingEvent is an event held back by the rateLimiter
+type pendingEvent struct {
+	event   update.ObjectEvent
+	partObj runtime.PartialObject
+	timer   clock.Timer
+}
+
+// rateLimiter sends at most one event per object key per interval. The events for a key
+// received within the interval are collapsed into one, which is sent when the interval ends.
+type rateLimiter struct {
+	interval time.Duration
+	clock    clock.Clock
+	send     func(update.ObjectEvent, runtime.PartialObject)
+	// last holds the time the last event was sent for each key
+	last map[storage.ObjectKey]time.Time
+	// pending holds the collapsed event waiting to be sent for each key
+	pending map[storage.ObjectKey]*pendingEvent
+	// timers tracks the timers of the pending events, which send them when they fire
+	timers sync.WaitGroup
+	mux    sync.Mutex
+}
+
+func newRateLimiter(interval time.Duration, c clock.Clock, send func(update.ObjectEvent, runtime.PartialObject)) *rateLimiter {
+	return &rateLimiter{
+		interval: interval,
+		clock:    c,
+		send:     send,
+		last:     make(map[storage.ObjectKey]time.Time),
+		pending:  make(map[storage.ObjectKey]*pendingEvent),
+	}
+}
+
+// limit sends the given event for key right away if no event has been sent for key during the
+// interval, otherwise it's collapsed with the other events held back for key
+func (r *rateLimiter) limit(key storage.ObjectKey, event update.ObjectEvent, partObj runtime.PartialObject) {
+	r.mux.Lock()
+
+	if p, ok := r.pending[key]; ok {
+		// Keep the latest object, but don't hide that the object was created or deleted
+		p.event = collapseEvents(p.event, event)
+		p.partObj = partObj
+		r.mux.Unlock()
+		return
+	}
+
+	now := r.clock.Now()
+	if wait := r.last[key].Add(r.interval).Sub(now); wait > 0 {
+		r.timers.Add(1)
+		r.pending[key] = &pendingEvent{
+			event:   event,
+			partObj: partObj,
+			timer: r.clock.AfterFunc(wait, func() {
+				defer r.timers.Done()
+				r.fire(key)
+			}),
+		}
+		r.mux.Unlock()
+		return
+	}
+
+	r.last[key] = now
+	r.mux.Unlock()
+	r.send(event, partObj)
+}
+
+// fire sends the pending event for key, if it hasn't been flushed
+func (r *rateLimiter) fire(key storage.ObjectKey) {
+	r.mux.Lock()
+	p, ok := r.pending[key]
+	if ok {
+		delete(r.pending, key)
+		r.last[key] = r.clock.Now()
+	}
+	r.mux.Unlock()
+
+	if ok {
+		r.send(p.event, p.partObj)
+	}
+}
+
+// flush sends all pending events right away, and waits for the timers which already fired
+func (r *rateLimiter) flush() {
+	r.mux.Lock()
+	pending := r.pending
+	r.pending = make(map[storage.ObjectKey]*pendingEvent)
+	for _, p := range pending {
+		if p.timer.Stop() {
+			r.timers.Done()
+		}
+	}
+	r.mux.Unlock()
+
+	for _, p := range pending {
+		r.send(p.event, p.partObj)
+	}
+	r.timers.Wait()
+}
+
+// collapseEvents returns the event describing both the pending and the next event for an object
+func collapseEvents(pending, next update.ObjectEvent) update.ObjectEvent {
+	switch {
+	case pending == update.ObjectEventCreate && next == update.ObjectEventModify:
+		// The consumers haven't seen the object yet
+		return update.ObjectEventCreate
+	case pending == update.ObjectEventDelete && next == update.ObjectEventCreate:
+		// The consumers still know the object from before it was deleted
+		return update.ObjectEventModify
+	}
+	return next
+}
+
+// eventKey returns the key of the object of the given event. DELETE events carry the
+// identifier of the object as its UID (see EventDeleteObjectName).
+func (s *GenericWatchStorage) eventKey(event update.ObjectEvent, partObj runtime.PartialObject) (storage.ObjectKey, error) {
+	if event != update.ObjectEventDelete || partObj.GetName() != EventDeleteObjectName {
+		return s.Storage.ObjectKeyFor(partObj)
+	}
+
+	gvk := partObj.GetObjectKind().GroupVersionKind()
+	return storage.NewObjectKey(storage.NewKindKey(gvk), runtime.NewIdentifier(string(partObj.GetUID()))), nil
+}
@@ -0,0 +1,83 @@
+package watch
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/storage/watch/update"
+)
+
+// maxDeletedRevisions is the number of deleted objects whose revisions are kept by the revisionCounter
+const maxDeletedRevisions = 4096
+
+// revisionCounter assigns the revisions of the events for every object, see update.Update.Revision.
+// The revisions of the maxDeleted most recently deleted objects are kept, so that objects created again
+// soon after continue from them, while the revisions of objects deleted for good are dropped.
+type revisionCounter struct {
+	// revisions holds the last revision of every object, by its key without the version
+	// (see storage.FormatObjectKey), so that the revision is kept when the version changes
+	revisions map[string]uint64
+	// deleted holds the elements of the deleted objects in order, which lists their keys
+	// from the least to the most recently deleted
+	deleted    map[string]*list.Element
+	order      *list.List
+	maxDeleted int
+	mux        sync.Mutex
+}
+
+func newRevisionCounter() *revisionCounter {
+	return &revisionCounter{
+		revisions:  make(map[string]uint64),
+		deleted:    make(map[string]*list.Element),
+		order:      list.New(),
+		maxDeleted: maxDeletedRevisions,
+	}
+}
+
+// next returns the revision of the next event for key, which is the revision of the previous event
+// incremented by one, or the stored revision of partObj (see storage.RevisionAnnotation) if it's
+// greater. This makes the revisions of the objects found by the initial scan deterministic, i.e.
+// either their stored revisions or 1, and lets them follow the writes of storage.Options.ManageRevision.
+// If deleted is set, the object has been deleted, and its revision is dropped once more than
+// maxDeleted other objects have been deleted since.
+func (c *revisionCounter) next(key storage.ObjectKey, partObj runtime.PartialObject, deleted bool) uint64 {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	id := storage.FormatObjectKey(key)
+	revision := c.revisions[id] + 1
+	if stored := storage.Revision(partObj); stored > revision {
+		revision = stored
+	}
+	c.revisions[id] = revision
+
+	if elem, ok := c.deleted[id]; ok {
+		c.order.Remove(elem)
+		delete(c.deleted, id)
+	}
+	if deleted {
+		c.deleted[id] = c.order.PushBack(id)
+		for c.order.Len() > c.maxDeleted {
+			oldest := c.order.Remove(c.order.Front()).(string)
+			delete(c.deleted, oldest)
+			delete(c.revisions, oldest)
+		}
+	}
+	return revision
+}
+
+// setRevision sets the revision of the given Update, SYNC events have none
+func (s *GenericWatchStorage) setRevision(upd *update.Update) {
+	if upd.Event == update.ObjectEventSync {
+		return
+	}
+
+	key, err := s.eventKey(upd.Event, upd.PartialObject)
+	if err != nil {
+		s.logger.Error(err, "Failed to identify the object", "eventType", upd.Event, "name", upd.PartialObject.GetName())
+		return
+	}
+	upd.Revision = s.revisions.next(key, upd.PartialObject, upd.Event == update.ObjectEventDelete)
+}
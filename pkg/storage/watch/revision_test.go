@@ -0,0 +1,140 @@
+package watch
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/storage/watch/update"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// writeRevisionCar writes a Car with the given stored revision, if not empty
+func writeRevisionCar(t *testing.T, dir, name, revision, brand string) {
+	annotations := ""
+	if len(revision) != 0 {
+		annotations = fmt.Sprintf("  annotations:\n    %s: %q\n", storage.RevisionAnnotation, revision)
+	}
+	content := fmt.Sprintf("apiVersion: sample-app.weave.works/v1alpha1\nkind: Car\nmetadata:\n  name: %s\n  namespace: default\n%sspec:\n  brand: %s\n", name, annotations, brand)
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".yaml"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEventRevisions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The objects found by the initial scan start at their stored revisions, or 1
+	writeRevisionCar(t, dir, "foo", "", "Volvo")
+	writeRevisionCar(t, dir, "bar", "5", "Volvo")
+
+	opts := DefaultOptions()
+	opts.SyncEvent = true
+	s, err := NewGenericWatchStorageWithOptions(storage.NewGenericStorage(
+		storage.NewGenericMappedRawStorage(dir), testSerializer, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier},
+	), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	updates := make(update.UpdateStream, 10)
+	s.SetUpdateStream(updates)
+
+	initial := make(map[string]uint64)
+	for upd := range updates {
+		if upd.Event == update.ObjectEventSync {
+			if upd.Revision != 0 {
+				t.Errorf("expected no revision for the SYNC event, got %d", upd.Revision)
+			}
+			break
+		}
+		initial[upd.PartialObject.GetName()] = upd.Revision
+	}
+	if initial["foo"] != 1 || initial["bar"] != 5 {
+		t.Errorf("expected the revisions 1 and 5, got %v", initial)
+	}
+
+	// A single write may be reported as more than one MODIFY event, so the revisions are
+	// only required to increase, and to be at least the stored revision
+	last := initial["foo"]
+	expectRevision := func(step string, event update.ObjectEvent, min uint64) {
+		for {
+			select {
+			case upd := <-updates:
+				if upd.Revision <= last {
+					t.Fatalf("%s: expected the revision to increase from %d, got %d", step, last, upd.Revision)
+				}
+				last = upd.Revision
+				if upd.Event != event {
+					continue
+				}
+				if upd.Revision < min {
+					t.Errorf("%s: expected at least revision %d, got %d", step, min, upd.Revision)
+				}
+				return
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%s: timed out waiting for the %s event", step, event)
+			}
+		}
+	}
+
+	writeRevisionCar(t, dir, "foo", "3", "Tesla")
+	expectRevision("stored revision ahead", update.ObjectEventModify, 3)
+	writeRevisionCar(t, dir, "foo", "", "Audi")
+	expectRevision("no stored revision", update.ObjectEventModify, 4)
+	writeRevisionCar(t, dir, "foo", "2", "Saab")
+	expectRevision("stored revision behind", update.ObjectEventModify, 5)
+
+	// Objects created again continue from the revision they were deleted with
+	if err := os.Remove(filepath.Join(dir, "foo.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	expectRevision("delete", update.ObjectEventDelete, 6)
+	writeRevisionCar(t, dir, "foo", "1", "Volvo")
+	expectRevision("create", update.ObjectEventCreate, 7)
+}
+
+func TestRevisionCounter(t *testing.T) {
+	c := newRevisionCounter()
+	c.maxDeleted = 2
+
+	obj := &runtime.PartialObjectImpl{}
+	key := func(version, name string) storage.ObjectKey {
+		gvk := schema.GroupVersionKind{Group: "sample-app.weave.works", Version: version, Kind: "Car"}
+		return storage.NewObjectKey(storage.NewKindKey(gvk), runtime.NewIdentifier("default/"+name))
+	}
+	expect := func(revision, expected uint64) {
+		t.Helper()
+		if revision != expected {
+			t.Errorf("expected revision %d, got %d", expected, revision)
+		}
+	}
+
+	// The revisions are counted per object, regardless of the version
+	expect(c.next(key("v1alpha1", "foo"), obj, false), 1)
+	expect(c.next(key("v1beta1", "foo"), obj, false), 2)
+	expect(c.next(key("v1alpha1", "bar"), obj, false), 1)
+
+	// Objects created again continue from the revision they were deleted with, until
+	// more than maxDeleted other objects have been deleted since
+	expect(c.next(key("v1alpha1", "foo"), obj, true), 3)
+	expect(c.next(key("v1alpha1", "foo"), obj, false), 4)
+	expect(c.next(key("v1alpha1", "foo"), obj, true), 5)
+	expect(c.next(key("v1alpha1", "bar"), obj, true), 2)
+	expect(c.next(key("v1alpha1", "baz"), obj, true), 1)
+	if len(c.revisions) != 2 || len(c.deleted) != 2 || c.order.Len() != 2 {
+		t.Errorf("expected the revisions of 2 objects, got %v", c.revisions)
+	}
+	expect(c.next(key("v1alpha1", "foo"), obj, false), 1)
+	expect(c.next(key("v1alpha1", "bar"), obj, false), 3)
+}
@@ -0,0 +1,59 @@
+package watch
+
+import (
+	"github.com/weaveworks/libgitops/pkg/runtime"
+)
+
+// scanResult holds the object read from a file by scanFiles
+type scanResult struct {
+	obj      runtime.PartialObject
+	checksum string
+	err      error
+}
+
+// scanFiles reads and recognizes the objects in the given files using opts.ScanConcurrency
+// workers, and calls fn for every file in the order of files. fn is only called by the
+// calling goroutine, so the mappings it registers are updated serially, and the result is
+// the same as when reading the files one by one.
+func (s *GenericWatchStorage) scanFiles(files []string, fn func(file string, obj runtime.PartialObject, checksum string, err error)) {
+	workers := s.opts.ScanConcurrency
+	if workers > len(files) {
+		workers = len(files)
+	}
+	if workers <= 1 {
+		for _, file := range files {
+			obj, checksum, err := s.readFile(file)
+			fn(file, obj, checksum, err)
+		}
+		return
+	}
+
+	// Every file gets its own buffered channel, so the workers never block on the results
+	results := make([]chan scanResult, len(files))
+	for i := range results {
+		results[i] = make(chan scanResult, 1)
+	}
+
+	indexes := make(chan int)
+	go func() {
+		defer close(indexes)
+		for i := range files {
+			indexes <- i
+		}
+	}()
+
+	for w := 0; w < workers; w++ {
+		go func() {
+			for i := range indexes {
+				obj, checksum, err := s.readFile(files[i])
+				results[i] <- scanResult{obj, checksum, err}
+			}
+		}()
+	}
+
+	// All results are received, so the workers are done when this returns
+	for i, file := range files {
+		r := <-results[i]
+		fn(file, r.obj, r.checksum, r.err)
+	}
+}
@@ -0,0 +1,126 @@
+package watch
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	libgitopsruntime "github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/storage/watch/update"
+)
+
+// writeTestTree writes n Cars into subdirectories of a new temporary directory
+func writeTestTree(tb testing.TB, n int) string {
+	dir, err := ioutil.TempDir("", "watch-test")
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	for i := 0; i < n; i++ {
+		sub := filepath.Join(dir, fmt.Sprintf("dir-%d", i%10))
+		if err := os.MkdirAll(sub, 0755); err != nil {
+			tb.Fatal(err)
+		}
+		content := fmt.Sprintf("apiVersion: sample-app.weave.works/v1alpha1\nkind: Car\nmetadata:\n  name: car-%d\n  namespace: default\nspec:\n  brand: Volvo\n", i)
+		if err := ioutil.WriteFile(filepath.Join(sub, fmt.Sprintf("car-%d.yaml", i)), []byte(content), 0644); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// scanTree runs the initial scan of dir using the given concurrency, and returns
+// the storage and the events sent before the SYNC event
+func scanTree(tb testing.TB, dir string, concurrency int) (*GenericWatchStorage, []update.Update) {
+	opts := DefaultOptions()
+	opts.SyncEvent = true
+	opts.ScanConcurrency = concurrency
+	s, err := NewGenericWatchStorageWithOptions(storage.NewGenericStorage(
+		storage.NewGenericMappedRawStorage(dir), testSerializer, []libgitopsruntime.IdentifierFactory{libgitopsruntime.Metav1NameIdentifier},
+	), opts)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	updates := make(update.UpdateStream, 1024)
+	s.SetUpdateStream(updates)
+
+	var events []update.Update
+	for {
+		select {
+		case upd := <-updates:
+			if upd.Event == update.ObjectEventSync {
+				return s.(*GenericWatchStorage), events
+			}
+			events = append(events, upd)
+		case <-time.After(30 * time.Second):
+			tb.Fatal("timed out waiting for the initial scan")
+		}
+	}
+}
+
+func TestScanConcurrency(t *testing.T) {
+	dir := writeTestTree(t, 200)
+	defer os.RemoveAll(dir)
+
+	// A conflicting declaration of car-1, and a file without an object
+	writeTestCar(t, dir, "car-1")
+	if err := ioutil.WriteFile(filepath.Join(dir, "invalid.yaml"), []byte("foo: bar\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	serial, expected := scanTree(t, dir, 0)
+	defer serial.Close()
+
+	for _, concurrency := range []int{2, runtime.NumCPU(), 1000} {
+		s, events := scanTree(t, dir, concurrency)
+		if len(events) != len(expected) {
+			t.Fatalf("concurrency %d: expected %d events, got %d", concurrency, len(expected), len(events))
+		}
+		for i := range events {
+			if events[i].Event != expected[i].Event || !reflect.DeepEqual(events[i].PartialObject, expected[i].PartialObject) {
+				t.Errorf("concurrency %d: expected event %d to be %s %s, got %s %s", concurrency, i,
+					expected[i].Event, objectID(expected[i].PartialObject), events[i].Event, objectID(events[i].PartialObject))
+			}
+
+			// The same files are mapped for the objects
+			key, err := s.ObjectKeyFor(events[i].PartialObject)
+			if err != nil {
+				t.Fatal(err)
+			}
+			path, _ := s.RawStorage().(storage.MappedRawStorage).GetPath(key)
+			expectedPath, _ := serial.RawStorage().(storage.MappedRawStorage).GetPath(key)
+			if path != expectedPath {
+				t.Errorf("concurrency %d: expected %s to be mapped to %q, got %q", concurrency, key, expectedPath, path)
+			}
+		}
+		if !reflect.DeepEqual(s.Conflicts(), serial.Conflicts()) {
+			t.Errorf("concurrency %d: expected the conflicts %v, got %v", concurrency, serial.Conflicts(), s.Conflicts())
+		}
+		s.Close()
+	}
+}
+
+// BenchmarkInitialScan measures the initial scan of a tree of 5000 files using
+// different concurrencies, e.g. using go test -run=^$ -bench=InitialScan ./pkg/storage/watch
+func BenchmarkInitialScan(b *testing.B) {
+	dir := writeTestTree(b, 5000)
+	defer os.RemoveAll(dir)
+
+	for _, concurrency := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("concurrency=%d", concurrency), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				s, _ := scanTree(b, dir, concurrency)
+				b.StopTimer()
+				s.Close()
+				b.StartTimer()
+			}
+		})
+	}
+}
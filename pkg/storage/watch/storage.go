@@ -1,16 +1,30 @@
 package watch
 
 import (
-	"io/ioutil"
-
-	log "github.com/sirupsen/logrus"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	gosync "sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/weaveworks/libgitops/pkg/logs"
 	"github.com/weaveworks/libgitops/pkg/runtime"
 	"github.com/weaveworks/libgitops/pkg/serializer"
 	"github.com/weaveworks/libgitops/pkg/storage"
 	"github.com/weaveworks/libgitops/pkg/storage/watch/update"
+	"github.com/weaveworks/libgitops/pkg/util/clock"
+	"github.com/weaveworks/libgitops/pkg/util/filesystem"
 	"github.com/weaveworks/libgitops/pkg/util/sync"
 	"github.com/weaveworks/libgitops/pkg/util/watcher"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 )
 
@@ -27,6 +41,26 @@ func NewManifestStorage(manifestDir string, ser serializer.Serializer) (update.E
 	)
 }
 
+// NewMultiDirManifestStorage is the same as NewManifestStorage, but watches all the given
+// manifest directories using one GenericWatchStorage and one event stream. Files with the
+// same relative path in different directories are distinguished by their absolute paths.
+func NewMultiDirManifestStorage(manifestDirs []string, ser serializer.Serializer) (update.EventStorage, error) {
+	if len(manifestDirs) == 0 {
+		return nil, fmt.Errorf("at least one manifest directory is required")
+	}
+
+	opts := DefaultOptions()
+	opts.AdditionalDirs = manifestDirs[1:]
+	return NewGenericWatchStorageWithOptions(
+		storage.NewGenericStorage(
+			storage.NewGenericMappedRawStorage(manifestDirs[0]),
+			ser,
+			[]runtime.IdentifierFactory{runtime.Metav1NameIdentifier},
+		),
+		opts,
+	)
+}
+
 // NewGenericWatchStorage is an extended Storage implementation, which provides a watcher
 // for watching changes in the directory managed by the embedded Storage's RawStorage.
 // If the RawStorage is a MappedRawStorage instance, it's mappings will automatically
@@ -34,211 +68,953 @@ func NewManifestStorage(manifestDir string, ser serializer.Serializer) (update.E
 // Note: This WatchStorage only works for one-frame files (i.e. only one YAML document
 // per file is supported).
 func NewGenericWatchStorage(s storage.Storage) (update.EventStorage, error) {
+	return NewGenericWatchStorageWithOptions(s, DefaultOptions())
+}
+
+// NewGenericWatchStorageWithOptions is the same as NewGenericWatchStorage, but allows
+// customizing the behavior of the GenericWatchStorage using the given Options.
+func NewGenericWatchStorageWithOptions(s storage.Storage, opts Options) (update.EventStorage, error) {
 	ws := &GenericWatchStorage{
-		Storage: s,
+		Storage:       s,
+		opts:          opts,
+		logger:        logs.OrDefault(opts.Logger).WithName("GenericWatchStorage"),
+		clock:         clock.OrDefault(opts.Clock),
+		tracker:       newPathTracker(),
+		previous:      newObjectCache(),
+		revisions:     newRevisionCounter(),
+		log:           newEventLog(opts.EventLogSize),
+		subscriptions: newSubscriptions(),
+		stop:          make(chan struct{}),
+		streamSet:     make(chan struct{}),
+		closing:       make(chan struct{}),
+		resyncs:       make(chan chan struct{}),
+	}
+	if opts.ExpiryReaperInterval > 0 {
+		ws.expiries = newExpiryIndex()
+	}
+	if opts.PerIDRateLimit > 0 {
+		ws.limiter = newRateLimiter(opts.PerIDRateLimit, ws.clock, ws.emitEvent)
 	}
 
 	var err error
 	var files []string
-	if ws.watcher, files, err = watcher.NewFileWatcher(s.RawStorage().WatchDir()); err != nil {
+	dirs := append([]string{s.RawStorage().WatchDir()}, opts.AdditionalDirs...)
+	watcherOpts := watcher.DefaultOptions()
+	watcherOpts.PathExcluders = opts.PathExcluders
+	watcherOpts.Logger = opts.Logger
+	watcherOpts.Clock = opts.Clock
+	if ws.watcher, files, err = watcher.NewMultiDirFileWatcher(dirs, watcherOpts); err != nil {
 		return nil, err
 	}
 
 	ws.monitor = sync.RunMonitor(func() {
 		ws.monitorFunc(ws.RawStorage(), files) // Offload the file registration to the goroutine
 	})
+	if opts.ExpiryReaperInterval > 0 {
+		ws.reaper = sync.RunMonitor(ws.runReaper)
+	}
 
 	return ws, nil
 }
 
+// GVKResolver resolves the GroupVersionKind of the object stored in the file at the given path.
+// If ok is false, the GroupVersionKind couldn't be resolved.
+type GVKResolver func(path string) (gvk schema.GroupVersionKind, ok bool)
+
+// KeyDeriver derives the namespace and name of the object stored in the file at the given path,
+// relative to the watched directory containing it. If ok is false, the key couldn't be derived.
+type KeyDeriver func(relPath string) (namespace, name string, ok bool)
+
+// ErrKeyMismatch is returned if the namespace or name of an object differs from
+// the ones derived from the path of its file using Options.KeyDeriver.
+var ErrKeyMismatch = errors.New("object key doesn't match the key derived from its path")
+
+// GVKFilter decides whether the objects of the given GroupVersionKind are watched
+type GVKFilter func(gvk schema.GroupVersionKind) bool
+
+// ErrKindExcluded is returned for files declaring objects of kinds rejected by Options.GVKFilter
+var ErrKindExcluded = errors.New("object kind is excluded")
+
+// DefaultMaxFileSize is the maximum size of the files read by the GenericWatchStorage, unless
+// configured otherwise using Options.MaxFileSize. It's the same as the one of the raw storages.
+const DefaultMaxFileSize = filesystem.DefaultMaxFileSize
+
+// ErrFileTooLarge is returned for files larger than Options.MaxFileSize. It's the same error as
+// the raw storages return for files larger than storage.RawStorageOptions.MaxFileSize.
+var ErrFileTooLarge = filesystem.ErrFileTooLarge
+
+// Options specifies options for the GenericWatchStorage
+type Options struct {
+	// FallbackGVK is consulted for files that don't specify apiVersion and kind themselves,
+	// e.g. in repositories where the directory of a file implies its type. (Default: nil)
+	FallbackGVK GVKResolver
+	// KeyDeriver is consulted for all files, e.g. in repositories where the path of a file
+	// implies the namespace and name of its object. Files that don't set metadata.name get
+	// the derived namespace and name assigned, while for the others the derived key must
+	// match the one in the file, otherwise the file is ignored with ErrKeyMismatch. (Default: nil)
+	KeyDeriver KeyDeriver
+	// GVKFilter is consulted for all files before decoding their objects, and files declaring
+	// objects of the kinds it rejects are ignored with ErrKindExcluded. Only the apiVersion and
+	// kind of the files are read for this (see runtime.RecognizeGVK), so this is cheap even for
+	// large files. The GroupVersionKind is resolved using FallbackGVK, if needed. (Default: nil)
+	GVKFilter GVKFilter
+	// AdditionalDirs specifies directories to watch in addition to the RawStorage's
+	// WatchDir. This is only useful for MappedRawStorages, as the objects found in
+	// these directories are mapped to their files. The directories may not overlap.
+	// (Default: nil)
+	AdditionalDirs []string
+	// EventFilters are consulted for all ObjectEvents before they're sent to the update stream,
+	// including the ones for the initial scan. An event is only sent if all filters accept it.
+	// The PartialObject of the Update has its GroupVersionKind resolved, but DELETE events only
+	// carry the GroupVersionKind and UID of the deleted object. (Default: nil)
+	EventFilters []update.EventFilter
+	// PreviousObject specifies whether to decode the objects of all CREATE and MODIFY events
+	// into update.Update.Object, and to attach the previously sent version of the object to
+	// MODIFY events as update.Update.PreviousObject. This keeps the last decoded version of
+	// every object in memory. Objects are decoded after applying the EventFilters, so the
+	// previous object is the last version that passed the filters. (Default: false)
+	PreviousObject bool
+	// SyncEvent specifies whether to send an ObjectEventSync after the events for all objects
+	// found by the initial scan, which tells consumers that they've seen the whole state of the
+	// watched directories. The update of the SYNC event has no PartialObject, and bypasses the
+	// EventFilters. To not lose any events, the initial scan is deferred until SetUpdateStream
+	// is called, and objects can't be read from the storage before that. (Default: false)
+	SyncEvent bool
+	// CompareContent specifies whether to compare the content of a modified file byte by byte
+	// with its previous content, when their checksums match. Modifications not changing the
+	// content of a file are never sent, but are only detected by the checksum by default. This
+	// keeps the content of all tracked files in memory. (Default: false)
+	CompareContent bool
+	// PerIDRateLimit specifies the minimum interval between two events for the same object, if
+	// positive. The events received for an object within the interval are collapsed into one,
+	// which carries the latest state of the object, and is sent when the interval ends. This
+	// avoids flooding consumers when e.g. a git pull rewrites many files. (Default: 0)
+	PerIDRateLimit time.Duration
+	// PathExcluders specify files and directories in the watched directories to ignore, e.g. using
+	// a watcher.GitignoreExcluder. They're also applied by Import. (Default: nil)
+	PathExcluders []watcher.PathExcluder
+	// ScanConcurrency specifies how many files are read and decoded in parallel by the initial
+	// scan, if greater than one. The mappings are still registered and the events sent in the
+	// order of the files, so the result doesn't depend on it. FallbackGVK and KeyDeriver must
+	// be safe for concurrent use when setting this. (Default: 0, which scans the files serially)
+	ScanConcurrency int
+	// ExpiryReaperInterval specifies how often to delete the objects whose ExpiresAtAnnotation
+	// has passed, if positive. The expiries are recorded when the files are loaded or modified,
+	// so the reaper doesn't read any files. It removes the files through the embedded Storage, so
+	// the DELETE events are sent like for any other removed file. (Default: 0, which disables
+	// the reaper)
+	ExpiryReaperInterval time.Duration
+	// EventLogSize specifies how many of the latest events to keep for subscriptions resuming from
+	// a sequence number, see WithResumeFrom and update.Update.Sequence. The events are kept in memory,
+	// including their objects. If positive, the events are sequenced and kept even while there are no
+	// consumers. (Default: 0, which only allows resuming from the last sequence number)
+	EventLogSize int
+	// MaxFileSize specifies the maximum size of the files read by the GenericWatchStorage, in bytes.
+	// Larger files are ignored with ErrFileTooLarge without reading them into memory, which protects
+	// against e.g. a huge file accidentally committed to the watched repository. (Default: 0, which
+	// uses DefaultMaxFileSize, while a negative value disables the limit)
+	MaxFileSize int64
+	// Clock is the source of time for PerIDRateLimit, ExpiryReaperInterval and the expiry of the
+	// objects, as well as for the debouncing of the FileWatcher, e.g. a clock.FakeClock for tests.
+	// (Default: nil, which uses clock.RealClock)
+	Clock clock.Clock
+	// Logger receives the logs of the GenericWatchStorage and its FileWatcher, with the path,
+	// objectID and eventType of the file or object concerned as key/value pairs. (Default: nil,
+	// which logs to logs.Logger, see logs.NewLogger)
+	Logger logr.Logger
+}
+
+// DefaultOptions returns the default options for the GenericWatchStorage
+func DefaultOptions() Options {
+	return Options{}
+}
+
 // EventDeleteObjectName is used as the name of an object sent to the
 // GenericWatchStorage's event stream when the the object has been deleted
 const EventDeleteObjectName = "<deleted>"
 
 // GenericWatchStorage implements the WatchStorage interface
 type GenericWatchStorage struct {
+	// counters must be the first field, see the counters type
+	counters counters
 	storage.Storage
 	watcher *watcher.FileWatcher
-	events  update.UpdateStream
-	monitor *sync.Monitor
+	// events is the update stream set by SetUpdateStream, guarded by eventsMux
+	events    update.UpdateStream
+	eventsMux gosync.RWMutex
+	monitor   *sync.Monitor
+	// reaper deletes the expired objects, if opts.ExpiryReaperInterval is set
+	reaper *sync.Monitor
+	// expiries holds the expiries of the objects for the reaper, if opts.ExpiryReaperInterval is set
+	expiries *expiryIndex
+	opts     Options
+	logger   logr.Logger
+	clock    clock.Clock
+	tracker  *pathTracker
+	// previous holds the last sent objects, if opts.PreviousObject is set
+	previous *objectCache
+	// revisions assigns the revisions of the events
+	revisions *revisionCounter
+	// log assigns the sequence numbers of the events, and keeps the latest opts.EventLogSize of them
+	log *eventLog
+	// subscriptions holds the subscriptions registered using WatchKind
+	subscriptions *subscriptions
+	// limiter holds back the events exceeding opts.PerIDRateLimit, if set
+	limiter *rateLimiter
+	// stop is closed when pending events should be dropped instead of sent
+	stop chan struct{}
+	// streamSet is closed when the update stream has been set for the first time
+	streamSet     chan struct{}
+	streamSetOnce gosync.Once
+	// closing is closed when the storage starts closing
+	closing   chan struct{}
+	closeOnce gosync.Once
+	// resyncs receives the requests of Resync, which are completed by closing the channel
+	resyncs chan chan struct{}
 }
 
 var _ update.EventStorage = &GenericWatchStorage{}
 
 // Suspend modify events during Create
 func (s *GenericWatchStorage) Create(obj runtime.Object) error {
-	s.watcher.Suspend(watcher.FileEventModify)
-	return s.Storage.Create(obj)
+	if err := s.suspend(watcher.FileEventModify); err != nil {
+		return err
+	}
+	if err := s.Storage.Create(obj); err != nil {
+		return err
+	}
+
+	// As the modify event is suspended, start tracking the created file here
+	if key, err := s.Storage.ObjectKeyFor(obj); err == nil {
+		s.trackKey(key)
+	}
+	return nil
+}
+
+// trackKey updates the tracked file mapped by a MappedRawStorage for the given key
+// after it has been written by the storage, as the write caused no event doing so
+func (s *GenericWatchStorage) trackKey(key storage.ObjectKey) {
+	raw := s.RawStorage()
+	mapped, ok := raw.(storage.MappedRawStorage)
+	if !ok {
+		return
+	}
+
+	path, err := mapped.GetPath(key)
+	if err != nil {
+		return
+	}
+
+	partObj, checksum, err := s.readFile(path)
+	if err != nil {
+		s.ignoreFile(path, err)
+		return
+	}
+
+	s.addMapping(raw, partObj, path, checksum)
 }
 
 // Suspend modify events during Update
 func (s *GenericWatchStorage) Update(obj runtime.Object) error {
-	s.watcher.Suspend(watcher.FileEventModify)
-	return s.Storage.Update(obj)
+	if err := s.suspend(watcher.FileEventModify); err != nil {
+		return err
+	}
+	if err := s.Storage.Update(obj); err != nil {
+		return err
+	}
+
+	key, err := s.Storage.ObjectKeyFor(obj)
+	if err != nil {
+		return err
+	}
+	s.trackWritten(key)
+	return nil
 }
 
 // Suspend modify events during Patch
 func (s *GenericWatchStorage) Patch(key storage.ObjectKey, patch []byte) error {
-	s.watcher.Suspend(watcher.FileEventModify)
-	return s.Storage.Patch(key, patch)
+	if err := s.suspend(watcher.FileEventModify); err != nil {
+		return err
+	}
+	if err := s.Storage.Patch(key, patch); err != nil {
+		return err
+	}
+
+	s.trackWritten(key)
+	return nil
+}
+
+// Suspend modify events during PatchWithType
+func (s *GenericWatchStorage) PatchWithType(key storage.ObjectKey, patchType types.PatchType, patch []byte) error {
+	if err := s.suspend(watcher.FileEventModify); err != nil {
+		return err
+	}
+	if err := s.Storage.PatchWithType(key, patchType, patch); err != nil {
+		return err
+	}
+
+	s.trackWritten(key)
+	return nil
 }
 
 // Suspend delete events during Delete
 func (s *GenericWatchStorage) Delete(key storage.ObjectKey) error {
-	s.watcher.Suspend(watcher.FileEventDelete)
-	return s.Storage.Delete(key)
+	if err := s.suspend(watcher.FileEventDelete); err != nil {
+		return err
+	}
+	path, tracked := s.tracker.winner(key)
+	if err := s.Storage.Delete(key); err != nil {
+		return err
+	}
+
+	// With finalizers, the Storage may only have set the deletionTimestamp of the object.
+	// Let the caused modify event through, so subscribers learn about the pending deletion.
+	if s.RawStorage().Exists(key) {
+		s.watcher.Suspend(watcher.FileEventNone)
+		return nil
+	}
+
+	// As the delete event is suspended, stop tracking the deleted file here
+	if tracked {
+		s.removeMapping(s.RawStorage(), path)
+	}
+	s.previous.forget(key)
+	return nil
+}
+
+// trackWritten tracks the file for key after it has been modified by the storage. If the file was
+// removed by the write instead, which happens when the Storage finalizes an object being deleted,
+// the modify event is no longer suspended. The caused delete event is then sent to the update
+// stream, and removes the mapping of the file like for any other deletion.
+func (s *GenericWatchStorage) trackWritten(key storage.ObjectKey) {
+	if s.RawStorage().Exists(key) {
+		s.trackKey(key)
+		return
+	}
+
+	s.watcher.Suspend(watcher.FileEventNone)
+}
+
+// suspend suspends the given event for the next write. Writes to a read-only
+// Storage are rejected here, as the never-written file would otherwise cause
+// the next real event to be skipped.
+func (s *GenericWatchStorage) suspend(event watcher.FileEvent) error {
+	if storage.IsReadOnly(s.Storage) {
+		return fmt.Errorf("GenericWatchStorage: %w", storage.ErrReadOnly)
+	}
+
+	s.watcher.Suspend(event)
+	return nil
 }
 
 func (s *GenericWatchStorage) SetUpdateStream(eventStream update.UpdateStream) {
+	s.eventsMux.Lock()
 	s.events = eventStream
+	s.eventsMux.Unlock()
+	s.streamSetOnce.Do(func() { close(s.streamSet) })
+}
+
+// updateStream returns the update stream set by SetUpdateStream, or nil if none is set
+func (s *GenericWatchStorage) updateStream() update.UpdateStream {
+	s.eventsMux.RLock()
+	defer s.eventsMux.RUnlock()
+	return s.events
 }
 
+// Close stops watching for changes, and blocks until all events already
+// received from the watcher have been sent to the update stream.
 func (s *GenericWatchStorage) Close() error {
-	s.watcher.Close()
-	s.monitor.Wait()
-	return nil
+	return s.CloseContext(context.Background())
+}
+
+// CloseContext stops watching for changes, flushes all events already received
+// from the watcher to the update stream, and blocks until all goroutines of the
+// GenericWatchStorage have stopped. If ctx expires before that, the remaining
+// events are dropped, and ctx.Err() is returned after the goroutines have stopped.
+// Closing the storage again returns ErrClosed without waiting.
+func (s *GenericWatchStorage) CloseContext(ctx context.Context) error {
+	closed := true
+	s.closeOnce.Do(func() {
+		closed = false
+		close(s.closing)
+	})
+	if closed {
+		return ErrClosed
+	}
+
+	done := make(chan struct{})
+	go func() {
+		// Stop the reaper first, so that the watcher sees all of its deletions
+		s.reaper.Wait()
+		s.watcher.Close()
+		s.monitor.Wait()
+		s.subscriptions.close()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		// Unblock the monitor if the update stream is full, and drop the remaining events
+		close(s.stop)
+		<-done
+		return ctx.Err()
+	}
 }
 
 func (s *GenericWatchStorage) monitorFunc(raw storage.RawStorage, files []string) {
-	log.Debug("GenericWatchStorage: Monitoring thread started")
-	defer log.Debug("GenericWatchStorage: Monitoring thread stopped")
-	var content []byte
+	s.logger.V(logs.DebugLevel).Info("Monitoring thread started")
+	defer s.logger.V(logs.DebugLevel).Info("Monitoring thread stopped")
+
+	// Defer the initial scan until the events can be sent, so that the SYNC event
+	// is only sent after the events for all objects found by the initial scan
+	if s.opts.SyncEvent {
+		select {
+		case <-s.streamSet:
+		case <-s.closing:
+			return
+		}
+	}
 
-	// Send a MODIFY event for all files (and fill the mappings
+	// Send a CREATE event for all objects (and fill the mappings
 	// of the MappedRawStorage) before starting to monitor changes
-	for _, file := range files {
-		content, err := ioutil.ReadFile(file)
+	s.scanFiles(files, func(file string, obj runtime.PartialObject, checksum string, err error) {
 		if err != nil {
-			log.Warnf("Ignoring %q: %v", file, err)
+			s.ignoreFile(file, err)
+			return
+		}
+
+		// Add a mapping between this object and path, and send the event to the events
+		// channel, unless another file declaring the same object is used instead
+		if winner, created := s.addMapping(raw, obj, file, checksum); winner == file {
+			s.sendEvent(trackedEvent(raw, created), obj)
+		}
+	})
+
+	if s.opts.SyncEvent {
+		s.sendEvent(update.ObjectEventSync, nil)
+	}
+
+	for {
+		var event *watcher.FileUpdate
+		var ok bool
+		select {
+		case event, ok = <-s.watcher.GetFileUpdateStream():
+		case done := <-s.resyncs:
+			s.logger.V(logs.DebugLevel).Info("Resyncing all watched directories")
+			s.resync(raw)
+			close(done)
 			continue
 		}
 
-		obj, err := runtime.NewPartialObject(content)
-		if err != nil {
-			log.Warnf("Ignoring %q: %v", file, err)
+		if !ok {
+			// Don't lose the events held back by the rate limit when closing
+			if s.limiter != nil {
+				s.limiter.flush()
+			}
+			return
+		}
+
+		if l := s.logger.V(logs.TraceLevel); l.Enabled() {
+			l.Info("Processing event", "eventType", event.Event, "path", event.Path)
+		}
+		if event.Event == watcher.FileEventResync {
+			s.logger.Info("Events may have been lost, resyncing all watched directories")
+			s.resync(raw)
 			continue
 		}
 
-		// Add a mapping between this object and path
-		s.addMapping(raw, obj, file)
-		// Send the event to the events channel
-		s.sendEvent(update.ObjectEventModify, obj)
+		s.handleUpdate(raw, event)
 	}
+}
 
-	for {
-		if event, ok := <-s.watcher.GetFileUpdateStream(); ok {
-			var partObj runtime.PartialObject
-			var err error
-
-			var objectEvent update.ObjectEvent
-			switch event.Event {
-			case watcher.FileEventModify:
-				objectEvent = update.ObjectEventModify
-			case watcher.FileEventDelete:
-				objectEvent = update.ObjectEventDelete
+// Resync rescans all watched directories, and sends the ObjectEvents for all changes not seen
+// yet, e.g. when the watcher missed some of the changes made by a git pull. Objects in new
+// files are created, changed files are modified, and the objects of removed files are deleted.
+// The rescan is done by the same goroutine handling the events of the watcher, so changes seen
+// by both don't cause duplicate events. Resync blocks until the rescan is done, or ctx expires.
+func (s *GenericWatchStorage) Resync(ctx context.Context) error {
+	done := make(chan struct{})
+	select {
+	case s.resyncs <- done:
+	case <-s.closing:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// handleUpdate updates the mappings based on the given FileUpdate,
+// and sends the resulting ObjectEvent to the events channel
+func (s *GenericWatchStorage) handleUpdate(raw storage.RawStorage, event *watcher.FileUpdate) {
+	var partObj runtime.PartialObject
+	var checksum string
+	var err error
+
+	var objectEvent update.ObjectEvent
+	switch event.Event {
+	case watcher.FileEventModify:
+		objectEvent = update.ObjectEventModify
+	case watcher.FileEventDelete:
+		objectEvent = update.ObjectEventDelete
+	}
+
+	if event.Event == watcher.FileEventDelete {
+		// Remove the mapping for this file as it's now deleted
+		key, winner, tracked := s.removeMapping(raw, event.Path)
+		if !tracked {
+			if _, mapped := raw.(storage.MappedRawStorage); mapped {
+				// The file didn't declare any object, e.g. it was a temporary file
+				s.logger.V(logs.DebugLevel).Info("Ignoring deletion of untracked file", "path", event.Path)
+				return
 			}
 
-			log.Tracef("GenericWatchStorage: Processing event: %s", event.Event)
-			if event.Event == watcher.FileEventDelete {
-				key, err := raw.GetKey(event.Path)
-				if err != nil {
-					log.Warnf("Failed to retrieve data for %q: %v", event.Path, err)
-					continue
-				}
-
-				// This creates a "fake" Object from the key to be used for
-				// deletion, as the original has already been removed from disk
-				apiVersion, kind := key.GetGVK().ToAPIVersionAndKind()
-				partObj = &runtime.PartialObjectImpl{
-					TypeMeta: metav1.TypeMeta{
-						APIVersion: apiVersion,
-						Kind:       kind,
-					},
-					ObjectMeta: metav1.ObjectMeta{
-						Name: EventDeleteObjectName,
-						// TODO: This doesn't take into account where e.g. the identifier is "{namespace}/{name}"
-						UID: types.UID(key.GetIdentifier()),
-					},
-				}
-				// remove the mapping for this key as it's now deleted
-				s.removeMapping(raw, key)
-			} else {
-				content, err = ioutil.ReadFile(event.Path)
-				if err != nil {
-					log.Warnf("Ignoring %q: %v", event.Path, err)
-					continue
-				}
-
-				if partObj, err = runtime.NewPartialObject(content); err != nil {
-					log.Warnf("Ignoring %q: %v", event.Path, err)
-					continue
-				}
-
-				if event.Event == watcher.FileEventMove {
-					// Update the mappings for the moved file (AddMapping overwrites)
-					s.addMapping(raw, partObj, event.Path)
-
-					// Internal move events are a no-op
-					continue
-				}
-
-				// This is based on the key's existence instead of watcher.EventCreate,
-				// as Objects can get updated (via watcher.FileEventModify) to be conformant
-				if _, err = raw.GetKey(event.Path); err != nil {
-					// Add a mapping between this object and path
-					s.addMapping(raw, partObj, event.Path)
-
-					// This is what actually determines if an Object is created,
-					// so update the event to update.ObjectEventCreate here
-					objectEvent = update.ObjectEventCreate
-				}
+			if key, err = raw.GetKey(event.Path); err != nil {
+				s.logger.Error(err, "Failed to retrieve data", "path", event.Path)
+				return
 			}
+		}
 
-			// Send the objectEvent to the events channel
-			if objectEvent != update.ObjectEventNone {
-				s.sendEvent(objectEvent, partObj)
+		if len(winner) != 0 {
+			// Another file declares the same object, so it still exists
+			if partObj, _, err = s.readFile(winner); err != nil {
+				s.ignoreFile(winner, err)
+				return
 			}
+			objectEvent = update.ObjectEventModify
 		} else {
+			s.previous.forget(key)
+
+			// This creates a "fake" Object from the key to be used for
+			// deletion, as the original has already been removed from disk
+			apiVersion, kind := key.GetGVK().ToAPIVersionAndKind()
+			partObj = &runtime.PartialObjectImpl{
+				TypeMeta: metav1.TypeMeta{
+					APIVersion: apiVersion,
+					Kind:       kind,
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name: EventDeleteObjectName,
+					// TODO: This doesn't take into account where e.g. the identifier is "{namespace}/{name}"
+					UID: types.UID(key.GetIdentifier()),
+				},
+			}
+		}
+	} else {
+		// Get the previous state of the file before reading it updates the tracked content
+		oldChecksum, oldContent, tracked := s.tracker.state(event.Path)
+		if partObj, checksum, err = s.readFile(event.Path); err != nil {
+			s.ignoreFile(event.Path, err)
 			return
 		}
+
+		if event.Event == watcher.FileEventMove {
+			// Update the mappings for the moved file
+			s.moveMapping(raw, partObj, event.OldPath, event.Path, checksum)
+			return
+		}
+
+		if _, mapped := raw.(storage.MappedRawStorage); mapped {
+			// This is based on whether the object was declared before instead of watcher.EventCreate,
+			// as Objects can get updated (via watcher.FileEventModify) to be conformant
+			winner, created := s.addMapping(raw, partObj, event.Path, checksum)
+			if winner != event.Path {
+				// Another file declaring the same object is used instead, ignore this one
+				return
+			}
+			if created {
+				objectEvent = update.ObjectEventCreate
+			} else if tracked && s.unchanged(event.Path, oldChecksum, oldContent, checksum) {
+				// The file was only touched or rewritten with the same content, don't wake up consumers
+				atomic.AddUint64(&s.counters.unchanged, 1)
+				s.logger.V(logs.DebugLevel).Info("Skipping event for unchanged file", "path", event.Path)
+				return
+			}
+		} else if _, err = raw.GetKey(event.Path); err != nil {
+			// This is what actually determines if an Object is created,
+			// so update the event to update.ObjectEventCreate here
+			objectEvent = update.ObjectEventCreate
+		}
+	}
+
+	// Send the objectEvent to the events channel
+	if objectEvent != update.ObjectEventNone {
+		s.sendEvent(objectEvent, partObj)
+	}
+}
+
+// resync rescans all watched directories, e.g. after the watcher lost events. All present
+// files are handled as modified, and files that are no longer present as deleted, which
+// rebuilds the mappings and sends the ObjectEvents for all changed objects. The deletions
+// are handled last, so that objects moved to another file are modified, not re-created.
+func (s *GenericWatchStorage) resync(raw storage.RawStorage) {
+	files, err := s.watcher.Files()
+	if err != nil {
+		s.logger.Error(err, "Failed to resync")
+		return
+	}
+
+	present := make(map[string]bool, len(files))
+	for _, file := range files {
+		present[file] = true
+	}
+
+	for _, file := range files {
+		s.handleUpdate(raw, &watcher.FileUpdate{Event: watcher.FileEventModify, Path: file})
+	}
+
+	for _, path := range s.tracker.trackedPaths() {
+		if !present[path] {
+			s.handleUpdate(raw, &watcher.FileUpdate{Event: watcher.FileEventDelete, Path: path})
+		}
+	}
+}
+
+// unchanged returns true if the file at path still has the given old checksum. If opts.CompareContent
+// is set, the tracked content of the file is compared with the old content as well.
+func (s *GenericWatchStorage) unchanged(path, oldChecksum string, oldContent []byte, checksum string) bool {
+	if oldChecksum != checksum {
+		return false
+	}
+	if !s.opts.CompareContent {
+		return true
+	}
+
+	_, content, _ := s.tracker.state(path)
+	return bytes.Equal(oldContent, content)
+}
+
+// readFile reads the file at the given path, and recognizes the object it contains.
+// The returned checksum of the file content is used to detect changes of moved files.
+func (s *GenericWatchStorage) readFile(path string) (runtime.PartialObject, string, error) {
+	content, err := s.readContent(path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err := s.filterGVK(path, content); err != nil {
+		return nil, "", err
+	}
+
+	obj, err := s.recognize(path, content)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if s.opts.CompareContent {
+		s.tracker.setContent(path, content)
+	}
+
+	sum := sha256.Sum256(content)
+	return obj, hex.EncodeToString(sum[:]), nil
+}
+
+// readContent reads the content of the file at the given path, or returns ErrFileTooLarge
+// without reading more than opts.MaxFileSize bytes if it's larger than that
+func (s *GenericWatchStorage) readContent(path string) ([]byte, error) {
+	maxSize := s.opts.MaxFileSize
+	if maxSize == 0 {
+		maxSize = DefaultMaxFileSize
+	}
+	// The watched files are always on the local disk
+	return filesystem.ReadFileLimited(filesystem.NewOSFilesystem(), path, maxSize)
+}
+
+// filterGVK returns ErrKindExcluded if opts.GVKFilter rejects the kind of the given file content
+func (s *GenericWatchStorage) filterGVK(path string, content []byte) error {
+	if s.opts.GVKFilter == nil {
+		return nil
+	}
+
+	gvk, err := runtime.RecognizeGVK(content)
+	if err != nil {
+		return err
+	}
+	if gvk.Empty() && s.opts.FallbackGVK != nil {
+		gvk, _ = s.opts.FallbackGVK(path)
+	}
+
+	if !s.opts.GVKFilter(gvk) {
+		return fmt.Errorf("%w: %s", ErrKindExcluded, gvk)
+	}
+	return nil
+}
+
+// recognize decodes the TypeMeta and ObjectMeta of the given file content. If the content doesn't
+// specify its apiVersion and kind, the GroupVersionKind is resolved using opts.FallbackGVK. The
+// namespace and name are derived from the path using opts.KeyDeriver, if set.
+func (s *GenericWatchStorage) recognize(path string, content []byte) (runtime.PartialObject, error) {
+	obj, err := runtime.NewPartialObject(content)
+	if err != nil {
+		return nil, err
+	}
+
+	if obj.GetObjectKind().GroupVersionKind().Empty() && s.opts.FallbackGVK != nil {
+		if gvk, ok := s.opts.FallbackGVK(path); ok {
+			obj.GetObjectKind().SetGroupVersionKind(gvk)
+		}
+	}
+
+	if s.opts.KeyDeriver != nil {
+		if err := s.deriveKey(obj, path); err != nil {
+			return nil, err
+		}
 	}
+
+	return obj, nil
+}
+
+// deriveKey assigns the namespace and name derived from the path to obj if it has no name,
+// otherwise it validates that the derived namespace and name match the ones of obj
+func (s *GenericWatchStorage) deriveKey(obj runtime.PartialObject, path string) error {
+	namespace, name, ok := s.opts.KeyDeriver(s.relPath(path))
+	if !ok {
+		return nil
+	}
+
+	if len(obj.GetName()) == 0 {
+		obj.SetNamespace(namespace)
+		obj.SetName(name)
+		return nil
+	}
+
+	if obj.GetName() != name || (len(obj.GetNamespace()) != 0 && obj.GetNamespace() != namespace) {
+		return fmt.Errorf("%w: %s/%s in file, %s/%s derived from path", ErrKeyMismatch, obj.GetNamespace(), obj.GetName(), namespace, name)
+	}
+
+	// Files may leave the namespace implied by their path
+	obj.SetNamespace(namespace)
+	return nil
+}
+
+// relPath returns the given path relative to the watched directory containing it
+func (s *GenericWatchStorage) relPath(path string) string {
+	for _, dir := range s.watcher.Dirs() {
+		if rel, err := filepath.Rel(dir, path); err == nil && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return rel
+		}
+	}
+
+	return path
+}
+
+// ignoreFile logs that the file at the given path is ignored due to err
+func (s *GenericWatchStorage) ignoreFile(path string, err error) {
+	atomic.AddUint64(&s.counters.ignored, 1)
+	s.tracker.forgetContent(path)
+	if errors.Is(err, ErrKindExcluded) {
+		// Excluding files is intended, so don't flood the logs with errors
+		s.logger.V(logs.DebugLevel).Info("Ignoring file", "path", path, "reason", err.Error())
+		return
+	}
+	s.logger.Error(err, "Ignoring file", "path", path)
 }
 
 func (s *GenericWatchStorage) sendEvent(event update.ObjectEvent, partObj runtime.PartialObject) {
-	if s.events != nil {
-		log.Tracef("GenericWatchStorage: Sending event: %v", event)
-		s.events <- update.Update{
-			Event:         event,
-			PartialObject: partObj,
-			Storage:       s,
+	s.indexExpiry(event, partObj)
+
+	// Keep logging the events for subscriptions resuming later
+	if s.updateStream() == nil && s.subscriptions.empty() && s.opts.EventLogSize <= 0 {
+		return
+	}
+
+	if s.limiter != nil && event != update.ObjectEventSync {
+		key, err := s.eventKey(event, partObj)
+		if err == nil {
+			s.limiter.limit(key, event, partObj)
+			return
 		}
+		s.logger.Error(err, "Not rate limiting the event", "eventType", event, "name", partObj.GetName())
 	}
+
+	s.emitEvent(event, partObj)
+}
+
+// emitEvent sends the given event to the update stream and subscriptions, bypassing the rate limit
+func (s *GenericWatchStorage) emitEvent(event update.ObjectEvent, partObj runtime.PartialObject) {
+	upd := update.Update{
+		Event:         event,
+		PartialObject: partObj,
+		Storage:       s,
+	}
+	s.setRevision(&upd)
+
+	for _, filter := range s.opts.EventFilters {
+		if event == update.ObjectEventSync {
+			break
+		}
+		if !filter(upd) {
+			atomic.AddUint64(&s.counters.filtered, 1)
+			if l := s.logger.V(logs.TraceLevel); l.Enabled() {
+				l.Info("Filtered event", "eventType", event, "objectID", objectID(partObj))
+			}
+			return
+		}
+	}
+
+	if s.opts.PreviousObject && event != update.ObjectEventDelete {
+		s.attachObjects(&upd)
+	}
+
+	// Assign the sequence number and pick the subscriptions atomically, so that subscriptions
+	// resuming don't miss any events, but release the lock before blocking on the consumers
+	s.log.send.Lock()
+	defer s.log.send.Unlock()
+	s.log.mux.Lock()
+	s.log.append(&upd)
+	subs := s.subscriptions.list()
+	s.log.mux.Unlock()
+
+	s.publish(upd, subs)
+	events := s.updateStream()
+	if events == nil {
+		return
+	}
+
+	if l := s.logger.V(logs.TraceLevel); l.Enabled() {
+		l.Info("Sending event", "eventType", event, "objectID", objectID(partObj))
+	}
+	select {
+	case events <- upd:
+		atomic.AddUint64(&s.counters.sent, 1)
+	case <-s.stop:
+		atomic.AddUint64(&s.counters.dropped, 1)
+		s.logger.Info("Dropping event, as the storage is closed", "eventType", event, "objectID", objectID(partObj))
+	}
+}
+
+// objectID returns the identifier of the given object to log, which is carried by the UID of the
+// PartialObjects of DELETE events. SYNC events have no object, so their identifier is empty.
+func objectID(obj runtime.PartialObject) string {
+	switch {
+	case obj == nil:
+		return ""
+	case obj.GetName() == EventDeleteObjectName:
+		return string(obj.GetUID())
+	case len(obj.GetNamespace()) != 0:
+		return obj.GetNamespace() + "/" + obj.GetName()
+	}
+	return obj.GetName()
+}
+
+// trackedEvent returns the event to send for an object which has been mapped to a file. Only
+// objects which weren't declared by any tracked file before are created, others are modified.
+// Without a MappedRawStorage, objects aren't tracked, and are always reported as created.
+func trackedEvent(raw storage.RawStorage, created bool) update.ObjectEvent {
+	if _, mapped := raw.(storage.MappedRawStorage); mapped && !created {
+		return update.ObjectEventModify
+	}
+	return update.ObjectEventCreate
 }
 
 // addMapping registers a mapping between the given object and the specified path, if raw is a
-// MappedRawStorage. If a given mapping already exists between this object and some path, it
-// will be overridden with the specified new path
-func (s *GenericWatchStorage) addMapping(raw storage.RawStorage, obj runtime.Object, file string) {
+// MappedRawStorage. If multiple files declare the same object, the lexicographically first path
+// is mapped, and the conflict is reported by Conflicts. winner is the path mapped for the object,
+// and created is true if the object wasn't declared by any file before.
+func (s *GenericWatchStorage) addMapping(raw storage.RawStorage, obj runtime.Object, file, checksum string) (winner string, created bool) {
 	mapped, ok := raw.(storage.MappedRawStorage)
 	if !ok {
-		return
+		return file, false
 	}
 
 	// Let the embedded storage decide using its identifiers how to
 	key, err := s.Storage.ObjectKeyFor(obj)
 	if err != nil {
-		log.Errorf("couldn't get object key for: gvk=%s, uid=%s, name=%s", obj.GetObjectKind().GroupVersionKind(), obj.GetUID(), obj.GetName())
+		s.logger.Error(err, "Couldn't get the object key", "path", file, "gvk", obj.GetObjectKind().GroupVersionKind(), "uid", obj.GetUID(), "name", obj.GetName())
+		return "", false
 	}
 
-	mapped.AddMapping(key, file)
+	// If the file declared another object earlier, that object is no longer declared by it
+	if oldKey, ok := s.tracker.keyFor(file); ok && oldKey != key {
+		s.removeMapping(raw, file)
+	}
+
+	winner, created = s.tracker.add(key, file, checksum)
+	if winner != file {
+		s.logger.Info("Multiple files declare the object, using the first", "objectID", key.GetIdentifier(), "path", winner, "ignoredPath", file)
+	}
+
+	mapped.AddMapping(key, winner)
+	return
+}
+
+// moveMapping updates the mappings for a file moved from oldPath to path. If the file still
+// declares the same object, its mapping is updated atomically, and a MODIFY event is only
+// sent if the content of the file mapped for the object changed. Otherwise the move is
+// handled as a deletion of oldPath, followed by a modification of path.
+func (s *GenericWatchStorage) moveMapping(raw storage.RawStorage, obj runtime.PartialObject, oldPath, path, checksum string) {
+	mapped, ok := raw.(storage.MappedRawStorage)
+	if !ok {
+		return // Internal move events are a no-op
+	}
+
+	key, err := s.Storage.ObjectKeyFor(obj)
+	if err != nil {
+		s.logger.Error(err, "Couldn't get the object key", "path", path, "gvk", obj.GetObjectKind().GroupVersionKind(), "uid", obj.GetUID(), "name", obj.GetName())
+		return
+	}
+
+	// If the file was moved over another file, the object declared by that file is gone
+	if overwrittenKey, ok := s.tracker.keyFor(path); ok && overwrittenKey != key {
+		s.handleUpdate(raw, &watcher.FileUpdate{Event: watcher.FileEventDelete, Path: path})
+	}
+
+	if oldKey, ok := s.tracker.keyFor(oldPath); !ok || oldKey != key {
+		s.handleUpdate(raw, &watcher.FileUpdate{Event: watcher.FileEventDelete, Path: oldPath})
+		s.handleUpdate(raw, &watcher.FileUpdate{Event: watcher.FileEventModify, Path: path})
+		return
+	}
+
+	winner, changed := s.tracker.move(key, oldPath, path, checksum)
+	mapped.AddMapping(key, winner)
+	s.logger.V(logs.DebugLevel).Info("Object moved", "objectID", key.GetIdentifier(), "oldPath", oldPath, "path", path)
+
+	if !changed {
+		return
+	}
+
+	if winner != path {
+		if obj, _, err = s.readFile(winner); err != nil {
+			s.ignoreFile(winner, err)
+			return
+		}
+	}
+	s.sendEvent(update.ObjectEventModify, obj)
 }
 
-// removeMapping removes a mapping a file that doesn't exist
-func (s *GenericWatchStorage) removeMapping(raw storage.RawStorage, key storage.ObjectKey) {
+// removeMapping stops tracking the file at the given path. If another file declares the same
+// object, the object is mapped to that file instead, otherwise the mapping is removed. key is
+// the object the file declared, and winner the path now mapped for it. tracked is false if the
+// file wasn't known.
+func (s *GenericWatchStorage) removeMapping(raw storage.RawStorage, path string) (key storage.ObjectKey, winner string, tracked bool) {
+	if key, winner, tracked = s.tracker.remove(path); !tracked {
+		return
+	}
+
 	mapped, ok := raw.(storage.MappedRawStorage)
 	if !ok {
 		return
 	}
 
-	mapped.RemoveMapping(key)
+	if len(winner) != 0 {
+		mapped.AddMapping(key, winner)
+	} else {
+		mapped.RemoveMapping(key)
+	}
+	return
+}
+
+// Conflicts returns all objects declared by more than one file, together with the sorted
+// paths of those files. The first path is the one in use for the object, the other files
+// are ignored until it is removed.
+func (s *GenericWatchStorage) Conflicts() map[storage.ObjectKey][]string {
+	return s.tracker.conflicts()
 }
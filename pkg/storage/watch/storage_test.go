@@ -0,0 +1,1263 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	gosync "sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/scheme"
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/serializer"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/storage/watch/update"
+	"go.uber.org/goleak"
+	kruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var testSerializer = serializer.NewSerializer(kruntime.NewScheme(), nil)
+
+// globalGoroutines ignores the goroutines living for the whole process, started
+// by klog on init and by the underlying notify library on first use
+var globalGoroutines = []goleak.Option{
+	goleak.IgnoreTopFunction("k8s.io/klog.(*loggingT).flushDaemon"),
+	goleak.IgnoreTopFunction("github.com/rjeczalik/notify.(*nonrecursiveTree).dispatch"),
+	goleak.IgnoreTopFunction("github.com/rjeczalik/notify.(*nonrecursiveTree).internal"),
+	goleak.IgnoreTopFunction("github.com/rjeczalik/notify.(*inotify).send"),
+	goleak.IgnoreTopFunction("syscall.Syscall6"), // The epoll loop of notify's inotify watcher
+	// The logrus writer pkg/logs redirects the stdlib log to on init
+	goleak.IgnoreTopFunction("io.(*pipe).read"),
+}
+
+// newTestStorage creates a GenericWatchStorage for a new temporary directory
+func newTestStorage(t *testing.T) (update.EventStorage, string) {
+	dir, err := ioutil.TempDir("", "watch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewManifestStorage(dir, testSerializer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s, dir
+}
+
+func writeTestCar(t *testing.T, dir, name string) {
+	content := fmt.Sprintf("apiVersion: sample-app.weave.works/v1alpha1\nkind: Car\nmetadata:\n  name: %s\n  namespace: default\n", name)
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".yaml"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCloseContext(t *testing.T) {
+	defer goleak.VerifyNone(t, globalGoroutines...)
+
+	t.Run("flush", func(t *testing.T) {
+		for i := 0; i < 5; i++ {
+			s, dir := newTestStorage(t)
+			defer os.RemoveAll(dir)
+
+			updates := make(update.UpdateStream, 10)
+			s.SetUpdateStream(updates)
+
+			// Close before the events are dispatched after the batch timeout
+			writeTestCar(t, dir, "foo")
+			time.Sleep(200 * time.Millisecond)
+			if err := s.(*GenericWatchStorage).CloseContext(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			select {
+			case upd := <-updates:
+				if upd.Event != update.ObjectEventCreate || upd.PartialObject.GetName() != "foo" {
+					t.Errorf("unexpected update: %s %s", upd.Event, upd.PartialObject.GetName())
+				}
+			default:
+				t.Error("expected the buffered event to be flushed on close")
+			}
+		}
+	})
+
+	t.Run("context expired", func(t *testing.T) {
+		s, dir := newTestStorage(t)
+		defer os.RemoveAll(dir)
+
+		// Nobody reads the update stream, so sending the event blocks
+		s.SetUpdateStream(make(update.UpdateStream))
+		writeTestCar(t, dir, "foo")
+		time.Sleep(1500 * time.Millisecond)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		if err := s.(*GenericWatchStorage).CloseContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected context.DeadlineExceeded, got %v", err)
+		}
+	})
+
+	t.Run("close twice", func(t *testing.T) {
+		s, dir := newTestStorage(t)
+		defer os.RemoveAll(dir)
+
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+		// E.g. a deferred Close after an explicit shutdown doesn't panic
+		if err := s.(*GenericWatchStorage).CloseContext(context.Background()); !errors.Is(err, ErrClosed) {
+			t.Errorf("expected ErrClosed, got %v", err)
+		}
+		if err := s.Close(); !errors.Is(err, ErrClosed) {
+			t.Errorf("expected ErrClosed, got %v", err)
+		}
+	})
+}
+
+func TestMetrics(t *testing.T) {
+	s, dir := newTestStorage(t)
+	defer os.RemoveAll(dir)
+	defer s.Close()
+
+	updates := make(update.UpdateStream, 10)
+	s.SetUpdateStream(updates)
+
+	writeTestCar(t, dir, "foo")
+	select {
+	case <-updates:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the update")
+	}
+
+	m := s.(*GenericWatchStorage).Metrics()
+	if m.Watcher.EventsReceived == 0 || m.Watcher.UpdatesSent != 1 || m.EventsSent != 1 || m.Watcher.LastEvent.IsZero() {
+		t.Errorf("unexpected metrics: %+v", m)
+	}
+
+	// The update stream can be replaced while the metrics are read (run with -race)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			s.SetUpdateStream(updates)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		s.(*GenericWatchStorage).Metrics()
+	}
+	<-done
+
+	registry := prometheus.NewPedanticRegistry()
+	if err := registry.Register(NewCollector(s.(*GenericWatchStorage), "test", prometheus.Labels{"dir": dir})); err != nil {
+		t.Fatal(err)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(families) != 14 {
+		t.Errorf("expected 14 metric families, got %d", len(families))
+	}
+}
+
+func TestMoveMapping(t *testing.T) {
+	s, dir := newTestStorage(t)
+	defer os.RemoveAll(dir)
+	defer s.Close()
+
+	updates := make(update.UpdateStream, 10)
+	s.SetUpdateStream(updates)
+
+	writeTestCar(t, dir, "foo")
+	select {
+	case upd := <-updates:
+		if upd.Event != update.ObjectEventCreate {
+			t.Fatalf("expected a CREATE event, got %s", upd.Event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the update")
+	}
+
+	// Moving the file with unchanged content only updates the mapping
+	oldPath, newPath := filepath.Join(dir, "foo.yaml"), filepath.Join(dir, "renamed.yaml")
+	if err := os.Rename(oldPath, newPath); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case upd := <-updates:
+		t.Fatalf("expected no event for the move, got %s", upd.Event)
+	case <-time.After(2 * time.Second):
+	}
+
+	raw := s.RawStorage()
+	key, err := raw.GetKey(newPath)
+	if err != nil {
+		t.Fatalf("expected the moved file to be mapped: %v", err)
+	}
+	if _, err := raw.GetKey(oldPath); err == nil {
+		t.Error("expected the old path to no longer be mapped")
+	}
+	if conflicts := s.(*GenericWatchStorage).Conflicts(); len(conflicts) != 0 {
+		t.Errorf("expected no conflicts, got %v", conflicts)
+	}
+	if content, err := raw.Read(key); err != nil || len(content) == 0 {
+		t.Errorf("expected to read the moved object, got %v", err)
+	}
+}
+
+func TestKeyDeriver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// regions/<namespace>/cars/<name>.yaml
+	opts := DefaultOptions()
+	opts.KeyDeriver = func(relPath string) (string, string, bool) {
+		parts := strings.Split(relPath, string(filepath.Separator))
+		if len(parts) != 4 || parts[0] != "regions" || parts[2] != "cars" {
+			return "", "", false
+		}
+		return parts[1], strings.TrimSuffix(parts[3], filepath.Ext(parts[3])), true
+	}
+
+	s, err := NewGenericWatchStorageWithOptions(storage.NewGenericStorage(
+		storage.NewGenericMappedRawStorage(dir), testSerializer, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier},
+	), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	tests := []struct {
+		name      string
+		path      string
+		content   string
+		namespace string
+		objName   string
+		err       error
+	}{
+		{
+			name:      "path-derived",
+			path:      "regions/us-east/cars/prod.yaml",
+			content:   "apiVersion: sample-app.weave.works/v1alpha1\nkind: Car\n",
+			namespace: "us-east",
+			objName:   "prod",
+		},
+		{
+			name:      "metadata-derived",
+			path:      "other/car.yaml",
+			content:   "apiVersion: sample-app.weave.works/v1alpha1\nkind: Car\nmetadata:\n  name: foo\n  namespace: default\n",
+			namespace: "default",
+			objName:   "foo",
+		},
+		{
+			name:      "matching metadata",
+			path:      "regions/us-east/cars/prod.yaml",
+			content:   "apiVersion: sample-app.weave.works/v1alpha1\nkind: Car\nmetadata:\n  name: prod\n",
+			namespace: "us-east",
+			objName:   "prod",
+		},
+		{
+			name:    "mismatching name",
+			path:    "regions/us-east/cars/prod.yaml",
+			content: "apiVersion: sample-app.weave.works/v1alpha1\nkind: Car\nmetadata:\n  name: dev\n  namespace: us-east\n",
+			err:     ErrKeyMismatch,
+		},
+		{
+			name:    "mismatching namespace",
+			path:    "regions/us-east/cars/prod.yaml",
+			content: "apiVersion: sample-app.weave.works/v1alpha1\nkind: Car\nmetadata:\n  name: prod\n  namespace: eu-west\n",
+			err:     ErrKeyMismatch,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj, err := s.(*GenericWatchStorage).recognize(filepath.Join(dir, tt.path), []byte(tt.content))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+			if err != nil {
+				return
+			}
+
+			if obj.GetNamespace() != tt.namespace || obj.GetName() != tt.objName {
+				t.Errorf("expected %s/%s, got %s/%s", tt.namespace, tt.objName, obj.GetNamespace(), obj.GetName())
+			}
+		})
+	}
+}
+
+func TestGVKFilter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeTestCar(t, dir, "foo")
+	content := "apiVersion: sample-app.weave.works/v1alpha1\nkind: Motorcycle\nmetadata:\n  name: bar\n  namespace: default\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "bar.yaml"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := DefaultOptions()
+	opts.SyncEvent = true
+	opts.GVKFilter = func(gvk schema.GroupVersionKind) bool { return gvk.Kind == "Car" }
+	s, err := NewGenericWatchStorageWithOptions(storage.NewGenericStorage(
+		storage.NewGenericMappedRawStorage(dir), testSerializer, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier},
+	), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	updates := make(update.UpdateStream, 10)
+	s.SetUpdateStream(updates)
+
+	var names []string
+	for upd := range updates {
+		if upd.Event == update.ObjectEventSync {
+			break
+		}
+		names = append(names, upd.PartialObject.GetName())
+	}
+	if !reflect.DeepEqual(names, []string{"foo"}) {
+		t.Errorf("expected only the Car to be found, got %v", names)
+	}
+
+	if _, _, err := s.(*GenericWatchStorage).readFile(filepath.Join(dir, "bar.yaml")); !errors.Is(err, ErrKindExcluded) {
+		t.Errorf("expected ErrKindExcluded, got %v", err)
+	}
+}
+
+func TestMaxFileSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeTestCar(t, dir, "foo")
+	content := "apiVersion: sample-app.weave.works/v1alpha1\nkind: Car\nmetadata:\n  name: bar\n  namespace: default\n# " + strings.Repeat("x", 1024) + "\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "bar.yaml"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := DefaultOptions()
+	opts.SyncEvent = true
+	opts.MaxFileSize = 1024
+	s, err := NewGenericWatchStorageWithOptions(storage.NewGenericStorage(
+		storage.NewGenericMappedRawStorage(dir), testSerializer, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier},
+	), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	updates := make(update.UpdateStream, 10)
+	s.SetUpdateStream(updates)
+
+	var names []string
+	for upd := range updates {
+		if upd.Event == update.ObjectEventSync {
+			break
+		}
+		names = append(names, upd.PartialObject.GetName())
+	}
+	if !reflect.DeepEqual(names, []string{"foo"}) {
+		t.Errorf("expected the oversized file to be ignored, got %v", names)
+	}
+
+	ws := s.(*GenericWatchStorage)
+	if ignored := ws.Metrics().FilesIgnored; ignored != 1 {
+		t.Errorf("expected 1 ignored file, got %d", ignored)
+	}
+	if _, _, err := ws.readFile(filepath.Join(dir, "bar.yaml")); !errors.Is(err, ErrFileTooLarge) {
+		t.Errorf("expected ErrFileTooLarge, got %v", err)
+	}
+
+	// Files of exactly the maximum size are read, and the limit can be disabled
+	ws.opts.MaxFileSize = int64(len(content))
+	if _, _, err := ws.readFile(filepath.Join(dir, "bar.yaml")); err != nil {
+		t.Errorf("expected the file to be read, got %v", err)
+	}
+	ws.opts.MaxFileSize = -1
+	if _, _, err := ws.readFile(filepath.Join(dir, "bar.yaml")); err != nil {
+		t.Errorf("expected the file to be read, got %v", err)
+	}
+}
+
+func TestEventFilters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	opts := DefaultOptions()
+	opts.EventFilters = []update.EventFilter{
+		func(upd update.Update) bool {
+			return upd.PartialObject.GetObjectKind().GroupVersionKind().Kind == "Car"
+		},
+		func(upd update.Update) bool {
+			return upd.PartialObject.GetNamespace() == "default"
+		},
+	}
+
+	updates := make(update.UpdateStream, 10)
+	s, err := NewGenericWatchStorageWithOptions(storage.NewGenericStorage(
+		storage.NewGenericMappedRawStorage(dir), testSerializer, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier},
+	), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetUpdateStream(updates)
+	defer s.Close()
+
+	writeTestCar(t, dir, "foo")
+	other := "apiVersion: sample-app.weave.works/v1alpha1\nkind: Car\nmetadata:\n  name: bar\n  namespace: other\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "bar.yaml"), []byte(other), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case upd := <-updates:
+		if upd.PartialObject.GetName() != "foo" {
+			t.Errorf("expected only foo to pass the filters, got %s", upd.PartialObject.GetName())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the update")
+	}
+
+	select {
+	case upd := <-updates:
+		t.Errorf("unexpected update for %s", upd.PartialObject.GetName())
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	if m := s.(*GenericWatchStorage).Metrics(); m.EventsFiltered != 1 {
+		t.Errorf("expected 1 filtered event, got %d", m.EventsFiltered)
+	}
+}
+
+func TestPreviousObject(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	opts := DefaultOptions()
+	opts.PreviousObject = true
+	s, err := NewGenericWatchStorageWithOptions(storage.NewGenericStorage(
+		storage.NewGenericMappedRawStorage(dir), scheme.Serializer, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier},
+	), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	updates := make(update.UpdateStream, 10)
+	s.SetUpdateStream(updates)
+
+	writeCar := func(engine string) *v1alpha1.Car {
+		content := "apiVersion: sample-app.weave.works/v1alpha1\nkind: Car\nmetadata:\n  name: foo\n  namespace: default\nspec:\n  engine: " + engine + "\n"
+		if err := ioutil.WriteFile(filepath.Join(dir, "foo.yaml"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case upd := <-updates:
+			car, ok := upd.Object.(*v1alpha1.Car)
+			if !ok {
+				t.Fatalf("expected a decoded Car for the %s event, got %T", upd.Event, upd.Object)
+			}
+			if car.Spec.Engine != engine {
+				t.Errorf("expected engine %q, got %q", engine, car.Spec.Engine)
+			}
+			if upd.Event != update.ObjectEventModify {
+				return nil
+			}
+			previous, ok := upd.PreviousObject.(*v1alpha1.Car)
+			if !ok {
+				t.Fatalf("expected a previous Car for the MODIFY event, got %T", upd.PreviousObject)
+			}
+			return previous
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the update")
+		}
+		return nil
+	}
+
+	writeCar("v6")
+	if previous := writeCar("v8"); previous.Spec.Engine != "v6" {
+		t.Errorf("expected the previous engine to be v6, got %q", previous.Spec.Engine)
+	}
+}
+
+func TestWatchKind(t *testing.T) {
+	s, dir := newTestStorage(t)
+	defer os.RemoveAll(dir)
+	defer s.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Nobody reads the Cars, which must not block the Motorcycles
+	cars, motorcycles := make(update.UpdateStream), make(update.UpdateStream)
+	ws := s.(*GenericWatchStorage)
+	if err := ws.WatchKind(ctx, v1alpha1.SchemeGroupVersion.WithKind("Car"), cars); err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.WatchKind(ctx, v1alpha1.SchemeGroupVersion.WithKind("Motorcycle"), motorcycles); err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestCar(t, dir, "foo")
+	writeTestCar(t, dir, "bar")
+	motorcycle := "apiVersion: sample-app.weave.works/v1alpha1\nkind: Motorcycle\nmetadata:\n  name: baz\n  namespace: default\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "baz.yaml"), []byte(motorcycle), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case upd := <-motorcycles:
+		if upd.PartialObject.GetName() != "baz" {
+			t.Errorf("expected only the Motorcycle, got %s", upd.PartialObject.GetName())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the Motorcycle")
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case upd := <-cars:
+			if upd.PartialObject.GetObjectKind().GroupVersionKind().Kind != "Car" {
+				t.Errorf("expected only Cars, got %s", upd.PartialObject.GetObjectKind().GroupVersionKind().Kind)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the Cars")
+		}
+	}
+
+	// Cancelling the context removes the subscriptions
+	cancel()
+	for i := 0; !ws.subscriptions.empty(); i++ {
+		if i == 50 {
+			t.Fatal("expected the subscriptions to be removed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWatchKeyMatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeTestCar(t, dir, "prod-foo")
+	writeTestCar(t, dir, "dev-foo")
+
+	opts := DefaultOptions()
+	opts.SyncEvent = true
+	s, err := NewGenericWatchStorageWithOptions(storage.NewGenericStorage(
+		storage.NewGenericMappedRawStorage(dir), testSerializer, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier},
+	), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Subscribe before the initial scan, which waits for the update stream
+	prod := make(update.UpdateStream, 10)
+	if err := s.(*GenericWatchStorage).WatchKindWithOptions(ctx, v1alpha1.SchemeGroupVersion.WithKind("Car"), prod, WithNamePrefix("prod-")); err != nil {
+		t.Fatal(err)
+	}
+	s.SetUpdateStream(make(update.UpdateStream, 10))
+
+	expectUpdate := func(event update.ObjectEvent, name string) {
+		select {
+		case upd := <-prod:
+			if upd.Event != event || (name != "" && upd.PartialObject.GetName() != name) {
+				t.Errorf("expected %s for %q, got %s for %v", event, name, upd.Event, upd.PartialObject)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %s for %q", event, name)
+		}
+	}
+
+	// The initial scan only sends the matching object
+	expectUpdate(update.ObjectEventCreate, "prod-foo")
+	expectUpdate(update.ObjectEventSync, "")
+
+	// So do the later changes, including the deletions
+	writeTestCar(t, dir, "dev-bar")
+	writeTestCar(t, dir, "prod-bar")
+	expectUpdate(update.ObjectEventCreate, "prod-bar")
+	if err := os.Remove(filepath.Join(dir, "dev-foo.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "prod-foo.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	expectUpdate(update.ObjectEventDelete, "")
+
+	select {
+	case upd := <-prod:
+		t.Errorf("expected no more events, got %s for %v", upd.Event, upd.PartialObject)
+	case <-time.After(500 * time.Millisecond):
+	}
+}
+
+func TestWatchDeadLetter(t *testing.T) {
+	s, dir := newTestStorage(t)
+	defer os.RemoveAll(dir)
+	defer s.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Nobody receives from cars until all events have been sent, so only the first event
+	// (held by the subscription) and the next one (buffered) can be delivered to it
+	cars := make(update.UpdateStream)
+	deadLetters := make(chan DeadLetter, 10)
+	gvk := v1alpha1.SchemeGroupVersion.WithKind("Car")
+	if err := s.(*GenericWatchStorage).WatchKindWithOptions(ctx, gvk, cars, WithBuffer(1), WithDeadLetter(deadLetters)); err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{"foo", "bar", "baz", "qux"}
+	for _, name := range names {
+		writeTestCar(t, dir, name)
+	}
+
+	seen := make(map[string]bool)
+	for i := 0; i < len(names)-2; i++ {
+		select {
+		case dl := <-deadLetters:
+			if !errors.Is(dl.Err, ErrSubscriptionFull) {
+				t.Errorf("expected ErrSubscriptionFull, got %v", dl.Err)
+			}
+			seen[dl.PartialObject.GetName()] = true
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the dead letters")
+		}
+	}
+
+	// The events for the remaining Cars are delivered
+	for len(seen) != len(names) {
+		select {
+		case upd := <-cars:
+			seen[upd.PartialObject.GetName()] = true
+		case dl := <-deadLetters:
+			seen[dl.PartialObject.GetName()] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for the events, got %v", seen)
+		}
+	}
+}
+
+func TestWatchMultipleSubscribers(t *testing.T) {
+	s, dir := newTestStorage(t)
+	defer os.RemoveAll(dir)
+	defer s.Close()
+	ws := s.(*GenericWatchStorage)
+
+	// The metrics subscriber is cancelled in between, and nobody receives from the slow one
+	metricsCtx, cancelMetrics := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	metrics, cache, slow := make(update.UpdateStream), make(update.UpdateStream), make(update.UpdateStream)
+	for _, sub := range []struct {
+		ctx context.Context
+		ch  update.UpdateStream
+	}{{metricsCtx, metrics}, {ctx, cache}, {ctx, slow}} {
+		if err := ws.Watch(sub.ctx, sub.ch); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expectCars := func(ch update.UpdateStream, names ...string) {
+		pending := make(map[string]bool, len(names))
+		for _, name := range names {
+			pending[name] = true
+		}
+		for len(pending) != 0 {
+			select {
+			case upd := <-ch:
+				delete(pending, upd.PartialObject.GetName())
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out waiting for %v", pending)
+			}
+		}
+	}
+
+	writeTestCar(t, dir, "foo")
+	writeTestCar(t, dir, "bar")
+	expectCars(metrics, "foo", "bar")
+	expectCars(cache, "foo", "bar")
+
+	// Unsubscribing one doesn't affect the others
+	cancelMetrics()
+	writeTestCar(t, dir, "baz")
+	expectCars(cache, "baz")
+	select {
+	case upd := <-metrics:
+		t.Errorf("expected no events after unsubscribing, got %s for %s", upd.Event, upd.PartialObject.GetName())
+	default:
+	}
+
+	// The slow subscriber has buffered all events meanwhile
+	expectCars(slow, "foo", "bar", "baz")
+}
+
+func TestSubscribeWhileSending(t *testing.T) {
+	s, dir := newTestStorage(t)
+	defer os.RemoveAll(dir)
+	defer s.Close()
+	ws := s.(*GenericWatchStorage)
+
+	// Nobody receives from the update stream, which blocks sending the event
+	blocked := make(update.UpdateStream)
+	s.SetUpdateStream(blocked)
+	writeTestCar(t, dir, "foo")
+	time.Sleep(2 * time.Second)
+
+	// Subscribing doesn't wait for the blocked consumer
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := make(update.UpdateStream, 10)
+	subscribed := make(chan error, 1)
+	go func() {
+		subscribed <- ws.Watch(ctx, ch)
+	}()
+	select {
+	case err := <-subscribed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out subscribing while an event is being sent")
+	}
+
+	// Once the event is received, the new subscription gets the following ones
+	<-blocked
+	go func() {
+		for range blocked {
+		}
+	}()
+	writeTestCar(t, dir, "bar")
+	select {
+	case upd := <-ch:
+		if name := upd.PartialObject.GetName(); name != "bar" {
+			t.Errorf("expected an event for bar, got %s", name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for bar")
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	s, dir := newTestStorage(t)
+	defer os.RemoveAll(dir)
+	defer s.Close()
+	ws := s.(*GenericWatchStorage)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	other, ch := make(update.UpdateStream, 10), make(update.UpdateStream, 10)
+	if err := ws.Watch(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+	sub, err := ws.Subscribe(context.Background(), ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// subscriptionLeaks returns the number of running subscription goroutines. As the storage
+	// is still running, goleak reports its goroutines too, so only count the subscriptions.
+	subscriptionLeaks := func() int {
+		err := goleak.Find(globalGoroutines...)
+		if err == nil {
+			return 0
+		}
+		var n int
+		for _, stack := range strings.Split(err.Error(), "\n\n") {
+			if strings.Contains(stack, "(*GenericWatchStorage).subscribe.func") {
+				n++
+			}
+		}
+		return n
+	}
+	if n := subscriptionLeaks(); n != 2 {
+		t.Fatalf("expected 2 subscription goroutines, got %d", n)
+	}
+
+	sub.Unsubscribe()
+	sub.Unsubscribe()
+	select {
+	case <-sub.Done():
+	default:
+		t.Error("expected the subscription to be done")
+	}
+
+	// The goroutine of the subscription has exited, and the subscription is released
+	if n := subscriptionLeaks(); n != 1 {
+		t.Errorf("expected only the goroutine of the other subscription, got %d", n)
+	}
+	if n := len(ws.subscriptions.list()); n != 1 {
+		t.Errorf("expected only the other subscription to be registered, got %d", n)
+	}
+
+	// No more events are sent to the channel, unlike to the other subscription
+	writeTestCar(t, dir, "foo")
+	select {
+	case <-other:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for foo")
+	}
+	select {
+	case upd := <-ch:
+		t.Errorf("expected no events after unsubscribing, got %s for %s", upd.Event, upd.PartialObject.GetName())
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestFinalizers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	storageOpts := storage.DefaultOptions()
+	storageOpts.FinalizerSupport = true
+	rawOpts := storage.DefaultRawStorageOptions()
+	rawOpts.FileLayout = storage.FlatLayout
+	s, err := NewGenericWatchStorage(storage.NewGenericStorageWithOptions(
+		storage.NewGenericMappedRawStorageWithOptions(dir, rawOpts), scheme.Serializer,
+		[]runtime.IdentifierFactory{runtime.Metav1NameIdentifier}, storageOpts,
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	updates := make(update.UpdateStream, 10)
+	s.SetUpdateStream(updates)
+
+	// A single write may be reported as more than one MODIFY event, skip the extra ones
+	expectEvent := func(expected update.ObjectEvent) {
+		for {
+			select {
+			case upd := <-updates:
+				if upd.Event == expected {
+					return
+				} else if upd.Event != update.ObjectEventModify {
+					t.Fatalf("expected a %s event, got %s", expected, upd.Event)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out waiting for the %s event", expected)
+			}
+		}
+	}
+
+	car := &v1alpha1.Car{}
+	car.SetName("foo")
+	car.SetNamespace("default")
+	car.SetFinalizers([]string{"example.com/cleanup"})
+	// Like for other writes through the storage, no event is sent for the Create
+	if err := s.Create(car); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := s.ObjectKeyFor(car)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Deleting an object with finalizers only sets its deletionTimestamp
+	if err := s.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(update.ObjectEventModify)
+
+	obj, err := s.Get(key)
+	if err != nil {
+		t.Fatalf("expected the object to be kept until its finalizers are removed, got %v", err)
+	}
+	if obj.GetDeletionTimestamp() == nil {
+		t.Fatal("expected the deletionTimestamp to be set")
+	}
+
+	// Clearing the finalizers removes the file
+	obj.SetFinalizers(nil)
+	if err := s.Update(obj); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(update.ObjectEventDelete)
+
+	if _, err := s.Get(key); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected ErrNotFound after the finalizers were removed, got %v", err)
+	}
+}
+
+func TestCreateAndModifyEvents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Objects found by the initial scan are seen for the first time
+	writeTestCar(t, dir, "bar")
+	opts := DefaultOptions()
+	opts.CompareContent = true
+	s, err := NewGenericWatchStorageWithOptions(storage.NewGenericStorage(
+		storage.NewGenericMappedRawStorage(dir), testSerializer, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier},
+	), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	updates := make(update.UpdateStream, 10)
+	s.SetUpdateStream(updates)
+
+	expectEvent := func(step, name string, expected update.ObjectEvent) {
+		select {
+		case upd := <-updates:
+			if upd.Event != expected || upd.PartialObject.GetName() != name {
+				t.Fatalf("%s: expected %s %s, got %s %s", step, expected, name, upd.Event, upd.PartialObject.GetName())
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: timed out waiting for the %s event", step, expected)
+		}
+	}
+	expectEvent("initial scan", "bar", update.ObjectEventCreate)
+
+	writeTestCar(t, dir, "foo")
+	expectEvent("first seen", "foo", update.ObjectEventCreate)
+
+	content := "apiVersion: sample-app.weave.works/v1alpha1\nkind: Car\nmetadata:\n  name: foo\n  namespace: default\nspec:\n  engine: v8\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "foo.yaml"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent("content change", "foo", update.ObjectEventModify)
+
+	// Rewriting the file with the same content doesn't change the object
+	if err := ioutil.WriteFile(filepath.Join(dir, "foo.yaml"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeTestCar(t, dir, "baz")
+	expectEvent("no-op rewrite", "baz", update.ObjectEventCreate)
+	if unchanged := s.(*GenericWatchStorage).Metrics().EventsUnchanged; unchanged != 1 {
+		t.Errorf("expected 1 unchanged event, got %d", unchanged)
+	}
+}
+
+// TestExternalEditAfterWrite makes sure that the write of the storage uses up the suspension of its
+// event, also when the file is replaced by renaming a temporary file over it, so that the event of
+// the next external edit isn't skipped
+func TestExternalEditAfterWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewGenericWatchStorage(storage.NewGenericStorage(
+		storage.NewGenericMappedRawStorage(dir), scheme.Serializer, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	updates := make(update.UpdateStream, 10)
+	s.SetUpdateStream(updates)
+
+	expectEvent := func(step string, expected update.ObjectEvent) {
+		select {
+		case upd := <-updates:
+			if upd.Event != expected {
+				t.Fatalf("%s: expected a %s event, got %s", step, expected, upd.Event)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: timed out waiting for the %s event", step, expected)
+		}
+	}
+
+	writeTestCar(t, dir, "foo")
+	expectEvent("external create", update.ObjectEventCreate)
+
+	key := storage.NewObjectKey(storage.NewKindKey(v1alpha1.SchemeGroupVersion.WithKind("Car")), runtime.NewIdentifier("default/foo"))
+	obj, err := s.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj.(*v1alpha1.Car).Spec.Brand = "Volvo"
+	if err := s.Update(obj); err != nil {
+		t.Fatal(err)
+	}
+
+	// The write through the storage doesn't cause an event
+	select {
+	case upd := <-updates:
+		t.Fatalf("expected no event for the write of the storage, got %s", upd.Event)
+	case <-time.After(2 * time.Second):
+	}
+
+	writeTestCar(t, dir, "foo")
+	expectEvent("external edit", update.ObjectEventModify)
+}
+
+func TestPerIDRateLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	opts := DefaultOptions()
+	opts.PerIDRateLimit = 3 * time.Second
+	s, err := NewGenericWatchStorageWithOptions(storage.NewGenericStorage(
+		storage.NewGenericMappedRawStorage(dir), testSerializer, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier},
+	), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	updates := make(update.UpdateStream, 10)
+	s.SetUpdateStream(updates)
+
+	writeVersion := func(version int) {
+		content := fmt.Sprintf("apiVersion: sample-app.weave.works/v1alpha1\nkind: Car\nmetadata:\n  name: foo\n  namespace: default\n  labels:\n    version: %q\n", fmt.Sprint(version))
+		if err := ioutil.WriteFile(filepath.Join(dir, "foo.yaml"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectVersion := func(event update.ObjectEvent, version string) {
+		select {
+		case upd := <-updates:
+			if upd.Event != event || upd.PartialObject.GetLabels()["version"] != version {
+				t.Fatalf("expected %s of version %s, got %s of version %s", event, version, upd.Event, upd.PartialObject.GetLabels()["version"])
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for the %s event", event)
+		}
+	}
+
+	writeVersion(0)
+	expectVersion(update.ObjectEventCreate, "0")
+
+	// The modifications within the interval collapse into one event with the latest content.
+	// They're further apart than the batch timeout of the watcher, so it doesn't collapse them.
+	writeVersion(1)
+	time.Sleep(1200 * time.Millisecond)
+	writeVersion(2)
+	expectVersion(update.ObjectEventModify, "2")
+	if sent := s.(*GenericWatchStorage).Metrics().Watcher.UpdatesSent; sent != 3 {
+		t.Errorf("expected the watcher to send 3 updates, got %d", sent)
+	}
+
+	select {
+	case upd := <-updates:
+		t.Errorf("expected the modifications to collapse, got another %s event", upd.Event)
+	case <-time.After(1500 * time.Millisecond):
+	}
+}
+
+func TestResync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"a", "b", "c"} {
+		writeTestCar(t, dir, name)
+	}
+	s, err := NewGenericWatchStorage(storage.NewGenericStorage(
+		storage.NewGenericMappedRawStorage(dir), testSerializer, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	updates := make(update.UpdateStream, 10)
+	s.SetUpdateStream(updates)
+	collect := func(step string, count int) map[string]update.ObjectEvent {
+		events := make(map[string]update.ObjectEvent)
+		for i := 0; i < count; i++ {
+			select {
+			case upd := <-updates:
+				name := upd.PartialObject.GetName()
+				if upd.Event == update.ObjectEventDelete {
+					name = string(upd.PartialObject.GetUID())
+				}
+				events[name] = upd.Event
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%s: timed out waiting for %d events, got %v", step, count, events)
+			}
+		}
+		return events
+	}
+	collect("initial scan", 3)
+
+	// Change the files out of band, and resync before the watcher dispatches its events
+	content := "apiVersion: sample-app.weave.works/v1alpha1\nkind: Car\nmetadata:\n  name: a\n  namespace: default\nspec:\n  engine: v8\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.yaml"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "b.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(dir, "c.yaml"), filepath.Join(dir, "e.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	writeTestCar(t, dir, "d")
+	if err := s.(*GenericWatchStorage).Resync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]update.ObjectEvent{
+		"a":         update.ObjectEventModify,
+		"default/b": update.ObjectEventDelete,
+		"c":         update.ObjectEventModify,
+		"d":         update.ObjectEventCreate,
+	}
+	if events := collect("resync", len(expected)); !reflect.DeepEqual(events, expected) {
+		t.Errorf("expected %v, got %v", expected, events)
+	}
+
+	// The events of the watcher for the same changes are not sent again
+	select {
+	case upd := <-updates:
+		t.Errorf("expected no more events, got %s %s", upd.Event, upd.PartialObject.GetName())
+	case <-time.After(2 * time.Second):
+	}
+}
+
+// recordingLogger is a logr.Logger recording the key/value pairs of all messages
+type recordingLogger struct {
+	values []interface{}
+	// records is shared by the loggers derived using WithValues and V
+	records *[]map[string]interface{}
+	mux     *gosync.Mutex
+}
+
+func newRecordingLogger() *recordingLogger {
+	return &recordingLogger{records: &[]map[string]interface{}{}, mux: &gosync.Mutex{}}
+}
+
+func (l *recordingLogger) Enabled() bool { return true }
+func (l *recordingLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.record(msg, keysAndValues)
+}
+func (l *recordingLogger) Error(err error, msg string, keysAndValues ...interface{}) {
+	l.record(msg, append(keysAndValues, "error", err))
+}
+func (l *recordingLogger) V(int) logr.InfoLogger       { return l }
+func (l *recordingLogger) WithName(string) logr.Logger { return l }
+func (l *recordingLogger) WithValues(keysAndValues ...interface{}) logr.Logger {
+	return &recordingLogger{values: append(append([]interface{}{}, l.values...), keysAndValues...), records: l.records, mux: l.mux}
+}
+
+func (l *recordingLogger) record(msg string, keysAndValues []interface{}) {
+	record := map[string]interface{}{"msg": msg}
+	kv := append(append([]interface{}{}, l.values...), keysAndValues...)
+	for i := 0; i+1 < len(kv); i += 2 {
+		record[kv[i].(string)] = kv[i+1]
+	}
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	*l.records = append(*l.records, record)
+}
+
+// logged returns true if a message was logged with the given key/value pairs
+func (l *recordingLogger) logged(msg string, fields map[string]interface{}) bool {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	for _, record := range *l.records {
+		if record["msg"] != msg {
+			continue
+		}
+
+		matches := true
+		for k, v := range fields {
+			matches = matches && record[k] == v
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
+}
+
+func TestLogger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logger := newRecordingLogger()
+	opts := DefaultOptions()
+	opts.Logger = logger
+
+	updates := make(update.UpdateStream, 10)
+	s, err := NewGenericWatchStorageWithOptions(storage.NewGenericStorage(
+		storage.NewGenericMappedRawStorage(dir), testSerializer, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier},
+	), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetUpdateStream(updates)
+	defer s.Close()
+
+	invalid := filepath.Join(dir, "invalid.yaml")
+	if err := ioutil.WriteFile(invalid, []byte("kind: [\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeTestCar(t, dir, "foo")
+	select {
+	case <-updates:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the update")
+	}
+
+	expected := map[string]map[string]interface{}{
+		"Sending event": {"eventType": update.ObjectEventCreate, "objectID": "default/foo"},
+		"Ignoring file": {"path": invalid},
+		// The logger is passed on to the FileWatcher
+		"Sending update": {"path": filepath.Join(dir, "foo.yaml")},
+	}
+	for msg, fields := range expected {
+		if !logger.logged(msg, fields) {
+			t.Errorf("expected %q to be logged with %v", msg, fields)
+		}
+	}
+}
@@ -0,0 +1,378 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/storage/watch/update"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// subscriptionBuffer is the amount of events buffered for every subscription, so
+// that a slow consumer of one kind doesn't block the consumers of the other kinds
+const subscriptionBuffer = 1024
+
+// ErrClosed is returned when subscribing to or closing a closed GenericWatchStorage
+var ErrClosed = errors.New("storage is closed")
+
+// ErrSubscriptionFull is the reason of the DeadLetters of events which couldn't be
+// sent to a subscription, as its buffer was full
+var ErrSubscriptionFull = errors.New("the buffer of the subscription is full")
+
+// DeadLetter is an event which couldn't be delivered to a subscription, see WithDeadLetter
+type DeadLetter struct {
+	update.Update
+	// Err is the reason why the event couldn't be delivered, e.g. ErrSubscriptionFull
+	Err error
+}
+
+// subscription receives the events accepted by filter until ctx is cancelled
+type subscription struct {
+	ctx    context.Context
+	filter update.EventFilter
+	// matchers additionally need to accept the key of the object of an event
+	matchers []KeyMatcher
+	queue    update.UpdateStream
+	// resume specifies whether to replay the kept events after resumeFrom first
+	resume     bool
+	resumeFrom uint64
+	// buffer is the size of the queue
+	buffer int
+	// deadLetters receives the events which don't fit into the queue, if set
+	deadLetters chan<- DeadLetter
+}
+
+// KeyMatcher decides whether the events for the object with the given key are sent to a subscription.
+// Glob patterns can be matched e.g. by calling path.Match for the identifier of the key.
+type KeyMatcher func(key storage.ObjectKey) bool
+
+// WatchOption customizes a subscription registered using Watch or WatchKindWithOptions
+type WatchOption func(sub *subscription)
+
+// WithKeyMatcher only sends the events for objects whose keys are accepted by m. It applies to the
+// events of the initial scan as well as the ones for later changes. If given multiple times, all
+// matchers need to accept the key.
+func WithKeyMatcher(m KeyMatcher) WatchOption {
+	return func(sub *subscription) {
+		sub.matchers = append(sub.matchers, m)
+	}
+}
+
+// WithNamePrefix only sends the events for objects whose names start with the given prefix,
+// e.g. "prod-". The names are the part of the identifiers after the namespace, if any.
+func WithNamePrefix(prefix string) WatchOption {
+	return WithKeyMatcher(func(key storage.ObjectKey) bool {
+		name := key.GetIdentifier()
+		if i := strings.Index(name, "/"); i >= 0 {
+			name = name[i+1:]
+		}
+		return strings.HasPrefix(name, prefix)
+	})
+}
+
+// WithResumeFrom first sends the events after the one with the given sequence number (see
+// update.Update.Sequence), which are kept by the GenericWatchStorage if Options.EventLogSize is set.
+// This lets a consumer continue after a restart without missing any events. If some of the events
+// aren't kept anymore, or the sequence number is unknown as the GenericWatchStorage has been
+// restarted, subscribing returns an error wrapping ErrResumeTooOld.
+func WithResumeFrom(sequence uint64) WatchOption {
+	return func(sub *subscription) {
+		sub.resume, sub.resumeFrom = true, sequence
+	}
+}
+
+// WithBuffer sets the amount of events buffered for the subscription, until its consumer receives
+// them. By default, 1024 events are buffered. Once the buffer is full, sending further events blocks
+// the GenericWatchStorage, unless they're sent to a dead-letter channel (see WithDeadLetter).
+func WithBuffer(size int) WatchOption {
+	return func(sub *subscription) {
+		sub.buffer = size
+	}
+}
+
+// WithDeadLetter sends the events which can't be delivered to the subscription to ch instead of
+// losing them or blocking, together with the reason (see DeadLetter). This is the case when the
+// buffer of the subscription is full (see WithBuffer), as its consumer is too slow. Sending to ch
+// blocks the GenericWatchStorage, so ch should be buffered, and be received from concurrently.
+func WithDeadLetter(ch chan<- DeadLetter) WatchOption {
+	return func(sub *subscription) {
+		sub.deadLetters = ch
+	}
+}
+
+// matches returns true if all matchers of the subscription accept key
+func (sub *subscription) matches(key storage.ObjectKey) bool {
+	for _, m := range sub.matchers {
+		if !m(key) {
+			return false
+		}
+	}
+	return true
+}
+
+// subscriptions holds the active subscriptions of a GenericWatchStorage
+type subscriptions struct {
+	subs   map[*subscription]struct{}
+	closed bool
+	mux    sync.Mutex
+}
+
+func newSubscriptions() *subscriptions {
+	return &subscriptions{subs: make(map[*subscription]struct{})}
+}
+
+func (s *subscriptions) add(sub *subscription) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	s.subs[sub] = struct{}{}
+	return nil
+}
+
+func (s *subscriptions) remove(sub *subscription) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	delete(s.subs, sub)
+}
+
+// empty returns true if there are no active subscriptions
+func (s *subscriptions) empty() bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return len(s.subs) == 0
+}
+
+// list returns the active subscriptions
+func (s *subscriptions) list() []*subscription {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	subs := make([]*subscription, 0, len(s.subs))
+	for sub := range s.subs {
+		subs = append(subs, sub)
+	}
+	return subs
+}
+
+// close closes the queues of all subscriptions, after which their remaining
+// events are forwarded, and no new subscriptions are accepted. This may only
+// be called after the last event has been sent.
+func (s *subscriptions) close() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.closed = true
+	for sub := range s.subs {
+		close(sub.queue)
+	}
+}
+
+// kindFilter returns an EventFilter accepting the events for objects of the given GroupKind
+func kindFilter(gk schema.GroupKind) update.EventFilter {
+	return func(upd update.Update) bool {
+		return upd.PartialObject.GetObjectKind().GroupVersionKind().GroupKind() == gk
+	}
+}
+
+// WatchKind sends the ObjectEvents for objects with the group and kind of gvk to ch, until
+// ctx is cancelled. The version of gvk is ignored, as the objects keep the version of their
+// files. The events pass the Options.EventFilters first, and ObjectEventSync is sent to all
+// subscriptions registered before it. Every subscription buffers its events separately, so a
+// slow consumer only blocks the GenericWatchStorage once its buffer is full. ch is never
+// closed, the subscription ends when ctx is cancelled.
+func (s *GenericWatchStorage) WatchKind(ctx context.Context, gvk schema.GroupVersionKind, ch update.UpdateStream) error {
+	return s.WatchKindWithOptions(ctx, gvk, ch)
+}
+
+// WatchKindWithOptions is like WatchKind, but the objects can be narrowed down further
+// using WatchOptions, e.g. WithNamePrefix.
+func (s *GenericWatchStorage) WatchKindWithOptions(ctx context.Context, gvk schema.GroupVersionKind, ch update.UpdateStream, opts ...WatchOption) error {
+	_, err := s.subscribe(ctx, kindFilter(gvk.GroupKind()), ch, opts)
+	return err
+}
+
+// Watch sends the ObjectEvents for objects of all kinds to ch, until ctx is cancelled. Any number
+// of consumers can watch independently, like using WatchKind: each has its own buffer, and its own
+// policy for when the buffer is full (see WithBuffer and WithDeadLetter), and cancelling the context
+// of one doesn't affect the others. Unlike the update stream given to SetUpdateStream, ch is never
+// closed. The events can be narrowed down using WatchOptions.
+func (s *GenericWatchStorage) Watch(ctx context.Context, ch update.UpdateStream, opts ...WatchOption) error {
+	_, err := s.Subscribe(ctx, ch, opts...)
+	return err
+}
+
+// Subscribe is the same as Watch, but returns a handle for ending the subscription
+// without cancelling ctx, see Subscription.Unsubscribe
+func (s *GenericWatchStorage) Subscribe(ctx context.Context, ch update.UpdateStream, opts ...WatchOption) (*Subscription, error) {
+	return s.subscribe(ctx, func(update.Update) bool { return true }, ch, opts)
+}
+
+// Subscription is the handle of a subscription registered using Subscribe
+type Subscription struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Unsubscribe ends the subscription, and waits until it has stopped sending events. No
+// events are sent to the channel of the subscription after Unsubscribe has returned.
+// Calling Unsubscribe more than once is a no-op.
+func (sub *Subscription) Unsubscribe() {
+	sub.cancel()
+	<-sub.done
+}
+
+// Done returns a channel which is closed when the subscription has ended, i.e. when it has been
+// unsubscribed, its context has been cancelled, or the GenericWatchStorage has been closed
+func (sub *Subscription) Done() <-chan struct{} {
+	return sub.done
+}
+
+// subscribe registers a subscription for the events accepted by filter, see Watch
+func (s *GenericWatchStorage) subscribe(ctx context.Context, filter update.EventFilter, ch update.UpdateStream, opts []WatchOption) (*Subscription, error) {
+	// The subscription can be ended using the handle as well, which releases ctx once it has ended
+	ctx, cancel := context.WithCancel(ctx)
+	handle := &Subscription{cancel, make(chan struct{})}
+	sub := &subscription{
+		ctx:    ctx,
+		filter: filter,
+		buffer: subscriptionBuffer,
+	}
+	for _, opt := range opts {
+		opt(sub)
+	}
+	if sub.buffer < 0 {
+		sub.buffer = 0
+	}
+	sub.queue = make(update.UpdateStream, sub.buffer)
+
+	// Register the subscription before the next event is sent, so that none is missed
+	var replay []update.Update
+	s.log.mux.Lock()
+	err := s.subscriptions.add(sub)
+	if err == nil && sub.resume {
+		if replay, err = s.log.since(sub.resumeFrom); err != nil {
+			s.subscriptions.remove(sub)
+		}
+	}
+	s.log.mux.Unlock()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	go func() {
+		defer close(handle.done)
+		defer cancel()
+		defer s.subscriptions.remove(sub)
+		for _, upd := range replay {
+			if !s.accepts(sub, upd, nil) {
+				continue
+			}
+
+			select {
+			case ch <- upd:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		for {
+			select {
+			case upd, ok := <-sub.queue:
+				if !ok {
+					return
+				}
+
+				select {
+				case ch <- upd:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return handle, nil
+}
+
+// accepts returns true if the given Update should be sent to sub. The key of the object is
+// resolved into key once, and only if a subscription matches keys.
+func (s *GenericWatchStorage) accepts(sub *subscription, upd update.Update, key *eventKeyCache) bool {
+	if upd.Event == update.ObjectEventSync {
+		return true
+	}
+	if !sub.filter(upd) {
+		return false
+	}
+	if len(sub.matchers) == 0 {
+		return true
+	}
+
+	if key == nil {
+		key = &eventKeyCache{}
+	}
+	if !key.resolved {
+		key.key, key.err = s.eventKey(upd.Event, upd.PartialObject)
+		key.resolved = true
+	}
+	return key.err == nil && sub.matches(key.key)
+}
+
+// eventKeyCache holds the key of the object of an event, once resolved by accepts
+type eventKeyCache struct {
+	key      storage.ObjectKey
+	err      error
+	resolved bool
+}
+
+// publish sends the given Update to the given subscriptions accepting it
+func (s *GenericWatchStorage) publish(upd update.Update, subs []*subscription) {
+	key := &eventKeyCache{}
+	for _, sub := range subs {
+		if !s.accepts(sub, upd, key) {
+			continue
+		}
+
+		if sub.deadLetters != nil {
+			select {
+			case sub.queue <- upd:
+				continue
+			default:
+			}
+			// Hand the event to the dead-letter channel instead of waiting for the consumer
+			s.sendDeadLetter(sub, DeadLetter{upd, ErrSubscriptionFull})
+			continue
+		}
+
+		select {
+		case sub.queue <- upd:
+		case <-sub.ctx.Done():
+		case <-s.stop:
+			atomic.AddUint64(&s.counters.dropped, 1)
+			s.logger.Info("Dropping event, as the storage is closed", "eventType", upd.Event, "objectID", objectID(upd.PartialObject))
+		}
+	}
+}
+
+// sendDeadLetter sends the given DeadLetter to the dead-letter channel of sub
+func (s *GenericWatchStorage) sendDeadLetter(sub *subscription, dl DeadLetter) {
+	select {
+	case sub.deadLetters <- dl:
+	case <-sub.ctx.Done():
+	case <-s.stop:
+		atomic.AddUint64(&s.counters.dropped, 1)
+		s.logger.Info("Dropping event, as the storage is closed", "eventType", dl.Event, "objectID", objectID(dl.PartialObject))
+	}
+}
@@ -12,6 +12,7 @@ const (
 	ObjectEventCreate                    // 1
 	ObjectEventModify                    // 2
 	ObjectEventDelete                    // 3
+	ObjectEventSync                      // 4
 )
 
 func (o ObjectEvent) String() string {
@@ -24,6 +25,8 @@ func (o ObjectEvent) String() string {
 		return "MODIFY"
 	case 3:
 		return "DELETE"
+	case 4:
+		return "SYNC"
 	}
 
 	// Should never happen
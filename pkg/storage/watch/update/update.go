@@ -11,8 +11,25 @@ type Update struct {
 	Event         ObjectEvent
 	PartialObject runtime.PartialObject
 	Storage       storage.Storage
+
+	// Object is the fully decoded object of a CREATE or MODIFY event, and PreviousObject
+	// the version of the object sent with the event before, if any. These are only set if
+	// the EventStorage is configured to decode objects, e.g. using watch.Options.PreviousObject.
+	Object         runtime.Object
+	PreviousObject runtime.Object
+
+	// Revision orders the events of the object, it's incremented by one for every event sent for
+	// it, or set to the stored revision of the object if that's greater (see storage.Revision and
+	// storage.Options.ManageRevision). It's 0 for SYNC events.
+	Revision uint64
+	// Sequence orders all events sent by the EventStorage, it's incremented by one for every event
+	// that passes the EventFilters, starting at 1. Consumers can resume from it after a restart.
+	Sequence uint64
 }
 
+// EventFilter decides whether the given Update should be sent to the UpdateStream.
+type EventFilter func(Update) bool
+
 // UpdateStream is a channel of updates.
 type UpdateStream chan Update
 
@@ -23,6 +40,6 @@ type EventStorage interface {
 	// SetUpdateStream gives the EventStorage a channel to send events to.
 	// The caller is responsible for choosing a large enough buffer to avoid
 	// blocking the underlying EventStorage implementation unnecessarily.
-	// TODO: In the future maybe enable sending events to multiple listeners?
+	// Multiple independent consumers can subscribe using e.g. watch.GenericWatchStorage.Watch.
 	SetUpdateStream(UpdateStream)
 }
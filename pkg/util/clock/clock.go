@@ -0,0 +1,73 @@
+package clock
+
+import "time"
+
+// Clock is the source of time of the time-based features, e.g. debouncing and expiry. It makes
+// them testable without sleeping, using a FakeClock. The semantics of all methods match their
+// counterparts in the time package.
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+	// After waits for the duration to elapse, and then sends the current time on the returned channel
+	After(d time.Duration) <-chan time.Time
+	// AfterFunc waits for the duration to elapse, and then calls f in its own goroutine.
+	// The returned Timer can be used to cancel the call.
+	AfterFunc(d time.Duration, f func()) Timer
+	// NewTicker returns a new Ticker sending the current time every period. Ticks are
+	// dropped for slow receivers. The Ticker must be stopped to release its resources.
+	NewTicker(period time.Duration) Ticker
+}
+
+// Timer is a pending call created by Clock.AfterFunc
+type Timer interface {
+	// Stop prevents the call from happening. It returns false if the call has
+	// already happened, or the Timer has already been stopped.
+	Stop() bool
+}
+
+// Ticker sends ticks created by Clock.NewTicker
+type Ticker interface {
+	// C returns the channel the ticks are sent on
+	C() <-chan time.Time
+	// Stop turns off the Ticker, no more ticks are sent after it returns
+	Stop()
+}
+
+// RealClock is the Clock backed by the time package
+var RealClock Clock = realClock{}
+
+// OrDefault returns c, or RealClock if c is nil
+func OrDefault(c Clock) Clock {
+	if c == nil {
+		return RealClock
+	}
+	return c
+}
+
+// realClock implements Clock using the time package
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+func (realClock) NewTicker(period time.Duration) Ticker {
+	return realTicker{time.NewTicker(period)}
+}
+
+// realTicker implements Ticker using a time.Ticker
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
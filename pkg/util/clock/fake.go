@@ -0,0 +1,152 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// NewFakeClock returns a FakeClock whose time starts at now
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// FakeClock is a Clock for tests, whose time only moves when it's advanced using Advance.
+// The timers, tickers and After channels fire during Advance, in the order of their deadlines.
+type FakeClock struct {
+	now     time.Time
+	waiters []*fakeWaiter
+	mux     sync.Mutex
+}
+
+var _ Clock = &FakeClock{}
+
+// fakeWaiter is a timer, ticker or After channel of a FakeClock
+type fakeWaiter struct {
+	clock    *FakeClock
+	deadline time.Time
+	// period is the period of tickers, and zero for the others
+	period time.Duration
+	// ch receives the ticks of tickers and After channels, with a buffer of one
+	ch chan time.Time
+	// fn is called by timers
+	fn func()
+}
+
+// Now returns the current fake time
+func (c *FakeClock) Now() time.Time {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	return c.now
+}
+
+// After returns a channel receiving the fake time once it has been advanced by d
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	w := &fakeWaiter{ch: make(chan time.Time, 1)}
+	c.add(w, d)
+	return w.ch
+}
+
+// AfterFunc calls f in its own goroutine once the fake time has been advanced by d
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	w := &fakeWaiter{fn: f}
+	c.add(w, d)
+	return w
+}
+
+// NewTicker returns a Ticker ticking every period of fake time
+func (c *FakeClock) NewTicker(period time.Duration) Ticker {
+	if period <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+
+	w := &fakeWaiter{period: period, ch: make(chan time.Time, 1)}
+	c.add(w, period)
+	return fakeTicker{w}
+}
+
+// Advance moves the fake time forward by d, and fires the timers, tickers and
+// After channels whose deadlines have passed
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.now = c.now.Add(d)
+	for len(c.waiters) > 0 && !c.waiters[0].deadline.After(c.now) {
+		w := c.waiters[0]
+		c.waiters = c.waiters[1:]
+
+		switch {
+		case w.fn != nil:
+			go w.fn()
+		case w.period > 0:
+			// Like time.Ticker, drop the ticks for slow receivers
+			select {
+			case w.ch <- w.deadline:
+			default:
+			}
+			w.deadline = w.deadline.Add(w.period)
+			c.insert(w)
+		default:
+			w.ch <- w.deadline
+		}
+	}
+}
+
+// Waiters returns the number of pending timers, tickers and After channels. This
+// allows tests to wait for other goroutines to register them, before advancing.
+func (c *FakeClock) Waiters() int {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	return len(c.waiters)
+}
+
+// add registers w to fire once the fake time has been advanced by d
+func (c *FakeClock) add(w *fakeWaiter, d time.Duration) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	w.clock = c
+	w.deadline = c.now.Add(d)
+	c.insert(w)
+}
+
+// insert adds w to the waiters, which are kept sorted by their deadlines
+func (c *FakeClock) insert(w *fakeWaiter) {
+	i := sort.Search(len(c.waiters), func(i int) bool {
+		return c.waiters[i].deadline.After(w.deadline)
+	})
+	c.waiters = append(c.waiters, nil)
+	copy(c.waiters[i+1:], c.waiters[i:])
+	c.waiters[i] = w
+}
+
+// Stop implements Timer, removing w from the waiters
+func (w *fakeWaiter) Stop() bool {
+	c := w.clock
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	for i, waiter := range c.waiters {
+		if waiter == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// fakeTicker implements Ticker using a fakeWaiter
+type fakeTicker struct {
+	w *fakeWaiter
+}
+
+func (t fakeTicker) C() <-chan time.Time {
+	return t.w.ch
+}
+
+func (t fakeTicker) Stop() {
+	t.w.Stop()
+}
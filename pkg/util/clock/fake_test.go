@@ -0,0 +1,69 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	c := NewFakeClock(start)
+
+	after := c.After(time.Minute)
+	fired := make(chan struct{})
+	timer := c.AfterFunc(2*time.Minute, func() { close(fired) })
+	stopped := c.AfterFunc(2*time.Minute, func() { t.Error("expected the stopped timer not to fire") })
+	ticker := c.NewTicker(45 * time.Second)
+	defer ticker.Stop()
+	if !stopped.Stop() || stopped.Stop() {
+		t.Error("expected the timer to be stopped once")
+	}
+	if waiters := c.Waiters(); waiters != 3 {
+		t.Errorf("expected 3 waiters, got %d", waiters)
+	}
+
+	// Nothing fires before its deadline
+	c.Advance(30 * time.Second)
+	select {
+	case <-after:
+		t.Fatal("expected After not to fire yet")
+	case <-ticker.C():
+		t.Fatal("expected the ticker not to tick yet")
+	default:
+	}
+
+	c.Advance(30 * time.Second)
+	if now := <-after; !now.Equal(start.Add(time.Minute)) {
+		t.Errorf("expected After to send %s, got %s", start.Add(time.Minute), now)
+	}
+	if tick := <-ticker.C(); !tick.Equal(start.Add(45 * time.Second)) {
+		t.Errorf("expected a tick at %s, got %s", start.Add(45*time.Second), tick)
+	}
+
+	// Ticks are dropped for slow receivers, and the fired timer can't be stopped anymore
+	c.Advance(5 * time.Minute)
+	<-fired
+	if timer.Stop() {
+		t.Error("expected the fired timer not to be stopped")
+	}
+	if tick := <-ticker.C(); !tick.Equal(start.Add(90 * time.Second)) {
+		t.Errorf("expected a tick at %s, got %s", start.Add(90*time.Second), tick)
+	}
+	select {
+	case tick := <-ticker.C():
+		t.Errorf("expected the other ticks to be dropped, got %s", tick)
+	default:
+	}
+	if now := c.Now(); !now.Equal(start.Add(6 * time.Minute)) {
+		t.Errorf("expected the time %s, got %s", start.Add(6*time.Minute), now)
+	}
+
+	// Only the ticker is left
+	if waiters := c.Waiters(); waiters != 1 {
+		t.Errorf("expected 1 waiter, got %d", waiters)
+	}
+	ticker.Stop()
+	if waiters := c.Waiters(); waiters != 0 {
+		t.Errorf("expected no waiters, got %d", waiters)
+	}
+}
@@ -0,0 +1,300 @@
+package filesystem
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrReadOnly is returned by the write operations of read-only Filesystems, see NewReadOnly
+	ErrReadOnly = errors.New("filesystem is read-only")
+	// ErrUnsafeArchiveEntry is returned for archive entries with absolute paths or paths escaping the archive
+	ErrUnsafeArchiveEntry = errors.New("archive entry path is unsafe")
+	// ErrUnsupportedArchive is returned by OpenArchive for files of an unknown archive format
+	ErrUnsupportedArchive = errors.New("unsupported archive format")
+	// ErrDuplicateArchiveEntry is returned for archives containing the same file more than once
+	ErrDuplicateArchiveEntry = errors.New("duplicate archive entry")
+	// ErrArchiveTooLarge is returned for archives whose files are larger than the maximum total size,
+	// see WithMaxTotalSize
+	ErrArchiveTooLarge = errors.New("archive is too large")
+)
+
+// DefaultMaxTotalSize is the default maximum total size of the files read from an archive,
+// see WithMaxTotalSize
+const DefaultMaxTotalSize = 16 << 20
+
+// ArchiveOption is an option for OpenArchive, NewTarArchive and NewZipArchive
+type ArchiveOption func(*archiveFilesystem)
+
+// WithMaxFileSize sets the maximum size of a file in the archive, after decompression. Reading a larger
+// file fails with ErrFileTooLarge, without reading more than this amount of bytes of it. This protects
+// against running out of memory for untrusted archives. A value less than one disables the limit.
+// (Default: DefaultMaxFileSize)
+func WithMaxFileSize(size int64) ArchiveOption {
+	return func(fs *archiveFilesystem) {
+		fs.maxFileSize = size
+	}
+}
+
+// WithMaxTotalSize sets the maximum total size of the files in the archive, after decompression. Reading
+// a larger archive fails with ErrArchiveTooLarge, e.g. for an archive with many small files compressing
+// well. A value less than one disables the limit. (Default: DefaultMaxTotalSize)
+func WithMaxTotalSize(size int64) ArchiveOption {
+	return func(fs *archiveFilesystem) {
+		fs.maxTotalSize = size
+	}
+}
+
+// gzipMagic are the first bytes of gzip-compressed data
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// OpenArchive returns a read-only Filesystem with the contents of the archive at the given path of the
+// local disk, see NewTarArchive and NewZipArchive. The format is detected from the extension of the
+// archive, which must be ".zip", ".tar", ".tar.gz" or ".tgz".
+func OpenArchive(filename, root string, opts ...ArchiveOption) (Filesystem, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	switch name := strings.ToLower(filename); {
+	case strings.HasSuffix(name, ".zip"):
+		info, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		return NewZipArchive(f, info.Size(), root, opts...)
+	case strings.HasSuffix(name, ".tar"), strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return NewTarArchive(f, root, opts...)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedArchive, filename)
+	}
+}
+
+// NewTarArchive returns a read-only Filesystem with the contents of the tar archive read from r, which
+// may be gzip-compressed. The archive is read into memory, without extracting it to disk, and its files
+// and directories are placed under the root directory. The files keep their permissions and modification
+// times. Symlinks and other special files are skipped, and entries with absolute paths or paths
+// escaping root make it fail with ErrUnsafeArchiveEntry. Files occurring more than once make it
+// fail with ErrDuplicateArchiveEntry. The size of the files is limited, see WithMaxFileSize and
+// WithMaxTotalSize.
+func NewTarArchive(r io.Reader, root string, opts ...ArchiveOption) (Filesystem, error) {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(len(gzipMagic)); bytes.Equal(magic, gzipMagic) {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer gr.Close()
+		r = gr
+	} else {
+		r = br
+	}
+
+	fs, err := newArchiveFilesystem(root, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = fs.addDir(hdr.Name)
+		case tar.TypeReg, tar.TypeRegA:
+			err = fs.addFile(hdr.Name, tr, hdr.FileInfo().Mode(), hdr.ModTime)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return NewReadOnly(fs.mem), nil
+}
+
+// NewZipArchive returns a read-only Filesystem with the contents of the zip archive of the given size
+// read from r. It otherwise behaves like NewTarArchive.
+func NewZipArchive(r io.ReaderAt, size int64, root string, opts ...ArchiveOption) (Filesystem, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	fs, err := newArchiveFilesystem(root, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range zr.File {
+		if err := fs.addZipFile(f); err != nil {
+			return nil, err
+		}
+	}
+
+	return NewReadOnly(fs.mem), nil
+}
+
+// archiveFilesystem builds the in-memory Filesystem of an archive
+type archiveFilesystem struct {
+	mem          *inMemoryFilesystem
+	root         string
+	maxFileSize  int64
+	maxTotalSize int64
+	// totalSize is the total size of the files read so far
+	totalSize int64
+}
+
+func newArchiveFilesystem(root string, opts []ArchiveOption) (*archiveFilesystem, error) {
+	fs := &archiveFilesystem{
+		mem:          NewInMemory().(*inMemoryFilesystem),
+		root:         filepath.Clean(root),
+		maxFileSize:  DefaultMaxFileSize,
+		maxTotalSize: DefaultMaxTotalSize,
+	}
+	for _, opt := range opts {
+		opt(fs)
+	}
+
+	if err := fs.mem.MkdirAll(fs.root, 0755); err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+// path returns the path of the given archive entry within the Filesystem
+func (fs *archiveFilesystem) path(name string) (string, error) {
+	// Archives use forward slashes, but some Windows tools write backslashes
+	name = strings.ReplaceAll(name, "\\", "/")
+	cleaned := path.Clean(name)
+	if path.IsAbs(name) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("%w: %q", ErrUnsafeArchiveEntry, name)
+	}
+	return filepath.Join(fs.root, filepath.FromSlash(cleaned)), nil
+}
+
+func (fs *archiveFilesystem) addDir(name string) error {
+	p, err := fs.path(name)
+	if err != nil {
+		return err
+	}
+	return fs.mem.MkdirAll(p, 0755)
+}
+
+func (fs *archiveFilesystem) addFile(name string, r io.Reader, mode os.FileMode, modTime time.Time) error {
+	p, err := fs.path(name)
+	if err != nil {
+		return err
+	}
+	if _, ok := fs.mem.files[p]; ok {
+		return fmt.Errorf("%w: %q", ErrDuplicateArchiveEntry, name)
+	}
+	data, err := fs.read(name, r)
+	if err != nil {
+		return err
+	}
+
+	// Archives don't necessarily contain entries for the parent directories
+	if err := fs.mem.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	if err := fs.mem.WriteFile(p, data, mode); err != nil {
+		return err
+	}
+
+	fs.mem.files[p].modTime = modTime
+	return nil
+}
+
+// read reads the content of the archive entry with the given name from r, without exceeding
+// the maximum file size and total size
+func (fs *archiveFilesystem) read(name string, r io.Reader) ([]byte, error) {
+	// Read one byte more than allowed, to tell whether the limit is exceeded
+	limit := int64(-1)
+	if fs.maxFileSize > 0 {
+		limit = fs.maxFileSize
+	}
+	if fs.maxTotalSize > 0 && (limit < 0 || fs.maxTotalSize-fs.totalSize < limit) {
+		limit = fs.maxTotalSize - fs.totalSize
+	}
+	if limit >= 0 {
+		r = io.LimitReader(r, limit+1)
+	}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	size := int64(len(data))
+	if fs.maxFileSize > 0 && size > fs.maxFileSize {
+		return nil, fmt.Errorf("%w: %q exceeds the maximum size of %d bytes", ErrFileTooLarge, name, fs.maxFileSize)
+	}
+	fs.totalSize += size
+	if fs.maxTotalSize > 0 && fs.totalSize > fs.maxTotalSize {
+		return nil, fmt.Errorf("%w: the files exceed the maximum total size of %d bytes", ErrArchiveTooLarge, fs.maxTotalSize)
+	}
+	return data, nil
+}
+
+func (fs *archiveFilesystem) addZipFile(f *zip.File) error {
+	mode := f.Mode()
+	if mode.IsDir() {
+		return fs.addDir(f.Name)
+	}
+	if !mode.IsRegular() {
+		return nil
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return fs.addFile(f.Name, rc, mode, f.Modified)
+}
+
+// NewReadOnly returns a Filesystem that reads from fs, but whose write operations fail with ErrReadOnly
+func NewReadOnly(fs Filesystem) Filesystem {
+	return readOnlyFilesystem{fs}
+}
+
+// readOnlyFilesystem rejects all write operations of the embedded Filesystem
+type readOnlyFilesystem struct {
+	Filesystem
+}
+
+func (readOnlyFilesystem) WriteFile(filename string, _ []byte, _ os.FileMode) error {
+	return &os.PathError{Op: "open", Path: filename, Err: ErrReadOnly}
+}
+
+func (readOnlyFilesystem) MkdirAll(path string, _ os.FileMode) error {
+	return &os.PathError{Op: "mkdir", Path: path, Err: ErrReadOnly}
+}
+
+func (readOnlyFilesystem) Remove(name string) error {
+	return &os.PathError{Op: "remove", Path: name, Err: ErrReadOnly}
+}
+
+func (readOnlyFilesystem) RemoveAll(path string) error {
+	return &os.PathError{Op: "remove", Path: path, Err: ErrReadOnly}
+}
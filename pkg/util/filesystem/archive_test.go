@@ -0,0 +1,245 @@
+package filesystem
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// archiveEntry is a file, directory (if content is empty and the name ends in a slash) or symlink of a test archive
+type archiveEntry struct {
+	name    string
+	content string
+	symlink bool
+}
+
+var archiveModTime = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func newTarGz(t *testing.T, entries ...archiveEntry) []byte {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, ModTime: archiveModTime, Typeflag: tar.TypeReg, Size: int64(len(e.content))}
+		switch {
+		case e.symlink:
+			hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeSymlink, e.content, 0
+		case e.name[len(e.name)-1] == '/':
+			hdr.Typeflag, hdr.Mode = tar.TypeDir, 0755
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			if _, err := tw.Write([]byte(e.content)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func newZip(t *testing.T, entries ...archiveEntry) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &zip.FileHeader{Name: e.name, Modified: archiveModTime}
+		hdr.SetMode(0644)
+		if e.symlink {
+			hdr.SetMode(os.ModeSymlink | 0777)
+		}
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestArchive(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "archive-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	entries := []archiveEntry{
+		{name: "cars/"},
+		{name: "cars/foo.yaml", content: "foo"},
+		// The parent directory has no entry of its own
+		{name: "trucks/nested/bar.json", content: "bar"},
+		{name: "cars/link.yaml", content: "/etc/passwd", symlink: true},
+	}
+	tarGz := newTarGz(t, entries...)
+	zipData := newZip(t, entries...)
+
+	open := func(name string, data []byte) Filesystem {
+		filename := filepath.Join(tmpDir, name)
+		if err := ioutil.WriteFile(filename, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		fs, err := OpenArchive(filename, "/archive")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fs
+	}
+	implementations := []struct {
+		name string
+		fs   Filesystem
+	}{
+		{"tar.gz", open("manifests.tar.gz", tarGz)},
+		{"zip", open("manifests.zip", zipData)},
+	}
+	for _, impl := range implementations {
+		t.Run(impl.name, func(t *testing.T) {
+			fs := impl.fs
+
+			var files []string
+			if err := fs.Walk("/archive", func(path string, info os.FileInfo, err error) error {
+				if err == nil && !info.IsDir() {
+					files = append(files, path)
+				}
+				return err
+			}); err != nil {
+				t.Fatal(err)
+			}
+			// Symlinks are skipped
+			expected := []string{"/archive/cars/foo.yaml", "/archive/trucks/nested/bar.json"}
+			if !reflect.DeepEqual(files, expected) {
+				t.Errorf("expected files %v, got %v", expected, files)
+			}
+
+			if content, err := fs.ReadFile("/archive/trucks/nested/bar.json"); err != nil || string(content) != "bar" {
+				t.Errorf("expected content %q, got %q (%v)", "bar", content, err)
+			}
+			info, err := fs.Stat("/archive/cars/foo.yaml")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode() != 0644 || !info.ModTime().Equal(archiveModTime) {
+				t.Errorf("expected mode 0644 and modification time %v, got %v and %v", archiveModTime, info.Mode(), info.ModTime())
+			}
+
+			writes := map[string]error{
+				"write":      fs.WriteFile("/archive/cars/foo.yaml", []byte("changed"), 0644),
+				"mkdir":      fs.MkdirAll("/archive/buses", 0755),
+				"remove":     fs.Remove("/archive/cars/foo.yaml"),
+				"remove all": fs.RemoveAll("/archive"),
+			}
+			for name, err := range writes {
+				if !errors.Is(err, ErrReadOnly) {
+					t.Errorf("%s: expected ErrReadOnly, got %v", name, err)
+				}
+			}
+			if content, err := fs.ReadFile("/archive/cars/foo.yaml"); err != nil || string(content) != "foo" {
+				t.Errorf("expected the file to be unchanged, got %q (%v)", content, err)
+			}
+		})
+	}
+
+	// Uncompressed tar archives are supported too
+	gr, err := gzip.NewReader(bytes.NewReader(tarGz))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fs, err := NewTarArchive(gr, "archive"); err != nil {
+		t.Error(err)
+	} else if !FileExists(fs, "archive/cars/foo.yaml") {
+		t.Error("expected the file of the uncompressed archive to exist")
+	}
+
+	if _, err := OpenArchive(filepath.Join(tmpDir, "manifests.rar"), "/archive"); !os.IsNotExist(err) {
+		t.Errorf("expected not exist error for missing archive, got %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, "manifests.rar"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenArchive(filepath.Join(tmpDir, "manifests.rar"), "/archive"); !errors.Is(err, ErrUnsupportedArchive) {
+		t.Errorf("expected ErrUnsupportedArchive, got %v", err)
+	}
+}
+
+func TestArchiveUnsafeEntries(t *testing.T) {
+	for _, name := range []string{"../escaped.yaml", "cars/../../escaped.yaml", "/etc/escaped.yaml", "..\\escaped.yaml"} {
+		entry := archiveEntry{name: name, content: "escaped"}
+		if _, err := NewTarArchive(bytes.NewReader(newTarGz(t, entry)), "/archive"); !errors.Is(err, ErrUnsafeArchiveEntry) {
+			t.Errorf("tar %q: expected ErrUnsafeArchiveEntry, got %v", name, err)
+		}
+		data := newZip(t, entry)
+		if _, err := NewZipArchive(bytes.NewReader(data), int64(len(data)), "/archive"); !errors.Is(err, ErrUnsafeArchiveEntry) {
+			t.Errorf("zip %q: expected ErrUnsafeArchiveEntry, got %v", name, err)
+		}
+	}
+
+	// Paths that only look suspicious stay within the archive
+	fs, err := NewTarArchive(bytes.NewReader(newTarGz(t, archiveEntry{name: "./cars/..foo.yaml", content: "foo"})), "/archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !FileExists(fs, "/archive/cars/..foo.yaml") {
+		t.Error("expected the file to exist")
+	}
+}
+
+func TestArchiveLimits(t *testing.T) {
+	newTar := func(entries ...archiveEntry) *bytes.Reader {
+		return bytes.NewReader(newTarGz(t, entries...))
+	}
+
+	// A gzip bomb is rejected by default, without decompressing all of it
+	bomb := archiveEntry{name: "bomb.yaml", content: strings.Repeat("\x00", DefaultMaxFileSize+1)}
+	if _, err := NewTarArchive(newTar(bomb), "/archive"); !errors.Is(err, ErrFileTooLarge) {
+		t.Errorf("expected ErrFileTooLarge, got %v", err)
+	}
+	data := newZip(t, bomb)
+	if _, err := NewZipArchive(bytes.NewReader(data), int64(len(data)), "/archive"); !errors.Is(err, ErrFileTooLarge) {
+		t.Errorf("expected ErrFileTooLarge, got %v", err)
+	}
+
+	// The limits are configurable, and files of exactly the maximum size are allowed
+	entries := []archiveEntry{{name: "a.yaml", content: "1234"}, {name: "b.yaml", content: "5678"}}
+	if _, err := NewTarArchive(newTar(entries...), "/archive", WithMaxFileSize(4), WithMaxTotalSize(8)); err != nil {
+		t.Errorf("expected the archive to be read, got %v", err)
+	}
+	if _, err := NewTarArchive(newTar(entries...), "/archive", WithMaxFileSize(3)); !errors.Is(err, ErrFileTooLarge) {
+		t.Errorf("expected ErrFileTooLarge, got %v", err)
+	}
+	if _, err := NewTarArchive(newTar(entries...), "/archive", WithMaxTotalSize(7)); !errors.Is(err, ErrArchiveTooLarge) {
+		t.Errorf("expected ErrArchiveTooLarge, got %v", err)
+	}
+	if _, err := NewTarArchive(newTar(bomb), "/archive", WithMaxFileSize(0), WithMaxTotalSize(0)); err != nil {
+		t.Errorf("expected the limits to be disabled, got %v", err)
+	}
+
+	// Files occurring twice are rejected instead of being overwritten
+	duplicates := []archiveEntry{{name: "cars/foo.yaml", content: "foo"}, {name: "./cars//foo.yaml", content: "bar"}}
+	if _, err := NewTarArchive(newTar(duplicates...), "/archive"); !errors.Is(err, ErrDuplicateArchiveEntry) {
+		t.Errorf("expected ErrDuplicateArchiveEntry, got %v", err)
+	}
+	data = newZip(t, duplicates...)
+	if _, err := NewZipArchive(bytes.NewReader(data), int64(len(data)), "/archive"); !errors.Is(err, ErrDuplicateArchiveEntry) {
+		t.Errorf("expected ErrDuplicateArchiveEntry, got %v", err)
+	}
+}
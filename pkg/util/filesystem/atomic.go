@@ -0,0 +1,98 @@
+package filesystem
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// rename renames the temporary files over the target files, it's replaced by tests to simulate crashes
+var rename = os.Rename
+
+// writeFileAtomic writes data to a temporary file in the directory of filename, and renames it over
+// filename. This makes sure that filename has either its old or its new content, also after a crash.
+// If filename exists, its mode and owner are kept, just like for ioutil.WriteFile, otherwise it's
+// created with permissions perm (before umask). If the process crashes before the rename, the
+// temporary file, which is hidden and has the ".tmp" extension, is left behind.
+func (fs osFilesystem) writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	info, err := os.Stat(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if info != nil && info.IsDir() {
+		return &os.PathError{Op: "open", Path: filename, Err: syscall.EISDIR}
+	}
+
+	var tmp *os.File
+	if err := createTemp(filename, func(name string) (err error) {
+		tmp, err = os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+		return err
+	}); err != nil {
+		return err
+	}
+	// Remove the temporary file unless it was renamed
+	renamed := false
+	defer func() {
+		if !renamed {
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+
+	if err := fs.writeTemp(tmp, info, data); err != nil {
+		return err
+	}
+	if err := rename(tmp.Name(), filename); err != nil {
+		return err
+	}
+	renamed = true
+
+	// Persist the rename as well
+	if fs.fsync {
+		return syncDir(filepath.Dir(filename))
+	}
+	return nil
+}
+
+// writeTemp writes data to the temporary file tmp, copies the mode and owner from info of the
+// existing file if it's non-nil, and closes tmp
+func (fs osFilesystem) writeTemp(tmp *os.File, info os.FileInfo, data []byte) error {
+	err := func() error {
+		if info != nil {
+			// Change the owner first, as it may clear the setuid and setgid bits
+			if err := chown(tmp, info); err != nil {
+				return err
+			}
+			if err := os.Chmod(tmp.Name(), info.Mode()&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+				return err
+			}
+		}
+
+		if _, err := tmp.Write(data); err != nil {
+			return err
+		}
+		if fs.fsync {
+			return tmp.Sync()
+		}
+		return nil
+	}()
+
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// createTemp invokes create with names for a new hidden temporary file for filename in its directory,
+// until it returns another error than a file exists error
+func createTemp(filename string, create func(name string) error) error {
+	dir, base := filepath.Split(filename)
+	prefix := filepath.Join(dir, "."+base+"."+strconv.Itoa(os.Getpid())+"-"+strconv.FormatInt(time.Now().UnixNano(), 36))
+	for i := 0; ; i++ {
+		err := create(prefix + "-" + strconv.Itoa(i) + ".tmp")
+		if !os.IsExist(err) || i == 100 {
+			return err
+		}
+	}
+}
@@ -0,0 +1,15 @@
+// +build windows plan9
+
+package filesystem
+
+import "os"
+
+// chown is a no-op, files don't have owners like on unix
+func chown(*os.File, os.FileInfo) error {
+	return nil
+}
+
+// syncDir is a no-op, directories can't be flushed separately
+func syncDir(string) error {
+	return nil
+}
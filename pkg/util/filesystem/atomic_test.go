@@ -0,0 +1,109 @@
+package filesystem
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/osfs"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filesystem-atomic-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "foo.yaml")
+
+	implementations := []struct {
+		fs   Filesystem
+		file string
+	}{
+		{NewOSFilesystem(), file},
+		{NewOSFilesystem(WithFsync(false)), file},
+		{NewBillyFilesystem(osfs.New(dir)), "foo.yaml"},
+	}
+	for _, impl := range implementations {
+		if err := impl.fs.WriteFile(impl.file, []byte("foo"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		// The mode of existing files is kept
+		if err := os.Chmod(file, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := impl.fs.WriteFile(impl.file, []byte("foo2"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		expectFile(t, file, "foo2", 0600)
+		if err := os.Remove(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Symlinks are written through, instead of being replaced
+	if err := NewOSFilesystem().WriteFile(file, []byte("foo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "link.yaml")
+	if err := os.Symlink(file, link); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewOSFilesystem().WriteFile(link, []byte("bar"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("expected the symlink to be kept, got %v", err)
+	}
+	expectFile(t, file, "bar", 0644)
+	if err := os.Remove(link); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a crash between writing the temporary file and renaming it
+	errCrash := errors.New("crash")
+	rename = func(tmp, target string) error {
+		// The new content is only in the temporary file, the target isn't modified yet
+		expectFile(t, tmp, "baz", 0644)
+		expectFile(t, target, "bar", 0644)
+		return errCrash
+	}
+	defer func() { rename = os.Rename }()
+
+	if err := NewOSFilesystem().WriteFile(file, []byte("baz"), 0644); !errors.Is(err, errCrash) {
+		t.Fatalf("expected the crash error, got %v", err)
+	}
+	expectFile(t, file, "bar", 0644)
+
+	// The temporary file is removed after a failed write
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 {
+		t.Errorf("expected only the written file to be left, got %d files", len(infos))
+	}
+}
+
+func expectFile(t *testing.T, file, content string, perm os.FileMode) {
+	t.Helper()
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != content {
+		t.Errorf("expected %s to contain %q, got %q", file, content, data)
+	}
+
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The permissions of new files depend on the umask
+	if mode := info.Mode().Perm(); mode&^perm != 0 {
+		t.Errorf("expected %s to have mode %v, got %v", file, perm, mode)
+	}
+}
@@ -0,0 +1,28 @@
+// +build !windows,!plan9
+
+package filesystem
+
+import (
+	"os"
+	"syscall"
+)
+
+// chown changes the owner of f to the one of the file described by info, if they differ
+func chown(f *os.File, info os.FileInfo) error {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || (stat.Uid == uint32(os.Geteuid()) && stat.Gid == uint32(os.Getegid())) {
+		return nil
+	}
+	return f.Chown(int(stat.Uid), int(stat.Gid))
+}
+
+// syncDir flushes the entries of the directory dir to the disk
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return f.Sync()
+}
@@ -0,0 +1,116 @@
+package filesystem
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+)
+
+// NewBillyFilesystem returns a Filesystem backed by the given billy.Filesystem, e.g.
+// the worktree of a go-git repository. Paths are interpreted by fs, which usually
+// means that they're relative to its root directory.
+func NewBillyFilesystem(fs billy.Filesystem) Filesystem {
+	return &billyFilesystem{fs}
+}
+
+// billyFilesystem implements Filesystem using a billy.Filesystem
+type billyFilesystem struct {
+	fs billy.Filesystem
+}
+
+func (b *billyFilesystem) ReadFile(filename string) ([]byte, error) {
+	f, err := b.fs.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ioutil.ReadAll(f)
+}
+
+// WriteFile writes data to a temporary file, which is renamed over filename, just like for the
+// Filesystem returned by NewOSFilesystem. Whether the rename is atomic depends on the billy.Filesystem.
+// The permissions of an existing file are kept, subject to the umask unless the billy.Filesystem
+// implements billy.Change.
+func (b *billyFilesystem) WriteFile(filename string, data []byte, perm os.FileMode) error {
+	info, err := b.fs.Stat(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if info != nil && info.IsDir() {
+		return &os.PathError{Op: "open", Path: filename, Err: syscall.EISDIR}
+	} else if info != nil {
+		perm = info.Mode().Perm()
+	}
+
+	var tmp billy.File
+	if err := createTemp(filename, func(name string) (err error) {
+		tmp, err = b.fs.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+		return err
+	}); err != nil {
+		return err
+	}
+	// Remove the temporary file unless it was renamed
+	renamed := false
+	defer func() {
+		if !renamed {
+			_ = b.fs.Remove(tmp.Name())
+		}
+	}()
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if change, ok := b.fs.(billy.Change); ok && info != nil {
+		if err := change.Chmod(tmp.Name(), info.Mode()); err != nil {
+			return err
+		}
+	}
+
+	if err := b.fs.Rename(tmp.Name(), filename); err != nil {
+		return err
+	}
+	renamed = true
+	return nil
+}
+
+func (b *billyFilesystem) MkdirAll(path string, perm os.FileMode) error {
+	return b.fs.MkdirAll(path, perm)
+}
+
+func (b *billyFilesystem) Remove(name string) error {
+	return b.fs.Remove(name)
+}
+
+func (b *billyFilesystem) RemoveAll(path string) error {
+	return util.RemoveAll(b.fs, path)
+}
+
+func (b *billyFilesystem) Stat(name string) (os.FileInfo, error) {
+	return b.fs.Stat(name)
+}
+
+func (b *billyFilesystem) ReadDir(dirname string) ([]os.FileInfo, error) {
+	infos, err := b.fs.ReadDir(dirname)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+	return infos, nil
+}
+
+func (b *billyFilesystem) Walk(root string, walkFn filepath.WalkFunc) error {
+	return walk(b, root, walkFn)
+}
@@ -0,0 +1,76 @@
+package filesystem
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+)
+
+// Checksummer computes checksums of files, used for detecting changes to them
+type Checksummer interface {
+	// Checksum returns the checksum of the file at path in fs, prefixed with the
+	// name of the algorithm, e.g. "sha256:<hex>". Checksums computed by different
+	// algorithms hence never compare equal.
+	Checksum(fs Filesystem, path string) (string, error)
+}
+
+// ChecksumFunc computes a checksum of the file at path in fs
+type ChecksumFunc func(fs Filesystem, path string) (string, error)
+
+// NewChecksummer returns a Checksummer computing checksums using fn, prefixed with name.
+// This can be used to plug in any checksum algorithm, e.g. xxhash.
+func NewChecksummer(name string, fn ChecksumFunc) Checksummer {
+	return &checksummer{name, fn}
+}
+
+var (
+	// ModTimeChecksummer uses the modification time of the file as the checksum. This is
+	// the cheapest way to detect changes, as the file doesn't need to be read. (Default)
+	ModTimeChecksummer = NewChecksummer("mtime", func(fs Filesystem, path string) (string, error) {
+		fi, err := fs.Stat(path)
+		if err != nil {
+			return "", err
+		}
+
+		return strconv.FormatInt(fi.ModTime().UnixNano(), 10), nil
+	})
+
+	// SizeModTimeChecksummer uses both the size and the modification time of the file as the
+	// checksum. This detects changes that don't alter the modification time of the file.
+	SizeModTimeChecksummer = NewChecksummer("size-mtime", func(fs Filesystem, path string) (string, error) {
+		fi, err := fs.Stat(path)
+		if err != nil {
+			return "", err
+		}
+
+		return fmt.Sprintf("%d-%d", fi.Size(), fi.ModTime().UnixNano()), nil
+	})
+
+	// SHA256Checksummer uses the SHA-256 hash of the file contents as the checksum. This
+	// only detects real content changes, but requires reading the whole file.
+	SHA256Checksummer = NewChecksummer("sha256", func(fs Filesystem, path string) (string, error) {
+		content, err := fs.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+
+		sum := sha256.Sum256(content)
+		return hex.EncodeToString(sum[:]), nil
+	})
+)
+
+// checksummer implements Checksummer for a named ChecksumFunc
+type checksummer struct {
+	name string
+	fn   ChecksumFunc
+}
+
+func (c *checksummer) Checksum(fs Filesystem, path string) (string, error) {
+	sum, err := c.fn(fs, path)
+	if err != nil {
+		return "", err
+	}
+
+	return c.name + ":" + sum, nil
+}
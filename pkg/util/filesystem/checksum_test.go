@@ -0,0 +1,93 @@
+package filesystem
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChecksummers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checksum-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs := NewOSFilesystem()
+	file := filepath.Join(dir, "car.yaml")
+	modTime := time.Unix(1600000000, 0)
+	write := func(content string) {
+		if err := fs.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		// Keep the modification time the same for all writes
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	checksummers := map[string]Checksummer{
+		"mtime":      ModTimeChecksummer,
+		"size-mtime": SizeModTimeChecksummer,
+		"sha256":     SHA256Checksummer,
+		"custom": NewChecksummer("custom", func(fs Filesystem, path string) (string, error) {
+			return "static", nil
+		}),
+	}
+
+	write("engine: v8")
+	cached := map[string]string{}
+	for name, c := range checksummers {
+		sum, err := c.Checksum(fs, file)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !strings.HasPrefix(sum, name+":") {
+			t.Errorf("%s: expected checksum %q to be prefixed with the algorithm name", name, sum)
+		}
+		cached[name] = sum
+	}
+
+	// Checksums computed by different algorithms must never compare equal, so changing
+	// the algorithm invalidates all previously cached checksums
+	for name, sum := range cached {
+		for otherName, c := range checksummers {
+			if otherName == name {
+				continue
+			}
+			if otherSum, _ := c.Checksum(fs, file); otherSum == sum {
+				t.Errorf("checksums of %s and %s compare equal: %q", name, otherName, sum)
+			}
+		}
+	}
+
+	tests := []struct {
+		content string
+		changed map[string]bool
+	}{
+		// Same size and modification time, only the content hash detects the change
+		{"engine: v6", map[string]bool{"mtime": false, "size-mtime": false, "sha256": true, "custom": false}},
+		// Different size, but same modification time
+		{"engine: v12", map[string]bool{"mtime": false, "size-mtime": true, "sha256": true, "custom": false}},
+	}
+	for _, tt := range tests {
+		write(tt.content)
+		for name, c := range checksummers {
+			sum, err := c.Checksum(fs, file)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if changed := sum != cached[name]; changed != tt.changed[name] {
+				t.Errorf("%s: writing %q: expected changed=%t, got %t", name, tt.content, tt.changed[name], changed)
+			}
+			cached[name] = sum
+		}
+	}
+
+	if _, err := SHA256Checksummer.Checksum(fs, filepath.Join(dir, "missing.yaml")); !os.IsNotExist(err) {
+		t.Errorf("expected not exist error for missing file, got %v", err)
+	}
+}
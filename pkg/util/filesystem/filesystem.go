@@ -0,0 +1,172 @@
+package filesystem
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/go-logr/logr"
+	"github.com/weaveworks/libgitops/pkg/logs"
+)
+
+// Filesystem is an abstraction of the filesystem operations used by the storages.
+// It makes it possible to back a storage by something else than the local disk,
+// e.g. memory for testing. The semantics of all methods match their counterparts
+// in the os, io/ioutil and path/filepath packages.
+type Filesystem interface {
+	// ReadFile reads the file named by filename and returns the contents.
+	ReadFile(filename string) ([]byte, error)
+	// WriteFile writes data to a file named by filename. If the file does not
+	// exist, WriteFile creates it with permissions perm. The parent directory
+	// must exist.
+	WriteFile(filename string, data []byte, perm os.FileMode) error
+	// MkdirAll creates a directory named path, along with any necessary parents.
+	MkdirAll(path string, perm os.FileMode) error
+	// Remove removes the named file or (empty) directory.
+	Remove(name string) error
+	// RemoveAll removes path and any children it contains. If the path does
+	// not exist, RemoveAll returns nil.
+	RemoveAll(path string) error
+	// Stat returns a FileInfo describing the named file.
+	Stat(name string) (os.FileInfo, error)
+	// ReadDir reads the directory named by dirname and returns a list of
+	// directory entries sorted by filename.
+	ReadDir(dirname string) ([]os.FileInfo, error)
+	// Walk walks the file tree rooted at root, calling walkFn for each file or
+	// directory in the tree, including root, in lexical order.
+	Walk(root string, walkFn filepath.WalkFunc) error
+}
+
+// NewOSFilesystem returns a Filesystem backed by the local disk. WriteFile replaces files atomically,
+// see WithFsync. It also takes an advisory lock (flock, or LockFileEx on Windows) of the directory of
+// the file while writing, so that concurrent writes of other processes using this Filesystem don't
+// corrupt the file. If the filesystem doesn't support locking, e.g. for some network filesystems,
+// a warning is logged and the files are written without locks.
+func NewOSFilesystem(opts ...OSOption) Filesystem {
+	fs := osFilesystem{fsync: true}
+	for _, opt := range opts {
+		opt(&fs)
+	}
+	fs.logger = logs.OrDefault(fs.logger).WithName("Filesystem")
+	return fs
+}
+
+// OSOption is an option for NewOSFilesystem
+type OSOption func(*osFilesystem)
+
+// WithFsync sets whether WriteFile flushes the written files and their directories to the disk
+// before returning. The files are written to a temporary file which is renamed over the target
+// file, so a crash never leaves a half-written file behind either way, but without fsync, the
+// write may be lost after a power loss. (Default: true)
+func WithFsync(fsync bool) OSOption {
+	return func(fs *osFilesystem) {
+		fs.fsync = fsync
+	}
+}
+
+// WithLogger sets the logr.Logger receiving the warning about unsupported locking, with the
+// directory as a key/value pair. (Default: nil, which logs to logs.Logger, see logs.NewLogger)
+func WithLogger(logger logr.Logger) OSOption {
+	return func(fs *osFilesystem) {
+		fs.logger = logger
+	}
+}
+
+// osFilesystem implements Filesystem using the os, io/ioutil and path/filepath packages
+type osFilesystem struct {
+	fsync  bool
+	logger logr.Logger
+}
+
+func (osFilesystem) ReadFile(filename string) ([]byte, error) {
+	return ioutil.ReadFile(filename)
+}
+
+func (fs osFilesystem) WriteFile(filename string, data []byte, perm os.FileMode) error {
+	// Write the target of symlinks, like ioutil.WriteFile does, instead of replacing the symlink
+	if resolved, err := filepath.EvalSymlinks(filename); err == nil {
+		filename = resolved
+	}
+
+	unlock, err := lockDir(filepath.Dir(filename), fs.logger)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return fs.writeFileAtomic(filename, data, perm)
+}
+
+func (osFilesystem) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+func (osFilesystem) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFilesystem) RemoveAll(path string) error {
+	return os.RemoveAll(path)
+}
+
+func (osFilesystem) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFilesystem) ReadDir(dirname string) ([]os.FileInfo, error) {
+	return ioutil.ReadDir(dirname)
+}
+
+func (osFilesystem) Walk(root string, walkFn filepath.WalkFunc) error {
+	return filepath.Walk(root, walkFn)
+}
+
+// FileExists returns true if the given path exists in fs, and is not a directory
+func FileExists(fs Filesystem, filename string) bool {
+	info, err := fs.Stat(filename)
+	if err != nil {
+		return false
+	}
+
+	return !info.IsDir()
+}
+
+// walk implements Filesystem.Walk for Filesystems without a native implementation, using
+// Stat and ReadDir. This mirrors filepath.Walk.
+func walk(fs Filesystem, root string, walkFn filepath.WalkFunc) error {
+	info, err := fs.Stat(root)
+	if err != nil {
+		err = walkFn(root, nil, err)
+	} else {
+		err = walkPath(fs, root, info, walkFn)
+	}
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+// walkPath recursively descends path, calling walkFn. This mirrors filepath.walk.
+func walkPath(fs Filesystem, path string, info os.FileInfo, walkFn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return walkFn(path, info, nil)
+	}
+
+	infos, err := fs.ReadDir(path)
+	err1 := walkFn(path, info, err)
+	// If err != nil, walk can't walk into this directory. If err1 != nil, walkFn wants
+	// walk to skip this directory or stop walking.
+	if err != nil || err1 != nil {
+		return err1
+	}
+
+	for _, fileInfo := range infos {
+		filename := filepath.Join(path, fileInfo.Name())
+		if err := walkPath(fs, filename, fileInfo, walkFn); err != nil {
+			if !fileInfo.IsDir() || err != filepath.SkipDir {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package filesystem
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// TestFilesystemConformance runs the same set of tests against all Filesystem implementations,
+// to make sure they behave the same way
+func TestFilesystemConformance(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "filesystem-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	implementations := []struct {
+		name string
+		fs   Filesystem
+		root string
+	}{
+		{"os", NewOSFilesystem(), tmpDir},
+		{"in-memory", NewInMemory(), "/in-memory"},
+	}
+	for _, impl := range implementations {
+		t.Run(impl.name, func(t *testing.T) {
+			testFilesystem(t, impl.fs, impl.root)
+		})
+	}
+}
+
+func testFilesystem(t *testing.T, fs Filesystem, root string) {
+	join := func(elem ...string) string {
+		return filepath.Join(append([]string{root}, elem...)...)
+	}
+
+	if err := fs.MkdirAll(join("cars", "nested"), 0755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	// MkdirAll is a no-op for existing directories
+	if err := fs.MkdirAll(join("cars"), 0755); err != nil {
+		t.Fatalf("MkdirAll on existing directory: %v", err)
+	}
+
+	t.Run("write and read", func(t *testing.T) {
+		if err := fs.WriteFile(join("cars", "foo.yaml"), []byte("foo"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		// Overwrite the file with new content
+		if err := fs.WriteFile(join("cars", "foo.yaml"), []byte("foo2"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		content, err := fs.ReadFile(join("cars", "foo.yaml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != "foo2" {
+			t.Errorf("expected content %q, got %q", "foo2", content)
+		}
+		// Paths are cleaned
+		if _, err := fs.ReadFile(join("cars", "nested", "..", "foo.yaml")); err != nil {
+			t.Errorf("expected unclean path to resolve: %v", err)
+		}
+	})
+
+	t.Run("error semantics", func(t *testing.T) {
+		if _, err := fs.ReadFile(join("cars", "missing.yaml")); !os.IsNotExist(err) {
+			t.Errorf("expected not exist error for missing file, got %v", err)
+		}
+		if err := fs.WriteFile(join("missing", "foo.yaml"), []byte("foo"), 0644); !os.IsNotExist(err) {
+			t.Errorf("expected not exist error for missing parent directory, got %v", err)
+		}
+		if _, err := fs.ReadFile(join("cars")); err == nil {
+			t.Error("expected error when reading a directory")
+		}
+		if err := fs.Remove(join("cars", "missing.yaml")); !os.IsNotExist(err) {
+			t.Errorf("expected not exist error when removing a missing file, got %v", err)
+		}
+		if err := fs.Remove(join("cars")); err == nil {
+			t.Error("expected error when removing a non-empty directory")
+		}
+		if err := fs.MkdirAll(join("cars", "foo.yaml", "dir"), 0755); err == nil {
+			t.Error("expected error when creating a directory under a file")
+		}
+	})
+
+	t.Run("stat", func(t *testing.T) {
+		info, err := fs.Stat(join("cars", "foo.yaml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Name() != "foo.yaml" || info.IsDir() || info.Size() != 4 {
+			t.Errorf("unexpected file info: name=%q dir=%t size=%d", info.Name(), info.IsDir(), info.Size())
+		}
+		if info, err = fs.Stat(join("cars")); err != nil || !info.IsDir() {
+			t.Errorf("expected directory, got %v, %v", info, err)
+		}
+		if !FileExists(fs, join("cars", "foo.yaml")) || FileExists(fs, join("cars")) || FileExists(fs, join("bar")) {
+			t.Error("unexpected FileExists result")
+		}
+	})
+
+	t.Run("read dir", func(t *testing.T) {
+		if err := fs.WriteFile(join("cars", "bar.yaml"), []byte("bar"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		infos, err := fs.ReadDir(join("cars"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := []string{}
+		for _, info := range infos {
+			names = append(names, info.Name())
+		}
+		if expected := []string{"bar.yaml", "foo.yaml", "nested"}; !reflect.DeepEqual(names, expected) {
+			t.Errorf("expected %v, got %v", expected, names)
+		}
+	})
+
+	t.Run("walk", func(t *testing.T) {
+		if err := fs.WriteFile(join("cars", "nested", "baz.yaml"), []byte("baz"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		var paths []string
+		err := fs.Walk(join("cars"), func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, _ := filepath.Rel(root, path)
+			paths = append(paths, rel)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []string{"cars", "cars/bar.yaml", "cars/foo.yaml", "cars/nested", "cars/nested/baz.yaml"}
+		if !reflect.DeepEqual(paths, expected) {
+			t.Errorf("expected %v, got %v", expected, paths)
+		}
+
+		// SkipDir skips the contents of the directory
+		paths = nil
+		_ = fs.Walk(join("cars"), func(path string, info os.FileInfo, err error) error {
+			if info.IsDir() && info.Name() == "nested" {
+				return filepath.SkipDir
+			}
+			paths = append(paths, info.Name())
+			return nil
+		})
+		if expected := []string{"cars", "bar.yaml", "foo.yaml"}; !reflect.DeepEqual(paths, expected) {
+			t.Errorf("expected %v, got %v", expected, paths)
+		}
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		if err := fs.Remove(join("cars", "bar.yaml")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fs.Stat(join("cars", "bar.yaml")); !os.IsNotExist(err) {
+			t.Errorf("expected file to be removed, got %v", err)
+		}
+		if err := fs.RemoveAll(join("cars")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fs.Stat(join("cars", "nested", "baz.yaml")); !os.IsNotExist(err) {
+			t.Errorf("expected nested file to be removed, got %v", err)
+		}
+		if err := fs.RemoveAll(join("cars")); err != nil {
+			t.Errorf("expected RemoveAll of a missing path to succeed, got %v", err)
+		}
+	})
+
+	t.Run("concurrent use", func(t *testing.T) {
+		if err := fs.MkdirAll(join("concurrent"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				file := join("concurrent", string(rune('a'+i)))
+				if err := fs.WriteFile(file, []byte{byte(i)}, 0644); err != nil {
+					t.Error(err)
+				}
+				if _, err := fs.ReadFile(file); err != nil {
+					t.Error(err)
+				}
+				_, _ = fs.ReadDir(join("concurrent"))
+			}(i)
+		}
+		wg.Wait()
+		if infos, err := fs.ReadDir(join("concurrent")); err != nil || len(infos) != 10 {
+			t.Errorf("expected 10 files, got %d: %v", len(infos), err)
+		}
+	})
+}
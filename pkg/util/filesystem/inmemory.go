@@ -0,0 +1,239 @@
+package filesystem
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// NewInMemory returns a Filesystem that stores all files and directories in memory.
+// Paths are cleaned using filepath.Clean, and the root directory ("/", or "." for
+// relative paths) always exists. The returned Filesystem is safe for concurrent use.
+func NewInMemory() Filesystem {
+	return &inMemoryFilesystem{
+		files: make(map[string]*memFile),
+	}
+}
+
+// inMemoryFilesystem implements Filesystem using a map of paths to files
+type inMemoryFilesystem struct {
+	files map[string]*memFile
+	mux   sync.RWMutex
+}
+
+// memFile is a file or directory stored in memory
+type memFile struct {
+	data    []byte
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (fs *inMemoryFilesystem) ReadFile(filename string) ([]byte, error) {
+	fs.mux.RLock()
+	defer fs.mux.RUnlock()
+
+	f, err := fs.get("open", filename)
+	if err != nil {
+		return nil, err
+	}
+	if f.mode.IsDir() {
+		return nil, &os.PathError{Op: "read", Path: filename, Err: syscall.EISDIR}
+	}
+
+	// Return a copy, so the caller can't modify the stored data
+	return append([]byte{}, f.data...), nil
+}
+
+func (fs *inMemoryFilesystem) WriteFile(filename string, data []byte, perm os.FileMode) error {
+	fs.mux.Lock()
+	defer fs.mux.Unlock()
+
+	p := filepath.Clean(filename)
+	if f, ok := fs.files[p]; ok && f.mode.IsDir() {
+		return &os.PathError{Op: "open", Path: filename, Err: syscall.EISDIR}
+	}
+	// The parent directory must exist, just like for the real filesystem
+	if err := fs.checkDir("open", filepath.Dir(p), filename); err != nil {
+		return err
+	}
+
+	mode := perm.Perm()
+	if f, ok := fs.files[p]; ok {
+		// Existing files keep their permissions
+		mode = f.mode
+	}
+	fs.files[p] = &memFile{
+		data:    append([]byte{}, data...),
+		mode:    mode,
+		modTime: time.Now(),
+	}
+	return nil
+}
+
+func (fs *inMemoryFilesystem) MkdirAll(path string, perm os.FileMode) error {
+	fs.mux.Lock()
+	defer fs.mux.Unlock()
+
+	p := filepath.Clean(path)
+	// Collect the directories to create, from the innermost to the outermost
+	var dirs []string
+	for ; !isRoot(p); p = filepath.Dir(p) {
+		if f, ok := fs.files[p]; ok {
+			if !f.mode.IsDir() {
+				return &os.PathError{Op: "mkdir", Path: path, Err: syscall.ENOTDIR}
+			}
+			break
+		}
+		dirs = append(dirs, p)
+	}
+
+	for _, dir := range dirs {
+		fs.files[dir] = &memFile{
+			mode:    os.ModeDir | perm.Perm(),
+			modTime: time.Now(),
+		}
+	}
+	return nil
+}
+
+func (fs *inMemoryFilesystem) Remove(name string) error {
+	fs.mux.Lock()
+	defer fs.mux.Unlock()
+
+	p := filepath.Clean(name)
+	f, err := fs.get("remove", name)
+	if err != nil {
+		return err
+	}
+	if f.mode.IsDir() && len(fs.children(p)) != 0 {
+		return &os.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+	}
+
+	delete(fs.files, p)
+	return nil
+}
+
+func (fs *inMemoryFilesystem) RemoveAll(path string) error {
+	fs.mux.Lock()
+	defer fs.mux.Unlock()
+
+	p := filepath.Clean(path)
+	prefix := dirPrefix(p)
+	for name := range fs.files {
+		if name == p || strings.HasPrefix(name, prefix) {
+			delete(fs.files, name)
+		}
+	}
+	return nil
+}
+
+func (fs *inMemoryFilesystem) Stat(name string) (os.FileInfo, error) {
+	fs.mux.RLock()
+	defer fs.mux.RUnlock()
+
+	f, err := fs.get("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return &memFileInfo{name: filepath.Base(filepath.Clean(name)), file: f}, nil
+}
+
+func (fs *inMemoryFilesystem) ReadDir(dirname string) ([]os.FileInfo, error) {
+	fs.mux.RLock()
+	defer fs.mux.RUnlock()
+
+	p := filepath.Clean(dirname)
+	if err := fs.checkDir("open", p, dirname); err != nil {
+		return nil, err
+	}
+
+	children := fs.children(p)
+	infos := make([]os.FileInfo, 0, len(children))
+	for _, child := range children {
+		infos = append(infos, &memFileInfo{name: filepath.Base(child), file: fs.files[child]})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+	return infos, nil
+}
+
+// Walk walks the file tree in the same way as filepath.Walk. The filesystem is not
+// locked while walkFn is called, hence walkFn may modify the filesystem.
+func (fs *inMemoryFilesystem) Walk(root string, walkFn filepath.WalkFunc) error {
+	return walk(fs, root, walkFn)
+}
+
+// get returns the file at the given path. The root directory always exists.
+// The caller must hold the lock.
+func (fs *inMemoryFilesystem) get(op, name string) (*memFile, error) {
+	p := filepath.Clean(name)
+	if isRoot(p) {
+		return &memFile{mode: os.ModeDir | 0755}, nil
+	}
+
+	f, ok := fs.files[p]
+	if !ok {
+		return nil, &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	}
+	return f, nil
+}
+
+// checkDir returns an error if the directory at p doesn't exist, or isn't a directory.
+// The caller must hold the lock.
+func (fs *inMemoryFilesystem) checkDir(op, p, name string) error {
+	f, err := fs.get(op, p)
+	if err != nil {
+		return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	}
+	if !f.mode.IsDir() {
+		return &os.PathError{Op: op, Path: name, Err: syscall.ENOTDIR}
+	}
+	return nil
+}
+
+// children returns the paths of the direct children of the directory at p.
+// The caller must hold the lock.
+func (fs *inMemoryFilesystem) children(p string) []string {
+	prefix := dirPrefix(p)
+	var children []string
+	for name := range fs.files {
+		if strings.HasPrefix(name, prefix) && !strings.ContainsRune(name[len(prefix):], filepath.Separator) {
+			children = append(children, name)
+		}
+	}
+	return children
+}
+
+// isRoot returns true for the cleaned root paths, i.e. "/" and "."
+func isRoot(p string) bool {
+	return p == "." || filepath.Dir(p) == p
+}
+
+// dirPrefix returns the prefix that all children of the directory p have
+func dirPrefix(p string) string {
+	if p == "." {
+		return ""
+	}
+	if strings.HasSuffix(p, string(filepath.Separator)) {
+		return p
+	}
+	return p + string(filepath.Separator)
+}
+
+// memFileInfo implements os.FileInfo for memFile
+type memFileInfo struct {
+	name string
+	file *memFile
+}
+
+func (fi *memFileInfo) Name() string       { return fi.name }
+func (fi *memFileInfo) Size() int64        { return int64(len(fi.file.data)) }
+func (fi *memFileInfo) Mode() os.FileMode  { return fi.file.mode }
+func (fi *memFileInfo) ModTime() time.Time { return fi.file.modTime }
+func (fi *memFileInfo) IsDir() bool        { return fi.file.mode.IsDir() }
+func (fi *memFileInfo) Sys() interface{}   { return nil }
@@ -0,0 +1,92 @@
+package filesystem
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// ErrFileTooLarge is returned for files larger than the maximum size, see ReadFileLimited and WithMaxFileSize
+var ErrFileTooLarge = errors.New("file is too large")
+
+// DefaultMaxFileSize is the default maximum size of the files read by the storages, and of the
+// files read from archives, see ReadFileLimited and WithMaxFileSize
+const DefaultMaxFileSize = 16 << 20
+
+// limitedFileReader is implemented by the Filesystems which can stop reading a file after
+// the given amount of bytes, instead of reading all of it into memory first
+type limitedFileReader interface {
+	readFileLimited(filename string, maxSize int64) ([]byte, error)
+}
+
+// ReadFileLimited reads the file named by filename from fs like fs.ReadFile, but fails with
+// ErrFileTooLarge if it's larger than maxSize bytes. The Filesystems returned by NewOSFilesystem
+// and NewBillyFilesystem don't read more than maxSize bytes of such files, which protects against
+// running out of memory. Others are checked using Stat before reading the file. A maxSize less
+// than one disables the limit.
+func ReadFileLimited(fs Filesystem, filename string, maxSize int64) ([]byte, error) {
+	if maxSize < 1 {
+		return fs.ReadFile(filename)
+	}
+	if lr, ok := fs.(limitedFileReader); ok {
+		return lr.readFileLimited(filename, maxSize)
+	}
+
+	info, err := fs.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > maxSize {
+		return nil, fileTooLarge(filename, maxSize)
+	}
+	// The file may have grown in the meantime
+	content, err := fs.ReadFile(filename)
+	if err == nil && int64(len(content)) > maxSize {
+		return nil, fileTooLarge(filename, maxSize)
+	}
+	return content, err
+}
+
+// readLimited reads r until EOF, or returns ErrFileTooLarge for the file with the given
+// name after reading more than maxSize bytes
+func readLimited(filename string, r io.Reader, maxSize int64) ([]byte, error) {
+	// Read one byte more than allowed, to tell whether the limit is exceeded
+	content, err := ioutil.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(content)) > maxSize {
+		return nil, fileTooLarge(filename, maxSize)
+	}
+	return content, nil
+}
+
+func fileTooLarge(filename string, maxSize int64) error {
+	return fmt.Errorf("%w: %q exceeds the maximum size of %d bytes", ErrFileTooLarge, filename, maxSize)
+}
+
+var _ limitedFileReader = osFilesystem{}
+
+func (osFilesystem) readFileLimited(filename string, maxSize int64) ([]byte, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return readLimited(filename, f, maxSize)
+}
+
+var _ limitedFileReader = &billyFilesystem{}
+
+func (b *billyFilesystem) readFileLimited(filename string, maxSize int64) ([]byte, error) {
+	f, err := b.fs.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return readLimited(filename, f, maxSize)
+}
@@ -0,0 +1,57 @@
+package filesystem
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+)
+
+func TestReadFileLimited(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "filesystem-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	inMemory := NewInMemory()
+	implementations := []struct {
+		name string
+		fs   Filesystem
+		root string
+	}{
+		{"os", NewOSFilesystem(), tmpDir},
+		{"in-memory", inMemory, "/in-memory"},
+		{"billy", NewBillyFilesystem(memfs.New()), "billy"},
+		// Filesystems that can't limit reading use Stat
+		{"read-only", NewReadOnly(inMemory), "/in-memory"},
+	}
+	for _, impl := range implementations {
+		t.Run(impl.name, func(t *testing.T) {
+			filename := filepath.Join(impl.root, "foo.yaml")
+			if impl.name != "read-only" {
+				if err := impl.fs.MkdirAll(impl.root, 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := impl.fs.WriteFile(filename, []byte("1234"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for _, maxSize := range []int64{4, 5, 0, -1} {
+				if content, err := ReadFileLimited(impl.fs, filename, maxSize); err != nil || string(content) != "1234" {
+					t.Errorf("expected content %q for maximum size %d, got %q (%v)", "1234", maxSize, content, err)
+				}
+			}
+			if _, err := ReadFileLimited(impl.fs, filename, 3); !errors.Is(err, ErrFileTooLarge) {
+				t.Errorf("expected ErrFileTooLarge, got %v", err)
+			}
+			if _, err := ReadFileLimited(impl.fs, filepath.Join(impl.root, "bar.yaml"), 3); !os.IsNotExist(err) {
+				t.Errorf("expected not exist error, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package filesystem
+
+import (
+	"os"
+	"sync"
+
+	"github.com/go-logr/logr"
+)
+
+// warnUnsupportedLock makes sure the warning about unsupported locking is only logged once
+var warnUnsupportedLock sync.Once
+
+// lockDir takes an exclusive advisory lock of the given directory, which serializes the writes of
+// the files in it with the ones of other processes (and other Filesystems in this process). The
+// directory is locked instead of the files, so that files can be replaced by renaming other files
+// over them, and so that no lock files are left next to them. If the filesystem doesn't support
+// locking, a warning is logged to logger and the returned unlock func is a no-op. If the directory
+// doesn't exist, there's nothing to lock, and the write fails on its own.
+func lockDir(dir string, logger logr.Logger) (unlock func(), err error) {
+	f, err := openLockFile(dir)
+	if os.IsNotExist(err) {
+		return func() {}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := lockFile(f); err != nil {
+		f.Close()
+		if !isLockUnsupported(err) {
+			return nil, &os.PathError{Op: "lock", Path: dir, Err: err}
+		}
+
+		warnUnsupportedLock.Do(func() {
+			logger.Error(err, "Locking isn't supported, writing without locks", "dir", dir)
+		})
+		return func() {}, nil
+	}
+
+	return func() {
+		// Closing the file releases the lock as well, but do it explicitly for clarity
+		_ = unlockFile(f)
+		f.Close()
+	}, nil
+}
@@ -0,0 +1,34 @@
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package filesystem
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// openLockFile opens the directory to lock
+func openLockFile(dir string) (*os.File, error) {
+	return os.Open(dir)
+}
+
+// lockFile takes an exclusive flock of f, waiting until it's released by its current holder
+func lockFile(f *os.File) error {
+	for {
+		err := unix.Flock(int(f.Fd()), unix.LOCK_EX)
+		if err != unix.EINTR {
+			return err
+		}
+	}
+}
+
+// unlockFile releases the flock of f
+func unlockFile(f *os.File) error {
+	return unix.Flock(int(f.Fd()), unix.LOCK_UN)
+}
+
+// isLockUnsupported returns true if err tells that the filesystem doesn't support flock, e.g. for NFS
+func isLockUnsupported(err error) bool {
+	return err == unix.ENOLCK || err == unix.EOPNOTSUPP || err == unix.ENOTSUP || err == unix.ENOSYS
+}
@@ -0,0 +1,28 @@
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!windows
+
+package filesystem
+
+import (
+	"errors"
+	"os"
+)
+
+// errLockUnsupported is returned by lockFile on platforms without file locking
+var errLockUnsupported = errors.New("file locking is not supported on this platform")
+
+// openLockFile opens the directory to lock
+func openLockFile(dir string) (*os.File, error) {
+	return os.Open(dir)
+}
+
+func lockFile(*os.File) error {
+	return errLockUnsupported
+}
+
+func unlockFile(*os.File) error {
+	return nil
+}
+
+func isLockUnsupported(err error) bool {
+	return err == errLockUnsupported
+}
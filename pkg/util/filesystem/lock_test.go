@@ -0,0 +1,83 @@
+package filesystem
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/libgitops/pkg/logs"
+)
+
+func TestLockDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filesystem-lock-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "foo.yaml")
+
+	// A write waits for the lock to be released
+	unlock, err := lockDir(dir, logs.NewLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() {
+		done <- NewOSFilesystem().WriteFile(file, []byte("foo"), 0644)
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("expected the write to wait for the lock, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// There's nothing to lock for a missing directory, the write fails on its own
+	if err := NewOSFilesystem().WriteFile(filepath.Join(dir, "missing", "foo.yaml"), []byte("foo"), 0644); !os.IsNotExist(err) {
+		t.Errorf("expected a not exist error, got %v", err)
+	}
+}
+
+// TestConcurrentWrites races the writes of two Filesystems, like two processes writing the same
+// file. The shorter content would leave the tail of the longer one behind if the writes interleaved.
+func TestConcurrentWrites(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filesystem-lock-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "foo.yaml")
+
+	contents := [][]byte{
+		bytes.Repeat([]byte("a"), 1<<16),
+		bytes.Repeat([]byte("b"), 1<<10),
+	}
+	for i := 0; i < 100; i++ {
+		var wg sync.WaitGroup
+		for _, content := range contents {
+			wg.Add(1)
+			go func(content []byte) {
+				defer wg.Done()
+				if err := NewOSFilesystem().WriteFile(file, content, 0644); err != nil {
+					t.Error(err)
+				}
+			}(content)
+		}
+		wg.Wait()
+
+		written, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(written, contents[0]) && !bytes.Equal(written, contents[1]) {
+			t.Fatalf("the writes were interleaved in round %d, got %d bytes", i, len(written))
+		}
+	}
+}
@@ -0,0 +1,42 @@
+// +build windows
+
+package filesystem
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// openLockFile opens the directory to lock. Directories can only be opened
+// with FILE_FLAG_BACKUP_SEMANTICS, which os.Open doesn't use for locking.
+func openLockFile(dir string) (*os.File, error) {
+	p, err := windows.UTF16PtrFromString(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := windows.CreateFile(p, windows.GENERIC_READ,
+		windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE|windows.FILE_SHARE_DELETE,
+		nil, windows.OPEN_EXISTING, windows.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: dir, Err: err}
+	}
+	return os.NewFile(uintptr(h), dir), nil
+}
+
+// lockFile takes an exclusive lock of the first byte of f using LockFileEx,
+// waiting until it's released by its current holder
+func lockFile(f *os.File) error {
+	return windows.LockFileEx(windows.Handle(f.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK, 0, 1, 0, &windows.Overlapped{})
+}
+
+// unlockFile releases the lock of f
+func unlockFile(f *os.File) error {
+	return windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, &windows.Overlapped{})
+}
+
+// isLockUnsupported returns true if err tells that the filesystem doesn't support LockFileEx
+func isLockUnsupported(err error) bool {
+	return err == windows.ERROR_NOT_SUPPORTED || err == windows.ERROR_INVALID_FUNCTION || err == windows.ERROR_INVALID_PARAMETER
+}
@@ -2,18 +2,32 @@ package patch
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 
+	jsonpatch "github.com/evanphx/json-patch"
 	"github.com/weaveworks/libgitops/pkg/runtime"
 	"github.com/weaveworks/libgitops/pkg/serializer"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/jsonmergepatch"
 	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"sigs.k8s.io/yaml"
 )
 
+// LastAppliedAnnotation is the annotation used by ApplyThreeWay to store the last-applied configuration of an object
+const LastAppliedAnnotation = "patch.libgitops.weave.works/last-applied-configuration"
+
+// ErrPatchPathNotFound is returned when a JSON patch operation targets a path that doesn't exist in the object
+var ErrPatchPathNotFound = errors.New("patch operation targets a nonexistent path")
+
 type Patcher interface {
 	Create(new runtime.Object, applyFn func(runtime.Object) error) ([]byte, error)
 	Apply(original, patch []byte, gvk schema.GroupVersionKind) ([]byte, error)
+	ApplyJSONPatch(original, patch []byte, gvk schema.GroupVersionKind) ([]byte, error)
+	ApplyThreeWay(original, modified, current []byte, gvk schema.GroupVersionKind) ([]byte, error)
 	ApplyOnFile(filePath string, patch []byte, gvk schema.GroupVersionKind) error
 }
 
@@ -70,6 +84,89 @@ func (p *patcher) Apply(original, patch []byte, gvk schema.GroupVersionKind) ([]
 	return p.serializerEncode(b)
 }
 
+// ApplyJSONPatch applies a JSON patch (RFC 6902) to the original object, which may be encoded
+// as either JSON or YAML. The result is validated by decoding it with the serializer. If an
+// operation targets a path that doesn't exist, ErrPatchPathNotFound is returned.
+func (p *patcher) ApplyJSONPatch(original, patch []byte, gvk schema.GroupVersionKind) ([]byte, error) {
+	// Make sure the Object is registered in the scheme
+	if _, err := p.serializer.Scheme().New(gvk); err != nil {
+		return nil, err
+	}
+
+	ops, err := jsonpatch.DecodePatch(patch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode JSON patch: %w", err)
+	}
+
+	// The JSON patch can only be applied to JSON, convert the original if it's YAML
+	originalJSON, err := yaml.YAMLToJSON(original)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := ops.Apply(originalJSON)
+	if err != nil {
+		if errors.Is(err, jsonpatch.ErrMissing) || errors.Is(err, jsonpatch.ErrInvalidIndex) {
+			return nil, fmt.Errorf("%w: %v", ErrPatchPathNotFound, err)
+		}
+		return nil, err
+	}
+
+	return p.serializerEncode(b)
+}
+
+// ApplyThreeWay computes and applies a three-way JSON merge patch. original is the last-applied
+// configuration, modified the new desired state and current the object as it is stored right now.
+// Fields set in current by other actors (e.g. status set by a controller) are left untouched, while
+// fields removed between original and modified are removed. If original is empty, it is read from the
+// LastAppliedAnnotation of current. The result records modified in the LastAppliedAnnotation, so that
+// repeated applies of the same manifest converge.
+func (p *patcher) ApplyThreeWay(original, modified, current []byte, gvk schema.GroupVersionKind) ([]byte, error) {
+	// Make sure the Object is registered in the scheme
+	if _, err := p.serializer.Scheme().New(gvk); err != nil {
+		return nil, err
+	}
+
+	// The patch can only be computed for JSON, convert the inputs in case they're YAML
+	modifiedJSON, err := yaml.YAMLToJSON(modified)
+	if err != nil {
+		return nil, err
+	}
+	currentJSON, err := yaml.YAMLToJSON(current)
+	if err != nil {
+		return nil, err
+	}
+	var originalJSON []byte
+	if len(original) != 0 {
+		originalJSON, err = yaml.YAMLToJSON(original)
+	} else {
+		originalJSON, err = getLastApplied(currentJSON)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Record the modified configuration as the last-applied one for the next apply
+	modifiedJSON, err = setLastApplied(modifiedJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	// A JSON merge patch is used like kubectl does for custom resources, as it doesn't depend on
+	// the patch strategies of the Go structs, which don't resolve embedded metadata correctly
+	patch, err := jsonmergepatch.CreateThreeWayJSONMergePatch(originalJSON, modifiedJSON, currentJSON)
+	if err != nil {
+		return nil, fmt.Errorf("CreateThreeWayJSONMergePatch failed: %v", err)
+	}
+
+	b, err := jsonpatch.MergePatch(currentJSON, patch)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.serializerEncode(b)
+}
+
 func (p *patcher) ApplyOnFile(filePath string, patch []byte, gvk schema.GroupVersionKind) error {
 	oldContent, err := ioutil.ReadFile(filePath)
 	if err != nil {
@@ -101,3 +198,54 @@ func (p *patcher) serializerEncode(input []byte) ([]byte, error) {
 
 	return result.Bytes(), err
 }
+
+// getLastApplied returns the last-applied configuration stored in the annotation of the given JSON object,
+// or nil if the object hasn't been applied before
+func getLastApplied(obj []byte) ([]byte, error) {
+	var partial struct {
+		Metadata metav1.ObjectMeta `json:"metadata"`
+	}
+	if err := json.Unmarshal(obj, &partial); err != nil {
+		return nil, err
+	}
+
+	if lastApplied, ok := partial.Metadata.Annotations[LastAppliedAnnotation]; ok {
+		return []byte(lastApplied), nil
+	}
+	return nil, nil
+}
+
+// setLastApplied stores the given JSON object in its own last-applied annotation. Any previously
+// stored last-applied configuration is excluded from the stored data.
+func setLastApplied(obj []byte) ([]byte, error) {
+	var objMap map[string]interface{}
+	if err := json.Unmarshal(obj, &objMap); err != nil {
+		return nil, err
+	}
+
+	metadata, _ := objMap["metadata"].(map[string]interface{})
+	if metadata == nil {
+		metadata = map[string]interface{}{}
+		objMap["metadata"] = metadata
+	}
+	annotations, _ := metadata["annotations"].(map[string]interface{})
+	if annotations != nil {
+		delete(annotations, LastAppliedAnnotation)
+		if len(annotations) == 0 {
+			delete(metadata, "annotations")
+		}
+	}
+
+	lastApplied, err := json.Marshal(objMap)
+	if err != nil {
+		return nil, err
+	}
+
+	if annotations == nil {
+		annotations = map[string]interface{}{}
+	}
+	annotations[LastAppliedAnnotation] = string(lastApplied)
+	metadata["annotations"] = annotations
+
+	return json.Marshal(objMap)
+}
@@ -2,6 +2,7 @@ package patch
 
 import (
 	"bytes"
+	"errors"
 	"testing"
 
 	api "github.com/weaveworks/libgitops/cmd/sample-app/apis/sample"
@@ -58,3 +59,134 @@ func TestApplyPatch(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestApplyJSONPatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		patch   string
+		wantErr error
+		check   func(t *testing.T, car *api.Car)
+	}{
+		{
+			name:  "add",
+			patch: `[{"op": "add", "path": "/spec/yearModel", "value": "2020"}]`,
+			check: func(t *testing.T, car *api.Car) {
+				if car.Spec.YearModel != "2020" {
+					t.Errorf("expected yearModel 2020, got %q", car.Spec.YearModel)
+				}
+			},
+		},
+		{
+			name:  "remove",
+			patch: `[{"op": "remove", "path": "/spec/brand"}]`,
+			check: func(t *testing.T, car *api.Car) {
+				if car.Spec.Brand != "" {
+					t.Errorf("expected empty brand, got %q", car.Spec.Brand)
+				}
+			},
+		},
+		{
+			name:  "replace",
+			patch: `[{"op": "replace", "path": "/spec/engine", "value": "v8"}]`,
+			check: func(t *testing.T, car *api.Car) {
+				if car.Spec.Engine != "v8" || car.Spec.Brand != "bar" {
+					t.Errorf("unexpected spec after replace: %+v", car.Spec)
+				}
+			},
+		},
+		{
+			name:    "remove nonexistent path",
+			patch:   `[{"op": "remove", "path": "/spec/yearModel"}]`,
+			wantErr: ErrPatchPathNotFound,
+		},
+		{
+			name:    "replace nonexistent path",
+			patch:   `[{"op": "replace", "path": "/status/speed", "value": 10}]`,
+			wantErr: ErrPatchPathNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := p.ApplyJSONPatch(basebytes, []byte(tt.patch), carGVK)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			car := &api.Car{}
+			frameReader := serializer.NewJSONFrameReader(serializer.FromBytes(result))
+			if err := scheme.Serializer.Decoder().DecodeInto(frameReader, car); err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, car)
+		})
+	}
+}
+
+func TestApplyThreeWay(t *testing.T) {
+	// The status has been set by a controller, which isn't part of the applied manifest
+	current := []byte(`
+{
+	"kind": "Car",
+	"apiVersion": "sample-app.weave.works/v1alpha1",
+	"metadata": {
+	  "name": "foo",
+	  "uid": "0123456789101112"
+	},
+	"spec": {
+	  "engine": "foo",
+	  "brand": "bar"
+	},
+	"status": {
+	  "speed": 24.7
+	}
+}`)
+	// The new manifest changes the engine, and drops the brand
+	modified := []byte(`
+apiVersion: sample-app.weave.works/v1alpha1
+kind: Car
+metadata:
+  name: foo
+  uid: "0123456789101112"
+spec:
+  engine: v8
+`)
+
+	applyAndDecode := func(original, current []byte) ([]byte, *api.Car) {
+		result, err := p.ApplyThreeWay(original, modified, current, carGVK)
+		if err != nil {
+			t.Fatal(err)
+		}
+		car := &api.Car{}
+		frameReader := serializer.NewJSONFrameReader(serializer.FromBytes(result))
+		if err := scheme.Serializer.Decoder().DecodeInto(frameReader, car); err != nil {
+			t.Fatal(err)
+		}
+		return result, car
+	}
+
+	result, car := applyAndDecode(basebytes, current)
+	if car.Spec.Engine != "v8" {
+		t.Errorf("expected engine v8, got %q", car.Spec.Engine)
+	}
+	if car.Spec.Brand != "" {
+		t.Errorf("expected brand to be removed, got %q", car.Spec.Brand)
+	}
+	if car.Status.Speed != 24.7 {
+		t.Errorf("expected externally set speed to be preserved, got %v", car.Status.Speed)
+	}
+	if _, ok := car.Annotations[LastAppliedAnnotation]; !ok {
+		t.Errorf("expected the %s annotation to be set", LastAppliedAnnotation)
+	}
+
+	// Applying the same manifest again, using the stored last-applied configuration, should converge
+	result2, _ := applyAndDecode(nil, result)
+	if !bytes.Equal(result, result2) {
+		t.Errorf("repeated apply didn't converge:\n%s\n%s", result, result2)
+	}
+}
@@ -5,13 +5,20 @@ import (
 	"time"
 
 	log "github.com/sirupsen/logrus"
+	"github.com/weaveworks/libgitops/pkg/util/clock"
 )
 
 // NewBatchWriter creates a new BatchWriter
 func NewBatchWriter(duration time.Duration) *BatchWriter {
+	return NewBatchWriterWithClock(duration, clock.RealClock)
+}
+
+// NewBatchWriterWithClock is the same as NewBatchWriter, but measures the duration using the given Clock
+func NewBatchWriterWithClock(duration time.Duration, c clock.Clock) *BatchWriter {
 	return &BatchWriter{
 		duration: duration,
-		flushCh:  make(chan struct{}),
+		clock:    c,
+		flushCh:  make(chan struct{}, 1),
 		syncMap:  &sync.Map{},
 	}
 }
@@ -24,9 +31,13 @@ func NewBatchWriter(duration time.Duration) *BatchWriter {
 // which can process the result after all the writes are done.
 type BatchWriter struct {
 	duration time.Duration
-	timer    *time.Timer
+	clock    clock.Clock
+	timer    clock.Timer
 	flushCh  chan struct{}
 	syncMap  *sync.Map
+	// mux guards timer and closed
+	mux    sync.Mutex
+	closed bool
 }
 
 // Load reads the key from the map
@@ -38,6 +49,9 @@ func (b *BatchWriter) Load(key interface{}) (value interface{}, ok bool) {
 // If no other .Store call is made during the specified duration,
 // flushCh is invoked and ProcessBatch unblocks in the other goroutine
 func (b *BatchWriter) Store(key, value interface{}) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
 	// prevent the timer from firing as we're manipulating it now
 	b.cancelUnfiredTimer()
 	// store the key and the value as requested
@@ -47,25 +61,39 @@ func (b *BatchWriter) Store(key, value interface{}) {
 	b.dispatchAfterTimeout()
 }
 
-// Close closes the underlying channel
+// Close closes the underlying channel. Any writes still waiting for the
+// timeout are flushed immediately by the last call to ProcessBatch.
 func (b *BatchWriter) Close() {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if b.closed {
+		return
+	}
+
 	log.Trace("BatchWriter: Closing the batch channel")
+	b.cancelUnfiredTimer()
+	b.closed = true
 	close(b.flushCh)
 }
 
 // ProcessBatch is effectively a Range over the sync.Map, once a batch write is
 // released. This should be used in the receiving goroutine. The internal map is
 // reset after this call, so be sure to capture all the contents if needed. This
-// function returns false if Close() has been called.
+// function returns false if Close() has been called, after processing any writes
+// that were still waiting for the timeout.
 func (b *BatchWriter) ProcessBatch(fn func(key, val interface{}) bool) bool {
-	if _, ok := <-b.flushCh; !ok {
-		// channel is closed
-		return false
+	_, ok := <-b.flushCh
+	if ok {
+		log.Trace("BatchWriter: Received a flush for the batch. Dispatching it now.")
+	} else {
+		// channel is closed, flush the remaining writes
+		log.Trace("BatchWriter: Batch channel closed. Dispatching the remaining writes.")
 	}
-	log.Trace("BatchWriter: Received a flush for the batch. Dispatching it now.")
+
 	b.syncMap.Range(fn)
 	*b.syncMap = sync.Map{}
-	return true
+	return ok
 }
 
 func (b *BatchWriter) cancelUnfiredTimer() {
@@ -78,8 +106,18 @@ func (b *BatchWriter) cancelUnfiredTimer() {
 }
 
 func (b *BatchWriter) dispatchAfterTimeout() {
-	b.timer = time.AfterFunc(b.duration, func() {
+	b.timer = b.clock.AfterFunc(b.duration, func() {
+		b.mux.Lock()
+		defer b.mux.Unlock()
+
+		if b.closed {
+			return // Close flushes the writes instead
+		}
+
 		log.Tracef("BatchWriter: Dispatching a batch job")
-		b.flushCh <- struct{}{}
+		select {
+		case b.flushCh <- struct{}{}:
+		default: // A flush is already pending, which will include these writes
+		}
 	})
 }
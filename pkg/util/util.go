@@ -43,6 +43,14 @@ func BoolPtr(b bool) *bool {
 	return &b
 }
 
+func IntPtr(i int) *int {
+	return &i
+}
+
+func Int64Ptr(i int64) *int64 {
+	return &i
+}
+
 // RandomSHA returns a hex-encoded string from {byteLen} random bytes.
 func RandomSHA(byteLen int) (string, error) {
 	b := make([]byte, byteLen)
@@ -1,39 +1,227 @@
 package watcher
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+
+	"github.com/weaveworks/libgitops/pkg/logs"
+)
+
+// ErrSymlinkNotAllowed is returned when a symlink is encountered while walking
+// a directory using SymlinkError.
+var ErrSymlinkNotAllowed = errors.New("symlinks are not allowed")
+
+// SymlinkMode specifies how symlinks are handled when walking a directory
+type SymlinkMode byte
+
+const (
+	// SymlinkIgnore skips all symlinks
+	SymlinkIgnore SymlinkMode = iota
+	// SymlinkFollowOnce resolves symlinks, but includes every file and directory only
+	// once, preferring their real paths. This prevents cycles. Dangling symlinks, and
+	// symlinks pointing outside the walked directory, are skipped, as changes to files
+	// outside of it aren't watched.
+	SymlinkFollowOnce
+	// SymlinkError aborts the walk with ErrSymlinkNotAllowed if a symlink is encountered
+	SymlinkError
+	// SymlinkNoFollow doesn't resolve symlinks, like filepath.Walk. Symlinks with a valid
+	// extension are included like regular files, but symlinked directories aren't walked.
+	SymlinkNoFollow
 )
 
-func (w *FileWatcher) getFiles() ([]string, error) {
-	return WalkDirectoryForFiles(w.dir, w.opts.ValidExtensions, w.opts.ExcludeDirs)
+func (m SymlinkMode) String() string {
+	switch m {
+	case SymlinkIgnore:
+		return "Ignore"
+	case SymlinkFollowOnce:
+		return "FollowOnce"
+	case SymlinkError:
+		return "Error"
+	case SymlinkNoFollow:
+		return "NoFollow"
+	}
+
+	return "Unknown"
 }
 
 func (w *FileWatcher) validFile(path string) bool {
-	return isValidFile(path, w.opts.ValidExtensions, w.opts.ExcludeDirs)
+	return isValidFile(path, w.opts.ValidExtensions, w.opts.ExcludeDirs) && !isExcluded(path, false, w.opts.PathExcluders)
 }
 
 // WalkDirectoryForFiles discovers all subdirectories and
 // returns a list of valid files in them
 func WalkDirectoryForFiles(dir string, validExts, excludeDirs []string) (files []string, err error) {
-	err = filepath.Walk(dir,
-		func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
+	opts := DefaultOptions()
+	opts.ValidExtensions = validExts
+	opts.ExcludeDirs = excludeDirs
+	return WalkDirectoryForFilesWithOptions(dir, opts)
+}
+
+// WalkDirectoryForFilesWithOptions discovers all subdirectories and returns a list of valid
+// files in them, based on opts.ValidExtensions, opts.ExcludeDirs and opts.PathExcluders. Symlinks are handled
+// according to opts.SymlinkMode.
+func WalkDirectoryForFilesWithOptions(dir string, opts Options) ([]string, error) {
+	// Resolve the directory itself, so that symlinks can be checked to stay within it
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil, err
+	}
+	root, err = filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &dirWalker{
+		opts:    opts,
+		root:    root,
+		visited: make(map[fileID]bool),
+	}
+
+	// First walk the real directory tree, then follow the symlinks found, so that
+	// files are preferably included by their real paths
+	if err := w.walk(dir, dir); err != nil {
+		return nil, err
+	}
+	for len(w.symlinks) != 0 {
+		link := w.symlinks[0]
+		w.symlinks = w.symlinks[1:]
+
+		if err := w.followSymlink(link.path, link.realPath); err != nil {
+			return nil, err
+		}
+	}
+
+	return w.files, nil
+}
+
+// dirWalker keeps the state of one directory walk
+type dirWalker struct {
+	opts Options
+	// root is the real, absolute path of the walked directory
+	root     string
+	files    []string
+	symlinks []symlink
+	// visited tracks the inodes of the files and directories already walked
+	visited map[fileID]bool
+}
+
+// symlink describes a symlink to be followed
+type symlink struct {
+	// path is the path to the symlink as seen from the walked directory
+	path string
+	// realPath is the path to the symlink with all parent symlinks resolved
+	realPath string
+}
+
+// fileID uniquely identifies a file or directory on the system
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+// walk walks the real directory realDir. The found files are reported relative to dir,
+// which is the path to realDir through any followed symlinks.
+func (w *dirWalker) walk(dir, realDir string) error {
+	return filepath.Walk(realDir, func(realPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(realDir, realPath)
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(dir, rel)
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			switch w.opts.SymlinkMode {
+			case SymlinkFollowOnce:
+				w.symlinks = append(w.symlinks, symlink{path, realPath})
+			case SymlinkError:
+				return fmt.Errorf("%w: %q", ErrSymlinkNotAllowed, path)
 			}
+			// With SymlinkNoFollow, the symlink is handled like a regular file
+			if w.opts.SymlinkMode != SymlinkNoFollow {
+				return nil
+			}
+		}
 
-			if !info.IsDir() {
-				// Only include valid files
-				if isValidFile(path, validExts, excludeDirs) {
-					files = append(files, path)
-				}
+		// Skip files and directories that have already been walked
+		if !w.visit(info) {
+			if info.IsDir() {
+				return filepath.SkipDir
 			}
+			return nil
+		}
 
+		if path != dir && isExcluded(path, info.IsDir(), w.opts.PathExcluders) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
 			return nil
-		})
+		}
+
+		// Only include valid files
+		if !info.IsDir() && isValidFile(path, w.opts.ValidExtensions, w.opts.ExcludeDirs) {
+			w.files = append(w.files, path)
+		}
+
+		return nil
+	})
+}
+
+// followSymlink resolves the given symlink, and walks its target if it hasn't been walked already
+func (w *dirWalker) followSymlink(path, realPath string) error {
+	target, err := filepath.EvalSymlinks(realPath)
+	if err != nil {
+		logs.OrDefault(w.opts.Logger).Error(err, "Skipping dangling symlink", "path", path)
+		return nil
+	}
 
-	return
+	// Never read files outside the walked directory, e.g. when the watched repository is
+	// untrusted, and a crafted symlink points to sensitive files elsewhere on the system
+	if absTarget, err := filepath.Abs(target); err != nil || !isSubDir(w.root, absTarget) {
+		logs.OrDefault(w.opts.Logger).Info("Security warning: Skipping symlink pointing outside the watched directory", "path", path, "target", target)
+		return nil
+	}
+
+	info, err := os.Stat(target)
+	if err != nil {
+		logs.OrDefault(w.opts.Logger).Error(err, "Skipping symlink", "path", path)
+		return nil
+	}
+
+	if isExcluded(path, info.IsDir(), w.opts.PathExcluders) {
+		return nil
+	}
+
+	if info.IsDir() {
+		return w.walk(path, target)
+	}
+
+	if w.visit(info) && isValidFile(path, w.opts.ValidExtensions, w.opts.ExcludeDirs) {
+		w.files = append(w.files, path)
+	}
+	return nil
+}
+
+// visit marks the file or directory described by info as visited. It returns false
+// if it has already been visited.
+func (w *dirWalker) visit(info os.FileInfo) bool {
+	id, ok := getFileID(info)
+	if !ok {
+		// The inode can't be determined, always walk the file
+		return true
+	}
+
+	if w.visited[id] {
+		return false
+	}
+	w.visited[id] = true
+	return true
 }
 
 // isValidFile is used to filter out all unsupported
@@ -0,0 +1,10 @@
+// +build windows plan9
+
+package watcher
+
+import "os"
+
+// getFileID returns false, as os.FileInfo doesn't carry the file index on this platform
+func getFileID(os.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
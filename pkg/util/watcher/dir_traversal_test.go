@@ -0,0 +1,111 @@
+package watcher
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWalkDirectoryForFilesSymlinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "walk-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	shared, err := ioutil.TempDir("", "walk-test-shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(shared)
+
+	// Layout:
+	// dir/a.yaml
+	// dir/b.yaml -> dir/a.yaml
+	// dir/base -> shared
+	// dir/dangling -> dir/missing.yaml
+	// dir/loop -> dir
+	// dir/sub/c.yaml
+	// shared/d.yaml
+	// shared/sub -> dir/sub
+	for _, file := range []string{filepath.Join(dir, "a.yaml"), filepath.Join(dir, "sub", "c.yaml"), filepath.Join(shared, "d.yaml")} {
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte("{}"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		filepath.Join(dir, "b.yaml"):   filepath.Join(dir, "a.yaml"),
+		filepath.Join(dir, "base"):     shared,
+		filepath.Join(dir, "dangling"): filepath.Join(dir, "missing.yaml"),
+		filepath.Join(dir, "loop"):     dir,
+		filepath.Join(shared, "sub"):   filepath.Join(dir, "sub"),
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, link); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		mode     SymlinkMode
+		expected []string
+		err      error
+	}{
+		{
+			name:     "ignore",
+			mode:     SymlinkIgnore,
+			expected: []string{"a.yaml", "sub/c.yaml"},
+		},
+		{
+			// base points outside of dir, so it's not followed
+			name:     "follow once",
+			mode:     SymlinkFollowOnce,
+			expected: []string{"a.yaml", "sub/c.yaml"},
+		},
+		{
+			name:     "no follow",
+			mode:     SymlinkNoFollow,
+			expected: []string{"a.yaml", "b.yaml", "sub/c.yaml"},
+		},
+		{
+			name: "error",
+			mode: SymlinkError,
+			err:  ErrSymlinkNotAllowed,
+		},
+	}
+
+	if mode := DefaultOptions().SymlinkMode; mode != SymlinkNoFollow {
+		t.Errorf("expected symlinks not to be followed by default, got %s", mode)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultOptions()
+			opts.SymlinkMode = tt.mode
+
+			files, err := WalkDirectoryForFilesWithOptions(dir, opts)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+
+			var relFiles []string
+			for _, file := range files {
+				rel, err := filepath.Rel(dir, file)
+				if err != nil {
+					t.Fatal(err)
+				}
+				relFiles = append(relFiles, rel)
+			}
+			if !reflect.DeepEqual(relFiles, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, relFiles)
+			}
+		})
+	}
+}
@@ -0,0 +1,17 @@
+// +build !windows,!plan9
+
+package watcher
+
+import (
+	"os"
+	"syscall"
+)
+
+// getFileID returns the device and inode of the file or directory described by info
+func getFileID(info os.FileInfo) (fileID, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, false
+	}
+	return fileID{uint64(stat.Dev), uint64(stat.Ino)}, true
+}
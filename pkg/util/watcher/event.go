@@ -13,6 +13,10 @@ const (
 	FileEventModify                  // 1
 	FileEventDelete                  // 2
 	FileEventMove                    // 3
+	// FileEventResync signals that events may have been lost, e.g. due to an
+	// inotify queue overflow. The watched directories should be rescanned.
+	// The Path of a FileUpdate carrying this event is empty.
+	FileEventResync // 4
 )
 
 func (e FileEvent) String() string {
@@ -25,6 +29,8 @@ func (e FileEvent) String() string {
 		return "DELETE"
 	case 3:
 		return "MOVE"
+	case 4:
+		return "RESYNC"
 	}
 
 	return "UNKNOWN"
@@ -61,4 +67,6 @@ type FileUpdates []*FileUpdate
 type FileUpdate struct {
 	Event FileEvent
 	Path  string
+	// OldPath is the path the file was moved from, only set for FileEventMove
+	OldPath string
 }
@@ -0,0 +1,135 @@
+package watcher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+	log "github.com/sirupsen/logrus"
+)
+
+// PathExcluder decides whether files and directories are excluded when walking
+// a directory, and when filtering the events of the FileWatcher
+type PathExcluder interface {
+	// ShouldExcludePath returns true if the file or directory at the given path should be excluded
+	ShouldExcludePath(path string, isDir bool) bool
+}
+
+// ReloadablePathExcluder is a PathExcluder configured by a file. The FileWatcher reloads
+// it when that file changes, and resyncs the watched directories afterwards.
+type ReloadablePathExcluder interface {
+	PathExcluder
+	// File returns the absolute path of the file configuring the PathExcluder
+	File() string
+	// Reload reloads the configuration from the file
+	Reload() error
+}
+
+// GitignoreExcluder excludes the paths matching the gitignore-style patterns of an ignore file
+// in the root directory of a repository, e.g. ".gitignore". Like in git, negated patterns
+// ("!foo") include paths excluded by earlier patterns, and patterns ending with a slash
+// ("bar/") only match directories. The patterns of ignore files in subdirectories aren't used.
+type GitignoreExcluder struct {
+	root    string
+	file    string
+	matcher gitignore.Matcher
+	mux     sync.RWMutex
+}
+
+var _ ReloadablePathExcluder = &GitignoreExcluder{}
+
+// NewGitignoreExcluder creates a new GitignoreExcluder for the ignore file with the given name
+// in the given root directory. If the file doesn't exist, no paths are excluded until it's created.
+func NewGitignoreExcluder(root, name string) (*GitignoreExcluder, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &GitignoreExcluder{root: root, file: filepath.Join(root, name)}
+	return e, e.Reload()
+}
+
+// File implements ReloadablePathExcluder
+func (e *GitignoreExcluder) File() string {
+	return e.file
+}
+
+// Reload implements ReloadablePathExcluder
+func (e *GitignoreExcluder) Reload() error {
+	content, err := ioutil.ReadFile(e.file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	var patterns []gitignore.Pattern
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if strings.HasPrefix(line, "#") || len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+		patterns = append(patterns, gitignore.ParsePattern(line, nil))
+	}
+
+	e.mux.Lock()
+	defer e.mux.Unlock()
+
+	e.matcher = gitignore.NewMatcher(patterns)
+	log.Debugf("GitignoreExcluder: Loaded %d patterns from %q", len(patterns), e.file)
+	return nil
+}
+
+// ShouldExcludePath implements PathExcluder. Paths outside of the root directory are never excluded.
+func (e *GitignoreExcluder) ShouldExcludePath(path string, isDir bool) bool {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+
+	rel, err := filepath.Rel(e.root, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false
+	}
+
+	e.mux.RLock()
+	defer e.mux.RUnlock()
+
+	return e.matcher.Match(strings.Split(filepath.ToSlash(rel), "/"), isDir)
+}
+
+// isExcluded returns true if any of the given PathExcluders excludes the path
+func isExcluded(path string, isDir bool, excluders []PathExcluder) bool {
+	for _, excluder := range excluders {
+		if excluder.ShouldExcludePath(path, isDir) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// reloadExcluders reloads the ReloadablePathExcluders configured by the file at the given path,
+// and returns true if there were any. The watched directories are resynced afterwards, as the
+// excluded files have changed.
+func (w *FileWatcher) reloadExcluders(path string) (reloaded bool) {
+	for _, excluder := range w.opts.PathExcluders {
+		r, ok := excluder.(ReloadablePathExcluder)
+		if !ok || r.File() != path {
+			continue
+		}
+
+		if err := r.Reload(); err != nil {
+			w.logger.Error(err, "Failed to reload the excluded paths", "path", path)
+		}
+		reloaded = true
+	}
+
+	if reloaded {
+		w.logger.Info("Reloaded the excluded paths, resyncing", "path", path)
+		w.resync()
+	}
+	return
+}
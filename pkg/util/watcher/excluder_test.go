@@ -0,0 +1,131 @@
+package watcher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+const testIgnoreFile = `# Generated manifests
+gen/
+*.tmp.yaml
+secret-*.yaml
+!secret-public.yaml
+/root-only.yaml
+`
+
+func TestGitignoreExcluder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "excluder-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, ".libgitopsignore"), []byte(testIgnoreFile), 0644); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]bool{
+		"a.yaml":                 false,
+		"gen/b.yaml":             true,
+		"sub/gen/c.yaml":         true,
+		"sub/gen.yaml":           false, // Only directories named gen are excluded
+		"x.tmp.yaml":             true,
+		"secret-a.yaml":          true,
+		"secret-public.yaml":     false,
+		"root-only.yaml":         true,
+		"sub/root-only.yaml":     false,
+		"sub/secret-key.yaml":    true,
+		"sub/secret-public.yaml": false,
+	}
+	var expected []string
+	for file, excluded := range files {
+		path := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte("{}"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if !excluded {
+			expected = append(expected, path)
+		}
+	}
+
+	e, err := NewGitignoreExcluder(dir, ".libgitopsignore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for file, excluded := range files {
+		if e.ShouldExcludePath(filepath.Join(dir, file), false) != excluded {
+			t.Errorf("expected %s to be excluded: %t", file, excluded)
+		}
+	}
+	if !e.ShouldExcludePath(filepath.Join(dir, "gen"), true) || e.ShouldExcludePath(dir, true) {
+		t.Error("expected only the gen directory to be excluded")
+	}
+
+	opts := DefaultOptions()
+	opts.PathExcluders = []PathExcluder{e}
+	walked, err := WalkDirectoryForFilesWithOptions(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(walked)
+	sort.Strings(expected)
+	if !reflect.DeepEqual(walked, expected) {
+		t.Errorf("expected the walk to return %v, got %v", expected, walked)
+	}
+}
+
+func TestGitignoreExcluderReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "excluder-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "gen", "a.yaml")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The ignore file doesn't exist yet
+	e, err := NewGitignoreExcluder(dir, ".gitignore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := DefaultOptions()
+	opts.PathExcluders = []PathExcluder{e}
+	w, files, err := NewFileWatcherWithOptions(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if len(files) != 1 {
+		t.Fatalf("expected 1 file, got %v", files)
+	}
+
+	// Creating the ignore file reloads the patterns, and resyncs the watched directory
+	if err := ioutil.WriteFile(e.File(), []byte("gen/\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case update := <-w.GetFileUpdateStream():
+		if update.Event != FileEventResync {
+			t.Fatalf("expected a resync, got %s", update.Event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the resync")
+	}
+
+	if files, err := w.Files(); err != nil || len(files) != 0 {
+		t.Errorf("expected the gen directory to be excluded after the reload, got %v (%v)", files, err)
+	}
+}
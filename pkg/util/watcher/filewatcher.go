@@ -1,12 +1,19 @@
 package watcher
 
 import (
+	"errors"
 	"fmt"
 	"path"
+	"path/filepath"
+	"strings"
+	gosync "sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/go-logr/logr"
 	"github.com/rjeczalik/notify"
-	log "github.com/sirupsen/logrus"
+	"github.com/weaveworks/libgitops/pkg/logs"
+	"github.com/weaveworks/libgitops/pkg/util/clock"
 	"github.com/weaveworks/libgitops/pkg/util/sync"
 	"golang.org/x/sys/unix"
 )
@@ -46,6 +53,8 @@ func (c *combinedEvent) match(events notifyEvents) (notify.EventInfo, bool) {
 // combinedEvents describes the event combinations to concatenate,
 // this is iterated in order, so the longest matches should be first
 var combinedEvents = []combinedEvent{
+	// MOVE FROM + MODIFY => MODIFY (the file was moved away and replaced, e.g. by vim)
+	{[]notify.Event{notify.InMovedFrom, notify.InCloseWrite}, 1},
 	// DELETE + MODIFY => MODIFY
 	{[]notify.Event{notify.InDelete, notify.InCloseWrite}, 1},
 	// MODIFY + DELETE => NONE
@@ -64,6 +73,16 @@ type Options struct {
 	BatchTimeout time.Duration
 	// ValidExtensions specifies what file extensions to look at
 	ValidExtensions []string
+	// SymlinkMode specifies how symlinks are handled when discovering the files to watch (Default: SymlinkNoFollow)
+	SymlinkMode SymlinkMode
+	// PathExcluders specify files and directories to not watch, in addition to ExcludeDirs
+	PathExcluders []PathExcluder
+	// Logger receives the logs of the FileWatcher, with the path and eventType of the
+	// file concerned as key/value pairs. If nil, logs.Logger is used, see logs.NewLogger.
+	Logger logr.Logger
+	// Clock measures BatchTimeout and the time to wait for the second half of moves.
+	// If nil, clock.RealClock is used.
+	Clock clock.Clock
 }
 
 // DefaultOptions returns the default options
@@ -72,6 +91,8 @@ func DefaultOptions() Options {
 		ExcludeDirs:     []string{".git"},
 		BatchTimeout:    1 * time.Second,
 		ValidExtensions: []string{".yaml", ".yml", ".json"},
+		SymlinkMode:     SymlinkNoFollow,
+		PathExcluders:   nil,
 	}
 }
 
@@ -86,69 +107,160 @@ func NewFileWatcher(dir string) (w *FileWatcher, files []string, err error) {
 // addition to the generated FileWatcher, it can be used to populate
 // MappedRawStorage fileMappings
 func NewFileWatcherWithOptions(dir string, opts Options) (w *FileWatcher, files []string, err error) {
+	return NewMultiDirFileWatcher([]string{dir}, opts)
+}
+
+// ErrOverlappingDirs is returned when a directory to watch is, or is located
+// in, a directory that is already watched by the FileWatcher.
+var ErrOverlappingDirs = errors.New("directories to watch overlap")
+
+// NewMultiDirFileWatcher is the same as NewFileWatcherWithOptions, but recursively
+// watches all the given directories using a single FileWatcher. The directories
+// are converted to absolute paths, and may not overlap. The returned files, as well
+// as the paths of all sent FileUpdates, are absolute, so files with the same relative
+// path under different directories can be distinguished.
+func NewMultiDirFileWatcher(dirs []string, opts Options) (w *FileWatcher, files []string, err error) {
 	w = &FileWatcher{
-		dir:     dir,
 		events:  make(eventStream, eventBuffer),
 		updates: make(FileUpdateStream, eventBuffer),
-		batcher: sync.NewBatchWriter(opts.BatchTimeout),
+		batcher: sync.NewBatchWriterWithClock(opts.BatchTimeout, clock.OrDefault(opts.Clock)),
 		opts:    opts,
+		logger:  logs.OrDefault(opts.Logger).WithName("FileWatcher"),
+		clock:   clock.OrDefault(opts.Clock),
 	}
 
-	log.Tracef("FileWatcher: Starting recursive watch for %q", dir)
-	if err = notify.Watch(path.Join(dir, "..."), w.events, listenEvents...); err != nil {
-		notify.Stop(w.events)
-	} else if files, err = w.getFiles(); err == nil {
-		w.monitor = sync.RunMonitor(w.monitorFunc)
-		w.dispatcher = sync.RunMonitor(w.dispatchFunc)
+	for _, dir := range dirs {
+		var dirFiles []string
+		if dirFiles, err = w.addDir(dir); err != nil {
+			notify.Stop(w.events)
+			return
+		}
+
+		files = append(files, dirFiles...)
 	}
 
+	w.monitor = sync.RunMonitor(w.monitorFunc)
+	w.dispatcher = sync.RunMonitor(w.dispatchFunc)
 	return
 }
 
+// addDir starts a recursive watch for the given directory,
+// and returns the list of valid files currently in it
+func (w *FileWatcher) addDir(dir string) ([]string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, watched := range w.dirs {
+		if isSubDir(watched, dir) || isSubDir(dir, watched) {
+			return nil, fmt.Errorf("%w: %q and %q", ErrOverlappingDirs, watched, dir)
+		}
+	}
+
+	w.logger.V(logs.TraceLevel).Info("Starting recursive watch", "path", dir)
+	if err := notify.Watch(path.Join(dir, "..."), w.events, listenEvents...); err != nil {
+		return nil, err
+	}
+
+	w.dirs = append(w.dirs, dir)
+	return WalkDirectoryForFilesWithOptions(dir, w.opts)
+}
+
+// inWatchedDir returns true if the given path is absolute, and is located in one of the
+// watched directories after cleaning it, i.e. resolving any ".." elements
+func (w *FileWatcher) inWatchedDir(p string) bool {
+	if !filepath.IsAbs(p) {
+		return false
+	}
+
+	for _, dir := range w.dirs {
+		if isSubDir(dir, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSubDir returns true if dir is, or is located in, parent
+func isSubDir(parent, dir string) bool {
+	rel, err := filepath.Rel(parent, dir)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
 // FileWatcher recursively monitors changes in files in the given directory
 // and sends out events based on their state changes. Only files conforming
 // to validSuffix are monitored. The FileWatcher can be suspended for a single
 // event at a time to eliminate updates by WatchStorage causing a loop.
 type FileWatcher struct {
-	dir          string
+	counters     counters
+	dirs         []string
 	events       eventStream
 	updates      FileUpdateStream
 	suspendEvent FileEvent
 	monitor      *sync.Monitor
 	dispatcher   *sync.Monitor
 	opts         Options
+	logger       logr.Logger
+	clock        clock.Clock
 	// the batcher is used for properly sending many concurrent inotify events
 	// as a group, after a specified timeout. This fixes the issue of one single
 	// file operation being registered as many different inotify events
 	batcher *sync.BatchWriter
+	// moveCaches keeps track of active moves by cookie
+	moveCaches map[uint32]*moveCache
+	// moveMux guards moveCaches
+	moveMux gosync.Mutex
+	// moveTimers tracks the moveCache timers that haven't finished yet
+	moveTimers gosync.WaitGroup
+	// suspendMux guards suspendEvent
+	suspendMux gosync.Mutex
 }
 
 func (w *FileWatcher) monitorFunc() {
-	log.Debug("FileWatcher: Monitoring thread started")
-	defer log.Debug("FileWatcher: Monitoring thread stopped")
-	defer close(w.updates) // Close the update stream after the FileWatcher has stopped
+	w.logger.V(logs.DebugLevel).Info("Monitoring thread started")
+	defer w.logger.V(logs.DebugLevel).Info("Monitoring thread stopped")
 
 	for {
+		// If the event channel is full, notify drops the events it can't send
+		full := len(w.events) == cap(w.events)
+
 		event, ok := <-w.events
 		if !ok {
 			return
 		}
 
+		if full || ievent(event).Mask&unix.IN_Q_OVERFLOW != 0 {
+			w.overflow()
+			if !full {
+				continue // The overflow event doesn't describe a file
+			}
+		}
+
 		if ievent(event).Mask&unix.IN_ISDIR != 0 {
 			continue // Skip directories
 		}
 
-		if !w.validFile(event.Path()) {
-			continue // Skip invalid files
+		// Never read files outside the watched directories, e.g. when the watched repository
+		// is untrusted, and a notify bug or crafted symlink leads to an escaping path
+		if !w.inWatchedDir(event.Path()) {
+			w.logger.Info("Security warning: Dropping event for a path outside the watched directories", "path", event.Path())
+			continue
 		}
 
-		updateEvent := convertEvent(event.Event())
-		if w.suspendEvent > 0 && updateEvent == w.suspendEvent {
-			w.suspendEvent = 0
-			log.Debugf("FileWatcher: Skipping suspended event %s for path: %q", updateEvent, event.Path())
-			continue // Skip the suspended event
+		if w.reloadExcluders(event.Path()) {
+			continue // The file configures excluded paths, and isn't watched itself
 		}
 
+		// Move events of other files are needed to pair atomic saves,
+		// e.g. when an editor renames a temporary file over a valid file
+		if !w.validFile(event.Path()) && !isMoveEvent(event.Event()) {
+			continue // Skip invalid files
+		}
+
+		atomic.AddUint64(&w.counters.received, 1)
+		atomic.StoreInt64(&w.counters.lastEvent, w.clock.Now().UnixNano())
+
 		// Get any events registered for the specific file, and append the specified event
 		var eventList notifyEvents
 		if val, ok := w.batcher.Load(event.Path()); ok {
@@ -159,17 +271,41 @@ func (w *FileWatcher) monitorFunc() {
 
 		// Register the event in the map, and dispatch all the events at once after the timeout
 		w.batcher.Store(event.Path(), eventList)
-		log.Debugf("FileWatcher: Registered inotify events %v for path %q", eventList, event.Path())
+		if l := w.logger.V(logs.DebugLevel); l.Enabled() {
+			l.Info("Registered inotify events", "events", eventList, "path", event.Path())
+		}
 	}
 }
 
+// resyncKey is the batcher key used for registering a resync
+type resyncKey struct{}
+
+// overflow registers that events have been lost, and that a FileEventResync
+// should be sent with the next batch. Only one resync is sent per batch.
+func (w *FileWatcher) overflow() {
+	atomic.AddUint64(&w.counters.overflows, 1)
+	w.logger.Info("Event queue overflowed, events have been lost")
+	w.resync()
+}
+
+// resync registers that a FileEventResync should be sent with the next batch
+func (w *FileWatcher) resync() {
+	w.batcher.Store(resyncKey{}, notifyEvents{})
+}
+
 func (w *FileWatcher) dispatchFunc() {
-	log.Debug("FileWatcher: Dispatch thread started")
-	defer log.Debug("FileWatcher: Dispatch thread stopped")
+	w.logger.V(logs.DebugLevel).Info("Dispatch thread started")
+	defer w.logger.V(logs.DebugLevel).Info("Dispatch thread stopped")
 
 	for {
 		// Wait until we have a batch dispatched to us
+		resync := false
 		ok := w.batcher.ProcessBatch(func(key, val interface{}) bool {
+			if _, ok := key.(resyncKey); ok {
+				resync = true
+				return true
+			}
+
 			// Concatenate all known events, and dispatch them to be handled one by one
 			for _, event := range w.concatenateEvents(val.(notifyEvents)) {
 				w.sendUpdate(event)
@@ -178,17 +314,30 @@ func (w *FileWatcher) dispatchFunc() {
 			// Continue traversing the map
 			return true
 		})
+
+		// Resync after the rest of the batch, so it reflects the latest state
+		if resync {
+			w.sendUpdate(&FileUpdate{Event: FileEventResync})
+		}
+
 		if !ok {
 			return // The BatchWriter channel is closed, stop processing
 		}
 
-		log.Debug("FileWatcher: Dispatched events batch and reset the events cache")
+		w.logger.V(logs.DebugLevel).Info("Dispatched events batch and reset the events cache")
 	}
 }
 
 func (w *FileWatcher) sendUpdate(update *FileUpdate) {
-	log.Debugf("FileWatcher: Sending update: %s -> %q", update.Event, update.Path)
+	if w.suspended(update.Event) {
+		w.logger.V(logs.DebugLevel).Info("Skipping suspended update", "eventType", update.Event, "path", update.Path)
+		return
+	}
+	if l := w.logger.V(logs.DebugLevel); l.Enabled() {
+		l.Info("Sending update", "eventType", update.Event, "path", update.Path)
+	}
 	w.updates <- update
+	atomic.AddUint64(&w.counters.sent, 1)
 }
 
 // GetFileUpdateStream gets the channel with FileUpdates
@@ -196,19 +345,73 @@ func (w *FileWatcher) GetFileUpdateStream() FileUpdateStream {
 	return w.updates
 }
 
-// Close closes active underlying resources
+// Dirs returns the absolute paths of the directories watched by the FileWatcher
+func (w *FileWatcher) Dirs() []string {
+	return append([]string{}, w.dirs...)
+}
+
+// Files returns the list of valid files currently in the watched directories
+func (w *FileWatcher) Files() ([]string, error) {
+	var files []string
+	for _, dir := range w.dirs {
+		dirFiles, err := WalkDirectoryForFilesWithOptions(dir, w.opts)
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, dirFiles...)
+	}
+
+	return files, nil
+}
+
+// Close closes active underlying resources. No new filesystem events are
+// accepted after this is called, but all events already received are flushed
+// to the update stream, which is then closed. Close blocks until all goroutines
+// of the FileWatcher have stopped.
 func (w *FileWatcher) Close() {
+	// Stop receiving events, and let the monitor register the remaining ones
 	notify.Stop(w.events)
-	w.batcher.Close()
-	close(w.events) // Close the event stream
+	close(w.events)
 	w.monitor.Wait()
+
+	// Flush the batched events without waiting for the timeout
+	w.batcher.Close()
 	w.dispatcher.Wait()
+
+	// Dispatch the incomplete moves without waiting for them to be cancelled
+	w.flushMoves()
+	w.moveTimers.Wait()
+
+	close(w.updates) // Close the update stream after the FileWatcher has stopped
 }
 
 // Suspend enables a one-time suspend of the given event,
 // the FileWatcher will skip the given event once
 func (w *FileWatcher) Suspend(updateEvent FileEvent) {
+	w.suspendMux.Lock()
 	w.suspendEvent = updateEvent
+	w.suspendMux.Unlock()
+}
+
+// suspended returns true if updates of the given event are suspended, and ends the suspension.
+// This is checked for the updates to send, after the moves have been paired, so that files
+// replaced by renaming a temporary file over them, like filesystem.Filesystem.WriteFile does
+// for the local disk, end the suspension of the modify event as well.
+func (w *FileWatcher) suspended(updateEvent FileEvent) bool {
+	w.suspendMux.Lock()
+	defer w.suspendMux.Unlock()
+
+	if w.suspendEvent == FileEventNone || updateEvent != w.suspendEvent {
+		return false
+	}
+	w.suspendEvent = FileEventNone
+	atomic.AddUint64(&w.counters.suspended, 1)
+	return true
+}
+
+func isMoveEvent(event notify.Event) bool {
+	return event == notify.InMovedFrom || event == notify.InMovedTo
 }
 
 func convertEvent(event notify.Event) FileEvent {
@@ -237,17 +440,24 @@ func convertUpdate(event notify.EventInfo) *FileUpdate {
 type moveCache struct {
 	watcher *FileWatcher
 	event   notify.EventInfo
-	timer   *time.Timer
+	// written is true if the moved file was written right before being moved away
+	written bool
+	timer   clock.Timer
 }
 
-func (w *FileWatcher) newMoveCache(event notify.EventInfo) *moveCache {
+func (w *FileWatcher) newMoveCache(event notify.EventInfo, written bool) *moveCache {
 	m := &moveCache{
 		watcher: w,
 		event:   event,
+		written: written,
 	}
 
 	// moveCaches wait one second to be cancelled before firing
-	m.timer = time.AfterFunc(time.Second, m.incomplete)
+	w.moveTimers.Add(1)
+	m.timer = w.clock.AfterFunc(time.Second, func() {
+		defer w.moveTimers.Done()
+		m.incomplete()
+	})
 	return m
 }
 
@@ -260,6 +470,15 @@ func (m *moveCache) cookie() uint32 {
 // if only one is received, the file is moved in/out of a watched directory, which
 // is treated as a normal creation/deletion by this method.
 func (m *moveCache) incomplete() {
+	// Delete the cache before dispatching, unless it has been cancelled in the meantime
+	m.watcher.moveMux.Lock()
+	if m.watcher.moveCaches[m.cookie()] != m {
+		m.watcher.moveMux.Unlock()
+		return
+	}
+	delete(m.watcher.moveCaches, m.cookie())
+	m.watcher.moveMux.Unlock()
+
 	var event FileEvent
 
 	switch m.event.Event() {
@@ -272,30 +491,65 @@ func (m *moveCache) incomplete() {
 		panic(fmt.Sprintf("moveCache: unrecognized event: %v", m.event.Event()))
 	}
 
-	log.Tracef("moveCache: Timer expired for %d, dispatching...", m.cookie())
-	m.watcher.sendUpdate(&FileUpdate{event, m.event.Path()})
+	if !m.watcher.validFile(m.event.Path()) {
+		m.watcher.logger.V(logs.TraceLevel).Info("Move timer expired, skipping invalid file", "cookie", m.cookie(), "path", m.event.Path())
+		return
+	}
 
-	// Delete the cache after the timer has fired
-	delete(moveCaches, m.cookie())
+	m.watcher.logger.V(logs.TraceLevel).Info("Move timer expired, dispatching", "cookie", m.cookie(), "path", m.event.Path())
+	m.watcher.sendUpdate(&FileUpdate{Event: event, Path: m.event.Path()})
 }
 
+// cancel stops the timer of the moveCache, and deletes it.
+// The caller must hold the moveMux lock of the watcher.
 func (m *moveCache) cancel() {
-	m.timer.Stop()
-	delete(moveCaches, m.cookie())
-	log.Tracef("moveCache: Dispatching cancelled for %d", m.cookie())
+	if m.timer.Stop() {
+		m.watcher.moveTimers.Done()
+	}
+	delete(m.watcher.moveCaches, m.cookie())
+	m.watcher.logger.V(logs.TraceLevel).Info("Move dispatching cancelled", "cookie", m.cookie())
 }
 
-// moveCaches keeps track of active moves by cookie
-var moveCaches = make(map[uint32]*moveCache)
+// flushMoves dispatches all incomplete moves immediately,
+// instead of waiting for their timers to fire
+func (w *FileWatcher) flushMoves() {
+	w.moveMux.Lock()
+	var caches []*moveCache
+	for _, cache := range w.moveCaches {
+		if cache.timer.Stop() {
+			caches = append(caches, cache)
+		}
+	}
+	w.moveMux.Unlock()
+
+	for _, cache := range caches {
+		cache.incomplete()
+		w.moveTimers.Done()
+	}
+}
+
+// move processes InMovedFrom and InMovedTo events in any order, also across
+// batches, and dispatches FileUpdates when a move is detected. written is true
+// if the file was written right before being moved away. The events are paired
+// by their cookie, and translated based on the validity of the paths:
+//   - valid -> valid: an internal move, or a modification of the destination if
+//     the source was just written (atomic save using a temporary file)
+//   - invalid -> valid: a modification of the destination (atomic save, e.g. "sed -i")
+//   - valid -> invalid: a deletion of the source (e.g. a backup made by an editor)
+//   - invalid -> invalid: ignored
+func (w *FileWatcher) move(event notify.EventInfo, written bool) (updates FileUpdates) {
+	w.moveMux.Lock()
+	defer w.moveMux.Unlock()
+
+	if w.moveCaches == nil {
+		w.moveCaches = make(map[uint32]*moveCache)
+	}
 
-// move processes InMovedFrom and InMovedTo events in any order
-// and dispatches FileUpdates when a move is detected
-func (w *FileWatcher) move(event notify.EventInfo) (moveUpdate *FileUpdate) {
 	cookie := ievent(event).Cookie
-	cache, ok := moveCaches[cookie]
+	cache, ok := w.moveCaches[cookie]
 	if !ok {
 		// The cookie is not cached, create a new cache object for it
-		moveCaches[cookie] = w.newMoveCache(event)
+		w.moveCaches[cookie] = w.newMoveCache(event, written)
 		return
 	}
 
@@ -303,11 +557,30 @@ func (w *FileWatcher) move(event notify.EventInfo) (moveUpdate *FileUpdate) {
 	switch event.Event() {
 	case notify.InMovedFrom:
 		sourcePath, destPath = destPath, sourcePath
-		fallthrough
 	case notify.InMovedTo:
-		cache.cancel()                                    // Cancel dispatching the cache's incomplete move
-		moveUpdate = &FileUpdate{FileEventMove, destPath} // Register an internal, complete move instead
-		log.Tracef("FileWatcher: Detected move: %q -> %q", sourcePath, destPath)
+		written = cache.written
+	default:
+		return
+	}
+
+	cache.cancel()                             // Cancel dispatching the cache's incomplete move
+	atomic.AddUint64(&w.counters.coalesced, 1) // The two events result in one update
+	if l := w.logger.V(logs.TraceLevel); l.Enabled() {
+		l.Info("Detected move", "oldPath", sourcePath, "path", destPath)
+	}
+
+	validSource, validDest := w.validFile(sourcePath), w.validFile(destPath)
+	switch {
+	case validSource && validDest && !written:
+		// Register an internal, complete move
+		updates = FileUpdates{{Event: FileEventMove, Path: destPath, OldPath: sourcePath}}
+	case validSource && validDest:
+		// The source was replaced by new content, which is now found at the destination
+		updates = FileUpdates{{Event: FileEventDelete, Path: sourcePath}, {Event: FileEventModify, Path: destPath}}
+	case validDest:
+		updates = FileUpdates{{Event: FileEventModify, Path: destPath}}
+	case validSource:
+		updates = FileUpdates{{Event: FileEventDelete, Path: sourcePath}}
 	}
 
 	return
@@ -325,22 +598,31 @@ func (w *FileWatcher) concatenateEvents(events notifyEvents) FileUpdates {
 			if event != nil { // Prepend the concatenation result event if any
 				concatenated = append(notifyEvents{event}, concatenated...)
 			}
+			atomic.AddUint64(&w.counters.coalesced, uint64(len(events)-len(concatenated)))
 
-			log.Tracef("FileWatcher: Concatenated events: %v -> %v", events, concatenated)
+			if l := w.logger.V(logs.TraceLevel); l.Enabled() {
+				l.Info("Concatenated events", "events", events, "concatenated", concatenated)
+			}
 			return w.concatenateEvents(concatenated)
 		}
 	}
 
 	// Convert the events to updates
 	updates := make(FileUpdates, 0, len(events))
-	for _, event := range events {
+	for i, event := range events {
+		// A file written right before being moved away is handled by the move
+		written := i > 0 && events[i-1].Event() == notify.InCloseWrite
+
 		switch event.Event() {
 		case notify.InMovedFrom, notify.InMovedTo:
-			// Send move-related events to w.move
-			if update := w.move(event); update != nil {
-				// Add the update to the list if we get something back
-				updates = append(updates, update)
+			// Send move-related events to w.move, and add the updates we get back
+			updates = append(updates, w.move(event, written && event.Event() == notify.InMovedFrom)...)
+		case notify.InCloseWrite:
+			if i+1 < len(events) && events[i+1].Event() == notify.InMovedFrom {
+				atomic.AddUint64(&w.counters.coalesced, 1)
+				continue // The written content is moved, see above
 			}
+			fallthrough
 		default:
 			updates = append(updates, convertUpdate(event))
 		}
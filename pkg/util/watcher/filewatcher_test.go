@@ -1,27 +1,41 @@
 package watcher
 
 import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
 	"testing"
 
 	"github.com/rjeczalik/notify"
+	"github.com/weaveworks/libgitops/pkg/logs"
+	"github.com/weaveworks/libgitops/pkg/util/clock"
 	"golang.org/x/sys/unix"
 )
 
 type testEventWrapper struct {
-	event notify.Event
+	event  notify.Event
+	path   string
+	cookie uint32
 }
 
 func (t *testEventWrapper) Event() notify.Event {
 	return t.event
 }
 
-func (t *testEventWrapper) Path() string     { return "" }
-func (t *testEventWrapper) Sys() interface{} { return &unix.InotifyEvent{} }
+func (t *testEventWrapper) Path() string     { return t.path }
+func (t *testEventWrapper) Sys() interface{} { return &unix.InotifyEvent{Cookie: t.cookie} }
 
 var _ notify.EventInfo = &testEventWrapper{}
 
 func testEvent(event notify.Event) notify.EventInfo {
-	return &testEventWrapper{event}
+	return &testEventWrapper{event, "test.yaml", 0}
+}
+
+func testMoveEvent(event notify.Event, path string, cookie uint32) notify.EventInfo {
+	return &testEventWrapper{event, path, cookie}
 }
 
 var testEvents = []notifyEvents{
@@ -93,9 +107,208 @@ func eventsEqual(a, b FileEvents) bool {
 
 func TestEventConcatenation(t *testing.T) {
 	for i, e := range testEvents {
-		result := extractEvents((&FileWatcher{}).concatenateEvents(e))
+		result := extractEvents((&FileWatcher{opts: DefaultOptions(), logger: logs.NewLogger(nil), clock: clock.RealClock}).concatenateEvents(e))
 		if !eventsEqual(result, targets[i]) {
 			t.Errorf("wrong concatenation result: %v != %v", result, targets[i])
 		}
 	}
 }
+
+func TestAtomicSaveConcatenation(t *testing.T) {
+	tests := []struct {
+		name string
+		// batches holds the events of every path, in the order they are dispatched
+		batches  []notifyEvents
+		expected FileUpdates
+	}{
+		{
+			name: "sed -i",
+			batches: []notifyEvents{
+				{testMoveEvent(notify.InMovedFrom, "sedX1Y2Z3", 1)},
+				{testMoveEvent(notify.InMovedTo, "car.yaml", 1)},
+			},
+			expected: FileUpdates{{Event: FileEventModify, Path: "car.yaml"}},
+		},
+		{
+			name: "sed -i, reverse order",
+			batches: []notifyEvents{
+				{testMoveEvent(notify.InMovedTo, "car.yaml", 1)},
+				{testMoveEvent(notify.InMovedFrom, "sedX1Y2Z3", 1)},
+			},
+			expected: FileUpdates{{Event: FileEventModify, Path: "car.yaml"}},
+		},
+		{
+			name: "vim",
+			batches: []notifyEvents{
+				{testMoveEvent(notify.InMovedFrom, "car.yaml", 2), testMoveEvent(notify.InCloseWrite, "car.yaml", 0)},
+				{testMoveEvent(notify.InMovedTo, "car.yaml~", 2)},
+			},
+			expected: FileUpdates{{Event: FileEventModify, Path: "car.yaml"}},
+		},
+		{
+			name: "vim, reverse order",
+			batches: []notifyEvents{
+				{testMoveEvent(notify.InMovedTo, "car.yaml~", 2)},
+				{testMoveEvent(notify.InMovedFrom, "car.yaml", 2), testMoveEvent(notify.InCloseWrite, "car.yaml", 0)},
+			},
+			expected: FileUpdates{{Event: FileEventModify, Path: "car.yaml"}},
+		},
+		{
+			name: "write to valid temporary file",
+			batches: []notifyEvents{
+				{testMoveEvent(notify.InCloseWrite, "car.new.yaml", 0), testMoveEvent(notify.InMovedFrom, "car.new.yaml", 3)},
+				{testMoveEvent(notify.InMovedTo, "car.yaml", 3)},
+			},
+			expected: FileUpdates{{Event: FileEventDelete, Path: "car.new.yaml"}, {Event: FileEventModify, Path: "car.yaml"}},
+		},
+		{
+			name: "rename",
+			batches: []notifyEvents{
+				{testMoveEvent(notify.InMovedFrom, "car.yaml", 4)},
+				{testMoveEvent(notify.InMovedTo, "truck.yaml", 4)},
+			},
+			expected: FileUpdates{{Event: FileEventMove, Path: "truck.yaml", OldPath: "car.yaml"}},
+		},
+		{
+			name: "backup",
+			batches: []notifyEvents{
+				{testMoveEvent(notify.InMovedFrom, "car.yaml", 5)},
+				{testMoveEvent(notify.InMovedTo, "car.yaml.bak", 5)},
+			},
+			expected: FileUpdates{{Event: FileEventDelete, Path: "car.yaml"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &FileWatcher{
+				opts:    DefaultOptions(),
+				updates: make(FileUpdateStream, 10),
+				logger:  logs.NewLogger(nil),
+				clock:   clock.RealClock,
+			}
+
+			var updates FileUpdates
+			for _, batch := range tt.batches {
+				updates = append(updates, w.concatenateEvents(batch)...)
+			}
+
+			// Incomplete moves are dispatched to the update stream
+			w.flushMoves()
+			close(w.updates)
+			for update := range w.updates {
+				updates = append(updates, update)
+			}
+
+			if !reflect.DeepEqual(updates, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, updates)
+			}
+		})
+	}
+}
+
+func TestMultiDirFileWatcher(t *testing.T) {
+	root, err := ioutil.TempDir("", "multidir-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	// Both directories contain a file with the same relative path
+	var dirs, expected []string
+	for _, name := range []string{"app", "infra"} {
+		dir := filepath.Join(root, name)
+		file := filepath.Join(dir, "sub", "car.yaml")
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte("{}"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		dirs = append(dirs, dir)
+		expected = append(expected, file)
+	}
+
+	w, files, err := NewMultiDirFileWatcher(dirs, DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	sort.Strings(files)
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("expected files %v, got %v", expected, files)
+	}
+	if !reflect.DeepEqual(w.Dirs(), dirs) {
+		t.Errorf("expected watched dirs %v, got %v", dirs, w.Dirs())
+	}
+
+	if _, _, err := NewMultiDirFileWatcher([]string{root, dirs[0]}, DefaultOptions()); !errors.Is(err, ErrOverlappingDirs) {
+		t.Errorf("expected ErrOverlappingDirs for overlapping dirs, got %v", err)
+	}
+}
+
+type overflowEvent struct{}
+
+func (overflowEvent) Event() notify.Event { return notify.InDelete }
+func (overflowEvent) Path() string        { return "" }
+func (overflowEvent) Sys() interface{}    { return &unix.InotifyEvent{Mask: unix.IN_Q_OVERFLOW} }
+
+func TestOverflowResync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "overflow-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w, _, err := NewFileWatcherWithOptions(dir, DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Feed an overflow event, the batched resync is flushed on close
+	w.events <- overflowEvent{}
+	w.Close()
+
+	var events FileEvents
+	for update := range w.GetFileUpdateStream() {
+		events = append(events, update.Event)
+	}
+	if !eventsEqual(events, FileEvents{FileEventResync}) {
+		t.Errorf("expected a single resync, got %v", events)
+	}
+	if overflows := w.Metrics().Overflows; overflows != 1 {
+		t.Errorf("expected 1 overflow, got %d", overflows)
+	}
+}
+
+func TestEscapingPathIgnored(t *testing.T) {
+	dir, err := ioutil.TempDir("", "escape-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w, _, err := NewFileWatcherWithOptions(dir, DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Feed events for paths escaping the watched directory, which are dropped, and a valid one
+	w.events <- testMoveEvent(notify.InCloseWrite, dir+"/../escaped.yaml", 0)
+	w.events <- testMoveEvent(notify.InCloseWrite, "relative.yaml", 0)
+	w.events <- testMoveEvent(notify.InCloseWrite, filepath.Join(dir, "foo.yaml"), 0)
+	w.Close()
+
+	var paths []string
+	for update := range w.GetFileUpdateStream() {
+		paths = append(paths, update.Path)
+	}
+	if expected := []string{filepath.Join(dir, "foo.yaml")}; !reflect.DeepEqual(paths, expected) {
+		t.Errorf("expected only the update for %v, got %v", expected, paths)
+	}
+	if received := w.Metrics().EventsReceived; received != 1 {
+		t.Errorf("expected 1 received event, got %d", received)
+	}
+}
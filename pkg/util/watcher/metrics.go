@@ -0,0 +1,60 @@
+package watcher
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Metrics is a snapshot of the counters of a FileWatcher, used for
+// detecting if the watcher falls behind the changes on disk
+type Metrics struct {
+	// EventsReceived is the number of inotify events received for valid files
+	EventsReceived uint64
+	// EventsCoalesced is the number of received events that were concatenated
+	// with other events of the same file, instead of being sent as updates
+	EventsCoalesced uint64
+	// EventsSuspended is the number of updates of received events skipped using Suspend
+	EventsSuspended uint64
+	// Overflows is the number of times the event queue overflowed, after which
+	// events have been lost, and a FileEventResync was sent
+	Overflows uint64
+	// UpdatesSent is the number of FileUpdates sent to the update stream
+	UpdatesSent uint64
+	// EventQueueDepth is the number of inotify events waiting to be registered
+	EventQueueDepth int
+	// UpdateQueueDepth is the number of FileUpdates waiting to be consumed
+	UpdateQueueDepth int
+	// LastEvent is the time the last inotify event for a valid file was received,
+	// zero if no event has been received
+	LastEvent time.Time
+}
+
+// counters holds the counters of a FileWatcher. It must be the first field of
+// FileWatcher to guarantee the 64-bit alignment required by the atomic operations.
+type counters struct {
+	received  uint64
+	coalesced uint64
+	suspended uint64
+	overflows uint64
+	sent      uint64
+	lastEvent int64 // UnixNano
+}
+
+// Metrics returns a snapshot of the counters of the FileWatcher
+func (w *FileWatcher) Metrics() Metrics {
+	m := Metrics{
+		EventsReceived:   atomic.LoadUint64(&w.counters.received),
+		EventsCoalesced:  atomic.LoadUint64(&w.counters.coalesced),
+		EventsSuspended:  atomic.LoadUint64(&w.counters.suspended),
+		Overflows:        atomic.LoadUint64(&w.counters.overflows),
+		UpdatesSent:      atomic.LoadUint64(&w.counters.sent),
+		EventQueueDepth:  len(w.events),
+		UpdateQueueDepth: len(w.updates),
+	}
+
+	if lastEvent := atomic.LoadInt64(&w.counters.lastEvent); lastEvent != 0 {
+		m.LastEvent = time.Unix(0, lastEvent)
+	}
+
+	return m
+}